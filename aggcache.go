@@ -0,0 +1,201 @@
+package squid
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// aggregateCache holds the results of aggregations over closed time ranges,
+// that is ranges ending before the current time. Results stay cached until
+// they are evicted or a write touches their range: appends and updates
+// invalidate the ranges containing the written timestamps, and deletions
+// the ranges they may have deleted from.
+type aggregateCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[aggregateCacheKey]*list.Element
+	lru     *list.List // front is most recently used
+
+	// horizon is the latest end of any range cached or being computed.
+	// Writes after it cannot affect a cached result.
+	horizon time.Time
+
+	// gen is incremented by every invalidation. A result computed while
+	// gen changed may be stale and is not stored.
+	gen uint64
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// aggregateCacheKey identifies an aggregation over a time range.
+type aggregateCacheKey struct {
+	query      string // filters, field and aggregation types
+	start, end int64
+}
+
+// aggregateCacheEntry is a cached result, stored in the LRU list.
+type aggregateCacheEntry struct {
+	key        aggregateCacheKey
+	start, end time.Time
+	result     AggregateResult
+}
+
+// newAggregateCache returns nil if caching is disabled.
+func newAggregateCache(opts Options) *aggregateCache {
+	if opts.AggregateCacheSize <= 0 {
+		return nil
+	}
+	return &aggregateCache{
+		maxEntries: opts.AggregateCacheSize,
+		entries:    make(map[aggregateCacheKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// cacheKey builds the cache key of an aggregation over [start, end].
+func (c *aggregateCache) cacheKey(q Query, field string, aggs []AggregationType, start, end time.Time) aggregateCacheKey {
+	// Map keys are encoded in sorted order, so equal queries encode equally
+	query, _ := json.Marshal(struct {
+		Types         []string
+		TypePattern   string
+		Tags          map[string]string
+		ExcludeTypes  []string
+		ExcludeTags   map[string]string
+		Where         []Predicate
+		HasFields     []string
+		MissingFields []string
+		Search        string
+		SearchTags    bool
+		MinID         *ulid.ULID
+		MaxID         *ulid.ULID
+		IngestedStart *time.Time
+		IngestedEnd   *time.Time
+		Latest        bool
+		Field         string
+		Aggs          []AggregationType
+	}{q.Types, q.TypePattern, q.Tags, q.ExcludeTypes, q.ExcludeTags, q.Where, q.HasFields, q.MissingFields, q.Search, q.SearchTags, q.MinID, q.MaxID, q.IngestedStart, q.IngestedEnd, q.Latest, field, aggs})
+
+	return aggregateCacheKey{
+		query: string(query),
+		start: start.UnixNano(),
+		end:   end.UnixNano(),
+	}
+}
+
+// get returns a copy of a cached result.
+func (c *aggregateCache) get(key aggregateCacheKey) (*AggregateResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits.Add(1)
+
+	result := elem.Value.(*aggregateCacheEntry).result
+	return &result, true
+}
+
+// begin announces the computation of a result ending at end. It returns the
+// generation to pass to put.
+func (c *aggregateCache) begin(end time.Time) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if end.After(c.horizon) {
+		c.horizon = end
+	}
+	return c.gen
+}
+
+// put stores a result computed since begin returned gen, unless a write
+// may have changed it in the meantime.
+func (c *aggregateCache) put(key aggregateCacheKey, gen uint64, start, end time.Time, result *AggregateResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		return
+	}
+
+	entry := &aggregateCacheEntry{key: key, start: start, end: end, result: *result}
+	c.entries[key] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*aggregateCacheEntry).key)
+	}
+}
+
+// invalidate removes the results of ranges overlapping [from, to].
+func (c *aggregateCache) invalidate(from, to time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Live writes land after every cached range
+	if from.After(c.horizon) {
+		return
+	}
+	c.gen++
+
+	for key, elem := range c.entries {
+		entry := elem.Value.(*aggregateCacheEntry)
+		if entry.start.After(to) || entry.end.Before(from) {
+			continue
+		}
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// invalidateAll removes every cached result.
+func (c *aggregateCache) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// invalidateEvents removes the results of ranges containing any of events.
+func (db *DB) invalidateEvents(events []Event) {
+	if db.aggCache == nil || len(events) == 0 {
+		return
+	}
+
+	from, to := events[0].Timestamp, events[0].Timestamp
+	widen := func(t time.Time) {
+		if t.Before(from) {
+			from = t
+		}
+		if t.After(to) {
+			to = t
+		}
+	}
+	for _, e := range events {
+		widen(e.Timestamp)
+		// A correction changes what Latest aggregations see of the time of
+		// the event it supersedes
+		if e.Supersedes != nil {
+			widen(ulidTime(*e.Supersedes))
+		}
+	}
+	// Event IDs, which queries match on, truncate timestamps to milliseconds
+	db.aggCache.invalidate(from.Add(-time.Millisecond), to)
+}
@@ -0,0 +1,332 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// openBucketTestDB opens a database whose clock reads 03:30 on 2024-01-01
+// and appends events with values 1 to 4 at 00:10, 01:20, 01:40 and 03:10.
+func openBucketTestDB(t *testing.T, cacheSize int) (*DB, time.Time) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := day.Add(3*time.Hour + 30*time.Minute)
+
+	db, err := OpenWithOptions(dir, Options{
+		Now:                func() time.Time { return now },
+		AggregateCacheSize: cacheSize,
+	})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for i, offset := range []time.Duration{10 * time.Minute, 80 * time.Minute, 100 * time.Minute, 190 * time.Minute} {
+		_, err := db.Append(Event{
+			Timestamp: day.Add(offset),
+			Type:      "request",
+			Data:      map[string]any{"value": float64(i + 1)},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	return db, day
+}
+
+func bucketCounts(t *testing.T, db *DB, start time.Time) []int64 {
+	t.Helper()
+
+	buckets, err := db.AggregateBuckets(context.Background(), Query{Start: &start}, "", []AggregationType{Count}, time.Hour)
+	if err != nil {
+		t.Fatalf("AggregateBuckets failed: %v", err)
+	}
+
+	counts := make([]int64, len(buckets))
+	for i, b := range buckets {
+		counts[i] = b.Count
+	}
+	return counts
+}
+
+func equalCounts(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAggregateBuckets(t *testing.T) {
+	db, day := openBucketTestDB(t, 0)
+
+	start := day.Add(30 * time.Minute)
+	buckets, err := db.AggregateBuckets(context.Background(), Query{Start: &start}, "value", []AggregationType{Count, Sum}, time.Hour)
+	if err != nil {
+		t.Fatalf("AggregateBuckets failed: %v", err)
+	}
+
+	// The first bucket starts at 00:00 but only covers events from 00:30
+	want := []struct {
+		count int64
+		sum   float64
+	}{{0, 0}, {2, 5}, {0, 0}, {1, 4}}
+	if len(buckets) != len(want) {
+		t.Fatalf("expected %d buckets, got %d", len(want), len(buckets))
+	}
+	for i, b := range buckets {
+		if !b.Start.Equal(day.Add(time.Duration(i)*time.Hour)) || b.End.Sub(b.Start) != time.Hour {
+			t.Errorf("bucket %d: unexpected range %v - %v", i, b.Start, b.End)
+		}
+		if b.Count != want[i].count || b.Sum != want[i].sum {
+			t.Errorf("bucket %d: expected count %d sum %v, got %d %v", i, want[i].count, want[i].sum, b.Count, b.Sum)
+		}
+	}
+
+	if _, err := db.AggregateBuckets(context.Background(), Query{}, "", []AggregationType{Count}, time.Hour); err == nil {
+		t.Error("expected error without a start time")
+	}
+	if _, err := db.AggregateBuckets(context.Background(), Query{Start: &start}, "", []AggregationType{Count}, time.Nanosecond); err == nil {
+		t.Error("expected error for too many buckets")
+	}
+}
+
+func TestAggregateBucketsCumulative(t *testing.T) {
+	db, day := openBucketTestDB(t, 0)
+
+	buckets, err := db.AggregateBucketsWithOptions(context.Background(), Query{Start: &day}, "value",
+		[]AggregationType{Count, Sum, ExactSum}, time.Hour, BucketOptions{Cumulative: true})
+	if err != nil {
+		t.Fatalf("AggregateBucketsWithOptions failed: %v", err)
+	}
+
+	want := []struct {
+		count int64
+		sum   float64
+		exact string
+	}{{1, 1, "1"}, {3, 6, "6"}, {3, 6, "6"}, {4, 10, "10"}}
+	if len(buckets) != len(want) {
+		t.Fatalf("expected %d buckets, got %d", len(want), len(buckets))
+	}
+	for i, b := range buckets {
+		if b.Count != want[i].count || b.Sum != want[i].sum || b.ExactSum != want[i].exact {
+			t.Errorf("bucket %d: expected running totals %d, %v, %s, got %d, %v, %s", i, want[i].count, want[i].sum, want[i].exact, b.Count, b.Sum, b.ExactSum)
+		}
+	}
+	if buckets[3].Avg != 2.5 {
+		t.Errorf("expected a running average of 2.5, got %v", buckets[3].Avg)
+	}
+
+	_, err = db.AggregateBucketsWithOptions(context.Background(), Query{Start: &day}, "", []AggregationType{Count},
+		time.Hour, BucketOptions{Cumulative: true, Fill: FillNull})
+	if err == nil {
+		t.Error("expected error for filled cumulative buckets")
+	}
+}
+
+func TestCompareBuckets(t *testing.T) {
+	db, day := openBucketTestDB(t, 0)
+
+	// 02:00 to now (03:30) against 00:00 to 01:30
+	start := day.Add(2 * time.Hour)
+	cmp, err := db.CompareBuckets(context.Background(), Query{Start: &start}, "value", []AggregationType{Count, Sum}, time.Hour, 2*time.Hour, BucketOptions{})
+	if err != nil {
+		t.Fatalf("CompareBuckets failed: %v", err)
+	}
+	if len(cmp.Current) != 2 || len(cmp.Previous) != 2 {
+		t.Fatalf("expected 2 buckets in each series, got %d and %d", len(cmp.Current), len(cmp.Previous))
+	}
+	for i, want := range []float64{0, 4} {
+		if cmp.Current[i].Sum != want {
+			t.Errorf("current bucket %d: expected sum %v, got %v", i, want, cmp.Current[i].Sum)
+		}
+	}
+	for i, want := range []float64{1, 2} {
+		if cmp.Previous[i].Sum != want {
+			t.Errorf("previous bucket %d: expected sum %v, got %v", i, want, cmp.Previous[i].Sum)
+		}
+		if !cmp.Previous[i].Start.Equal(cmp.Current[i].Start.Add(-cmp.Offset)) {
+			t.Errorf("previous bucket %d: expected it to start %s before the current one", i, cmp.Offset)
+		}
+	}
+
+	if _, err := db.CompareBuckets(context.Background(), Query{Start: &start}, "", []AggregationType{Count}, time.Hour, 90*time.Minute, BucketOptions{}); err == nil {
+		t.Error("expected error for an offset that is not a multiple of the interval")
+	}
+}
+
+func TestAggregateSliding(t *testing.T) {
+	db, day := openBucketTestDB(t, 0)
+
+	windows, err := db.AggregateSliding(context.Background(), Query{Start: &day}, "value", []AggregationType{Count, Sum}, 2*time.Hour, time.Hour)
+	if err != nil {
+		t.Fatalf("AggregateSliding failed: %v", err)
+	}
+
+	// Two-hour windows ending every hour from 01:00, the last one at 04:00
+	want := []struct {
+		count int64
+		sum   float64
+	}{{1, 1}, {3, 6}, {2, 5}, {1, 4}}
+	if len(windows) != len(want) {
+		t.Fatalf("expected %d windows, got %d", len(want), len(windows))
+	}
+	for i, w := range windows {
+		if !w.End.Equal(day.Add(time.Duration(i+1)*time.Hour)) || w.End.Sub(w.Start) != 2*time.Hour {
+			t.Errorf("window %d: unexpected range %v - %v", i, w.Start, w.End)
+		}
+		if w.Count != want[i].count || w.Sum != want[i].sum {
+			t.Errorf("window %d: expected count %d sum %v, got %d %v", i, want[i].count, want[i].sum, w.Count, w.Sum)
+		}
+	}
+
+	if _, err := db.AggregateSliding(context.Background(), Query{Start: &day}, "", []AggregationType{Count}, time.Minute, time.Hour); err == nil {
+		t.Error("expected error for a window shorter than the step")
+	}
+}
+
+func TestAggregateBucketsEpochAlignment(t *testing.T) {
+	db, day := openBucketTestDB(t, 0)
+	ctx := context.Background()
+
+	// Unix weeks start on Thursdays, 2023-12-28 being the one before the
+	// first event; 7-minute buckets start 6 minutes before midnight
+	week, err := db.AggregateBuckets(ctx, Query{Start: &day}, "", []AggregationType{Count}, 7*24*time.Hour)
+	if err != nil {
+		t.Fatalf("AggregateBuckets failed: %v", err)
+	}
+	if want := time.Date(2023, 12, 28, 0, 0, 0, 0, time.UTC); len(week) != 1 || !week[0].Start.Equal(want) || week[0].Count != 4 {
+		t.Errorf("expected one week from %v with 4 events, got %+v", want, week)
+	}
+
+	buckets, err := db.AggregateBuckets(ctx, Query{Start: &day}, "", []AggregationType{Count}, 7*time.Minute)
+	if err != nil {
+		t.Fatalf("AggregateBuckets failed: %v", err)
+	}
+	if want := day.Add(-6 * time.Minute); !buckets[0].Start.Equal(want) {
+		t.Errorf("expected the first bucket to start at %v, got %v", want, buckets[0].Start)
+	}
+	for _, b := range buckets {
+		if b.Start.Unix()%(7*60) != 0 {
+			t.Fatalf("bucket %v is not aligned to the epoch", b.Start)
+		}
+	}
+
+	windows, err := db.AggregateSliding(ctx, Query{Start: &day}, "", []AggregationType{Count}, 14*time.Minute, 7*time.Minute)
+	if err != nil {
+		t.Fatalf("AggregateSliding failed: %v", err)
+	}
+	if want := day.Add(time.Minute); !windows[0].End.Equal(want) {
+		t.Errorf("expected the first window to end at %v, got %v", want, windows[0].End)
+	}
+}
+
+func TestAggregateCache(t *testing.T) {
+	db, day := openBucketTestDB(t, 100)
+
+	if got := bucketCounts(t, db, day); !equalCounts(got, []int64{1, 2, 0, 1}) {
+		t.Fatalf("unexpected counts %v", got)
+	}
+	if s := db.Stats(); s.AggregateCacheHits != 0 || s.AggregateCacheMisses != 3 {
+		t.Errorf("expected 0 hits and 3 misses, got %d and %d", s.AggregateCacheHits, s.AggregateCacheMisses)
+	}
+
+	// Closed buckets are served from the cache, the current one is recomputed
+	if _, err := db.Append(Event{Timestamp: day.Add(200 * time.Minute), Type: "request"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := bucketCounts(t, db, day); !equalCounts(got, []int64{1, 2, 0, 2}) {
+		t.Fatalf("unexpected counts %v", got)
+	}
+	if s := db.Stats(); s.AggregateCacheHits != 3 {
+		t.Errorf("expected 3 hits, got %d", s.AggregateCacheHits)
+	}
+
+	// Backfilled events invalidate their bucket
+	_, _ = db.Append(Event{Timestamp: day.Add(150 * time.Minute), Type: "request"})
+	if got := bucketCounts(t, db, day); !equalCounts(got, []int64{1, 2, 1, 2}) {
+		t.Fatalf("unexpected counts after backfill %v", got)
+	}
+	if s := db.Stats(); s.AggregateCacheHits != 5 || s.AggregateCacheMisses != 4 {
+		t.Errorf("expected 5 hits and 4 misses, got %d and %d", s.AggregateCacheHits, s.AggregateCacheMisses)
+	}
+
+	// So do deletions
+	if _, err := db.DeleteBefore(day.Add(90 * time.Minute)); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	if got := bucketCounts(t, db, day); !equalCounts(got, []int64{0, 1, 1, 2}) {
+		t.Fatalf("unexpected counts after delete %v", got)
+	}
+}
+
+func TestAggregateCacheEviction(t *testing.T) {
+	db, day := openBucketTestDB(t, 1)
+
+	bucketCounts(t, db, day)
+	bucketCounts(t, db, day)
+
+	// Only the most recently used bucket fits
+	if s := db.Stats(); s.AggregateCacheHits != 0 {
+		t.Errorf("expected no hits, got %d", s.AggregateCacheHits)
+	}
+	if n := db.aggCache.lru.Len(); n != 1 {
+		t.Errorf("expected 1 cached result, got %d", n)
+	}
+}
+
+func TestAggregateCacheConcurrentWrites(t *testing.T) {
+	db, day := openBucketTestDB(t, 100)
+
+	start, end := day, day.Add(time.Hour-time.Nanosecond)
+	q := Query{Start: &start, End: &end}
+
+	var appended atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_, err := db.Append(Event{Timestamp: day.Add(time.Duration(i) * time.Second), Type: "request"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			appended.Add(1)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+		default:
+			if _, err := db.Aggregate(context.Background(), q, "", []AggregationType{Count}); err != nil {
+				t.Fatalf("Aggregate failed: %v", err)
+			}
+			continue
+		}
+		break
+	}
+
+	result, err := db.Aggregate(context.Background(), q, "", []AggregationType{Count})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if want := 1 + appended.Load(); result.Count != want {
+		t.Errorf("expected count %d, got %d", want, result.Count)
+	}
+}
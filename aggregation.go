@@ -3,8 +3,14 @@ package squid
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"math"
+	"math/big"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
 	"github.com/oklog/ulid/v2"
@@ -30,55 +36,165 @@ const (
 	P95
 	// P99 calculates the 99th percentile.
 	P99
+	// ExactSum adds up all values of a field exactly, as decimals, for
+	// counters such as amounts of money. Values are taken as the shortest
+	// decimal that reads back as the stored float, e.g. 0.1 rather than
+	// 0.1000000000000000055511151231257827.
+	ExactSum
 )
 
+// aggregationNames maps each AggregationType to its string form.
+var aggregationNames = [...]string{
+	Count: "count",
+	Sum:   "sum",
+	Avg:   "avg",
+	Min:   "min",
+	Max:   "max",
+	P50:   "p50",
+	P95:   "p95",
+	P99:   "p99",
+
+	ExactSum: "exact_sum",
+}
+
+// String returns the lower-case name of the aggregation, e.g. "p99".
+func (a AggregationType) String() string {
+	if a >= 0 && int(a) < len(aggregationNames) {
+		return aggregationNames[a]
+	}
+	return "AggregationType(" + strconv.Itoa(int(a)) + ")"
+}
+
+// ParseAggregationType returns the AggregationType named s.
+// Names are case-insensitive.
+func ParseAggregationType(s string) (AggregationType, error) {
+	for i, name := range aggregationNames {
+		if strings.EqualFold(s, name) {
+			return AggregationType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("squid: unknown aggregation type %q", s)
+}
+
+// MarshalText encodes the aggregation as its name.
+func (a AggregationType) MarshalText() ([]byte, error) {
+	if a < 0 || int(a) >= len(aggregationNames) {
+		return nil, fmt.Errorf("squid: unknown aggregation type %d", int(a))
+	}
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes an aggregation name.
+func (a *AggregationType) UnmarshalText(text []byte) error {
+	parsed, err := ParseAggregationType(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// UnmarshalJSON decodes an aggregation from its name, or from its numeric
+// value as sent by older clients.
+func (a *AggregationType) UnmarshalJSON(data []byte) error {
+	if n, err := strconv.Atoi(string(data)); err == nil {
+		if n < 0 || n >= len(aggregationNames) {
+			return fmt.Errorf("squid: unknown aggregation type %d", n)
+		}
+		*a = AggregationType(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return a.UnmarshalText([]byte(s))
+}
+
 // maxPercentileValues is the maximum number of values to collect for percentile calculations.
 // This prevents memory exhaustion on large datasets.
 const maxPercentileValues = 1_000_000
 
 // AggregateResult holds the results of an aggregation operation.
 type AggregateResult struct {
-	Count int64
-	Sum   float64
-	Avg   float64
-	Min   float64
-	Max   float64
-	P50   float64
-	P95   float64
-	P99   float64
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Avg   float64 `json:"avg"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	P50   float64 `json:"p50"`
+	P95   float64 `json:"p95"`
+	P99   float64 `json:"p99"`
+
+	// ExactSum is the exact decimal sum of the values, e.g. "1234.56",
+	// set only when the ExactSum aggregation is requested.
+	ExactSum string `json:"exact_sum,omitempty"`
+
+	// Skipped is the number of matching records that could not be read
+	// because they are corrupt (see ScanReport).
+	Skipped int64 `json:"skipped"`
+
+	// Partial is set if the query has Query.Partial set and the context's
+	// deadline expired, so that the result covers only the events read
+	// before it. Partial results are not cached.
+	Partial bool `json:"partial,omitempty"`
 }
 
 // aggregator accumulates values during aggregation.
 type aggregator struct {
 	field            string
+	unit             Unit // parses string values of field, if set
 	needsPercentiles bool
 	count            int64
 	sum              float64
+	comp             float64 // compensation for the rounding error of sum
 	min              float64
 	max              float64
 	values           []float64
+
+	// exact is the exact sum of the values, if ExactSum is requested, and
+	// scale the most decimal places of any value.
+	exact   *big.Rat
+	scale   int
+	scratch big.Rat
 }
 
-func newAggregator(field string, needsPercentiles bool) *aggregator {
-	return &aggregator{
+func newAggregator(field string, aggs []AggregationType) *aggregator {
+	a := &aggregator{
 		field:            field,
-		needsPercentiles: needsPercentiles,
+		needsPercentiles: needsPercentiles(aggs),
 		min:              math.MaxFloat64,
 		max:              -math.MaxFloat64,
 	}
+	if slices.Contains(aggs, ExactSum) {
+		a.exact = new(big.Rat)
+	}
+	return a
 }
 
 // add processes an event and updates the aggregation state.
 // Returns an error if too many values are collected for percentile calculation.
 func (a *aggregator) add(event *Event) error {
 	val, ok := extractNumericValue(event, a.field)
+	if s, isString := event.Data[a.field].(string); isString && a.unit != 0 {
+		v, err := a.unit.Parse(s)
+		val, ok = v, err == nil
+	}
 	if !ok && a.field != "" {
 		return nil // Skip events without the field
 	}
 
 	a.count++
 	if a.field != "" {
-		a.sum += val
+		a.addSum(val)
+		if a.exact != nil {
+			raw := event.Data[a.field]
+			if _, isString := raw.(string); isString {
+				raw = val // parsed with the unit
+			}
+			a.addExact(raw)
+		}
 		if val < a.min {
 			a.min = val
 		}
@@ -95,6 +211,96 @@ func (a *aggregator) add(event *Event) error {
 	return nil
 }
 
+// merge adds the values accumulated by another aggregator.
+func (a *aggregator) merge(o *aggregator) error {
+	if len(a.values)+len(o.values) > maxPercentileValues {
+		return ErrTooManyValues
+	}
+
+	a.count += o.count
+	a.addSum(o.sum)
+	a.comp += o.comp
+	if a.exact != nil && o.exact != nil {
+		a.exact.Add(a.exact, o.exact)
+		a.scale = max(a.scale, o.scale)
+	}
+	a.min = min(a.min, o.min)
+	a.max = max(a.max, o.max)
+	a.values = append(a.values, o.values...)
+	return nil
+}
+
+// addSum adds val to the sum using Neumaier's variant of Kahan summation:
+// the low-order bits lost by each addition are accumulated in comp, so that
+// summing many small values does not drift.
+func (a *aggregator) addSum(val float64) {
+	t := a.sum + val
+	if math.Abs(a.sum) >= math.Abs(val) {
+		a.comp += (a.sum - t) + val
+	} else {
+		a.comp += (val - t) + a.sum
+	}
+	a.sum = t
+}
+
+// total returns the compensated sum.
+func (a *aggregator) total() float64 {
+	if math.IsInf(a.sum, 0) || math.IsNaN(a.sum) {
+		return a.sum // comp is meaningless once the sum overflows
+	}
+	return a.sum + a.comp
+}
+
+// addExact adds a numeric data value to the exact sum.
+func (a *aggregator) addExact(val any) {
+	r := &a.scratch
+	switch v := val.(type) {
+	case float64:
+		if !a.setDecimal(strconv.FormatFloat(v, 'f', -1, 64)) {
+			return
+		}
+	case float32:
+		if !a.setDecimal(strconv.FormatFloat(float64(v), 'f', -1, 32)) {
+			return
+		}
+	case uint:
+		r.SetUint64(uint64(v))
+	case uint64:
+		r.SetUint64(v)
+	case uint32:
+		r.SetUint64(uint64(v))
+	case uint16:
+		r.SetUint64(uint64(v))
+	case uint8:
+		r.SetUint64(uint64(v))
+	case int:
+		r.SetInt64(int64(v))
+	case int64:
+		r.SetInt64(v)
+	case int32:
+		r.SetInt64(int64(v))
+	case int16:
+		r.SetInt64(int64(v))
+	case int8:
+		r.SetInt64(int64(v))
+	default:
+		return
+	}
+	a.exact.Add(a.exact, r)
+}
+
+// setDecimal sets the scratch value to the decimal s, recording its scale.
+// It reports false for NaN and infinities.
+func (a *aggregator) setDecimal(s string) bool {
+	if _, ok := a.scratch.SetString(s); !ok {
+		return false
+	}
+	if _, frac, ok := strings.Cut(s, "."); ok {
+		a.scale = max(a.scale, len(frac))
+	}
+	return true
+}
+
 // result builds the final AggregateResult.
 func (a *aggregator) result() *AggregateResult {
 	result := &AggregateResult{
@@ -102,8 +308,8 @@ func (a *aggregator) result() *AggregateResult {
 	}
 
 	if a.count > 0 && a.field != "" {
-		result.Sum = a.sum
-		result.Avg = a.sum / float64(a.count)
+		result.Sum = a.total()
+		result.Avg = result.Sum / float64(a.count)
 		result.Min = a.min
 		result.Max = a.max
 
@@ -113,6 +319,9 @@ func (a *aggregator) result() *AggregateResult {
 			result.P95 = percentile(a.values, 0.95)
 			result.P99 = percentile(a.values, 0.99)
 		}
+		if a.exact != nil {
+			result.ExactSum = a.exact.FloatString(a.scale)
+		}
 	}
 
 	return result
@@ -121,7 +330,16 @@ func (a *aggregator) result() *AggregateResult {
 // Aggregate computes aggregations over events matching the query.
 // The field parameter specifies which field in Event.Data to aggregate.
 // For Count aggregation, field can be empty.
+//
+// With Options.AggregateCacheSize set, results for queries with a Start and
+// an End in the past are cached.
 func (db *DB) Aggregate(ctx context.Context, q Query, field string, aggs []AggregationType) (*AggregateResult, error) {
+	cacheable := q.Start != nil && q.End != nil && q.End.Before(db.now())
+	return db.aggregate(ctx, q, field, aggs, cacheable)
+}
+
+// aggregate implements Aggregate. Results are only cached if cacheable is set.
+func (db *DB) aggregate(ctx context.Context, q Query, field string, aggs []AggregationType, cacheable bool) (*AggregateResult, error) {
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
@@ -132,71 +350,128 @@ func (db *DB) Aggregate(ctx context.Context, q Query, field string, aggs []Aggre
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-
-	// Check if we need percentiles
-	needsPercentiles := false
-	for _, agg := range aggs {
-		if agg == P50 || agg == P95 || agg == P99 {
-			needsPercentiles = true
-			break
+	defer recordDuration(ctx, time.Now())
+
+	var (
+		cacheKey aggregateCacheKey
+		cacheGen uint64
+	)
+	cacheable = cacheable && db.aggCache != nil
+	if cacheable {
+		cacheKey = db.aggCache.cacheKey(q, field, aggs, *q.Start, *q.End)
+		if result, ok := db.aggCache.get(cacheKey); ok {
+			recordPlan(ctx, "aggregate cache")
+			return result, nil
 		}
+		cacheGen = db.aggCache.begin(*q.End)
 	}
 
-	agg := newAggregator(field, needsPercentiles)
+	agg := newAggregator(field, aggs)
 
-	err := db.badger.View(func(txn *badger.Txn) error {
-		candidateIDs, useIndex := db.planQuery(ctx, txn, q)
-
-		if useIndex {
-			return db.aggregateByIDs(ctx, txn, candidateIDs, q, agg)
-		}
-		return db.aggregateFullScan(ctx, txn, q, agg)
-	})
+	report := scanReportFrom(ctx)
+	if report == nil {
+		report = &ScanReport{}
+		ctx = WithScanReport(ctx, report)
+	}
+	skipped := report.Skipped()
 
-	if err != nil {
+	err := db.aggregateInto(ctx, q, agg)
+	partial := isPartial(ctx, q, err)
+	if err != nil && !partial {
 		return nil, err
 	}
 
-	return agg.result(), nil
+	result := agg.result()
+	result.Skipped = report.Skipped() - skipped
+	result.Partial = partial
+
+	if cacheable && result.Skipped == 0 && !partial {
+		db.aggCache.put(cacheKey, cacheGen, *q.Start, *q.End, result)
+	}
+
+	return result, nil
 }
 
-// aggregateByIDs aggregates events by fetching them from candidate IDs.
-func (db *DB) aggregateByIDs(ctx context.Context, txn *badger.Txn, ids []ulid.ULID, q Query, agg *aggregator) error {
-	for _, id := range ids {
-		if ctx.Err() != nil {
-			return ctx.Err()
+// needsPercentiles reports whether aggs include a percentile.
+func needsPercentiles(aggs []AggregationType) bool {
+	for _, agg := range aggs {
+		if agg == P50 || agg == P95 || agg == P99 {
+			return true
 		}
+	}
+	return false
+}
 
-		item, err := txn.Get(encodeEventKey(id))
-		if err != nil {
-			continue
+// aggregateInto adds the events matching the query to agg.
+func (db *DB) aggregateInto(ctx context.Context, q Query, agg *aggregator) error {
+	// Counts don't need the data of events
+	q.OmitData = agg.field == ""
+	q.Offset = 0
+	agg.unit = db.opts.Units[agg.field]
+
+	return db.badger.View(func(txn *badger.Txn) error {
+		candidateIDs, useIndex, scanErr := db.planQuery(ctx, txn, q)
+		readCtx, ok := partialRead(ctx, q, useIndex, scanErr)
+		if !ok {
+			return scanErr
 		}
 
-		var event Event
-		err = item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &event)
-		})
-		if err != nil {
-			continue
+		if useIndex {
+			if err := db.aggregateByIDs(readCtx, txn, candidateIDs, q, agg); err != nil {
+				return err
+			}
+			return scanErr
 		}
+		return db.aggregateFullScan(ctx, txn, q, agg)
+	})
+}
 
-		if !db.matchesFilters(&event, q) {
-			continue
-		}
+// aggregateByIDs aggregates events by fetching them from candidate IDs,
+// as fetchEventsByIDs does.
+func (db *DB) aggregateByIDs(ctx context.Context, txn *badger.Txn, ids []ulid.ULID, q Query, agg *aggregator) error {
+	var event Event // reused for every event, as the aggregator keeps no references
+	filter := needsFilter(q, true)
+	ids = db.pruneCandidates(ids, q)
+	for len(ids) > 0 {
+		chunk := ids[:min(fetchChunk, len(ids))]
+		ids = ids[len(chunk):]
+
+		for i, f := range prefetch(txn, chunk) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 
-		if err := agg.add(&event); err != nil {
-			return err
+			recordScanned(ctx, 1)
+			if f.err == badger.ErrKeyNotFound {
+				db.recordDangling(ctx)
+				continue
+			}
+			if f.err != nil {
+				return &QueryError{Stage: StageFetch, Key: encodeEventKey(chunk[i]), Err: f.err}
+			}
+
+			event.reset()
+			ok, err := db.readMatching(ctx, txn, f.item, q, filter, &event)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+
+			if err := agg.add(&event); err != nil {
+				return err
+			}
 		}
 	}
 	return ctx.Err()
 }
 
 // aggregateFullScan aggregates events by scanning all events.
+// The context is checked every scanCheckInterval keys.
 func (db *DB) aggregateFullScan(ctx context.Context, txn *badger.Txn, q Query, agg *aggregator) error {
-	opts := badger.DefaultIteratorOptions
-	opts.Reverse = q.Descending
-
-	it := txn.NewIterator(opts)
+	filter := needsFilter(q, false)
+	it := txn.NewIterator(scanIteratorOptions(q, filter))
 	defer it.Close()
 
 	prefix := eventKeyPrefix()
@@ -205,38 +480,39 @@ func (db *DB) aggregateFullScan(ctx context.Context, txn *badger.Txn, q Query, a
 		seekKey = prefixEnd(prefix)
 	}
 
+	var event Event // reused, as in aggregateByIDs
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
 	for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
-		if ctx.Err() != nil {
-			return ctx.Err()
+		if scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 		}
+		scanned++
 
 		item := it.Item()
 		key := item.Key()
 
 		id, err := decodeEventKey(key)
 		if err != nil {
+			db.recordCorrupt(ctx)
 			continue
 		}
 
 		if !db.matchesTimeRange(id, q) {
-			if !q.Descending && q.End != nil && ulidTime(id).After(*q.End) {
-				break
-			}
-			if q.Descending && q.Start != nil && ulidTime(id).Before(*q.Start) {
+			if pastRange(id, q) {
 				break
 			}
 			continue
 		}
 
-		var event Event
-		err = item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &event)
-		})
+		event.reset()
+		ok, err := db.readMatching(ctx, txn, item, q, filter, &event)
 		if err != nil {
-			continue
+			return err
 		}
-
-		if !db.matchesFilters(&event, q) {
+		if !ok {
 			continue
 		}
 
@@ -258,7 +534,11 @@ func extractNumericValue(event *Event, field string) (float64, bool) {
 	if !ok {
 		return 0, false
 	}
+	return numericValue(val)
+}
 
+// numericValue converts a data value of any numeric type to a float64.
+func numericValue(val any) (float64, bool) {
 	switch v := val.(type) {
 	case float64:
 		return v, true
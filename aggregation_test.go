@@ -2,6 +2,7 @@ package squid
 
 import (
 	"context"
+	"encoding/json"
 	"math"
 	"os"
 	"testing"
@@ -77,6 +78,67 @@ func TestAggregateSum(t *testing.T) {
 	}
 }
 
+func TestAggregateCompensatedSum(t *testing.T) {
+	// Adding 1 to 1e16 is lost to rounding without compensation
+	agg := newAggregator("value", []AggregationType{Sum})
+	agg.add(&Event{Data: map[string]any{"value": 1e16}})
+	for i := 0; i < 1000; i++ {
+		agg.add(&Event{Data: map[string]any{"value": 1.0}})
+	}
+	if got := agg.result().Sum; got != 1e16+1000 {
+		t.Errorf("expected sum %v, got %v", 1e16+1000, got)
+	}
+
+	// Merging keeps the compensation of both aggregators
+	other := newAggregator("value", []AggregationType{Sum})
+	for i := 0; i < 1000; i++ {
+		other.add(&Event{Data: map[string]any{"value": 1.0}})
+	}
+	agg.merge(other)
+	if got := agg.result().Sum; got != 1e16+2000 {
+		t.Errorf("expected merged sum %v, got %v", 1e16+2000, got)
+	}
+}
+
+func TestAggregateExactSum(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// 0.1 + 0.2 is 0.30000000000000004 in floating point
+	for _, v := range []any{0.1, 0.2, 19.99, int64(1000), float32(0.5)} {
+		if _, err := db.Append(Event{Type: "payment", Data: map[string]any{"amount": v}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	result, err := db.Aggregate(ctx, Query{}, "amount", []AggregationType{Sum, ExactSum})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.ExactSum != "1020.79" {
+		t.Errorf("expected exact sum 1020.79, got %q", result.ExactSum)
+	}
+
+	// The exact sum is only computed when requested
+	result, err = db.Aggregate(ctx, Query{}, "amount", []AggregationType{Sum})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.ExactSum != "" {
+		t.Errorf("expected no exact sum, got %q", result.ExactSum)
+	}
+}
+
 func TestAggregateAvg(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
@@ -424,3 +486,45 @@ func TestExtractNumericValue(t *testing.T) {
 		})
 	}
 }
+
+func TestAggregationTypeText(t *testing.T) {
+	for _, agg := range []AggregationType{Count, Sum, Avg, Min, Max, P50, P95, P99, ExactSum} {
+		parsed, err := ParseAggregationType(agg.String())
+		if err != nil {
+			t.Fatalf("ParseAggregationType(%q) failed: %v", agg, err)
+		}
+		if parsed != agg {
+			t.Errorf("roundtrip failed: got %v, want %v", parsed, agg)
+		}
+	}
+
+	if agg, err := ParseAggregationType("P99"); err != nil || agg != P99 {
+		t.Errorf("expected case-insensitive parse, got %v, %v", agg, err)
+	}
+	if _, err := ParseAggregationType("median"); err == nil {
+		t.Error("expected error for unknown aggregation")
+	}
+	if s := AggregationType(42).String(); s != "AggregationType(42)" {
+		t.Errorf("unexpected String for unknown value: %s", s)
+	}
+
+	data, err := json.Marshal([]AggregationType{Count, P95})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `["count","p95"]` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+
+	// Numeric values from older clients are still accepted
+	var aggs []AggregationType
+	if err := json.Unmarshal([]byte(`["avg", 7]`), &aggs); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if len(aggs) != 2 || aggs[0] != Avg || aggs[1] != P99 {
+		t.Errorf("unexpected aggregations: %v", aggs)
+	}
+	if err := json.Unmarshal([]byte(`[42]`), &aggs); err == nil {
+		t.Error("expected error for out of range aggregation")
+	}
+}
@@ -0,0 +1,126 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// AppendJSON adds an event given as JSON, in the form returned by Get, as
+// Append does. It suits producers and proxies that already hold serialized
+// events: the data object is stored as given, without being decoded and
+// re-encoded, which saves most of the CPU time of an append. Only the
+// envelope (id, timestamp, type and tags) is decoded and validated, and
+// data must be a JSON object. Other top-level fields are unknown and are
+// handled according to the event type's IngestMode.
+//
+// The returned event's Data is nil. Malformed input returns an error
+// wrapping ErrInvalidJSON.
+func (db *DB) AppendJSON(ctx context.Context, raw []byte) (*AppendResult, error) {
+	results, err := db.AppendJSONBatch(ctx, [][]byte{raw})
+	if err != nil {
+		return nil, err
+	}
+	return results[0], nil
+}
+
+// AppendJSONBatch adds events given as JSON atomically, as AppendBatch
+// does. Each element of raws is one event, as for AppendJSON.
+func (db *DB) AppendJSONBatch(ctx context.Context, raws [][]byte) ([]*AppendResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(raws) == 0 {
+		return nil, nil
+	}
+
+	events := make([]Event, len(raws))
+	parts := make([]rawParts, len(raws))
+	for i, raw := range raws {
+		var err error
+		events[i], parts[i], err = parseRawEvent(raw)
+		if err != nil {
+			if len(raws) > 1 {
+				return nil, fmt.Errorf("event %d: %w", i, err)
+			}
+			return nil, err
+		}
+	}
+
+	return db.appendBatch(events, parts, nil)
+}
+
+// parseRawEvent decodes the envelope of an event and returns it with its
+// compacted data object, nil if the event has none, and its unknown fields.
+func parseRawEvent(raw []byte) (Event, rawParts, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return Event{}, rawParts{}, fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+
+	var event Event
+	var parts rawParts
+	for name, value := range fields {
+		var err error
+		switch name {
+		case "id":
+			err = json.Unmarshal(value, &event.ID)
+		case "timestamp":
+			err = json.Unmarshal(value, &event.Timestamp)
+		case "type":
+			err = json.Unmarshal(value, &event.Type)
+		case "tags":
+			err = json.Unmarshal(value, &event.Tags)
+		case "version":
+			err = json.Unmarshal(value, &event.Version)
+		case "supersedes":
+			err = json.Unmarshal(value, &event.Supersedes)
+		case "data":
+			parts.data, err = compactData(value)
+		default:
+			if parts.unknown == nil {
+				parts.unknown = make(map[string]json.RawMessage)
+			}
+			parts.unknown[name] = value
+		}
+		if err != nil {
+			return Event{}, rawParts{}, fmt.Errorf("%w: %s: %v", ErrInvalidJSON, name, err)
+		}
+	}
+	return event, parts, nil
+}
+
+// compactData returns an encoded data object without insignificant space,
+// or nil if it is null or empty.
+func compactData(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil, nil
+	}
+	if len(data) == 0 || data[0] != '{' {
+		return nil, fmt.Errorf("data must be an object")
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	if buf.Len() == 2 { // {}
+		return nil, nil
+	}
+	return buf.Bytes(), nil
+}
+
+// withRawData returns copies of events with their data decoded from raw.
+func (db *DB) withRawData(events []Event, raw []rawParts) []Event {
+	decoded := make([]Event, len(events))
+	for i, event := range events {
+		if raw[i].data != nil {
+			// The data was checked to be an object when it was appended
+			_ = db.codec().Unmarshal(raw[i].data, &event.Data)
+		}
+		decoded[i] = event
+	}
+	return decoded
+}
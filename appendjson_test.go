@@ -0,0 +1,119 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAppendJSON(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	result, err := db.AppendJSON(ctx, []byte(`{"timestamp":"2024-01-01T10:00:00Z","type":"request",
+		"tags":{"service":"api"},"data":{"latency": 42.5, "path": "/a<b>"}}`))
+	if err != nil {
+		t.Fatalf("AppendJSON failed: %v", err)
+	}
+	if result.Data != nil || result.Version != 1 || result.IndexEntries != 2 {
+		t.Errorf("unexpected append result: %+v", result)
+	}
+
+	got, err := db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !got.Timestamp.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) || got.Tags["service"] != "api" ||
+		got.Data["latency"] != 42.5 || got.Data["path"] != "/a<b>" {
+		t.Errorf("unexpected stored event: %+v", got)
+	}
+
+	events, err := db.Query(ctx, Query{Tags: map[string]string{"service": "api"}})
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected the event to be indexed, got %v, %v", events, err)
+	}
+
+	// Events without data store none
+	result, err = db.AppendJSON(ctx, []byte(`{"type":"ping","data":{}}`))
+	if err != nil {
+		t.Fatalf("AppendJSON failed: %v", err)
+	}
+	if got, err := db.Get(result.ID); err != nil || got.Data != nil || !result.TimestampDefaulted {
+		t.Errorf("unexpected event without data: %+v, %v", got, err)
+	}
+
+	invalid := []struct {
+		raw  string
+		want error
+	}{
+		{`{"type":"request"`, ErrInvalidJSON},
+		{`{"type":"request","data":[1,2]}`, ErrInvalidJSON},
+		{`{"type":"request","tags":{"n":1}}`, ErrInvalidJSON},
+		{`{"data":{"a":1}}`, ErrEmptyType},
+	}
+	for _, tt := range invalid {
+		if _, err := db.AppendJSON(ctx, []byte(tt.raw)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.raw, tt.want, err)
+		}
+	}
+}
+
+func TestAppendJSONBatch(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sub, err := db.Subscribe(ctx, SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	results, err := db.AppendJSONBatch(ctx, [][]byte{
+		[]byte(`{"type":"request","data":{"status":200}}`),
+		[]byte(`{"type":"error"}`),
+	})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("AppendJSONBatch failed: %v, %v", results, err)
+	}
+
+	// Subscribers receive the decoded data
+	select {
+	case batch := <-sub.C:
+		if len(batch) != 2 || batch[0].Data["status"] != 200.0 || batch[1].Data != nil {
+			t.Errorf("unexpected published batch: %+v", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the subscription")
+	}
+
+	// A batch with an invalid event appends nothing
+	_, err = db.AppendJSONBatch(ctx, [][]byte{[]byte(`{"type":"ok"}`), []byte(`{"type":`)})
+	if !errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("expected ErrInvalidJSON, got %v", err)
+	}
+	if n, _ := db.Count(); n != 2 {
+		t.Errorf("expected 2 events, got %d", n)
+	}
+}
@@ -0,0 +1,460 @@
+package squid
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// arrowBatchSize is the number of rows per Arrow record batch.
+const arrowBatchSize = 64 * 1024
+
+// arrowType is the Arrow type of a column.
+type arrowType int
+
+const (
+	arrowUtf8 arrowType = iota
+	arrowFloat64
+	arrowBool
+	arrowTimestamp
+)
+
+// arrowColumn is a column of the Arrow output. value returns the column's
+// value for an event, or nil for null: a string, float64, bool or, for
+// timestamps, nanoseconds since the epoch as an int64.
+type arrowColumn struct {
+	name  string
+	typ   arrowType
+	value func(e *Event) any
+}
+
+// WriteArrow writes the events matching the query to w as an Arrow IPC
+// stream, like Export with the Arrow format but with a choice of data
+// fields. pandas (pyarrow.ipc.open_stream), Polars and DuckDB read the
+// stream without conversion. Columns are as in the CSV export: id,
+// timestamp (UTC nanoseconds), type, a tag_<key> column per tag key and a
+// data_<key> column per data field. fields selects the data fields to
+// include; all are included if none are given.
+//
+// Data columns are typed as double if every value of the field is a number,
+// as boolean if every value is a boolean, and as strings otherwise, with
+// objects and arrays encoded as JSON. Missing tags and fields are null.
+//
+// The query is read twice, a page of up to 65536 events at a time: first
+// for the columns, then for the rows, written as a record batch per page.
+// Memory is bounded by the page size rather than the number of results,
+// except for queries ordered by ingest time, which are read at once. Tags
+// and fields first seen by the second read, e.g. of events appended in
+// between, are left out.
+func (db *DB) WriteArrow(ctx context.Context, w io.Writer, q Query, fields ...string) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	return db.writeArrow(ctx, nil, w, q, fields, nil)
+}
+
+// writeArrow writes the events matching q as an Arrow IPC stream with the
+// given data fields (all if none), as described for WriteArrow, reporting
+// progress to t. written, if set, is called with each event written.
+func (db *DB) writeArrow(ctx context.Context, t *progressTracker, w io.Writer, q Query, fields []string, written func(e *Event)) error {
+	layout := newArrowLayout(fields)
+	var n int64
+	err := db.queryPages(ctx, q, arrowBatchSize, func(events []*Event) error {
+		layout.add(events)
+		n += int64(len(events))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.total(n, 0)
+
+	w = progressWriter{w, t}
+	columns := layout.columns()
+	if err := writeArrowMessage(w, arrowSchema(columns), nil); err != nil {
+		return err
+	}
+
+	err = db.queryPages(ctx, q, arrowBatchSize, func(events []*Event) error {
+		header, body := arrowRecordBatch(columns, events)
+		if err := writeArrowMessage(w, header, body); err != nil {
+			return err
+		}
+		if written != nil {
+			for _, e := range events {
+				written(e)
+			}
+		}
+		t.add(int64(len(events)), 0)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(arrowEndOfStream)
+	return err
+}
+
+// arrowEndOfStream is the marker that ends an Arrow IPC stream.
+var arrowEndOfStream = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0}
+
+// writeArrowEvents writes events as an Arrow IPC stream with the given data
+// fields, checking the context between record batches.
+func writeArrowEvents(ctx context.Context, w io.Writer, events []*Event, fields []string) error {
+	layout := newArrowLayout(fields)
+	layout.add(events)
+	columns := layout.columns()
+
+	if err := writeArrowMessage(w, arrowSchema(columns), nil); err != nil {
+		return err
+	}
+
+	for start := 0; start < len(events); start += arrowBatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch := events[start:min(start+arrowBatchSize, len(events))]
+		header, body := arrowRecordBatch(columns, batch)
+		if err := writeArrowMessage(w, header, body); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write(arrowEndOfStream)
+	return err
+}
+
+// arrowLayout collects the columns of an Arrow stream from the events it
+// holds, a page at a time: their tag keys and the values of their data
+// fields, by kind.
+type arrowLayout struct {
+	tags   map[string]struct{}
+	fields map[string]*arrowFieldKinds
+
+	// all is set if every data field is included, not only those given
+	all bool
+}
+
+// arrowFieldKinds counts the non-null values of a data field, and those
+// that are numbers and booleans.
+type arrowFieldKinds struct {
+	values, numbers, bools int
+}
+
+// newArrowLayout returns a layout with the given data fields, or every data
+// field of the events if none are given.
+func newArrowLayout(fields []string) *arrowLayout {
+	l := &arrowLayout{
+		tags:   make(map[string]struct{}),
+		fields: make(map[string]*arrowFieldKinds),
+		all:    len(fields) == 0,
+	}
+	for _, k := range fields {
+		l.fields[k] = &arrowFieldKinds{}
+	}
+	return l
+}
+
+// add adds the tags and data fields of events to the layout.
+func (l *arrowLayout) add(events []*Event) {
+	for _, e := range events {
+		for k := range e.Tags {
+			l.tags[k] = struct{}{}
+		}
+		for k, v := range e.Data {
+			kinds := l.fields[k]
+			if kinds == nil {
+				if !l.all {
+					continue
+				}
+				kinds = &arrowFieldKinds{}
+				l.fields[k] = kinds
+			}
+			if v == nil {
+				continue
+			}
+			kinds.values++
+			if _, ok := numericValue(v); ok {
+				kinds.numbers++
+			} else if _, ok := v.(bool); ok {
+				kinds.bools++
+			}
+		}
+	}
+}
+
+// columns returns the columns of the layout: id, timestamp and type, the
+// tags and the data fields, each sorted by key. A data column is double if
+// all its values are numbers, boolean if all are booleans, and string
+// otherwise; values of another type, e.g. of events added after the type was
+// chosen, are null.
+func (l *arrowLayout) columns() []arrowColumn {
+	columns := []arrowColumn{
+		{"id", arrowUtf8, func(e *Event) any { return e.ID.String() }},
+		{"timestamp", arrowTimestamp, func(e *Event) any { return e.Timestamp.UnixNano() }},
+		{"type", arrowUtf8, func(e *Event) any { return e.Type }},
+	}
+
+	for _, k := range sortedKeys(l.tags) {
+		columns = append(columns, arrowColumn{"tag_" + k, arrowUtf8, func(e *Event) any {
+			if v, ok := e.Tags[k]; ok {
+				return v
+			}
+			return nil
+		}})
+	}
+
+	for _, k := range sortedKeys(l.fields) {
+		typ := l.fields[k].typ()
+		columns = append(columns, arrowColumn{"data_" + k, typ, func(e *Event) any {
+			v := e.Data[k]
+			if v == nil {
+				return nil
+			}
+			switch typ {
+			case arrowFloat64:
+				if f, ok := numericValue(v); ok {
+					return f
+				}
+				return nil
+			case arrowBool:
+				if b, ok := v.(bool); ok {
+					return b
+				}
+				return nil
+			default:
+				return formatDataValue(v)
+			}
+		}})
+	}
+
+	return columns
+}
+
+// typ returns the Arrow type of a data field with the values counted.
+func (k *arrowFieldKinds) typ() arrowType {
+	switch {
+	case k.values > 0 && k.numbers == k.values:
+		return arrowFloat64
+	case k.values > 0 && k.bools == k.values:
+		return arrowBool
+	default:
+		return arrowUtf8
+	}
+}
+
+// writeArrowMessage writes an encapsulated IPC message: a continuation
+// marker, the length of the flatbuffer header padded to 8 bytes, the header
+// and the body.
+func writeArrowMessage(w io.Writer, header, body []byte) error {
+	padded := (len(header) + 7) &^ 7
+	prefix := make([]byte, 8, 8+padded)
+	binary.LittleEndian.PutUint32(prefix[0:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(prefix[4:], uint32(padded))
+	prefix = append(prefix, header...)
+	prefix = append(prefix, make([]byte, padded-len(header))...)
+
+	if _, err := w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// Arrow flatbuffer constants, from the Arrow format's Schema.fbs and Message.fbs.
+const (
+	arrowMetadataV5       = 4
+	arrowHeaderSchema     = 1
+	arrowHeaderRecord     = 3
+	arrowTypeFloatingPt   = 3
+	arrowTypeUtf8         = 5
+	arrowTypeBool         = 6
+	arrowTypeTimestamp    = 10
+	arrowPrecisionDouble  = 2
+	arrowTimeUnitNanosecs = 3
+)
+
+// arrowMessage finishes a Message flatbuffer around a schema or record batch header.
+func arrowMessage(b *flatbuffers.Builder, headerType byte, header flatbuffers.UOffsetT, bodyLength int) []byte {
+	b.StartObject(5)
+	b.PrependInt64Slot(3, int64(bodyLength), 0)
+	b.PrependUOffsetTSlot(2, header, 0)
+	b.PrependInt16Slot(0, arrowMetadataV5, 0)
+	b.PrependByteSlot(1, headerType, 0)
+	b.Finish(b.EndObject())
+	return b.FinishedBytes()
+}
+
+// arrowSchema returns the Schema message for the columns.
+func arrowSchema(columns []arrowColumn) []byte {
+	b := flatbuffers.NewBuilder(1024)
+
+	fields := make([]flatbuffers.UOffsetT, len(columns))
+	for i, c := range columns {
+		name := b.CreateString(c.name)
+
+		var typeType byte
+		var typ flatbuffers.UOffsetT
+		switch c.typ {
+		case arrowFloat64:
+			typeType = arrowTypeFloatingPt
+			b.StartObject(1)
+			b.PrependInt16Slot(0, arrowPrecisionDouble, 0)
+			typ = b.EndObject()
+		case arrowBool:
+			typeType = arrowTypeBool
+			b.StartObject(0)
+			typ = b.EndObject()
+		case arrowTimestamp:
+			typeType = arrowTypeTimestamp
+			tz := b.CreateString("UTC")
+			b.StartObject(2)
+			b.PrependUOffsetTSlot(1, tz, 0)
+			b.PrependInt16Slot(0, arrowTimeUnitNanosecs, 0)
+			typ = b.EndObject()
+		default:
+			typeType = arrowTypeUtf8
+			b.StartObject(0)
+			typ = b.EndObject()
+		}
+
+		// Readers expect a children vector even for primitive types
+		b.StartVector(4, 0, 4)
+		children := b.EndVector(0)
+
+		b.StartObject(7)
+		b.PrependUOffsetTSlot(0, name, 0)
+		b.PrependUOffsetTSlot(3, typ, 0)
+		b.PrependUOffsetTSlot(5, children, 0)
+		b.PrependBoolSlot(1, true, false)
+		b.PrependByteSlot(2, typeType, 0)
+		fields[i] = b.EndObject()
+	}
+
+	b.StartVector(4, len(fields), 4)
+	for i := len(fields) - 1; i >= 0; i-- {
+		b.PrependUOffsetT(fields[i])
+	}
+	fieldVec := b.EndVector(len(fields))
+
+	b.StartObject(4)
+	b.PrependUOffsetTSlot(1, fieldVec, 0)
+	schema := b.EndObject()
+
+	return arrowMessage(b, arrowHeaderSchema, schema, 0)
+}
+
+// arrowBuffer is the location of a buffer in a record batch body.
+type arrowBuffer struct {
+	offset, length int64
+}
+
+// arrowBody accumulates the buffers of a record batch body, each padded to
+// 8 bytes.
+type arrowBody struct {
+	data    []byte
+	buffers []arrowBuffer
+}
+
+// add appends a buffer to the body.
+func (b *arrowBody) add(buf []byte) {
+	b.buffers = append(b.buffers, arrowBuffer{int64(len(b.data)), int64(len(buf))})
+	b.data = append(b.data, buf...)
+	if pad := len(b.data) % 8; pad != 0 {
+		b.data = append(b.data, make([]byte, 8-pad)...)
+	}
+}
+
+// arrowRecordBatch returns the RecordBatch message header and body for events.
+func arrowRecordBatch(columns []arrowColumn, events []*Event) ([]byte, []byte) {
+	type fieldNode struct{ length, nulls int64 }
+	nodes := make([]fieldNode, len(columns))
+	var body arrowBody
+
+	n := len(events)
+	for i, c := range columns {
+		validity := make([]byte, (n+7)/8)
+		var nulls int64
+		var values, offsets []byte
+
+		switch c.typ {
+		case arrowUtf8:
+			offsets = make([]byte, 4, 4*(n+1))
+		case arrowBool:
+			values = make([]byte, (n+7)/8)
+		default:
+			values = make([]byte, 0, 8*n)
+		}
+
+		for j, e := range events {
+			v := c.value(e)
+			if v == nil {
+				nulls++
+			} else {
+				validity[j/8] |= 1 << (j % 8)
+			}
+
+			switch c.typ {
+			case arrowUtf8:
+				s, _ := v.(string)
+				values = append(values, s...)
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(values)))
+			case arrowFloat64:
+				f, _ := v.(float64)
+				values = binary.LittleEndian.AppendUint64(values, math.Float64bits(f))
+			case arrowBool:
+				if v == true {
+					values[j/8] |= 1 << (j % 8)
+				}
+			case arrowTimestamp:
+				t, _ := v.(int64)
+				values = binary.LittleEndian.AppendUint64(values, uint64(t))
+			}
+		}
+
+		nodes[i] = fieldNode{int64(n), nulls}
+		if nulls == 0 {
+			validity = nil // An empty validity buffer means no nulls
+		}
+		body.add(validity)
+		if c.typ == arrowUtf8 {
+			body.add(offsets)
+		}
+		body.add(values)
+	}
+
+	b := flatbuffers.NewBuilder(1024)
+
+	// Structs are prepended field by field in reverse order
+	b.StartVector(16, len(body.buffers), 8)
+	for i := len(body.buffers) - 1; i >= 0; i-- {
+		b.Prep(8, 16)
+		b.PrependInt64(body.buffers[i].length)
+		b.PrependInt64(body.buffers[i].offset)
+	}
+	buffers := b.EndVector(len(body.buffers))
+
+	b.StartVector(16, len(nodes), 8)
+	for i := len(nodes) - 1; i >= 0; i-- {
+		b.Prep(8, 16)
+		b.PrependInt64(nodes[i].nulls)
+		b.PrependInt64(nodes[i].length)
+	}
+	nodeVec := b.EndVector(len(nodes))
+
+	b.StartObject(4)
+	b.PrependInt64Slot(0, int64(n), 0)
+	b.PrependUOffsetTSlot(1, nodeVec, 0)
+	b.PrependUOffsetTSlot(2, buffers, 0)
+	batch := b.EndObject()
+
+	return arrowMessage(b, arrowHeaderRecord, batch, len(body.data)), body.data
+}
@@ -0,0 +1,297 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// arrowTestColumn is a column read back from an Arrow IPC stream.
+type arrowTestColumn struct {
+	name     string
+	typeType byte
+	values   []any
+}
+
+// readArrowStream decodes the subset of the Arrow IPC stream format written
+// by WriteArrow.
+func readArrowStream(t *testing.T, data []byte) []*arrowTestColumn {
+	t.Helper()
+
+	// table returns the table pointed to by the offset field at slot
+	table := func(tab *flatbuffers.Table, slot int) *flatbuffers.Table {
+		o := flatbuffers.UOffsetT(tab.Offset(flatbuffers.VOffsetT(4 + 2*slot)))
+		if o == 0 {
+			t.Fatalf("missing field %d", slot)
+		}
+		return &flatbuffers.Table{Bytes: tab.Bytes, Pos: tab.Indirect(tab.Pos + o)}
+	}
+	vector := func(tab *flatbuffers.Table, slot int) (flatbuffers.UOffsetT, int) {
+		o := flatbuffers.UOffsetT(tab.Offset(flatbuffers.VOffsetT(4 + 2*slot)))
+		if o == 0 {
+			t.Fatalf("missing vector %d", slot)
+		}
+		return tab.Vector(o), tab.VectorLen(o)
+	}
+
+	var columns []*arrowTestColumn
+	for {
+		if len(data) < 8 || binary.LittleEndian.Uint32(data) != 0xFFFFFFFF {
+			t.Fatalf("missing continuation marker")
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		if size == 0 {
+			if len(data) != 8 {
+				t.Fatalf("%d bytes after end of stream", len(data)-8)
+			}
+			return columns
+		}
+		if size%8 != 0 {
+			t.Fatalf("metadata size %d is not padded to 8 bytes", size)
+		}
+		meta := data[8 : 8+size]
+		data = data[8+size:]
+
+		msg := &flatbuffers.Table{Bytes: meta, Pos: flatbuffers.GetUOffsetT(meta)}
+		if v := msg.GetInt16Slot(4, 0); v != arrowMetadataV5 {
+			t.Fatalf("unexpected metadata version %d", v)
+		}
+		bodyLen := int(msg.GetInt64Slot(10, 0))
+		body := data[:bodyLen]
+		data = data[bodyLen:]
+
+		header := table(msg, 2)
+		switch msg.GetByteSlot(6, 0) {
+		case arrowHeaderSchema:
+			start, n := vector(header, 1)
+			for i := 0; i < n; i++ {
+				field := &flatbuffers.Table{Bytes: meta, Pos: header.Indirect(start + flatbuffers.UOffsetT(4*i))}
+				name := field.String(field.Pos + flatbuffers.UOffsetT(field.Offset(4)))
+				columns = append(columns, &arrowTestColumn{name: name, typeType: field.GetByteSlot(8, 0)})
+			}
+
+		case arrowHeaderRecord:
+			rows := int(header.GetInt64Slot(4, 0))
+			bufStart, _ := vector(header, 2)
+			next := func() []byte {
+				off := binary.LittleEndian.Uint64(meta[bufStart:])
+				length := binary.LittleEndian.Uint64(meta[bufStart+8:])
+				bufStart += 16
+				return body[off : off+length]
+			}
+			valid := func(validity []byte, i int) bool {
+				return len(validity) == 0 || validity[i/8]&(1<<(i%8)) != 0
+			}
+
+			for _, c := range columns {
+				validity := next()
+				var offsets []byte
+				if c.typeType == arrowTypeUtf8 {
+					offsets = next()
+				}
+				values := next()
+
+				for i := 0; i < rows; i++ {
+					if !valid(validity, i) {
+						c.values = append(c.values, nil)
+						continue
+					}
+					switch c.typeType {
+					case arrowTypeUtf8:
+						start := binary.LittleEndian.Uint32(offsets[4*i:])
+						end := binary.LittleEndian.Uint32(offsets[4*i+4:])
+						c.values = append(c.values, string(values[start:end]))
+					case arrowTypeFloatingPt:
+						c.values = append(c.values, math.Float64frombits(binary.LittleEndian.Uint64(values[8*i:])))
+					case arrowTypeBool:
+						c.values = append(c.values, values[i/8]&(1<<(i%8)) != 0)
+					case arrowTypeTimestamp:
+						c.values = append(c.values, int64(binary.LittleEndian.Uint64(values[8*i:])))
+					}
+				}
+			}
+
+		default:
+			t.Fatalf("unexpected message type %d", msg.GetByteSlot(6, 0))
+		}
+	}
+}
+
+func TestWriteArrow(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := []Event{
+		{Timestamp: base, Type: "request", Tags: map[string]string{"service": "api"}, Data: map[string]any{"latency": 12.5, "ok": true, "path": "/a"}},
+		{Timestamp: base.Add(time.Second), Type: "request", Data: map[string]any{"latency": 7, "ok": false, "path": []any{"x"}}},
+		{Timestamp: base.Add(2 * time.Second), Type: "error", Tags: map[string]string{"service": "web"}},
+	}
+	for _, e := range events {
+		if _, err := db.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := db.WriteArrow(context.Background(), &buf, Query{}); err != nil {
+		t.Fatalf("WriteArrow failed: %v", err)
+	}
+	columns := readArrowStream(t, buf.Bytes())
+
+	want := []struct {
+		name     string
+		typeType byte
+		values   []any
+	}{
+		{"timestamp", arrowTypeTimestamp, []any{base.UnixNano(), base.Add(time.Second).UnixNano(), base.Add(2 * time.Second).UnixNano()}},
+		{"type", arrowTypeUtf8, []any{"request", "request", "error"}},
+		{"tag_service", arrowTypeUtf8, []any{"api", nil, "web"}},
+		{"data_latency", arrowTypeFloatingPt, []any{12.5, 7.0, nil}},
+		{"data_ok", arrowTypeBool, []any{true, false, nil}},
+		{"data_path", arrowTypeUtf8, []any{"/a", `["x"]`, nil}},
+	}
+	if len(columns) != len(want)+1 || columns[0].name != "id" || len(columns[0].values) != 3 {
+		t.Fatalf("unexpected columns: %+v", columns)
+	}
+	for i, w := range want {
+		c := columns[i+1]
+		if c.name != w.name || c.typeType != w.typeType {
+			t.Errorf("column %d: expected %s of type %d, got %s of type %d", i+1, w.name, w.typeType, c.name, c.typeType)
+			continue
+		}
+		for j := range w.values {
+			if c.values[j] != w.values[j] {
+				t.Errorf("%s[%d]: expected %v, got %v", c.name, j, w.values[j], c.values[j])
+			}
+		}
+	}
+
+	// Selected fields only
+	buf.Reset()
+	if err := db.WriteArrow(context.Background(), &buf, Query{Types: []string{"request"}}, "latency"); err != nil {
+		t.Fatalf("WriteArrow failed: %v", err)
+	}
+	columns = readArrowStream(t, buf.Bytes())
+	if last := columns[len(columns)-1]; last.name != "data_latency" || len(last.values) != 2 {
+		t.Errorf("expected only the latency field, got %+v", columns)
+	}
+}
+
+func TestWriteArrowBatches(t *testing.T) {
+	events := make([]*Event, arrowBatchSize+10)
+	for i := range events {
+		events[i] = &Event{Type: "request", Data: map[string]any{"i": float64(i)}}
+	}
+
+	var buf bytes.Buffer
+	if err := writeArrowEvents(context.Background(), &buf, events, []string{"i"}); err != nil {
+		t.Fatalf("writeArrowEvents failed: %v", err)
+	}
+
+	columns := readArrowStream(t, buf.Bytes())
+	values := columns[len(columns)-1].values
+	if len(values) != len(events) || values[arrowBatchSize] != float64(arrowBatchSize) {
+		t.Errorf("expected %d rows over two batches, got %d", len(events), len(values))
+	}
+}
+
+func TestWriteArrowPages(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// The second page has a field and a tag the first does not, and a field
+	// that is a number in the first
+	n := arrowBatchSize + 10
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var batch []Event
+	for i := 0; i < n; i++ {
+		e := Event{Timestamp: base.Add(time.Duration(i) * time.Millisecond), Type: "request", Data: map[string]any{"i": float64(i), "mixed": 1.0}}
+		if i >= arrowBatchSize {
+			e.Tags = map[string]string{"late": "yes"}
+			e.Data["late"] = true
+			e.Data["mixed"] = "x"
+		}
+		if batch = append(batch, e); len(batch) == 5000 || i == n-1 {
+			if _, err := db.AppendBatch(batch); err != nil {
+				t.Fatalf("AppendBatch failed: %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	column := func(columns []*arrowTestColumn, name string) *arrowTestColumn {
+		t.Helper()
+		for _, c := range columns {
+			if c.name == name {
+				return c
+			}
+		}
+		t.Fatalf("missing column %s", name)
+		return nil
+	}
+
+	ctx := context.Background()
+	var buf bytes.Buffer
+	if err := db.Export(ctx, &buf, Query{}, Arrow); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	columns := readArrowStream(t, buf.Bytes())
+	values := column(columns, "data_i").values
+	if len(values) != n || values[arrowBatchSize] != float64(arrowBatchSize) || values[n-1] != float64(n-1) {
+		t.Fatalf("expected %d rows over two batches, got %d", n, len(values))
+	}
+	if c := column(columns, "data_mixed"); c.typeType != arrowTypeUtf8 || c.values[0] != "1" || c.values[n-1] != "x" {
+		t.Errorf("expected a string column of mixed values, got type %d", c.typeType)
+	}
+	if c := column(columns, "data_late"); c.typeType != arrowTypeBool || c.values[0] != nil || c.values[n-1] != true {
+		t.Errorf("expected a boolean column set on the second page, got type %d", c.typeType)
+	}
+	if c := column(columns, "tag_late"); c.values[0] != nil || c.values[n-1] != "yes" {
+		t.Errorf("expected the tag of the second page")
+	}
+
+	// Offsets, limits and order hold across pages
+	buf.Reset()
+	q := Query{Descending: true, Offset: 5, Limit: arrowBatchSize + 2}
+	if err := db.WriteArrow(ctx, &buf, q, "i"); err != nil {
+		t.Fatalf("WriteArrow failed: %v", err)
+	}
+	values = column(readArrowStream(t, buf.Bytes()), "data_i").values
+	if len(values) != q.Limit || values[0] != float64(n-6) || values[q.Limit-1] != float64(n-6-q.Limit+1) {
+		t.Errorf("expected %d rows from %d down, got %d", q.Limit, n-6, len(values))
+	}
+
+	buf.Reset()
+	file, err := db.ExportWithManifest(ctx, &buf, Query{}, Arrow)
+	if err != nil {
+		t.Fatalf("ExportWithManifest failed: %v", err)
+	}
+	if file.Events != int64(n) || file.Bytes != int64(buf.Len()) {
+		t.Errorf("expected a manifest of %d events and %d bytes, got %+v", n, buf.Len(), file)
+	}
+}
@@ -0,0 +1,215 @@
+package squid
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults and tuning of AdaptiveBatching.
+const (
+	defaultMaxAppendBatch = 1000
+
+	// batchLatencyWindow is the number of recent Append latencies the p99
+	// is computed from, and batchAdjustEvery the number of appends between
+	// changes to the batch size.
+	batchLatencyWindow = 1024
+	batchAdjustEvery   = 128
+)
+
+// AdaptiveBatching commits concurrent Append calls together, in one
+// transaction per batch, sizing batches to keep the p99 Append latency
+// under a target. While a batch is written the Appends that arrive wait,
+// and are committed together when it is done; a lone Append is committed
+// at once, so batching adds no delay when appends are rare.
+//
+// Larger batches amortize the cost of a commit over more events, which
+// raises throughput and shortens the wait of queued appends, until the
+// batches take so long to write that the latency suffers. The batch size
+// grows while batches fill up and the p99 is under target, and halves when
+// it is over. Stats reports the current size and p99.
+//
+// Append returns when its batch is committed, with the same result and
+// errors as without batching: if a batch fails, its events are committed
+// one at a time so that only the failing event reports the error.
+// AppendBatch and transactions are not batched.
+type AdaptiveBatching struct {
+	// TargetP99 is the 99th percentile of Append latency to keep under.
+	TargetP99 time.Duration
+
+	// MaxBatch is the largest number of events committed together.
+	// Defaults to 1000.
+	MaxBatch int
+}
+
+// pendingAppend is an event prepared by Append that is waiting to be
+// committed.
+type pendingAppend struct {
+	event   *Event
+	meta    []byte
+	data    []byte
+	result  *AppendResult
+	derived []Event
+
+	start time.Time // when Append was called
+	done  chan error
+}
+
+// appendBatcher commits pending appends in batches.
+type appendBatcher struct {
+	target   time.Duration
+	maxBatch int
+
+	// queue is unbuffered, so an append is only taken by a running loop,
+	// which always answers it
+	queue chan *pendingAppend
+
+	size    atomic.Int64
+	p99     atomic.Int64 // nanoseconds
+	batches atomic.Int64
+	appends atomic.Int64
+
+	// latencies is only used by the loop goroutine
+	latencies []time.Duration
+	next      int
+	sampled   int
+	full      bool // the last batch reached the size limit
+
+	cancel context.CancelFunc
+	ctx    context.Context
+	done   chan struct{}
+}
+
+// newAppendBatcher returns a batcher for the given options, or nil if
+// appends are not batched.
+func newAppendBatcher(opts Options) *appendBatcher {
+	c := opts.AdaptiveBatching
+	if c == nil || c.TargetP99 <= 0 {
+		return nil
+	}
+	b := &appendBatcher{
+		target:    c.TargetP99,
+		maxBatch:  c.MaxBatch,
+		queue:     make(chan *pendingAppend),
+		latencies: make([]time.Duration, 0, batchLatencyWindow),
+	}
+	if b.maxBatch <= 0 {
+		b.maxBatch = defaultMaxAppendBatch
+	}
+	b.size.Store(1)
+	return b
+}
+
+// start commits appends in a background goroutine.
+func (b *appendBatcher) start(db *DB) {
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+
+		for {
+			var first *pendingAppend
+			select {
+			case <-b.ctx.Done():
+				return
+			case first = <-b.queue:
+			}
+
+			batch := []*pendingAppend{first}
+			size := int(b.size.Load())
+		gather:
+			for len(batch) < size {
+				select {
+				case w := <-b.queue:
+					batch = append(batch, w)
+				default:
+					break gather
+				}
+			}
+			b.full = len(batch) == size
+
+			b.commit(db, batch)
+		}
+	}()
+}
+
+// stop ends batching and waits for the goroutine to exit. Appends waiting
+// to be batched fail with ErrClosed.
+func (b *appendBatcher) stop() {
+	if b.cancel != nil {
+		b.cancel()
+		<-b.done
+	}
+}
+
+// append commits w with the next batch.
+func (b *appendBatcher) append(w *pendingAppend) error {
+	w.done = make(chan error, 1)
+	select {
+	case b.queue <- w:
+	case <-b.ctx.Done():
+		return ErrClosed
+	}
+	return <-w.done
+}
+
+// commit writes a batch, falling back to writing its events one at a time
+// if it fails, and adapts the batch size to the latencies observed.
+func (b *appendBatcher) commit(db *DB, batch []*pendingAppend) {
+	err := db.writeAppends(batch)
+	if err != nil && len(batch) > 1 {
+		for _, w := range batch {
+			w.done <- db.writeAppends([]*pendingAppend{w})
+		}
+	} else {
+		for _, w := range batch {
+			w.done <- err
+		}
+	}
+
+	b.batches.Add(1)
+	b.appends.Add(int64(len(batch)))
+
+	now := time.Now()
+	for _, w := range batch {
+		b.observe(now.Sub(w.start))
+	}
+}
+
+// observe records the latency of an append, and every batchAdjustEvery
+// appends changes the batch size by the p99 of the recent latencies:
+// halving it when over target, and growing it by a quarter when under
+// target and batches fill up.
+func (b *appendBatcher) observe(latency time.Duration) {
+	if len(b.latencies) < batchLatencyWindow {
+		b.latencies = append(b.latencies, latency)
+	} else {
+		b.latencies[b.next] = latency
+		b.next = (b.next + 1) % batchLatencyWindow
+	}
+
+	if b.sampled++; b.sampled < batchAdjustEvery {
+		return
+	}
+	b.sampled = 0
+
+	sorted := slices.Clone(b.latencies)
+	slices.Sort(sorted)
+	p99 := sorted[(len(sorted)-1)*99/100]
+	b.p99.Store(int64(p99))
+
+	size := b.size.Load()
+	switch {
+	case p99 > b.target:
+		size = max(size/2, 1)
+	case b.full:
+		size = min(size+size/4+1, int64(b.maxBatch))
+	}
+	if size != b.size.Load() {
+		// Latencies of the old size don't tell how the new one does
+		b.latencies, b.next = b.latencies[:0], 0
+		b.size.Store(size)
+	}
+}
@@ -0,0 +1,108 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestAdaptiveBatching(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Events carry their IDs, so that one can collide with a stored event
+	db, err := OpenWithOptions(dir, Options{
+		AdaptiveBatching: &AdaptiveBatching{TargetP99: time.Second, MaxBatch: 16},
+		IDSource:         IDSourceFunc(func(e *Event) (ulid.ULID, error) { return e.ID, nil }),
+	})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	source := newULIDSource()
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	dup := source.New(base)
+	if _, err := db.Append(Event{ID: dup, Timestamp: base, Type: "request"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	const n = 500
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		ts := base.Add(time.Duration(i+1) * time.Millisecond)
+		id := source.New(ts)
+		if i == n/2 {
+			ts, id = base, dup
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = db.Append(Event{ID: id, Timestamp: ts, Type: "request"})
+		}()
+	}
+	wg.Wait()
+
+	// Only the duplicate fails, even if batched with others
+	for i, err := range errs {
+		if i == n/2 {
+			if !errors.Is(err, ErrDuplicateID) {
+				t.Errorf("expected ErrDuplicateID, got %v", err)
+			}
+		} else if err != nil {
+			t.Errorf("Append %d failed: %v", i, err)
+		}
+	}
+
+	events, err := db.Query(context.Background(), Query{Types: []string{"request"}})
+	if err != nil || len(events) != n {
+		t.Fatalf("expected %d events, got %d (%v)", n, len(events), err)
+	}
+
+	stats := db.Stats()
+	if stats.AppendsBatched != n+1 || stats.AppendBatches == 0 || stats.AppendBatches > n+1 || stats.AppendBatchSize < 1 {
+		t.Errorf("unexpected stats: %d appends in %d batches of up to %d", stats.AppendsBatched, stats.AppendBatches, stats.AppendBatchSize)
+	}
+}
+
+func TestAdaptiveBatchSize(t *testing.T) {
+	b := newAppendBatcher(Options{AdaptiveBatching: &AdaptiveBatching{TargetP99: 10 * time.Millisecond, MaxBatch: 8}})
+
+	// Full batches under target grow up to MaxBatch
+	b.full = true
+	for i := 0; i < 10*batchAdjustEvery; i++ {
+		b.observe(time.Millisecond)
+	}
+	if size := b.size.Load(); size != 8 {
+		t.Errorf("expected batch size 8, got %d", size)
+	}
+
+	// Batches that don't fill up stay the same size
+	b.full = false
+	for i := 0; i < batchAdjustEvery; i++ {
+		b.observe(time.Millisecond)
+	}
+	if size := b.size.Load(); size != 8 {
+		t.Errorf("expected batch size 8, got %d", size)
+	}
+
+	// A p99 over target halves the size
+	for i := 0; i < batchAdjustEvery; i++ {
+		b.observe(20 * time.Millisecond)
+	}
+	if size := b.size.Load(); size != 4 {
+		t.Errorf("expected batch size 4, got %d", size)
+	}
+	if p99 := time.Duration(b.p99.Load()); p99 != 20*time.Millisecond {
+		t.Errorf("expected p99 of 20ms, got %v", p99)
+	}
+}
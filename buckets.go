@@ -0,0 +1,281 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// maxBuckets limits the number of buckets AggregateBuckets computes.
+const maxBuckets = 10_000
+
+// Bucket is the aggregation of the events in one interval of a time range.
+type Bucket struct {
+	// Start is the inclusive start of the bucket.
+	Start time.Time `json:"start"`
+
+	// End is the exclusive end of the bucket.
+	End time.Time `json:"end"`
+
+	// Fill is how the values of a bucket without events were filled (see
+	// BucketOptions.Fill). It is FillZero for buckets with events.
+	Fill GapFill `json:"fill,omitempty"`
+
+	AggregateResult
+}
+
+// BucketOptions configures AggregateBucketsWithOptions.
+type BucketOptions struct {
+	// Fill decides the values of buckets without events. Defaults to
+	// FillZero.
+	Fill GapFill
+
+	// Cumulative returns running totals: the Count, Sum and ExactSum of
+	// each bucket include those of the buckets before it, and Avg is their
+	// running average. Min, Max and percentiles stay per bucket. Running
+	// totals have no gaps, so it cannot be combined with Fill.
+	Cumulative bool
+}
+
+// AggregateBuckets splits the query's time range into buckets of the given
+// interval and aggregates each one, oldest first. Buckets are aligned to
+// multiples of interval since the Unix epoch; the first and last bucket
+// only cover the part of them inside the range. q.Start is required and
+// q.End defaults to the current time.
+//
+// With Options.AggregateCacheSize set, buckets that have ended are served
+// from the cache, so repeated calls only recompute the current bucket.
+func (db *DB) AggregateBuckets(ctx context.Context, q Query, field string, aggs []AggregationType, interval time.Duration) ([]*Bucket, error) {
+	return db.AggregateBucketsWithOptions(ctx, q, field, aggs, interval, BucketOptions{})
+}
+
+// AggregateBucketsWithOptions is like AggregateBuckets but post-processes
+// the buckets as configured by opts.
+func (db *DB) AggregateBucketsWithOptions(ctx context.Context, q Query, field string, aggs []AggregationType, interval time.Duration, opts BucketOptions) ([]*Bucket, error) {
+	if opts.Fill < 0 || int(opts.Fill) >= len(gapFillNames) {
+		return nil, fmt.Errorf("%w: unknown gap fill %d", ErrInvalidQuery, int(opts.Fill))
+	}
+	if opts.Cumulative && opts.Fill != FillZero {
+		return nil, fmt.Errorf("%w: cumulative buckets cannot be filled", ErrInvalidQuery)
+	}
+	if q.Start == nil {
+		return nil, fmt.Errorf("%w: start time is required", ErrInvalidQuery)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: interval must be positive", ErrInvalidQuery)
+	}
+
+	end := db.now()
+	if q.End != nil {
+		end = *q.End
+	}
+	if end.Before(*q.Start) {
+		return nil, fmt.Errorf("%w: end is before start", ErrInvalidQuery)
+	}
+
+	first := alignEpoch(*q.Start, interval)
+	if n := end.Sub(first) / interval; n >= maxBuckets {
+		return nil, fmt.Errorf("%w: more than %d buckets", ErrInvalidQuery, maxBuckets)
+	}
+
+	now := db.now()
+
+	var buckets []*Bucket
+	for bucketStart := first; !bucketStart.After(end); bucketStart = bucketStart.Add(interval) {
+		bucketEnd := bucketStart.Add(interval)
+
+		// Query time ranges are inclusive at both ends
+		bq := q
+		start, last := bucketStart, bucketEnd.Add(-time.Nanosecond)
+		if start.Before(*q.Start) {
+			start = *q.Start
+		}
+		if last.After(end) {
+			last = end
+		}
+		bq.Start, bq.End = &start, &last
+
+		// Only buckets that have ended are cached, the current one changes
+		result, err := db.aggregate(ctx, bq, field, aggs, !bucketEnd.After(now))
+		if err != nil {
+			return nil, err
+		}
+
+		buckets = append(buckets, &Bucket{
+			Start:           bucketStart,
+			End:             bucketEnd,
+			AggregateResult: *result,
+		})
+	}
+
+	if opts.Cumulative {
+		accumulate(buckets)
+	}
+	fillGaps(buckets, opts.Fill)
+	return buckets, nil
+}
+
+// Comparison holds a bucketed aggregation and the same aggregation over an
+// earlier time range, bucket by bucket.
+type Comparison struct {
+	// Current are the buckets of the query's time range.
+	Current []*Bucket `json:"current"`
+
+	// Previous are the buckets of the time range Offset earlier, such that
+	// Previous[i] is Current[i] shifted by Offset.
+	Previous []*Bucket `json:"previous"`
+
+	// Offset is how much earlier the previous time range is.
+	Offset time.Duration `json:"offset"`
+}
+
+// CompareBuckets aggregates the query's time range and the same range offset
+// earlier into buckets, as AggregateBucketsWithOptions does, e.g. with an
+// offset of 7 * 24 * time.Hour for week-over-week comparisons. The offset
+// must be a positive multiple of interval, so the buckets of both ranges
+// line up. q.Start is required and q.End defaults to the current time.
+func (db *DB) CompareBuckets(ctx context.Context, q Query, field string, aggs []AggregationType, interval, offset time.Duration, opts BucketOptions) (*Comparison, error) {
+	if q.Start == nil {
+		return nil, fmt.Errorf("%w: start time is required", ErrInvalidQuery)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: interval must be positive", ErrInvalidQuery)
+	}
+	if offset <= 0 || offset%interval != 0 {
+		return nil, fmt.Errorf("%w: offset must be a positive multiple of the interval", ErrInvalidQuery)
+	}
+
+	// Fix the end, so both ranges are equally long
+	end := db.now()
+	if q.End != nil {
+		end = *q.End
+	}
+	q.End = &end
+
+	current, err := db.AggregateBucketsWithOptions(ctx, q, field, aggs, interval, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pq := q
+	start, last := q.Start.Add(-offset), end.Add(-offset)
+	pq.Start, pq.End = &start, &last
+	previous, err := db.AggregateBucketsWithOptions(ctx, pq, field, aggs, interval, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Comparison{Current: current, Previous: previous, Offset: offset}, nil
+}
+
+// accumulate turns the counts and sums of buckets into running totals.
+func accumulate(buckets []*Bucket) {
+	var (
+		count int64
+		sum   float64
+		exact *big.Rat // nil unless ExactSum was requested
+		scale int
+	)
+	for _, b := range buckets {
+		count += b.Count
+		sum += b.Sum
+		b.Count, b.Sum = count, sum
+		if count > 0 {
+			b.Avg = sum / float64(count)
+		}
+
+		if b.ExactSum != "" {
+			if exact == nil {
+				exact = new(big.Rat)
+			}
+			if v, ok := new(big.Rat).SetString(b.ExactSum); ok {
+				exact.Add(exact, v)
+			}
+			if _, frac, ok := strings.Cut(b.ExactSum, "."); ok {
+				scale = max(scale, len(frac))
+			}
+		}
+		if exact != nil {
+			b.ExactSum = exact.FloatString(scale)
+		}
+	}
+}
+
+// AggregateSliding aggregates windows of the given length, at least step,
+// that end every step, oldest first, e.g. for a moving average of the last hour
+// every five minutes. Window ends are aligned to multiples of step since the
+// Unix epoch; windows only cover the part of them inside the query's time
+// range. With window equal to step it returns the buckets of
+// AggregateBuckets. q.Start is required and q.End defaults to the current
+// time.
+//
+// With Options.AggregateCacheSize set, windows that have ended are served
+// from the cache.
+func (db *DB) AggregateSliding(ctx context.Context, q Query, field string, aggs []AggregationType, window, step time.Duration) ([]*Bucket, error) {
+	if q.Start == nil {
+		return nil, fmt.Errorf("%w: start time is required", ErrInvalidQuery)
+	}
+	if step <= 0 {
+		return nil, fmt.Errorf("%w: step must be positive", ErrInvalidQuery)
+	}
+	if window < step {
+		return nil, fmt.Errorf("%w: window is shorter than step", ErrInvalidQuery)
+	}
+
+	end := db.now()
+	if q.End != nil {
+		end = *q.End
+	}
+	if end.Before(*q.Start) {
+		return nil, fmt.Errorf("%w: end is before start", ErrInvalidQuery)
+	}
+
+	first := alignEpoch(*q.Start, step).Add(step)
+	if n := end.Sub(first) / step; n >= maxBuckets {
+		return nil, fmt.Errorf("%w: more than %d windows", ErrInvalidQuery, maxBuckets)
+	}
+
+	now := db.now()
+
+	var windows []*Bucket
+	for windowEnd := first; !windowEnd.Add(-step).After(end); windowEnd = windowEnd.Add(step) {
+		windowStart := windowEnd.Add(-window)
+
+		// Query time ranges are inclusive at both ends
+		wq := q
+		start, last := windowStart, windowEnd.Add(-time.Nanosecond)
+		if start.Before(*q.Start) {
+			start = *q.Start
+		}
+		if last.After(end) {
+			last = end
+		}
+		wq.Start, wq.End = &start, &last
+
+		result, err := db.aggregate(ctx, wq, field, aggs, !windowEnd.After(now))
+		if err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, &Bucket{
+			Start:           windowStart,
+			End:             windowEnd,
+			AggregateResult: *result,
+		})
+	}
+
+	return windows, nil
+}
+
+// alignEpoch rounds t down to a multiple of d since the Unix epoch.
+// time.Truncate aligns to the zero Time instead, which differs for
+// intervals that do not divide a day, such as 7 minutes, and for weeks.
+func alignEpoch(t time.Time, d time.Duration) time.Time {
+	offset := time.Duration(t.UnixNano() % int64(d))
+	if offset < 0 {
+		offset += d
+	}
+	return t.Add(-offset).Round(0)
+}
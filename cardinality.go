@@ -0,0 +1,365 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// CardinalityAction defines how tags exceeding cardinality limits are handled.
+type CardinalityAction int
+
+const (
+	// RejectEvent fails the append with a *CardinalityError.
+	RejectEvent CardinalityAction = iota
+	// DropTag stores the event without the offending tag.
+	DropTag
+)
+
+// CardinalityError is returned when an event carries a tag that would exceed
+// a configured cardinality limit. It wraps ErrCardinalityLimit.
+type CardinalityError struct {
+	// Key is the tag key that hit the limit.
+	Key string
+
+	// Value is the new tag value, or empty if the key itself was new.
+	Value string
+
+	// Limit is the limit that was reached.
+	Limit int
+}
+
+func (e *CardinalityError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("%v: new tag key %q exceeds limit of %d keys", ErrCardinalityLimit, e.Key, e.Limit)
+	}
+	return fmt.Sprintf("%v: tag key %q exceeds limit of %d values", ErrCardinalityLimit, e.Key, e.Limit)
+}
+
+func (e *CardinalityError) Unwrap() error {
+	return ErrCardinalityLimit
+}
+
+// cardinalityTracker keeps the distinct tag values seen per key so that new
+// values can be checked against the configured limits without touching disk.
+// Memory use is bounded by the limits themselves.
+//
+// Values are admitted before the write transaction commits, and forgotten
+// again if no event with them is stored. They are only forgotten when events
+// are deleted once compaction finds none left, so the tracked cardinality can
+// slightly overestimate what is stored. The sets are rebuilt from the index on
+// Open.
+type cardinalityTracker struct {
+	maxValues int
+	maxKeys   int
+	action    CardinalityAction
+
+	mu     sync.Mutex
+	values map[string]map[string]struct{}
+
+	// pending counts the admissions not yet settled that rely on a value no
+	// stored event has
+	pending map[[2]string]int
+
+	rejected int64
+	dropped  int64
+}
+
+// newCardinalityTracker returns a tracker for the given options,
+// or nil if no limits are configured.
+func newCardinalityTracker(opts Options) *cardinalityTracker {
+	if opts.MaxTagValuesPerKey <= 0 && opts.MaxTagKeys <= 0 {
+		return nil
+	}
+	return &cardinalityTracker{
+		maxValues: opts.MaxTagValuesPerKey,
+		maxKeys:   opts.MaxTagKeys,
+		action:    opts.CardinalityAction,
+		values:    make(map[string]map[string]struct{}),
+		pending:   make(map[[2]string]int),
+	}
+}
+
+// load populates the tracker from the tag index.
+// It visits one key per distinct tag pair by seeking past each pair's entries.
+func (c *cardinalityTracker) load(bdb *badger.DB) error {
+	return bdb.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(prefixTag)
+		it.Seek(prefix)
+		for it.ValidForPrefix(prefix) {
+			k, v, _, err := decodeTagIndexKey(it.Item().Key())
+			if err != nil {
+				it.Next()
+				continue
+			}
+			c.record(k, v)
+			it.Seek(prefixEnd(encodeTagIndexPrefix(k, v)))
+		}
+		return nil
+	})
+}
+
+// record adds a tag pair to the tracked sets without checking limits,
+// reporting whether it is new.
+func (c *cardinalityTracker) record(k, v string) bool {
+	set, ok := c.values[k]
+	if !ok {
+		set = make(map[string]struct{})
+		c.values[k] = set
+	}
+	if _, ok := set[v]; ok {
+		return false
+	}
+	set[v] = struct{}{}
+	return true
+}
+
+// admission lists the tag pairs an event was admitted with that no stored
+// event had yet. It is settled once the event is stored or has failed.
+type admission [][2]string
+
+// admit checks the event's tags against the limits and records new values.
+// Tags are considered in key order so that the outcome is deterministic when
+// several new keys compete for the last free slot. With DropTag, offending
+// tags are removed from the event; the caller's map is copied rather than
+// modified. With RejectEvent, a rejected event records no values.
+//
+// The returned admission must be passed to settle once the write of the
+// event has committed or failed.
+func (c *cardinalityTracker) admit(event *Event) (admission, error) {
+	keys := make([]string, 0, len(event.Tags))
+	for k := range event.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var admitted admission
+	var drop []string
+	for _, k := range keys {
+		v := event.Tags[k]
+		if err := c.check(k, v); err != nil {
+			if c.action != DropTag {
+				c.release(admitted)
+				atomic.AddInt64(&c.rejected, 1)
+				return nil, err
+			}
+			drop = append(drop, k)
+			continue
+		}
+		pair := [2]string{k, v}
+		if c.record(k, v) || c.pending[pair] > 0 {
+			c.pending[pair]++
+			admitted = append(admitted, pair)
+		}
+	}
+
+	if len(drop) > 0 {
+		tags := make(map[string]string, len(event.Tags)-len(drop))
+		for k, v := range event.Tags {
+			tags[k] = v
+		}
+		for _, k := range drop {
+			delete(tags, k)
+		}
+		event.Tags = tags
+		atomic.AddInt64(&c.dropped, int64(len(drop)))
+	}
+
+	return admitted, nil
+}
+
+// settle ends an admission: the values of a stored event are kept, and
+// those of an event that was not stored are forgotten, unless another
+// admission still relies on them or stored them meanwhile.
+func (c *cardinalityTracker) settle(a admission, stored bool) {
+	if c == nil || len(a) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !stored {
+		c.release(a)
+		return
+	}
+	for _, pair := range a {
+		delete(c.pending, pair)
+	}
+}
+
+// release forgets the values of an admission that failed. The caller holds
+// c.mu.
+func (c *cardinalityTracker) release(a admission) {
+	for _, pair := range a {
+		n, ok := c.pending[pair]
+		if !ok {
+			// Stored by another event
+			continue
+		}
+		if n > 1 {
+			c.pending[pair] = n - 1
+			continue
+		}
+		delete(c.pending, pair)
+		c.forgetLocked(pair[0], pair[1])
+	}
+}
+
+// check returns a *CardinalityError if admitting the tag would exceed a limit.
+func (c *cardinalityTracker) check(k, v string) error {
+	set, ok := c.values[k]
+	if !ok {
+		if c.maxKeys > 0 && len(c.values) >= c.maxKeys {
+			return &CardinalityError{Key: k, Limit: c.maxKeys}
+		}
+		return nil
+	}
+	if _, ok := set[v]; ok {
+		return nil
+	}
+	if c.maxValues > 0 && len(set) >= c.maxValues {
+		return &CardinalityError{Key: k, Value: v, Limit: c.maxValues}
+	}
+	return nil
+}
+
+// Thresholds for flagging a tag key as a likely label-explosion offender.
+const (
+	// suspectMinValues is the smallest distinct value count worth flagging.
+	suspectMinValues = 100
+	// suspectUniqueRatio is the share of index entries with distinct values
+	// above which a key looks like a per-event identifier.
+	suspectUniqueRatio = 0.5
+)
+
+// CardinalityReport summarises the tag index by key.
+type CardinalityReport struct {
+	// TotalIndexEntries is the number of tag index entries across all keys.
+	TotalIndexEntries int64
+
+	// TotalIndexBytes is the estimated size of the tag index.
+	TotalIndexBytes int64
+
+	// Keys lists each tag key, highest distinct value count first.
+	Keys []TagCardinality
+}
+
+// TagCardinality describes the index footprint of a single tag key.
+type TagCardinality struct {
+	Key            string
+	DistinctValues int64
+	IndexEntries   int64
+	IndexBytes     int64
+
+	// Suspect marks keys whose values are mostly unique per event, such as
+	// request or trace IDs. These keys dominate the index while rarely being
+	// useful as filters.
+	Suspect bool
+}
+
+// CardinalityReport scans the tag index and reports distinct value counts and
+// index size per tag key, flagging likely high-cardinality offenders.
+// The context can be used to cancel the scan.
+func (db *DB) CardinalityReport(ctx context.Context) (*CardinalityReport, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	report := &CardinalityReport{}
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var (
+			current   *TagCardinality
+			lastValue string
+		)
+
+		prefix := []byte(prefixTag)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			item := it.Item()
+			k, v, _, err := decodeTagIndexKey(item.Key())
+			if err != nil {
+				db.recordCorrupt(ctx)
+				continue
+			}
+
+			if current == nil || current.Key != k {
+				report.Keys = append(report.Keys, TagCardinality{Key: k})
+				current = &report.Keys[len(report.Keys)-1]
+				lastValue = ""
+			}
+
+			// Entries for the same value are contiguous within a key
+			if current.DistinctValues == 0 || v != lastValue {
+				current.DistinctValues++
+				lastValue = v
+			}
+
+			size := item.EstimatedSize()
+			current.IndexEntries++
+			current.IndexBytes += size
+			report.TotalIndexEntries++
+			report.TotalIndexBytes += size
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range report.Keys {
+		tc := &report.Keys[i]
+		tc.Suspect = tc.DistinctValues >= suspectMinValues &&
+			float64(tc.DistinctValues) >= suspectUniqueRatio*float64(tc.IndexEntries)
+	}
+
+	sort.SliceStable(report.Keys, func(i, j int) bool {
+		return report.Keys[i].DistinctValues > report.Keys[j].DistinctValues
+	})
+
+	return report, nil
+}
+
+// forget removes a tag pair whose events are all gone from the tracked sets,
+// and the key once it has no values left.
+func (c *cardinalityTracker) forget(k, v string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.forgetLocked(k, v)
+}
+
+// forgetLocked is forget for callers holding c.mu.
+func (c *cardinalityTracker) forgetLocked(k, v string) {
+	set := c.values[k]
+	delete(set, v)
+	if len(set) == 0 {
+		delete(c.values, k)
+	}
+}
@@ -0,0 +1,259 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestCardinalityRejectEvent(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{MaxTagValuesPerKey: 3})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := db.Append(Event{Type: "request", Tags: map[string]string{"request_id": fmt.Sprint(i)}})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Known values are still accepted
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"request_id": "0"}}); err != nil {
+		t.Fatalf("Append with known value failed: %v", err)
+	}
+
+	_, err = db.Append(Event{Type: "request", Tags: map[string]string{"request_id": "3"}})
+	if !errors.Is(err, ErrCardinalityLimit) {
+		t.Fatalf("expected ErrCardinalityLimit, got %v", err)
+	}
+	var cerr *CardinalityError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("expected *CardinalityError, got %T", err)
+	}
+	if cerr.Key != "request_id" || cerr.Limit != 3 {
+		t.Errorf("unexpected error details: %+v", cerr)
+	}
+
+	if got := db.Stats().CardinalityRejections; got != 1 {
+		t.Errorf("expected 1 rejection, got %d", got)
+	}
+
+	count, _ := db.Count()
+	if count != 4 {
+		t.Errorf("expected 4 events, got %d", count)
+	}
+}
+
+func TestCardinalityDropTag(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{MaxTagValuesPerKey: 1, CardinalityAction: DropTag})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{"service": "api", "request_id": "a"}
+	if _, err := db.Append(Event{Type: "request", Tags: tags}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	tags = map[string]string{"service": "api", "request_id": "b"}
+	event, err := db.Append(Event{Type: "request", Tags: tags})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	if _, ok := event.Tags["request_id"]; ok {
+		t.Error("expected request_id tag to be dropped")
+	}
+	if event.Tags["service"] != "api" {
+		t.Error("expected service tag to be kept")
+	}
+	if tags["request_id"] != "b" {
+		t.Error("caller's tag map must not be modified")
+	}
+
+	if got := db.Stats().CardinalityDroppedTags; got != 1 {
+		t.Errorf("expected 1 dropped tag, got %d", got)
+	}
+
+	events, err := db.Query(context.Background(), Query{Tags: map[string]string{"request_id": "b"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("expected dropped tag not to be indexed, got %d events", len(events))
+	}
+}
+
+func TestCardinalityMaxTagKeys(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{MaxTagKeys: 1})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Append(Event{Type: "request", Tags: map[string]string{"a": "1", "b": "1"}})
+	if !errors.Is(err, ErrCardinalityLimit) {
+		t.Fatalf("expected ErrCardinalityLimit, got %v", err)
+	}
+
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"a": "2"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+}
+
+func TestCardinalityNotStored(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{MaxTagKeys: 1})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Neither rejected events nor failed writes use up the only key
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"a": "1", "b": "1"}}); !errors.Is(err, ErrCardinalityLimit) {
+		t.Fatalf("expected ErrCardinalityLimit, got %v", err)
+	}
+	_, err = db.AppendBatch([]Event{
+		{Type: "request", Tags: map[string]string{"c": "1"}},
+		{Type: "request", Tags: map[string]string{"d": "1"}},
+	})
+	if !errors.Is(err, ErrCardinalityLimit) {
+		t.Fatalf("expected ErrCardinalityLimit, got %v", err)
+	}
+	missing := ulid.Make()
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"e": "1"}, Supersedes: &missing}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	err = db.Tx(func(tx *Tx) error {
+		if _, err := tx.Append(Event{Type: "request", Tags: map[string]string{"f": "1"}}); err != nil {
+			return err
+		}
+		return errors.New("rolled back")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"g": "1"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"h": "1"}}); !errors.Is(err, ErrCardinalityLimit) {
+		t.Errorf("expected the stored key to count, got %v", err)
+	}
+}
+
+func TestCardinalityLoadedOnOpen(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		_, _ = db.Append(Event{Type: "request", Tags: map[string]string{"user": fmt.Sprint(i)}})
+		_, _ = db.Append(Event{Type: "request", Tags: map[string]string{"user": fmt.Sprint(i)}})
+	}
+	db.Close()
+
+	db, err = OpenWithOptions(dir, Options{MaxTagValuesPerKey: 2})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"user": "1"}}); err != nil {
+		t.Fatalf("Append with known value failed: %v", err)
+	}
+	_, err = db.Append(Event{Type: "request", Tags: map[string]string{"user": "2"}})
+	if !errors.Is(err, ErrCardinalityLimit) {
+		t.Fatalf("expected ErrCardinalityLimit, got %v", err)
+	}
+}
+
+func TestCardinalityReport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	events := make([]Event, 0, 200)
+	for i := 0; i < 200; i++ {
+		events = append(events, Event{
+			Type: "request",
+			Tags: map[string]string{
+				"service":    []string{"api", "web"}[i%2],
+				"request_id": fmt.Sprintf("req-%d", i),
+			},
+		})
+	}
+	if _, err := db.AppendBatch(events); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	report, err := db.CardinalityReport(context.Background())
+	if err != nil {
+		t.Fatalf("CardinalityReport failed: %v", err)
+	}
+
+	if len(report.Keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(report.Keys))
+	}
+	if report.TotalIndexEntries != 400 {
+		t.Errorf("expected 400 index entries, got %d", report.TotalIndexEntries)
+	}
+
+	top := report.Keys[0]
+	if top.Key != "request_id" || top.DistinctValues != 200 || !top.Suspect {
+		t.Errorf("unexpected top key: %+v", top)
+	}
+
+	service := report.Keys[1]
+	if service.Key != "service" || service.DistinctValues != 2 || service.IndexEntries != 200 || service.Suspect {
+		t.Errorf("unexpected service key: %+v", service)
+	}
+	if service.IndexBytes <= 0 {
+		t.Error("expected index bytes to be reported")
+	}
+}
@@ -0,0 +1,221 @@
+package squid
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// metaCheckpointPrefix is the prefix of the metadata records of
+// checkpointed aggregations.
+const metaCheckpointPrefix = "checkpoint/"
+
+// encodeCheckpointKey creates the key of a checkpoint.
+// Format: M:checkpoint/<name>
+// The value holds the end of the committed range and, while an aggregation
+// is being exported, the end of its pending range: 8 or 16 bytes of big
+// endian Unix nanoseconds.
+func encodeCheckpointKey(name string) []byte {
+	return encodeMetaKey(metaCheckpointPrefix + name)
+}
+
+// ingestClock hands out the ingest times of appends and tracks the appends
+// that have not committed yet, so that checkpoints only advance past ingest
+// times to which no append can still add events.
+type ingestClock struct {
+	mu      sync.Mutex
+	pending map[*time.Time]struct{}
+}
+
+// begin returns the ingest time of an append and a function to call once
+// the append has committed or failed.
+func (c *ingestClock) begin(now func() time.Time) (time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := now()
+	if c.pending == nil {
+		c.pending = make(map[*time.Time]struct{})
+	}
+	c.pending[&t] = struct{}{}
+	return t, func() {
+		c.mu.Lock()
+		delete(c.pending, &t)
+		c.mu.Unlock()
+	}
+}
+
+// horizon returns the time before which every append has committed: the
+// current time, or the ingest time of the oldest pending append.
+func (c *ingestClock) horizon(now func() time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := now()
+	for t := range c.pending {
+		if t.Before(h) {
+			h = *t
+		}
+	}
+	return h
+}
+
+// CheckpointRange is the range of ingest times covered by one evaluation of
+// a checkpointed aggregation.
+type CheckpointRange struct {
+	// Checkpoint is the name of the checkpoint.
+	Checkpoint string `json:"checkpoint"`
+
+	// From is the inclusive start of the range, the end of the previous
+	// range. It is zero for the first range of a checkpoint.
+	From time.Time `json:"from"`
+
+	// To is the exclusive end of the range, where the checkpoint advances to.
+	To time.Time `json:"to"`
+}
+
+// checkpointState is the decoded value of a checkpoint.
+type checkpointState struct {
+	committed time.Time
+	pending   time.Time
+}
+
+// readCheckpoint reads the state of a checkpoint in txn. A checkpoint that
+// was never committed has a zero state.
+func readCheckpoint(txn *badger.Txn, key []byte) (checkpointState, error) {
+	var state checkpointState
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return state, nil
+	}
+	if err != nil {
+		return state, &QueryError{Stage: StageFetch, Key: key, Err: err}
+	}
+
+	err = item.Value(func(val []byte) error {
+		if len(val) != 8 && len(val) != 16 {
+			return ErrCorruptRecord
+		}
+		if committed := int64(binary.BigEndian.Uint64(val)); committed != 0 {
+			state.committed = time.Unix(0, committed).UTC()
+		}
+		if len(val) == 16 {
+			state.pending = time.Unix(0, int64(binary.BigEndian.Uint64(val[8:]))).UTC()
+		}
+		return nil
+	})
+	if err != nil {
+		return state, &QueryError{Stage: StageDecode, Key: key, Err: err}
+	}
+	return state, nil
+}
+
+// encode returns the value of a checkpoint.
+func (s checkpointState) encode() []byte {
+	val := make([]byte, 8, 16)
+	if !s.committed.IsZero() {
+		binary.BigEndian.PutUint64(val, uint64(s.committed.UnixNano()))
+	}
+	if !s.pending.IsZero() {
+		val = binary.BigEndian.AppendUint64(val, uint64(s.pending.UnixNano()))
+	}
+	return val
+}
+
+// AggregateCheckpoint evaluates an aggregation over the events matching q
+// that were appended since the checkpoint with the given name last
+// advanced, passes the result to fn, and advances the checkpoint once fn
+// returns nil. Each event is covered by exactly one range of a checkpoint,
+// which lets an exporter push the deltas of metrics, such as usage to a
+// billing system, without counting an event twice or missing one.
+//
+// Ranges are of ingest time, so events backfilled with old timestamps are
+// covered by the next range. A range ends before the oldest append still in
+// progress, so that no event can be appended into a range once evaluated.
+// If fn fails, or the process stops before the checkpoint advances, the
+// next call evaluates the same range again: fn should make its push
+// idempotent by the range, for example by using the range's To as the key
+// of the push. Events updated or deleted after their range was evaluated
+// are not evaluated again.
+//
+// q must not set IngestedStart or IngestedEnd, nor Partial, as a partial
+// result would leave the events it skipped out of every range. Concurrent
+// calls for the same checkpoint fail with an error wrapping ErrTxConflict.
+func (db *DB) AggregateCheckpoint(ctx context.Context, name string, q Query, field string, aggs []AggregationType, fn func(CheckpointRange, *AggregateResult) error) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := checkMetaKey(name); err != nil {
+		return err
+	}
+	if q.IngestedStart != nil || q.IngestedEnd != nil {
+		return fmt.Errorf("%w: a checkpoint sets the ingest time range", ErrInvalidQuery)
+	}
+	if q.Partial {
+		return fmt.Errorf("%w: a checkpoint cannot aggregate partial results", ErrInvalidQuery)
+	}
+
+	key := encodeCheckpointKey(name)
+	var state checkpointState
+	err := db.checkpointUpdate(func(txn *badger.Txn) error {
+		var err error
+		if state, err = readCheckpoint(txn, key); err != nil {
+			return err
+		}
+		if !state.pending.IsZero() {
+			return nil
+		}
+		state.pending = db.ingest.horizon(db.now)
+		if state.pending.Before(state.committed) {
+			state.pending = state.committed
+		}
+		return txn.Set(key, state.encode())
+	})
+	if err != nil {
+		return err
+	}
+
+	r := CheckpointRange{Checkpoint: name, From: state.committed, To: state.pending}
+	if !r.From.IsZero() {
+		q.IngestedStart = &r.From
+	}
+	end := r.To.Add(-time.Nanosecond)
+	q.IngestedEnd = &end
+
+	result, err := db.aggregate(ctx, q, field, aggs, false)
+	if err != nil {
+		return err
+	}
+	if err := fn(r, result); err != nil {
+		return err
+	}
+
+	return db.checkpointUpdate(func(txn *badger.Txn) error {
+		current, err := readCheckpoint(txn, key)
+		if err != nil {
+			return err
+		}
+		if !current.committed.Equal(state.committed) || !current.pending.Equal(state.pending) {
+			return fmt.Errorf("%w: checkpoint %q advanced concurrently", ErrTxConflict, name)
+		}
+		return txn.Set(key, checkpointState{committed: r.To}.encode())
+	})
+}
+
+// checkpointUpdate runs fn in a transaction, reporting conflicts with
+// concurrent updates of the same checkpoint as ErrTxConflict.
+func (db *DB) checkpointUpdate(fn func(txn *badger.Txn) error) error {
+	err := db.badger.Update(fn)
+	if err == badger.ErrConflict {
+		return fmt.Errorf("%w: %v", ErrTxConflict, err)
+	}
+	return err
+}
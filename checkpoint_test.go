@@ -0,0 +1,104 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAggregateCheckpoint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	db, err := OpenWithOptions(dir, Options{
+		IndexIngestTime: true,
+		Now:             func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	q := Query{Types: []string{"usage"}}
+	appendUsage := func(units float64) {
+		t.Helper()
+		if _, err := db.Append(Event{Type: "usage", Data: map[string]any{"units": units}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	export := func(fail error) (CheckpointRange, float64, error) {
+		t.Helper()
+		var r CheckpointRange
+		var sum float64
+		err := db.AggregateCheckpoint(ctx, "billing", q, "units", []AggregationType{Sum}, func(cr CheckpointRange, result *AggregateResult) error {
+			r, sum = cr, result.Sum
+			return fail
+		})
+		return r, sum, err
+	}
+
+	appendUsage(10)
+	appendUsage(20)
+	now = now.Add(time.Second)
+
+	r, sum, err := export(nil)
+	if err != nil {
+		t.Fatalf("AggregateCheckpoint failed: %v", err)
+	}
+	if sum != 30 || !r.From.IsZero() || !r.To.Equal(now) {
+		t.Errorf("expected 30 up to %v, got %v over %v", now, sum, r)
+	}
+
+	// A failed export is evaluated again over the same range
+	appendUsage(5)
+	now = now.Add(time.Second)
+	pushFailed := errors.New("push failed")
+	failed, sum, err := export(pushFailed)
+	if !errors.Is(err, pushFailed) || sum != 5 {
+		t.Fatalf("expected the push to fail with 5, got %v, %v", sum, err)
+	}
+	appendUsage(1)
+	retried, sum, err := export(nil)
+	if err != nil {
+		t.Fatalf("AggregateCheckpoint failed: %v", err)
+	}
+	if retried != failed || sum != 5 || !retried.From.Equal(r.To) {
+		t.Errorf("expected 5 over %v, got %v over %v", failed, sum, retried)
+	}
+
+	// Ranges end before appends in progress
+	now = now.Add(time.Second)
+	inProgress, done := db.ingest.begin(db.now)
+	now = now.Add(time.Second)
+	r, sum, err = export(nil)
+	done()
+	if err != nil {
+		t.Fatalf("AggregateCheckpoint failed: %v", err)
+	}
+	if sum != 1 || !r.To.Equal(inProgress) {
+		t.Errorf("expected 1 up to %v, got %v over %v", inProgress, sum, r)
+	}
+
+	r, sum, err = export(nil)
+	if err != nil || sum != 0 || !r.To.Equal(now) {
+		t.Errorf("expected nothing up to %v, got %v over %v, %v", now, sum, r, err)
+	}
+
+	q.Partial = true
+	if _, _, err := export(nil); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for a partial query, got %v", err)
+	}
+	q.Partial = false
+
+	q.IngestedEnd = &now
+	if _, _, err := export(nil); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Minimal JavaScript client for the Squid HTTP API (see api/openapi.yaml).
+//
+// Works in browsers and Node.js 18+ (uses the global fetch).
+//
+//   import { Client, Aggregation } from "./squid-client.js";
+//
+//   const c = new Client("http://localhost:8080");
+//   await c.append({ type: "request", tags: { service: "api" }, data: { latency: 42.5 } });
+//   const events = await c.query({ types: ["request"], limit: 100 });
+//   const result = await c.aggregate({ types: ["request"] }, "latency", [Aggregation.AVG, Aggregation.P99]);
+
+export const Aggregation = Object.freeze({
+  COUNT: "count",
+  SUM: "sum",
+  AVG: "avg",
+  MIN: "min",
+  MAX: "max",
+  P50: "p50",
+  P95: "p95",
+  P99: "p99",
+  EXACT_SUM: "exact_sum",
+});
+
+export class SquidError extends Error {
+  constructor(status, code, message) {
+    super(message);
+    this.name = "SquidError";
+    this.status = status;
+    this.code = code;
+  }
+}
+
+export class Client {
+  constructor(baseURL, { fetch: fetchImpl = globalThis.fetch } = {}) {
+    this.baseURL = baseURL.replace(/\/+$/, "");
+    this.fetch = fetchImpl;
+  }
+
+  append(event) {
+    return this.#do("POST", "/v1/events", event);
+  }
+
+  appendBatch(events) {
+    return this.#do("POST", "/v1/events/batch", events);
+  }
+
+  // get returns the event, or its latest correction if latest is set.
+  get(id, latest = false) {
+    return this.#do("GET", `/v1/events/${encodeURIComponent(id)}${latest ? "?latest=true" : ""}`);
+  }
+
+  // update replaces an event's type, tags and data. event.version must match
+  // the stored version, otherwise a SquidError with code "version_conflict" is thrown.
+  update(event) {
+    return this.#do("PUT", `/v1/events/${encodeURIComponent(event.id)}`, event);
+  }
+
+  // query accepts { start, end, types, type_pattern, tags, exclude_types,
+  // exclude_tags, where, has_fields, missing_fields, search, search_tags,
+  // latest, limit, offset, descending, hint, omit_data, min_id, max_id }.
+  // where is a list of { field, op, value } data filters.
+  // start and end may be Date objects or RFC 3339 strings.
+  query(query = {}) {
+    return this.#do("POST", "/v1/query", toQuery(query));
+  }
+
+  aggregate(query, field, aggregations) {
+    return this.#do("POST", "/v1/aggregate", {
+      query: toQuery(query),
+      field,
+      aggregations,
+    });
+  }
+
+  async count() {
+    const body = await this.#do("GET", "/v1/count");
+    return body.count;
+  }
+
+  async #do(method, path, body) {
+    const init = { method, headers: {} };
+    if (body !== undefined) {
+      init.body = JSON.stringify(body);
+      init.headers["Content-Type"] = "application/json";
+    }
+
+    const resp = await this.fetch(this.baseURL + path, init);
+    const payload = await resp.json().catch(() => ({}));
+    if (!resp.ok) {
+      throw new SquidError(resp.status, payload.code ?? "", payload.error ?? resp.statusText);
+    }
+    return payload;
+  }
+}
+
+function toQuery({ start, end, ...rest } = {}) {
+  const q = { ...rest };
+  if (start !== undefined) q.start = start instanceof Date ? start.toISOString() : start;
+  if (end !== undefined) q.end = end instanceof Date ? end.toISOString() : end;
+  return q;
+}
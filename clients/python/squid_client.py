@@ -0,0 +1,151 @@
+"""Minimal Python client for the Squid HTTP API (see api/openapi.yaml).
+
+Uses only the standard library.
+
+    from squid_client import Client
+
+    c = Client("http://localhost:8080")
+    c.append({"type": "request", "tags": {"service": "api"}, "data": {"latency": 42.5}})
+    events = c.query(types=["request"], limit=100)
+    result = c.aggregate("latency", [Client.AVG, Client.P99], types=["request"])
+"""
+
+import json
+import urllib.error
+import urllib.request
+
+
+class SquidError(Exception):
+    """Raised when the server returns an error response."""
+
+    def __init__(self, status, code, message):
+        super().__init__(message)
+        self.status = status
+        self.code = code
+
+
+class Client:
+    COUNT, SUM, AVG, MIN, MAX, P50, P95, P99 = "count", "sum", "avg", "min", "max", "p50", "p95", "p99"
+    EXACT_SUM = "exact_sum"
+
+    def __init__(self, base_url, timeout=30):
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+
+    def append(self, event):
+        return self._do("POST", "/v1/events", event)
+
+    def append_batch(self, events):
+        return self._do("POST", "/v1/events/batch", list(events))
+
+    def get(self, event_id, latest=False):
+        # latest returns the latest correction of the event instead.
+        return self._do("GET", "/v1/events/" + event_id + ("?latest=true" if latest else ""))
+
+    def update(self, event):
+        # event["version"] must match the stored version, otherwise a
+        # SquidError with code "version_conflict" is raised.
+        return self._do("PUT", "/v1/events/" + event["id"], event)
+
+    def query(self, start=None, end=None, types=None, tags=None, limit=0, descending=False, hint=None, omit_data=False,
+              min_id=None, max_id=None, offset=0, exclude_types=None, exclude_tags=None, type_pattern=None,
+              where=None, has_fields=None, missing_fields=None, search=None, search_tags=False,
+              latest=False):
+        # hint overrides the query planner: "no_index", "full_scan",
+        # "index:type" or "index:tag:<key>". min_id and max_id bound the
+        # event IDs inclusively. offset skips that many matching events.
+        # exclude_types and exclude_tags leave out matching events.
+        # type_pattern is a regular expression matching whole types.
+        # where is a list of (field, op, value) data filters, e.g.
+        # [("status", "gte", 500)]. has_fields and missing_fields list data
+        # fields events must have or not have. search finds events whose
+        # data contains all its words; search_tags also searches tag values.
+        # latest skips events superseded by a correction.
+        return self._do("POST", "/v1/query", _query(start, end, types, tags, limit, descending, hint, omit_data,
+                                                    min_id, max_id, offset, exclude_types, exclude_tags,
+                                                    type_pattern, where, has_fields, missing_fields,
+                                                    search, search_tags, latest))
+
+    def aggregate(self, field, aggregations, start=None, end=None, types=None, tags=None):
+        body = {
+            "query": _query(start, end, types, tags, 0, False, None, False),
+            "field": field,
+            "aggregations": list(aggregations),
+        }
+        return self._do("POST", "/v1/aggregate", body)
+
+    def count(self):
+        return self._do("GET", "/v1/count")["count"]
+
+    def _do(self, method, path, body=None):
+        data = None
+        headers = {}
+        if body is not None:
+            data = json.dumps(body).encode("utf-8")
+            headers["Content-Type"] = "application/json"
+
+        req = urllib.request.Request(self.base_url + path, data=data, headers=headers, method=method)
+        try:
+            with urllib.request.urlopen(req, timeout=self.timeout) as resp:
+                return json.load(resp)
+        except urllib.error.HTTPError as e:
+            try:
+                payload = json.load(e)
+            except ValueError:
+                payload = {}
+            raise SquidError(e.code, payload.get("code", ""), payload.get("error", str(e))) from None
+
+
+def _query(start, end, types, tags, limit, descending, hint, omit_data, min_id=None, max_id=None, offset=0,
+           exclude_types=None, exclude_tags=None, type_pattern=None, where=None,
+           has_fields=None, missing_fields=None, search=None, search_tags=False, latest=False):
+    q = {}
+    if start is not None:
+        q["start"] = _rfc3339(start)
+    if end is not None:
+        q["end"] = _rfc3339(end)
+    if types:
+        q["types"] = list(types)
+    if type_pattern:
+        q["type_pattern"] = type_pattern
+    if tags:
+        q["tags"] = dict(tags)
+    if exclude_types:
+        q["exclude_types"] = list(exclude_types)
+    if exclude_tags:
+        q["exclude_tags"] = dict(exclude_tags)
+    if where:
+        q["where"] = [{"field": f, "op": op, "value": v} for f, op, v in where]
+    if has_fields:
+        q["has_fields"] = list(has_fields)
+    if missing_fields:
+        q["missing_fields"] = list(missing_fields)
+    if search:
+        q["search"] = search
+    if search_tags:
+        q["search_tags"] = True
+    if latest:
+        q["latest"] = True
+    if limit:
+        q["limit"] = limit
+    if offset:
+        q["offset"] = offset
+    if descending:
+        q["descending"] = True
+    if hint:
+        q["hint"] = hint
+    if omit_data:
+        q["omit_data"] = True
+    if min_id is not None:
+        q["min_id"] = min_id
+    if max_id is not None:
+        q["max_id"] = max_id
+    return q
+
+
+def _rfc3339(t):
+    if isinstance(t, str):
+        return t
+    if t.tzinfo is None:
+        raise ValueError("timestamps must be timezone-aware")
+    return t.isoformat()
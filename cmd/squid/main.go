@@ -0,0 +1,560 @@
+// Command squid works with Squid databases from the command line.
+//
+// Usage:
+//
+//	squid report [flags]
+//	squid query [flags] sql
+//	squid import [flags] file...
+//	squid tail [flags] file...
+//	squid journal [flags]
+//	squid kube [flags]
+//	squid docker [flags]
+//
+// The report command writes a static HTML summary of recent events, e.g.
+// for a daily email from cron:
+//
+//	squid report -data /var/lib/squid --since 24h --out report.html
+//
+// The query command runs a SQL-like query (see squid.ParseSQL) and writes
+// the events as JSON lines, or the aggregations as a JSON object:
+//
+//	squid query -data /var/lib/squid "SELECT p99(data.latency) WHERE type = 'request' SINCE 1h"
+//
+// The import command imports JSON or logfmt exports. With -verify, each
+// file is first checked against its entry in the archive's manifest (see
+// squid.Manifest), and nothing is imported unless every file matches:
+//
+//	squid import -data /var/lib/squid -verify /archive/2024-01.json /archive/2024-02.json
+//
+// The tail command follows log files and appends their lines as events
+// until it is interrupted, resuming where it stopped when run again:
+//
+//	squid tail -data /var/lib/squid -format json -time-field ts /var/log/app.log
+//
+// The journal command does the same for the systemd journal:
+//
+//	squid journal -data /var/lib/squid -units nginx.service,sshd.service
+//
+// The kube command runs in a Kubernetes pod and stores the cluster's
+// Events, with the pod's service account:
+//
+//	squid kube -data /data -namespace shop
+//
+// The docker command collects the logs of the host's Docker containers:
+//
+//	squid docker -data /var/lib/squid -json -tag-labels com.docker.compose.service
+//
+// The database is opened with squidclient.Open, so the report and query
+// commands also work while a daemon that shares the database has it open, including
+// the commands that collect events.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/asungur/squid"
+	"github.com/asungur/squid/squidclient"
+	"github.com/asungur/squid/squiddocker"
+	"github.com/asungur/squid/squidjournal"
+	"github.com/asungur/squid/squidkube"
+	"github.com/asungur/squid/squidreport"
+	"github.com/asungur/squid/squidtail"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "squid:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command given by args.
+func run(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: squid <command> [flags]\n\ncommands:\n  report   write an HTML summary of recent events\n  query    run a SQL-like query\n  import   import exported events\n  tail     follow log files into events\n  journal  follow the systemd journal into events\n  kube     watch Kubernetes Events into events\n  docker   follow Docker container logs into events")
+		return errors.New("no command given")
+	}
+
+	switch args[0] {
+	case "report":
+		return report(args[1:], stdout, stderr)
+	case "query":
+		return query(args[1:], stdout, stderr)
+	case "import":
+		return importFiles(args[1:], stdout, stderr)
+	case "tail":
+		return tail(args[1:], stderr)
+	case "journal":
+		return journal(args[1:], stderr)
+	case "kube":
+		return kube(args[1:], stderr)
+	case "docker":
+		return docker(args[1:], stderr)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// report implements the report command.
+func report(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("report", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	out := fs.String("out", "-", "output file (- for standard output)")
+	title := fs.String("title", "", "report title")
+	since := fs.Duration("since", 24*time.Hour, "how far back the report looks")
+	intervals := fs.Int("intervals", 24, "number of intervals in charts")
+	top := fs.Int("top", 10, "number of event types listed")
+	errorTypes := fs.String("errors", "error", "comma-separated event types counted as errors")
+	latency := fs.String("latency", "duration_ms", "data field whose percentiles are charted")
+	latencyTypes := fs.String("latency-types", "", "comma-separated event types with latencies (default all)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	r, err := squidreport.Build(context.Background(), db, squidreport.Options{
+		Title:        *title,
+		Since:        *since,
+		Intervals:    *intervals,
+		TopTypes:     *top,
+		ErrorTypes:   splitList(*errorTypes),
+		LatencyField: *latency,
+		LatencyTypes: splitList(*latencyTypes),
+	})
+	if err != nil {
+		return err
+	}
+
+	if *out == "-" {
+		return r.WriteHTML(stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := r.WriteHTML(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// query implements the query command.
+func query(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("expected one query, quoted")
+	}
+
+	stmt, err := squid.ParseSQL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	q := stmt.Query
+	if stmt.Since > 0 {
+		start := time.Now().Add(-stmt.Since)
+		if q.Start == nil || q.Start.Before(start) {
+			q.Start = &start
+		}
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	enc := json.NewEncoder(stdout)
+	if len(stmt.Aggregations) > 0 {
+		// Aggregating no field counts every event, as COUNT(*) does
+		result, err := db.Aggregate(context.Background(), q, stmt.Field, stmt.Aggregations)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(result)
+	}
+
+	events, err := db.Query(context.Background(), q)
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFiles implements the import command.
+func importFiles(args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	format := fs.String("format", "json", "format of the files without -verify: json or logfmt")
+	verify := fs.Bool("verify", false, "check the files against their manifest entries before importing them")
+	manifestPath := fs.String("manifest", "", "manifest of the files with -verify (default manifest.json next to each file)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("expected files to import")
+	}
+
+	// Every file is checked before any is imported, so that a mismatch
+	// leaves the database untouched
+	entries := make([]squid.ManifestFile, fs.NArg())
+	if *verify {
+		for i, path := range fs.Args() {
+			entry, err := manifestEntry(*manifestPath, path)
+			if err != nil {
+				return err
+			}
+			if err := verifyFile(path, entry); err != nil {
+				return err
+			}
+			entries[i] = entry
+		}
+	} else {
+		f, err := squid.ParseExportFormat(*format)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			entries[i].Format = f.String()
+		}
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	shared, ok := db.(*squidclient.Shared)
+	if !ok {
+		return fmt.Errorf("%s is open in another process", *data)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for i, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		var n int64
+		if *verify {
+			n, err = shared.ImportVerified(ctx, f, entries[i])
+		} else {
+			format, _ := squid.ParseExportFormat(entries[i].Format)
+			n, err = shared.Import(ctx, f, format)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(stdout, "%s: imported %d events\n", path, n)
+	}
+	return nil
+}
+
+// manifestEntry returns the entry of the file at path in the manifest at
+// manifestPath, or in manifest.json next to the file if manifestPath is
+// empty. Entries are named relative to the directory of the manifest.
+func manifestEntry(manifestPath, path string) (squid.ManifestFile, error) {
+	if manifestPath == "" {
+		manifestPath = filepath.Join(filepath.Dir(path), "manifest.json")
+	}
+	b, err := os.ReadFile(manifestPath)
+	if err != nil {
+		return squid.ManifestFile{}, err
+	}
+	var m squid.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return squid.ManifestFile{}, fmt.Errorf("%s: %w", manifestPath, err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(manifestPath))
+	if err != nil {
+		return squid.ManifestFile{}, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return squid.ManifestFile{}, err
+	}
+	name, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return squid.ManifestFile{}, err
+	}
+	for _, entry := range m.Files {
+		if filepath.Clean(filepath.FromSlash(entry.Name)) == name {
+			return entry, nil
+		}
+	}
+	return squid.ManifestFile{}, fmt.Errorf("%s is not in %s", path, manifestPath)
+}
+
+// verifyFile checks the file at path against its manifest entry.
+func verifyFile(path string, entry squid.ManifestFile) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return squid.VerifyExport(f, entry)
+}
+
+// tail implements the tail command.
+func tail(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	format := fs.String("format", "plain", "line format: plain, json, regexp or csv")
+	pattern := fs.String("pattern", "", "regular expression with named groups for the regexp format")
+	columns := fs.String("columns", "", "comma-separated CSV columns (default the first line)")
+	eventType := fs.String("type", "log", "event type")
+	typeField := fs.String("type-field", "", "field holding the event type")
+	timeField := fs.String("time-field", "", "field holding the event timestamp")
+	timeLayout := fs.String("time-layout", "", "Go time layout of the timestamp, or unix or unixms (default RFC 3339)")
+	tags := fs.String("tags", "", "comma-separated key=value tags added to every event")
+	tagFields := fs.String("tag-fields", "", "comma-separated fields stored as tags")
+	fromEnd := fs.Bool("from-end", false, "skip the lines written before a file is first followed")
+	poll := fs.Duration("poll", time.Second, "how often files are checked for new lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no files given")
+	}
+
+	file := squidtail.File{
+		Type:       *eventType,
+		Format:     squidtail.Format(*format),
+		Columns:    splitList(*columns),
+		TypeField:  *typeField,
+		TimeField:  *timeField,
+		TimeLayout: *timeLayout,
+		TagFields:  splitList(*tagFields),
+		FromEnd:    *fromEnd,
+	}
+	if *pattern != "" {
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			return err
+		}
+		file.Pattern = re
+	}
+	for _, tag := range splitList(*tags) {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok {
+			return fmt.Errorf("tag %q is not key=value", tag)
+		}
+		if file.Tags == nil {
+			file.Tags = make(map[string]string)
+		}
+		file.Tags[key] = value
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	shared, ok := db.(*squidclient.Shared)
+	if !ok {
+		return fmt.Errorf("%s is open in another process", *data)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	t := squidtail.New(shared.DB, squidtail.Options{
+		PollInterval: *poll,
+		OnError: func(name string, err error) {
+			fmt.Fprintf(stderr, "squid: %s: %v\n", name, err)
+		},
+	})
+	defer t.Close()
+
+	for _, path := range fs.Args() {
+		// Positions are stored under the absolute path, so that they are
+		// found from any working directory
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		file.Path = abs
+		if err := t.Follow(abs, file); err != nil {
+			return err
+		}
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// journal implements the journal command.
+func journal(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("journal", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	name := fs.String("name", "", "name of the stored cursor (default local)")
+	eventType := fs.String("type", "journal", "prefix of the event types")
+	units := fs.String("units", "", "comma-separated systemd units followed (default all)")
+	tagFields := fs.String("tag-fields", "", "comma-separated journal fields stored as tags")
+	dataFields := fs.String("data-fields", "", "comma-separated journal fields stored as data (default all)")
+	fromEnd := fs.Bool("from-end", false, "skip the entries logged before the first run")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	shared, ok := db.(*squidclient.Shared)
+	if !ok {
+		return fmt.Errorf("%s is open in another process", *data)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	r := squidjournal.New(shared.DB, squidjournal.Options{
+		Name:       *name,
+		Type:       *eventType,
+		Units:      splitList(*units),
+		TagFields:  splitList(*tagFields),
+		DataFields: splitList(*dataFields),
+		FromEnd:    *fromEnd,
+	})
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// kube implements the kube command.
+func kube(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("kube", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	name := fs.String("name", "", "name of the stored watch state (default cluster)")
+	namespace := fs.String("namespace", "", "namespace watched (default all)")
+	fieldSelector := fs.String("field-selector", "", "field selector of the Events watched, e.g. type=Warning")
+	eventType := fs.String("type", "kube", "prefix of the event types")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := squidkube.InClusterConfig()
+	if err != nil {
+		return err
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	shared, ok := db.(*squidclient.Shared)
+	if !ok {
+		return fmt.Errorf("%s is open in another process", *data)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := squidkube.New(shared.DB, config, squidkube.Options{
+		Name:          *name,
+		Namespace:     *namespace,
+		FieldSelector: *fieldSelector,
+		Type:          *eventType,
+		OnError: func(err error) {
+			fmt.Fprintln(stderr, "squid:", err)
+		},
+	})
+	if err := w.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// docker implements the docker command.
+func docker(args []string, stderr io.Writer) error {
+	fs := flag.NewFlagSet("docker", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	data := fs.String("data", "./data", "database directory")
+	socket := fs.String("socket", "/var/run/docker.sock", "Docker daemon socket")
+	eventType := fs.String("type", "docker", "prefix of the event types")
+	labels := fs.String("labels", "", "comma-separated labels, key or key=value, of the containers followed (default all)")
+	tagLabels := fs.String("tag-labels", "", "comma-separated container labels stored as tags")
+	jsonLines := fs.Bool("json", false, "store the members of JSON lines as data")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := squidclient.Open(*data, squid.Options{})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	shared, ok := db.(*squidclient.Shared)
+	if !ok {
+		return fmt.Errorf("%s is open in another process", *data)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c := squiddocker.New(shared.DB, squiddocker.Options{
+		Socket:    *socket,
+		Type:      *eventType,
+		Labels:    splitList(*labels),
+		TagLabels: splitList(*tagLabels),
+		JSON:      *jsonLines,
+		OnError: func(err error) {
+			fmt.Fprintln(stderr, "squid:", err)
+		},
+	})
+	if err := c.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
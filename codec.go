@@ -0,0 +1,31 @@
+package squid
+
+import "encoding/json"
+
+// JSONCodec encodes events for storage and decodes them again, letting a
+// faster JSON implementation replace encoding/json in scans. Drop-in
+// replacements such as jsoniter.ConfigCompatibleWithStandardLibrary or
+// sonic.ConfigStd satisfy it.
+//
+// Stored events must stay readable by encoding/json, which ParseEvent,
+// exports and older versions of Squid use, so the codec must be compatible
+// with it: same field names, time and number formats, and valid UTF-8.
+type JSONCodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// stdJSON is the default JSONCodec, backed by encoding/json.
+type stdJSON struct{}
+
+func (stdJSON) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+func (stdJSON) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// codec returns the JSON codec configured in the options.
+func (db *DB) codec() JSONCodec {
+	if db.opts.JSON != nil {
+		return db.opts.JSON
+	}
+	return stdJSON{}
+}
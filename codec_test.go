@@ -0,0 +1,83 @@
+package squid
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// countingCodec is a JSONCodec that counts its calls.
+type countingCodec struct {
+	marshals, unmarshals atomic.Int64
+}
+
+func (c *countingCodec) Marshal(v any) ([]byte, error) {
+	c.marshals.Add(1)
+	return json.Marshal(v)
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v any) error {
+	c.unmarshals.Add(1)
+	return json.Unmarshal(data, v)
+}
+
+func TestJSONCodec(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	codec := &countingCodec{}
+	db, err := OpenWithOptions(dir, Options{JSON: codec})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := db.Append(Event{Type: "request", Data: map[string]any{"latency": float64(i)}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	// Events are stored as a primary record and a data payload
+	if got := codec.marshals.Load(); got != 10 {
+		t.Errorf("expected 10 marshals, got %d", got)
+	}
+
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	if got := codec.unmarshals.Load(); got != 10 {
+		t.Errorf("expected 10 unmarshals after Query, got %d", got)
+	}
+
+	result, err := db.Aggregate(ctx, Query{Types: []string{"request"}}, "latency", []AggregationType{Sum})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Sum != 10 {
+		t.Errorf("expected sum 10, got %v", result.Sum)
+	}
+	if got := codec.unmarshals.Load(); got != 20 {
+		t.Errorf("expected 20 unmarshals after Aggregate, got %d", got)
+	}
+
+	// Stored events stay readable by encoding/json
+	err = db.QueryRaw(ctx, Query{Limit: 1}, func(_ ulid.ULID, value []byte) error {
+		_, err := ParseEvent(value)
+		return err
+	})
+	if err != nil {
+		t.Errorf("ParseEvent failed on stored event: %v", err)
+	}
+}
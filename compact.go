@@ -0,0 +1,298 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"slices"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// compactBatch bounds the number of index entries removed per transaction.
+const compactBatch = 1000
+
+// IndexCompaction reports what CompactIndex removed.
+type IndexCompaction struct {
+	// DanglingEntries is the number of type, tag, ingest time and search
+	// index entries removed because their event is gone.
+	DanglingEntries int64
+
+	// RetiredTypes lists the types left without events whose index entries
+	// or hourly counts were removed.
+	RetiredTypes []string
+
+	// RetiredTagKeys lists the tag keys left without events whose index
+	// entries were removed.
+	RetiredTagKeys []string
+}
+
+// indexUsage counts the entries of an index prefix and how many of them
+// were dangling.
+type indexUsage struct {
+	entries  int64
+	dangling int64
+}
+
+// gone reports whether every entry of the prefix was dangling.
+func (u indexUsage) gone() bool {
+	return u.entries > 0 && u.entries == u.dangling
+}
+
+// CompactIndex removes the residue of events that are gone: index entries
+// whose event no longer exists, the hourly counts of types without events
+// (unless Options.CountRetention keeps them), and the tag values that
+// cardinality limits still track for tags without events. Deletes clean up
+// after themselves, so residue is left only by failed best-effort index
+// deletes and stores written by older versions; long-lived stores whose
+// types and tags are retired over time can run it occasionally to stay
+// tidy. The context can be used to cancel it, and to report progress with
+// WithProgress.
+//
+// CompactIndex reads every index entry and is safe to run alongside other
+// operations.
+func (db *DB) CompactIndex(ctx context.Context) (*IndexCompaction, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	t := startProgress(ctx, "compact index")
+	defer t.done()
+
+	report := &IndexCompaction{}
+	types := make(map[string]indexUsage)
+	tagKeys := make(map[string]indexUsage)
+	tagPairs := make(map[[2]string]indexUsage)
+
+	for _, prefix := range []string{prefixType, prefixTag, prefixIngest, prefixSearch} {
+		err := db.compactPrefix(ctx, []byte(prefix), report, func(key []byte, dangling bool) {
+			t.add(1, int64(len(key)))
+			var n int64
+			if dangling {
+				n = 1
+			}
+			switch prefix {
+			case prefixType:
+				if t, _, err := decodeTypeIndexKey(key); err == nil {
+					types[t] = indexUsage{types[t].entries + 1, types[t].dangling + n}
+				}
+			case prefixTag:
+				if k, v, _, err := decodeTagIndexKey(key); err == nil {
+					tagKeys[k] = indexUsage{tagKeys[k].entries + 1, tagKeys[k].dangling + n}
+					pair := [2]string{k, v}
+					tagPairs[pair] = indexUsage{tagPairs[pair].entries + 1, tagPairs[pair].dangling + n}
+				}
+			}
+		})
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Counts retained longer than events expire on their own
+	var retired []string
+	if db.opts.CountRetention == 0 {
+		var err error
+		if retired, err = db.retireCounts(ctx, types); err != nil {
+			return report, err
+		}
+	}
+	for t, usage := range types {
+		if usage.gone() && !slices.Contains(retired, t) {
+			retired = append(retired, t)
+		}
+	}
+	slices.Sort(retired)
+	report.RetiredTypes = retired
+
+	for k, usage := range tagKeys {
+		if usage.gone() {
+			report.RetiredTagKeys = append(report.RetiredTagKeys, k)
+		}
+	}
+	slices.Sort(report.RetiredTagKeys)
+
+	if db.cardinality != nil {
+		for pair, usage := range tagPairs {
+			if usage.gone() {
+				db.cardinality.forget(pair[0], pair[1])
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// compactPrefix removes the dangling entries of the index with the given
+// prefix, calling visit with every entry and whether it was dangling.
+func (db *DB) compactPrefix(ctx context.Context, prefix []byte, report *IndexCompaction, visit func(key []byte, dangling bool)) error {
+	seek := prefix
+	for seek != nil {
+		var dangling [][]byte
+		var ids []ulid.ULID
+
+		// Collect a batch of dangling entries, then remove them
+		err := db.badger.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			var scanned int
+			defer func() { recordScanned(ctx, scanned) }()
+			for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+				if scanned%scanCheckInterval == 0 {
+					if err := ctx.Err(); err != nil {
+						return err
+					}
+				}
+				scanned++
+
+				key := it.Item().Key()
+				if len(dangling) == compactBatch {
+					seek = slices.Clone(key)
+					return nil
+				}
+
+				id, err := decodeIndexKey(key)
+				if err != nil {
+					db.recordCorrupt(ctx)
+					continue
+				}
+				_, err = txn.Get(encodeEventKey(id))
+				if err != nil && err != badger.ErrKeyNotFound {
+					return &QueryError{Stage: StageFetch, Key: encodeEventKey(id), Err: err}
+				}
+				gone := err == badger.ErrKeyNotFound
+				if gone {
+					dangling = append(dangling, slices.Clone(key))
+					ids = append(ids, id)
+				}
+				visit(key, gone)
+			}
+			seek = nil
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(dangling) == 0 {
+			continue
+		}
+
+		err = db.badger.Update(func(txn *badger.Txn) error {
+			for i, key := range dangling {
+				// An event appended since with a reused ID keeps its entry
+				if _, err := txn.Get(encodeEventKey(ids[i])); err != badger.ErrKeyNotFound {
+					continue
+				}
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				report.DanglingEntries++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retireCounts cancels the hourly counts of types without any type index
+// entries left, given the usage of the type index found by CompactIndex,
+// and returns those types. Counts are cancelled with negative deltas rather
+// than deleted, so that events of the type appended meanwhile still count.
+func (db *DB) retireCounts(ctx context.Context, types map[string]indexUsage) ([]string, error) {
+	var candidates []string
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(prefixCount)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			eventType, _, err := decodeCountKey(it.Item().Key())
+			if err != nil {
+				it.Next()
+				continue
+			}
+			// Visit one key per type
+			it.Seek(prefixEnd(encodeCountPrefix(eventType)))
+
+			if usage, ok := types[eventType]; ok && !usage.gone() {
+				continue
+			}
+			if !hasKeys(txn, encodeTypeIndexPrefix(eventType)) {
+				candidates = append(candidates, eventType)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var retired []string
+	var hours []countKey
+	for _, eventType := range candidates {
+		err := db.badger.Update(func(txn *badger.Txn) error {
+			// An event of the type may have been appended since
+			if hasKeys(txn, encodeTypeIndexPrefix(eventType)) {
+				return nil
+			}
+
+			typePrefix := encodeCountPrefix(eventType)
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = typePrefix
+			it := txn.NewIterator(opts)
+			deltas := make(countDeltas)
+			for it.Seek(typePrefix); it.ValidForPrefix(typePrefix); it.Next() {
+				item := it.Item()
+				hour := int64(binary.BigEndian.Uint64(item.Key()[len(typePrefix):]))
+				err := item.Value(func(val []byte) error {
+					deltas[countKey{eventType, hour}] -= decodeCount(val)
+					return nil
+				})
+				if err != nil {
+					it.Close()
+					return err
+				}
+			}
+			it.Close()
+
+			retired = append(retired, eventType)
+			for k := range deltas {
+				hours = append(hours, k)
+			}
+			return db.counts.write(txn, deltas)
+		})
+		if err != nil {
+			return retired, err
+		}
+	}
+
+	// Merge the cancelled hours away now rather than on the next tick
+	return retired, db.counts.merge(db.badger, hours)
+}
+
+// hasKeys reports whether any key starts with prefix.
+func hasKeys(txn *badger.Txn, prefix []byte) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	it.Seek(prefix)
+	return it.Valid() && bytes.HasPrefix(it.Item().Key(), prefix)
+}
@@ -0,0 +1,97 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestCompactIndex(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{MaxTagValuesPerKey: 1})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	var legacy []*AppendResult
+	for i := 0; i < 3; i++ {
+		result, err := db.Append(Event{Type: "legacy", Tags: map[string]string{"build": "1", "env": "prod"}})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		legacy = append(legacy, result)
+	}
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"env": "prod"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Remove the legacy events but not their indices, as a failed
+	// best-effort index delete would
+	err = db.badger.Update(func(txn *badger.Txn) error {
+		for _, r := range legacy {
+			if err := txn.Delete(encodeEventKey(r.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	report, err := db.CompactIndex(ctx)
+	if err != nil {
+		t.Fatalf("CompactIndex failed: %v", err)
+	}
+	if report.DanglingEntries != 9 {
+		t.Errorf("expected 9 dangling entries removed, got %d", report.DanglingEntries)
+	}
+	if !slices.Equal(report.RetiredTypes, []string{"legacy"}) {
+		t.Errorf("expected legacy to be retired, got %v", report.RetiredTypes)
+	}
+	if !slices.Equal(report.RetiredTagKeys, []string{"build"}) {
+		t.Errorf("expected build to be retired, got %v", report.RetiredTagKeys)
+	}
+
+	counts, err := db.HourlyCounts(ctx, Query{})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	if len(counts) != 1 || counts[0].Type != "request" || counts[0].Count != 1 {
+		t.Errorf("expected only the request count, got %+v", counts)
+	}
+
+	// The retired tag value no longer counts towards the limit
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"build": "2"}}); err != nil {
+		t.Errorf("expected a new build value to be admitted, got %v", err)
+	}
+	events, err := db.Query(ctx, Query{Tags: map[string]string{"env": "prod"}})
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected the request event, got %d, %v", len(events), err)
+	}
+
+	// A second run finds nothing
+	report, err = db.CompactIndex(ctx)
+	if err != nil {
+		t.Fatalf("CompactIndex failed: %v", err)
+	}
+	if report.DanglingEntries != 0 || len(report.RetiredTypes) != 0 || len(report.RetiredTagKeys) != 0 {
+		t.Errorf("expected nothing to compact, got %+v", report)
+	}
+
+	db.Close()
+	if _, err := db.CompactIndex(ctx); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
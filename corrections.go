@@ -0,0 +1,134 @@
+package squid
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxCorrections bounds the corrections Latest follows, in case a chain
+// loops in a store written with custom IDs.
+const maxCorrections = 1 << 16
+
+// checkSupersedes checks that the event an appended correction supersedes
+// is stored and not superseded yet. Each event is superseded at most once,
+// so the corrections of an event form a chain ending at its latest.
+// Reading the correction key makes concurrent corrections of the same event
+// conflict, so that only one commits.
+func (db *DB) checkSupersedes(txn *badger.Txn, event *Event) error {
+	if event.Supersedes == nil {
+		return nil
+	}
+	id := *event.Supersedes
+
+	key := encodeEventKey(id)
+	_, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return fmt.Errorf("%w: superseded event %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return &QueryError{Stage: StageFetch, Key: key, Err: err}
+	}
+
+	by, ok, err := supersededBy(txn, id)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return fmt.Errorf("%w: %s is superseded by %s", ErrSuperseded, id, by)
+	}
+	return nil
+}
+
+// supersededBy returns the ID of the correction that supersedes the event
+// with the given ID, if there is one.
+func supersededBy(txn *badger.Txn, id ulid.ULID) (ulid.ULID, bool, error) {
+	key := encodeCorrectionKey(id)
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return ulid.ULID{}, false, nil
+	}
+	if err != nil {
+		return ulid.ULID{}, false, &QueryError{Stage: StageFetch, Key: key, Err: err}
+	}
+
+	var by ulid.ULID
+	var valid bool
+	err = item.Value(func(val []byte) error {
+		valid = len(val) == ulidLen
+		copy(by[:], val)
+		return nil
+	})
+	if err != nil {
+		return ulid.ULID{}, false, &QueryError{Stage: StageFetch, Key: key, Err: err}
+	}
+	if !valid {
+		return ulid.ULID{}, false, &QueryError{Stage: StageDecode, Key: key, Err: ErrCorruptRecord}
+	}
+	return by, true, nil
+}
+
+// supersedeConflict returns the error of appending events whose commit
+// failed: two corrections of the same event committed concurrently conflict,
+// and the one that lost fails with ErrSuperseded.
+func supersedeConflict(err error, events []Event) error {
+	if !errors.Is(err, badger.ErrConflict) {
+		return err
+	}
+	for _, e := range events {
+		if e.Supersedes != nil {
+			return fmt.Errorf("%w: %s was superseded concurrently", ErrSuperseded, *e.Supersedes)
+		}
+	}
+	return err
+}
+
+// Latest returns the latest correction of the event with the given ID,
+// following corrections of corrections, or the event itself if no event
+// supersedes it. Corrections are events appended with Event.Supersedes set,
+// so the events they correct stay stored as they were.
+func (db *DB) Latest(id ulid.ULID) (*Event, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	var event Event
+	err := db.badger.View(func(txn *badger.Txn) error {
+		key := encodeEventKey(id)
+		if _, err := txn.Get(key); err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		} else if err != nil {
+			return &QueryError{Stage: StageFetch, Key: key, Err: err}
+		}
+
+		for range maxCorrections {
+			by, ok, err := supersededBy(txn, id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+			id = by
+		}
+
+		key = encodeEventKey(id)
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return &QueryError{Stage: StageFetch, Key: key, Err: err}
+		}
+		return db.decodeEvent(txn, item, &event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,221 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+func TestCorrections(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	payment, err := db.Append(Event{Timestamp: base, Type: "payment", Data: map[string]any{"amount": 100.0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	other, err := db.Append(Event{Timestamp: base.Add(time.Minute), Type: "payment", Data: map[string]any{"amount": 5.0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Corrections must supersede a stored event that is not superseded yet
+	missing := ulid.Make()
+	if _, err := db.Append(Event{Type: "payment", Supersedes: &missing}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	fix, err := db.Append(Event{Timestamp: base, Type: "payment", Supersedes: &payment.ID, Data: map[string]any{"amount": 110.0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := db.AppendBatch([]Event{{Type: "payment", Supersedes: &payment.ID}}); !errors.Is(err, ErrSuperseded) {
+		t.Errorf("expected ErrSuperseded, got %v", err)
+	}
+	fix2, err := db.Append(Event{Timestamp: base, Type: "payment", Supersedes: &fix.ID, Data: map[string]any{"amount": 120.0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Latest follows the chain of corrections
+	for _, id := range []ulid.ULID{payment.ID, fix.ID, fix2.ID} {
+		latest, err := db.Latest(id)
+		if err != nil {
+			t.Fatalf("Latest failed: %v", err)
+		}
+		if latest.ID != fix2.ID || latest.Data["amount"] != 120.0 {
+			t.Errorf("expected the second correction for %s, got %s %v", id, latest.ID, latest.Data)
+		}
+	}
+	if latest, err := db.Latest(other.ID); err != nil || latest.ID != other.ID {
+		t.Errorf("expected the uncorrected event itself, got %v, %v", latest, err)
+	}
+
+	// Queries return every event unless asked for the latest
+	events, err := db.Query(ctx, Query{Types: []string{"payment"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 4 {
+		t.Errorf("expected 4 events, got %d", len(events))
+	}
+	events, err = db.Query(ctx, Query{Types: []string{"payment"}, Latest: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != fix2.ID || events[1].ID != other.ID {
+		t.Errorf("expected the second correction and the other payment, got %v", events)
+	}
+
+	result, err := db.Aggregate(ctx, Query{Types: []string{"payment"}, Latest: true}, "amount", []AggregationType{Sum})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Sum != 125 {
+		t.Errorf("expected a sum of 125, got %v", result.Sum)
+	}
+
+	// Updates keep what a correction supersedes
+	fix2.Event.Data = map[string]any{"amount": 130.0}
+	if _, err := db.Update(*fix2.Event); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if latest, err := db.Latest(payment.ID); err != nil || latest.Data["amount"] != 130.0 || *latest.Supersedes != fix.ID {
+		t.Errorf("expected the updated correction, got %v, %v", latest, err)
+	}
+
+	// and updates of superseded events keep what supersedes them
+	for _, keepData := range []bool{false, true} {
+		stored, err := db.Get(payment.ID)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		stored.Tags = map[string]string{"checked": "yes"}
+		if keepData {
+			_, err = db.UpdateMetadata(*stored)
+		} else {
+			_, err = db.Update(*stored)
+		}
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if latest, err := db.Latest(payment.ID); err != nil || latest.ID != fix2.ID {
+			t.Errorf("expected the second correction after the update, got %v, %v", latest, err)
+		}
+	}
+	events, err = db.Query(ctx, Query{Types: []string{"payment"}, Latest: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected the second correction and the other payment, got %v", events)
+	}
+}
+
+func TestImportCorrections(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := Open(dir + "/src")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(dir + "/dst")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer dst.Close()
+
+	ctx := context.Background()
+	original, err := src.Append(Event{Type: "payment", Data: map[string]any{"amount": 100.0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := src.Append(Event{Type: "payment", Supersedes: &original.ID, Data: map[string]any{"amount": 110.0}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export(ctx, &buf, Query{}, JSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if n, err := dst.Import(ctx, &buf, JSON); err != nil || n != 2 {
+		t.Fatalf("expected 2 imported, got %d, %v", n, err)
+	}
+
+	events, err := dst.Query(ctx, Query{Latest: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Data["amount"] != 110.0 {
+		t.Errorf("expected the imported correction only, got %v", events)
+	}
+}
+
+func TestDeleteCorrections(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	payment, err := db.Append(Event{Timestamp: base, Type: "payment"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	fix, err := db.Append(Event{Timestamp: base.Add(time.Hour), Type: "payment", Supersedes: &payment.ID})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	hasLink := func(id ulid.ULID) bool {
+		var ok bool
+		err := db.badger.View(func(txn *badger.Txn) error {
+			_, ok, err = supersededBy(txn, id)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("supersededBy failed: %v", err)
+		}
+		return ok
+	}
+
+	// Deleting the superseded event removes its link to the correction
+	if n, err := db.DeleteBefore(base.Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("expected 1 deleted, got %d, %v", n, err)
+	}
+	if hasLink(payment.ID) {
+		t.Error("expected no correction key for the deleted event")
+	}
+	if latest, err := db.Latest(fix.ID); err != nil || latest.ID != fix.ID {
+		t.Errorf("expected the correction itself, got %v, %v", latest, err)
+	}
+}
@@ -0,0 +1,555 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// PartitionCount is the number of events of one type stored in one hour or day.
+type PartitionCount struct {
+	// Start is the start of the hour or UTC day.
+	Start time.Time `json:"start"`
+
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+const (
+	// countCompactInterval is how often hourly count deltas are merged.
+	countCompactInterval = 10 * time.Second
+
+	// countCompactThreshold is the number of deltas written for a single
+	// hour and type that triggers an early merge.
+	countCompactThreshold = 1000
+
+	// countCompactBatch bounds the number of hours merged per transaction.
+	countCompactBatch = 1000
+
+	// countPruneInterval is how often counts older than
+	// Options.CountRetention are deleted.
+	countPruneInterval = time.Hour
+
+	msPerHour = int64(time.Hour / time.Millisecond)
+)
+
+// metaCounts records that the hourly counts cover every stored event.
+var metaCounts = encodeMetaKey("counts")
+
+// countKey identifies the events of one type stored in one hour.
+type countKey struct {
+	eventType string
+	hour      int64 // hours since the Unix epoch
+}
+
+// hourOf returns the hour an event ID belongs to.
+func hourOf(id ulid.ULID) int64 {
+	return int64(id.Time()) / msPerHour
+}
+
+// hourRange returns the hours overlapping a query's time range.
+func hourRange(q Query) (int64, int64) {
+	from, to := int64(0), int64(math.MaxInt64)
+	if q.Start != nil && q.Start.UnixMilli() > 0 {
+		from = q.Start.UnixMilli() / msPerHour
+	}
+	if q.End != nil {
+		to = q.End.UnixMilli() / msPerHour
+	}
+	return from, to
+}
+
+// countDeltas collects the changes to hourly counts made by a transaction.
+type countDeltas map[countKey]int64
+
+// add records n events of eventType stored (or removed, if n is negative)
+// with the given ID.
+func (d countDeltas) add(eventType string, id ulid.ULID, n int64) {
+	d[countKey{eventType, hourOf(id)}] += n
+}
+
+// countTracker maintains per-hour, per-type event counts.
+//
+// Writers never read the counts: each transaction stores its changes as
+// delta keys with a unique suffix, so concurrent appends do not conflict.
+// A background goroutine merges the deltas of each hour into a single key,
+// and readers sum whatever keys an hour has.
+type countTracker struct {
+	mu    sync.Mutex
+	dirty map[countKey]int // deltas written per hour since the last merge
+
+	// prune deletes expired counts every countPruneInterval, if set.
+	prune func(context.Context) error
+
+	wake   chan struct{}
+	cancel chan struct{}
+	done   chan struct{}
+}
+
+func newCountTracker() *countTracker {
+	return &countTracker{
+		dirty: make(map[countKey]int),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// write stores deltas in txn.
+func (c *countTracker) write(txn *badger.Txn, deltas countDeltas) error {
+	suffix := ulid.Make()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, n := range deltas {
+		if n == 0 {
+			continue
+		}
+		if err := txn.Set(encodeCountKey(k.eventType, k.hour, suffix), encodeCount(n)); err != nil {
+			return fmt.Errorf("failed to write count of %s: %w", k.eventType, err)
+		}
+
+		c.dirty[k]++
+		if c.dirty[k] == countCompactThreshold {
+			signal(c.wake)
+		}
+	}
+	return nil
+}
+
+// load rebuilds the counts from the type index if they are missing, as in
+// databases created before they were maintained, and merges any deltas
+// left behind by an unclean shutdown.
+func (c *countTracker) load(bdb *badger.DB) error {
+	var complete bool
+	err := bdb.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(metaCounts)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		complete = err == nil
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if !complete {
+		return c.rebuild(bdb)
+	}
+
+	var keys []countKey
+	err = bdb.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(prefixCount)
+		var last countKey
+		var n int
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			eventType, hour, err := decodeCountKey(it.Item().Key())
+			if err != nil {
+				continue
+			}
+			k := countKey{eventType, hour}
+			if k != last {
+				last, n = k, 0
+			}
+			if n++; n == 2 {
+				keys = append(keys, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.merge(bdb, keys)
+}
+
+// rebuild recomputes the counts from the type index.
+func (c *countTracker) rebuild(bdb *badger.DB) error {
+	if err := bdb.DropPrefix([]byte(prefixCount)); err != nil {
+		return err
+	}
+
+	counts := make(map[countKey]int64)
+	err := bdb.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(prefixType)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			eventType, id, err := decodeTypeIndexKey(it.Item().Key())
+			if err != nil {
+				continue
+			}
+			counts[countKey{eventType, hourOf(id)}]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	wb := bdb.NewWriteBatch()
+	defer wb.Cancel()
+	for k, n := range counts {
+		if err := wb.Set(encodeCountKey(k.eventType, k.hour, ulid.ULID{}), encodeCount(n)); err != nil {
+			return err
+		}
+	}
+	if err := wb.Set(metaCounts, nil); err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// start merges deltas in the background until stop is called.
+func (c *countTracker) start(bdb *badger.DB, logger Logger) {
+	c.cancel = make(chan struct{})
+	c.done = make(chan struct{})
+
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(countCompactInterval)
+		defer ticker.Stop()
+
+		var pruned time.Time
+		for {
+			select {
+			case <-c.cancel:
+				return
+			case <-ticker.C:
+			case <-c.wake:
+			}
+
+			if err := c.mergeDirty(bdb); err != nil && logger != nil {
+				logger.Warningf("squid: merging hourly counts: %v", err)
+			}
+
+			if c.prune != nil && time.Since(pruned) >= countPruneInterval {
+				pruned = time.Now()
+				if err := c.prune(context.Background()); err != nil && logger != nil {
+					logger.Warningf("squid: pruning hourly counts: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// stop ends the background merging and merges the remaining deltas.
+func (c *countTracker) stop(bdb *badger.DB) error {
+	if c.cancel != nil {
+		close(c.cancel)
+		<-c.done
+	}
+	return c.mergeDirty(bdb)
+}
+
+// mergeDirty merges the deltas written since the last merge.
+func (c *countTracker) mergeDirty(bdb *badger.DB) error {
+	c.mu.Lock()
+	keys := make([]countKey, 0, len(c.dirty))
+	for k, n := range c.dirty {
+		if n > 1 {
+			keys = append(keys, k)
+		}
+	}
+	clear(c.dirty)
+	c.mu.Unlock()
+
+	return c.merge(bdb, keys)
+}
+
+// merge replaces the delta keys of each hour with a single key holding their sum.
+func (c *countTracker) merge(bdb *badger.DB, keys []countKey) error {
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), countCompactBatch)]
+		keys = keys[len(batch):]
+
+		err := bdb.Update(func(txn *badger.Txn) error {
+			for _, k := range batch {
+				if err := mergeHour(txn, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeHour merges the delta keys of one hour. Writers only add new keys,
+// so deltas committed concurrently are simply left for the next merge.
+func mergeHour(txn *badger.Txn, k countKey) error {
+	prefix := binary.BigEndian.AppendUint64(encodeCountPrefix(k.eventType), uint64(k.hour))
+
+	var sum int64
+	var keys [][]byte
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		err := item.Value(func(val []byte) error {
+			sum += decodeCount(val)
+			return nil
+		})
+		if err != nil {
+			it.Close()
+			return err
+		}
+		keys = append(keys, item.KeyCopy(nil))
+	}
+	it.Close()
+
+	if len(keys) < 2 {
+		return nil
+	}
+
+	for _, key := range keys {
+		if err := txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	if sum == 0 {
+		return nil
+	}
+	return txn.Set(encodeCountKey(k.eventType, k.hour, ulid.ULID{}), encodeCount(sum))
+}
+
+// encodeCount encodes a count delta.
+func encodeCount(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+// decodeCount decodes a count delta, treating malformed values as zero.
+func decodeCount(val []byte) int64 {
+	if len(val) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(val))
+}
+
+// countCutoff returns the first hour whose counts Options.CountRetention
+// keeps. Counts of earlier hours may have been pruned.
+func (db *DB) countCutoff() int64 {
+	return db.now().Add(-db.opts.CountRetention).UnixMilli() / msPerHour
+}
+
+// countsCover reports whether the hourly counts hold every hour of the
+// query's time range. With Options.CountRetention set, the counts of old
+// hours are pruned even while their events remain.
+func (db *DB) countsCover(q Query) bool {
+	if db.opts.CountRetention == 0 {
+		return true
+	}
+	from, _ := hourRange(q)
+	return from >= db.countCutoff()
+}
+
+// pruneCounts deletes the counts of hours that ended more than
+// Options.CountRetention ago.
+func (db *DB) pruneCounts(ctx context.Context) error {
+	cutoff := db.countCutoff()
+
+	var expired [][]byte
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := []byte(prefixCount)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if len(expired)%scanCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			_, hour, err := decodeCountKey(it.Item().Key())
+			if err == nil && hour < cutoff {
+				expired = append(expired, it.Item().KeyCopy(nil))
+			}
+		}
+		return nil
+	})
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+
+	wb := db.badger.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range expired {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// HourlyCounts returns the number of events of each type stored in each
+// hour overlapping the query's time range, oldest first. Hours without
+// events are omitted. Counts are maintained as events are written, so this
+// is fast regardless of the number of events; the time range is rounded
+// out to whole hours. Only Start, End and Types are used; tag filters and
+// ID ranges are not supported. With Options.CountRetention set, counts
+// include expired events until the counts themselves expire.
+func (db *DB) HourlyCounts(ctx context.Context, q Query) ([]PartitionCount, error) {
+	return db.partitionCounts(ctx, q, 1)
+}
+
+// DailyCounts is like HourlyCounts, but counts events per UTC day.
+func (db *DB) DailyCounts(ctx context.Context, q Query) ([]PartitionCount, error) {
+	return db.partitionCounts(ctx, q, 24)
+}
+
+// partitionCounts sums the hourly counts into partitions of the given number of hours.
+func (db *DB) partitionCounts(ctx context.Context, q Query, hours int64) ([]PartitionCount, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if len(q.Tags) > 0 || len(q.ExcludeTags) > 0 {
+		return nil, fmt.Errorf("%w: tag filters are not supported by partition counts", ErrInvalidQuery)
+	}
+	if q.MinID != nil || q.MaxID != nil {
+		return nil, fmt.Errorf("%w: ID ranges are not supported by partition counts", ErrInvalidQuery)
+	}
+	if q.filtersData() {
+		return nil, fmt.Errorf("%w: data filters are not supported by partition counts", ErrInvalidQuery)
+	}
+	if q.Latest {
+		return nil, fmt.Errorf("%w: Latest is not supported by partition counts", ErrInvalidQuery)
+	}
+
+	if err := checkTypePattern(q); err != nil {
+		return nil, err
+	}
+
+	defer recordDuration(ctx, time.Now())
+	recordPlan(ctx, "hourly counts")
+
+	counts := make(map[countKey]int64)
+	err := db.badger.View(func(txn *badger.Txn) error {
+		return db.scanCounts(ctx, txn, q, func(k countKey, n int64) {
+			k.hour -= k.hour % hours
+			counts[k] += n
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]PartitionCount, 0, len(counts))
+	for k, n := range counts {
+		if n <= 0 {
+			continue
+		}
+		result = append(result, PartitionCount{
+			Start: time.UnixMilli(k.hour * msPerHour).UTC(),
+			Type:  k.eventType,
+			Count: n,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].Start.Equal(result[j].Start) {
+			return result[i].Start.Before(result[j].Start)
+		}
+		return result[i].Type < result[j].Type
+	})
+
+	return result, nil
+}
+
+// countEvents returns an upper bound of the number of events matching the
+// query's types in its time range, rounded out to whole hours.
+func (db *DB) countEvents(ctx context.Context, txn *badger.Txn, q Query) (int64, error) {
+	var total int64
+	err := db.scanCounts(ctx, txn, q, func(_ countKey, n int64) {
+		total += n
+	})
+	return total, err
+}
+
+// scanCounts calls fn with every count delta of the query's types, less its
+// excluded types and those its type pattern does not match, in the hours
+// overlapping its time range.
+func (db *DB) scanCounts(ctx context.Context, txn *badger.Txn, q Query, fn func(countKey, int64)) error {
+	from, to := hourRange(q)
+
+	prefixes := [][]byte{[]byte(prefixCount)}
+	if len(q.Types) > 0 {
+		prefixes = prefixes[:0]
+		for _, eventType := range q.Types {
+			prefixes = append(prefixes, encodeCountPrefix(eventType))
+		}
+	}
+
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+	for _, prefix := range prefixes {
+		seekKey := prefix
+		if len(q.Types) > 0 {
+			seekKey = binary.BigEndian.AppendUint64(bytes.Clone(prefix), uint64(from))
+		}
+
+		for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+			if scanned%scanCheckInterval == 0 {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+			}
+			scanned++
+
+			item := it.Item()
+			eventType, hour, err := decodeCountKey(item.Key())
+			if err != nil {
+				db.recordCorrupt(ctx)
+				continue
+			}
+			if hour < from || slices.Contains(q.ExcludeTypes, eventType) || !matchesTypePattern(q, eventType) {
+				continue
+			}
+			if hour > to {
+				if len(q.Types) > 0 {
+					break
+				}
+				continue
+			}
+
+			err = item.Value(func(val []byte) error {
+				fn(countKey{eventType, hour}, decodeCount(val))
+				return nil
+			})
+			if err != nil {
+				return &QueryError{Stage: StageFetch, Key: item.KeyCopy(nil), Err: err}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,329 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestHourlyCounts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	_, err = db.AppendBatch([]Event{
+		{Timestamp: day.Add(10 * time.Minute), Type: "request"},
+		{Timestamp: day.Add(20 * time.Minute), Type: "request"},
+		{Timestamp: day.Add(30 * time.Minute), Type: "error"},
+		{Timestamp: day.Add(90 * time.Minute), Type: "request"},
+		{Timestamp: day.Add(25 * time.Hour), Type: "request"},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	result, err := db.Append(Event{Timestamp: day.Add(95 * time.Minute), Type: "request"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Changing an event's type moves it between counts
+	changed := *result.Event
+	changed.Type = "error"
+	if _, err := db.Update(changed); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	counts, err := db.HourlyCounts(ctx, Query{})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	want := []PartitionCount{
+		{Start: day, Type: "error", Count: 1},
+		{Start: day, Type: "request", Count: 2},
+		{Start: day.Add(time.Hour), Type: "error", Count: 1},
+		{Start: day.Add(time.Hour), Type: "request", Count: 1},
+		{Start: day.Add(25 * time.Hour), Type: "request", Count: 1},
+	}
+	assertCounts(t, counts, want)
+
+	end := day.Add(2 * time.Hour)
+	counts, err = db.DailyCounts(ctx, Query{End: &end, Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("DailyCounts failed: %v", err)
+	}
+	assertCounts(t, counts, []PartitionCount{{Start: day, Type: "request", Count: 3}})
+
+	// Deletions are subtracted
+	if _, err := db.DeleteBefore(day.Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	counts, err = db.HourlyCounts(ctx, Query{})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	assertCounts(t, counts, want[2:])
+
+	if _, err := db.HourlyCounts(ctx, Query{Tags: map[string]string{"a": "b"}}); err == nil {
+		t.Error("expected error for tag filters")
+	}
+}
+
+func assertCounts(t *testing.T, got, want []PartitionCount) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d counts, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if !got[i].Start.Equal(want[i].Start) || got[i].Type != want[i].Type || got[i].Count != want[i].Count {
+			t.Errorf("count %d: expected %v, got %v", i, want[i], got[i])
+		}
+	}
+}
+
+func TestCountRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := day.Add(30 * 24 * time.Hour)
+	db, err := OpenWithOptions(dir, Options{
+		Now:            func() time.Time { return now },
+		CountRetention: 15 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	for _, offset := range []time.Duration{10 * time.Minute, 20 * 24 * time.Hour, 29 * 24 * time.Hour} {
+		if _, err := db.Append(Event{Timestamp: day.Add(offset), Type: "request"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Events are kept for a week, counts for 15 days
+	db.SetRetentionPolicy("events", RetentionPolicy{MaxAge: 7 * 24 * time.Hour})
+	ctx := context.Background()
+	if _, err := db.RunCleanupNow(ctx); err != nil {
+		t.Fatalf("RunCleanupNow failed: %v", err)
+	}
+
+	if n, err := db.Count(); err != nil || n != 1 {
+		t.Errorf("expected 1 event left, got %d, %v", n, err)
+	}
+	counts, err := db.HourlyCounts(ctx, Query{})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	want := []PartitionCount{
+		{Start: day.Add(20 * 24 * time.Hour), Type: "request", Count: 1},
+		{Start: day.Add(29 * 24 * time.Hour), Type: "request", Count: 1},
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("expected %d counts, got %+v", len(want), counts)
+	}
+	for i := range want {
+		if !counts[i].Start.Equal(want[i].Start) || counts[i].Count != want[i].Count {
+			t.Errorf("count %d: expected %+v, got %+v", i, want[i], counts[i])
+		}
+	}
+
+	// Queries over hours whose counts were pruned still find their events
+	kept := day.Add(10 * 24 * time.Hour)
+	db.RemoveRetentionPolicy("events")
+	if _, err := db.Append(Event{Timestamp: kept, Type: "audit"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := db.RunCleanupNow(ctx); err != nil {
+		t.Fatalf("RunCleanupNow failed: %v", err)
+	}
+	start := kept.Add(-time.Hour)
+	events, err := db.Query(ctx, Query{Types: []string{"audit"}, Start: &start})
+	if err != nil || len(events) != 1 {
+		t.Errorf("expected the event of a pruned hour, got %d, %v", len(events), err)
+	}
+
+	// Compaction leaves the counts of expired events alone
+	if _, err := db.CompactIndex(ctx); err != nil {
+		t.Fatalf("CompactIndex failed: %v", err)
+	}
+	if counts, _ := db.HourlyCounts(ctx, Query{}); len(counts) != 2 {
+		t.Errorf("expected the counts to survive compaction, got %+v", counts)
+	}
+}
+
+func TestHourlyCountsMerge(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	hour := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// Concurrent appends to the same hour must not conflict
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := db.Append(Event{Timestamp: hour.Add(time.Duration(i) * time.Second), Type: "request"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := countKeys(t, db, prefixCount); n != 200 {
+		t.Errorf("expected 200 delta keys before merging, got %d", n)
+	}
+	if err := db.counts.mergeDirty(db.badger); err != nil {
+		t.Fatalf("merge failed: %v", err)
+	}
+	if n := countKeys(t, db, prefixCount); n != 1 {
+		t.Errorf("expected 1 key after merging, got %d", n)
+	}
+
+	counts, err := db.HourlyCounts(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	assertCounts(t, counts, []PartitionCount{{Start: hour, Type: "request", Count: 200}})
+}
+
+// countKeys returns the number of keys with the given prefix.
+func countKeys(t *testing.T, db *DB, prefix string) int {
+	t.Helper()
+
+	var n int
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek([]byte(prefix)); it.ValidForPrefix([]byte(prefix)); it.Next() {
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestHourlyCountsRebuild(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	hour := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if _, err := db.Append(Event{Timestamp: hour, Type: "request"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Simulate a database written before counts were maintained
+	if err := db.badger.DropPrefix([]byte(prefixCount), metaCounts); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	counts, err := db.HourlyCounts(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	assertCounts(t, counts, []PartitionCount{{Start: hour, Type: "request", Count: 3}})
+}
+
+func TestPlanQueryUsesCounts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	hour := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if _, err := db.Append(Event{Timestamp: hour.Add(time.Duration(i) * time.Minute), Type: "request"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	plan := func(q Query) int {
+		t.Helper()
+		var n int
+		err := db.badger.View(func(txn *badger.Txn) error {
+			ids, useIndex, err := db.planQuery(context.Background(), txn, q)
+			if !useIndex {
+				t.Error("expected an index plan")
+			}
+			n = len(ids)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("planQuery failed: %v", err)
+		}
+		return n
+	}
+
+	start, later := hour, hour.Add(2*time.Hour)
+	if n := plan(Query{Types: []string{"request"}, Start: &start}); n != 5 {
+		t.Errorf("expected 5 candidates, got %d", n)
+	}
+	if n := plan(Query{Types: []string{"request"}, Start: &later}); n != 0 {
+		t.Errorf("expected no candidates after the last event, got %d", n)
+	}
+	if n := plan(Query{Types: []string{"request", "error"}, Start: &later}); n != 0 {
+		t.Errorf("expected no candidates for several types, got %d", n)
+	}
+}
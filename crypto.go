@@ -0,0 +1,67 @@
+package squid
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"hash"
+	"io"
+)
+
+// CryptoProvider supplies the cryptographic primitives Squid uses, so that
+// builds constrained to a particular validated module (FIPS 140,
+// BoringCrypto) can route them through it. Go's own crypto packages are
+// used by default; builds with GOEXPERIMENT=boringcrypto or GOFIPS140 route
+// those through the respective module without a provider.
+//
+// Encryption at rest is performed by BadgerDB with crypto/aes and cannot be
+// replaced by a provider. Package-level helpers that need no database, such
+// as VerifyExport and squidwebhook.Verify, also use Go's crypto packages.
+type CryptoProvider interface {
+	// SHA256 returns a new SHA-256 hash, which hashes deduplicated
+	// payloads, derived event IDs and export checksums. It must compute
+	// standard SHA-256: stored data depends on its output.
+	SHA256() hash.Hash
+
+	// HMACSHA256 returns a new HMAC-SHA256 keyed with key, which signs
+	// webhook payloads.
+	HMACSHA256(key []byte) hash.Hash
+
+	// Rand returns a source of cryptographically secure random bytes,
+	// which provides the entropy of event IDs. It must be safe for use by
+	// one goroutine at a time.
+	Rand() io.Reader
+}
+
+// stdCrypto is the CryptoProvider of Go's crypto packages.
+type stdCrypto struct{}
+
+func (stdCrypto) SHA256() hash.Hash { return sha256.New() }
+
+func (stdCrypto) HMACSHA256(key []byte) hash.Hash { return hmac.New(sha256.New, key) }
+
+func (stdCrypto) Rand() io.Reader { return rand.Reader }
+
+// crypto returns the crypto provider configured in the options.
+func (db *DB) crypto() CryptoProvider {
+	return cryptoOf(db.opts)
+}
+
+// cryptoOf returns the crypto provider configured in opts.
+func cryptoOf(opts Options) CryptoProvider {
+	if opts.Crypto != nil {
+		return opts.Crypto
+	}
+	return stdCrypto{}
+}
+
+// sha256Sum returns the SHA-256 of the concatenated parts computed by c.
+func sha256Sum(c CryptoProvider, parts ...[]byte) [sha256.Size]byte {
+	h := c.SHA256()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], h.Sum(nil))
+	return sum
+}
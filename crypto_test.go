@@ -0,0 +1,74 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"hash"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+)
+
+// countingCrypto counts the uses of Go's crypto primitives.
+type countingCrypto struct {
+	hashes, macs, reads atomic.Int64
+}
+
+func (c *countingCrypto) SHA256() hash.Hash {
+	c.hashes.Add(1)
+	return stdCrypto{}.SHA256()
+}
+
+func (c *countingCrypto) HMACSHA256(key []byte) hash.Hash {
+	c.macs.Add(1)
+	return stdCrypto{}.HMACSHA256(key)
+}
+
+func (c *countingCrypto) Rand() io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		c.reads.Add(1)
+		return stdCrypto{}.Rand().Read(p)
+	})
+}
+
+type readerFunc func([]byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+func TestCryptoProvider(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	provider := &countingCrypto{}
+	db, err := OpenWithOptions(dir, Options{Crypto: provider, DedupDataMinSize: 1})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Append(Event{Type: "report", Data: map[string]any{"body": "same"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if provider.reads.Load() == 0 {
+		t.Error("expected event IDs to draw entropy from the provider")
+	}
+	if provider.hashes.Load() != 1 {
+		t.Errorf("expected the payload to be hashed by the provider, got %d hashes", provider.hashes.Load())
+	}
+
+	var buf bytes.Buffer
+	file, err := db.ExportWithManifest(context.Background(), &buf, Query{}, JSON)
+	if err != nil {
+		t.Fatalf("ExportWithManifest failed: %v", err)
+	}
+	if provider.hashes.Load() != 2 {
+		t.Errorf("expected the export to be hashed by the provider, got %d hashes", provider.hashes.Load())
+	}
+	if err := VerifyExport(&buf, file); err != nil {
+		t.Errorf("expected the provider's checksum to verify, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package squid
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// dataRefMarker starts a data record that refers to a deduplicated payload
+// instead of holding the data itself. JSON never starts with it.
+const dataRefMarker = 0x00
+
+// dataRefLen is the length of a data record referring to a payload.
+const dataRefLen = 1 + sha256.Size
+
+// payloadHash identifies deduplicated data by the SHA-256 of its encoding.
+type payloadHash [sha256.Size]byte
+
+// writeData writes an event's encoded data. Data of at least
+// Options.DedupDataMinSize bytes is stored once per distinct content under
+// its hash, and the event's data record refers to it. It returns the number
+// of bytes written.
+//
+// Shared payloads are written unconditionally rather than checked for, so
+// appends never read keys that deletions write and cannot conflict with them.
+func (db *DB) writeData(txn *badger.Txn, id ulid.ULID, data []byte) (int, error) {
+	key := encodeDataKey(id)
+	if db.opts.DedupDataMinSize <= 0 || len(data) < db.opts.DedupDataMinSize {
+		if err := txn.Set(key, data); err != nil {
+			return 0, fmt.Errorf("failed to write data of event %s: %w", id, err)
+		}
+		return len(key) + len(data), nil
+	}
+
+	hash := payloadHash(sha256Sum(db.crypto(), data))
+	ref := append([]byte{dataRefMarker}, hash[:]...)
+	if err := txn.Set(key, ref); err != nil {
+		return 0, fmt.Errorf("failed to write data of event %s: %w", id, err)
+	}
+	payloadKey := encodePayloadKey(hash)
+	if err := txn.Set(payloadKey, data); err != nil {
+		return 0, fmt.Errorf("failed to write payload of event %s: %w", id, err)
+	}
+	refKey := encodePayloadRefKey(hash, id)
+	if err := txn.Set(refKey, nil); err != nil {
+		return 0, fmt.Errorf("failed to write payload reference of event %s: %w", id, err)
+	}
+	return len(key) + len(ref) + len(payloadKey) + len(data) + len(refKey), nil
+}
+
+// readData calls fn with the encoded data of an event, resolving references
+// to deduplicated payloads; fn must not fail. It returns false without
+// calling fn if the event has no data. Read failures are returned as a
+// *QueryError with StageFetch, and references to missing payloads as a
+// *QueryError with StageDecode wrapping ErrCorruptRecord.
+func (db *DB) readData(txn *badger.Txn, id ulid.ULID, fn func(data []byte) error) (bool, error) {
+	key := encodeDataKey(id)
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, &QueryError{Stage: StageFetch, Key: key, Err: err}
+	}
+
+	var hash payloadHash
+	var isRef bool
+	err = item.Value(func(val []byte) error {
+		if len(val) == dataRefLen && val[0] == dataRefMarker {
+			copy(hash[:], val[1:])
+			isRef = true
+			return nil
+		}
+		return fn(val)
+	})
+	if err != nil {
+		return false, &QueryError{Stage: StageFetch, Key: key, Err: err}
+	}
+	if !isRef {
+		return true, nil
+	}
+
+	payloadKey := encodePayloadKey(hash)
+	item, err = txn.Get(payloadKey)
+	if err == badger.ErrKeyNotFound {
+		return false, &QueryError{Stage: StageDecode, Key: key, Err: fmt.Errorf("%w: missing payload %x", ErrCorruptRecord, hash)}
+	}
+	if err != nil {
+		return false, &QueryError{Stage: StageFetch, Key: payloadKey, Err: err}
+	}
+	if err := item.Value(fn); err != nil {
+		return false, &QueryError{Stage: StageFetch, Key: payloadKey, Err: err}
+	}
+	return true, nil
+}
+
+// deleteData removes an event's data record. If it refers to a deduplicated
+// payload, the reference is removed too, and so is the payload once no other
+// event refers to it.
+//
+// The payload is read before it is deleted, so a concurrent append of the
+// same content makes the deletion fail with badger.ErrConflict rather than
+// leaving the new event without its payload.
+func (db *DB) deleteData(txn *badger.Txn, id ulid.ULID) error {
+	key := encodeDataKey(id)
+	item, err := txn.Get(key)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var hash payloadHash
+	var isRef bool
+	err = item.Value(func(val []byte) error {
+		if len(val) == dataRefLen && val[0] == dataRefMarker {
+			copy(hash[:], val[1:])
+			isRef = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := txn.Delete(key); err != nil {
+		return err
+	}
+	if !isRef {
+		return nil
+	}
+
+	if err := txn.Delete(encodePayloadRefKey(hash, id)); err != nil {
+		return err
+	}
+
+	// Iteration sees this transaction's own deletions
+	it := txn.NewIterator(badger.IteratorOptions{Prefix: encodePayloadRefPrefix(hash)})
+	it.Rewind()
+	referenced := it.Valid()
+	it.Close()
+	if referenced {
+		return nil
+	}
+
+	payloadKey := encodePayloadKey(hash)
+	if _, err := txn.Get(payloadKey); err != nil && err != badger.ErrKeyNotFound {
+		return err
+	}
+	return txn.Delete(payloadKey)
+}
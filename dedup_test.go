@@ -0,0 +1,112 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestDedupData(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{DedupDataMinSize: 16})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	shared := map[string]any{"body": "the same large payload"}
+	base := time.Now().Add(-time.Hour)
+	var ids []ulid.ULID
+	for i := 0; i < 50; i++ {
+		event := Event{Timestamp: base.Add(time.Duration(i) * time.Second), Type: "request", Data: shared}
+		if i%10 == 0 {
+			event.Data = map[string]any{"small": i} // below the threshold
+		}
+		result, err := db.Append(event)
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		ids = append(ids, result.ID)
+	}
+
+	if n := countKeys(t, db, prefixBlob); n != 1 {
+		t.Errorf("expected 1 shared payload, got %d", n)
+	}
+	if n := countKeys(t, db, prefixRef); n != 45 {
+		t.Errorf("expected 45 payload references, got %d", n)
+	}
+
+	got, err := db.Get(ids[1])
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Data["body"] != shared["body"] {
+		t.Errorf("unexpected data: %v", got.Data)
+	}
+
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	for i, e := range events {
+		if i%10 != 0 && e.Data["body"] != shared["body"] {
+			t.Errorf("event %d: unexpected data %v", i, e.Data)
+		}
+	}
+
+	err = db.QueryRaw(ctx, Query{Limit: 2}, func(id ulid.ULID, value []byte) error {
+		e, err := ParseEvent(value)
+		if err != nil {
+			return err
+		}
+		if id == ids[1] && e.Data["body"] != shared["body"] {
+			t.Errorf("unexpected raw data: %s", value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("QueryRaw failed: %v", err)
+	}
+
+	// Replacing the data of one event leaves the others' payload alone
+	if _, err := db.Update(Event{ID: ids[1], Version: 1, Type: "request", Data: map[string]any{"body": "a different large payload"}}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if n := countKeys(t, db, prefixBlob); n != 2 {
+		t.Errorf("expected 2 payloads after Update, got %d", n)
+	}
+
+	// The shared payload outlives partial deletions
+	if _, err := db.DeleteBefore(base.Add(25 * time.Second)); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	if n := countKeys(t, db, prefixBlob); n != 1 {
+		t.Errorf("expected 1 payload after partial delete, got %d", n)
+	}
+	got, err = db.Get(ids[49])
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Data["body"] != shared["body"] {
+		t.Errorf("unexpected data after partial delete: %v", got.Data)
+	}
+
+	// and is removed with its last reference
+	if _, err := db.DeleteBefore(time.Now()); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	for _, prefix := range []string{prefixBlob, prefixRef, prefixData} {
+		if n := countKeys(t, db, prefix); n != 0 {
+			t.Errorf("expected no keys with prefix %q, got %d", prefix, n)
+		}
+	}
+}
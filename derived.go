@@ -0,0 +1,265 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// DerivedStream materializes a transformation of the events matching a
+// source query as events of a new type, which can be queried, aggregated
+// and subscribed to like any other. Derived events are written in the
+// transaction that appends their source event, so a source event is never
+// stored without them.
+//
+// A derived event has the source event's timestamp and tags, the Type of
+// the stream and the source data restricted to Fields. Events of derived
+// types are never derived from, and later updates or deletes of source
+// events do not change the events derived from them.
+type DerivedStream struct {
+	// Name identifies the stream. Together with the ID of a source event
+	// it determines the ID of the derived event, so deriving from the same
+	// event twice stores it once. Derived IDs keep the millisecond of their
+	// source, but derived events appended within the same millisecond may
+	// be ordered differently from their sources.
+	Name string
+
+	// Source selects the events to derive from by type, tags, time, ID,
+	// ingest range and data. Limit and ordering are ignored.
+	Source Query
+
+	// Where, if set, further selects source events, e.g. by their data.
+	// It must not modify the event.
+	Where func(*Event) bool
+
+	// Type is the type of the derived events. It must not be a type of
+	// the Source query.
+	Type string
+
+	// Fields lists the data fields copied from source events (nil copies
+	// all of them).
+	Fields []string
+}
+
+// checkDerivedStreams validates the derived streams of Options.
+func checkDerivedStreams(streams []DerivedStream) error {
+	names := make(map[string]bool)
+	for _, s := range streams {
+		if s.Name == "" {
+			return fmt.Errorf("squid: derived stream of type %q has no name", s.Type)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("squid: derived stream %q is defined twice", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.Type == "" {
+			return fmt.Errorf("squid: derived stream %q: %w", s.Name, ErrEmptyType)
+		}
+		if err := validateKeyComponent("type", s.Type); err != nil {
+			return fmt.Errorf("squid: derived stream %q: %w", s.Name, err)
+		}
+		for _, t := range s.Source.Types {
+			if t == s.Type {
+				return fmt.Errorf("squid: derived stream %q derives type %q from itself", s.Name, s.Type)
+			}
+		}
+		if err := checkTypePattern(s.Source); err != nil {
+			return fmt.Errorf("squid: derived stream %q: %w", s.Name, err)
+		}
+		if err := checkWhere(s.Source); err != nil {
+			return fmt.Errorf("squid: derived stream %q: %w", s.Name, err)
+		}
+		if err := checkSearch(s.Source); err != nil {
+			return fmt.Errorf("squid: derived stream %q: %w", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// derivedID returns the ID of the event derived by the named stream from
+// the event with ID source: its timestamp with entropy taken from a hash of
+// the name and source ID.
+func (db *DB) derivedID(name string, source ulid.ULID) ulid.ULID {
+	sum := sha256Sum(db.crypto(), []byte(name), source[:])
+
+	id := source
+	copy(id[6:], sum[:])
+	return id
+}
+
+// isDerivedType reports whether events of type typ are derived by a stream.
+func (db *DB) isDerivedType(typ string) bool {
+	for _, s := range db.opts.DerivedStreams {
+		if s.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDerived writes the events derived from a source event written in
+// txn and records them in deltas. rawData is the encoded data of a source
+// event appended as JSON, or nil. It returns the derived events written.
+func (db *DB) writeDerived(txn *badger.Txn, source *Event, rawData []byte, deltas countDeltas) ([]Event, error) {
+	var derived []Event
+	for i := range db.opts.DerivedStreams {
+		e, err := db.deriveOne(txn, &db.opts.DerivedStreams[i], source, &rawData, deltas)
+		if err != nil {
+			return nil, err
+		}
+		if e != nil {
+			derived = append(derived, *e)
+		}
+	}
+	return derived, nil
+}
+
+// deriveOne writes the event derived by stream s from a source event, if
+// it matches and the event is not stored yet. rawData is decoded into the
+// source event the first time its data is needed.
+func (db *DB) deriveOne(txn *badger.Txn, s *DerivedStream, source *Event, rawData *[]byte, deltas countDeltas) (*Event, error) {
+	if db.isDerivedType(source.Type) {
+		return nil, nil
+	}
+	if !db.matchesTimeRange(source.ID, s.Source) || !db.matchesFilters(source, s.Source) {
+		return nil, nil
+	}
+
+	if *rawData != nil {
+		var data map[string]any
+		if err := db.codec().Unmarshal(*rawData, &data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+		}
+		decoded := *source
+		decoded.Data = data
+		source = &decoded
+		*rawData = nil
+	}
+	if !matchesData(source, s.Source) || s.Where != nil && !s.Where(source) {
+		return nil, nil
+	}
+
+	e := &Event{
+		ID:         db.derivedID(s.Name, source.ID),
+		Timestamp:  source.Timestamp,
+		Type:       s.Type,
+		Tags:       maps.Clone(source.Tags),
+		Version:    1,
+		IngestedAt: source.IngestedAt,
+	}
+	if s.Fields == nil {
+		e.Data = maps.Clone(source.Data)
+	} else {
+		for _, f := range s.Fields {
+			if v, ok := source.Data[f]; ok {
+				if e.Data == nil {
+					e.Data = make(map[string]any, len(s.Fields))
+				}
+				e.Data[f] = v
+			}
+		}
+	}
+
+	if _, err := txn.Get(encodeEventKey(e.ID)); err == nil {
+		return nil, nil // Already derived
+	} else if err != badger.ErrKeyNotFound {
+		return nil, err
+	}
+
+	meta, data, err := db.encodeEvent(e)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := db.writeEvent(txn, e, meta, data); err != nil {
+		return nil, err
+	}
+	deltas.add(e.Type, e.ID, 1)
+	return e, nil
+}
+
+// deriveBatch is the number of source events DeriveExisting derives from
+// per transaction.
+const deriveBatch = 1000
+
+// DeriveExisting derives the events of the named stream from matching
+// events stored before the stream was defined, and returns the number of
+// events derived. Source events that already have a derived event are
+// skipped, so it can be run again, e.g. after an interruption.
+func (db *DB) DeriveExisting(ctx context.Context, name string) (int64, error) {
+	var s *DerivedStream
+	for i := range db.opts.DerivedStreams {
+		if db.opts.DerivedStreams[i].Name == name {
+			s = &db.opts.DerivedStreams[i]
+		}
+	}
+	if s == nil {
+		return 0, fmt.Errorf("%w: unknown derived stream %q", ErrInvalidQuery, name)
+	}
+
+	var total int64
+	q := s.Source
+	q.Limit, q.Descending, q.OrderByIngest = deriveBatch, false, false
+	for {
+		sources, err := db.Query(ctx, q)
+		if err != nil {
+			return total, err
+		}
+		if len(sources) == 0 {
+			return total, nil
+		}
+
+		var derived []Event
+		err = db.badger.Update(func(txn *badger.Txn) error {
+			derived = derived[:0]
+			deltas := make(countDeltas)
+			for _, source := range sources {
+				var raw []byte
+				e, err := db.deriveOne(txn, s, source, &raw, deltas)
+				if err != nil {
+					return err
+				}
+				if e != nil {
+					derived = append(derived, *e)
+				}
+			}
+			return db.counts.write(txn, deltas)
+		})
+		if err != nil {
+			return total, err
+		}
+		db.appended(derived, nil)
+		total += int64(len(derived))
+
+		if len(sources) < deriveBatch {
+			return total, nil
+		}
+		next := nextID(sources[len(sources)-1].ID)
+		q.MinID = &next
+	}
+}
+
+// nextID returns the ID following id.
+func nextID(id ulid.ULID) ulid.ULID {
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i]++
+		if id[i] != 0 {
+			break
+		}
+	}
+	return id
+}
+
+// prevID returns the ID preceding id.
+func prevID(id ulid.ULID) ulid.ULID {
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i]--
+		if id[i] != 0xFF {
+			break
+		}
+	}
+	return id
+}
@@ -0,0 +1,121 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+func TestDerivedStreams(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	slow := DerivedStream{
+		Name:   "slow-requests",
+		Source: Query{Types: []string{"request"}, Tags: map[string]string{"env": "prod"}},
+		Where: func(e *Event) bool {
+			ms, ok := e.Data["duration_ms"].(float64)
+			return ok && ms >= 500
+		},
+		Type:   "slow_request",
+		Fields: []string{"duration_ms", "path"},
+	}
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	// Stored before the stream is defined
+	if _, err := db.Append(Event{Type: "request", Tags: map[string]string{"env": "prod"}, Data: map[string]any{"duration_ms": 900.0, "path": "/old"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	db.Close()
+
+	if _, err := OpenWithOptions(dir, Options{DerivedStreams: []DerivedStream{{Name: "loop", Source: Query{Types: []string{"a"}}, Type: "a"}}}); err == nil {
+		t.Error("expected a stream deriving its own source type to be rejected")
+	}
+
+	db, err = OpenWithOptions(dir, Options{DerivedStreams: []DerivedStream{slow}})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	sub, err := db.Subscribe(ctx, SubscribeOptions{Query: Query{Types: []string{"slow_request"}}})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	source, err := db.Append(Event{Type: "request", Tags: map[string]string{"env": "prod"}, Data: map[string]any{"duration_ms": 750.0, "path": "/a", "user": "u1"}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	_, err = db.AppendBatch([]Event{
+		{Type: "request", Tags: map[string]string{"env": "prod"}, Data: map[string]any{"duration_ms": 20.0}},
+		{Type: "request", Tags: map[string]string{"env": "dev"}, Data: map[string]any{"duration_ms": 800.0}},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	if _, err := db.AppendJSON(ctx, []byte(`{"type":"request","tags":{"env":"prod"},"data":{"duration_ms":600,"path":"/json"}}`)); err != nil {
+		t.Fatalf("AppendJSON failed: %v", err)
+	}
+
+	events, err := db.Query(ctx, Query{Types: []string{"slow_request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 derived events, got %+v", events)
+	}
+	paths := make(map[any]*Event)
+	for _, e := range events {
+		paths[e.Data["path"]] = e
+	}
+	derived := paths["/a"]
+	if derived == nil || paths["/json"] == nil {
+		t.Fatalf("expected events derived from /a and /json, got %+v", events)
+	}
+	if !derived.Timestamp.Equal(source.Timestamp) || derived.Tags["env"] != "prod" || derived.Data["user"] != nil {
+		t.Errorf("unexpected derived event %+v", derived)
+	}
+	if derived.ID != db.derivedID(slow.Name, source.ID) {
+		t.Errorf("expected the derived ID of the source, got %s", derived.ID)
+	}
+
+	// Derived events are published like appended ones
+	for _, batch := range receive(t, sub, 2) {
+		for _, e := range batch {
+			if e.Type != "slow_request" {
+				t.Errorf("expected a slow_request to be published, got %s", e.Type)
+			}
+		}
+	}
+
+	counts, err := db.HourlyCounts(ctx, Query{Types: []string{"slow_request"}})
+	if err != nil || len(counts) != 1 || counts[0].Count != 2 {
+		t.Errorf("expected a count of 2, got %+v, %v", counts, err)
+	}
+
+	// Backfilling derives from the old event only, and only once
+	for _, want := range []int64{1, 0} {
+		n, err := db.DeriveExisting(ctx, slow.Name)
+		if err != nil {
+			t.Fatalf("DeriveExisting failed: %v", err)
+		}
+		if n != want {
+			t.Errorf("expected %d events derived, got %d", want, n)
+		}
+	}
+	if n, err := db.Count(); err != nil || n != 8 {
+		t.Errorf("expected 8 events, got %d, %v", n, err)
+	}
+	if _, err := db.DeriveExisting(ctx, "missing"); err == nil {
+		t.Error("expected an unknown stream to be rejected")
+	}
+}
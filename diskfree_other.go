@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package squid
+
+import "errors"
+
+// diskFree is not implemented on this platform; the disk watchdog is disabled.
+func diskFree(path string) (uint64, error) {
+	return 0, errors.New("squid: free disk space is not available on this platform")
+}
@@ -0,0 +1,47 @@
+package squid
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// FutureTimestampAction defines how events timestamped beyond
+// Options.MaxFutureDrift are handled.
+type FutureTimestampAction int
+
+const (
+	// RejectTimestamp fails the append with ErrFutureTimestamp.
+	RejectTimestamp FutureTimestampAction = iota
+	// ClampTimestamp stores the event with its timestamp set to the current time.
+	ClampTimestamp
+)
+
+// driftCounters counts events affected by Options.MaxFutureDrift.
+type driftCounters struct {
+	rejected int64
+	clamped  int64
+}
+
+// checkFutureDrift applies Options.MaxFutureDrift to the event's timestamp.
+// It reports whether the timestamp was clamped to now.
+func (db *DB) checkFutureDrift(event *Event, now time.Time) (bool, error) {
+	if db.opts.MaxFutureDrift <= 0 || event.Timestamp.IsZero() {
+		return false, nil
+	}
+
+	limit := now.Add(db.opts.MaxFutureDrift)
+	if !event.Timestamp.After(limit) {
+		return false, nil
+	}
+
+	if db.opts.FutureTimestampAction == ClampTimestamp {
+		atomic.AddInt64(&db.drift.clamped, 1)
+		event.Timestamp = now
+		return true, nil
+	}
+
+	atomic.AddInt64(&db.drift.rejected, 1)
+	return false, fmt.Errorf("%w: %s is more than %s ahead of %s", ErrFutureTimestamp,
+		event.Timestamp.Format(time.RFC3339Nano), db.opts.MaxFutureDrift, now.Format(time.RFC3339Nano))
+}
@@ -0,0 +1,97 @@
+package squid
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMaxFutureDriftReject(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	db, err := OpenWithOptions(dir, Options{
+		MaxFutureDrift: time.Minute,
+		Now:            func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Within the allowed drift
+	if _, err := db.Append(Event{Timestamp: now.Add(time.Minute), Type: "event"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	_, err = db.Append(Event{Timestamp: now.Add(time.Hour), Type: "event"})
+	if !errors.Is(err, ErrFutureTimestamp) {
+		t.Errorf("expected ErrFutureTimestamp, got %v", err)
+	}
+
+	// A single bad event fails the whole batch
+	_, err = db.AppendBatch([]Event{
+		{Timestamp: now, Type: "event"},
+		{Timestamp: now.Add(24 * time.Hour), Type: "event"},
+	})
+	if !errors.Is(err, ErrFutureTimestamp) {
+		t.Errorf("expected ErrFutureTimestamp, got %v", err)
+	}
+
+	count, _ := db.Count()
+	if count != 1 {
+		t.Errorf("expected 1 event, got %d", count)
+	}
+	if n := db.Stats().FutureTimestampsRejected; n != 2 {
+		t.Errorf("expected 2 rejections, got %d", n)
+	}
+}
+
+func TestMaxFutureDriftClamp(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	db, err := OpenWithOptions(dir, Options{
+		MaxFutureDrift:        time.Minute,
+		FutureTimestampAction: ClampTimestamp,
+		Now:                   func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	result, err := db.Append(Event{Timestamp: now.Add(time.Hour), Type: "event"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if !result.TimestampClamped || !result.Timestamp.Equal(now) {
+		t.Errorf("expected timestamp clamped to %v, got %v (clamped=%v)", now, result.Timestamp, result.TimestampClamped)
+	}
+	if !ulidTime(result.ID).Equal(now) {
+		t.Errorf("expected ID to use the clamped timestamp, got %v", ulidTime(result.ID))
+	}
+
+	results, err := db.AppendBatch([]Event{
+		{Timestamp: now.Add(-time.Hour), Type: "event"},
+		{Timestamp: now.Add(time.Hour), Type: "event"},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	if results[0].TimestampClamped || !results[1].TimestampClamped {
+		t.Error("only the future event should be clamped")
+	}
+	if n := db.Stats().FutureTimestampsClamped; n != 2 {
+		t.Errorf("expected 2 clamped, got %d", n)
+	}
+}
@@ -0,0 +1,392 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Defaults for Encryption.
+const (
+	defaultDataKeyRotation = 10 * 24 * time.Hour
+
+	// encryptionIndexCacheSize is the size in bytes of the table index
+	// cache, which BadgerDB requires for encrypted tables.
+	encryptionIndexCacheSize = 64 << 20
+
+	// reencryptMaxBatch is the largest number of records rewritten in one
+	// transaction.
+	reencryptMaxBatch = 1000
+)
+
+// metaReencrypt stores the progress of re-encryption across restarts.
+var metaReencrypt = encodeMetaKey("reencrypt")
+
+// metaFlush is written and dropped to flush BadgerDB's memtables.
+var metaFlush = encodeMetaKey("flush")
+
+// Encryption configures encryption at rest. Stored data is encrypted with
+// AES using data keys that are replaced every DataKeyRotation; the data
+// keys are stored encrypted with the master Key. Files written by
+// Options.Mirror are not encrypted.
+type Encryption struct {
+	// Key is the master key: 16, 24 or 32 bytes for AES-128, AES-192 or
+	// AES-256. The database must be opened with the key it was created or
+	// last rotated with; change it with RotateEncryptionKey.
+	Key []byte
+
+	// DataKeyRotation is how often a new data key is generated for data
+	// written from then on. Defaults to 10 days.
+	DataKeyRotation time.Duration
+
+	// ReencryptRate is the number of stored records per second the
+	// background re-encryption rewrites (0 disables it). Data stays
+	// encrypted with the data key it was written with until compaction
+	// rewrites it, which may never happen to old data; re-encryption
+	// rewrites every record once per DataKeyRotation, so that it is
+	// encrypted with a recent key. An Update of an event while its record
+	// is rewritten may fail with ErrVersionConflict, as it would for a
+	// concurrent Update.
+	//
+	// Once a pass has rewritten every record, the files holding the records
+	// it replaced are compacted and garbage collected, so that no data on
+	// disk is left encrypted with an older data key. Writes fail with
+	// badger.ErrBlockedWrites for the moment this flushes BadgerDB's
+	// memtables.
+	ReencryptRate int
+}
+
+// checkEncryption validates the encryption options.
+func checkEncryption(e *Encryption) error {
+	if e == nil {
+		return nil
+	}
+	switch len(e.Key) {
+	case 16, 24, 32:
+	default:
+		return fmt.Errorf("%w: key of %d bytes, expected 16, 24 or 32", ErrInvalidEncryptionKey, len(e.Key))
+	}
+	if e.DataKeyRotation < 0 || e.ReencryptRate < 0 {
+		return fmt.Errorf("%w: DataKeyRotation and ReencryptRate must not be negative", ErrInvalidEncryptionKey)
+	}
+	return nil
+}
+
+// dataKeyRotation returns the configured data key rotation interval.
+func (e *Encryption) dataKeyRotation() time.Duration {
+	if e.DataKeyRotation > 0 {
+		return e.DataKeyRotation
+	}
+	return defaultDataKeyRotation
+}
+
+// RotateEncryptionKey replaces the master key of the closed database at
+// path. Only the data keys are encrypted with the master key, so rotation
+// does not rewrite stored data; a new data key is generated for data
+// written after the database is next opened with newKey. To stop relying on
+// the data keys readable with oldKey, re-encrypt the stored data with
+// Encryption.ReencryptRate.
+func RotateEncryptionKey(path string, oldKey, newKey []byte) error {
+	if err := checkEncryption(&Encryption{Key: newKey}); err != nil {
+		return err
+	}
+
+	opts := badger.KeyRegistryOptions{Dir: path, ReadOnly: true, EncryptionKey: oldKey}
+	registry, err := badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return encryptionError(err)
+	}
+	if err := registry.Close(); err != nil {
+		return err
+	}
+
+	opts.EncryptionKey = newKey
+	if err := badger.WriteKeyRegistry(registry, opts); err != nil {
+		return fmt.Errorf("failed to write key registry: %w", err)
+	}
+
+	// A rotation interval of zero makes the registry generate a data key
+	// that was never readable with the old master key
+	opts.ReadOnly = false
+	registry, err = badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return encryptionError(err)
+	}
+	if _, err := registry.LatestDataKey(); err != nil {
+		registry.Close()
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return registry.Close()
+}
+
+// encryptionError wraps the errors BadgerDB returns for wrong or invalid
+// encryption keys in ErrInvalidEncryptionKey.
+func encryptionError(err error) error {
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) || errors.Is(err, badger.ErrInvalidEncryptionKey) {
+		return fmt.Errorf("%w: %v", ErrInvalidEncryptionKey, err)
+	}
+	return err
+}
+
+// reencryptState is the progress of re-encryption, stored under
+// metaReencrypt.
+type reencryptState struct {
+	// Started is when the current or last pass started.
+	Started time.Time `json:"started"`
+
+	// Completed is when the last pass completed (zero if none has).
+	Completed time.Time `json:"completed"`
+
+	// Cursor is the last key rewritten by the current pass (nil when no
+	// pass is running).
+	Cursor []byte `json:"cursor,omitempty"`
+
+	// Rewritten is the number of records the current pass has rewritten,
+	// and Total the number stored when it started.
+	Rewritten int64 `json:"rewritten"`
+	Total     int64 `json:"total"`
+
+	Running bool `json:"running"`
+}
+
+// reencryptor rewrites stored records in the background so that they are
+// encrypted with the current data key.
+type reencryptor struct {
+	rate     int
+	rotation time.Duration
+
+	// state is only changed by the reencryptor's goroutine, holding mu
+	mu    sync.Mutex
+	state reencryptState
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newReencryptor returns a reencryptor for the given options, or nil if
+// re-encryption is disabled.
+func newReencryptor(opts Options) *reencryptor {
+	if opts.Encryption == nil || opts.Encryption.ReencryptRate <= 0 {
+		return nil
+	}
+	return &reencryptor{
+		rate:     opts.Encryption.ReencryptRate,
+		rotation: opts.Encryption.dataKeyRotation(),
+	}
+}
+
+// batch returns the number of records rewritten per step and the time
+// between steps that keep to the configured rate.
+func (r *reencryptor) batch() (int, time.Duration) {
+	n := min(r.rate, reencryptMaxBatch)
+	return n, time.Duration(n) * time.Second / time.Duration(r.rate)
+}
+
+// load reads the stored progress.
+func (r *reencryptor) load(bdb *badger.DB) error {
+	return bdb.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(metaReencrypt)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if err := json.Unmarshal(val, &r.state); err != nil {
+				return fmt.Errorf("failed to read re-encryption progress: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// start rewrites records in a background goroutine.
+func (r *reencryptor) start(db *DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.done = make(chan struct{})
+
+	_, interval := r.batch()
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.step(db); err != nil && !errors.Is(err, badger.ErrConflict) && db.opts.Logger != nil {
+					db.opts.Logger.Errorf("squid: re-encryption failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// stop ends re-encryption and waits for the goroutine to exit. Progress
+// is kept, and the pass resumes when the database is next opened.
+func (r *reencryptor) stop() {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+}
+
+// snapshot returns the current progress.
+func (r *reencryptor) snapshot() reencryptState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// step starts a pass if one is due and rewrites the next batch of
+// records of the running pass. A batch that conflicts with a concurrent
+// write is retried by the next step.
+func (r *reencryptor) step(db *DB) error {
+	state := r.state
+	if !state.Running {
+		now := db.now()
+		if !state.Started.IsZero() && now.Sub(state.Started) < r.rotation {
+			return nil
+		}
+		total, err := countRecords(db.badger)
+		if err != nil {
+			return err
+		}
+		state = reencryptState{Started: now, Completed: state.Completed, Total: total, Running: true}
+	}
+
+	n, _ := r.batch()
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		keys, values, more, err := nextRecords(txn, state.Cursor, n)
+		if err != nil {
+			return err
+		}
+
+		for i, key := range keys {
+			err := txn.Set(key, values[i])
+			if errors.Is(err, badger.ErrTxnTooBig) && i > 0 {
+				// Leave the rest to the next step
+				keys, more = keys[:i], true
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to rewrite %q: %w", key, err)
+			}
+		}
+
+		state.Rewritten += int64(len(keys))
+		if len(keys) > 0 {
+			state.Cursor = keys[len(keys)-1]
+		}
+		if !more {
+			state.Cursor, state.Running = nil, false
+			state.Completed = db.now()
+		}
+		val, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return txn.Set(metaReencrypt, val)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The pass only counts as completed once the records it replaced are
+	// gone from disk
+	if !state.Running {
+		err = reclaimReplaced(db.badger)
+	}
+	r.mu.Lock()
+	r.state = state
+	r.mu.Unlock()
+	return err
+}
+
+// reclaimReplaced removes the records replaced by a re-encryption pass from
+// disk, where they stay readable with the data keys they were written with:
+// it flushes the memtables, compacts the tables into one level, which
+// rewrites every table with the current data key, and garbage collects the
+// value log files.
+func reclaimReplaced(bdb *badger.DB) error {
+	// BadgerDB only flushes memtables when they are full or a prefix is
+	// dropped
+	if err := bdb.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaFlush, nil)
+	}); err != nil {
+		return err
+	}
+	if err := bdb.DropPrefix(metaFlush); err != nil {
+		return fmt.Errorf("failed to flush memtables: %w", err)
+	}
+
+	if err := bdb.Flatten(1); err != nil {
+		return fmt.Errorf("failed to compact tables: %w", err)
+	}
+	for {
+		err := bdb.RunValueLogGC(0.5)
+		if errors.Is(err, badger.ErrNoRewrite) || errors.Is(err, badger.ErrRejected) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to collect value log files: %w", err)
+		}
+	}
+}
+
+// nextRecords returns up to n stored records following the cursor, and
+// whether more follow them.
+func nextRecords(txn *badger.Txn, cursor []byte, n int) (keys, values [][]byte, more bool, err error) {
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(cursor); it.Valid(); it.Next() {
+		item := it.Item()
+		if cursor != nil && bytes.Equal(item.Key(), cursor) {
+			continue
+		}
+		if bytes.Equal(item.Key(), metaReencrypt) {
+			continue
+		}
+		if len(keys) == n {
+			return keys, values, true, nil
+		}
+
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		keys = append(keys, item.KeyCopy(nil))
+		values = append(values, value)
+	}
+	return keys, values, false, nil
+}
+
+// countRecords returns the number of stored records.
+func countRecords(bdb *badger.DB) (int64, error) {
+	var n int64
+	err := bdb.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Rewind(); it.Valid(); it.Next() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,142 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestEncryption(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 16)
+
+	if _, err := OpenWithOptions(dir, Options{Encryption: &Encryption{Key: []byte("short")}}); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Fatalf("expected ErrInvalidEncryptionKey for a short key, got %v", err)
+	}
+
+	db, err := OpenWithOptions(dir, Options{Encryption: &Encryption{Key: oldKey}})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := db.Append(Event{Type: "secret", Data: map[string]any{"i": float64(i)}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	oldDataKeys := dataKeyIDs(t, dir)
+	if len(oldDataKeys) == 0 {
+		t.Fatal("expected files encrypted with a data key")
+	}
+
+	if _, err := OpenWithOptions(dir, Options{Encryption: &Encryption{Key: newKey}}); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Fatalf("expected ErrInvalidEncryptionKey for the wrong key, got %v", err)
+	}
+	if err := RotateEncryptionKey(dir, newKey, oldKey); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Fatalf("expected ErrInvalidEncryptionKey rotating from the wrong key, got %v", err)
+	}
+	if err := RotateEncryptionKey(dir, oldKey, newKey); err != nil {
+		t.Fatalf("RotateEncryptionKey failed: %v", err)
+	}
+	if _, err := OpenWithOptions(dir, Options{Encryption: &Encryption{Key: oldKey}}); !errors.Is(err, ErrInvalidEncryptionKey) {
+		t.Fatalf("expected ErrInvalidEncryptionKey for the rotated key, got %v", err)
+	}
+
+	db, err = OpenWithOptions(dir, Options{Encryption: &Encryption{Key: newKey, ReencryptRate: 100_000}})
+	if err != nil {
+		t.Fatalf("Open with the new key failed: %v", err)
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			db.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for db.Stats().LastReencryption.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("re-encryption did not complete")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := db.Stats()
+	if stats.Reencrypting || stats.ReencryptTotal == 0 || stats.ReencryptedRecords < stats.ReencryptTotal {
+		t.Errorf("unexpected re-encryption progress %+v", stats)
+	}
+
+	events, err := db.Query(context.Background(), Query{Types: []string{"secret"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 10 || events[9].Data["i"] != 9.0 {
+		t.Errorf("expected the 10 events to survive rotation, got %d", len(events))
+	}
+
+	// Nothing on disk is left encrypted with the data keys of the old master key
+	closed = true
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for id, file := range dataKeyIDs(t, dir) {
+		if oldDataKeys[id] != "" {
+			t.Errorf("%s is still encrypted with old data key %d", file, id)
+		}
+	}
+}
+
+// dataKeyIDs returns the IDs of the data keys the tables and log files of
+// the BadgerDB directory are encrypted with, and a file of each.
+func dataKeyIDs(t *testing.T, dir string) map[uint64]string {
+	t.Helper()
+
+	ids := make(map[uint64]string)
+	f, err := os.Open(filepath.Join(dir, badger.ManifestFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	manifest, _, err := badger.ReplayManifestFile(f, 0, badger.DefaultOptions(dir))
+	if err != nil {
+		t.Fatalf("ReplayManifestFile failed: %v", err)
+	}
+	for fid, table := range manifest.Tables {
+		ids[table.KeyID] = fmt.Sprintf("table %d", fid)
+	}
+
+	// Log files start with the ID of their data key and an IV; those with
+	// nothing after them hold no data
+	for _, pattern := range []string{"*.vlog", "*.mem"} {
+		files, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) > 20 {
+				ids[binary.BigEndian.Uint64(data)] = filepath.Base(file)
+			}
+		}
+	}
+	delete(ids, 0)
+	return ids
+}
@@ -1,6 +1,9 @@
 package squid
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrClosed is returned when operating on a closed database.
@@ -12,9 +15,161 @@ var (
 	// ErrEmptyType is returned when an event has an empty type.
 	ErrEmptyType = errors.New("squid: event type cannot be empty")
 
+	// ErrKeyTooLong is returned when an event type or tag does not fit in an index key.
+	ErrKeyTooLong = errors.New("squid: event type or tag exceeds maximum key length")
+
+	// ErrInvalidTag is returned when an event type or tag cannot be indexed safely.
+	ErrInvalidTag = errors.New("squid: invalid event type or tag")
+
+	// ErrCardinalityLimit is returned when a tag would exceed a configured cardinality limit.
+	ErrCardinalityLimit = errors.New("squid: tag cardinality limit exceeded")
+
+	// ErrInvalidKey is returned when a stored key cannot be decoded.
+	ErrInvalidKey = errors.New("squid: malformed key")
+
+	// ErrDiskFull is returned when writes are rejected because free disk space is low.
+	ErrDiskFull = errors.New("squid: insufficient disk space, writes rejected")
+
+	// ErrTooManyFiles is returned when writes are rejected because the
+	// database has FileLimits.MaxOpenFiles files.
+	ErrTooManyFiles = errors.New("squid: too many open files, writes rejected")
+
 	// ErrInvalidQuery is returned when a query has invalid parameters.
 	ErrInvalidQuery = errors.New("squid: invalid query parameters")
 
 	// ErrTooManyValues is returned when aggregating percentiles over too many values.
 	ErrTooManyValues = errors.New("squid: too many values for percentile calculation")
+
+	// ErrFutureTimestamp is returned when an event's timestamp exceeds Options.MaxFutureDrift.
+	ErrFutureTimestamp = errors.New("squid: event timestamp too far in the future")
+
+	// ErrInvalidID is returned when an ID from Options.IDSource does not encode the event's timestamp.
+	ErrInvalidID = errors.New("squid: event ID does not match event timestamp")
+
+	// ErrDuplicateID is returned when an ID from Options.IDSource is already stored.
+	ErrDuplicateID = errors.New("squid: event ID already exists")
+
+	// ErrVersionConflict is returned when an update is based on an outdated version of an event.
+	ErrVersionConflict = errors.New("squid: event version conflict")
+
+	// ErrSubscriptionOverflow is returned by Subscription.Err when a subscriber fell behind
+	// and its FailSubscription overflow policy ended the subscription.
+	ErrSubscriptionOverflow = errors.New("squid: subscription buffer overflow")
+
+	// ErrCorruptRecord is returned when a stored event cannot be decoded.
+	ErrCorruptRecord = errors.New("squid: corrupt record")
+
+	// ErrInvalidEncryptionKey is returned when an encryption key has an invalid length or
+	// does not match the key the database was encrypted with.
+	ErrInvalidEncryptionKey = errors.New("squid: invalid encryption key")
+
+	// ErrInvalidJSON is returned when an event appended as JSON cannot be parsed.
+	ErrInvalidJSON = errors.New("squid: invalid event JSON")
+
+	// ErrInvalidData is returned when event data holds a value that cannot be stored, such as NaN.
+	ErrInvalidData = errors.New("squid: invalid data value")
+
+	// ErrManifestMismatch is returned when an export file does not match its manifest entry.
+	ErrManifestMismatch = errors.New("squid: export does not match manifest")
+
+	// ErrMetaNotFound is returned when an application metadata key is not set.
+	ErrMetaNotFound = errors.New("squid: metadata not found")
+
+	// ErrInvalidMetaKey is returned when an application metadata key is empty or too long.
+	ErrInvalidMetaKey = errors.New("squid: invalid metadata key")
+
+	// ErrTxConflict is returned by DB.Tx when metadata read by the transaction was changed concurrently.
+	ErrTxConflict = errors.New("squid: transaction conflict")
+
+	// ErrSuperseded is returned when a correction supersedes an event that another correction already supersedes.
+	ErrSuperseded = errors.New("squid: event already superseded")
+
+	// ErrInvalidLegalHold is returned when a legal hold has no name or an empty time range.
+	ErrInvalidLegalHold = errors.New("squid: invalid legal hold")
+
+	// ErrLegalHoldNotFound is returned when releasing a legal hold that is not placed.
+	ErrLegalHoldNotFound = errors.New("squid: legal hold not found")
+
+	// ErrLegalHold is returned when updating an event that a legal hold covers.
+	ErrLegalHold = errors.New("squid: event under legal hold")
+)
+
+// Stages of a read reported by QueryError.
+const (
+	// StageScan is iterating over event or index keys.
+	StageScan = "scan"
+	// StageFetch is loading a stored event.
+	StageFetch = "fetch"
+	// StageDecode is decoding a stored event.
+	StageDecode = "decode"
 )
+
+// QueryError describes a storage failure while reading events.
+// It wraps the underlying BadgerDB or decoding error, so it can be
+// matched with errors.Is and errors.As.
+type QueryError struct {
+	// Stage is the part of the read that failed: StageScan, StageFetch or StageDecode.
+	Stage string
+
+	// Key is the storage key being read, if known.
+	Key []byte
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	if len(e.Key) == 0 {
+		return fmt.Sprintf("squid: %s failed: %v", e.Stage, e.Err)
+	}
+	return fmt.Sprintf("squid: %s failed at key %q: %v", e.Stage, e.Key, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError describes which part of an event failed validation.
+// It wraps ErrKeyTooLong, ErrInvalidTag or ErrInvalidData, so it can be
+// matched with errors.Is.
+type ValidationError struct {
+	// Field is the part of the event that was rejected: "type", "tag key",
+	// "tag value" or "data", whose Value is the path of the rejected value.
+	Field string
+
+	// Value is the rejected value.
+	Value string
+
+	// Err is the underlying sentinel error.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	value := e.Value
+	if len(value) > 64 {
+		value = value[:64] + "..."
+	}
+	return fmt.Sprintf("%v: %s %q", e.Err, e.Field, value)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// SQLError describes where a SQL-like query failed to parse. It wraps
+// ErrInvalidQuery, so it can be matched with errors.Is.
+type SQLError struct {
+	// Offset is the byte offset in the query of the token that failed.
+	Offset int
+
+	// Message describes the failure.
+	Message string
+}
+
+func (e *SQLError) Error() string {
+	return fmt.Sprintf("%v: %s at offset %d", ErrInvalidQuery, e.Message, e.Offset)
+}
+
+func (e *SQLError) Unwrap() error {
+	return ErrInvalidQuery
+}
@@ -2,6 +2,7 @@ package squid
 
 import (
 	"time"
+	"unicode/utf8"
 
 	"github.com/oklog/ulid/v2"
 )
@@ -22,12 +23,62 @@ type Event struct {
 
 	// Data contains the event payload with arbitrary fields.
 	Data map[string]any `json:"data,omitempty"`
+
+	// Version is incremented by every Update (set to 1 on append).
+	Version uint64 `json:"version,omitempty"`
+
+	// IngestedAt is when the event was appended, which differs from
+	// Timestamp for events backfilled from the past. It is set by the
+	// database and is zero for events stored before it was recorded.
+	IngestedAt time.Time `json:"ingested_at"`
+
+	// Supersedes is the ID of an earlier event that this event corrects,
+	// or nil. See DB.Latest and Query.Latest.
+	Supersedes *ulid.ULID `json:"supersedes,omitempty"`
 }
 
-// validate checks if the event has required fields.
+// ingestedAt returns when the event was appended, taking events stored
+// before ingest times were recorded as appended at their timestamp.
+func (e *Event) ingestedAt() time.Time {
+	if e.IngestedAt.IsZero() {
+		return e.Timestamp
+	}
+	return e.IngestedAt
+}
+
+// validate checks if the event has required fields and that its type and
+// tags can be stored in index keys.
 func (e *Event) validate() error {
 	if e.Type == "" {
 		return ErrEmptyType
 	}
+	if err := validateKeyComponent("type", e.Type); err != nil {
+		return err
+	}
+	for k, v := range e.Tags {
+		if k == "" {
+			return &ValidationError{Field: "tag key", Value: k, Err: ErrInvalidTag}
+		}
+		if err := validateKeyComponent("tag key", k); err != nil {
+			return err
+		}
+		if err := validateKeyComponent("tag value", v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateKeyComponent checks that s can be used as an index key component.
+// Values must be valid UTF-8: the JSON encoding of the event would otherwise
+// replace invalid bytes, and the stored event would no longer match its
+// index entries.
+func validateKeyComponent(field, s string) error {
+	if len(s) > maxKeyComponentLen {
+		return &ValidationError{Field: field, Value: s, Err: ErrKeyTooLong}
+	}
+	if !utf8.ValidString(s) {
+		return &ValidationError{Field: field, Value: s, Err: ErrInvalidTag}
+	}
 	return nil
 }
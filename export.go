@@ -1,12 +1,16 @@
 package squid
 
 import (
+	"bytes"
 	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"io"
 	"sort"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 )
 
@@ -18,11 +22,70 @@ const (
 	JSON ExportFormat = iota
 	// CSV exports events as CSV with flattened tags and data.
 	CSV
+	// Arrow exports events as an Arrow IPC stream with the columns of the
+	// CSV export, typed and read a page at a time as described for
+	// WriteArrow.
+	Arrow
+	// Logfmt exports events as logfmt lines, one per event. See Import for
+	// how lines are read back.
+	Logfmt
 )
 
+// exportFormatNames maps each ExportFormat to its string form.
+var exportFormatNames = [...]string{
+	JSON:   "json",
+	CSV:    "csv",
+	Arrow:  "arrow",
+	Logfmt: "logfmt",
+}
+
+// String returns the lower-case name of the format, e.g. "csv".
+func (f ExportFormat) String() string {
+	if f >= 0 && int(f) < len(exportFormatNames) {
+		return exportFormatNames[f]
+	}
+	return "ExportFormat(" + strconv.Itoa(int(f)) + ")"
+}
+
+// ParseExportFormat returns the ExportFormat named s.
+// Names are case-insensitive.
+func ParseExportFormat(s string) (ExportFormat, error) {
+	for i, name := range exportFormatNames {
+		if strings.EqualFold(s, name) {
+			return ExportFormat(i), nil
+		}
+	}
+	return 0, fmt.Errorf("squid: unknown export format %q", s)
+}
+
+// MarshalText encodes the format as its name.
+func (f ExportFormat) MarshalText() ([]byte, error) {
+	if f < 0 || int(f) >= len(exportFormatNames) {
+		return nil, fmt.Errorf("squid: unknown export format %d", int(f))
+	}
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText decodes a format name.
+func (f *ExportFormat) UnmarshalText(text []byte) error {
+	parsed, err := ParseExportFormat(string(text))
+	if err != nil {
+		return err
+	}
+	*f = parsed
+	return nil
+}
+
+// Exporter encodes the events written by Export. The ExportFormat constants
+// and the exporters returned by Template implement it.
+type Exporter interface {
+	export(ctx context.Context, w io.Writer, events []*Event) error
+}
+
 // Export writes events matching the query to the given writer in the specified format.
-// The context can be used to cancel long-running exports.
-func (db *DB) Export(ctx context.Context, w io.Writer, q Query, format ExportFormat) error {
+// The context can be used to cancel long-running exports, and to report
+// progress with WithProgress.
+func (db *DB) Export(ctx context.Context, w io.Writer, q Query, format Exporter) error {
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
@@ -34,21 +97,98 @@ func (db *DB) Export(ctx context.Context, w io.Writer, q Query, format ExportFor
 		return err
 	}
 
+	t := startProgress(ctx, "export")
+	defer t.done()
+
+	if format == Arrow {
+		return db.writeArrow(ctx, t, w, q, nil, nil)
+	}
+
 	events, err := db.Query(ctx, q)
 	if err != nil {
 		return err
 	}
 
-	switch format {
+	return exportEvents(ctx, t, w, events, format)
+}
+
+// exportEvents writes events in format, reporting progress to t. Formats
+// that encode the events one by one report each; the others report all of
+// them once written.
+func exportEvents(ctx context.Context, t *progressTracker, w io.Writer, events []*Event, format Exporter) error {
+	if t == nil {
+		return format.export(ctx, w, events)
+	}
+
+	t.total(int64(len(events)), 0)
+	if err := format.export(t.with(ctx), progressWriter{w, t}, events); err != nil {
+		return err
+	}
+	t.p.Items = t.p.TotalItems
+	return nil
+}
+
+// export writes events in format f; unknown formats are written as JSON.
+func (f ExportFormat) export(ctx context.Context, w io.Writer, events []*Event) error {
+	switch f {
 	case JSON:
 		return exportJSON(ctx, w, events)
 	case CSV:
 		return exportCSV(ctx, w, events)
+	case Arrow:
+		return writeArrowEvents(ctx, w, events, nil)
+	case Logfmt:
+		return exportLogfmt(ctx, w, events)
 	default:
 		return exportJSON(ctx, w, events)
 	}
 }
 
+// templateExporter writes each event by executing a template.
+type templateExporter struct {
+	tmpl *template.Template
+}
+
+// Template returns an exporter that executes tmpl for each event, with the
+// *Event as data, to produce formats such as logfmt or syslog lines:
+//
+//	tmpl := template.Must(template.New("logfmt").Parse(
+//	    `ts={{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}} type={{.Type}} service={{index .Tags "service"}}`))
+//	err := db.Export(ctx, w, q, squid.Template(tmpl))
+//
+// Each event's output is written as a line; a newline is added unless the
+// output already ends with one.
+func Template(tmpl *template.Template) Exporter {
+	return templateExporter{tmpl: tmpl}
+}
+
+// export executes the template for each event, checking the context every
+// 1000 events.
+func (e templateExporter) export(ctx context.Context, w io.Writer, events []*Event) error {
+	var buf bytes.Buffer
+	t := progressFrom(ctx)
+	for i, event := range events {
+		if i%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		buf.Reset()
+		if err := e.tmpl.Execute(&buf, event); err != nil {
+			return fmt.Errorf("squid: executing export template for event %s: %w", event.ID, err)
+		}
+		if buf.Len() == 0 || buf.Bytes()[buf.Len()-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		t.add(1, 0)
+	}
+	return nil
+}
+
 // exportJSON writes events as a JSON array.
 // For JSON, we write all events at once, so we just check context before starting.
 func exportJSON(ctx context.Context, w io.Writer, events []*Event) error {
@@ -87,6 +227,7 @@ func exportCSV(ctx context.Context, w io.Writer, events []*Event) error {
 	}
 
 	// Write rows with periodic context checks
+	t := progressFrom(ctx)
 	for i, event := range events {
 		// Check context every 1000 rows to avoid overhead on small exports
 		if i%1000 == 0 {
@@ -98,6 +239,7 @@ func exportCSV(ctx context.Context, w io.Writer, events []*Event) error {
 		if err := writer.Write(row); err != nil {
 			return err
 		}
+		t.add(1, 0)
 	}
 
 	return writer.Error()
@@ -8,6 +8,7 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"text/template"
 	"time"
 )
 
@@ -379,3 +380,121 @@ func TestExportContextCancellation(t *testing.T) {
 		t.Errorf("expected context.Canceled for CSV, got %v", err)
 	}
 }
+
+func TestExportFormatText(t *testing.T) {
+	for _, format := range []ExportFormat{JSON, CSV, Arrow, Logfmt} {
+		parsed, err := ParseExportFormat(format.String())
+		if err != nil {
+			t.Fatalf("ParseExportFormat(%q) failed: %v", format, err)
+		}
+		if parsed != format {
+			t.Errorf("roundtrip failed: got %v, want %v", parsed, format)
+		}
+	}
+
+	if _, err := ParseExportFormat("xml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+
+	var cfg struct {
+		Format ExportFormat `json:"format"`
+	}
+	if err := json.Unmarshal([]byte(`{"format":"CSV"}`), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if cfg.Format != CSV {
+		t.Errorf("expected CSV, got %v", cfg.Format)
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `{"format":"csv"}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
+
+func TestExportArrow(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 3; i++ {
+		event := Event{Type: "request", Tags: map[string]string{"env": "prod"}, Data: map[string]any{"latency": float64(i)}}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := db.Export(context.Background(), &buf, Query{}, Arrow); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	columns := readArrowStream(t, buf.Bytes())
+	var names []string
+	for _, c := range columns {
+		names = append(names, c.name)
+	}
+	if got := strings.Join(names, ","); got != "id,timestamp,type,tag_env,data_latency" {
+		t.Fatalf("unexpected columns %s", got)
+	}
+	if latency := columns[4].values; len(latency) != 3 || latency[2] != 2.0 {
+		t.Errorf("unexpected latency column %v", latency)
+	}
+}
+
+func TestExportTemplate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i, service := range []string{"api", "web"} {
+		event := Event{
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+			Type:      "request",
+			Tags:      map[string]string{"service": service},
+			Data:      map[string]any{"latency": float64(10 * (i + 1))},
+		}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	tmpl := template.Must(template.New("logfmt").Parse(
+		`ts={{.Timestamp.Format "15:04"}} type={{.Type}} service={{index .Tags "service"}} latency={{.Data.latency}}`))
+
+	var buf bytes.Buffer
+	if err := db.Export(context.Background(), &buf, Query{}, Template(tmpl)); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	want := "ts=10:00 type=request service=api latency=10\nts=10:01 type=request service=web latency=20\n"
+	if buf.String() != want {
+		t.Errorf("unexpected output:\n%s", buf.String())
+	}
+
+	// Execution errors name the event
+	bad := template.Must(template.New("bad").Parse(`{{.Missing}}`))
+	if err := db.Export(context.Background(), &buf, Query{}, Template(bad)); err == nil {
+		t.Error("expected a template error")
+	}
+}
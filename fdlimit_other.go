@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd)
+
+package squid
+
+import "errors"
+
+// openFileLimit is not implemented on this platform.
+func openFileLimit() (uint64, error) {
+	return 0, errors.New("squid: the open file limit is not available on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package squid
+
+import "syscall"
+
+// openFileLimit returns the process's soft limit of open files.
+func openFileLimit() (uint64, error) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, err
+	}
+	return uint64(rl.Cur), nil
+}
@@ -0,0 +1,73 @@
+package squid
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// fetchConcurrency is the number of goroutines that get the primary records
+// of candidate events at once.
+const fetchConcurrency = 8
+
+// fetchChunk is the number of candidates fetched ahead of the filters that
+// decide how many more are needed.
+const fetchChunk = 256
+
+// fetched is the primary record of a candidate event, or the error getting
+// it.
+type fetched struct {
+	item *badger.Item
+	err  error
+}
+
+// pruneCandidates returns the candidate IDs in the query's time and ID
+// range, so that the records of the others are never read. Candidates are
+// in ingest order for OrderByIngest, so the first one past the range does
+// not end it.
+func (db *DB) pruneCandidates(ids []ulid.ULID, q Query) []ulid.ULID {
+	if q.Start == nil && q.End == nil && q.MinID == nil && q.MaxID == nil {
+		return ids
+	}
+	kept := make([]ulid.ULID, 0, len(ids))
+	for _, id := range ids {
+		if db.matchesTimeRange(id, q) {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// prefetch gets the primary records of the events with the given IDs, with
+// up to fetchConcurrency gets in flight. Gets in a read-only transaction
+// are safe to run concurrently; decoding the records is left to the caller,
+// which records stats in order.
+func prefetch(txn *badger.Txn, ids []ulid.ULID) []fetched {
+	found := make([]fetched, len(ids))
+	get := func(i int) {
+		found[i].item, found[i].err = txn.Get(encodeEventKey(ids[i]))
+	}
+
+	if len(ids) <= fetchConcurrency {
+		for i := range ids {
+			get(i)
+		}
+		return found
+	}
+
+	var wg sync.WaitGroup
+	var next atomic.Int64
+	for range fetchConcurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1)) - 1; i < len(ids); i = int(next.Add(1)) - 1 {
+				get(i)
+			}
+		}()
+	}
+	wg.Wait()
+	return found
+}
@@ -0,0 +1,188 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// defaultFileCheckInterval is how often FileLimits.MaxOpenFiles is checked
+// by default.
+const defaultFileCheckInterval = 10 * time.Second
+
+// FileLimits bounds the files BadgerDB keeps open and memory-mapped, for
+// deployment in containers with tight ulimits or little address space.
+// BadgerDB keeps every table, value log and memtable file open and mapped
+// for as long as it exists; BadgerDB v4 has no table loading modes that
+// would read tables without mapping them, so only the number and size of
+// the files can be tuned. Stats reports the current usage.
+type FileLimits struct {
+	// ValueLogFileSize is the size in bytes of value log files (0 keeps the
+	// size of the Profile, or BadgerDB's 1 GB). Each file is mapped whole,
+	// and the file being written at twice its size, so smaller files map
+	// less memory but more of them are open.
+	ValueLogFileSize int64
+
+	// TableSize is the size in bytes of the tables of the first level of
+	// BadgerDB's LSM tree, which grow tenfold on each level below (0 keeps
+	// BadgerDB's 2 MB). Larger tables mean fewer open files.
+	TableSize int64
+
+	// MaxOpenFiles makes writes fail with ErrTooManyFiles while BadgerDB
+	// has at least this many files (0 means no limit), so that a store
+	// growing towards the process's file descriptor limit stops taking
+	// writes instead of failing in a compaction. Set it well below the
+	// limit, leaving room for compactions, which open files before removing
+	// the files they replace, and for the application's own files.
+	MaxOpenFiles int
+
+	// CheckInterval is how often files are counted for MaxOpenFiles.
+	// Defaults to 10 seconds.
+	CheckInterval time.Duration
+}
+
+// applyFileLimits applies the file sizes of opts to bopts, overriding the
+// profile.
+func applyFileLimits(opts Options, bopts *badger.Options) {
+	l := opts.FileLimits
+	if l == nil {
+		return
+	}
+	if l.ValueLogFileSize > 0 {
+		bopts.ValueLogFileSize = l.ValueLogFileSize
+	}
+	if l.TableSize > 0 {
+		bopts.BaseTableSize = l.TableSize
+	}
+}
+
+// fileUsage is the number and total size of BadgerDB's open files.
+type fileUsage struct {
+	files int
+	bytes int64
+}
+
+// countFiles returns the usage of the table, value log and memtable files
+// in the directories of bdb. Files removed while they are counted are
+// skipped.
+func countFiles(bdb *badger.DB) (fileUsage, error) {
+	opts := bdb.Opts()
+	dirs := []string{opts.Dir}
+	if opts.ValueDir != opts.Dir {
+		dirs = append(dirs, opts.ValueDir)
+	}
+
+	var u fileUsage
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return u, err
+		}
+		for _, e := range entries {
+			switch filepath.Ext(e.Name()) {
+			case ".sst", ".vlog", ".mem":
+			default:
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			u.files++
+			u.bytes += info.Size()
+		}
+	}
+	return u, nil
+}
+
+// fileMonitor rejects writes while BadgerDB has too many files.
+type fileMonitor struct {
+	max      int
+	interval time.Duration
+
+	tooMany atomic.Bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newFileMonitor returns a monitor for the given options, or nil if the
+// number of files is not limited.
+func newFileMonitor(opts Options) *fileMonitor {
+	l := opts.FileLimits
+	if l == nil || l.MaxOpenFiles <= 0 {
+		return nil
+	}
+	m := &fileMonitor{max: l.MaxOpenFiles, interval: l.CheckInterval}
+	if m.interval <= 0 {
+		m.interval = defaultFileCheckInterval
+	}
+	return m
+}
+
+// start runs an initial check and then checks periodically in the
+// background.
+func (m *fileMonitor) start(bdb *badger.DB, logger Logger) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	if limit, err := openFileLimit(); err == nil && uint64(m.max) > limit && logger != nil {
+		logger.Warningf("squid: MaxOpenFiles %d exceeds the process's limit of %d open files", m.max, limit)
+	}
+	m.check(bdb, logger)
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.check(bdb, logger)
+			}
+		}
+	}()
+}
+
+// stop ends checking and waits for the goroutine to exit.
+func (m *fileMonitor) stop() {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+}
+
+// check counts the files and updates whether writes are rejected.
+func (m *fileMonitor) check(bdb *badger.DB, logger Logger) {
+	u, err := countFiles(bdb)
+	if err != nil {
+		if logger != nil {
+			logger.Warningf("squid: counting files: %v", err)
+		}
+		return
+	}
+
+	if u.files < m.max {
+		if m.tooMany.Swap(false) && logger != nil {
+			logger.Infof("squid: %d open files, writes resumed", u.files)
+		}
+		return
+	}
+	if !m.tooMany.Swap(true) && logger != nil {
+		logger.Warningf("squid: %d open files (maximum %d), writes rejected", u.files, m.max)
+	}
+}
+
+// rejectWrites reports whether writes should fail with ErrTooManyFiles.
+func (m *fileMonitor) rejectWrites() bool {
+	return m.tooMany.Load()
+}
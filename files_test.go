@@ -0,0 +1,59 @@
+package squid
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileLimits(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{FileLimits: &FileLimits{
+		ValueLogFileSize: 4 << 20,
+		TableSize:        1 << 20,
+		MaxOpenFiles:     1000,
+		CheckInterval:    time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if opts := db.badger.Opts(); opts.ValueLogFileSize != 4<<20 || opts.BaseTableSize != 1<<20 {
+		t.Errorf("file sizes not applied: %d, %d", opts.ValueLogFileSize, opts.BaseTableSize)
+	}
+	if _, err := db.Append(Event{Type: "request"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// A fresh store has a value log and a memtable file, the value log
+	// mapped at twice its size
+	stats := db.Stats()
+	if stats.OpenFiles < 2 || stats.MappedBytes < 8<<20 || stats.FileLimitExceeded {
+		t.Errorf("unexpected stats: %d files, %d bytes mapped", stats.OpenFiles, stats.MappedBytes)
+	}
+
+	// Writes are rejected at the limit, and resume below it
+	db.files.max = stats.OpenFiles
+	db.files.check(db.badger, nil)
+	if _, err := db.Append(Event{Type: "request"}); err != ErrTooManyFiles {
+		t.Errorf("expected ErrTooManyFiles, got %v", err)
+	}
+	if _, err := db.AppendBatch([]Event{{Type: "request"}}); err != ErrTooManyFiles {
+		t.Errorf("expected ErrTooManyFiles, got %v", err)
+	}
+	if !db.Stats().FileLimitExceeded {
+		t.Error("expected FileLimitExceeded")
+	}
+
+	db.files.max = stats.OpenFiles + 1
+	db.files.check(db.badger, nil)
+	if _, err := db.Append(Event{Type: "request"}); err != nil {
+		t.Errorf("Append after recovery failed: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package squid
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// GapFill decides the values of buckets without events, so charts can plot
+// bucketed results as they are.
+type GapFill int
+
+const (
+	// FillZero leaves the values of empty buckets zero.
+	FillZero GapFill = iota
+	// FillNull marks the values of empty buckets as missing. Their Sum,
+	// Avg, Min, Max and percentiles encode to JSON as null.
+	FillNull
+	// FillPrevious repeats the values of the last bucket with events.
+	// Empty buckets before the first bucket with events are filled with
+	// null.
+	FillPrevious
+	// FillLinear interpolates the values of empty buckets linearly between
+	// the buckets with events around them. Empty buckets at either end of
+	// the range are filled with null, and ExactSum is not interpolated.
+	FillLinear
+)
+
+// gapFillNames maps each GapFill to its string form.
+var gapFillNames = [...]string{
+	FillZero:     "zero",
+	FillNull:     "null",
+	FillPrevious: "previous",
+	FillLinear:   "linear",
+}
+
+// String returns the lower-case name of the fill, e.g. "linear".
+func (f GapFill) String() string {
+	if f >= 0 && int(f) < len(gapFillNames) {
+		return gapFillNames[f]
+	}
+	return "GapFill(" + strconv.Itoa(int(f)) + ")"
+}
+
+// ParseGapFill returns the GapFill named s. Names are case-insensitive.
+func ParseGapFill(s string) (GapFill, error) {
+	for i, name := range gapFillNames {
+		if strings.EqualFold(s, name) {
+			return GapFill(i), nil
+		}
+	}
+	return 0, fmt.Errorf("squid: unknown gap fill %q", s)
+}
+
+// MarshalText encodes the fill as its name.
+func (f GapFill) MarshalText() ([]byte, error) {
+	if f < 0 || int(f) >= len(gapFillNames) {
+		return nil, fmt.Errorf("squid: unknown gap fill %d", int(f))
+	}
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText decodes a fill name.
+func (f *GapFill) UnmarshalText(text []byte) error {
+	parsed, err := ParseGapFill(string(text))
+	if err != nil {
+		return err
+	}
+	*f = parsed
+	return nil
+}
+
+// MarshalJSON encodes the bucket, with null values if they were filled
+// with null.
+func (b Bucket) MarshalJSON() ([]byte, error) {
+	type bucket Bucket
+	if b.Fill != FillNull {
+		return json.Marshal(bucket(b))
+	}
+
+	// The outer fields hide the values of the embedded result
+	return json.Marshal(struct {
+		bucket
+		Sum *float64 `json:"sum"`
+		Avg *float64 `json:"avg"`
+		Min *float64 `json:"min"`
+		Max *float64 `json:"max"`
+		P50 *float64 `json:"p50"`
+		P95 *float64 `json:"p95"`
+		P99 *float64 `json:"p99"`
+	}{bucket: bucket(b)})
+}
+
+// fillGaps fills the values of the buckets without events.
+func fillGaps(buckets []*Bucket, fill GapFill) {
+	if fill == FillZero {
+		return
+	}
+
+	prev := -1 // index of the last bucket with events
+	for i, b := range buckets {
+		if b.Count > 0 {
+			if fill == FillLinear && prev >= 0 && prev < i-1 {
+				interpolate(buckets[prev : i+1])
+			}
+			prev = i
+			continue
+		}
+
+		switch {
+		case fill == FillPrevious && prev >= 0:
+			b.Fill = FillPrevious
+			copyValues(&b.AggregateResult, &buckets[prev].AggregateResult)
+		case fill == FillLinear && prev >= 0:
+			// Filled once the next bucket with events is found
+		default:
+			b.Fill = FillNull
+		}
+	}
+
+	// Trailing empty buckets have nothing to interpolate towards
+	if fill == FillLinear {
+		for _, b := range buckets[prev+1:] {
+			b.Fill = FillNull
+		}
+	}
+}
+
+// interpolate fills the values of the empty buckets between the first and
+// last bucket linearly.
+func interpolate(buckets []*Bucket) {
+	first := &buckets[0].AggregateResult
+	last := &buckets[len(buckets)-1].AggregateResult
+	n := float64(len(buckets) - 1)
+	for i, b := range buckets[1 : len(buckets)-1] {
+		f := float64(i+1) / n
+		lerp := func(a, b float64) float64 { return a + (b-a)*f }
+
+		b.Fill = FillLinear
+		b.Sum = lerp(first.Sum, last.Sum)
+		b.Avg = lerp(first.Avg, last.Avg)
+		b.Min = lerp(first.Min, last.Min)
+		b.Max = lerp(first.Max, last.Max)
+		b.P50 = lerp(first.P50, last.P50)
+		b.P95 = lerp(first.P95, last.P95)
+		b.P99 = lerp(first.P99, last.P99)
+	}
+}
+
+// copyValues copies the values of a result, but not its counts, to dst.
+func copyValues(dst, src *AggregateResult) {
+	dst.Sum, dst.Avg, dst.Min, dst.Max = src.Sum, src.Avg, src.Min, src.Max
+	dst.P50, dst.P95, dst.P99 = src.P50, src.P95, src.P99
+	dst.ExactSum = src.ExactSum
+}
@@ -0,0 +1,67 @@
+package squid
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregateBucketsFill(t *testing.T) {
+	db, day := openBucketTestDB(t, 0)
+
+	// Half-hour buckets from 00:00 to 03:30 with events in the first,
+	// third, fourth and seventh
+	null := math.NaN()
+	tests := []struct {
+		fill GapFill
+		sums []float64
+	}{
+		{FillZero, []float64{1, 0, 2, 3, 0, 0, 4, 0}},
+		{FillNull, []float64{1, null, 2, 3, null, null, 4, null}},
+		{FillPrevious, []float64{1, 1, 2, 3, 3, 3, 4, 4}},
+		{FillLinear, []float64{1, 1.5, 2, 3, 10.0 / 3, 11.0 / 3, 4, null}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fill.String(), func(t *testing.T) {
+			buckets, err := db.AggregateBucketsWithOptions(context.Background(), Query{Start: &day}, "value",
+				[]AggregationType{Count, Sum}, 30*time.Minute, BucketOptions{Fill: tt.fill})
+			if err != nil {
+				t.Fatalf("AggregateBucketsWithOptions failed: %v", err)
+			}
+			if len(buckets) != len(tt.sums) {
+				t.Fatalf("expected %d buckets, got %d", len(tt.sums), len(buckets))
+			}
+			for i, b := range buckets {
+				want := tt.sums[i]
+				if math.IsNaN(want) {
+					if b.Fill != FillNull {
+						t.Errorf("bucket %d: expected a null fill, got %s", i, b.Fill)
+					}
+					continue
+				}
+				if math.Abs(b.Sum-want) > 1e-9 {
+					t.Errorf("bucket %d: expected sum %v, got %v", i, want, b.Sum)
+				}
+				if b.Count == 0 && b.Fill != tt.fill {
+					t.Errorf("bucket %d: expected a %s fill, got %s", i, tt.fill, b.Fill)
+				}
+			}
+		})
+	}
+
+	buckets, err := db.AggregateBucketsWithOptions(context.Background(), Query{Start: &day}, "value",
+		[]AggregationType{Sum}, 30*time.Minute, BucketOptions{Fill: FillNull})
+	if err != nil {
+		t.Fatalf("AggregateBucketsWithOptions failed: %v", err)
+	}
+	encoded, err := json.Marshal(buckets[1])
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(encoded), `"sum":null`) || !strings.Contains(string(encoded), `"count":0`) {
+		t.Errorf("expected a null sum and a zero count, got %s", encoded)
+	}
+}
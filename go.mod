@@ -4,6 +4,7 @@ go 1.23.0
 
 require (
 	github.com/dgraph-io/badger/v4 v4.9.0
+	github.com/google/flatbuffers v25.2.10+incompatible
 	github.com/oklog/ulid/v2 v2.1.1
 )
 
@@ -13,7 +14,6 @@ require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/go-logr/logr v1.4.3 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
-	github.com/google/flatbuffers v25.2.10+incompatible // indirect
 	github.com/klauspost/compress v1.18.0 // indirect
 	go.opentelemetry.io/auto/sdk v1.1.0 // indirect
 	go.opentelemetry.io/otel v1.37.0 // indirect
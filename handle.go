@@ -0,0 +1,190 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// NamespaceTag is the tag that assigns events to the namespace of a
+// NamespaceHandle.
+const NamespaceTag = "namespace"
+
+// ReadOnlyDB is a handle that can read events but not change them, for
+// embedders that give plugins or submodules access to a database without
+// letting them append, update or delete events, or change retention. A
+// ReadOnlyDB from NamespaceHandle.ReadOnly only reads its namespace.
+type ReadOnlyDB struct {
+	db        *DB
+	namespace string // empty for the whole database
+}
+
+// ReadOnly returns a handle that can only read the database.
+func (db *DB) ReadOnly() *ReadOnlyDB {
+	return &ReadOnlyDB{db: db}
+}
+
+// scope restricts a query to the handle's namespace. A query for events of
+// another namespace is rejected.
+func (r *ReadOnlyDB) scope(q Query) (Query, error) {
+	if r.namespace == "" {
+		return q, nil
+	}
+	if v, ok := q.Tags[NamespaceTag]; ok && v != r.namespace {
+		return q, fmt.Errorf("%w: %s %q is outside namespace %q", ErrInvalidQuery, NamespaceTag, v, r.namespace)
+	}
+	q.Tags = maps.Clone(q.Tags)
+	if q.Tags == nil {
+		q.Tags = make(map[string]string, 1)
+	}
+	q.Tags[NamespaceTag] = r.namespace
+	return q, nil
+}
+
+// Get returns the event with the given ID, or ErrNotFound if it is not
+// stored or outside the handle's namespace.
+func (r *ReadOnlyDB) Get(id ulid.ULID) (*Event, error) {
+	event, err := r.db.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.namespace != "" && event.Tags[NamespaceTag] != r.namespace {
+		return nil, ErrNotFound
+	}
+	return event, nil
+}
+
+// Latest returns the latest correction of the event with the given ID, as
+// DB.Latest does, or ErrNotFound if either is outside the handle's
+// namespace.
+func (r *ReadOnlyDB) Latest(id ulid.ULID) (*Event, error) {
+	if _, err := r.Get(id); err != nil {
+		return nil, err
+	}
+	event, err := r.db.Latest(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.namespace != "" && event.Tags[NamespaceTag] != r.namespace {
+		return nil, ErrNotFound
+	}
+	return event, nil
+}
+
+// Query returns the events matching q, as DB.Query does.
+func (r *ReadOnlyDB) Query(ctx context.Context, q Query) ([]*Event, error) {
+	q, err := r.scope(q)
+	if err != nil {
+		return nil, err
+	}
+	return r.db.Query(ctx, q)
+}
+
+// Aggregate aggregates the events matching q, as DB.Aggregate does.
+func (r *ReadOnlyDB) Aggregate(ctx context.Context, q Query, field string, aggs []AggregationType) (*AggregateResult, error) {
+	q, err := r.scope(q)
+	if err != nil {
+		return nil, err
+	}
+	return r.db.Aggregate(ctx, q, field, aggs)
+}
+
+// AggregateBuckets aggregates the events matching q in buckets, as
+// DB.AggregateBuckets does.
+func (r *ReadOnlyDB) AggregateBuckets(ctx context.Context, q Query, field string, aggs []AggregationType, interval time.Duration) ([]*Bucket, error) {
+	q, err := r.scope(q)
+	if err != nil {
+		return nil, err
+	}
+	return r.db.AggregateBuckets(ctx, q, field, aggs, interval)
+}
+
+// Subscribe delivers newly appended events matching opts.Query, as
+// DB.Subscribe does.
+func (r *ReadOnlyDB) Subscribe(ctx context.Context, opts SubscribeOptions) (*Subscription, error) {
+	q, err := r.scope(opts.Query)
+	if err != nil {
+		return nil, err
+	}
+	opts.Query = q
+	return r.db.Subscribe(ctx, opts)
+}
+
+// Export writes the events matching q to w, as DB.Export does.
+func (r *ReadOnlyDB) Export(ctx context.Context, w io.Writer, q Query, format Exporter) error {
+	q, err := r.scope(q)
+	if err != nil {
+		return err
+	}
+	return r.db.Export(ctx, w, q, format)
+}
+
+// NamespaceHandle is a handle that reads and appends the events of one
+// namespace: those whose NamespaceTag is the namespace name. It gives a
+// plugin or tenant its own slice of a shared database, without access to
+// other namespaces, updates, deletes or retention.
+type NamespaceHandle struct {
+	ReadOnlyDB
+}
+
+// Namespace returns a handle to the events of the named namespace. The
+// name must be a non-empty, valid tag value.
+func (db *DB) Namespace(name string) (*NamespaceHandle, error) {
+	if name == "" {
+		return nil, &ValidationError{Field: "tag value", Value: name, Err: ErrInvalidTag}
+	}
+	if err := validateKeyComponent("tag value", name); err != nil {
+		return nil, err
+	}
+	return &NamespaceHandle{ReadOnlyDB{db: db, namespace: name}}, nil
+}
+
+// Name returns the name of the handle's namespace.
+func (h *NamespaceHandle) Name() string {
+	return h.namespace
+}
+
+// ReadOnly returns a handle that can only read the namespace.
+func (h *NamespaceHandle) ReadOnly() *ReadOnlyDB {
+	r := h.ReadOnlyDB
+	return &r
+}
+
+// claim assigns an event to the handle's namespace, rejecting events that
+// are tagged with another one.
+func (h *NamespaceHandle) claim(event *Event) error {
+	if v, ok := event.Tags[NamespaceTag]; ok && v != h.namespace {
+		return &ValidationError{Field: "tag value", Value: v, Err: ErrInvalidTag}
+	}
+	event.Tags = maps.Clone(event.Tags)
+	if event.Tags == nil {
+		event.Tags = make(map[string]string, 1)
+	}
+	event.Tags[NamespaceTag] = h.namespace
+	return nil
+}
+
+// Append adds an event to the namespace, as DB.Append does.
+func (h *NamespaceHandle) Append(event Event) (*AppendResult, error) {
+	if err := h.claim(&event); err != nil {
+		return nil, err
+	}
+	return h.db.Append(event)
+}
+
+// AppendBatch adds events to the namespace atomically, as DB.AppendBatch
+// does.
+func (h *NamespaceHandle) AppendBatch(events []Event) ([]*AppendResult, error) {
+	claimed := make([]Event, len(events))
+	for i, event := range events {
+		if err := h.claim(&event); err != nil {
+			return nil, err
+		}
+		claimed[i] = event
+	}
+	return h.db.AppendBatch(claimed)
+}
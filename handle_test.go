@@ -0,0 +1,75 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestHandles(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	billing, err := db.Namespace("billing")
+	if err != nil {
+		t.Fatalf("Namespace failed: %v", err)
+	}
+	results, err := billing.AppendBatch([]Event{
+		{Type: "invoice", Tags: map[string]string{"env": "prod"}},
+		{Type: "invoice"},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	if results[0].Tags[NamespaceTag] != "billing" || results[0].Tags["env"] != "prod" {
+		t.Errorf("expected the event to be tagged with its namespace, got %v", results[0].Tags)
+	}
+	search, err := db.Namespace("search")
+	if err != nil {
+		t.Fatalf("Namespace failed: %v", err)
+	}
+	other, err := search.Append(Event{Type: "invoice"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := billing.Append(Event{Type: "invoice", Tags: map[string]string{NamespaceTag: "search"}}); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("expected ErrInvalidTag for another namespace, got %v", err)
+	}
+	if _, err := db.Namespace(""); !errors.Is(err, ErrInvalidTag) {
+		t.Errorf("expected ErrInvalidTag for an empty namespace, got %v", err)
+	}
+
+	// Reads only see the namespace
+	reader := billing.ReadOnly()
+	events, err := reader.Query(ctx, Query{Types: []string{"invoice"}})
+	if err != nil || len(events) != 2 {
+		t.Errorf("expected the 2 billing events, got %d, %v", len(events), err)
+	}
+	if _, err := reader.Get(other.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an event of another namespace, got %v", err)
+	}
+	if _, err := reader.Query(ctx, Query{Tags: map[string]string{NamespaceTag: "search"}}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for another namespace, got %v", err)
+	}
+	result, err := billing.Aggregate(ctx, Query{}, "", []AggregationType{Count})
+	if err != nil || result.Count != 2 {
+		t.Errorf("expected a count of 2, got %+v, %v", result, err)
+	}
+
+	// The whole database
+	result, err = db.ReadOnly().Aggregate(ctx, Query{}, "", []AggregationType{Count})
+	if err != nil || result.Count != 3 {
+		t.Errorf("expected a count of 3, got %+v, %v", result, err)
+	}
+}
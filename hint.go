@@ -0,0 +1,180 @@
+package squid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Hint overrides the query planner's choice of how to find events.
+// The zero value lets the planner decide.
+type Hint string
+
+const (
+	// NoIndex finds events by scanning all of them instead of an index.
+	// The planner may still skip time ranges that its statistics show to
+	// be empty.
+	NoIndex Hint = "no_index"
+
+	// ForceFullScan scans every event in the time range, without any
+	// shortcut. It is mainly useful to check the indices and statistics.
+	ForceFullScan Hint = "full_scan"
+)
+
+// hintIndexPrefix starts the hints returned by ForceIndex.
+const hintIndexPrefix = "index:"
+
+// ForceIndex makes the planner use the named index: "type" for the type
+// index, which requires a type filter or a type pattern, "tag:<key>" for the
+// index of a tag the query filters on, "tags" for the intersection of the
+// indices of the query's exact tag filters, which requires at least two,
+// "ingest" for the ingest time index, which requires Options.IndexIngestTime
+// and an ingest time range, or "search" for the search index, which requires
+// Options.SearchIndex and a search.
+func ForceIndex(index string) Hint {
+	return Hint(hintIndexPrefix + index)
+}
+
+// chooseIndex returns the index a query scans: "type", "tag:<key>", "tags",
+// "ingest", "search", or "" for a full scan. A hint that changes the planner's choice is logged.
+func (db *DB) chooseIndex(q Query) (string, error) {
+	if err := checkTypePattern(q); err != nil {
+		return "", err
+	}
+	if err := checkWhere(q); err != nil {
+		return "", err
+	}
+	if err := checkSearch(q); err != nil {
+		return "", err
+	}
+
+	planned := plannedIndex(q, db.opts)
+	if q.Hint == "" {
+		return planned, nil
+	}
+
+	var index string
+	switch {
+	case q.Hint == NoIndex, q.Hint == ForceFullScan:
+		index = ""
+	case strings.HasPrefix(string(q.Hint), hintIndexPrefix):
+		index = strings.TrimPrefix(string(q.Hint), hintIndexPrefix)
+		if err := checkIndex(q, index, db.opts); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("%w: unknown hint %q", ErrInvalidQuery, q.Hint)
+	}
+
+	if index != planned && db.opts.Logger != nil {
+		db.opts.Logger.Infof("squid: hint %q changed query plan from %s to %s", q.Hint, describeIndex(planned), describeIndex(index))
+	}
+	return index, nil
+}
+
+// plannedIndex returns the index the planner picks for a query.
+// TODO(asungur): Query planning prioritises type index.
+// This could be improved by approximating selectivity of each index type,
+// and choosing the more performant index.
+func plannedIndex(q Query, opts Options) string {
+	// Ingest time ranges are usually recent and narrow, so their index
+	// reads the fewest events
+	if opts.IndexIngestTime && q.hasIngestRange() {
+		return "ingest"
+	}
+
+	// Searches are usually for rare words
+	if opts.SearchIndex && q.Search != "" {
+		return "search"
+	}
+
+	// If we have type filters, use the type index, or the union of the
+	// indices of several types
+	if len(q.Types) > 0 {
+		return "type"
+	}
+
+	// Several exact tags are intersected, so that a selective one bounds
+	// the entries read from the others
+	if len(exactTags(q)) > 1 {
+		return "tags"
+	}
+
+	// If we have tag filters, use the first tag's index
+	// (smallest result set heuristic would require counting, skip for MVP).
+	// An exact value reads a single range of the index, unlike a pattern
+	var pattern string
+	for k, v := range q.Tags {
+		if !isTagPattern(v) {
+			return "tag:" + k
+		}
+		pattern = k
+	}
+
+	// A type pattern reads the type index entries of every type it matches
+	if len(q.Types) == 0 && q.TypePattern != "" {
+		return "type"
+	}
+	if pattern != "" {
+		return "tag:" + pattern
+	}
+
+	// No suitable index, use full scan
+	return ""
+}
+
+// checkIndex reports whether a query can be answered from the named index.
+func checkIndex(q Query, index string, opts Options) error {
+	if index == "ingest" {
+		if !opts.IndexIngestTime {
+			return fmt.Errorf("%w: the ingest time index requires Options.IndexIngestTime", ErrInvalidQuery)
+		}
+		if !q.hasIngestRange() {
+			return fmt.Errorf("%w: the ingest time index requires an ingest time range", ErrInvalidQuery)
+		}
+		return nil
+	}
+
+	if index == "search" {
+		if !opts.SearchIndex {
+			return fmt.Errorf("%w: the search index requires Options.SearchIndex", ErrInvalidQuery)
+		}
+		if q.Search == "" {
+			return fmt.Errorf("%w: the search index requires a search", ErrInvalidQuery)
+		}
+		return nil
+	}
+
+	if index == "type" {
+		if len(q.Types) == 0 && q.TypePattern == "" {
+			return fmt.Errorf("%w: the type index requires a type filter or a type pattern", ErrInvalidQuery)
+		}
+		return nil
+	}
+
+	if index == "tags" {
+		if len(exactTags(q)) < 2 {
+			return fmt.Errorf("%w: intersecting tag indices requires filters on at least two exact tag values", ErrInvalidQuery)
+		}
+		return nil
+	}
+
+	if key, ok := strings.CutPrefix(index, "tag:"); ok {
+		if _, ok := q.Tags[key]; !ok {
+			return fmt.Errorf("%w: the index of tag %q requires a filter on it", ErrInvalidQuery, key)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown index %q", ErrInvalidQuery, index)
+}
+
+// describeIndex names an index for log messages.
+func describeIndex(index string) string {
+	if index == "" {
+		return "full scan"
+	}
+	if index == "tags" {
+		return "tag index intersection"
+	}
+	return index + " index"
+}
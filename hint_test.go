@@ -0,0 +1,108 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger collects Squid's Info and Warning messages.
+type recordingLogger struct {
+	mu       sync.Mutex
+	infos    []string
+	warnings []string
+}
+
+func (l *recordingLogger) Errorf(string, ...interface{}) {}
+func (l *recordingLogger) Debugf(string, ...interface{}) {}
+
+func (l *recordingLogger) Warningf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !strings.HasPrefix(msg, "squid:") {
+		return // BadgerDB output
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warnings = append(l.warnings, msg)
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	if !strings.HasPrefix(msg, "squid:") {
+		return // BadgerDB output
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.infos = append(l.infos, msg)
+}
+
+func TestQueryHint(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := &recordingLogger{}
+	db, err := OpenWithOptions(dir, Options{Logger: logger})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		service := "api"
+		if i%2 == 0 {
+			service = "web"
+		}
+		_, err := db.Append(Event{Type: "request", Tags: map[string]string{"service": service}})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	base := Query{Types: []string{"request"}, Tags: map[string]string{"service": "api"}}
+
+	for _, hint := range []Hint{"", NoIndex, ForceFullScan, ForceIndex("type"), ForceIndex("tag:service")} {
+		q := base
+		q.Hint = hint
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("hint %q: Query failed: %v", hint, err)
+		}
+		if len(events) != 5 {
+			t.Errorf("hint %q: expected 5 events, got %d", hint, len(events))
+		}
+	}
+
+	// Only hints that changed the plan are logged
+	logger.mu.Lock()
+	logged := logger.infos
+	logger.mu.Unlock()
+	if len(logged) != 3 {
+		t.Fatalf("expected 3 log messages, got %q", logged)
+	}
+	if !strings.Contains(logged[2], "from type index to tag:service index") {
+		t.Errorf("unexpected log message %q", logged[2])
+	}
+
+	for _, hint := range []Hint{"bogus", ForceIndex("tag:env"), ForceIndex("type:request")} {
+		q := base
+		q.Hint = hint
+		if _, err := db.Query(ctx, q); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("hint %q: expected ErrInvalidQuery, got %v", hint, err)
+		}
+	}
+
+	q := Query{Tags: map[string]string{"service": "api"}, Hint: ForceIndex("type")}
+	if _, err := db.Query(ctx, q); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for the type index without a type filter, got %v", err)
+	}
+}
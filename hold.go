@@ -0,0 +1,72 @@
+package squid
+
+import (
+	"sync"
+	"time"
+)
+
+// RetentionHold keeps retention from deleting the events in a time range
+// while it is held, so that operations spanning several calls, such as an
+// archive job paging through old events, see every event in the range.
+// Export and QueryRaw read from a single snapshot and need no hold.
+type RetentionHold struct {
+	db         *DB
+	start, end time.Time
+	once       sync.Once
+}
+
+// HoldRetention defers the deletion of events between start and end
+// (inclusive) by retention policies and DeleteBefore until the hold is
+// released. Other events expire as usual. The disk watchdog's emergency
+// deletions ignore holds.
+func (db *DB) HoldRetention(start, end time.Time) *RetentionHold {
+	h := &RetentionHold{db: db, start: start, end: end}
+
+	m := db.retention
+	m.mu.Lock()
+	m.holds[h] = struct{}{}
+	m.mu.Unlock()
+
+	return h
+}
+
+// Release ends the hold. Events it deferred are deleted by the next cleanup
+// of their policy. Calling Release more than once has no effect.
+func (h *RetentionHold) Release() {
+	h.once.Do(func() {
+		m := h.db.retention
+		m.mu.Lock()
+		delete(m.holds, h)
+		m.mu.Unlock()
+	})
+}
+
+// heldRange is the time range of a RetentionHold.
+type heldRange struct {
+	start, end time.Time
+}
+
+// heldRanges returns the ranges currently held, or nil if there are none.
+func (m *retentionManager) heldRanges() []heldRange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.holds) == 0 {
+		return nil
+	}
+	ranges := make([]heldRange, 0, len(m.holds))
+	for h := range m.holds {
+		ranges = append(ranges, heldRange{h.start, h.end})
+	}
+	return ranges
+}
+
+// isHeld reports whether t falls within any of ranges.
+func isHeld(ranges []heldRange, t time.Time) bool {
+	for _, r := range ranges {
+		if !t.Before(r.start) && !t.After(r.end) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package squid
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// importBatchSize is the number of events Import appends per transaction.
+const importBatchSize = 1000
+
+// maxImportLine bounds the length of a line read by Import.
+const maxImportLine = 16 << 20
+
+// Import appends the events read from r and returns the number appended.
+// JSON input may be an array of events, as written by Export, or one event
+// per line (NDJSON). Logfmt input is read as described for the Logfmt
+// format. Other formats cannot be imported.
+//
+// Events are appended in batches, as by AppendBatch, so they get new IDs
+// unless Options.IDSource provides them. Corrections are changed to
+// supersede the new IDs of the events they correct. If an error occurs, the
+// batches already appended are kept. The context is checked between
+// batches, and can report progress with WithProgress.
+func (db *DB) Import(ctx context.Context, r io.Reader, format ExportFormat) (int64, error) {
+	t := startProgress(ctx, "import")
+	defer t.done()
+	if t != nil {
+		t.total(0, remainingSize(r))
+		r = progressReader{r, t}
+	}
+
+	var imported int64
+	batch := make([]Event, 0, importBatchSize)
+	ids := make(map[ulid.ULID]ulid.ULID) // exported ID -> imported ID
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// AppendBatch sets the new IDs in place
+		exported := make([]ulid.ULID, len(batch))
+		for i := range batch {
+			exported[i] = batch[i].ID
+		}
+		results, err := db.AppendBatch(batch)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			if exported[i] != (ulid.ULID{}) {
+				ids[exported[i]] = result.ID
+			}
+		}
+		imported += int64(len(batch))
+		t.add(int64(len(batch)), 0)
+		batch = batch[:0]
+		return nil
+	}
+	add := func(event Event) error {
+		if event.Supersedes != nil {
+			// The event it corrects must be appended first
+			if slices.ContainsFunc(batch, func(e Event) bool { return e.ID == *event.Supersedes }) {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			if id, ok := ids[*event.Supersedes]; ok {
+				event.Supersedes = &id
+			}
+		}
+		batch = append(batch, event)
+		if len(batch) < importBatchSize {
+			return nil
+		}
+		return flush()
+	}
+
+	var err error
+	switch format {
+	case JSON:
+		err = readJSONEvents(r, add)
+	case Logfmt:
+		err = readLogfmt(r, add)
+	default:
+		return 0, fmt.Errorf("squid: cannot import the %s format", format)
+	}
+	if err == nil {
+		err = flush()
+	}
+	return imported, err
+}
+
+// readJSONEvents calls fn with each event of a JSON array or of a stream of
+// JSON events such as NDJSON.
+func readJSONEvents(r io.Reader, fn func(Event) error) error {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+
+	dec := json.NewDecoder(br)
+	b, _ := br.Peek(1)
+	if b[0] != '[' {
+		for n := 1; ; n++ {
+			var event Event
+			if err := dec.Decode(&event); errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("squid: import event %d: %w", n, err)
+			}
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("squid: import: %w", err)
+	}
+	for n := 1; dec.More(); n++ {
+		var event Event
+		if err := dec.Decode(&event); err != nil {
+			return fmt.Errorf("squid: import event %d: %w", n, err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("squid: import: %w", err)
+	}
+	return nil
+}
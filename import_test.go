@@ -0,0 +1,72 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestImportJSON(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := Open(dir + "/src")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(dir + "/dst")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer dst.Close()
+
+	for i := 0; i < importBatchSize+5; i++ {
+		if _, err := src.Append(Event{Type: "request", Tags: map[string]string{"service": "api"}, Data: map[string]any{"i": float64(i)}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// An exported JSON array imports in batches
+	var buf bytes.Buffer
+	if err := src.Export(context.Background(), &buf, Query{}, JSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	n, err := dst.Import(context.Background(), &buf, JSON)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if n != importBatchSize+5 {
+		t.Errorf("expected %d imported, got %d", importBatchSize+5, n)
+	}
+
+	// So does NDJSON
+	ndjson := `{"type":"error","tags":{"service":"web"},"data":{"code":500}}
+
+{"type":"error","timestamp":"2024-01-01T10:00:00Z"}
+`
+	n, err = dst.Import(context.Background(), strings.NewReader(ndjson), JSON)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 imported, got %d, %v", n, err)
+	}
+
+	events, err := dst.Query(context.Background(), Query{Types: []string{"error"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[1].Tags["service"] != "web" {
+		t.Errorf("unexpected imported events: %+v", events)
+	}
+
+	if _, err := dst.Import(context.Background(), strings.NewReader(`{"type":`), JSON); err == nil {
+		t.Error("expected invalid JSON to be rejected")
+	}
+	if _, err := dst.Import(context.Background(), strings.NewReader(""), CSV); err == nil {
+		t.Error("expected CSV import to be rejected")
+	}
+}
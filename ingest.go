@@ -0,0 +1,268 @@
+package squid
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// IngestMode decides how appends treat events that cannot be stored as
+// given.
+type IngestMode int
+
+const (
+	// Strict rejects invalid events: tags that are too long or not valid
+	// UTF-8, NaN and infinite data values, and unknown top-level fields of
+	// events appended as JSON.
+	Strict IngestMode = iota
+
+	// Lenient stores invalid events after coercing them: invalid tag keys
+	// are dropped, tag values are made valid UTF-8 and truncated to 1 KiB
+	// if they are too long, NaN and infinite values become null, and
+	// unknown top-level fields are moved into the data. The coercions are
+	// listed in the event's "_coerced" data field and in
+	// AppendResult.Coerced. Events with a missing or invalid type are still
+	// rejected.
+	Lenient
+)
+
+// coercedKey is the data field listing the coercions made to an event.
+const coercedKey = "_coerced"
+
+// coercedTagValueLen is the length in bytes that the Lenient mode truncates
+// tag values that are too long to.
+const coercedTagValueLen = 1024
+
+// rawParts holds the parts of an event appended as JSON that are left
+// encoded: its data object and any unknown top-level fields.
+type rawParts struct {
+	data    []byte
+	unknown map[string]json.RawMessage
+}
+
+// ingestMode returns the ingest mode for events of type typ.
+func (db *DB) ingestMode(typ string) IngestMode {
+	if mode, ok := db.opts.IngestModes[typ]; ok {
+		return mode
+	}
+	return db.opts.IngestMode
+}
+
+// checkIngest validates an event about to be appended, coercing it if its
+// type is ingested leniently, and returns the coercions made. raw holds the
+// encoded parts of an event appended as JSON and is nil otherwise; its data
+// is decoded into the event if the data must change.
+func (db *DB) checkIngest(event *Event, raw *rawParts) ([]string, error) {
+	if db.ingestMode(event.Type) == Strict {
+		if err := event.validate(); err != nil {
+			return nil, err
+		}
+		if raw != nil && len(raw.unknown) > 0 {
+			names := slices.Sorted(maps.Keys(raw.unknown))
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidJSON, names[0])
+		}
+		if err := db.applyUnits(event, raw, true); err != nil {
+			return nil, err
+		}
+		if path, ok := findNonFinite(event.Data, ""); ok {
+			return nil, &ValidationError{Field: "data", Value: path, Err: ErrInvalidData}
+		}
+		return nil, nil
+	}
+
+	// The type cannot be coerced without changing what the event is
+	if event.Type == "" {
+		return nil, ErrEmptyType
+	}
+	if err := validateKeyComponent("type", event.Type); err != nil {
+		return nil, err
+	}
+
+	var coerced []string
+	if tags, c := coerceTags(event.Tags); len(c) > 0 {
+		event.Tags = tags
+		coerced = append(coerced, c...)
+	}
+
+	if raw != nil && len(raw.unknown) > 0 {
+		if err := db.decodeRawData(event, raw); err != nil {
+			return nil, err
+		}
+		data := maps.Clone(event.Data)
+		if data == nil {
+			data = make(map[string]any)
+		}
+		for _, name := range slices.Sorted(maps.Keys(raw.unknown)) {
+			if _, ok := data[name]; ok {
+				coerced = append(coerced, fmt.Sprintf("field %s dropped: data has a field of the same name", name))
+				continue
+			}
+			var v any
+			if err := json.Unmarshal(raw.unknown[name], &v); err != nil {
+				return nil, fmt.Errorf("%w: field %s: %v", ErrInvalidJSON, name, err)
+			}
+			data[name] = v
+			coerced = append(coerced, fmt.Sprintf("field %s moved to data", name))
+		}
+		event.Data = data
+	}
+
+	if err := db.applyUnits(event, raw, false); err != nil {
+		return nil, err
+	}
+	if v, c := coerceValue(event.Data, ""); len(c) > 0 {
+		event.Data = v.(map[string]any)
+		coerced = append(coerced, c...)
+	}
+
+	if len(coerced) > 0 {
+		if err := db.decodeRawData(event, raw); err != nil {
+			return nil, err
+		}
+		data := maps.Clone(event.Data)
+		if data == nil {
+			data = make(map[string]any)
+		}
+		data[coercedKey] = coerced
+		event.Data = data
+	}
+	return coerced, nil
+}
+
+// decodeRawData decodes the encoded data of an event appended as JSON into
+// the event, so that it can be changed and encoded again.
+func (db *DB) decodeRawData(event *Event, raw *rawParts) error {
+	if raw == nil || raw.data == nil {
+		return nil
+	}
+	if err := db.codec().Unmarshal(raw.data, &event.Data); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidJSON, err)
+	}
+	raw.data = nil
+	return nil
+}
+
+// coerceTags returns tags with invalid keys dropped and invalid values made
+// valid, and the coercions made. tags is copied rather than modified.
+func coerceTags(tags map[string]string) (map[string]string, []string) {
+	var out map[string]string
+	var coerced []string
+	for _, k := range sortedKeys(tags) {
+		v := tags[k]
+		if k == "" || validateKeyComponent("tag key", k) != nil {
+			if out == nil {
+				out = maps.Clone(tags)
+			}
+			delete(out, k)
+			coerced = append(coerced, fmt.Sprintf("tag %q dropped: invalid key", truncateUTF8(strings.ToValidUTF8(k, "�"), 64)))
+			continue
+		}
+		if validateKeyComponent("tag value", v) != nil {
+			if out == nil {
+				out = maps.Clone(tags)
+			}
+			out[k] = truncateUTF8(strings.ToValidUTF8(v, "�"), coercedTagValueLen)
+			coerced = append(coerced, fmt.Sprintf("tag %s: invalid value replaced", k))
+		}
+	}
+	if out == nil {
+		return tags, nil
+	}
+	return out, coerced
+}
+
+// truncateUTF8 truncates a valid UTF-8 string to at most n bytes without
+// splitting a character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// coerceValue returns v with NaN and infinite numbers replaced by nil, and
+// the coercions made, naming values by their path from the data root. Maps
+// and slices are copied rather than modified.
+func coerceValue(v any, path string) (any, []string) {
+	switch v := v.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, []string{fmt.Sprintf("data %s: %v replaced with null", path, v)}
+		}
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, []string{fmt.Sprintf("data %s: %v replaced with null", path, v)}
+		}
+	case map[string]any:
+		var out map[string]any
+		var coerced []string
+		for _, k := range sortedKeys(v) {
+			cv, c := coerceValue(v[k], joinPath(path, k))
+			if len(c) > 0 {
+				if out == nil {
+					out = maps.Clone(v)
+				}
+				out[k] = cv
+				coerced = append(coerced, c...)
+			}
+		}
+		if out != nil {
+			return out, coerced
+		}
+	case []any:
+		var out []any
+		var coerced []string
+		for i, e := range v {
+			cv, c := coerceValue(e, fmt.Sprintf("%s[%d]", path, i))
+			if len(c) > 0 {
+				if out == nil {
+					out = slices.Clone(v)
+				}
+				out[i] = cv
+				coerced = append(coerced, c...)
+			}
+		}
+		if out != nil {
+			return out, coerced
+		}
+	}
+	return v, nil
+}
+
+// findNonFinite returns the path of the first NaN or infinite number in v.
+func findNonFinite(v any, path string) (string, bool) {
+	switch v := v.(type) {
+	case float64:
+		return path, math.IsNaN(v) || math.IsInf(v, 0)
+	case float32:
+		return path, math.IsNaN(float64(v)) || math.IsInf(float64(v), 0)
+	case map[string]any:
+		for k, e := range v {
+			if p, ok := findNonFinite(e, joinPath(path, k)); ok {
+				return p, true
+			}
+		}
+	case []any:
+		for i, e := range v {
+			if p, ok := findNonFinite(e, fmt.Sprintf("%s[%d]", path, i)); ok {
+				return p, true
+			}
+		}
+	}
+	return "", false
+}
+
+// joinPath appends a field name to a data path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,110 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestIngestStrict(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Append(Event{Type: "metric", Data: map[string]any{"cpu": map[string]any{"load": math.NaN()}}})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrInvalidData) || verr.Value != "cpu.load" {
+		t.Errorf("expected ErrInvalidData for cpu.load, got %v", err)
+	}
+
+	_, err = db.Append(Event{Type: "metric", Tags: map[string]string{"host": strings.Repeat("h", maxKeyComponentLen+1)}})
+	if !errors.Is(err, ErrKeyTooLong) {
+		t.Errorf("expected ErrKeyTooLong, got %v", err)
+	}
+
+	_, err = db.AppendJSON(context.Background(), []byte(`{"type":"metric","level":"info"}`))
+	if !errors.Is(err, ErrInvalidJSON) || !strings.Contains(err.Error(), "level") {
+		t.Errorf("expected ErrInvalidJSON for the unknown field, got %v", err)
+	}
+}
+
+func TestIngestLenient(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{
+		IngestMode:  Lenient,
+		IngestModes: map[string]IngestMode{"audit": Strict},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	tags := map[string]string{
+		"host": strings.Repeat("é", maxKeyComponentLen),
+		strings.Repeat("k", maxKeyComponentLen+1): "v",
+		"service": "api",
+	}
+	data := map[string]any{"load": math.Inf(1), "samples": []any{1.0, math.NaN()}}
+	result, err := db.Append(Event{Type: "metric", Tags: tags, Data: data})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if len(result.Coerced) != 4 {
+		t.Errorf("expected 4 coercions, got %q", result.Coerced)
+	}
+	if len(tags) != 3 || !math.IsInf(data["load"].(float64), 1) {
+		t.Error("the caller's tags and data were modified")
+	}
+
+	got, err := db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if len(got.Tags) != 2 || got.Tags["service"] != "api" || len(got.Tags["host"]) > coercedTagValueLen || !strings.HasPrefix(got.Tags["host"], "éé") {
+		t.Errorf("unexpected coerced tags: %d %q", len(got.Tags), got.Tags["service"])
+	}
+	if got.Data["load"] != nil || got.Data["samples"].([]any)[1] != nil || len(got.Data[coercedKey].([]any)) != 4 {
+		t.Errorf("unexpected coerced data: %+v", got.Data)
+	}
+
+	// Unknown fields of JSON events move into the data
+	result, err = db.AppendJSON(context.Background(), []byte(`{"type":"log","level":"warn","data":{"msg":"disk"}}`))
+	if err != nil {
+		t.Fatalf("AppendJSON failed: %v", err)
+	}
+	got, err = db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Data["level"] != "warn" || got.Data["msg"] != "disk" || len(result.Coerced) != 1 {
+		t.Errorf("unexpected event: %+v, coerced %q", got.Data, result.Coerced)
+	}
+
+	// Valid events are stored as given
+	result, err = db.Append(Event{Type: "metric", Data: map[string]any{"load": 0.5}})
+	if err != nil || result.Coerced != nil {
+		t.Errorf("expected no coercions, got %q, %v", result.Coerced, err)
+	}
+
+	// Per-type overrides
+	_, err = db.Append(Event{Type: "audit", Data: map[string]any{"amount": math.NaN()}})
+	if !errors.Is(err, ErrInvalidData) {
+		t.Errorf("expected audit events to be strict, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package squid
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// IngestErrorType is the type of the events recorded by
+// Options.RecordIngestErrors.
+const IngestErrorType = "squid.ingest_error"
+
+// Values of the "action" tag of ingest error events.
+const (
+	IngestRejected = "rejected" // the event was not stored
+	IngestModified = "modified" // the event was stored with changes
+)
+
+// ingestIssue is an event that the checks of an append rejected or
+// changed.
+type ingestIssue struct {
+	event   Event // the event as given, or as stored if it was modified
+	err     error
+	changes []string
+}
+
+// rejectedIngest returns the issue of an event rejected with err.
+func rejectedIngest(event Event, err error) []ingestIssue {
+	return []ingestIssue{{event: event, err: err}}
+}
+
+// ingestChanges returns the changes the checks of an append made to an
+// event: the coercions of the Lenient mode, a clamped timestamp, and the
+// tags dropped by cardinality limits. given is the event as appended and
+// tags its tags before the limits applied.
+func ingestChanges(given Event, tags map[string]string, result *AppendResult) []string {
+	changes := slices.Clone(result.Coerced)
+	if result.TimestampClamped {
+		changes = append(changes, fmt.Sprintf("timestamp %s clamped to now", given.Timestamp.Format(time.RFC3339Nano)))
+	}
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		if _, ok := result.Tags[k]; !ok {
+			changes = append(changes, fmt.Sprintf("tag %s dropped: cardinality limit", k))
+		}
+	}
+	return changes
+}
+
+// recordIngestErrors stores an ingest error event for each issue if
+// Options.RecordIngestErrors is set. Recording is best effort: the events
+// bypass the checks of appends, and failures are only logged.
+func (db *DB) recordIngestErrors(issues []ingestIssue) {
+	if !db.opts.RecordIngestErrors || len(issues) == 0 {
+		return
+	}
+	if db.watchdog != nil && db.watchdog.rejectWrites() || db.files != nil && db.files.rejectWrites() {
+		return
+	}
+
+	now, done := db.ingest.begin(db.now)
+	defer done()
+	events := make([]Event, len(issues))
+	for i, issue := range issues {
+		events[i] = ingestErrorEvent(issue, now)
+		events[i].ID = db.ulids.New(now)
+	}
+
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		deltas := make(countDeltas)
+		for i := range events {
+			meta, data, err := db.encodeEvent(&events[i])
+			if err != nil {
+				return err
+			}
+			if _, _, err := db.writeEvent(txn, &events[i], meta, data); err != nil {
+				return err
+			}
+			deltas.add(IngestErrorType, events[i].ID, 1)
+		}
+		return db.counts.write(txn, deltas)
+	})
+	if err != nil {
+		if db.opts.Logger != nil {
+			db.opts.Logger.Warningf("squid: recording ingest errors: %v", err)
+		}
+		return
+	}
+
+	db.appended(events, nil)
+}
+
+// ingestErrorEvent returns the event recording an issue. Its tags hold the
+// action and the type of the event if that is a valid tag value; its data
+// the error or changes, and the ID of a modified event or the timestamp and
+// tags of a rejected one.
+func ingestErrorEvent(issue ingestIssue, now time.Time) Event {
+	e := issue.event
+	event := Event{
+		Type:       IngestErrorType,
+		Timestamp:  now,
+		IngestedAt: now,
+		Version:    1,
+		Tags:       map[string]string{"action": IngestModified},
+		Data:       map[string]any{"event_type": e.Type},
+	}
+	if e.Type != "" && validateKeyComponent("type", e.Type) == nil {
+		event.Tags["event_type"] = e.Type
+	}
+
+	if issue.err == nil {
+		event.Data["event_id"] = e.ID.String()
+		event.Data["changes"] = issue.changes
+		return event
+	}
+
+	event.Tags["action"] = IngestRejected
+	event.Data["error"] = issue.err.Error()
+	if !e.Timestamp.IsZero() {
+		event.Data["timestamp"] = e.Timestamp.Format(time.RFC3339Nano)
+	}
+	if len(e.Tags) > 0 {
+		event.Data["tags"] = e.Tags
+	}
+	return event
+}
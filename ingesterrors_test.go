@@ -0,0 +1,143 @@
+package squid
+
+import (
+	"context"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecordIngestErrors(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	db, err := OpenWithOptions(dir, Options{
+		IngestModes:           map[string]IngestMode{"metric": Lenient},
+		MaxFutureDrift:        time.Minute,
+		FutureTimestampAction: ClampTimestamp,
+		MaxTagValuesPerKey:    1,
+		CardinalityAction:     DropTag,
+		RecordIngestErrors:    true,
+		Now:                   func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	errorsOf := func(action string) []*Event {
+		t.Helper()
+		events, err := db.Query(ctx, Query{Types: []string{IngestErrorType}, Tags: map[string]string{"action": action}})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		return events
+	}
+
+	// Rejected by the Strict mode, alone and in a batch
+	if _, err := db.Append(Event{Type: "audit", Tags: map[string]string{"user": strings.Repeat("u", maxKeyComponentLen+1)}}); err == nil {
+		t.Fatal("expected the append to be rejected")
+	}
+	if _, err := db.AppendBatch([]Event{{Type: "audit"}, {Type: "audit", Data: map[string]any{"n": math.Inf(1)}}}); err == nil {
+		t.Fatal("expected the batch to be rejected")
+	}
+
+	rejected := errorsOf(IngestRejected)
+	if len(rejected) != 2 {
+		t.Fatalf("expected 2 rejections, got %d", len(rejected))
+	}
+	for _, e := range rejected {
+		if e.Tags["event_type"] != "audit" || e.Data["error"] == "" {
+			t.Errorf("unexpected rejection %v %v", e.Tags, e.Data)
+		}
+	}
+	if want := `squid: invalid data value: data "n"`; rejected[1].Data["error"] != want {
+		t.Errorf("expected %q for the batch's second event, got %v", want, rejected[1].Data["error"])
+	}
+
+	// Coerced, clamped and stripped of a tag
+	if _, err := db.Append(Event{Type: "host", Tags: map[string]string{"region": "eu"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	result, err := db.Append(Event{
+		Type:      "metric",
+		Timestamp: now.Add(time.Hour),
+		Tags:      map[string]string{"region": "us"},
+		Data:      map[string]any{"load": math.NaN()},
+	})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	modified := errorsOf(IngestModified)
+	if len(modified) != 1 {
+		t.Fatalf("expected 1 modification, got %d", len(modified))
+	}
+	e := modified[0]
+	if e.Tags["event_type"] != "metric" || e.Data["event_id"] != result.ID.String() {
+		t.Errorf("unexpected modification %v %v", e.Tags, e.Data)
+	}
+	changes, _ := e.Data["changes"].([]any)
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %v", e.Data["changes"])
+	}
+	if !strings.Contains(changes[1].(string), "clamped") || changes[2] != "tag region dropped: cardinality limit" {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	// Transactional appends are recorded alike, their changes once committed
+	var txResult *AppendResult
+	err = db.Tx(func(tx *Tx) error {
+		if _, err := tx.Append(Event{Type: "audit", Data: map[string]any{"n": math.Inf(1)}}); err == nil {
+			t.Error("expected the transactional append to be rejected")
+		}
+		var err error
+		txResult, err = tx.Append(Event{Type: "metric", Data: map[string]any{"load": math.NaN()}})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Tx failed: %v", err)
+	}
+	if !txResult.Timestamp.Equal(txResult.IngestedAt) {
+		t.Errorf("expected the ingest time as the timestamp, got %v and %v", txResult.Timestamp, txResult.IngestedAt)
+	}
+	if n := len(errorsOf(IngestRejected)); n != 3 {
+		t.Errorf("expected 3 rejections, got %d", n)
+	}
+	if n := len(errorsOf(IngestModified)); n != 2 {
+		t.Errorf("expected 2 modifications, got %d", n)
+	}
+
+	// Valid events are not recorded: 3 events and 5 ingest errors
+	if count, _ := db.Count(); count != 8 {
+		t.Errorf("expected 8 events, got %d", count)
+	}
+}
+
+func TestRecordIngestErrorsDisabled(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Append(Event{}); err == nil {
+		t.Fatal("expected the append to be rejected")
+	}
+	if count, _ := db.Count(); count != 0 {
+		t.Errorf("expected no events, got %d", count)
+	}
+}
@@ -0,0 +1,136 @@
+package squid
+
+import (
+	"context"
+	"slices"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// exactTags returns the sorted keys of the query's tag filters that match
+// a single value, whose index entries are in ID order.
+func exactTags(q Query) []string {
+	var keys []string
+	for k, v := range q.Tags {
+		if !isTagPattern(v) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// indexCursor is a position in the index entries under a prefix, which end
+// with the IDs of their events.
+type indexCursor struct {
+	it     *badger.Iterator
+	prefix []byte
+	id     ulid.ULID
+}
+
+// scanTagIntersection returns the IDs of the events that have every tag of
+// the query's exact tag filters, in the query's order. The indices of the
+// tags are merge-joined: each one skips ahead to the furthest ID any other
+// is at, so a selective tag bounds the entries read from the others.
+func (db *DB) scanTagIntersection(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false // Index keys have no values
+	opts.Reverse = q.Descending
+
+	keys := exactTags(q)
+	cursors := make([]*indexCursor, len(keys))
+	for i, k := range keys {
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		cursors[i] = &indexCursor{it: it, prefix: encodeTagIndexPrefix(k, q.Tags[k])}
+	}
+
+	// Without other filters, every ID of the intersection matches
+	limit := q.scanLimit()
+	rest := q
+	rest.Tags = nil
+	if len(keys) < len(q.Tags) || needsFilter(rest, false) {
+		limit = 0
+	}
+
+	var ids []ulid.ULID
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+
+	// advance decodes the entry a cursor is at, skipping corrupt ones,
+	// and reports false once its index has no more entries
+	advance := func(c *indexCursor) bool {
+		for ; c.it.ValidForPrefix(c.prefix); c.it.Next() {
+			scanned++
+			id, err := decodeIndexKey(c.it.Item().Key())
+			if err != nil {
+				db.recordCorrupt(ctx)
+				continue
+			}
+			c.id = id
+			return true
+		}
+		return false
+	}
+
+	for _, c := range cursors {
+		start := c.prefix
+		if q.Descending {
+			start = prefixEnd(c.prefix)
+		}
+		if c.it.Seek(start); !advance(c) {
+			return ids, nil
+		}
+	}
+
+	for rounds := 0; ; rounds++ {
+		if rounds%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return ids, err
+			}
+		}
+
+		// The furthest ID is the first any other cursor may also be at
+		furthest := cursors[0].id
+		for _, c := range cursors[1:] {
+			cmp := c.id.Compare(furthest)
+			if q.Descending {
+				cmp = -cmp
+			}
+			if cmp > 0 {
+				furthest = c.id
+			}
+		}
+		if pastRange(furthest, q) {
+			return ids, nil
+		}
+
+		joined := true
+		for _, c := range cursors {
+			if c.id == furthest {
+				continue
+			}
+			joined = false
+			if c.it.Seek(append(slices.Clip(c.prefix), furthest[:]...)); !advance(c) {
+				return ids, nil
+			}
+		}
+		if !joined {
+			continue
+		}
+
+		if db.matchesTimeRange(furthest, q) {
+			ids = append(ids, furthest)
+			if limit > 0 && len(ids) >= limit {
+				return ids, nil
+			}
+		}
+
+		for _, c := range cursors {
+			if c.it.Next(); !advance(c) {
+				return ids, nil
+			}
+		}
+	}
+}
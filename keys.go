@@ -1,91 +1,299 @@
 package squid
 
 import (
+	"encoding/binary"
+	"time"
+
 	"github.com/oklog/ulid/v2"
 )
 
 // Key prefixes for different record types in BadgerDB.
+//
+// Keys use the v2 layout: ULIDs are stored as 16 raw bytes and variable-length
+// components are prefixed with a 2-byte big-endian length, so types, tag keys
+// and tag values may contain any byte (including ':' and '=').
 const (
-	prefixEvent = "e:" // Primary event storage
-	prefixTag   = "t:" // Tag index: t:<key>=<value>:<ulid>
-	prefixType  = "y:" // Type index: y:<type>:<ulid>
-	eventKeyLen = len(prefixEvent) + 26
+	prefixEvent      = "E:" // Primary event storage: E:<ulid>
+	prefixData       = "D:" // Event data payloads: D:<ulid>
+	prefixBlob       = "P:" // Deduplicated data payloads: P:<sha256>
+	prefixRef        = "R:" // Deduplicated payload references: R:<sha256><ulid>
+	prefixTag        = "T:" // Tag index: T:<len><key><len><value><ulid>
+	prefixType       = "Y:" // Type index: Y:<len><type><ulid>
+	prefixMeta       = "M:" // Store metadata: M:<name>
+	prefixCount      = "H:" // Hourly counts: H:<len><type><hour><ulid>
+	prefixIngest     = "I:" // Ingest time index: I:<unix nanos><ulid>
+	prefixSearch     = "S:" // Search index: S:<word>\x00<ulid>
+	prefixCorrection = "C:" // Corrections: C:<superseded ulid> -> <ulid>
+
+	ulidLen     = len(ulid.ULID{})
+	lenPrefix   = 2
+	hourLen     = 8
+	eventKeyLen = len(prefixEvent) + ulidLen
+
+	// maxKeyComponentLen is the longest type, tag key or tag value that fits
+	// in a length-prefixed key component.
+	maxKeyComponentLen = 1<<16 - 1
 )
 
+// appendComponent appends a length-prefixed component to key.
+func appendComponent(key []byte, s string) []byte {
+	key = binary.BigEndian.AppendUint16(key, uint16(len(s)))
+	return append(key, s...)
+}
+
+// readComponent reads a length-prefixed component from the start of b.
+// It returns the component and the remaining bytes.
+func readComponent(b []byte) (string, []byte, bool) {
+	if len(b) < lenPrefix {
+		return "", nil, false
+	}
+	n := int(binary.BigEndian.Uint16(b))
+	b = b[lenPrefix:]
+	if len(b) < n {
+		return "", nil, false
+	}
+	return string(b[:n]), b[n:], true
+}
+
 // encodeEventKey creates a primary event key from a ULID.
-// Format: e:<ulid>
+// Format: E:<ulid>
 func encodeEventKey(id ulid.ULID) []byte {
 	key := make([]byte, 0, eventKeyLen)
 	key = append(key, prefixEvent...)
-	key = append(key, id.String()...)
+	key = append(key, id[:]...)
+	return key
+}
+
+// encodeDataKey creates the key of an event's data payload from its ULID.
+// Format: D:<ulid>
+func encodeDataKey(id ulid.ULID) []byte {
+	key := make([]byte, 0, eventKeyLen)
+	key = append(key, prefixData...)
+	key = append(key, id[:]...)
 	return key
 }
 
+// encodePayloadKey creates the key of a deduplicated payload.
+// Format: P:<sha256>
+func encodePayloadKey(hash payloadHash) []byte {
+	key := make([]byte, 0, len(prefixBlob)+len(hash))
+	key = append(key, prefixBlob...)
+	return append(key, hash[:]...)
+}
+
+// encodePayloadRefPrefix creates the prefix of the references to a payload.
+// Format: R:<sha256>
+func encodePayloadRefPrefix(hash payloadHash) []byte {
+	key := make([]byte, 0, len(prefixRef)+len(hash)+ulidLen)
+	key = append(key, prefixRef...)
+	return append(key, hash[:]...)
+}
+
+// encodePayloadRefKey creates the key recording that an event refers to a payload.
+// Format: R:<sha256><ulid>
+func encodePayloadRefKey(hash payloadHash, id ulid.ULID) []byte {
+	return append(encodePayloadRefPrefix(hash), id[:]...)
+}
+
 // decodeEventKey extracts the ULID from a primary event key.
 func decodeEventKey(key []byte) (ulid.ULID, error) {
-	if len(key) < eventKeyLen {
-		return ulid.ULID{}, ErrNotFound
+	if len(key) != eventKeyLen {
+		return ulid.ULID{}, ErrInvalidKey
 	}
-	return ulid.ParseStrict(string(key[len(prefixEvent):]))
+	var id ulid.ULID
+	copy(id[:], key[len(prefixEvent):])
+	return id, nil
 }
 
 // encodeTagIndexKey creates a tag index key.
-// Format: t:<key>=<value>:<ulid>
+// Format: T:<len><key><len><value><ulid>
 func encodeTagIndexKey(tagKey, tagValue string, id ulid.ULID) []byte {
-	// t: + key + = + value + : + ulid(26)
-	key := make([]byte, 0, len(prefixTag)+len(tagKey)+1+len(tagValue)+1+26)
-	key = append(key, prefixTag...)
-	key = append(key, tagKey...)
-	key = append(key, '=')
-	key = append(key, tagValue...)
-	key = append(key, ':')
-	key = append(key, id.String()...)
-	return key
+	key := encodeTagIndexPrefix(tagKey, tagValue)
+	return append(key, id[:]...)
 }
 
 // encodeTagIndexPrefix creates a prefix for scanning all events with a specific tag.
-// Format: t:<key>=<value>:
+// Format: T:<len><key><len><value>
 func encodeTagIndexPrefix(tagKey, tagValue string) []byte {
-	prefix := make([]byte, 0, len(prefixTag)+len(tagKey)+1+len(tagValue)+1)
+	prefix := make([]byte, 0, len(prefixTag)+2*lenPrefix+len(tagKey)+len(tagValue)+ulidLen)
 	prefix = append(prefix, prefixTag...)
-	prefix = append(prefix, tagKey...)
-	prefix = append(prefix, '=')
-	prefix = append(prefix, tagValue...)
-	prefix = append(prefix, ':')
+	prefix = appendComponent(prefix, tagKey)
+	prefix = appendComponent(prefix, tagValue)
 	return prefix
 }
 
+// encodeTagKeyPrefix creates a prefix for scanning all events with a tag,
+// whatever its value.
+// Format: T:<len><key>
+func encodeTagKeyPrefix(tagKey string) []byte {
+	prefix := make([]byte, 0, len(prefixTag)+lenPrefix+len(tagKey))
+	prefix = append(prefix, prefixTag...)
+	return appendComponent(prefix, tagKey)
+}
+
+// decodeTagIndexKey extracts the tag key, tag value and ULID from a tag index key.
+func decodeTagIndexKey(key []byte) (string, string, ulid.ULID, error) {
+	if len(key) < len(prefixTag) || string(key[:len(prefixTag)]) != prefixTag {
+		return "", "", ulid.ULID{}, ErrInvalidKey
+	}
+	tagKey, rest, ok := readComponent(key[len(prefixTag):])
+	if !ok {
+		return "", "", ulid.ULID{}, ErrInvalidKey
+	}
+	tagValue, rest, ok := readComponent(rest)
+	if !ok || len(rest) != ulidLen {
+		return "", "", ulid.ULID{}, ErrInvalidKey
+	}
+	var id ulid.ULID
+	copy(id[:], rest)
+	return tagKey, tagValue, id, nil
+}
+
 // decodeIndexKey extracts the ULID from an index key (works for both tag and type indices).
-// The ULID is always the last 26 characters of the key.
+// The ULID is always the last 16 bytes of the key.
 func decodeIndexKey(key []byte) (ulid.ULID, error) {
-	if len(key) < 26 {
-		return ulid.ULID{}, ErrNotFound
+	if len(key) < ulidLen {
+		return ulid.ULID{}, ErrInvalidKey
 	}
-	return ulid.ParseStrict(string(key[len(key)-26:]))
+	var id ulid.ULID
+	copy(id[:], key[len(key)-ulidLen:])
+	return id, nil
 }
 
 // encodeTypeIndexKey creates a type index key.
-// Format: y:<type>:<ulid>
+// Format: Y:<len><type><ulid>
 func encodeTypeIndexKey(eventType string, id ulid.ULID) []byte {
-	key := make([]byte, 0, len(prefixType)+len(eventType)+1+26)
-	key = append(key, prefixType...)
-	key = append(key, eventType...)
-	key = append(key, ':')
-	key = append(key, id.String()...)
-	return key
+	key := encodeTypeIndexPrefix(eventType)
+	return append(key, id[:]...)
 }
 
 // encodeTypeIndexPrefix creates a prefix for scanning all events of a specific type.
-// Format: y:<type>:
+// Format: Y:<len><type>
 func encodeTypeIndexPrefix(eventType string) []byte {
-	prefix := make([]byte, 0, len(prefixType)+len(eventType)+1)
+	prefix := make([]byte, 0, len(prefixType)+lenPrefix+len(eventType)+ulidLen)
 	prefix = append(prefix, prefixType...)
-	prefix = append(prefix, eventType...)
-	prefix = append(prefix, ':')
+	prefix = appendComponent(prefix, eventType)
 	return prefix
 }
 
+// decodeTypeIndexKey extracts the event type and ULID from a type index key.
+func decodeTypeIndexKey(key []byte) (string, ulid.ULID, error) {
+	if len(key) < len(prefixType) || string(key[:len(prefixType)]) != prefixType {
+		return "", ulid.ULID{}, ErrInvalidKey
+	}
+	eventType, rest, ok := readComponent(key[len(prefixType):])
+	if !ok || len(rest) != ulidLen {
+		return "", ulid.ULID{}, ErrInvalidKey
+	}
+	var id ulid.ULID
+	copy(id[:], rest)
+	return eventType, id, nil
+}
+
+// encodeCountKey creates an hourly count key. Each key holds a delta of the
+// number of events of a type stored in an hour; suffix makes it unique.
+// Format: H:<len><type><hour><ulid>
+func encodeCountKey(eventType string, hour int64, suffix ulid.ULID) []byte {
+	key := encodeCountPrefix(eventType)
+	key = binary.BigEndian.AppendUint64(key, uint64(hour))
+	return append(key, suffix[:]...)
+}
+
+// encodeCountPrefix creates a prefix for scanning the hourly counts of a type.
+// Format: H:<len><type>
+func encodeCountPrefix(eventType string) []byte {
+	prefix := make([]byte, 0, len(prefixCount)+lenPrefix+len(eventType)+hourLen+ulidLen)
+	prefix = append(prefix, prefixCount...)
+	prefix = appendComponent(prefix, eventType)
+	return prefix
+}
+
+// decodeCountKey extracts the event type and hour from an hourly count key.
+func decodeCountKey(key []byte) (string, int64, error) {
+	if len(key) < len(prefixCount) || string(key[:len(prefixCount)]) != prefixCount {
+		return "", 0, ErrInvalidKey
+	}
+	eventType, rest, ok := readComponent(key[len(prefixCount):])
+	if !ok || len(rest) != hourLen+ulidLen {
+		return "", 0, ErrInvalidKey
+	}
+	return eventType, int64(binary.BigEndian.Uint64(rest)), nil
+}
+
+// encodeIngestIndexKey creates an ingest time index key.
+// Format: I:<unix nanos><ulid>
+func encodeIngestIndexKey(t time.Time, id ulid.ULID) []byte {
+	key := make([]byte, 0, len(prefixIngest)+8+ulidLen)
+	key = append(key, prefixIngest...)
+	key = binary.BigEndian.AppendUint64(key, uint64(t.UnixNano()))
+	return append(key, id[:]...)
+}
+
+// decodeIngestIndexKey extracts the ingest time and ULID from an ingest time
+// index key.
+func decodeIngestIndexKey(key []byte) (time.Time, ulid.ULID, error) {
+	if len(key) != len(prefixIngest)+8+ulidLen || string(key[:len(prefixIngest)]) != prefixIngest {
+		return time.Time{}, ulid.ULID{}, ErrInvalidKey
+	}
+	nanos := int64(binary.BigEndian.Uint64(key[len(prefixIngest):]))
+	var id ulid.ULID
+	copy(id[:], key[len(prefixIngest)+8:])
+	return time.Unix(0, nanos), id, nil
+}
+
+// encodeSearchIndexKey creates a search index key. Words are letters and
+// digits only, so unlike other components they end with a zero byte
+// rather than being length-prefixed, which keeps the entries of words
+// sharing a prefix together.
+// Format: S:<word>\x00<ulid>
+func encodeSearchIndexKey(word string, id ulid.ULID) []byte {
+	key := encodeSearchIndexPrefix(word, true)
+	return append(key, id[:]...)
+}
+
+// encodeSearchIndexPrefix creates a prefix for scanning the events with a
+// word, or with any word it starts if whole is not set.
+// Format: S:<word>\x00 or S:<word>
+func encodeSearchIndexPrefix(word string, whole bool) []byte {
+	prefix := make([]byte, 0, len(prefixSearch)+len(word)+1+ulidLen)
+	prefix = append(prefix, prefixSearch...)
+	prefix = append(prefix, word...)
+	if whole {
+		prefix = append(prefix, 0)
+	}
+	return prefix
+}
+
+// decodeSearchIndexKey extracts the word and ULID from a search index key.
+func decodeSearchIndexKey(key []byte) (string, ulid.ULID, error) {
+	n := len(key) - ulidLen - 1
+	if n < len(prefixSearch) || string(key[:len(prefixSearch)]) != prefixSearch || key[n] != 0 {
+		return "", ulid.ULID{}, ErrInvalidKey
+	}
+	var id ulid.ULID
+	copy(id[:], key[n+1:])
+	return string(key[len(prefixSearch):n]), id, nil
+}
+
+// encodeMetaKey creates a store metadata key.
+// Format: M:<name>
+func encodeMetaKey(name string) []byte {
+	key := make([]byte, 0, len(prefixMeta)+len(name))
+	key = append(key, prefixMeta...)
+	key = append(key, name...)
+	return key
+}
+
 // eventKeyPrefix returns the prefix for all event keys.
 func eventKeyPrefix() []byte {
 	return []byte(prefixEvent)
 }
+
+// encodeCorrectionKey creates the key holding the ID of the correction that
+// supersedes an event.
+// Format: C:<superseded ulid>
+func encodeCorrectionKey(superseded ulid.ULID) []byte {
+	key := make([]byte, 0, len(prefixCorrection)+ulidLen)
+	key = append(key, prefixCorrection...)
+	return append(key, superseded[:]...)
+}
@@ -0,0 +1,259 @@
+package squid
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// metaLegalHoldPrefix is the prefix of the metadata records of legal holds.
+const metaLegalHoldPrefix = "legalhold/"
+
+// encodeLegalHoldKey creates the key of a legal hold.
+// Format: M:legalhold/<name>
+func encodeLegalHoldKey(name string) []byte {
+	return encodeMetaKey(metaLegalHoldPrefix + name)
+}
+
+// LegalHold preserves the events it covers for legal proceedings such as
+// e-discovery. Retention policies, DeleteBefore and the disk watchdog's
+// emergency deletions keep every covered event until the hold is released,
+// and log how many events each hold kept. Update and UpdateMetadata refuse
+// to rewrite covered events with ErrLegalHold. Holds are stored in the
+// database, so they survive restarts.
+type LegalHold struct {
+	// Name identifies the hold, such as a case number.
+	Name string `json:"name"`
+
+	// Reason records why the events are held.
+	Reason string `json:"reason,omitempty"`
+
+	// Namespace restricts the hold to the events of a namespace, those
+	// whose NamespaceTag is the namespace name (empty means all events).
+	Namespace string `json:"namespace,omitempty"`
+
+	// Types restricts the hold to events of the given types
+	// (empty means all types).
+	Types []string `json:"types,omitempty"`
+
+	// Start and End restrict the hold to events timestamped in the range
+	// (inclusive, nil means unbounded).
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+
+	// PlacedAt is when the hold was placed. It is set by PlaceLegalHold.
+	PlacedAt time.Time `json:"placed_at"`
+}
+
+// covers reports whether the hold covers the event with the given ID.
+func (h *LegalHold) covers(id ulid.ULID, event *Event) bool {
+	t := ulidTime(id)
+	if h.Start != nil && t.Before(*h.Start) || h.End != nil && t.After(*h.End) {
+		return false
+	}
+	if h.Namespace != "" && event.Tags[NamespaceTag] != h.Namespace {
+		return false
+	}
+	return len(h.Types) == 0 || slices.Contains(h.Types, event.Type)
+}
+
+// legalHoldSet holds the legal holds placed on a database, loaded on Open.
+type legalHoldSet struct {
+	mu    sync.RWMutex
+	holds map[string]LegalHold
+}
+
+// load reads the stored legal holds.
+func (s *legalHoldSet) load(bdb *badger.DB) error {
+	holds := make(map[string]LegalHold)
+	err := bdb.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := encodeLegalHoldKey("")
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var h LegalHold
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &h)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to load legal hold %q: %w", it.Item().Key()[len(prefix):], err)
+			}
+			holds[h.Name] = h
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.holds = holds
+	s.mu.Unlock()
+	return nil
+}
+
+// list returns the holds sorted by name.
+func (s *legalHoldSet) list() []LegalHold {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	holds := make([]LegalHold, 0, len(s.holds))
+	for _, h := range s.holds {
+		holds = append(holds, h)
+	}
+	sort.Slice(holds, func(i, j int) bool { return holds[i].Name < holds[j].Name })
+	return holds
+}
+
+// holding returns the name of a hold covering the event with the given ID,
+// or "" if none does.
+func (s *legalHoldSet) holding(id ulid.ULID, event *Event) string {
+	for _, h := range s.list() {
+		if h.covers(id, event) {
+			return h.Name
+		}
+	}
+	return ""
+}
+
+// PlaceLegalHold stores a legal hold, replacing any hold with the same name.
+// Deletions that start after it returns keep the events it covers.
+func (db *DB) PlaceLegalHold(hold LegalHold) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if hold.Name == "" || len(hold.Name) > maxMetaKeyLen {
+		return fmt.Errorf("%w: name of %d bytes, expected 1 to %d", ErrInvalidLegalHold, len(hold.Name), maxMetaKeyLen)
+	}
+	if hold.Start != nil && hold.End != nil && hold.End.Before(*hold.Start) {
+		return fmt.Errorf("%w: end %s is before start %s", ErrInvalidLegalHold, hold.End, hold.Start)
+	}
+	hold.Types = slices.Clone(hold.Types)
+	hold.PlacedAt = db.now()
+
+	val, err := json.Marshal(hold)
+	if err != nil {
+		return err
+	}
+
+	s := &db.legalHolds
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = db.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(encodeLegalHoldKey(hold.Name), val)
+	})
+	if err != nil {
+		return err
+	}
+	s.holds[hold.Name] = hold
+
+	if db.opts.Logger != nil {
+		db.opts.Logger.Infof("squid: legal hold %q placed", hold.Name)
+	}
+	return nil
+}
+
+// ReleaseLegalHold removes the named legal hold. Events it kept are deleted
+// by the next cleanup of the retention policy that expires them.
+func (db *DB) ReleaseLegalHold(name string) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	s := &db.legalHolds
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.holds[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrLegalHoldNotFound, name)
+	}
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		return txn.Delete(encodeLegalHoldKey(name))
+	})
+	if err != nil {
+		return err
+	}
+	delete(s.holds, name)
+
+	if db.opts.Logger != nil {
+		db.opts.Logger.Infof("squid: legal hold %q released", name)
+	}
+	return nil
+}
+
+// LegalHolds returns the legal holds placed on the database, sorted by name.
+func (db *DB) LegalHolds() []LegalHold {
+	return db.legalHolds.list()
+}
+
+// deletionGuard decides which events a deletion keeps: those timestamped in
+// ranges held by HoldRetention and those covered by legal holds. It counts
+// the events each legal hold kept, so that the deletion can log them.
+type deletionGuard struct {
+	held  []heldRange
+	legal []LegalHold
+	kept  map[string]int64
+
+	// last is the highest ID counted. Deletions in batches scan the events
+	// kept by earlier batches again, in ID order, without counting them.
+	last ulid.ULID
+}
+
+// newDeletionGuard returns a guard for a deletion. Retention holds are
+// only honoured if retentionHolds is set.
+func (db *DB) newDeletionGuard(retentionHolds bool) *deletionGuard {
+	g := &deletionGuard{legal: db.legalHolds.list(), kept: make(map[string]int64)}
+	if retentionHolds {
+		g.held = db.retention.heldRanges()
+	}
+	return g
+}
+
+// heldAt reports whether events timestamped t are kept by a retention hold.
+func (g *deletionGuard) heldAt(t time.Time) bool {
+	return isHeld(g.held, t)
+}
+
+// keeps reports whether the event with the given ID must not be deleted.
+func (g *deletionGuard) keeps(id ulid.ULID, event *Event) bool {
+	if g.heldAt(ulidTime(id)) {
+		return true
+	}
+	for i := range g.legal {
+		if g.legal[i].covers(id, event) {
+			if id.Compare(g.last) > 0 {
+				g.kept[g.legal[i].Name]++
+				g.last = id
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// logKept logs the events kept by each legal hold during a deletion.
+func (db *DB) logKept(g *deletionGuard) {
+	if db.opts.Logger == nil {
+		return
+	}
+	for _, h := range g.legal {
+		if n := g.kept[h.Name]; n > 0 {
+			db.opts.Logger.Warningf("squid: legal hold %q kept %d events from deletion", h.Name, n)
+		}
+	}
+}
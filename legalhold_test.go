@@ -0,0 +1,110 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLegalHold(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger := &recordingLogger{}
+	db, err := OpenWithOptions(dir, Options{Logger: logger})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		event := Event{
+			Timestamp: base.Add(time.Duration(i) * time.Hour),
+			Type:      []string{"request", "error"}[i%2],
+			Tags:      map[string]string{NamespaceTag: []string{"acme", "other"}[i/3]},
+		}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	if err := db.PlaceLegalHold(LegalHold{Reason: "unnamed"}); !errors.Is(err, ErrInvalidLegalHold) {
+		t.Errorf("expected ErrInvalidLegalHold, got %v", err)
+	}
+
+	// The acme errors, and everything from 14:00
+	if err := db.PlaceLegalHold(LegalHold{Name: "case-42", Namespace: "acme", Types: []string{"error"}}); err != nil {
+		t.Fatalf("PlaceLegalHold failed: %v", err)
+	}
+	start := base.Add(4 * time.Hour)
+	if err := db.PlaceLegalHold(LegalHold{Name: "case-7", Start: &start}); err != nil {
+		t.Fatalf("PlaceLegalHold failed: %v", err)
+	}
+
+	deleted, err := db.DeleteBefore(base.Add(6 * time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected 3 deleted around the holds, got %d", deleted)
+	}
+	for _, want := range []string{
+		`squid: legal hold "case-42" kept 1 events from deletion`,
+		`squid: legal hold "case-7" kept 2 events from deletion`,
+	} {
+		if !slices.Contains(logger.warnings, want) {
+			t.Errorf("expected warning %q, got %v", want, logger.warnings)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Holds survive restarts and keep events from the disk watchdog too
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	holds := db.LegalHolds()
+	if len(holds) != 2 || holds[0].Name != "case-42" || holds[1].Start == nil || holds[1].PlacedAt.IsZero() {
+		t.Fatalf("unexpected holds %+v", holds)
+	}
+	if deleted, err := db.deleteOldest(10); err != nil || deleted != 0 {
+		t.Errorf("expected nothing deleted, got %d, %v", deleted, err)
+	}
+
+	// Held events cannot be rewritten
+	held, err := db.Query(context.Background(), Query{Types: []string{"error"}, Limit: 1})
+	if err != nil || len(held) != 1 {
+		t.Fatalf("Query failed: %v", err)
+	}
+	held[0].Data = map[string]any{"redacted": true}
+	if _, err := db.Update(*held[0]); !errors.Is(err, ErrLegalHold) {
+		t.Errorf("expected ErrLegalHold, got %v", err)
+	}
+	held[0].Type = "request"
+	if _, err := db.UpdateMetadata(*held[0]); !errors.Is(err, ErrLegalHold) {
+		t.Errorf("expected ErrLegalHold, got %v", err)
+	}
+
+	if err := db.ReleaseLegalHold("case-7"); err != nil {
+		t.Fatalf("ReleaseLegalHold failed: %v", err)
+	}
+	if err := db.ReleaseLegalHold("case-7"); !errors.Is(err, ErrLegalHoldNotFound) {
+		t.Errorf("expected ErrLegalHoldNotFound, got %v", err)
+	}
+	if deleted, err := db.DeleteBefore(base.Add(6 * time.Hour)); err != nil || deleted != 2 {
+		t.Errorf("expected the 2 released events deleted, got %d, %v", deleted, err)
+	}
+	if count, _ := db.Count(); count != 1 {
+		t.Errorf("expected the acme error to remain, got %d events", count)
+	}
+}
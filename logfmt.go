@@ -0,0 +1,308 @@
+package squid
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLogfmtType is the type of imported logfmt lines without a type or event key.
+const defaultLogfmtType = "log"
+
+// Prefixes that mark logfmt keys as tags or data fields explicitly.
+const (
+	logfmtTagPrefix  = "tag."
+	logfmtDataPrefix = "data."
+)
+
+// logfmtPair is a key and value of a logfmt line. Keys without a value are
+// flags.
+type logfmtPair struct {
+	key, value string
+	flag       bool
+}
+
+// parseLogfmt splits a logfmt line into its key-value pairs, in order.
+// Values may be quoted with Go string escapes.
+func parseLogfmt(line string) ([]logfmtPair, error) {
+	var pairs []logfmtPair
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			return pairs, nil
+		}
+
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' && line[i] != '"' {
+			i++
+		}
+		key := line[start:i]
+		if key == "" {
+			return nil, fmt.Errorf("expected a key at column %d", start+1)
+		}
+		if i == len(line) || line[i] != '=' {
+			if i < len(line) && line[i] == '"' {
+				return nil, fmt.Errorf("unexpected quote at column %d", i+1)
+			}
+			pairs = append(pairs, logfmtPair{key: key, flag: true})
+			continue
+		}
+		i++ // '='
+
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("unterminated quoted value for %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value for %s: %w", key, err)
+			}
+			pairs = append(pairs, logfmtPair{key: key, value: value})
+			i = end + 1
+			continue
+		}
+
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		pairs = append(pairs, logfmtPair{key: key, value: line[start:i]})
+	}
+}
+
+// logfmtEvent builds an event from the pairs of a logfmt line. ts, time or
+// timestamp hold the RFC 3339 timestamp, type or event the type, and id is
+// ignored. Other keys are guessed to be tags or data fields, see
+// classifyLogfmt.
+func logfmtEvent(pairs []logfmtPair) (Event, error) {
+	var event Event
+	for _, p := range pairs {
+		switch p.key {
+		case "ts", "time", "timestamp":
+			t, err := time.Parse(time.RFC3339Nano, p.value)
+			if err != nil {
+				return Event{}, fmt.Errorf("invalid timestamp: %w", err)
+			}
+			event.Timestamp = t
+			continue
+		case "type", "event":
+			event.Type = p.value
+			continue
+		case "id":
+			continue
+		}
+
+		if p.flag {
+			setData(&event, p.key, true)
+			continue
+		}
+
+		key, value, isTag := classifyLogfmt(p.key, p.value)
+		if isTag {
+			if event.Tags == nil {
+				event.Tags = make(map[string]string)
+			}
+			event.Tags[key] = value.(string)
+		} else {
+			setData(&event, key, value)
+		}
+	}
+
+	if event.Type == "" {
+		event.Type = defaultLogfmtType
+	}
+	return event, nil
+}
+
+// setData sets a data field of event.
+func setData(event *Event, key string, value any) {
+	if event.Data == nil {
+		event.Data = make(map[string]any)
+	}
+	event.Data[key] = value
+}
+
+// classifyLogfmt guesses whether a logfmt key-value pair is a tag or a data
+// field, returning the key without any tag. or data. prefix and the value.
+// Numbers and booleans are data, as are msg and message, which hold free
+// text. Other values are tags when they are valid as tags and contain no
+// spaces, and string data otherwise.
+func classifyLogfmt(key, value string) (string, any, bool) {
+	if k, ok := strings.CutPrefix(key, logfmtTagPrefix); ok && k != "" {
+		return k, value, true
+	}
+	if k, ok := strings.CutPrefix(key, logfmtDataPrefix); ok && k != "" {
+		return k, parseLogfmtValue(value), false
+	}
+
+	if key == "msg" || key == "message" {
+		return key, value, false
+	}
+	if v := parseLogfmtValue(value); v != value {
+		return key, v, false
+	}
+	if strings.ContainsAny(value, " \t\n") ||
+		validateKeyComponent("tag key", key) != nil || validateKeyComponent("tag value", value) != nil {
+		return key, value, false
+	}
+	return key, value, true
+}
+
+// parseLogfmtValue returns a value as a float64 or bool if it is a number or
+// boolean, and unchanged otherwise.
+func parseLogfmtValue(value string) any {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	return value
+}
+
+// reservedLogfmtKey reports whether key has a fixed meaning on import.
+func reservedLogfmtKey(key string) bool {
+	switch key {
+	case "ts", "time", "timestamp", "type", "event", "id":
+		return true
+	}
+	return false
+}
+
+// exportLogfmt writes events as logfmt lines:
+//
+//	ts=2024-01-01T10:00:00Z id=01HXYZ... type=request service=api latency=42.5
+//
+// Tags and data fields follow in key order. Keys are prefixed with tag. or
+// data. where the import heuristics would otherwise misread them, so
+// exported events import unchanged. Objects and arrays are written as JSON.
+func exportLogfmt(ctx context.Context, w io.Writer, events []*Event) error {
+	bw := bufio.NewWriter(w)
+	t := progressFrom(ctx)
+	for i, event := range events {
+		if i%1000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		bw.WriteString("ts=" + event.Timestamp.Format(time.RFC3339Nano))
+		bw.WriteString(" id=" + event.ID.String())
+		bw.WriteString(" type=" + quoteLogfmt(event.Type))
+
+		for _, k := range sortedKeys(event.Tags) {
+			v := event.Tags[k]
+			key := k
+			if reservedLogfmtKey(k) {
+				key = logfmtTagPrefix + k
+			} else if ck, _, isTag := classifyLogfmt(k, v); !isTag || ck != k {
+				key = logfmtTagPrefix + k
+			}
+			bw.WriteString(" " + key + "=" + quoteLogfmt(v))
+		}
+
+		for _, k := range sortedKeys(event.Data) {
+			v := event.Data[k]
+			s := formatDataValue(v)
+			key := k
+			if reservedLogfmtKey(k) {
+				key = logfmtDataPrefix + k
+			} else if ck, cv, isTag := classifyLogfmt(k, s); isTag || ck != k || !sameLogfmtValue(cv, v) {
+				key = logfmtDataPrefix + k
+			}
+			bw.WriteString(" " + key + "=" + quoteLogfmt(s))
+		}
+
+		bw.WriteByte('\n')
+		t.add(1, 0)
+	}
+	return bw.Flush()
+}
+
+// sameLogfmtValue reports whether a value parsed from logfmt equals the
+// data value it was written from.
+func sameLogfmtValue(parsed, v any) bool {
+	if f, ok := numericValue(v); ok {
+		return parsed == f
+	}
+	switch v.(type) {
+	case string, bool:
+		return parsed == v
+	}
+	return false
+}
+
+// quoteLogfmt quotes a logfmt value if it is empty or contains spaces,
+// quotes, equals signs or non-printable characters.
+func quoteLogfmt(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || !strconv.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readLogfmt calls fn with the event of each non-empty logfmt line in r.
+func readLogfmt(r io.Reader, fn func(Event) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		pairs, err := parseLogfmt(text)
+		if err != nil {
+			return fmt.Errorf("squid: import line %d: %w", line, err)
+		}
+		event, err := logfmtEvent(pairs)
+		if err != nil {
+			return fmt.Errorf("squid: import line %d: %w", line, err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("squid: import: line longer than %d bytes", maxImportLine)
+		}
+		return err
+	}
+	return nil
+}
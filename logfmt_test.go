@@ -0,0 +1,145 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	pairs, err := parseLogfmt(`level=info msg="hello \"world\"" debug  empty= path=/a=b`)
+	if err != nil {
+		t.Fatalf("parseLogfmt failed: %v", err)
+	}
+	want := []logfmtPair{
+		{key: "level", value: "info"},
+		{key: "msg", value: `hello "world"`},
+		{key: "debug", flag: true},
+		{key: "empty"},
+		{key: "path", value: "/a=b"},
+	}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("unexpected pairs: %+v", pairs)
+	}
+
+	for _, line := range []string{`=x`, `msg="unterminated`, `a"b=c`} {
+		if _, err := parseLogfmt(line); err == nil {
+			t.Errorf("expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestImportLogfmt(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	input := `ts=2024-01-01T10:00:00Z level=error service=api status=500 msg="upstream timed out" retry=true
+time=2024-01-01T10:00:01Z type=request service=web latency=12.5 tag.code=404 data.user=alice
+
+ts=2024-01-01T10:00:02Z note="two words"
+`
+	n, err := db.Import(context.Background(), strings.NewReader(input), Logfmt)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 events imported, got %d", n)
+	}
+
+	events, err := db.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	first := events[0]
+	if first.Type != "log" || !first.Timestamp.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first event: %+v", first)
+	}
+	if !reflect.DeepEqual(first.Tags, map[string]string{"level": "error", "service": "api"}) {
+		t.Errorf("unexpected tags %v", first.Tags)
+	}
+	if !reflect.DeepEqual(first.Data, map[string]any{"status": 500.0, "msg": "upstream timed out", "retry": true}) {
+		t.Errorf("unexpected data %v", first.Data)
+	}
+
+	second := events[1]
+	if second.Type != "request" || second.Tags["code"] != "404" || second.Data["user"] != "alice" || second.Data["latency"] != 12.5 {
+		t.Errorf("unexpected second event: %+v", second)
+	}
+	if events[2].Data["note"] != "two words" {
+		t.Errorf("expected values with spaces to be data, got %+v", events[2])
+	}
+
+	if _, err := db.Import(context.Background(), strings.NewReader("ts=yesterday type=x\n"), Logfmt); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected an error naming the line, got %v", err)
+	}
+}
+
+func TestLogfmtRoundTrip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := Open(dir + "/src")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer src.Close()
+	dst, err := Open(dir + "/dst")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer dst.Close()
+
+	// Values the import heuristics would misread are exported with prefixes
+	event := Event{
+		Timestamp: time.Date(2024, 1, 1, 10, 0, 0, 123000000, time.UTC),
+		Type:      "deploy job",
+		Tags:      map[string]string{"service": "api", "status": "200", "type": "x", "msg": "tag"},
+		Data:      map[string]any{"latency": 1.5, "path": "/a", "note": "two words", "ok": false, "ts": "now"},
+	}
+	if _, err := src.Append(event); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export(context.Background(), &buf, Query{}, Logfmt); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	for _, prefixed := range []string{" tag.msg=tag ", " tag.status=200 ", " tag.type=x ", " data.path=/a ", " data.ts=now"} {
+		if !strings.Contains(buf.String(), prefixed) {
+			t.Errorf("expected %q in %s", prefixed, buf.String())
+		}
+	}
+	if _, err := dst.Import(context.Background(), &buf, Logfmt); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	events, err := dst.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	got := events[0]
+	if got.Type != event.Type || !got.Timestamp.Equal(event.Timestamp) ||
+		!reflect.DeepEqual(got.Tags, event.Tags) || !reflect.DeepEqual(got.Data, event.Data) {
+		t.Errorf("round trip changed the event:\n got %+v\nwant %+v", got, event)
+	}
+}
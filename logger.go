@@ -0,0 +1,10 @@
+package squid
+
+// Logger is the logging interface used by Squid. It matches badger.Logger,
+// so the same logger also receives BadgerDB's output.
+type Logger interface {
+	Errorf(string, ...interface{})
+	Warningf(string, ...interface{})
+	Infof(string, ...interface{})
+	Debugf(string, ...interface{})
+}
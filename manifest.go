@@ -0,0 +1,224 @@
+package squid
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Manifest describes the files of an export archive, so the archive can be
+// checked for missing, truncated or altered files before it is trusted.
+// It is usually stored as JSON next to the files.
+type Manifest struct {
+	Files []ManifestFile `json:"files"`
+}
+
+// ManifestFile describes an export file.
+type ManifestFile struct {
+	// Name is the file name, relative to the directory of the manifest.
+	Name string `json:"name"`
+
+	// Format is the name of the export format, or "template" for exports
+	// written by a Template.
+	Format string `json:"format"`
+
+	// Events is the number of events exported.
+	Events int64 `json:"events"`
+
+	// Start and End are the earliest and latest event timestamps, nil if no
+	// events were exported.
+	Start *time.Time `json:"start,omitempty"`
+	End   *time.Time `json:"end,omitempty"`
+
+	// Bytes is the size of the file.
+	Bytes int64 `json:"bytes"`
+
+	// SHA256 is the hex-encoded SHA-256 checksum of the file.
+	SHA256 string `json:"sha256"`
+}
+
+// ExportWithManifest is like Export but also returns the manifest entry of
+// the written file. The entry's Name is left for the caller to set.
+func (db *DB) ExportWithManifest(ctx context.Context, w io.Writer, q Query, format Exporter) (ManifestFile, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ManifestFile{}, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	t := startProgress(ctx, "export")
+	defer t.done()
+
+	file := ManifestFile{Format: exporterName(format)}
+	cw := newChecksumWriter(w, db.crypto())
+	if format == Arrow {
+		if err := db.writeArrow(ctx, t, cw, q, nil, file.add); err != nil {
+			return ManifestFile{}, err
+		}
+	} else {
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			return ManifestFile{}, err
+		}
+		if err := exportEvents(ctx, t, cw, events, format); err != nil {
+			return ManifestFile{}, err
+		}
+		for _, e := range events {
+			file.add(e)
+		}
+	}
+
+	file.Bytes, file.SHA256 = cw.n, cw.sum()
+	return file, nil
+}
+
+// exporterName returns the manifest format name of an exporter.
+func exporterName(format Exporter) string {
+	if f, ok := format.(ExportFormat); ok {
+		return f.String()
+	}
+	return "template"
+}
+
+// add counts an event and widens the time bounds to include it.
+func (f *ManifestFile) add(e *Event) {
+	f.Events++
+	if f.Start == nil || e.Timestamp.Before(*f.Start) {
+		t := e.Timestamp
+		f.Start = &t
+	}
+	if f.End == nil || e.Timestamp.After(*f.End) {
+		t := e.Timestamp
+		f.End = &t
+	}
+}
+
+// Verify checks every file of the manifest, read from dir, with
+// VerifyExport.
+func (m *Manifest) Verify(dir string) error {
+	for _, file := range m.Files {
+		f, err := os.Open(filepath.Join(dir, file.Name))
+		if err != nil {
+			return err
+		}
+		err = VerifyExport(f, file)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyExport checks that the export file read from r matches its
+// manifest entry, returning an error wrapping ErrManifestMismatch if not.
+// The size and checksum are checked for every format. The event count and
+// time bounds are also checked for the JSON and logfmt formats, which can be
+// read back.
+func VerifyExport(r io.Reader, file ManifestFile) error {
+	mismatch := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s: %s", ErrManifestMismatch, file.Name, fmt.Sprintf(format, args...))
+	}
+
+	cw := newChecksumWriter(io.Discard, stdCrypto{})
+	tee := io.TeeReader(r, cw)
+
+	var got ManifestFile
+	add := func(e Event) error {
+		got.add(&e)
+		return nil
+	}
+	var readErr error
+	readable := true
+	switch file.Format {
+	case JSON.String():
+		readErr = readJSONEvents(tee, add)
+	case Logfmt.String():
+		readErr = readLogfmt(tee, add)
+	default:
+		readable = false
+	}
+	// Hash whatever the events were not read from
+	if _, err := io.Copy(cw, r); err != nil {
+		return err
+	}
+
+	if cw.n != file.Bytes {
+		return mismatch("expected %d bytes, got %d", file.Bytes, cw.n)
+	}
+	if sum := cw.sum(); sum != file.SHA256 {
+		return mismatch("expected checksum %s, got %s", file.SHA256, sum)
+	}
+	if !readable {
+		return nil
+	}
+	if readErr != nil {
+		return mismatch("%v", readErr)
+	}
+	if got.Events != file.Events {
+		return mismatch("expected %d events, got %d", file.Events, got.Events)
+	}
+	if !sameTime(got.Start, file.Start) || !sameTime(got.End, file.End) {
+		return mismatch("time bounds differ")
+	}
+	return nil
+}
+
+// sameTime reports whether two optional times are equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// ImportVerified checks the export read from r against its manifest entry
+// with VerifyExport and, if it matches, imports it as Import does. Nothing
+// is imported from a file that does not match. The format is taken from
+// the entry and must be importable.
+func (db *DB) ImportVerified(ctx context.Context, r io.ReadSeeker, file ManifestFile) (int64, error) {
+	format, err := ParseExportFormat(file.Format)
+	if err != nil {
+		return 0, err
+	}
+	if format != JSON && format != Logfmt {
+		return 0, fmt.Errorf("squid: cannot import the %s format", format)
+	}
+
+	if err := VerifyExport(r, file); err != nil {
+		return 0, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return db.Import(ctx, r, format)
+}
+
+// checksumWriter counts and hashes the bytes written through it.
+type checksumWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func newChecksumWriter(w io.Writer, c CryptoProvider) *checksumWriter {
+	return &checksumWriter{w: w, h: c.SHA256()}
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// sum returns the hex-encoded checksum of the bytes written.
+func (c *checksumWriter) sum() string {
+	return hex.EncodeToString(c.h.Sum(nil))
+}
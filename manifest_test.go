@@ -0,0 +1,110 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportManifest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		if _, err := db.Append(Event{Timestamp: base.Add(time.Duration(i) * time.Minute), Type: "request", Data: map[string]any{"i": i}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	archive := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archive, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	for _, format := range []ExportFormat{JSON, Logfmt, CSV} {
+		name := "events." + format.String()
+		f, err := os.Create(filepath.Join(archive, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := db.ExportWithManifest(context.Background(), f, Query{}, format)
+		f.Close()
+		if err != nil {
+			t.Fatalf("ExportWithManifest %s failed: %v", format, err)
+		}
+		file.Name = name
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	entry := manifest.Files[0]
+	if entry.Format != "json" || entry.Events != 5 || !entry.Start.Equal(base) || !entry.End.Equal(base.Add(4*time.Minute)) {
+		t.Errorf("unexpected manifest entry: %+v", entry)
+	}
+	if info, err := os.Stat(filepath.Join(archive, entry.Name)); err != nil || info.Size() != entry.Bytes || len(entry.SHA256) != 64 {
+		t.Errorf("unexpected size or checksum: %+v", entry)
+	}
+
+	if err := manifest.Verify(archive); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	// A wrong event count is detected even with a matching checksum
+	wrong := manifest
+	wrong.Files = append([]ManifestFile(nil), manifest.Files...)
+	wrong.Files[1].Events++
+	if err := wrong.Verify(archive); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("expected ErrManifestMismatch for a wrong count, got %v", err)
+	}
+
+	// An altered file fails verification and is not imported
+	path := filepath.Join(archive, entry.Name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(data[:len(data)-2], ']'), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Verify(archive); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("expected ErrManifestMismatch for an altered file, got %v", err)
+	}
+
+	restored, err := Open(filepath.Join(dir, "restored"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer restored.Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := restored.ImportVerified(context.Background(), f, entry)
+	f.Close()
+	if !errors.Is(err, ErrManifestMismatch) || n != 0 {
+		t.Errorf("expected ErrManifestMismatch and nothing imported, got %d, %v", n, err)
+	}
+
+	f, err = os.Open(filepath.Join(archive, manifest.Files[1].Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err = restored.ImportVerified(context.Background(), f, manifest.Files[1])
+	f.Close()
+	if err != nil || n != 5 {
+		t.Fatalf("expected 5 events imported, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,119 @@
+package squid
+
+import (
+	"container/heap"
+	"context"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// cursorHeap orders index cursors by the ID each is at, in ascending or,
+// if descending is set, descending order.
+type cursorHeap struct {
+	cursors    []*indexCursor
+	descending bool
+}
+
+func (h *cursorHeap) Len() int { return len(h.cursors) }
+
+func (h *cursorHeap) Less(i, j int) bool {
+	c := h.cursors[i].id.Compare(h.cursors[j].id)
+	if h.descending {
+		return c > 0
+	}
+	return c < 0
+}
+
+func (h *cursorHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *cursorHeap) Push(x any) { h.cursors = append(h.cursors, x.(*indexCursor)) }
+
+func (h *cursorHeap) Pop() any {
+	c := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return c
+}
+
+// scanIndexUnion returns the IDs of the index entries under any of the
+// prefixes, in the query's order and without duplicates. The indices are
+// merged lazily with a heap of cursors, so a query with a Limit and no other
+// filters reads about Limit entries in all rather than Limit per index.
+func (db *DB) scanIndexUnion(ctx context.Context, txn *badger.Txn, prefixes [][]byte, q Query) ([]ulid.ULID, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false // Index keys have no values
+	opts.Reverse = q.Descending
+
+	// Candidates the remaining filters may reject don't count towards the
+	// limit
+	limit := q.scanLimit()
+	if needsFilter(q, true) {
+		limit = 0
+	}
+
+	var ids []ulid.ULID
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+
+	// advance decodes the entry a cursor is at, skipping corrupt ones,
+	// and reports false once its index has no more entries
+	advance := func(c *indexCursor) bool {
+		for ; c.it.ValidForPrefix(c.prefix); c.it.Next() {
+			scanned++
+			id, err := decodeIndexKey(c.it.Item().Key())
+			if err != nil {
+				db.recordCorrupt(ctx)
+				continue
+			}
+			c.id = id
+			return true
+		}
+		return false
+	}
+
+	h := &cursorHeap{descending: q.Descending}
+	for _, prefix := range prefixes {
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		c := &indexCursor{it: it, prefix: prefix}
+		start := prefix
+		if q.Descending {
+			start = prefixEnd(prefix)
+		}
+		if c.it.Seek(start); advance(c) {
+			h.cursors = append(h.cursors, c)
+		}
+	}
+	heap.Init(h)
+
+	for rounds := 0; h.Len() > 0; rounds++ {
+		if rounds%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return ids, err
+			}
+		}
+
+		// The next ID of the union is at the top; once it is past the
+		// query's range, so are those of every other cursor
+		c := h.cursors[0]
+		id := c.id
+		if pastRange(id, q) {
+			break
+		}
+		if db.matchesTimeRange(id, q) && (len(ids) == 0 || ids[len(ids)-1] != id) {
+			ids = append(ids, id)
+			if limit > 0 && len(ids) >= limit {
+				break
+			}
+		}
+
+		if c.it.Next(); advance(c) {
+			heap.Fix(h, 0)
+		} else {
+			heap.Pop(h)
+		}
+	}
+
+	return ids, nil
+}
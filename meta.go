@@ -0,0 +1,128 @@
+package squid
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// metaAppPrefix separates application metadata from the database's own
+// metadata records.
+const metaAppPrefix = "app/"
+
+// maxMetaKeyLen is the longest application metadata key.
+const maxMetaKeyLen = 1024
+
+// encodeAppMetaKey creates the key of an application metadata record.
+// Format: M:app/<key>
+func encodeAppMetaKey(key string) []byte {
+	return encodeMetaKey(metaAppPrefix + key)
+}
+
+// checkMetaKey checks that an application metadata key can be stored.
+func checkMetaKey(key string) error {
+	if key == "" || len(key) > maxMetaKeyLen {
+		return fmt.Errorf("%w: key of %d bytes, expected 1 to %d", ErrInvalidMetaKey, len(key), maxMetaKeyLen)
+	}
+	return nil
+}
+
+// checkMetaKeys checks every key of a metadata update.
+func checkMetaKeys(meta map[string][]byte) error {
+	for key := range meta {
+		if err := checkMetaKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMeta writes a metadata update in txn. Nil values delete their key.
+func writeMeta(txn *badger.Txn, meta map[string][]byte) error {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+		if value := meta[key]; value == nil {
+			err = txn.Delete(encodeAppMetaKey(key))
+		} else {
+			err = txn.Set(encodeAppMetaKey(key), value)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write metadata %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// SetMeta stores an application metadata value, such as a consumer cursor,
+// a schema version or a migration marker, under key. Application metadata
+// lives in the same store as the events, apart from them and from the
+// database's own metadata. A nil value deletes the key.
+func (db *DB) SetMeta(key string, value []byte) error {
+	return db.UpdateMeta(map[string][]byte{key: value})
+}
+
+// UpdateMeta stores several application metadata values atomically, as
+// SetMeta does.
+func (db *DB) UpdateMeta(meta map[string][]byte) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := checkMetaKeys(meta); err != nil {
+		return err
+	}
+	return db.badger.Update(func(txn *badger.Txn) error {
+		return writeMeta(txn, meta)
+	})
+}
+
+// GetMeta returns the application metadata value stored under key, or
+// ErrMetaNotFound if it is not set.
+func (db *DB) GetMeta(key string) ([]byte, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := checkMetaKey(key); err != nil {
+		return nil, err
+	}
+
+	var value []byte
+	err := db.badger.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeAppMetaKey(key))
+		if err == badger.ErrKeyNotFound {
+			return ErrMetaNotFound
+		}
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// AppendBatchWithMeta adds events and stores application metadata in a
+// single transaction, so that either both are stored or neither is. A
+// consumer that copies events from elsewhere can store its cursor with
+// them and resume exactly where it stopped after a crash. Nil values
+// delete their key.
+func (db *DB) AppendBatchWithMeta(events []Event, meta map[string][]byte) ([]*AppendResult, error) {
+	return db.appendBatch(events, nil, meta)
+}
@@ -0,0 +1,74 @@
+package squid
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if _, err := db.GetMeta("schema"); !errors.Is(err, ErrMetaNotFound) {
+		t.Errorf("expected ErrMetaNotFound, got %v", err)
+	}
+	if err := db.SetMeta("schema", []byte("3")); err != nil {
+		t.Fatalf("SetMeta failed: %v", err)
+	}
+
+	// Application keys don't clash with the database's own metadata
+	if err := db.SetMeta("format", []byte("mine")); err != nil {
+		t.Fatalf("SetMeta failed: %v", err)
+	}
+
+	// Events and the cursor of the consumer that copied them are stored together
+	results, err := db.AppendBatchWithMeta([]Event{{Type: "order"}, {Type: "order"}}, map[string][]byte{
+		"cursor": []byte("offset-2"),
+		"format": nil,
+	})
+	if err != nil || len(results) != 2 {
+		t.Fatalf("AppendBatchWithMeta failed: %v", err)
+	}
+
+	// A failed append stores no metadata
+	_, err = db.AppendBatchWithMeta([]Event{{Type: ""}}, map[string][]byte{"cursor": []byte("offset-3")})
+	if !errors.Is(err, ErrEmptyType) {
+		t.Errorf("expected ErrEmptyType, got %v", err)
+	}
+	if _, err := db.AppendBatchWithMeta(nil, map[string][]byte{"": []byte("x")}); !errors.Is(err, ErrInvalidMetaKey) {
+		t.Errorf("expected ErrInvalidMetaKey, got %v", err)
+	}
+	if err := db.SetMeta(strings.Repeat("k", maxMetaKeyLen+1), nil); !errors.Is(err, ErrInvalidMetaKey) {
+		t.Errorf("expected ErrInvalidMetaKey, got %v", err)
+	}
+
+	// Metadata survives reopening
+	db.Close()
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	defer db.Close()
+
+	for key, want := range map[string]string{"schema": "3", "cursor": "offset-2"} {
+		if got, err := db.GetMeta(key); err != nil || string(got) != want {
+			t.Errorf("GetMeta(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	if _, err := db.GetMeta("format"); !errors.Is(err, ErrMetaNotFound) {
+		t.Errorf("expected the deleted key to be gone, got %v", err)
+	}
+	if n, err := db.Count(); err != nil || n != 2 {
+		t.Errorf("expected 2 events, got %d, %v", n, err)
+	}
+}
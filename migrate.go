@@ -0,0 +1,249 @@
+package squid
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// Legacy (v1) key prefixes. v1 keys stored ULIDs as 26-character strings and
+// joined tag components with '=' and ':', which made index keys ambiguous
+// when a tag value contained either character.
+const (
+	legacyPrefixEvent = "e:"
+	legacyPrefixTag   = "t:"
+	legacyPrefixType  = "y:"
+	legacyEventKeyLen = len(legacyPrefixEvent) + 26
+)
+
+const (
+	// keyFormatVersion is the current on-disk key layout. Version 3 moved
+	// event data out of the primary records into separate payload records.
+	keyFormatVersion = 3
+
+	// migrateBatchSize bounds the number of events rewritten per
+	// transaction. Batches too big for a transaction, e.g. of events with
+	// many tags, are halved until they fit.
+	migrateBatchSize = 1000
+)
+
+// metaKeyFormat stores the key layout version of the database.
+var metaKeyFormat = encodeMetaKey("format")
+
+// decodeLegacyEventKey extracts the ULID from a v1 primary event key.
+func decodeLegacyEventKey(key []byte) (ulid.ULID, error) {
+	if len(key) != legacyEventKeyLen {
+		return ulid.ULID{}, ErrInvalidKey
+	}
+	return ulid.ParseStrict(string(key[len(legacyPrefixEvent):]))
+}
+
+// migrateKeys upgrades a database written with an older key layout.
+//
+// For v1 layouts, events are moved in batches, each committed in its own transaction, so the
+// migration is resumable: if it is interrupted, the next Open continues with
+// the events that are still stored under v1 keys. Indices are rebuilt from
+// the stored events rather than parsed from the ambiguous v1 index keys,
+// which are dropped once every event has been moved.
+//
+// The migration runs in Open, which blocks until every event is rewritten:
+// reads of a partly migrated store would miss the events not moved yet.
+func (db *DB) migrateKeys() error {
+	version, err := db.keyFormat()
+	if err != nil {
+		return err
+	}
+	if version >= keyFormatVersion {
+		return nil
+	}
+
+	if version < 2 {
+		size := migrateBatchSize
+		for {
+			n, err := db.migrateEventBatch(size)
+			if errors.Is(err, badger.ErrTxnTooBig) && size > 1 {
+				size /= 2
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+		}
+
+		if err := db.badger.DropPrefix([]byte(legacyPrefixTag), []byte(legacyPrefixType)); err != nil {
+			return fmt.Errorf("failed to drop legacy indices: %w", err)
+		}
+	}
+
+	// Events moved from v1 keys are already split, which splitting again
+	// leaves unchanged
+	var after []byte
+	size := migrateBatchSize
+	for {
+		last, err := db.splitEventBatch(after, size)
+		if errors.Is(err, badger.ErrTxnTooBig) && size > 1 {
+			size /= 2
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if after = last; after == nil {
+			break
+		}
+	}
+
+	return db.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaKeyFormat, []byte{keyFormatVersion})
+	})
+}
+
+// keyFormat returns the stored key layout version, or 0 if none is recorded.
+func (db *DB) keyFormat() (byte, error) {
+	var version byte
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(metaKeyFormat)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			if len(val) > 0 {
+				version = val[0]
+			}
+			return nil
+		})
+	})
+
+	return version, err
+}
+
+// migrateEventBatch moves up to size events from v1 to v2 keys. It returns
+// the number of events moved.
+func (db *DB) migrateEventBatch(size int) (int, error) {
+	var moved int
+
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		type legacyEntry struct {
+			key  []byte
+			data []byte
+		}
+		var batch []legacyEntry
+
+		opts := badger.DefaultIteratorOptions
+		it := txn.NewIterator(opts)
+		prefix := []byte(legacyPrefixEvent)
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && len(batch) < size; it.Next() {
+			item := it.Item()
+			data, err := item.ValueCopy(nil)
+			if err != nil {
+				it.Close()
+				return err
+			}
+			batch = append(batch, legacyEntry{key: item.KeyCopy(nil), data: data})
+		}
+		it.Close()
+
+		for _, entry := range batch {
+			id, err := decodeLegacyEventKey(entry.key)
+			if err != nil {
+				return fmt.Errorf("failed to migrate event key %q: %w", entry.key, err)
+			}
+
+			var event Event
+			if err := db.codec().Unmarshal(entry.data, &event); err != nil {
+				return fmt.Errorf("failed to migrate event %s: %w", id, err)
+			}
+			event.ID = id
+
+			meta, data, err := db.encodeEvent(&event)
+			if err != nil {
+				return err
+			}
+			if _, _, err := db.writeEvent(txn, &event, meta, data); err != nil {
+				return err
+			}
+			if err := txn.Delete(entry.key); err != nil {
+				return err
+			}
+		}
+
+		moved = len(batch)
+		return nil
+	})
+
+	return moved, err
+}
+
+// splitEventBatch moves the data of up to size events stored after the key
+// after out of their primary records into payload records. It returns the
+// key of the last event visited, or nil once all are.
+func (db *DB) splitEventBatch(after []byte, size int) ([]byte, error) {
+	var last []byte
+
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		type entry struct {
+			id    ulid.ULID
+			event Event
+		}
+		var batch []entry
+
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := eventKeyPrefix()
+		seek := prefix
+		if after != nil {
+			seek = append(after, 0)
+		}
+		var visited int
+		for it.Seek(seek); it.ValidForPrefix(prefix) && visited < size; it.Next() {
+			visited++
+			item := it.Item()
+			last = item.KeyCopy(last[:0])
+
+			id, err := decodeEventKey(item.Key())
+			if err != nil {
+				continue
+			}
+
+			var event Event
+			if err := item.Value(func(val []byte) error {
+				return db.codec().Unmarshal(val, &event)
+			}); err != nil {
+				// Corrupt records are left for reads to report
+				continue
+			}
+			if len(event.Data) > 0 {
+				batch = append(batch, entry{id: id, event: event})
+			}
+		}
+		it.Close()
+
+		if visited == 0 {
+			last = nil
+		}
+
+		for _, e := range batch {
+			meta, data, err := db.encodeEvent(&e.event)
+			if err != nil {
+				return fmt.Errorf("failed to split event %s: %w", e.id, err)
+			}
+			if err := txn.Set(encodeEventKey(e.id), meta); err != nil {
+				return err
+			}
+			if _, err := db.writeData(txn, e.id, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return last, err
+}
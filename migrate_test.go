@@ -0,0 +1,277 @@
+package squid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// writeLegacyEvent stores an event using the v1 key layout.
+func writeLegacyEvent(t *testing.T, bdb *badger.DB, event Event) {
+	t.Helper()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := event.ID.String()
+	err = bdb.Update(func(txn *badger.Txn) error {
+		if err := txn.Set([]byte(legacyPrefixEvent+id), data); err != nil {
+			return err
+		}
+		if err := txn.Set([]byte(legacyPrefixType+event.Type+":"+id), nil); err != nil {
+			return err
+		}
+		for k, v := range event.Tags {
+			if err := txn.Set([]byte(legacyPrefixTag+k+"="+v+":"+id), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to write legacy event: %v", err)
+	}
+}
+
+func TestMigrateLegacyKeys(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Seed a v1 store directly through Badger
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	bdb, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := newULIDSource()
+	base := time.Now().Add(-time.Hour)
+	var legacy []Event
+	for i := 0; i < migrateBatchSize+5; i++ {
+		ts := base.Add(time.Duration(i) * time.Millisecond)
+		event := Event{
+			ID:        source.New(ts),
+			Timestamp: ts,
+			Type:      "request",
+			Tags:      map[string]string{"url": "a:b"},
+		}
+		if i%2 == 0 {
+			event.Type = "error"
+		}
+		writeLegacyEvent(t, bdb, event)
+		legacy = append(legacy, event)
+	}
+	bdb.Close()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	count, err := db.Count()
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != int64(len(legacy)) {
+		t.Errorf("expected %d events, got %d", len(legacy), count)
+	}
+
+	// Migrated events keep their IDs
+	got, err := db.Get(legacy[3].ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Type != legacy[3].Type {
+		t.Errorf("Type mismatch: got %s, want %s", got.Type, legacy[3].Type)
+	}
+
+	// Indices are rebuilt under the v2 layout
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != len(legacy)/2 {
+		t.Errorf("expected %d request events, got %d", len(legacy)/2, len(events))
+	}
+
+	events, err = db.Query(ctx, Query{Tags: map[string]string{"url": "a:b"}, Limit: 10})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 10 {
+		t.Errorf("expected 10 tagged events, got %d", len(events))
+	}
+
+	// No legacy keys remain
+	err = db.badger.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for _, prefix := range []string{legacyPrefixEvent, legacyPrefixTag, legacyPrefixType} {
+			it.Seek([]byte(prefix))
+			if it.ValidForPrefix([]byte(prefix)) {
+				t.Errorf("legacy keys with prefix %q remain", prefix)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := db.keyFormat()
+	if err != nil {
+		t.Fatalf("keyFormat failed: %v", err)
+	}
+	if version != keyFormatVersion {
+		t.Errorf("expected format %d, got %d", keyFormatVersion, version)
+	}
+}
+
+func TestMigrateManyTags(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	bdb, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A batch of these writes more index entries than a transaction holds
+	tags := make(map[string]string)
+	for i := 0; i < 120; i++ {
+		tags[fmt.Sprintf("k%d", i)] = "v"
+	}
+	source := newULIDSource()
+	base := time.Now().Add(-time.Hour)
+	n := migrateBatchSize + 5
+	for i := 0; i < n; i++ {
+		ts := base.Add(time.Duration(i) * time.Millisecond)
+		writeLegacyEvent(t, bdb, Event{ID: source.New(ts), Timestamp: ts, Type: "request", Tags: tags})
+	}
+	bdb.Close()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	events, err := db.Query(context.Background(), Query{Tags: map[string]string{"k119": "v"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != n {
+		t.Errorf("expected %d migrated events, got %d", n, len(events))
+	}
+}
+
+func TestMigrateSplitData(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Seed a v2 store, which kept data in the primary records
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	bdb, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := newULIDSource()
+	base := time.Now().Add(-time.Hour)
+	var stored []Event
+	err = bdb.Update(func(txn *badger.Txn) error {
+		for i := 0; i < migrateBatchSize+5; i++ {
+			ts := base.Add(time.Duration(i) * time.Millisecond)
+			event := Event{ID: source.New(ts), Timestamp: ts, Type: "request", Version: 1}
+			if i%3 != 0 {
+				event.Data = map[string]any{"i": float64(i)}
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := txn.Set(encodeEventKey(event.ID), data); err != nil {
+				return err
+			}
+			if err := txn.Set(encodeTypeIndexKey(event.Type, event.ID), nil); err != nil {
+				return err
+			}
+			stored = append(stored, event)
+		}
+		return txn.Set(metaKeyFormat, []byte{2})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb.Close()
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	events, err := db.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != len(stored) {
+		t.Fatalf("expected %d events, got %d", len(stored), len(events))
+	}
+	for i, e := range events {
+		if len(e.Data) != len(stored[i].Data) || (len(e.Data) > 0 && e.Data["i"] != stored[i].Data["i"]) {
+			t.Fatalf("event %d: expected data %v, got %v", i, stored[i].Data, e.Data)
+		}
+	}
+
+	// Primary records no longer hold data
+	err = db.badger.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeEventKey(stored[len(stored)-1].ID))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			var fields map[string]any
+			if err := json.Unmarshal(val, &fields); err != nil {
+				return err
+			}
+			if _, ok := fields["data"]; ok {
+				t.Errorf("primary record still holds data: %s", val)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := db.keyFormat()
+	if err != nil {
+		t.Fatalf("keyFormat failed: %v", err)
+	}
+	if version != keyFormatVersion {
+		t.Errorf("expected format %d, got %d", keyFormatVersion, version)
+	}
+}
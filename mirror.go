@@ -0,0 +1,214 @@
+package squid
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults and file naming for Mirror.
+const (
+	defaultMirrorMaxFileSize = 64 << 20
+	mirrorFilePrefix         = "events-"
+	mirrorFileExt            = ".ndjson"
+	mirrorTimeFormat         = "20060102T150405.000000000Z"
+)
+
+// Mirror configures a copy of appended events written to rotating NDJSON
+// files alongside the database. The files are a disaster-recovery trail
+// independent of BadgerDB's storage: if the database is lost or corrupted,
+// they can be re-imported with Import and the JSON format.
+//
+// Events are mirrored after they are committed, so the mirror holds every
+// appended event unless writing it fails. Updates and deletes are not
+// mirrored.
+type Mirror struct {
+	// Dir is the directory the files are written to. It is created if
+	// needed and should be on a different disk than the database.
+	Dir string
+
+	// MaxFileSize is the size in bytes from which a new file is started.
+	// Defaults to 64 MiB.
+	MaxFileSize int64
+
+	// MaxFileAge is the age from which a new file is started (0 means no
+	// limit).
+	MaxFileAge time.Duration
+
+	// MaxFiles is the number of files kept; the oldest are removed when a
+	// new file is started (0 keeps all files).
+	MaxFiles int
+
+	// Sync makes every write wait for the file to be flushed to disk.
+	Sync bool
+}
+
+// mirrorState holds the file currently written by the mirror.
+type mirrorState struct {
+	config Mirror
+	now    func() time.Time
+	logger Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	errors atomic.Int64
+}
+
+// newMirror returns the mirror configured by opts, or nil if none is.
+func newMirror(opts Options, now func() time.Time) (*mirrorState, error) {
+	if opts.Mirror == nil {
+		return nil, nil
+	}
+
+	config := *opts.Mirror
+	if config.Dir == "" {
+		return nil, errors.New("squid: mirror directory is required")
+	}
+	if config.MaxFileSize <= 0 {
+		config.MaxFileSize = defaultMirrorMaxFileSize
+	}
+	if err := os.MkdirAll(config.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("squid: creating mirror directory: %w", err)
+	}
+
+	return &mirrorState{config: config, now: now, logger: opts.Logger}, nil
+}
+
+// write appends events to the current file, one JSON object per line,
+// starting a new file first if the current one is full or too old. Events
+// are already committed when they are mirrored, so errors are counted and
+// logged rather than returned.
+func (m *mirrorState) write(events []Event) {
+	var buf []byte
+	for i := range events {
+		line, err := json.Marshal(&events[i])
+		if err != nil {
+			m.fail(fmt.Errorf("encoding event %s: %w", events[i].ID, err))
+			continue
+		}
+		buf = append(buf, line...)
+		buf = append(buf, '\n')
+	}
+	if len(buf) == 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.rotate(int64(len(buf))); err != nil {
+		m.fail(err)
+		return
+	}
+
+	n, err := m.file.Write(buf)
+	m.size += int64(n)
+	if err == nil && m.config.Sync {
+		err = m.file.Sync()
+	}
+	if err != nil {
+		// Start a new file on the next write rather than append to a
+		// file that may end in a partial line
+		m.file.Close()
+		m.file = nil
+		m.fail(err)
+	}
+}
+
+// rotate starts a new file if there is none or the current one cannot take
+// another n bytes or has reached its maximum age.
+func (m *mirrorState) rotate(n int64) error {
+	now := m.now()
+	if m.file != nil {
+		full := m.size > 0 && m.size+n > m.config.MaxFileSize
+		old := m.config.MaxFileAge > 0 && now.Sub(m.opened) >= m.config.MaxFileAge
+		if !full && !old {
+			return nil
+		}
+		if err := m.file.Close(); err != nil {
+			m.fail(err)
+		}
+		m.file = nil
+	}
+
+	name := filepath.Join(m.config.Dir, mirrorFilePrefix+now.UTC().Format(mirrorTimeFormat)+mirrorFileExt)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	m.file, m.size, m.opened = f, info.Size(), now
+
+	return m.prune()
+}
+
+// prune removes the oldest files beyond MaxFiles.
+func (m *mirrorState) prune() error {
+	if m.config.MaxFiles <= 0 {
+		return nil
+	}
+	files, err := MirrorFiles(m.config.Dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > m.config.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// fail records a mirror error.
+func (m *mirrorState) fail(err error) {
+	m.errors.Add(1)
+	if m.logger != nil {
+		m.logger.Errorf("squid: mirror: %v", err)
+	}
+}
+
+// close closes the current file.
+func (m *mirrorState) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// MirrorFiles returns the paths of the files written by a Mirror to dir,
+// oldest first, ready to be passed to Import in order.
+func MirrorFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && strings.HasPrefix(name, mirrorFilePrefix) && strings.HasSuffix(name, mirrorFileExt) {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
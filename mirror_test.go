@@ -0,0 +1,148 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestMirror(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mirrorDir := filepath.Join(dir, "mirror")
+	db, err := OpenWithOptions(filepath.Join(dir, "db"), Options{
+		Mirror: &Mirror{Dir: mirrorDir, MaxFileSize: 1024},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	if _, err := db.Append(Event{Timestamp: base, Type: "request", Tags: map[string]string{"service": "api"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	batch := make([]Event, 30)
+	for i := range batch {
+		batch[i] = Event{Timestamp: base.Add(time.Duration(i+1) * time.Second), Type: "request", Data: map[string]any{"i": float64(i)}}
+	}
+	if _, err := db.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if _, err := db.Append(Event{Timestamp: base.Add(time.Minute), Type: "error"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	want, err := db.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	files, err := MirrorFiles(mirrorDir)
+	if err != nil {
+		t.Fatalf("MirrorFiles failed: %v", err)
+	}
+	if len(files) < 2 {
+		t.Fatalf("expected the mirror to rotate by size, got %d files", len(files))
+	}
+
+	// Re-importing the mirror with the original IDs restores the events
+	restored, err := OpenWithOptions(filepath.Join(dir, "restored"), Options{
+		IDSource: IDSourceFunc(func(e *Event) (ulid.ULID, error) { return e.ID, nil }),
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer restored.Close()
+
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = restored.Import(context.Background(), f, JSON)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Import %s failed: %v", name, err)
+		}
+	}
+
+	got, err := restored.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].ID != want[i].ID || got[i].Type != want[i].Type || !got[i].Timestamp.Equal(want[i].Timestamp) {
+			t.Errorf("event %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	if got[0].Tags["service"] != "api" || got[1].Data["i"] != 0.0 {
+		t.Errorf("tags or data not restored: %+v, %+v", got[0], got[1])
+	}
+}
+
+func TestMirrorRotation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	mirrorDir := filepath.Join(dir, "mirror")
+	db, err := OpenWithOptions(filepath.Join(dir, "db"), Options{
+		Now:    func() time.Time { return now },
+		Mirror: &Mirror{Dir: mirrorDir, MaxFileAge: time.Hour, MaxFiles: 2},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, err := db.Append(Event{Type: "tick"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		now = now.Add(30 * time.Minute)
+	}
+
+	// Writes at 10:00 and 10:30 go to the first file, 11:00 and 11:30 to the second
+	files, err := MirrorFiles(mirrorDir)
+	if err != nil {
+		t.Fatalf("MirrorFiles failed: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 files, got %v", files)
+	}
+
+	// Starting a third file removes the oldest
+	now = now.Add(time.Hour)
+	if _, err := db.Append(Event{Type: "tick"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	newFiles, err := MirrorFiles(mirrorDir)
+	if err != nil {
+		t.Fatalf("MirrorFiles failed: %v", err)
+	}
+	if len(newFiles) != 2 || newFiles[0] != files[1] {
+		t.Errorf("expected the oldest file to be removed, got %v", newFiles)
+	}
+	if s := db.Stats(); s.MirrorErrors != 0 {
+		t.Errorf("expected no mirror errors, got %d", s.MirrorErrors)
+	}
+}
@@ -0,0 +1,148 @@
+package squid
+
+import "time"
+
+// Options configures a database opened with OpenWithOptions.
+// The zero value matches the behaviour of Open.
+type Options struct {
+	// MaxTagValuesPerKey limits the number of distinct values indexed for any
+	// single tag key (0 means no limit). Use it to stop high-cardinality tags
+	// such as request IDs from exploding the tag index.
+	MaxTagValuesPerKey int
+
+	// MaxTagKeys limits the number of distinct tag keys (0 means no limit).
+	MaxTagKeys int
+
+	// CardinalityAction decides what happens to a tag that would exceed
+	// MaxTagValuesPerKey or MaxTagKeys. Defaults to RejectEvent.
+	CardinalityAction CardinalityAction
+
+	// Profile tunes BadgerDB's memory use and background work for a class
+	// of machine. Defaults to ProfileDefault, BadgerDB's own settings.
+	Profile Profile
+
+	// ValueLogGCInterval is how often space held by deleted and replaced
+	// events in the value log is reclaimed (0 uses the interval of the
+	// Profile, negative disables it). ProfileDefault reclaims none.
+	ValueLogGCInterval time.Duration
+
+	// Now returns the current time. It is used to default event timestamps
+	// and to compute retention cutoffs. Defaults to time.Now; tests can
+	// supply a fake clock.
+	Now func() time.Time
+
+	// Logger receives log output from Squid and BadgerDB.
+	// Defaults to nil, which disables logging.
+	Logger Logger
+
+	// OnWriteStall is called when BadgerDB starts and stops stalling writes
+	// because compaction has fallen behind. Append blocks while a stall is
+	// in progress, so applications can use this to shed load. The callback
+	// runs on a monitoring goroutine and should return quickly.
+	OnWriteStall func(WriteStall)
+
+	// MaxFutureDrift limits how far ahead of the current time an event's
+	// timestamp may be (0 means no limit). Future-dated events from clients
+	// with bad clocks would otherwise sort after every real event and
+	// outlive their retention.
+	MaxFutureDrift time.Duration
+
+	// FutureTimestampAction decides what happens to an event timestamped
+	// beyond MaxFutureDrift. Defaults to RejectTimestamp.
+	FutureTimestampAction FutureTimestampAction
+
+	// IDSource generates event IDs (nil uses monotonic ULIDs). Use it to
+	// keep the native IDs of events ingested from another system.
+	IDSource IDSource
+
+	// DiskWatchdog monitors free space in the data directory and applies
+	// emergency measures when it runs low (nil disables the watchdog).
+	DiskWatchdog *DiskWatchdog
+
+	// AggregateCacheSize is the maximum number of aggregation results cached
+	// (0 disables caching). Only aggregations over time ranges that have
+	// ended are cached, such as the closed buckets of AggregateBuckets;
+	// writes into a cached range invalidate it.
+	AggregateCacheSize int
+
+	// CountRetention is how long the hourly counts of HourlyCounts and
+	// DailyCounts are kept (0 keeps them exactly as long as the events they
+	// count). When set, events deleted by retention policies, DeleteBefore
+	// or the disk watchdog stay counted, and counts of hours older than
+	// CountRetention are deleted by retention cleanup instead. This keeps,
+	// say, 13 months of hourly counts alongside 7 days of events.
+	CountRetention time.Duration
+
+	// Crypto supplies the hashes and randomness Squid uses (nil uses Go's
+	// crypto packages), for builds that must use a validated module.
+	Crypto CryptoProvider
+
+	// JSON encodes and decodes stored events (nil uses encoding/json).
+	// Decoding dominates the CPU time of large scans, which a faster
+	// compatible implementation can cut.
+	JSON JSONCodec
+
+	// DedupDataMinSize is the size in bytes from which encoded event data is
+	// stored once per distinct content, with events referring to it by hash
+	// (0 disables deduplication). Use it when many events carry identical
+	// payloads.
+	DedupDataMinSize int
+
+	// IngestMode decides whether appends reject invalid events or coerce
+	// them. Defaults to Strict.
+	IngestMode IngestMode
+
+	// IngestModes overrides IngestMode for events of the given types.
+	IngestModes map[string]IngestMode
+
+	// Units gives the unit of numeric data fields that producers may send
+	// as strings with a unit suffix, such as "150ms" or "2.5MB". Appends
+	// store such values as numbers in the unit, rejecting values that
+	// cannot be parsed in the Strict mode and storing them as given in the
+	// Lenient mode. Aggregations parse string values stored before the
+	// unit was set.
+	Units map[string]Unit
+
+	// RecordIngestErrors stores an event of type IngestErrorType for every
+	// append that the ingest checks reject, and for every event they change:
+	// coerced by the Lenient mode, clamped by MaxFutureDrift, or stripped of
+	// tags by the DropTag CardinalityAction. Query them to see what was
+	// rejected and why. Appends within a Tx are not recorded.
+	RecordIngestErrors bool
+
+	// IndexIngestTime indexes events by the time they were appended, so
+	// queries with Query.IngestedStart or IngestedEnd read only the events
+	// appended in that range instead of scanning. Only events appended while
+	// it is set are indexed.
+	IndexIngestTime bool
+
+	// SearchIndex indexes the words of the string values in event data
+	// and of tag values, so queries with Query.Search read only the events
+	// with one of the words instead of scanning. Each distinct word of an
+	// event adds an index entry, and deleting an event reads its data to
+	// remove them. Only events appended or updated while it is set are
+	// indexed.
+	SearchIndex bool
+
+	// DerivedStreams are written alongside the events they are derived
+	// from. Use DB.DeriveExisting to derive from events stored before a
+	// stream was added. Not supported by OpenStriped.
+	DerivedStreams []DerivedStream
+
+	// Mirror also writes appended events to rotating NDJSON files as a
+	// disaster-recovery trail (nil disables the mirror).
+	Mirror *Mirror
+
+	// Encryption encrypts stored data at rest (nil stores it unencrypted).
+	// Unencrypted data stored before it was set stays readable and is
+	// encrypted as it is rewritten.
+	Encryption *Encryption
+
+	// FileLimits bounds the files BadgerDB keeps open and memory-mapped
+	// (nil keeps the sizes of Profile and sets no limit).
+	FileLimits *FileLimits
+
+	// AdaptiveBatching commits concurrent Append calls together, sizing
+	// batches to a target latency (nil commits each Append on its own).
+	AdaptiveBatching *AdaptiveBatching
+}
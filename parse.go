@@ -0,0 +1,98 @@
+package squid
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// KeyKind identifies the record type of a stored key.
+type KeyKind int
+
+const (
+	// EventKey is a primary event record.
+	EventKey KeyKind = iota + 1
+	// TagIndexKey is a tag index entry.
+	TagIndexKey
+	// TypeIndexKey is a type index entry.
+	TypeIndexKey
+	// MetaKey is a store metadata record.
+	MetaKey
+	// DataKey is the data payload of an event.
+	DataKey
+)
+
+// Key is a decoded BadgerDB key written by Squid.
+type Key struct {
+	Kind KeyKind
+
+	// ID is set for event, data and index keys.
+	ID ulid.ULID
+
+	// Type is set for type index keys.
+	Type string
+
+	// TagKey and TagValue are set for tag index keys.
+	TagKey   string
+	TagValue string
+
+	// Name is set for metadata keys.
+	Name string
+}
+
+// ParseKey decodes a raw key written by Squid, for example when inspecting a
+// database with Badger tools. It never panics: malformed input returns an
+// error wrapping ErrInvalidKey.
+func ParseKey(key []byte) (Key, error) {
+	switch {
+	case bytes.HasPrefix(key, []byte(prefixEvent)):
+		id, err := decodeEventKey(key)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: event key %q", err, key)
+		}
+		return Key{Kind: EventKey, ID: id}, nil
+
+	case bytes.HasPrefix(key, []byte(prefixData)):
+		if len(key) != eventKeyLen {
+			return Key{}, fmt.Errorf("%w: data key %q", ErrInvalidKey, key)
+		}
+		var id ulid.ULID
+		copy(id[:], key[len(prefixData):])
+		return Key{Kind: DataKey, ID: id}, nil
+
+	case bytes.HasPrefix(key, []byte(prefixTag)):
+		k, v, id, err := decodeTagIndexKey(key)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: tag index key %q", err, key)
+		}
+		return Key{Kind: TagIndexKey, ID: id, TagKey: k, TagValue: v}, nil
+
+	case bytes.HasPrefix(key, []byte(prefixType)):
+		t, id, err := decodeTypeIndexKey(key)
+		if err != nil {
+			return Key{}, fmt.Errorf("%w: type index key %q", err, key)
+		}
+		return Key{Kind: TypeIndexKey, ID: id, Type: t}, nil
+
+	case bytes.HasPrefix(key, []byte(prefixMeta)):
+		return Key{Kind: MetaKey, Name: string(key[len(prefixMeta):])}, nil
+
+	default:
+		return Key{}, fmt.Errorf("%w: unknown prefix in %q", ErrInvalidKey, key)
+	}
+}
+
+// ParseEvent decodes a stored event value and validates it.
+// It never panics: malformed input returns an error.
+func ParseEvent(data []byte) (*Event, error) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, err
+	}
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
@@ -0,0 +1,93 @@
+package squid
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	id := newULIDSource().Now()
+
+	tests := []struct {
+		name string
+		key  []byte
+		want Key
+	}{
+		{"event", encodeEventKey(id), Key{Kind: EventKey, ID: id}},
+		{"data", encodeDataKey(id), Key{Kind: DataKey, ID: id}},
+		{"tag", encodeTagIndexKey("url", "a:b=c", id), Key{Kind: TagIndexKey, ID: id, TagKey: "url", TagValue: "a:b=c"}},
+		{"type", encodeTypeIndexKey("request", id), Key{Kind: TypeIndexKey, ID: id, Type: "request"}},
+		{"meta", metaKeyFormat, Key{Kind: MetaKey, Name: "format"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.key)
+			if err != nil {
+				t.Fatalf("ParseKey failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, bad := range [][]byte{nil, []byte("E:short"), []byte("T:\xff\xff"), []byte("x:")} {
+		if _, err := ParseKey(bad); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ParseKey(%q): expected ErrInvalidKey, got %v", bad, err)
+		}
+	}
+}
+
+func FuzzParseKey(f *testing.F) {
+	id := newULIDSource().Now()
+	f.Add(encodeEventKey(id))
+	f.Add(encodeTagIndexKey("service", "api", id))
+	f.Add(encodeTypeIndexKey("request", id))
+	f.Add([]byte("T:\x00\x05ab"))
+	f.Add([]byte("Y:"))
+
+	f.Fuzz(func(t *testing.T, key []byte) {
+		k, err := ParseKey(key)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("unexpected error type: %v", err)
+			}
+			return
+		}
+
+		// Successfully parsed keys must re-encode to the same bytes
+		var encoded []byte
+		switch k.Kind {
+		case EventKey:
+			encoded = encodeEventKey(k.ID)
+		case TagIndexKey:
+			encoded = encodeTagIndexKey(k.TagKey, k.TagValue, k.ID)
+		case TypeIndexKey:
+			encoded = encodeTypeIndexKey(k.Type, k.ID)
+		case MetaKey:
+			encoded = encodeMetaKey(k.Name)
+		}
+		if !bytes.Equal(encoded, key) {
+			t.Fatalf("roundtrip mismatch: %q -> %+v -> %q", key, k, encoded)
+		}
+	})
+}
+
+func FuzzParseEvent(f *testing.F) {
+	f.Add([]byte(`{"id":"01ARZ3NDEKTSV4RRFFQ69G5FAV","type":"request","tags":{"a":"b"},"data":{"n":1}}`))
+	f.Add([]byte(`{"type":""}`))
+	f.Add([]byte(`{"tags":{"":"x"},"type":"a"}`))
+	f.Add([]byte(`[]`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		event, err := ParseEvent(data)
+		if err != nil {
+			return
+		}
+		if event.Type == "" {
+			t.Fatal("parsed event without a type")
+		}
+	})
+}
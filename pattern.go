@@ -0,0 +1,197 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// isTagPattern reports whether a tag filter value is a pattern rather than
+// an exact value.
+func isTagPattern(value string) bool {
+	return strings.Contains(value, "*")
+}
+
+// matchesTag reports whether the tags match the filter on key, which is
+// either an exact value or a pattern. Tags without the key only match an
+// exact empty value.
+func matchesTag(tags map[string]string, key, filter string) bool {
+	got, ok := tags[key]
+	if !isTagPattern(filter) {
+		return got == filter
+	}
+	return ok && matchPattern(filter, got)
+}
+
+// matchPattern reports whether s matches the tag pattern, in which *
+// matches any run of characters and a backslash escapes the next one.
+func matchPattern(pattern, s string) bool {
+	// Greedy matching that backtracks to the last star is enough, as a
+	// star can absorb anything a later one would
+	var p, i int
+	star, next := -1, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			c := pattern[p]
+			switch {
+			case c == '*':
+				star, next = p, i
+				p++
+				continue
+			case c == '\\' && p+1 < len(pattern):
+				if pattern[p+1] == s[i] {
+					p, i = p+2, i+1
+					continue
+				}
+			case c == s[i]:
+				p, i = p+1, i+1
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		next++
+		p, i = star+1, next
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// scanTagPattern scans the index of a tag for the IDs of events whose
+// value matches the pattern, returning them in the query's order.
+func (db *DB) scanTagPattern(ctx context.Context, txn *badger.Txn, tagKey, pattern string, q Query) ([]ulid.ULID, error) {
+	value := func(key []byte) (string, error) {
+		_, v, _, err := decodeTagIndexKey(key)
+		return v, err
+	}
+	valuePrefix := func(v string) []byte { return encodeTagIndexPrefix(tagKey, v) }
+	match := func(v string) bool { return matchPattern(pattern, v) }
+	return db.scanIndexValues(ctx, txn, encodeTagKeyPrefix(tagKey), value, valuePrefix, match, q)
+}
+
+// scanTypePattern scans the type index for the IDs of events whose type
+// matches the query's type pattern, returning them in the query's order.
+func (db *DB) scanTypePattern(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, error) {
+	re, err := compileTypePattern(q.TypePattern)
+	if err != nil {
+		return nil, err
+	}
+	value := func(key []byte) (string, error) {
+		t, _, err := decodeTypeIndexKey(key)
+		return t, err
+	}
+	return db.scanIndexValues(ctx, txn, []byte(prefixType), value, encodeTypeIndexPrefix, re.MatchString, q)
+}
+
+// scanIndexValues scans the index entries under prefix of every distinct
+// value that match accepts, returning their IDs in the query's order.
+// value decodes the value of an index key and valuePrefix encodes the
+// prefix of a value's entries. Index entries are ordered by value length
+// first, so values sharing a prefix are not adjacent; each distinct value
+// is checked once and its entries skipped when it does not match.
+func (db *DB) scanIndexValues(ctx context.Context, txn *badger.Txn, prefix []byte, value func([]byte) (string, error), valuePrefix func(string) []byte, match func(string) bool, q Query) ([]ulid.ULID, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	// The IDs of each value are only ordered among themselves, so the
+	// limit can only be applied once all are found
+	valueQuery := q
+	valueQuery.Limit, valueQuery.Offset = 0, 0
+
+	var ids []ulid.ULID
+	for it.Seek(prefix); it.ValidForPrefix(prefix); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		v, err := value(it.Item().Key())
+		if err != nil {
+			db.recordCorrupt(ctx)
+			it.Next()
+			continue
+		}
+		vp := valuePrefix(v)
+		if match(v) {
+			found, err := db.scanIndex(ctx, txn, vp, valueQuery)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, found...)
+		}
+		it.Seek(prefixEnd(vp))
+	}
+
+	slices.SortFunc(ids, func(a, b ulid.ULID) int {
+		if q.Descending {
+			return b.Compare(a)
+		}
+		return a.Compare(b)
+	})
+	if limit := q.scanLimit(); limit > 0 && len(ids) > limit && !needsFilter(q, true) {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// maxTypePatterns is the number of compiled type patterns cached.
+const maxTypePatterns = 256
+
+// typePatterns caches compiled type patterns, which are matched against
+// every event a query reads.
+var typePatterns struct {
+	sync.Mutex
+	m map[string]*regexp.Regexp
+}
+
+// compileTypePattern compiles a Query.TypePattern, anchored to match whole
+// types.
+func compileTypePattern(pattern string) (*regexp.Regexp, error) {
+	typePatterns.Lock()
+	defer typePatterns.Unlock()
+
+	if re, ok := typePatterns.m[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("%w: type pattern: %v", ErrInvalidQuery, err)
+	}
+	if typePatterns.m == nil || len(typePatterns.m) >= maxTypePatterns {
+		typePatterns.m = make(map[string]*regexp.Regexp)
+	}
+	typePatterns.m[pattern] = re
+	return re, nil
+}
+
+// matchesTypePattern reports whether an event type matches the query's
+// type pattern, if it has one. Invalid patterns match nothing; queries
+// reject them before reading events.
+func matchesTypePattern(q Query, eventType string) bool {
+	if q.TypePattern == "" {
+		return true
+	}
+	re, err := compileTypePattern(q.TypePattern)
+	return err == nil && re.MatchString(eventType)
+}
+
+// checkTypePattern returns an error wrapping ErrInvalidQuery if the query's
+// type pattern does not compile.
+func checkTypePattern(q Query) error {
+	if q.TypePattern == "" {
+		return nil
+	}
+	_, err := compileTypePattern(q.TypePattern)
+	return err
+}
@@ -0,0 +1,161 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"web-*", "web-1", true},
+		{"web-*", "web-", true},
+		{"web-*", "api-1", false},
+		{"*-eu-*", "web-eu-2", true},
+		{"*-eu-*", "web-us-2", false},
+		{"*", "", true},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYbZ", false},
+		{`5\*`, "5*", true},
+		{`5\*`, "55", false},
+		{`\\*`, `\x`, true},
+		{"*.log", "app.log.1", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestQueryTagPattern(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	hosts := []string{"web-1", "api-1", "web-22", "web-1", "db-1", "web-eu-3"}
+	for i, host := range hosts {
+		_, err := db.Append(Event{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			Type:      "request",
+			Tags:      map[string]string{"host": host},
+			Data:      map[string]any{"i": float64(i)},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	start, end := base.Add(time.Second), base.Add(4*time.Second)
+	tests := []struct {
+		name string
+		q    Query
+		want []float64
+	}{
+		{"index", Query{Tags: map[string]string{"host": "web-*"}}, []float64{0, 2, 3, 5}},
+		{"full scan", Query{Tags: map[string]string{"host": "web-*"}, Hint: ForceFullScan}, []float64{0, 2, 3, 5}},
+		{"descending with limit", Query{Tags: map[string]string{"host": "web-*"}, Descending: true, Limit: 3}, []float64{5, 3, 2}},
+		{"time range", Query{Tags: map[string]string{"host": "*-1"}, Start: &start, End: &end}, []float64{1, 3, 4}},
+		{"excluded", Query{Tags: map[string]string{"host": "web-*"}, ExcludeTags: map[string]string{"host": "*-1"}}, []float64{2, 5}},
+		{"no match", Query{Tags: map[string]string{"host": "cache-*"}}, nil},
+	}
+	for _, tt := range tests {
+		events, err := db.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query failed: %v", tt.name, err)
+		}
+		var got []float64
+		for _, e := range events {
+			got = append(got, e.Data["i"].(float64))
+		}
+		if len(got) != len(tt.want) {
+			t.Fatalf("%s: expected events %v, got %v", tt.name, tt.want, got)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: expected events %v, got %v", tt.name, tt.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestQueryTypePattern(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	types := []string{"payment.created", "refund", "payment.failed", "payments", "payment.created"}
+	for _, typ := range types {
+		if _, err := db.Append(Event{Type: typ, Tags: map[string]string{"region": "eu"}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		q    Query
+		want int
+	}{
+		{"type index", Query{TypePattern: `payment\..*`}, 3},
+		{"full scan", Query{TypePattern: `payment\..*`, Hint: ForceFullScan}, 3},
+		{"anchored", Query{TypePattern: `payment`}, 0},
+		{"with types", Query{Types: []string{"refund", "payments"}, TypePattern: `pay.*`}, 1},
+		{"with tags", Query{Tags: map[string]string{"region": "eu"}, TypePattern: `.*\.created`}, 2},
+		{"limit", Query{TypePattern: `payment\..*`, Limit: 2, Descending: true}, 2},
+	}
+	for _, tt := range tests {
+		events, err := db.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query failed: %v", tt.name, err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("%s: expected %d events, got %d", tt.name, tt.want, len(events))
+		}
+		for _, e := range events {
+			if !db.matchesFilters(e, tt.q) {
+				t.Errorf("%s: returned unmatched event of type %q", tt.name, e.Type)
+			}
+		}
+	}
+
+	counts, err := db.HourlyCounts(ctx, Query{TypePattern: `payment\..*`})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	var counted int64
+	for _, c := range counts {
+		counted += c.Count
+	}
+	if counted != 3 {
+		t.Errorf("expected 3 events counted, got %d", counted)
+	}
+
+	if _, err := db.Query(ctx, Query{TypePattern: `payment(`}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for an invalid pattern, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package squid
+
+import (
+	"context"
+	"sync"
+)
+
+// eventPool holds events released by Borrowed.Release for reuse by later
+// borrowed queries, along with their tag and data maps.
+var eventPool = sync.Pool{
+	New: func() any { return new(Event) },
+}
+
+// reset clears e so another event can be decoded into it, keeping its maps
+// to avoid allocating new ones.
+func (e *Event) reset() {
+	tags, data := e.Tags, e.Data
+	clear(tags)
+	clear(data)
+	*e = Event{Tags: tags, Data: data}
+}
+
+// eventAlloc supplies the events a query decodes into. An event rejected by
+// the query's filters is kept and reused for the next one.
+type eventAlloc struct {
+	pooled bool
+	spare  *Event
+}
+
+// get returns an empty event.
+func (a *eventAlloc) get() *Event {
+	if e := a.spare; e != nil {
+		a.spare = nil
+		return e
+	}
+	if a.pooled {
+		return eventPool.Get().(*Event)
+	}
+	return new(Event)
+}
+
+// reject takes back an event that was not returned to the caller.
+func (a *eventAlloc) reject(e *Event) {
+	if a.pooled {
+		e.reset()
+	} else {
+		// Returned events must not share maps with anything
+		*e = Event{}
+	}
+	a.spare = e
+}
+
+// done returns the spare event to the pool.
+func (a *eventAlloc) done() {
+	if a.pooled && a.spare != nil {
+		eventPool.Put(a.spare)
+		a.spare = nil
+	}
+}
+
+// Borrowed holds the results of QueryBorrowed.
+type Borrowed struct {
+	// Events are the matching events. They and their tag and data maps are
+	// reused after Release, so nothing referring to them may be kept.
+	Events []*Event
+}
+
+// Release returns the events to the pool for reuse by later borrowed
+// queries. The events must not be used afterwards.
+func (b *Borrowed) Release() {
+	for _, e := range b.Events {
+		e.reset()
+		eventPool.Put(e)
+	}
+	b.Events = nil
+}
+
+// QueryBorrowed is like Query, but decodes events into memory reused from
+// earlier borrowed queries, which saves allocating an event and its maps
+// for every result. Call Release once done with the events.
+//
+// Unlike Query, events without tags or data may have empty rather than nil
+// maps.
+func (db *DB) QueryBorrowed(ctx context.Context, q Query) (*Borrowed, error) {
+	events, err := db.query(ctx, q, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Borrowed{Events: events}, nil
+}
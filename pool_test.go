@@ -0,0 +1,178 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestQueryBorrowed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		event := Event{Type: "request", Data: map[string]any{"i": float64(i)}}
+		if i%2 == 0 {
+			event.Type = "error"
+			event.Tags = map[string]string{"service": fmt.Sprint("svc", i)}
+		}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	for _, q := range []Query{{}, {Types: []string{"request"}}, {Types: []string{"error"}, Limit: 2, Descending: true}} {
+		// Run twice so that the second query reuses released events
+		for round := 0; round < 2; round++ {
+			want, err := db.Query(ctx, q)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+
+			borrowed, err := db.QueryBorrowed(ctx, q)
+			if err != nil {
+				t.Fatalf("QueryBorrowed failed: %v", err)
+			}
+			if len(borrowed.Events) != len(want) {
+				t.Fatalf("expected %d events, got %d", len(want), len(borrowed.Events))
+			}
+			for i, e := range borrowed.Events {
+				w := want[i]
+				if e.ID != w.ID || e.Type != w.Type || !e.Timestamp.Equal(w.Timestamp) || e.Version != w.Version {
+					t.Errorf("event %d: expected %+v, got %+v", i, w, e)
+				}
+				if len(e.Tags) != len(w.Tags) || (len(w.Tags) > 0 && !reflect.DeepEqual(e.Tags, w.Tags)) {
+					t.Errorf("event %d: expected tags %v, got %v", i, w.Tags, e.Tags)
+				}
+				if !reflect.DeepEqual(e.Data, w.Data) {
+					t.Errorf("event %d: expected data %v, got %v", i, w.Data, e.Data)
+				}
+			}
+			borrowed.Release()
+		}
+	}
+}
+
+// benchmarkDB opens a database holding n events for benchmarks.
+func benchmarkDB(b *testing.B, n int) *DB {
+	b.Helper()
+
+	dir := b.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		b.Fatalf("Open failed: %v", err)
+	}
+	b.Cleanup(func() { db.Close() })
+
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{
+			Type: []string{"request", "error"}[i%2],
+			Tags: map[string]string{"service": "api", "env": "prod"},
+			Data: map[string]any{"latency": float64(i % 100), "path": "/users"},
+		}
+	}
+	if _, err := db.AppendBatch(events); err != nil {
+		b.Fatalf("AppendBatch failed: %v", err)
+	}
+	return db
+}
+
+func BenchmarkQuery(b *testing.B) {
+	db := benchmarkDB(b, 1000)
+	ctx := context.Background()
+	q := Query{Types: []string{"request"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Query(ctx, q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkQueryBorrowed(b *testing.B) {
+	db := benchmarkDB(b, 1000)
+	ctx := context.Background()
+	q := Query{Types: []string{"request"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		borrowed, err := db.QueryBorrowed(ctx, q)
+		if err != nil {
+			b.Fatal(err)
+		}
+		borrowed.Release()
+	}
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	db := benchmarkDB(b, 1000)
+	ctx := context.Background()
+	q := Query{Types: []string{"request"}}
+	aggs := []AggregationType{Count, Sum, Avg, P95}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := db.Aggregate(ctx, q, "latency", aggs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScanPrefetch(b *testing.B) {
+	db := benchmarkDB(b, 10000)
+	ctx := context.Background()
+
+	events, err := db.Query(ctx, Query{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	window := Query{Hint: ForceFullScan}.IDRange(events[8000].ID, events[8999].ID)
+
+	scans := []struct {
+		name string
+		q    Query
+	}{
+		{"all", Query{Hint: ForceFullScan}},
+		{"limit", Query{Hint: ForceFullScan, Limit: 10, Descending: true}},
+		{"range", window},
+	}
+	prefetches := []struct {
+		name string
+		p    *PrefetchOptions
+	}{
+		{"default", nil},
+		{"values", &PrefetchOptions{Values: true}},
+		{"values-1000", &PrefetchOptions{Values: true, Size: 1000}},
+	}
+	for _, s := range scans {
+		for _, p := range prefetches {
+			q := s.q
+			q.Prefetch = p.p
+			b.Run(s.name+"/"+p.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := db.Query(ctx, q); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
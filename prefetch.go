@@ -0,0 +1,49 @@
+package squid
+
+import "github.com/dgraph-io/badger/v4"
+
+// PrefetchOptions tunes how a scan of the stored events reads ahead. With
+// Values set, badger copies the values of the next Size keys in background
+// goroutines while the scan works through the current one. That pays off
+// for values read from the value log, which holds values larger than
+// badger's value threshold, and costs a goroutine and a copy per key for
+// the small records stored in the LSM tree, including those of keys a scan
+// skips.
+type PrefetchOptions struct {
+	// Values fetches values ahead of the scan. Without it, each value is
+	// read when the scan reaches its key.
+	Values bool `json:"values"`
+
+	// Size is the number of values fetched ahead. 0 means badger's default
+	// of 100, or the Limit and Offset of a query that stops there if fewer.
+	Size int `json:"size,omitempty"`
+}
+
+// scanIteratorOptions returns the options of an iterator over the stored
+// events for q, which are checked against its filters if filter is set.
+//
+// Without Query.Prefetch values are read as the scan reaches them. Events
+// keep their data in separate records, so the records scanned are small
+// and stored in the LSM tree, and BenchmarkScanPrefetch shows prefetching
+// them slows scans down: by a third for a scan of every event, by half for
+// a scan that skips keys outside its range, and several times for a scan
+// that stops at a small Limit unless the prefetch stops there too.
+func scanIteratorOptions(q Query, filter bool) badger.IteratorOptions {
+	opts := badger.DefaultIteratorOptions
+	opts.Reverse = q.Descending
+	opts.PrefetchValues = false
+
+	p := q.Prefetch
+	if p == nil || !p.Values {
+		return opts
+	}
+	opts.PrefetchValues = true
+	switch limit := q.scanLimit(); {
+	case p.Size > 0:
+		opts.PrefetchSize = p.Size
+	case limit > 0 && !filter:
+		// Values beyond the limit would never be read
+		opts.PrefetchSize = min(limit, opts.PrefetchSize)
+	}
+	return opts
+}
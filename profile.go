@@ -0,0 +1,200 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Profile tunes BadgerDB's memory use and background work for a class of
+// machine. BadgerDB's defaults suit servers and use several hundred MB of
+// memory, more than small devices can spare.
+type Profile int
+
+const (
+	// ProfileDefault keeps BadgerDB's defaults and runs no value log
+	// garbage collection of its own.
+	ProfileDefault Profile = iota
+
+	// ProfileEdge suits devices with little memory, such as a Raspberry
+	// Pi: memtables and caches of a few MB, small value log files, and one
+	// compactor. Expect a few tens of MB of memory, and slower writes and
+	// scans of large ranges.
+	ProfileEdge
+
+	// ProfileLaptop suits desktop applications and development machines,
+	// including Windows, where value log files are allocated at their full
+	// size: around a hundred MB of memory and 256 MB value log files.
+	ProfileLaptop
+
+	// ProfileServer suits dedicated servers: BadgerDB's write buffers with
+	// a larger block cache for scans.
+	ProfileServer
+)
+
+// profileNames maps each Profile to its string form.
+var profileNames = [...]string{
+	ProfileDefault: "default",
+	ProfileEdge:    "edge",
+	ProfileLaptop:  "laptop",
+	ProfileServer:  "server",
+}
+
+// String returns the lower-case name of the profile, e.g. "edge".
+func (p Profile) String() string {
+	if p >= 0 && int(p) < len(profileNames) {
+		return profileNames[p]
+	}
+	return "Profile(" + strconv.Itoa(int(p)) + ")"
+}
+
+// ParseProfile returns the Profile named s. Names are case-insensitive.
+func ParseProfile(s string) (Profile, error) {
+	for i, name := range profileNames {
+		if strings.EqualFold(s, name) {
+			return Profile(i), nil
+		}
+	}
+	return 0, fmt.Errorf("squid: unknown profile %q", s)
+}
+
+// profileSettings are the BadgerDB options and value log garbage collection
+// interval of a profile.
+type profileSettings struct {
+	memTableSize     int64
+	numMemtables     int
+	blockCacheSize   int64
+	indexCacheSize   int64
+	valueLogFileSize int64
+	numCompactors    int
+	level0Tables     int
+	level0Stall      int
+	gcInterval       time.Duration
+}
+
+// profiles holds the settings of every profile but ProfileDefault.
+var profiles = map[Profile]profileSettings{
+	ProfileEdge: {
+		memTableSize:     8 << 20,
+		numMemtables:     2,
+		blockCacheSize:   8 << 20,
+		indexCacheSize:   4 << 20,
+		valueLogFileSize: 64 << 20,
+		numCompactors:    2, // BadgerDB requires at least two
+		level0Tables:     2,
+		level0Stall:      8,
+		gcInterval:       30 * time.Minute,
+	},
+	ProfileLaptop: {
+		memTableSize:     32 << 20,
+		numMemtables:     3,
+		blockCacheSize:   32 << 20,
+		indexCacheSize:   16 << 20,
+		valueLogFileSize: 256 << 20,
+		numCompactors:    2,
+		level0Tables:     4,
+		level0Stall:      12,
+		gcInterval:       15 * time.Minute,
+	},
+	ProfileServer: {
+		memTableSize:     64 << 20,
+		numMemtables:     5,
+		blockCacheSize:   1 << 30,
+		valueLogFileSize: 1<<30 - 1,
+		numCompactors:    4,
+		level0Tables:     5,
+		level0Stall:      15,
+		gcInterval:       5 * time.Minute,
+	},
+}
+
+// applyProfile applies the profile of opts to bopts.
+func applyProfile(opts Options, bopts *badger.Options) error {
+	if opts.Profile == ProfileDefault {
+		return nil
+	}
+	p, ok := profiles[opts.Profile]
+	if !ok {
+		return fmt.Errorf("squid: unknown profile %d", int(opts.Profile))
+	}
+
+	bopts.MemTableSize = p.memTableSize
+	bopts.NumMemtables = p.numMemtables
+	bopts.BlockCacheSize = p.blockCacheSize
+	bopts.IndexCacheSize = p.indexCacheSize
+	bopts.ValueLogFileSize = p.valueLogFileSize
+	bopts.NumCompactors = p.numCompactors
+	bopts.NumLevelZeroTables = p.level0Tables
+	bopts.NumLevelZeroTablesStall = p.level0Stall
+	return nil
+}
+
+// valueLogGCInterval returns how often value log garbage collection runs
+// (0 means never).
+func valueLogGCInterval(opts Options) time.Duration {
+	if opts.ValueLogGCInterval != 0 {
+		return max(opts.ValueLogGCInterval, 0)
+	}
+	return profiles[opts.Profile].gcInterval
+}
+
+// valueLogGC reclaims value log space in the background.
+type valueLogGC struct {
+	interval time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newValueLogGC returns a collector for the given options, or nil if
+// value log garbage collection is disabled.
+func newValueLogGC(opts Options) *valueLogGC {
+	interval := valueLogGCInterval(opts)
+	if interval <= 0 {
+		return nil
+	}
+	return &valueLogGC{interval: interval}
+}
+
+// start collects garbage periodically in a background goroutine.
+func (g *valueLogGC) start(bdb *badger.DB, logger Logger) {
+	ctx, cancel := context.WithCancel(context.Background())
+	g.cancel = cancel
+	g.done = make(chan struct{})
+
+	go func() {
+		defer close(g.done)
+
+		ticker := time.NewTicker(g.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Each run rewrites at most one file; keep going while
+				// files are worth rewriting
+				var err error
+				for err == nil && ctx.Err() == nil {
+					err = bdb.RunValueLogGC(0.5)
+				}
+				if err != badger.ErrNoRewrite && err != badger.ErrRejected && ctx.Err() == nil && logger != nil {
+					logger.Warningf("squid: value log garbage collection failed: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// stop ends garbage collection and waits for the goroutine to exit.
+func (g *valueLogGC) stop() {
+	if g.cancel != nil {
+		g.cancel()
+		<-g.done
+	}
+}
@@ -0,0 +1,68 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestProfiles(t *testing.T) {
+	for _, p := range []Profile{ProfileDefault, ProfileEdge, ProfileLaptop, ProfileServer} {
+		t.Run(p.String(), func(t *testing.T) {
+			dir, err := os.MkdirTemp("", "squid-test-*")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			db, err := OpenWithOptions(dir, Options{Profile: p, ValueLogGCInterval: 10 * time.Millisecond})
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer db.Close()
+
+			for i := 0; i < 100; i++ {
+				if _, err := db.Append(Event{Type: "reading", Data: map[string]any{"i": float64(i)}}); err != nil {
+					t.Fatalf("Append failed: %v", err)
+				}
+			}
+			events, err := db.Query(context.Background(), Query{Types: []string{"reading"}})
+			if err != nil || len(events) != 100 {
+				t.Fatalf("expected 100 events, got %d (%v)", len(events), err)
+			}
+
+			// Give the collector a chance to run before Close stops it
+			time.Sleep(30 * time.Millisecond)
+		})
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	bopts := badger.DefaultOptions("")
+	if err := applyProfile(Options{Profile: ProfileEdge}, &bopts); err != nil {
+		t.Fatal(err)
+	}
+	if bopts.MemTableSize != 8<<20 || bopts.BlockCacheSize != 8<<20 || bopts.ValueLogFileSize != 64<<20 {
+		t.Errorf("unexpected edge options: memtable %d, block cache %d, value log files %d",
+			bopts.MemTableSize, bopts.BlockCacheSize, bopts.ValueLogFileSize)
+	}
+	if got := valueLogGCInterval(Options{Profile: ProfileEdge}); got != 30*time.Minute {
+		t.Errorf("expected a 30 minute collection interval, got %v", got)
+	}
+	if got := valueLogGCInterval(Options{Profile: ProfileEdge, ValueLogGCInterval: -1}); got != 0 {
+		t.Errorf("expected a negative interval to disable collection, got %v", got)
+	}
+	if got := valueLogGCInterval(Options{}); got != 0 {
+		t.Errorf("expected no collection by default, got %v", got)
+	}
+
+	if err := applyProfile(Options{Profile: Profile(42)}, &bopts); err == nil {
+		t.Error("expected an error for an unknown profile")
+	}
+	if p, err := ParseProfile("Laptop"); err != nil || p != ProfileLaptop {
+		t.Errorf("expected ProfileLaptop, got %v (%v)", p, err)
+	}
+}
@@ -0,0 +1,192 @@
+package squid
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Progress reports how far a long operation has got, so that tools can
+// render progress bars and operators can tell a hung operation from a slow
+// one.
+type Progress struct {
+	// Operation is the reporting operation: "export", "import", "delete"
+	// or "compact index".
+	Operation string `json:"operation"`
+
+	// Items is the number of events processed so far, or of index entries
+	// for "compact index".
+	Items int64 `json:"items"`
+
+	// Bytes is the number of bytes written by an export, read by an import
+	// or of index entries read by a compaction so far.
+	Bytes int64 `json:"bytes"`
+
+	// TotalItems and TotalBytes are the items and bytes the operation
+	// expects to process, or 0 if unknown. Exports know the events they
+	// write and imports the size of seekable input; deletions estimate
+	// their total from the hourly counts.
+	TotalItems int64 `json:"total_items,omitempty"`
+	TotalBytes int64 `json:"total_bytes,omitempty"`
+
+	// Elapsed is the time since the operation started.
+	Elapsed time.Duration `json:"elapsed"`
+
+	// ETA estimates the time left from the rate so far, or is 0 if neither
+	// total is known.
+	ETA time.Duration `json:"eta,omitempty"`
+
+	// Done is set in the last report of an operation, whether it succeeded
+	// or failed.
+	Done bool `json:"done,omitempty"`
+}
+
+// progressInterval is the minimum time between the reports of an operation.
+const progressInterval = 200 * time.Millisecond
+
+// progressKey is the context key for a progress callback.
+type progressKey struct{}
+
+// progressTrackerKey is the context key for the *progressTracker of a
+// running operation, for the code it calls.
+type progressTrackerKey struct{}
+
+// WithProgress returns a context that makes Export, ExportWithManifest,
+// Import, ImportVerified, DeleteBeforeContext and CompactIndex report their
+// progress to fn as they run, at most every 200ms and once more when they
+// end. fn is called from the operation's goroutine and should return
+// quickly.
+func WithProgress(ctx context.Context, fn func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// progressTracker accumulates the progress of an operation and reports it.
+// A nil tracker, returned when the context has no callback, ignores calls.
+type progressTracker struct {
+	fn    func(Progress)
+	p     Progress
+	start time.Time
+	last  time.Time
+}
+
+// startProgress returns a tracker for an operation if ctx has a progress
+// callback, or nil.
+func startProgress(ctx context.Context, operation string) *progressTracker {
+	fn, _ := ctx.Value(progressKey{}).(func(Progress))
+	if fn == nil {
+		return nil
+	}
+	return &progressTracker{fn: fn, p: Progress{Operation: operation}, start: time.Now()}
+}
+
+// progressFrom returns the tracker of the operation running with ctx.
+func progressFrom(ctx context.Context) *progressTracker {
+	t, _ := ctx.Value(progressTrackerKey{}).(*progressTracker)
+	return t
+}
+
+// with returns a context carrying the tracker, for the code the operation
+// calls.
+func (t *progressTracker) with(ctx context.Context) context.Context {
+	if t == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, progressTrackerKey{}, t)
+}
+
+// total sets the expected totals; zero leaves a total unknown.
+func (t *progressTracker) total(items, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.p.TotalItems, t.p.TotalBytes = items, bytes
+}
+
+// add records processed items and bytes, reporting if the last report is
+// older than progressInterval.
+func (t *progressTracker) add(items, bytes int64) {
+	if t == nil {
+		return
+	}
+	t.p.Items += items
+	t.p.Bytes += bytes
+	if now := time.Now(); now.Sub(t.last) >= progressInterval {
+		t.last = now
+		t.report(now)
+	}
+}
+
+// done sends the last report.
+func (t *progressTracker) done() {
+	if t == nil {
+		return
+	}
+	t.p.Done = true
+	t.report(time.Now())
+}
+
+// report sends the progress at now, estimating the time left from the
+// larger of the fractions of the totals processed.
+func (t *progressTracker) report(now time.Time) {
+	p := t.p
+	p.Elapsed = now.Sub(t.start)
+
+	var frac float64
+	if p.TotalItems > 0 {
+		frac = float64(p.Items) / float64(p.TotalItems)
+	}
+	if p.TotalBytes > 0 {
+		frac = max(frac, float64(p.Bytes)/float64(p.TotalBytes))
+	}
+	if !p.Done && frac > 0 && frac < 1 {
+		p.ETA = time.Duration(float64(p.Elapsed) * (1 - frac) / frac)
+	}
+	t.fn(p)
+}
+
+// progressWriter counts the bytes written through it as progress.
+type progressWriter struct {
+	w io.Writer
+	t *progressTracker
+}
+
+func (pw progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.t.add(0, int64(n))
+	return n, err
+}
+
+// progressReader counts the bytes read through it as progress.
+type progressReader struct {
+	r io.Reader
+	t *progressTracker
+}
+
+func (pr progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	pr.t.add(0, int64(n))
+	return n, err
+}
+
+// remainingSize returns the number of bytes left to read from r, or 0 if
+// r cannot tell.
+func remainingSize(r io.Reader) int64 {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len())
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0
+		}
+		return end - cur
+	}
+	return 0
+}
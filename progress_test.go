@@ -0,0 +1,112 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := make([]Event, 50)
+	for i := range events {
+		events[i] = Event{Timestamp: base.Add(time.Duration(i) * time.Minute), Type: "request", Tags: map[string]string{"host": "a"}}
+	}
+	if _, err := db.AppendBatch(events); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	var reports []Progress
+	ctx := WithProgress(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	})
+	last := func(operation string) Progress {
+		t.Helper()
+		if len(reports) == 0 {
+			t.Fatalf("%s: no progress reported", operation)
+		}
+		p := reports[len(reports)-1]
+		if p.Operation != operation || !p.Done {
+			t.Fatalf("%s: unexpected last report %+v", operation, p)
+		}
+		reports = nil
+		return p
+	}
+
+	var buf bytes.Buffer
+	if err := db.Export(ctx, &buf, Query{}, CSV); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if p := last("export"); p.Items != 50 || p.TotalItems != 50 || p.Bytes != int64(buf.Len()) {
+		t.Errorf("unexpected export progress %+v", p)
+	}
+
+	buf.Reset()
+	if err := db.Export(context.Background(), &buf, Query{}, JSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	size := int64(buf.Len())
+	if n, err := db.Import(ctx, &buf, JSON); err != nil || n != 50 {
+		t.Fatalf("expected 50 imported, got %d, %v", n, err)
+	}
+	if p := last("import"); p.Items != 50 || p.Bytes != size || p.TotalBytes != size {
+		t.Errorf("unexpected import progress %+v", p)
+	}
+
+	if _, err := db.CompactIndex(ctx); err != nil {
+		t.Fatalf("CompactIndex failed: %v", err)
+	}
+	if p := last("compact index"); p.Items != 200 { // type and tag entries of the events and their imports
+		t.Errorf("unexpected compaction progress %+v", p)
+	}
+
+	deleted, err := db.DeleteBeforeContext(ctx, base.Add(30*time.Minute))
+	if err != nil {
+		t.Fatalf("DeleteBeforeContext failed: %v", err)
+	}
+	if p := last("delete"); deleted != 60 || p.Items != 60 || p.TotalItems < 60 {
+		t.Errorf("expected 60 deleted, got %d with progress %+v", deleted, p)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := db.DeleteBeforeContext(cancelled, base.Add(time.Hour)); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	var got Progress
+	now := time.Now()
+	tracker := &progressTracker{
+		fn:    func(p Progress) { got = p },
+		p:     Progress{Items: 10, TotalItems: 40, Bytes: 1, TotalBytes: 100},
+		start: now.Add(-time.Second),
+	}
+
+	// A quarter of the items in a second leaves three
+	tracker.report(now)
+	if got.Elapsed != time.Second || got.ETA != 3*time.Second {
+		t.Errorf("expected 3s left after 1s, got %+v", got)
+	}
+
+	tracker.done()
+	if !got.Done || got.ETA != 0 {
+		t.Errorf("expected no ETA once done, got %+v", got)
+	}
+}
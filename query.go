@@ -2,7 +2,10 @@ package squid
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -12,28 +15,224 @@ import (
 // Query defines search criteria for events.
 type Query struct {
 	// Start is the inclusive start time (nil means no lower bound).
-	Start *time.Time
+	Start *time.Time `json:"start,omitempty"`
 
 	// End is the inclusive end time (nil means no upper bound).
-	End *time.Time
+	End *time.Time `json:"end,omitempty"`
 
 	// Types filters by event type (empty means all types).
-	Types []string
-
-	// Tags filters by tag key-value pairs (all must match).
-	Tags map[string]string
+	Types []string `json:"types,omitempty"`
+
+	// TypePattern filters by a regular expression (RE2 syntax) that must
+	// match the whole event type, such as `payment\..*`. Without Types, the
+	// type index entries of every type it matches are read.
+	TypePattern string `json:"type_pattern,omitempty"`
+
+	// Tags filters by tag key-value pairs (all must match). A value with a
+	// * matches tag values as a pattern: * matches any run of characters,
+	// so "web-*" matches "web-1" and "web-eu-2", and within a pattern \*
+	// and \\ match a literal star and backslash. A pattern visits every
+	// distinct value of its tag in the index, skipping those that do not
+	// match.
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// ExcludeTypes leaves out events of the given types, such as health
+	// checks.
+	ExcludeTypes []string `json:"exclude_types,omitempty"`
+
+	// ExcludeTags leaves out events with any of the given tag key-value
+	// pairs, whose values may be patterns as in Tags. Exclusions never
+	// narrow the index a query is answered from, so excluded events are
+	// still read.
+	ExcludeTags map[string]string `json:"exclude_tags,omitempty"`
+
+	// Where filters by the data of events (all predicates must match).
+	// Data is not indexed, so the data of every event that passes the
+	// other filters is read to check it, even with OmitData.
+	Where []Predicate `json:"where,omitempty"`
+
+	// HasFields requires events to have all of the given data fields, and
+	// MissingFields to have none of them. A field set to null exists. Like
+	// Where, they are checked against the data of every event that passes
+	// the other filters.
+	HasFields     []string `json:"has_fields,omitempty"`
+	MissingFields []string `json:"missing_fields,omitempty"`
+
+	// Search finds events whose data has every word of it in its string
+	// values, at any depth. Words are runs of letters and digits, matched
+	// case-insensitively, and a word followed by * matches the words it
+	// starts: "disk full" matches "Disk is FULL", and "time*" matches
+	// "timeout". With Options.SearchIndex the search index finds the events
+	// with one of the words; otherwise it is checked like Where.
+	Search string `json:"search,omitempty"`
+
+	// SearchTags makes Search also match words of tag values.
+	SearchTags bool `json:"search_tags,omitempty"`
 
 	// Limit is the maximum number of events to return (0 means no limit).
 	// TODO(asungur): Add input validation and avoid large numbers.
-	Limit int
+	Limit int `json:"limit,omitempty"`
+
+	// Offset is the number of matching events to skip before returning
+	// any, for paging with Limit. Skipped events are still found, so deep
+	// pages cost as much as reading every event before them; paging with
+	// MinID or MaxID from the last event seen is cheaper. Aggregations
+	// ignore it.
+	Offset int `json:"offset,omitempty"`
 
 	// Descending returns events in reverse chronological order.
-	Descending bool
+	Descending bool `json:"descending,omitempty"`
+
+	// Hint overrides the planner's choice of index (empty lets it decide).
+	Hint Hint `json:"hint,omitempty"`
+
+	// OmitData returns events without their data, which is then not read.
+	OmitData bool `json:"omit_data,omitempty"`
+
+	// MinID is the inclusive lowest event ID (nil means no lower bound).
+	MinID *ulid.ULID `json:"min_id,omitempty"`
+
+	// MaxID is the inclusive highest event ID (nil means no upper bound).
+	MaxID *ulid.ULID `json:"max_id,omitempty"`
+
+	// IngestedStart is the inclusive earliest time events were appended
+	// (nil means no lower bound). Unlike Start, it finds events backfilled
+	// with past timestamps, e.g. for consumers of what arrived in the last
+	// five minutes. Events stored before ingest times were recorded count
+	// as appended at their Timestamp.
+	IngestedStart *time.Time `json:"ingested_start,omitempty"`
+
+	// IngestedEnd is the inclusive latest time events were appended (nil
+	// means no upper bound).
+	IngestedEnd *time.Time `json:"ingested_end,omitempty"`
+
+	// OrderByIngest returns events in the order they were appended rather
+	// than in event time order, so that replication and audit consumers see
+	// backfilled events where they arrived. Events appended together are
+	// ordered by ID. A consumer can resume from the IngestedAt of the last
+	// event it processed, skipping the events at that time it has seen.
+	// Unless the ingest time index answers the query, every matching event
+	// is read before the Limit is applied.
+	OrderByIngest bool `json:"order_by_ingest,omitempty"`
+
+	// Latest skips events that a correction supersedes, so that a corrected
+	// event is returned as its latest correction, where that matches the
+	// query, and appears where the correction's timestamp places it.
+	// Corrections are appended with Event.Supersedes set.
+	Latest bool `json:"latest,omitempty"`
+
+	// Partial makes Query, QueryCount and Aggregate return what they found before the
+	// context's deadline expired instead of context.DeadlineExceeded, for
+	// best-effort dashboards over huge ranges. A ScanReport attached to the
+	// context records whether a query was cut short, and AggregateResult
+	// has its own flag. Cancellation is still an error.
+	Partial bool `json:"partial,omitempty"`
+
+	// Prefetch tunes how scans of the stored events read ahead (nil reads
+	// each value as the scan reaches it, see PrefetchOptions).
+	Prefetch *PrefetchOptions `json:"prefetch,omitempty"`
+}
+
+// scanLimit returns the number of matching events a scan must find to
+// return Limit events after skipping Offset (0 means no limit).
+func (q Query) scanLimit() int {
+	if q.Limit <= 0 {
+		return 0
+	}
+	return q.Limit + q.Offset
+}
+
+// hasIngestRange reports whether the query bounds the time events were
+// appended.
+func (q Query) hasIngestRange() bool {
+	return q.IngestedStart != nil || q.IngestedEnd != nil
+}
+
+// filtersData reports whether the query filters on the data of events.
+func (q Query) filtersData() bool {
+	return len(q.Where) > 0 || len(q.HasFields) > 0 || len(q.MissingFields) > 0 || q.Search != ""
+}
+
+// IDRange returns a copy of q restricted to events with IDs from min to max
+// inclusive. Unlike Start and End, ID bounds keep the order of events
+// created within the same millisecond, so they suit cursors that remember
+// the last ID seen.
+func (q Query) IDRange(min, max ulid.ULID) Query {
+	q.MinID, q.MaxID = &min, &max
+	return q
 }
 
 // Query finds events matching the given criteria.
 // The context can be used to cancel long-running queries.
 func (db *DB) Query(ctx context.Context, q Query) ([]*Event, error) {
+	return db.query(ctx, q, false)
+}
+
+// queryPages calls fn with the events matching q in pages of up to size
+// events, each read by its own query, so that results need not fit in
+// memory. A page resumes after the ID of the last event of the previous
+// one. Queries ordered by ingest time cannot resume that way and are read
+// at once, then split into pages.
+func (db *DB) queryPages(ctx context.Context, q Query, size int, fn func(events []*Event) error) error {
+	if q.OrderByIngest {
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(events); start += size {
+			if err := fn(events[start:min(start+size, len(events))]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	remaining := q.Limit
+	for {
+		page := q
+		page.Limit = size
+		if remaining > 0 {
+			page.Limit = min(size, remaining)
+		}
+		events, err := db.Query(ctx, page)
+		if err != nil {
+			return err
+		}
+		if len(events) > 0 {
+			if err := fn(events); err != nil {
+				return err
+			}
+		}
+		if len(events) < page.Limit {
+			return nil
+		}
+		if remaining > 0 {
+			if remaining -= len(events); remaining == 0 {
+				return nil
+			}
+		}
+
+		// Skipped events are before the first page
+		q.Offset = 0
+		last := events[len(events)-1].ID
+		if q.Descending {
+			if last == (ulid.ULID{}) {
+				return nil
+			}
+			prev := prevID(last)
+			q.MaxID = &prev
+		} else {
+			next := nextID(last)
+			if next == (ulid.ULID{}) {
+				return nil
+			}
+			q.MinID = &next
+		}
+	}
+}
+
+// query runs a query, decoding into pooled events if pooled is set.
+func (db *DB) query(ctx context.Context, q Query, pooled bool) ([]*Event, error) {
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
@@ -45,68 +244,278 @@ func (db *DB) Query(ctx context.Context, q Query) ([]*Event, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	defer recordDuration(ctx, time.Now())
+
+	if q.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+
+	// Unless the index returns events in ingest order, they are sorted
+	// once all are found
+	limit, offset := q.Limit, q.Offset
+	sortByIngest := q.OrderByIngest && !db.ingestIndexUsed(q)
+	if sortByIngest {
+		q.Limit, q.Offset = 0, 0
+	}
 
 	var events []*Event
+	alloc := &eventAlloc{pooled: pooled}
+	defer alloc.done()
 
 	err := db.badger.View(func(txn *badger.Txn) error {
 		// Determine which scan strategy to use
-		candidateIDs, useIndex := db.planQuery(ctx, txn, q)
+		candidateIDs, useIndex, scanErr := db.planQuery(ctx, txn, q)
+		readCtx, ok := partialRead(ctx, q, useIndex, scanErr)
+		if !ok {
+			return scanErr
+		}
 
+		var err error
 		if useIndex {
 			// Fetch events by ID from index scan results
-			events = db.fetchEventsByIDs(ctx, txn, candidateIDs, q)
+			events, err = db.fetchEventsByIDs(readCtx, txn, candidateIDs, q, alloc)
 		} else {
 			// Full scan on primary event keys
-			events = db.fullScan(ctx, txn, q)
+			events, err = db.fullScan(ctx, txn, q, alloc)
 		}
-
-		return ctx.Err()
+		if err == nil {
+			err = scanErr
+		}
+		return err
 	})
 
+	if isPartial(ctx, q, err) {
+		err = nil
+	}
 	if err != nil {
+		if pooled {
+			(&Borrowed{Events: events}).Release()
+		}
 		return nil, err
 	}
 
+	if sortByIngest {
+		sortByIngestOrder(events, q.Descending)
+		if offset > 0 {
+			offset = min(offset, len(events))
+			if pooled {
+				(&Borrowed{Events: events[:offset]}).Release()
+			}
+			events = events[offset:]
+		}
+		if limit > 0 && len(events) > limit {
+			if pooled {
+				(&Borrowed{Events: events[limit:]}).Release()
+			}
+			events = events[:limit]
+		}
+	}
+
 	return events, nil
 }
 
+// isPartial reports whether err ends a query that returns partial results
+// (see Query.Partial), recording in the context's ScanReport that it does.
+func isPartial(ctx context.Context, q Query, err error) bool {
+	if !q.Partial || !errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if r := scanReportFrom(ctx); r != nil {
+		r.Partial = true
+	}
+	return true
+}
+
+// partialRead returns the context to read the candidates of planQuery with,
+// or false if its error ends the query. When the deadline cuts the index
+// scan of a Partial query short, the candidates it found are read
+// regardless, and the caller reports the scan's error once they are.
+func partialRead(ctx context.Context, q Query, useIndex bool, err error) (context.Context, bool) {
+	switch {
+	case err == nil:
+		return ctx, true
+	case useIndex && q.Partial && errors.Is(err, context.DeadlineExceeded):
+		return context.WithoutCancel(ctx), true
+	}
+	return ctx, false
+}
+
 // planQuery decides whether to use an index and returns candidate IDs if so.
-// TODO(asungur): Query planning prioritises type index.
-// This could be improved by approximating selectivity of each index type,
-// and choosing the more performant index.
-func (db *DB) planQuery(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, bool) {
-	// If we have a single type filter, use the type index
-	// TODO(asungur): If we have multiple type filters, we should use the union of the indices.
-	if len(q.Types) == 1 {
-		ids := db.scanTypeIndex(ctx, txn, q.Types[0], q)
-		return ids, true
-	}
-
-	// If we have tag filters, use the first tag's index
-	// (smallest result set heuristic would require counting, skip for MVP)
-	for k, v := range q.Tags {
-		ids := db.scanTagIndex(ctx, txn, k, v, q)
-		return ids, true
+// It returns the context's error if the index scan is cancelled, with the
+// IDs found before it was.
+func (db *DB) planQuery(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, bool, error) {
+	index, err := db.chooseIndex(q)
+	if err != nil {
+		return nil, false, err
+	}
+	recordPlan(ctx, describeIndex(index))
+
+	// The hourly counts cheaply tell us when a time range has no events
+	// of the requested types, which saves scanning the indices
+	if q.Hint != ForceFullScan && len(q.Types) > 0 && (q.Start != nil || q.End != nil) && db.countsCover(q) {
+		n, err := db.countEvents(ctx, txn, q)
+		if err != nil {
+			return nil, false, err
+		}
+		if n == 0 {
+			recordPlan(ctx, "hourly counts")
+			return nil, true, nil
+		}
+	}
+
+	if index == "type" {
+		if len(q.Types) == 0 {
+			ids, err := db.scanTypePattern(ctx, txn, q)
+			return ids, true, err
+		}
+		if len(q.Types) > 1 {
+			ids, err := db.scanTypeIndexes(ctx, txn, q)
+			return ids, true, err
+		}
+		ids, err := db.scanTypeIndex(ctx, txn, q.Types[0], q)
+		return ids, true, err
+	}
+	if index == "ingest" {
+		ids, err := db.scanIngestIndex(ctx, txn, q)
+		return ids, true, err
+	}
+	if index == "search" {
+		ids, err := db.scanSearchIndex(ctx, txn, q)
+		return ids, true, err
+	}
+	if index == "tags" {
+		ids, err := db.scanTagIntersection(ctx, txn, q)
+		return ids, true, err
+	}
+	if key, ok := strings.CutPrefix(index, "tag:"); ok {
+		if value := q.Tags[key]; isTagPattern(value) {
+			ids, err := db.scanTagPattern(ctx, txn, key, value, q)
+			return ids, true, err
+		}
+		ids, err := db.scanTagIndex(ctx, txn, key, q.Tags[key], q)
+		return ids, true, err
 	}
 
 	// No suitable index, use full scan
-	return nil, false
+	return nil, false, nil
 }
 
 // scanTypeIndex scans the type index for matching event IDs.
-func (db *DB) scanTypeIndex(ctx context.Context, txn *badger.Txn, eventType string, q Query) []ulid.ULID {
+func (db *DB) scanTypeIndex(ctx context.Context, txn *badger.Txn, eventType string, q Query) ([]ulid.ULID, error) {
 	prefix := encodeTypeIndexPrefix(eventType)
 	return db.scanIndex(ctx, txn, prefix, q)
 }
 
+// scanTypeIndexes scans the type index of each of the query's types and
+// merges the IDs found into the query's order.
+func (db *DB) scanTypeIndexes(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, error) {
+	types := slices.Clone(q.Types)
+	slices.Sort(types)
+	types = slices.Compact(types)
+
+	prefixes := make([][]byte, len(types))
+	for i, t := range types {
+		prefixes[i] = encodeTypeIndexPrefix(t)
+	}
+	return db.scanIndexUnion(ctx, txn, prefixes, q)
+}
+
 // scanTagIndex scans the tag index for matching event IDs.
-func (db *DB) scanTagIndex(ctx context.Context, txn *badger.Txn, tagKey, tagValue string, q Query) []ulid.ULID {
+func (db *DB) scanTagIndex(ctx context.Context, txn *badger.Txn, tagKey, tagValue string, q Query) ([]ulid.ULID, error) {
 	prefix := encodeTagIndexPrefix(tagKey, tagValue)
 	return db.scanIndex(ctx, txn, prefix, q)
 }
 
+// scanIngestIndex scans the ingest time index for the IDs of events appended
+// in the query's ingest time range, returning them in the query's order.
+func (db *DB) scanIngestIndex(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, error) {
+	var ids []ulid.ULID
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false // Index keys have no values
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := []byte(prefixIngest)
+	seekKey := prefix
+	if q.IngestedStart != nil {
+		seekKey = encodeIngestIndexKey(*q.IngestedStart, ulid.ULID{})
+	}
+
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+	for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+		if scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		scanned++
+
+		t, id, err := decodeIngestIndexKey(it.Item().Key())
+		if err != nil {
+			db.recordCorrupt(ctx)
+			continue
+		}
+		if q.IngestedEnd != nil && t.After(*q.IngestedEnd) {
+			break
+		}
+		if db.matchesTimeRange(id, q) {
+			ids = append(ids, id)
+		}
+	}
+
+	if q.OrderByIngest {
+		if q.Descending {
+			slices.Reverse(ids)
+		}
+		return ids, nil
+	}
+
+	// Events are appended in ingest order but returned in ID order, so the
+	// limit can only be applied once all are found
+	slices.SortFunc(ids, func(a, b ulid.ULID) int {
+		if q.Descending {
+			return b.Compare(a)
+		}
+		return a.Compare(b)
+	})
+	if limit := q.scanLimit(); limit > 0 && len(ids) > limit && !needsFilter(q, true) {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// ingestIndexUsed reports whether the planner answers q from the ingest time
+// index.
+func (db *DB) ingestIndexUsed(q Query) bool {
+	if q.Hint != "" {
+		return q.Hint == ForceIndex("ingest")
+	}
+	return plannedIndex(q, db.opts) == "ingest"
+}
+
+// sortByIngestOrder sorts events in the order they were appended, or the
+// reverse order if descending is set.
+func sortByIngestOrder(events []*Event, descending bool) {
+	slices.SortFunc(events, func(a, b *Event) int {
+		if descending {
+			a, b = b, a
+		}
+		if c := a.ingestedAt().Compare(b.ingestedAt()); c != 0 {
+			return c
+		}
+		return a.ID.Compare(b.ID)
+	})
+}
+
+// scanCheckInterval is how many keys a scan visits between context checks.
+const scanCheckInterval = 1000
+
 // scanIndex scans an index prefix and returns matching event IDs.
-func (db *DB) scanIndex(ctx context.Context, txn *badger.Txn, prefix []byte, q Query) []ulid.ULID {
+// The context is checked every scanCheckInterval keys.
+func (db *DB) scanIndex(ctx context.Context, txn *badger.Txn, prefix []byte, q Query) ([]ulid.ULID, error) {
 	var ids []ulid.ULID
 
 	opts := badger.DefaultIteratorOptions
@@ -123,80 +532,127 @@ func (db *DB) scanIndex(ctx context.Context, txn *badger.Txn, prefix []byte, q Q
 		seekKey = prefixEnd(prefix)
 	}
 
+	// Candidates the remaining filters may reject don't count towards the
+	// limit
+	limit := q.scanLimit()
+	if needsFilter(q, true) {
+		limit = 0
+	}
+
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
 	for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
-		// Check for cancellation periodically
-		if ctx.Err() != nil {
-			break
+		// Check for cancellation periodically; the IDs found so far make a
+		// partial result
+		if scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return ids, err
+			}
 		}
+		scanned++
 
 		key := it.Item().Key()
 
 		id, err := decodeIndexKey(key)
 		if err != nil {
+			db.recordCorrupt(ctx)
 			continue
 		}
 
 		// Apply time filter
 		if !db.matchesTimeRange(id, q) {
+			// Index entries are ordered by ID too
+			if pastRange(id, q) {
+				break
+			}
 			continue
 		}
 
 		ids = append(ids, id)
 
-		if q.Limit > 0 && len(ids) >= q.Limit {
+		if limit > 0 && len(ids) >= limit {
 			break
 		}
 	}
 
-	return ids
+	return ids, nil
 }
 
-// fetchEventsByIDs retrieves events by their IDs and applies remaining filters.
-func (db *DB) fetchEventsByIDs(ctx context.Context, txn *badger.Txn, ids []ulid.ULID, q Query) []*Event {
+// fetchEventsByIDs retrieves events by their IDs and applies remaining
+// filters. Candidates outside the time range are dropped before any record
+// is read, and the records are fetched in chunks ahead of the filters:
+// without filters, only as many as the limit still needs.
+func (db *DB) fetchEventsByIDs(ctx context.Context, txn *badger.Txn, ids []ulid.ULID, q Query, alloc *eventAlloc) ([]*Event, error) {
 	var events []*Event
-
-	for _, id := range ids {
-		// Check for cancellation
-		if ctx.Err() != nil {
-			break
+	var skipped int
+	filter := needsFilter(q, true)
+	ids = db.pruneCandidates(ids, q)
+
+	for len(ids) > 0 {
+		n := fetchChunk
+		if !filter && q.Limit > 0 {
+			n = q.Offset - skipped + q.Limit - len(events)
 		}
+		chunk := ids[:min(n, len(ids))]
+		ids = ids[len(chunk):]
 
-		item, err := txn.Get(encodeEventKey(id))
-		if err != nil {
-			continue
-		}
+		for i, f := range prefetch(txn, chunk) {
+			// Check for cancellation
+			if err := ctx.Err(); err != nil {
+				return events, err
+			}
 
-		var event Event
-		err = item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &event)
-		})
-		if err != nil {
-			continue
-		}
+			recordScanned(ctx, 1)
+			if f.err == badger.ErrKeyNotFound {
+				db.recordDangling(ctx)
+				continue
+			}
+			if f.err != nil {
+				return nil, &QueryError{Stage: StageFetch, Key: encodeEventKey(chunk[i]), Err: f.err}
+			}
 
-		// Apply remaining filters
-		if !db.matchesFilters(&event, q) {
-			continue
-		}
+			// Without filters every stored candidate matches, so skipped
+			// events need not be decoded
+			if !filter && skipped < q.Offset {
+				skipped++
+				continue
+			}
 
-		events = append(events, &event)
+			event := alloc.get()
+			ok, err := db.readMatching(ctx, txn, f.item, q, filter, event)
+			if err != nil {
+				alloc.reject(event)
+				return events, err
+			}
+			if !ok {
+				alloc.reject(event)
+				continue
+			}
+			if skipped < q.Offset {
+				alloc.reject(event)
+				skipped++
+				continue
+			}
 
-		if q.Limit > 0 && len(events) >= q.Limit {
-			break
+			events = append(events, event)
+
+			if q.Limit > 0 && len(events) >= q.Limit {
+				return events, nil
+			}
 		}
 	}
 
-	return events
+	return events, nil
 }
 
 // fullScan iterates over all events and applies filters.
-func (db *DB) fullScan(ctx context.Context, txn *badger.Txn, q Query) []*Event {
+// The context is checked every scanCheckInterval keys.
+func (db *DB) fullScan(ctx context.Context, txn *badger.Txn, q Query, alloc *eventAlloc) ([]*Event, error) {
 	var events []*Event
+	var skipped int
+	filter := needsFilter(q, false)
 
-	opts := badger.DefaultIteratorOptions
-	opts.Reverse = q.Descending
-
-	it := txn.NewIterator(opts)
+	it := txn.NewIterator(scanIteratorOptions(q, filter))
 	defer it.Close()
 
 	prefix := eventKeyPrefix()
@@ -205,11 +661,16 @@ func (db *DB) fullScan(ctx context.Context, txn *badger.Txn, q Query) []*Event {
 		seekKey = prefixEnd(prefix)
 	}
 
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
 	for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
 		// Check for cancellation periodically
-		if ctx.Err() != nil {
-			break
+		if scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return events, err
+			}
 		}
+		scanned++
 
 		item := it.Item()
 		key := item.Key()
@@ -217,46 +678,185 @@ func (db *DB) fullScan(ctx context.Context, txn *badger.Txn, q Query) []*Event {
 		// Extract ULID from key for time filtering before deserializing
 		id, err := decodeEventKey(key)
 		if err != nil {
+			db.recordCorrupt(ctx)
 			continue
 		}
 
 		// Apply time filter early
 		if !db.matchesTimeRange(id, q) {
-			// For ascending order, if we're past the end time, stop
-			if !q.Descending && q.End != nil && ulidTime(id).After(*q.End) {
-				break
-			}
-			// For descending order, if we're before the start time, stop
-			if q.Descending && q.Start != nil && ulidTime(id).Before(*q.Start) {
+			// Keys are ordered by ID, so nothing past the range can match
+			if pastRange(id, q) {
 				break
 			}
 			continue
 		}
 
-		var event Event
-		err = item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &event)
-		})
-		if err != nil {
+		if !filter && skipped < q.Offset {
+			skipped++
 			continue
 		}
 
-		// Apply remaining filters
-		if !db.matchesFilters(&event, q) {
+		event := alloc.get()
+		ok, err := db.readMatching(ctx, txn, item, q, filter, event)
+		if err != nil {
+			alloc.reject(event)
+			return events, err
+		}
+		if !ok {
+			alloc.reject(event)
+			continue
+		}
+		if skipped < q.Offset {
+			alloc.reject(event)
+			skipped++
 			continue
 		}
 
-		events = append(events, &event)
+		events = append(events, event)
 
 		if q.Limit > 0 && len(events) >= q.Limit {
 			break
 		}
 	}
 
-	return events
+	return events, nil
 }
 
-// matchesTimeRange checks if an event ID falls within the query time range.
+// decodeItem unmarshals the primary record of an event, which holds
+// everything but its data. Read failures are returned as a *QueryError with
+// StageFetch, and values that are not valid events as a *QueryError with
+// StageDecode wrapping ErrCorruptRecord.
+func (db *DB) decodeItem(item *badger.Item, event *Event) error {
+	var decodeErr error
+	err := item.Value(func(val []byte) error {
+		decodeErr = db.codec().Unmarshal(val, event)
+		return nil
+	})
+	if err != nil {
+		return &QueryError{Stage: StageFetch, Key: item.KeyCopy(nil), Err: err}
+	}
+	if decodeErr != nil {
+		return &QueryError{Stage: StageDecode, Key: item.KeyCopy(nil), Err: fmt.Errorf("%w: %w", ErrCorruptRecord, decodeErr)}
+	}
+	return nil
+}
+
+// loadData reads an event's data from its payload record, leaving the data
+// empty if it has none. Errors are returned as by decodeItem.
+func (db *DB) loadData(txn *badger.Txn, event *Event) error {
+	var decodeErr error
+	_, err := db.readData(txn, event.ID, func(data []byte) error {
+		decodeErr = db.codec().Unmarshal(data, &event.Data)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if decodeErr != nil {
+		return &QueryError{Stage: StageDecode, Key: encodeDataKey(event.ID), Err: fmt.Errorf("%w: %w", ErrCorruptRecord, decodeErr)}
+	}
+	return nil
+}
+
+// decodeEvent unmarshals the event whose primary record is item, including
+// its data.
+func (db *DB) decodeEvent(txn *badger.Txn, item *badger.Item, event *Event) error {
+	if err := db.decodeItem(item, event); err != nil {
+		return err
+	}
+	return db.loadData(txn, event)
+}
+
+// readEvent decodes the event stored in item for a scan, with its data
+// unless q.OmitData is set. Corrupt records are recorded and skipped, so it
+// returns false without an error; read failures are returned.
+func (db *DB) readEvent(ctx context.Context, txn *badger.Txn, item *badger.Item, q Query, event *Event) (bool, error) {
+	recordDecoded(ctx)
+	err := db.decodeItem(item, event)
+	if err == nil && !q.OmitData {
+		err = db.loadData(txn, event)
+	}
+	if errors.Is(err, ErrCorruptRecord) {
+		db.recordCorrupt(ctx)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// eventHeader holds the fields of a stored event that queries filter on.
+type eventHeader struct {
+	Timestamp  time.Time         `json:"timestamp"`
+	Type       string            `json:"type"`
+	Tags       map[string]string `json:"tags"`
+	IngestedAt time.Time         `json:"ingested_at"`
+}
+
+// needsFilter reports whether events found for q must be checked against its
+// filters: always for scans, and for index lookups unless the index covers
+// the only filter.
+func needsFilter(q Query, useIndex bool) bool {
+	// The search index only finds events with one of the words, and no
+	// index knows which events are superseded
+	if q.Search != "" || q.Latest {
+		return true
+	}
+
+	// Several types are one filter, which the union of their indices covers
+	n := min(len(q.Types), 1) + len(q.Tags) + len(q.ExcludeTypes) + len(q.ExcludeTags) +
+		len(q.Where) + len(q.HasFields) + len(q.MissingFields)
+	if q.TypePattern != "" {
+		n++
+	}
+	if q.hasIngestRange() {
+		n++
+	}
+	if useIndex {
+		return n > 1
+	}
+	return n > 0
+}
+
+// readMatching decodes the event stored in item for a scan if it matches the
+// query's filters, reporting whether it did. With filter set, the filters
+// are checked against the primary record before the data is read, so the
+// payloads of discarded events are never touched, and then the data is
+// checked against the data filters. Corrupt records are recorded and skipped as by
+// readEvent.
+func (db *DB) readMatching(ctx context.Context, txn *badger.Txn, item *badger.Item, q Query, filter bool, event *Event) (bool, error) {
+	if !filter {
+		return db.readEvent(ctx, txn, item, q, event)
+	}
+
+	recordDecoded(ctx)
+	err := db.decodeItem(item, event)
+	if err == nil && !db.matchesFilters(event, q) {
+		return false, nil
+	}
+	if err == nil && q.Latest {
+		var superseded bool
+		if _, superseded, err = supersededBy(txn, event.ID); superseded {
+			return false, nil
+		}
+	}
+	if err == nil && (!q.OmitData || q.filtersData()) {
+		err = db.loadData(txn, event)
+	}
+	if err == nil && q.filtersData() {
+		if !matchesData(event, q) {
+			return false, nil
+		}
+		if q.OmitData {
+			event.Data = nil
+		}
+	}
+	if errors.Is(err, ErrCorruptRecord) {
+		db.recordCorrupt(ctx)
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// matchesTimeRange checks if an event ID falls within the query time and ID range.
 func (db *DB) matchesTimeRange(id ulid.ULID, q Query) bool {
 	t := ulidTime(id)
 
@@ -266,10 +866,27 @@ func (db *DB) matchesTimeRange(id ulid.ULID, q Query) bool {
 	if q.End != nil && t.After(*q.End) {
 		return false
 	}
+	if q.MinID != nil && id.Compare(*q.MinID) < 0 {
+		return false
+	}
+	if q.MaxID != nil && id.Compare(*q.MaxID) > 0 {
+		return false
+	}
 
 	return true
 }
 
+// pastRange reports whether a scan in the query's order has passed its time
+// and ID range, so no later key can match.
+func pastRange(id ulid.ULID, q Query) bool {
+	if q.Descending {
+		return (q.Start != nil && ulidTime(id).Before(*q.Start)) ||
+			(q.MinID != nil && id.Compare(*q.MinID) < 0)
+	}
+	return (q.End != nil && ulidTime(id).After(*q.End)) ||
+		(q.MaxID != nil && id.Compare(*q.MaxID) > 0)
+}
+
 // matchesFilters checks if an event matches all query filters.
 func (db *DB) matchesFilters(event *Event, q Query) bool {
 	// Check type filter
@@ -286,9 +903,33 @@ func (db *DB) matchesFilters(event *Event, q Query) bool {
 		}
 	}
 
+	if !matchesTypePattern(q, event.Type) {
+		return false
+	}
+
 	// Check tag filters (all must match)
 	for k, v := range q.Tags {
-		if event.Tags[k] != v {
+		if !matchesTag(event.Tags, k, v) {
+			return false
+		}
+	}
+
+	// Check exclusions (any excludes the event)
+	if slices.Contains(q.ExcludeTypes, event.Type) {
+		return false
+	}
+	for k, v := range q.ExcludeTags {
+		if _, ok := event.Tags[k]; ok && matchesTag(event.Tags, k, v) {
+			return false
+		}
+	}
+
+	if q.hasIngestRange() {
+		t := event.ingestedAt()
+		if q.IngestedStart != nil && t.Before(*q.IngestedStart) {
+			return false
+		}
+		if q.IngestedEnd != nil && t.After(*q.IngestedEnd) {
 			return false
 		}
 	}
@@ -344,3 +985,127 @@ func (db *DB) Count() (int64, error) {
 
 	return count, nil
 }
+
+// QueryCount returns the number of events matching the query, ignoring its
+// Limit and Offset, so that dashboards can show counts without reading
+// events. When the chosen index or the time range covers every filter, only
+// keys are read; otherwise the filters are checked as in Query, without
+// reading the data of events unless the query filters on it. With Partial
+// set, it returns the events counted before the context's deadline.
+func (db *DB) QueryCount(ctx context.Context, q Query) (int64, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	defer recordDuration(ctx, time.Now())
+
+	q.Limit, q.Offset = 0, 0
+	q.Descending = false
+	q.OmitData = true
+
+	var count int64
+	err := db.badger.View(func(txn *badger.Txn) error {
+		candidateIDs, useIndex, scanErr := db.planQuery(ctx, txn, q)
+		readCtx, ok := partialRead(ctx, q, useIndex, scanErr)
+		if !ok {
+			return scanErr
+		}
+
+		var err error
+		switch {
+		case !needsFilter(q, useIndex) && useIndex:
+			count, err = db.countIDs(readCtx, txn, candidateIDs)
+		case !needsFilter(q, useIndex):
+			count, err = db.countEventKeys(ctx, txn, q)
+		default:
+			agg := newAggregator("", []AggregationType{Count})
+			if useIndex {
+				err = db.aggregateByIDs(readCtx, txn, candidateIDs, q, agg)
+			} else {
+				err = db.aggregateFullScan(ctx, txn, q, agg)
+			}
+			count = agg.count
+		}
+		if err == nil {
+			err = scanErr
+		}
+		return err
+	})
+
+	if isPartial(ctx, q, err) {
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// countIDs counts the candidate IDs of an index scan whose events exist,
+// without reading them.
+func (db *DB) countIDs(ctx context.Context, txn *badger.Txn, ids []ulid.ULID) (int64, error) {
+	var count int64
+	for i, id := range ids {
+		if i%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+		}
+
+		key := encodeEventKey(id)
+		_, err := txn.Get(key)
+		recordScanned(ctx, 1)
+		if err == badger.ErrKeyNotFound {
+			db.recordDangling(ctx)
+			continue
+		}
+		if err != nil {
+			return count, &QueryError{Stage: StageFetch, Key: key, Err: err}
+		}
+		count++
+	}
+	return count, nil
+}
+
+// countEventKeys counts the event keys in the query's time range without
+// reading their values.
+func (db *DB) countEventKeys(ctx context.Context, txn *badger.Txn, q Query) (int64, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var count int64
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+	prefix := eventKeyPrefix()
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		if scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return count, err
+			}
+		}
+		scanned++
+
+		id, err := decodeEventKey(it.Item().Key())
+		if err != nil {
+			db.recordCorrupt(ctx)
+			continue
+		}
+		if !db.matchesTimeRange(id, q) {
+			if pastRange(id, q) {
+				break
+			}
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
@@ -2,9 +2,17 @@ package squid
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"slices"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
 )
 
 func TestQueryAll(t *testing.T) {
@@ -80,6 +88,120 @@ func TestQueryByType(t *testing.T) {
 	}
 }
 
+func TestQueryByTypes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	types := []string{"error", "request", "panic", "request", "error", "metric", "panic"}
+	for i, typ := range types {
+		if _, err := db.Append(Event{Timestamp: base.Add(time.Duration(i) * time.Second), Type: typ}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	var stats ExecStats
+	ctx := WithExecStats(context.Background(), &stats)
+	for _, q := range []Query{
+		{Types: []string{"error", "panic"}},
+		{Types: []string{"panic", "error", "panic"}, Descending: true},
+		{Types: []string{"error", "panic"}, Limit: 2, Offset: 1},
+	} {
+		stats = ExecStats{}
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+
+		var want []int
+		for i, typ := range types {
+			if typ == "error" || typ == "panic" {
+				want = append(want, i)
+			}
+		}
+		if q.Descending {
+			slices.Reverse(want)
+		}
+		if q.Limit > 0 {
+			want = want[q.Offset : q.Offset+q.Limit]
+		}
+
+		if len(events) != len(want) {
+			t.Fatalf("%+v: expected %d events, got %d", q, len(want), len(events))
+		}
+		for j, i := range want {
+			if !events[j].Timestamp.Equal(base.Add(time.Duration(i) * time.Second)) {
+				t.Errorf("%+v: expected event %d at position %d, got %s", q, i, j, events[j].Timestamp)
+			}
+		}
+		if stats.Plan != "type index" || stats.EventsDecoded != int64(len(want)) {
+			t.Errorf("%+v: unexpected stats %+v", q, stats)
+		}
+	}
+}
+
+func TestQueryByTypesLimit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	types := []string{"a", "b", "c", "d", "e"}
+	events := make([]Event, 1000)
+	for i := range events {
+		events[i] = Event{Timestamp: base.Add(time.Duration(i) * time.Second), Type: types[i%len(types)]}
+	}
+	results, err := db.AppendBatch(events)
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	// The latest 100 across the types read about 100 index entries in all
+	var stats ExecStats
+	got, err := db.Query(WithExecStats(context.Background(), &stats), Query{Types: types, Descending: true, Limit: 100})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 100 {
+		t.Fatalf("expected 100 events, got %d", len(got))
+	}
+	for i, e := range got {
+		if want := results[len(results)-1-i].ID; e.ID != want {
+			t.Fatalf("event %d: expected %s, got %s", i, want, e.ID)
+		}
+	}
+	if limit := int64(100 + len(types) + 100); stats.KeysScanned > limit {
+		t.Errorf("expected at most %d keys scanned, got %d", limit, stats.KeysScanned)
+	}
+
+	// Time ranges end the merge of every index
+	start, end := base.Add(100*time.Second), base.Add(199*time.Second)
+	got, err = db.Query(context.Background(), Query{Types: types[:2], Start: &start, End: &end})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 40 || !got[0].Timestamp.Equal(start) || got[39].Timestamp.Before(base.Add(195*time.Second)) {
+		t.Errorf("expected the 40 events of the range in order, got %d", len(got))
+	}
+}
+
 func TestQueryByTags(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
@@ -120,7 +242,7 @@ func TestQueryByTags(t *testing.T) {
 	}
 }
 
-func TestQueryByTimeRange(t *testing.T) {
+func TestQueryTagIntersection(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
 		t.Fatal(err)
@@ -133,50 +255,180 @@ func TestQueryByTimeRange(t *testing.T) {
 	}
 	defer db.Close()
 
-	// Insert events with specific timestamps
-	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
-	t2 := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
-	t3 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := make([]Event, 200)
+	for i := range events {
+		tags := map[string]string{"region": []string{"eu", "us"}[i%2], "host": fmt.Sprintf("h%d", i%10)}
+		if i%100 == 37 {
+			tags["user"] = "u1"
+		}
+		events[i] = Event{Timestamp: base.Add(time.Duration(i) * time.Second), Type: "request", Tags: tags}
+	}
+	if _, err := db.AppendBatch(events); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
 
-	_, _ = db.Append(Event{Timestamp: t1, Type: "event"})
-	_, _ = db.Append(Event{Timestamp: t2, Type: "event"})
-	_, _ = db.Append(Event{Timestamp: t3, Type: "event"})
+	start := base.Add(50 * time.Second)
+	var stats ExecStats
+	ctx := WithExecStats(context.Background(), &stats)
+	for _, tc := range []struct {
+		q    Query
+		want []int
+	}{
+		{Query{Tags: map[string]string{"region": "eu", "host": "h4"}, Limit: 3}, []int{4, 14, 24}},
+		{Query{Tags: map[string]string{"region": "eu", "host": "h4"}, Limit: 2, Offset: 1, Descending: true}, []int{184, 174}},
+		{Query{Tags: map[string]string{"region": "us", "host": "h7", "user": "u1"}}, []int{37, 137}},
+		{Query{Tags: map[string]string{"region": "us", "user": "u1"}, Start: &start}, []int{137}},
+		{Query{Tags: map[string]string{"region": "us", "host": "h4"}}, nil},
+	} {
+		stats = ExecStats{}
+		found, err := db.Query(ctx, tc.q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(found) != len(tc.want) {
+			t.Fatalf("%+v: expected %d events, got %d", tc.q, len(tc.want), len(found))
+		}
+		for j, i := range tc.want {
+			if !found[j].Timestamp.Equal(base.Add(time.Duration(i) * time.Second)) {
+				t.Errorf("%+v: expected event %d at position %d, got %s", tc.q, i, j, found[j].Timestamp)
+			}
+		}
+		// Only the events with every tag are read
+		if stats.Plan != "tag index intersection" || stats.EventsDecoded != int64(len(tc.want)+tc.q.Offset) {
+			t.Errorf("%+v: unexpected stats %+v", tc.q, stats)
+		}
+	}
+
+	if _, err := db.Query(ctx, Query{Tags: map[string]string{"region": "eu"}, Hint: ForceIndex("tags")}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestQueryExclusions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for _, e := range []Event{
+		{Type: "request", Tags: map[string]string{"host": "web-1", "path": "/"}},
+		{Type: "request", Tags: map[string]string{"host": "web-1", "path": "/health"}},
+		{Type: "request", Tags: map[string]string{"host": "web-2", "path": "/"}},
+		{Type: "healthcheck", Tags: map[string]string{"host": "web-1"}},
+		{Type: "error", Tags: map[string]string{"host": "web-2"}},
+	} {
+		if _, err := db.Append(e); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
 
-	// Query with start time
 	ctx := context.Background()
-	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
-	events, err := db.Query(ctx, Query{Start: &start})
+	tests := []struct {
+		name string
+		q    Query
+		want int
+	}{
+		{"types", Query{ExcludeTypes: []string{"healthcheck", "error"}}, 3},
+		{"tags", Query{ExcludeTags: map[string]string{"path": "/health", "host": "web-2"}}, 2},
+		{"type index", Query{Types: []string{"request"}, ExcludeTags: map[string]string{"path": "/health"}}, 2},
+		{"tag index", Query{Tags: map[string]string{"host": "web-1"}, ExcludeTypes: []string{"healthcheck"}}, 2},
+		{"full scan", Query{Tags: map[string]string{"host": "web-1"}, ExcludeTypes: []string{"healthcheck"}, Hint: ForceFullScan}, 2},
+		{"excluded type filter", Query{Types: []string{"error"}, ExcludeTypes: []string{"error"}}, 0},
+	}
+	for _, tt := range tests {
+		events, err := db.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query failed: %v", tt.name, err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("%s: expected %d events, got %d", tt.name, tt.want, len(events))
+		}
+		for _, e := range events {
+			if !db.matchesFilters(e, tt.q) {
+				t.Errorf("%s: returned excluded event %v", tt.name, e)
+			}
+		}
+	}
+
+	result, err := db.Aggregate(ctx, Query{ExcludeTypes: []string{"healthcheck"}}, "", []AggregationType{Count})
 	if err != nil {
-		t.Fatalf("Query failed: %v", err)
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Count != 4 {
+		t.Errorf("expected 4 events aggregated, got %d", result.Count)
 	}
 
-	if len(events) != 2 {
-		t.Errorf("expected 2 events, got %d", len(events))
+	counts, err := db.HourlyCounts(ctx, Query{ExcludeTypes: []string{"request"}})
+	if err != nil {
+		t.Fatalf("HourlyCounts failed: %v", err)
+	}
+	var counted int64
+	for _, c := range counts {
+		if c.Type == "request" {
+			t.Errorf("expected request counts to be excluded, got %+v", c)
+		}
+		counted += c.Count
+	}
+	if counted != 2 {
+		t.Errorf("expected 2 events counted, got %d", counted)
 	}
+}
 
-	// Query with end time
-	end := time.Date(2024, 1, 1, 11, 30, 0, 0, time.UTC)
-	events, err = db.Query(ctx, Query{End: &end})
+func TestQueryByTagsWithDelimiters(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
-		t.Fatalf("Query failed: %v", err)
+		t.Fatal(err)
 	}
+	defer os.RemoveAll(dir)
 
-	if len(events) != 2 {
-		t.Errorf("expected 2 events, got %d", len(events))
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
 	}
+	defer db.Close()
 
-	// Query with both start and end
-	events, err = db.Query(ctx, Query{Start: &start, End: &end})
+	// With v1 keys, "a:b" and "a" shared an index prefix
+	_, _ = db.Append(Event{Type: "request", Tags: map[string]string{"url": "a:b"}})
+	_, _ = db.Append(Event{Type: "request", Tags: map[string]string{"url": "a"}})
+	_, _ = db.Append(Event{Type: "request", Tags: map[string]string{"url=a": "b"}})
+
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{Tags: map[string]string{"url": "a"}})
 	if err != nil {
 		t.Fatalf("Query failed: %v", err)
 	}
 
 	if len(events) != 1 {
-		t.Errorf("expected 1 event, got %d", len(events))
+		t.Fatalf("expected 1 event, got %d", len(events))
+	}
+	if events[0].Tags["url"] != "a" {
+		t.Errorf("expected url=a, got %q", events[0].Tags["url"])
 	}
 }
 
-func TestQueryLimit(t *testing.T) {
+// cancelAfter is a context that reports cancellation after n checks.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestQueryCancelDuringIndexScan(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
 		t.Fatal(err)
@@ -189,24 +441,52 @@ func TestQueryLimit(t *testing.T) {
 	}
 	defer db.Close()
 
-	// Insert 10 events
-	for i := 0; i < 10; i++ {
-		_, _ = db.Append(Event{Type: "event"})
+	batch := make([]Event, 2*scanCheckInterval)
+	for i := range batch {
+		batch[i] = Event{Type: "request", Tags: map[string]string{"service": "api"}}
+	}
+	if _, err := db.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
 	}
 
-	// Query with limit
-	ctx := context.Background()
-	events, err := db.Query(ctx, Query{Limit: 3})
-	if err != nil {
-		t.Fatalf("Query failed: %v", err)
+	tests := []Query{
+		{Types: []string{"request"}},
+		{Tags: map[string]string{"service": "api"}},
+		{},
+	}
+	for _, q := range tests {
+		// Allow the check before the scan and the first check inside it
+		ctx := &cancelAfter{Context: context.Background(), n: 2}
+		events, err := db.Query(ctx, q)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled for %+v, got %v", q, err)
+		}
+		if events != nil {
+			t.Errorf("expected no events for %+v, got %d", q, len(events))
+		}
 	}
 
-	if len(events) != 3 {
-		t.Errorf("expected 3 events, got %d", len(events))
+	ctx := &cancelAfter{Context: context.Background(), n: 2}
+	if _, err := db.Aggregate(ctx, Query{}, "", []AggregationType{Count}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from Aggregate, got %v", err)
 	}
 }
 
-func TestQueryDescending(t *testing.T) {
+// expireAfter is a context whose deadline expires after n checks.
+type expireAfter struct {
+	context.Context
+	n int
+}
+
+func (c *expireAfter) Err() error {
+	if c.n <= 0 {
+		return context.DeadlineExceeded
+	}
+	c.n--
+	return nil
+}
+
+func TestQueryPartial(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
 		t.Fatal(err)
@@ -219,38 +499,66 @@ func TestQueryDescending(t *testing.T) {
 	}
 	defer db.Close()
 
-	// Insert events with specific timestamps
-	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
-	t2 := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
-	t3 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	batch := make([]Event, 2*scanCheckInterval)
+	for i := range batch {
+		batch[i] = Event{Type: "request", Data: map[string]any{"ms": 1}}
+	}
+	if _, err := db.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
 
-	_, _ = db.Append(Event{Timestamp: t1, Type: "event", Data: map[string]any{"order": 1}})
-	_, _ = db.Append(Event{Timestamp: t2, Type: "event", Data: map[string]any{"order": 2}})
-	_, _ = db.Append(Event{Timestamp: t3, Type: "event", Data: map[string]any{"order": 3}})
+	// Without Partial the deadline is an error
+	if _, err := db.Query(&expireAfter{Context: context.Background(), n: 2}, Query{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
 
-	// Query ascending (default)
-	ctx := context.Background()
-	events, err := db.Query(ctx, Query{})
+	// The full scan expires at its second check
+	var report ScanReport
+	ctx := WithScanReport(&expireAfter{Context: context.Background(), n: 2}, &report)
+	events, err := db.Query(ctx, Query{Partial: true})
 	if err != nil {
 		t.Fatalf("Query failed: %v", err)
 	}
+	if len(events) != scanCheckInterval || !report.Partial {
+		t.Errorf("expected %d partial events, got %d with %+v", scanCheckInterval, len(events), report)
+	}
 
-	if events[0].Data["order"].(float64) != 1 {
-		t.Errorf("expected first event order=1, got %v", events[0].Data["order"])
+	// The fetch of the type index's candidates expires after 10 events
+	ctx = &expireAfter{Context: context.Background(), n: 13}
+	if events, err := db.Query(ctx, Query{Types: []string{"request"}, Partial: true}); err != nil || len(events) != 10 {
+		t.Errorf("expected 10 partial events, got %d, %v", len(events), err)
 	}
 
-	// Query descending
-	events, err = db.Query(ctx, Query{Descending: true})
+	// The type index scan expires at its second check, and the candidates
+	// it found are still read
+	report = ScanReport{}
+	ctx = WithScanReport(&expireAfter{Context: context.Background(), n: 2}, &report)
+	events, err = db.Query(ctx, Query{Types: []string{"request"}, Partial: true})
+	if err != nil || len(events) != scanCheckInterval || !report.Partial {
+		t.Errorf("expected %d partial events from the index, got %d, %v with %+v", scanCheckInterval, len(events), err, report)
+	}
+	ctx = &expireAfter{Context: context.Background(), n: 2}
+	if n, err := db.QueryCount(ctx, Query{Types: []string{"request"}, Partial: true}); err != nil || n != scanCheckInterval {
+		t.Errorf("expected a partial count of %d from the index, got %d, %v", scanCheckInterval, n, err)
+	}
+
+	ctx = &expireAfter{Context: context.Background(), n: 2}
+	result, err := db.Aggregate(ctx, Query{Partial: true}, "ms", []AggregationType{Count, Sum})
 	if err != nil {
-		t.Fatalf("Query failed: %v", err)
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if !result.Partial || result.Count != scanCheckInterval || result.Sum != scanCheckInterval {
+		t.Errorf("expected a partial count of %d, got %+v", scanCheckInterval, result)
 	}
 
-	if events[0].Data["order"].(float64) != 3 {
-		t.Errorf("expected first event order=3, got %v", events[0].Data["order"])
+	// Cancellation is still an error
+	ctx = &cancelAfter{Context: context.Background(), n: 2}
+	if _, err := db.Query(ctx, Query{Partial: true}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
 	}
 }
 
-func TestCount(t *testing.T) {
+func TestQueryCount(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
 		t.Fatal(err)
@@ -263,25 +571,917 @@ func TestCount(t *testing.T) {
 	}
 	defer db.Close()
 
-	// Empty database
-	count, err := db.Count()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := make([]Event, 30)
+	for i := range events {
+		events[i] = Event{
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+			Type:      []string{"request", "error", "login"}[i%3],
+			Tags:      map[string]string{"host": []string{"a", "b"}[i%2]},
+			Data:      map[string]any{"ms": i},
+		}
+	}
+	if _, err := db.AppendBatch(events); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	start, end := base.Add(10*time.Minute), base.Add(19*time.Minute)
+	tests := []struct {
+		q       Query
+		keyOnly bool
+	}{
+		{Query{}, true},
+		{Query{Start: &start, End: &end, Limit: 3, Descending: true}, true},
+		{Query{Types: []string{"request"}, Offset: 5}, true},
+		{Query{Types: []string{"request", "error"}, Start: &start}, true},
+		{Query{Tags: map[string]string{"host": "a"}}, true},
+		{Query{Types: []string{"error"}, Tags: map[string]string{"host": "b"}}, false},
+		{Query{Where: []Predicate{{Field: "ms", Op: GTE, Value: 20}}}, false},
+	}
+	for _, tt := range tests {
+		var stats ExecStats
+		n, err := db.QueryCount(WithExecStats(context.Background(), &stats), tt.q)
+		if err != nil {
+			t.Fatalf("QueryCount(%+v) failed: %v", tt.q, err)
+		}
+
+		all := tt.q
+		all.Limit, all.Offset = 0, 0
+		matching, err := db.Query(context.Background(), all)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if n != int64(len(matching)) {
+			t.Errorf("QueryCount(%+v) = %d, expected %d", tt.q, n, len(matching))
+		}
+		if tt.keyOnly && stats.EventsDecoded != 0 {
+			t.Errorf("QueryCount(%+v) decoded %d events, expected none", tt.q, stats.EventsDecoded)
+		}
+	}
+}
+
+func TestFetchEventsByIDs(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
-		t.Fatalf("Count failed: %v", err)
+		t.Fatal(err)
 	}
-	if count != 0 {
-		t.Errorf("expected 0, got %d", count)
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
 	}
+	defer db.Close()
 
-	// Insert events
-	for i := 0; i < 5; i++ {
-		_, _ = db.Append(Event{Type: "event"})
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	events := make([]Event, 1000)
+	for i := range events {
+		events[i] = Event{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			Type:      "request",
+			Tags:      map[string]string{"host": []string{"a", "b"}[i%2]},
+		}
+	}
+	results, err := db.AppendBatch(events)
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	ids := make([]ulid.ULID, len(results))
+	for i, r := range results {
+		ids[i] = r.ID
 	}
 
-	count, err = db.Count()
+	fetch := func(q Query) ([]*Event, ExecStats) {
+		t.Helper()
+		var stats ExecStats
+		var found []*Event
+		err := db.badger.View(func(txn *badger.Txn) error {
+			var err error
+			found, err = db.fetchEventsByIDs(WithExecStats(context.Background(), &stats), txn, ids, q, &eventAlloc{})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("fetchEventsByIDs failed: %v", err)
+		}
+		return found, stats
+	}
+
+	// Without filters, only the records the limit needs are read
+	found, stats := fetch(Query{Limit: 5, Offset: 10})
+	if len(found) != 5 || found[0].ID != ids[10] || stats.KeysScanned != 15 || stats.EventsDecoded != 5 {
+		t.Errorf("expected events 10 to 14 from 15 keys, got %d events with %+v", len(found), stats)
+	}
+
+	// Candidates outside the time range are never read
+	start, end := base.Add(100*time.Second), base.Add(199*time.Second)
+	found, stats = fetch(Query{Start: &start, End: &end})
+	if len(found) != 100 || found[0].ID != ids[100] || stats.KeysScanned != 100 {
+		t.Errorf("expected the 100 events of the range from 100 keys, got %d events with %+v", len(found), stats)
+	}
+
+	// Filtered candidates are fetched concurrently but returned in order
+	found, _ = fetch(Query{Types: []string{"request"}, Tags: map[string]string{"host": "b"}})
+	if len(found) != 500 {
+		t.Fatalf("expected 500 events, got %d", len(found))
+	}
+	for i, e := range found {
+		if e.ID != ids[2*i+1] {
+			t.Fatalf("event %d: expected %s, got %s", i, ids[2*i+1], e.ID)
+		}
+	}
+}
+
+func TestScanIteratorOptions(t *testing.T) {
+	tests := []struct {
+		q      Query
+		filter bool
+		values bool
+		size   int
+	}{
+		{Query{}, false, false, 100},
+		{Query{Prefetch: &PrefetchOptions{Values: true}}, false, true, 100},
+		{Query{Limit: 10, Offset: 5, Prefetch: &PrefetchOptions{Values: true}}, false, true, 15},
+		{Query{Limit: 10, Prefetch: &PrefetchOptions{Values: true}}, true, true, 100},
+		{Query{Limit: 10, Prefetch: &PrefetchOptions{Values: true, Size: 500}}, false, true, 500},
+	}
+	for _, tt := range tests {
+		opts := scanIteratorOptions(tt.q, tt.filter)
+		if opts.PrefetchValues != tt.values || opts.PrefetchSize != tt.size {
+			t.Errorf("%+v: expected values %v and size %d, got %v and %d", tt.q, tt.values, tt.size, opts.PrefetchValues, opts.PrefetchSize)
+		}
+	}
+}
+
+func TestQueryByTimeRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
-		t.Fatalf("Count failed: %v", err)
+		t.Fatal(err)
 	}
-	if count != 5 {
-		t.Errorf("expected 5, got %d", count)
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Insert events with specific timestamps
+	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	t3 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	_, _ = db.Append(Event{Timestamp: t1, Type: "event"})
+	_, _ = db.Append(Event{Timestamp: t2, Type: "event"})
+	_, _ = db.Append(Event{Timestamp: t3, Type: "event"})
+
+	// Query with start time
+	ctx := context.Background()
+	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	events, err := db.Query(ctx, Query{Start: &start})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(events))
+	}
+
+	// Query with end time
+	end := time.Date(2024, 1, 1, 11, 30, 0, 0, time.UTC)
+	events, err = db.Query(ctx, Query{End: &end})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if len(events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(events))
+	}
+
+	// Query with both start and end
+	events, err = db.Query(ctx, Query{Start: &start, End: &end})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if len(events) != 1 {
+		t.Errorf("expected 1 event, got %d", len(events))
+	}
+}
+
+func TestQueryLimit(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Insert 10 events
+	for i := 0; i < 10; i++ {
+		_, _ = db.Append(Event{Type: "event"})
+	}
+
+	// Query with limit
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{Limit: 3})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Errorf("expected 3 events, got %d", len(events))
+	}
+}
+
+func TestQueryOffset(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Now().Add(-time.Hour)
+	var ids []ulid.ULID
+	for i := 0; i < 10; i++ {
+		typ := "even"
+		if i%2 == 1 {
+			typ = "odd"
+		}
+		result, err := db.Append(Event{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			Type:      typ,
+			Tags:      map[string]string{"host": "a"},
+			Data:      map[string]any{"i": float64(i)},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		ids = append(ids, result.ID)
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		q    Query
+		want []int
+	}{
+		{"full scan", Query{Limit: 3, Offset: 2}, []int{2, 3, 4}},
+		{"full scan descending", Query{Limit: 3, Offset: 2, Descending: true}, []int{7, 6, 5}},
+		{"full scan without limit", Query{Offset: 8}, []int{8, 9}},
+		{"full scan filtered", Query{Tags: map[string]string{"host": "a"}, Types: []string{"odd", "even"}, Limit: 2, Offset: 3, Hint: ForceFullScan}, []int{3, 4}},
+		{"type index", Query{Types: []string{"odd"}, Limit: 2, Offset: 1}, []int{3, 5}},
+		{"tag index filtered", Query{Types: []string{"odd"}, Tags: map[string]string{"host": "a"}, Limit: 2, Offset: 3, Hint: ForceIndex("tag:host")}, []int{7, 9}},
+		{"ingest order", Query{OrderByIngest: true, Types: []string{"even"}, Limit: 2, Offset: 1}, []int{2, 4}},
+		{"past the end", Query{Limit: 3, Offset: 20}, nil},
+	}
+	for _, tt := range tests {
+		events, err := db.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("%s: Query failed: %v", tt.name, err)
+		}
+		if len(events) != len(tt.want) {
+			t.Fatalf("%s: expected %d events, got %d", tt.name, len(tt.want), len(events))
+		}
+		for i, e := range events {
+			if e.ID != ids[tt.want[i]] {
+				t.Errorf("%s: expected event %d at %d, got %v", tt.name, tt.want[i], i, e.Data["i"])
+			}
+		}
+	}
+
+	var raw int
+	err = db.QueryRaw(ctx, Query{Limit: 3, Offset: 8}, func(id ulid.ULID, value []byte) error {
+		raw++
+		return nil
+	})
+	if err != nil || raw != 2 {
+		t.Errorf("expected 2 raw events, got %d (%v)", raw, err)
+	}
+
+	if _, err := db.Query(ctx, Query{Offset: -1}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for a negative offset, got %v", err)
+	}
+}
+
+func TestQueryDescending(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Insert events with specific timestamps
+	t1 := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)
+	t3 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	_, _ = db.Append(Event{Timestamp: t1, Type: "event", Data: map[string]any{"order": 1}})
+	_, _ = db.Append(Event{Timestamp: t2, Type: "event", Data: map[string]any{"order": 2}})
+	_, _ = db.Append(Event{Timestamp: t3, Type: "event", Data: map[string]any{"order": 3}})
+
+	// Query ascending (default)
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if events[0].Data["order"].(float64) != 1 {
+		t.Errorf("expected first event order=1, got %v", events[0].Data["order"])
+	}
+
+	// Query descending
+	events, err = db.Query(ctx, Query{Descending: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+
+	if events[0].Data["order"].(float64) != 3 {
+		t.Errorf("expected first event order=3, got %v", events[0].Data["order"])
+	}
+}
+
+func TestCount(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Empty database
+	count, err := db.Count()
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("expected 0, got %d", count)
+	}
+
+	// Insert events
+	for i := 0; i < 5; i++ {
+		_, _ = db.Append(Event{Type: "event"})
+	}
+
+	count, err = db.Count()
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != 5 {
+		t.Errorf("expected 5, got %d", count)
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	_, _ = db.Append(Event{Type: "request"})
+	corrupt, _ := db.Append(Event{Type: "request"})
+
+	err = db.badger.Update(func(txn *badger.Txn) error {
+		return txn.Set(encodeEventKey(corrupt.ID), []byte("{not json"))
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	_, err = db.Get(corrupt.ID)
+	if !errors.Is(err, ErrCorruptRecord) {
+		t.Fatalf("expected ErrCorruptRecord, got %v", err)
+	}
+	var qerr *QueryError
+	if !errors.As(err, &qerr) {
+		t.Fatalf("expected *QueryError, got %T", err)
+	}
+	if qerr.Stage != StageDecode || string(qerr.Key) != string(encodeEventKey(corrupt.ID)) {
+		t.Errorf("unexpected QueryError: %v", qerr)
+	}
+
+	// Scans skip the record and count it
+	ctx := context.Background()
+	for _, q := range []Query{{}, {Types: []string{"request"}}} {
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 1 {
+			t.Errorf("expected 1 event, got %d", len(events))
+		}
+	}
+
+	if n := db.Stats().DecodeErrors; n != 2 {
+		t.Errorf("expected 2 decode errors, got %d", n)
+	}
+}
+
+func TestScanReport(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	_, _ = db.Append(Event{Type: "request", Data: map[string]any{"latency": 1}})
+	corrupt, _ := db.Append(Event{Type: "request"})
+	dangling, _ := db.Append(Event{Type: "request"})
+
+	err = db.badger.Update(func(txn *badger.Txn) error {
+		if err := txn.Set(encodeEventKey(corrupt.ID), []byte("{not json")); err != nil {
+			return err
+		}
+		// Leave the type index entry behind
+		return txn.Delete(encodeEventKey(dangling.ID))
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	var report ScanReport
+	ctx := WithScanReport(context.Background(), &report)
+	events, err := db.Query(ctx, Query{Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("expected 1 event, got %d", len(events))
+	}
+	if report.DecodeErrors != 1 || report.DanglingIndexEntries != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	result, err := db.Aggregate(context.Background(), Query{Types: []string{"request"}}, "latency", []AggregationType{Count})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Count != 1 || result.Skipped != 2 {
+		t.Errorf("expected count 1 and 2 skipped, got %d and %d", result.Count, result.Skipped)
+	}
+
+	// The report accumulates across calls
+	if err := db.Export(ctx, io.Discard, Query{}, JSON); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if report.Skipped() != 3 {
+		t.Errorf("expected 3 skipped, got %d", report.Skipped())
+	}
+
+	stats := db.Stats()
+	if stats.DecodeErrors != 3 || stats.DanglingIndexEntries != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// eventDecodeCodec is a JSONCodec that counts decodes of event data.
+type eventDecodeCodec struct {
+	stdJSON
+	events atomic.Int64
+}
+
+func (c *eventDecodeCodec) Unmarshal(data []byte, v any) error {
+	if _, ok := v.(*map[string]any); ok {
+		c.events.Add(1)
+	}
+	return c.stdJSON.Unmarshal(data, v)
+}
+
+func TestQueryPartialDecode(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	codec := &eventDecodeCodec{}
+	db, err := OpenWithOptions(dir, Options{JSON: codec})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		event := Event{Type: "request", Tags: map[string]string{"service": "api"}, Data: map[string]any{"latency": float64(i)}}
+		if i%5 == 0 {
+			event.Type = "error"
+		}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	queries := []Query{
+		{Types: []string{"error"}, Hint: NoIndex},
+		{Types: []string{"error"}, Tags: map[string]string{"service": "api"}, Hint: ForceIndex("tag:service")},
+	}
+	for _, q := range queries {
+		codec.events.Store(0)
+
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 2 {
+			t.Fatalf("expected 2 events, got %d", len(events))
+		}
+		if got := codec.events.Load(); got != 2 {
+			t.Errorf("query %+v: expected only the data of the 2 matching events to be decoded, got %d", q, got)
+		}
+
+		codec.events.Store(0)
+		result, err := db.Aggregate(ctx, q, "latency", []AggregationType{Sum})
+		if err != nil {
+			t.Fatalf("Aggregate failed: %v", err)
+		}
+		if result.Sum != 5 {
+			t.Errorf("expected sum 5, got %v", result.Sum)
+		}
+		if got := codec.events.Load(); got != 2 {
+			t.Errorf("aggregate %+v: expected only the data of the 2 matching events to be decoded, got %d", q, got)
+		}
+	}
+}
+
+func TestQueryOmitData(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	codec := &eventDecodeCodec{}
+	db, err := OpenWithOptions(dir, Options{JSON: codec})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 5; i++ {
+		event := Event{Type: "request", Tags: map[string]string{"service": "api"}, Data: map[string]any{"i": float64(i)}}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{OmitData: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	for _, e := range events {
+		if e.Type != "request" || e.Tags["service"] != "api" || e.Data != nil {
+			t.Errorf("unexpected event: %+v", e)
+		}
+	}
+	if got := codec.events.Load(); got != 0 {
+		t.Errorf("expected no data to be decoded, got %d", got)
+	}
+
+	// Counts don't need data either
+	result, err := db.Aggregate(ctx, Query{}, "", []AggregationType{Count})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Count != 5 || codec.events.Load() != 0 {
+		t.Errorf("expected count 5 without decoding data, got %d after %d decodes", result.Count, codec.events.Load())
+	}
+
+	// Raw values include data unless it is omitted
+	for _, omit := range []bool{false, true} {
+		err := db.QueryRaw(ctx, Query{Limit: 1, OmitData: omit}, func(_ ulid.ULID, value []byte) error {
+			e, err := ParseEvent(value)
+			if err != nil {
+				return err
+			}
+			if (e.Data != nil) == omit {
+				t.Errorf("OmitData %v: unexpected data %v", omit, e.Data)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("QueryRaw failed: %v", err)
+		}
+	}
+}
+
+func TestExecStats(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 10; i++ {
+		typ := "request"
+		if i%2 == 0 {
+			typ = "error"
+		}
+		if _, err := db.Append(Event{Type: typ, Data: map[string]any{"i": float64(i)}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	var stats ExecStats
+	ctx := WithExecStats(context.Background(), &stats)
+	events, err := db.Query(ctx, Query{Types: []string{"error"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(events))
+	}
+	if stats.Plan != "type index" {
+		t.Errorf("expected type index plan, got %q", stats.Plan)
+	}
+	if stats.EventsDecoded != 5 {
+		t.Errorf("expected 5 events decoded, got %d", stats.EventsDecoded)
+	}
+	if stats.KeysScanned < 5 {
+		t.Errorf("expected at least 5 keys scanned, got %d", stats.KeysScanned)
+	}
+	if stats.Duration <= 0 {
+		t.Error("expected a duration to be recorded")
+	}
+
+	stats = ExecStats{}
+	if _, err := db.Query(ctx, Query{}); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if stats.Plan != "full scan" || stats.KeysScanned != 10 || stats.EventsDecoded != 10 {
+		t.Errorf("unexpected full scan stats: %+v", stats)
+	}
+
+	// Stats accumulate over calls made with the same context
+	before := stats.KeysScanned
+	if _, err := db.Aggregate(ctx, Query{}, "i", []AggregationType{Sum}); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if stats.KeysScanned != before+10 {
+		t.Errorf("expected aggregation to scan 10 more keys, got %d", stats.KeysScanned-before)
+	}
+}
+
+func TestQueryIDRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// All events share a millisecond, so only their IDs order them
+	ts := time.Now().Truncate(time.Millisecond)
+	var ids []ulid.ULID
+	for i := 0; i < 10; i++ {
+		result, err := db.Append(Event{Type: "request", Timestamp: ts, Data: map[string]any{"i": float64(i)}})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		ids = append(ids, result.ID)
+	}
+
+	ctx := context.Background()
+	for _, q := range []Query{
+		Query{}.IDRange(ids[3], ids[6]),
+		Query{Types: []string{"request"}}.IDRange(ids[3], ids[6]),
+		Query{Hint: ForceFullScan, Start: &ts, End: &ts}.IDRange(ids[3], ids[6]),
+	} {
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 4 || events[0].ID != ids[3] || events[3].ID != ids[6] {
+			t.Errorf("expected events 3 to 6 for %+v, got %d events", q, len(events))
+		}
+
+		q.Descending = true
+		events, err = db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 4 || events[0].ID != ids[6] || events[3].ID != ids[3] {
+			t.Errorf("expected events 6 to 3 for %+v, got %d events", q, len(events))
+		}
+	}
+
+	// A cursor continues after the last ID seen
+	q := Query{MinID: &ids[8]}
+	events, err := db.Query(ctx, q)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != ids[8] {
+		t.Errorf("expected the last 2 events, got %d", len(events))
+	}
+
+	result, err := db.Aggregate(ctx, Query{}.IDRange(ids[0], ids[4]), "i", []AggregationType{Sum})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Sum != 10 {
+		t.Errorf("expected sum 10, got %v", result.Sum)
+	}
+}
+
+func TestQueryIngestedRange(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		dir, err := os.MkdirTemp("", "squid-test-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		db, err := OpenWithOptions(dir, Options{
+			Now:             func() time.Time { return now },
+			IndexIngestTime: indexed,
+		})
+		if err != nil {
+			t.Fatalf("OpenWithOptions failed: %v", err)
+		}
+		defer db.Close()
+
+		// Two live events, then a backfill of last week's events five minutes later
+		for i := 0; i < 2; i++ {
+			if _, err := db.Append(Event{Type: "request"}); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+		now = now.Add(5 * time.Minute)
+		var backfill []Event
+		for i := 0; i < 3; i++ {
+			backfill = append(backfill, Event{Type: "request", Timestamp: now.Add(-7 * 24 * time.Hour).Add(time.Duration(i) * time.Second)})
+		}
+		results, err := db.AppendBatch(backfill)
+		if err != nil {
+			t.Fatalf("AppendBatch failed: %v", err)
+		}
+		if !results[0].IngestedAt.Equal(now) {
+			t.Errorf("expected IngestedAt %v, got %v", now, results[0].IngestedAt)
+		}
+
+		since := now.Add(-time.Minute)
+		var stats ExecStats
+		ctx := WithExecStats(context.Background(), &stats)
+		events, err := db.Query(ctx, Query{IngestedStart: &since, Descending: true})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 3 || events[0].ID != results[2].ID {
+			t.Errorf("indexed=%v: expected the 3 backfilled events, newest first, got %d", indexed, len(events))
+		}
+		if want := map[bool]string{false: "full scan", true: "ingest index"}[indexed]; stats.Plan != want {
+			t.Errorf("expected plan %q, got %q", want, stats.Plan)
+		}
+
+		// Event time and ingest time ranges combine
+		start := results[1].Timestamp
+		events, err = db.Query(ctx, Query{Types: []string{"request"}, Start: &start, IngestedStart: &since, Limit: 1})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 1 || events[0].ID != results[1].ID {
+			t.Errorf("indexed=%v: expected the second backfilled event, got %+v", indexed, events)
+		}
+
+		// Updates keep the ingest time
+		updated, err := db.Update(*results[0].Event)
+		if err != nil {
+			t.Fatalf("Update failed: %v", err)
+		}
+		if !updated.IngestedAt.Equal(now) {
+			t.Errorf("expected Update to keep IngestedAt, got %v", updated.IngestedAt)
+		}
+		before := now.Add(-time.Minute)
+		if n, err := db.Query(ctx, Query{IngestedEnd: &before}); err != nil || len(n) != 2 {
+			t.Errorf("indexed=%v: expected the 2 live events, got %d, %v", indexed, len(n), err)
+		}
+
+		// The ingest index can only be forced when it exists and applies
+		_, err = db.Query(ctx, Query{Hint: ForceIndex("ingest"), IngestedEnd: &before})
+		if indexed != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidQuery)) {
+			t.Errorf("indexed=%v: unexpected error forcing the ingest index: %v", indexed, err)
+		}
+	}
+}
+
+func TestQueryOrderByIngest(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		dir, err := os.MkdirTemp("", "squid-test-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+		db, err := OpenWithOptions(dir, Options{
+			Now:             func() time.Time { return now },
+			IndexIngestTime: indexed,
+		})
+		if err != nil {
+			t.Fatalf("OpenWithOptions failed: %v", err)
+		}
+		defer db.Close()
+
+		// Each event is appended a minute after the previous one, but the
+		// second is backfilled from the day before
+		var ids []ulid.ULID
+		for _, ts := range []time.Time{now, now.Add(-24 * time.Hour), now.Add(2 * time.Minute)} {
+			result, err := db.Append(Event{Type: "audit", Timestamp: ts})
+			if err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+			ids = append(ids, result.ID)
+			now = now.Add(time.Minute)
+		}
+
+		ctx := context.Background()
+		start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+		for _, q := range []Query{
+			{OrderByIngest: true},
+			{OrderByIngest: true, Types: []string{"audit"}, IngestedStart: &start},
+		} {
+			events, err := db.Query(ctx, q)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(events) != 3 || events[0].ID != ids[0] || events[1].ID != ids[1] || events[2].ID != ids[2] {
+				t.Errorf("indexed=%v: expected events in ingest order for %+v, got %d events", indexed, q, len(events))
+			}
+
+			q.Descending, q.Limit = true, 2
+			events, err = db.Query(ctx, q)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(events) != 2 || events[0].ID != ids[2] || events[1].ID != ids[1] {
+				t.Errorf("indexed=%v: expected the last 2 events appended, newest first, got %d events", indexed, len(events))
+			}
+		}
+
+		// Raw queries can only order by ingest from the index
+		var raw []ulid.ULID
+		err = db.QueryRaw(ctx, Query{OrderByIngest: true, IngestedStart: &start}, func(id ulid.ULID, _ []byte) error {
+			raw = append(raw, id)
+			return nil
+		})
+		if indexed {
+			if err != nil || len(raw) != 3 || raw[1] != ids[1] {
+				t.Errorf("expected raw events in ingest order, got %v, %v", raw, err)
+			}
+		} else if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected ErrInvalidQuery without the index, got %v", err)
+		}
 	}
 }
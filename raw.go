@@ -0,0 +1,225 @@
+package squid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// errStopScan ends a raw scan early without an error.
+var errStopScan = errors.New("squid: stop scan")
+
+// QueryRaw calls fn with the ID and stored JSON encoding of every event
+// matching the query, in the order Query would return them, without
+// decoding events into Event values. It suits callers that decode events
+// themselves or forward them unchanged. An event's data is stored apart
+// from the rest of it and joined into value unless q.OmitData is set.
+//
+// value is only valid until fn returns and must not be modified; copy it to
+// keep it. An error returned by fn stops the scan and is returned by
+// QueryRaw. Values are only parsed when the query filters on more than the
+// index it is answered from covers, and data only for its data filters.
+func (db *DB) QueryRaw(ctx context.Context, q Query, fn func(id ulid.ULID, value []byte) error) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if q.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+	if q.OrderByIngest && !db.ingestIndexUsed(q) {
+		return fmt.Errorf("%w: QueryRaw can only order by ingest from the ingest time index", ErrInvalidQuery)
+	}
+	defer recordDuration(ctx, time.Now())
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		candidateIDs, useIndex, err := db.planQuery(ctx, txn, q)
+		if err != nil {
+			return err
+		}
+
+		filter := needsFilter(q, useIndex)
+
+		var n, skipped int
+		var buf []byte
+		visit := func(id ulid.ULID, item *badger.Item) error {
+			return item.Value(func(val []byte) error {
+				if filter {
+					var header eventHeader
+					if err := db.codec().Unmarshal(val, &header); err != nil {
+						db.recordCorrupt(ctx)
+						return nil
+					}
+					event := Event{Timestamp: header.Timestamp, Type: header.Type, Tags: header.Tags, IngestedAt: header.IngestedAt}
+					if !db.matchesFilters(&event, q) {
+						return nil
+					}
+					if q.Latest {
+						_, superseded, err := supersededBy(txn, id)
+						if errors.Is(err, ErrCorruptRecord) {
+							db.recordCorrupt(ctx)
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						if superseded {
+							return nil
+						}
+					}
+					if q.filtersData() {
+						event.ID = id
+						err := db.loadData(txn, &event)
+						if errors.Is(err, ErrCorruptRecord) {
+							db.recordCorrupt(ctx)
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						if !matchesData(&event, q) {
+							return nil
+						}
+					}
+				}
+
+				if skipped < q.Offset {
+					skipped++
+					return nil
+				}
+
+				if !q.OmitData {
+					var err error
+					val, err = db.joinData(txn, id, val, &buf)
+					if errors.Is(err, ErrCorruptRecord) {
+						db.recordCorrupt(ctx)
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+				}
+
+				if err := fn(id, val); err != nil {
+					return err
+				}
+				if n++; q.Limit > 0 && n >= q.Limit {
+					return errStopScan
+				}
+				return nil
+			})
+		}
+
+		if useIndex {
+			return db.rawFetch(ctx, txn, candidateIDs, visit)
+		}
+		return db.rawScan(ctx, txn, q, visit)
+	})
+
+	if errors.Is(err, errStopScan) {
+		return nil
+	}
+	return err
+}
+
+// joinData returns the JSON encoding of the event with the given primary
+// record, adding its data from the payload record. Records without data are
+// returned unchanged; others are built in buf.
+func (db *DB) joinData(txn *badger.Txn, id ulid.ULID, meta []byte, buf *[]byte) ([]byte, error) {
+	meta = bytes.TrimRight(meta, " \t\r\n")
+	if len(meta) < 2 || meta[len(meta)-1] != '}' {
+		return nil, &QueryError{Stage: StageDecode, Key: encodeEventKey(id), Err: ErrCorruptRecord}
+	}
+
+	b := append((*buf)[:0], meta[:len(meta)-1]...)
+	b = append(b, `,"data":`...)
+	found, err := db.readData(txn, id, func(data []byte) error {
+		b = append(b, data...)
+		return nil
+	})
+	if err != nil || !found {
+		return meta, err
+	}
+	*buf = append(b, '}')
+	return *buf, nil
+}
+
+// rawFetch visits the events with the given IDs.
+func (db *DB) rawFetch(ctx context.Context, txn *badger.Txn, ids []ulid.ULID, visit func(ulid.ULID, *badger.Item) error) error {
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		key := encodeEventKey(id)
+		item, err := txn.Get(key)
+		recordScanned(ctx, 1)
+		if err == badger.ErrKeyNotFound {
+			db.recordDangling(ctx)
+			continue
+		}
+		if err != nil {
+			return &QueryError{Stage: StageFetch, Key: key, Err: err}
+		}
+
+		if err := visit(id, item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawScan visits the events in the query's time range.
+// The context is checked every scanCheckInterval keys.
+func (db *DB) rawScan(ctx context.Context, txn *badger.Txn, q Query, visit func(ulid.ULID, *badger.Item) error) error {
+	it := txn.NewIterator(scanIteratorOptions(q, needsFilter(q, false)))
+	defer it.Close()
+
+	prefix := eventKeyPrefix()
+	seekKey := prefix
+	if q.Descending {
+		seekKey = prefixEnd(prefix)
+	}
+
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+	for it.Seek(seekKey); it.ValidForPrefix(prefix); it.Next() {
+		if scanned%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		scanned++
+
+		item := it.Item()
+		id, err := decodeEventKey(item.Key())
+		if err != nil {
+			db.recordCorrupt(ctx)
+			continue
+		}
+
+		if !db.matchesTimeRange(id, q) {
+			if pastRange(id, q) {
+				break
+			}
+			continue
+		}
+
+		if err := visit(id, item); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
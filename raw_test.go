@@ -0,0 +1,87 @@
+package squid
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestQueryRaw(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		eventType := []string{"request", "error"}[i%2]
+		service := []string{"api", "web", "db"}[i%3]
+		_, err := db.Append(Event{Type: eventType, Tags: map[string]string{"service": service}, Data: map[string]any{"i": i}})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	queries := []Query{
+		{},
+		{Types: []string{"error"}},
+		{Tags: map[string]string{"service": "api"}},
+		{Types: []string{"request"}, Tags: map[string]string{"service": "web"}},
+		{Types: []string{"request", "error"}, Limit: 3, Descending: true},
+		{Types: []string{"error"}, Hint: NoIndex, Limit: 4},
+	}
+
+	for _, q := range queries {
+		want, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+
+		var ids []ulid.ULID
+		err = db.QueryRaw(ctx, q, func(id ulid.ULID, value []byte) error {
+			var e Event
+			if err := json.Unmarshal(value, &e); err != nil {
+				return err
+			}
+			if e.ID != id {
+				t.Errorf("value of %s holds event %s", id, e.ID)
+			}
+			ids = append(ids, id)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("QueryRaw failed: %v", err)
+		}
+
+		if len(ids) != len(want) {
+			t.Fatalf("query %+v: expected %d events, got %d", q, len(want), len(ids))
+		}
+		for i := range want {
+			if ids[i] != want[i].ID {
+				t.Errorf("query %+v: event %d: expected %s, got %s", q, i, want[i].ID, ids[i])
+			}
+		}
+	}
+
+	// An error from the callback stops the scan
+	errStop := errors.New("stop")
+	var calls int
+	err = db.QueryRaw(ctx, Query{}, func(ulid.ULID, []byte) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("expected errStop after 1 call, got %v after %d", err, calls)
+	}
+}
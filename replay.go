@@ -0,0 +1,58 @@
+package squid
+
+import (
+	"context"
+	"time"
+)
+
+// Replay re-delivers events matching the query to fn in chronological order,
+// preserving the original time between events scaled by speed. A speed of 2
+// replays twice as fast as the events occurred; speed must be positive.
+// Query.Descending and OrderByIngest are ignored.
+//
+// Replay blocks until every event has been delivered or the context is done.
+func (db *DB) Replay(ctx context.Context, q Query, speed float64, fn func(*Event)) error {
+	if speed <= 0 {
+		return ErrInvalidQuery
+	}
+
+	q.Descending = false
+	q.OrderByIngest = false
+	events, err := db.Query(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	for i, event := range events {
+		if i > 0 {
+			gap := event.Timestamp.Sub(events[i-1].Timestamp)
+			if err := sleepContext(ctx, time.Duration(float64(gap)/speed)); err != nil {
+				return err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fn(event)
+	}
+
+	return nil
+}
+
+// sleepContext waits for d or until the context is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Three events spread over 400ms
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 3; i++ {
+		_, err := db.Append(Event{
+			Type:      "tick",
+			Timestamp: base.Add(time.Duration(i) * 200 * time.Millisecond),
+			Data:      map[string]any{"index": i},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	var got []*Event
+	start := time.Now()
+	err = db.Replay(context.Background(), Query{Descending: true}, 4, func(e *Event) {
+		got = append(got, e)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	elapsed := time.Since(start)
+
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(got))
+	}
+	for i, e := range got {
+		if e.Data["index"] != float64(i) {
+			t.Errorf("event %d delivered out of order: %v", i, e.Data["index"])
+		}
+	}
+
+	// 400ms of history at 4x speed takes ~100ms
+	if elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected replay duration: %v", elapsed)
+	}
+}
+
+func TestReplayCancellation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Now().Add(-time.Hour)
+	_, _ = db.Append(Event{Type: "tick", Timestamp: base})
+	_, _ = db.Append(Event{Type: "tick", Timestamp: base.Add(time.Hour)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	delivered := 0
+	err = db.Replay(ctx, Query{}, 1, func(*Event) { delivered++ })
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected DeadlineExceeded, got %v", err)
+	}
+	if delivered != 1 {
+		t.Errorf("expected 1 event before cancellation, got %d", delivered)
+	}
+
+	if err := db.Replay(context.Background(), Query{}, 0, func(*Event) {}); err != ErrInvalidQuery {
+		t.Errorf("expected ErrInvalidQuery for zero speed, got %v", err)
+	}
+}
@@ -2,7 +2,8 @@ package squid
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,96 +16,408 @@ type RetentionPolicy struct {
 	// MaxAge is the maximum age of events. Events older than this will be deleted.
 	MaxAge time.Duration
 
-	// CleanupInterval is how often the cleanup goroutine runs.
+	// CleanupInterval is how often the policy's cleanup runs.
 	// Defaults to MaxAge/10 if not set (minimum 1 minute).
 	CleanupInterval time.Duration
+
+	// Types restricts the policy to events of the given types
+	// (empty means all types).
+	Types []string
 }
 
-// retentionState holds the state for the retention cleanup goroutine.
-type retentionState struct {
-	policy  RetentionPolicy
-	cancel  context.CancelFunc
-	done    chan struct{}
-	mu      sync.Mutex
-	running bool
+// withDefaults returns the policy with its default cleanup interval applied.
+func (p RetentionPolicy) withDefaults() RetentionPolicy {
+	if p.CleanupInterval == 0 {
+		p.CleanupInterval = p.MaxAge / 10
+		if p.CleanupInterval < time.Minute {
+			p.CleanupInterval = time.Minute
+		}
+	}
+	return p
 }
 
-// isRunning safely checks if the retention goroutine is running.
-func (s *retentionState) isRunning() bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return s.running
+// defaultPolicyName is the name of the policy managed by SetRetention.
+const defaultPolicyName = ""
+
+// scheduledPolicy is a retention policy and the time its cleanup is next due.
+type scheduledPolicy struct {
+	policy RetentionPolicy
+	next   time.Time
 }
 
-// SetRetention configures the retention policy and starts background cleanup.
-// Calling this multiple times will update the policy and restart the cleanup goroutine.
-// Pass a zero MaxAge to disable retention (stop cleanup).
-func (db *DB) SetRetention(policy RetentionPolicy) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// retentionManager runs cleanup for all configured retention policies from a
+// single goroutine. Policies can be added, replaced and removed, and cleanup
+// paused and resumed, while the goroutine runs: changes wake it up rather
+// than restarting it.
+type retentionManager struct {
+	mu       sync.Mutex
+	policies map[string]*scheduledPolicy
+	paused   bool
+	holds    map[*RetentionHold]struct{}
+
+	// cleanupMu serialises scheduled cleanups with RunCleanupNow.
+	cleanupMu sync.Mutex
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
 
-	// Stop existing retention goroutine if running
-	if db.retention != nil && db.retention.isRunning() {
-		db.retention.cancel()
-		<-db.retention.done
+// newRetentionManager returns an idle retention manager.
+func newRetentionManager() *retentionManager {
+	return &retentionManager{
+		policies: make(map[string]*scheduledPolicy),
+		holds:    make(map[*RetentionHold]struct{}),
+		wake:     make(chan struct{}, 1),
 	}
+}
 
-	// Disable retention if MaxAge is zero
-	if policy.MaxAge == 0 {
-		db.retention = nil
-		return
+// start runs the cleanup loop for db in a background goroutine.
+func (m *retentionManager) start(db *DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go m.run(ctx, db)
+}
+
+// stop ends the cleanup loop and waits for it to exit.
+func (m *retentionManager) stop() {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
 	}
+}
+
+// notify wakes the cleanup loop so it can reschedule.
+func (m *retentionManager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run waits for the next due policy and runs its cleanup.
+func (m *retentionManager) run(ctx context.Context, db *DB) {
+	defer close(m.done)
 
-	// Set default cleanup interval
-	if policy.CleanupInterval == 0 {
-		policy.CleanupInterval = policy.MaxAge / 10
-		if policy.CleanupInterval < time.Minute {
-			policy.CleanupInterval = time.Minute
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		var timerC <-chan time.Time
+		if wait, ok := m.nextWait(time.Now()); ok {
+			timer.Reset(wait)
+			timerC = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case <-timerC:
+			m.runDue(ctx, db)
 		}
 	}
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	state := &retentionState{
-		policy:  policy,
-		cancel:  cancel,
-		done:    make(chan struct{}),
-		running: true,
+// nextWait returns how long until the earliest policy is due.
+// It returns false if cleanup is paused or no policies are configured.
+func (m *retentionManager) nextWait(now time.Time) (time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.paused || len(m.policies) == 0 {
+		return 0, false
+	}
+
+	var next time.Time
+	for _, sp := range m.policies {
+		if next.IsZero() || sp.next.Before(next) {
+			next = sp.next
+		}
 	}
-	db.retention = state
 
-	go db.runRetentionCleanup(ctx, state)
+	return max(next.Sub(now), 0), true
 }
 
-// runRetentionCleanup periodically deletes expired events.
-func (db *DB) runRetentionCleanup(ctx context.Context, state *retentionState) {
-	defer close(state.done)
-	defer func() {
-		state.mu.Lock()
-		state.running = false
-		state.mu.Unlock()
-	}()
+// runDue runs cleanup for every policy that is due and reschedules it.
+func (m *retentionManager) runDue(ctx context.Context, db *DB) {
+	now := time.Now()
 
-	ticker := time.NewTicker(state.policy.CleanupInterval)
-	defer ticker.Stop()
+	m.mu.Lock()
+	due := make(map[string]RetentionPolicy)
+	for name, sp := range m.policies {
+		if !sp.next.After(now) {
+			due[name] = sp.policy
+			sp.next = now.Add(sp.policy.CleanupInterval)
+		}
+	}
+	paused := m.paused
+	m.mu.Unlock()
 
-	// Run cleanup immediately on start
-	cutoff := time.Now().Add(-state.policy.MaxAge)
-	db.deleteBefore(cutoff)
+	if paused {
+		return
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+
+	for name, policy := range due {
+		if ctx.Err() != nil {
 			return
-		case <-ticker.C:
-			cutoff := time.Now().Add(-state.policy.MaxAge)
-			db.deleteBefore(cutoff)
 		}
+		// Cleanup is retried when the policy is next due
+		_, err := db.applyRetention(ctx, policy)
+		if err != nil && ctx.Err() == nil && db.opts.Logger != nil {
+			db.opts.Logger.Errorf("squid: retention policy %q failed: %v", name, err)
+		}
+	}
+}
+
+// SetRetention configures the default retention policy.
+// Calling this multiple times atomically replaces the policy.
+// Pass a zero MaxAge to disable it.
+func (db *DB) SetRetention(policy RetentionPolicy) {
+	db.SetRetentionPolicy(defaultPolicyName, policy)
+}
+
+// SetRetentionPolicy adds or atomically replaces the named retention policy.
+// Its first cleanup runs immediately. Pass a zero MaxAge to remove it.
+// Policies are independent, so per-type policies can be combined with a
+// global one; each event is deleted by whichever policy expires it first.
+func (db *DB) SetRetentionPolicy(name string, policy RetentionPolicy) {
+	if policy.MaxAge == 0 {
+		db.RemoveRetentionPolicy(name)
+		return
+	}
+
+	policy.Types = slices.Clone(policy.Types)
+
+	m := db.retention
+	m.mu.Lock()
+	m.policies[name] = &scheduledPolicy{
+		policy: policy.withDefaults(),
+		next:   time.Now(),
+	}
+	m.mu.Unlock()
+
+	m.notify()
+}
+
+// RemoveRetentionPolicy removes the named retention policy.
+func (db *DB) RemoveRetentionPolicy(name string) {
+	m := db.retention
+	m.mu.Lock()
+	delete(m.policies, name)
+	m.mu.Unlock()
+
+	m.notify()
+}
+
+// RetentionPolicies returns the configured retention policies by name, with
+// defaults applied. The policy set by SetRetention has the empty name.
+func (db *DB) RetentionPolicies() map[string]RetentionPolicy {
+	m := db.retention
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	policies := make(map[string]RetentionPolicy, len(m.policies))
+	for name, sp := range m.policies {
+		policies[name] = sp.policy
 	}
+	return policies
 }
 
-// DeleteBefore manually deletes all events before the given time.
-// This can be used for manual cleanup or testing.
+// PauseRetention stops scheduled cleanups until ResumeRetention is called.
+// A cleanup that is already running completes. RunCleanupNow still works
+// while paused.
+func (db *DB) PauseRetention() {
+	m := db.retention
+	m.mu.Lock()
+	m.paused = true
+	m.mu.Unlock()
+
+	m.notify()
+}
+
+// ResumeRetention restarts scheduled cleanups. Policies that became due
+// while paused run immediately.
+func (db *DB) ResumeRetention() {
+	m := db.retention
+	m.mu.Lock()
+	m.paused = false
+	m.mu.Unlock()
+
+	m.notify()
+}
+
+// RunCleanupNow runs every retention policy immediately, as well as the
+// retention of hourly counts set by Options.CountRetention, and returns the
+// number of events deleted. It does not change when scheduled cleanups run.
+// The context is checked between policies.
+func (db *DB) RunCleanupNow(ctx context.Context) (int64, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	policies := db.RetentionPolicies()
+
+	m := db.retention
+	m.cleanupMu.Lock()
+	defer m.cleanupMu.Unlock()
+
+	var total int64
+	for _, policy := range policies {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		deleted, err := db.applyRetention(ctx, policy)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+
+	if db.opts.CountRetention > 0 {
+		return total, db.pruneCounts(ctx)
+	}
+	return total, nil
+}
+
+// applyRetention deletes the events expired under a single policy.
+func (db *DB) applyRetention(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	cutoff := db.now().Add(-policy.MaxAge)
+
+	if len(policy.Types) == 0 {
+		return db.deleteBefore(ctx, cutoff)
+	}
+
+	var total int64
+	for _, eventType := range policy.Types {
+		deleted, err := db.deleteTypeBefore(ctx, eventType, cutoff)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// retentionPreview returns the number and approximate stored size of the
+// events that the configured policies would delete if cleanup ran now,
+// excluding held events. Event keys are scanned up to each policy's cutoff
+// without reading values, unless legal holds need the events' types and
+// tags, so the cost grows with the backlog of expired events rather than
+// with the size of the database. Shared payloads, which
+// are only freed with their last reference, are not counted.
+func (db *DB) retentionPreview() (int64, int64, error) {
+	now := db.now()
+	guard := db.newDeletionGuard(true)
+
+	// Each event expires under whichever policy covering it has the latest cutoff
+	var global time.Time
+	typed := make(map[string]time.Time)
+	for _, policy := range db.RetentionPolicies() {
+		cutoff := now.Add(-policy.MaxAge)
+		if len(policy.Types) == 0 {
+			if cutoff.After(global) {
+				global = cutoff
+			}
+			continue
+		}
+		for _, t := range policy.Types {
+			if cutoff.After(typed[t]) {
+				typed[t] = cutoff
+			}
+		}
+	}
+
+	var events, bytes int64
+	err := db.badger.View(func(txn *badger.Txn) error {
+		size := func(id ulid.ULID, item *badger.Item) {
+			if len(guard.legal) > 0 {
+				var event Event
+				err := item.Value(func(val []byte) error {
+					return db.codec().Unmarshal(val, &event)
+				})
+				if err != nil || guard.keeps(id, &event) {
+					return
+				}
+			}
+			events++
+			bytes += item.EstimatedSize()
+			if data, err := txn.Get(encodeDataKey(id)); err == nil {
+				bytes += data.EstimatedSize()
+			}
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		if !global.IsZero() {
+			it := txn.NewIterator(opts)
+			prefix := eventKeyPrefix()
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				id, err := decodeEventKey(it.Item().Key())
+				if err != nil {
+					continue
+				}
+				if !ulidTime(id).Before(global) {
+					break
+				}
+				if !guard.heldAt(ulidTime(id)) {
+					size(id, it.Item())
+				}
+			}
+			it.Close()
+		}
+
+		for eventType, cutoff := range typed {
+			if !cutoff.After(global) {
+				continue
+			}
+
+			it := txn.NewIterator(opts)
+			prefix := encodeTypeIndexPrefix(eventType)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				id, err := decodeIndexKey(it.Item().Key())
+				if err != nil {
+					continue
+				}
+				t := ulidTime(id)
+				if !t.Before(cutoff) {
+					break
+				}
+				// Older events were counted under the global cutoff
+				if t.Before(global) || guard.heldAt(t) {
+					continue
+				}
+				if item, err := txn.Get(encodeEventKey(id)); err == nil {
+					size(id, item)
+				}
+			}
+			it.Close()
+		}
+		return nil
+	})
+	return events, bytes, err
+}
+
+// DeleteBefore manually deletes all events before the given time, except
+// those held by HoldRetention or a LegalHold. This can be used for manual
+// cleanup or testing.
 func (db *DB) DeleteBefore(before time.Time) (int64, error) {
+	return db.DeleteBeforeContext(context.Background(), before)
+}
+
+// DeleteBeforeContext is like DeleteBefore, checking the context between
+// batches of deletions. The context can report progress with WithProgress.
+// Events deleted before it is cancelled stay deleted.
+func (db *DB) DeleteBeforeContext(ctx context.Context, before time.Time) (int64, error) {
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
@@ -112,40 +425,150 @@ func (db *DB) DeleteBefore(before time.Time) (int64, error) {
 	}
 	db.mu.RUnlock()
 
-	return db.deleteBefore(before)
+	return db.deleteBefore(ctx, before)
 }
 
+// deleteBatchSize bounds the number of events read per transaction when
+// deleting them. Fewer are deleted if their entries do not fit in one
+// transaction, see deleteBatch.
+const deleteBatchSize = 10_000
+
 // deleteBefore is the internal implementation that deletes events before a cutoff time.
-func (db *DB) deleteBefore(before time.Time) (int64, error) {
+func (db *DB) deleteBefore(ctx context.Context, before time.Time) (int64, error) {
 	var deleted int64
 
-	err := db.badger.Update(func(txn *badger.Txn) error {
-		toDelete, err := db.findExpiredEvents(txn, before)
+	guard := db.newDeletionGuard(true)
+	defer db.logKept(guard)
+
+	t := db.startDeleteProgress(ctx, Query{}, before)
+	defer t.done()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		var batch *deleteBatch
+		var found int
+
+		err := db.badger.Update(func(txn *badger.Txn) error {
+			toDelete, err := db.findExpiredEvents(txn, before, guard, deleteBatchSize)
+			if err != nil {
+				return err
+			}
+			found = len(toDelete)
+
+			batch = db.newDeleteBatch(txn)
+			for _, entry := range toDelete {
+				if err := batch.add(entry); err != nil {
+					return err
+				}
+			}
+			return batch.commit()
+		})
 		if err != nil {
-			return err
+			return deleted, err
 		}
 
-		for _, entry := range toDelete {
-			if err := db.deleteEventAndIndices(txn, entry); err != nil {
-				continue
+		deleted += batch.deleted
+		t.add(batch.deleted, 0)
+		if batch.deleted > 0 && db.aggCache != nil {
+			db.aggCache.invalidate(time.Time{}, before)
+		}
+		if !batch.full && found < deleteBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// deleteTypeBefore deletes events of a single type before a cutoff time,
+// using the type index to find them.
+func (db *DB) deleteTypeBefore(ctx context.Context, eventType string, before time.Time) (int64, error) {
+	var deleted int64
+
+	guard := db.newDeletionGuard(true)
+	defer db.logKept(guard)
+
+	t := db.startDeleteProgress(ctx, Query{Types: []string{eventType}}, before)
+	defer t.done()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		var batch *deleteBatch
+		var found int
+
+		err := db.badger.Update(func(txn *badger.Txn) error {
+			toDelete, err := db.findExpiredTypeEvents(txn, eventType, before, guard, deleteBatchSize)
+			if err != nil {
+				return err
 			}
-			deleted++
+			found = len(toDelete)
+
+			batch = db.newDeleteBatch(txn)
+			for _, entry := range toDelete {
+				if err := batch.add(entry); err != nil {
+					return err
+				}
+			}
+			return batch.commit()
+		})
+		if err != nil {
+			return deleted, err
 		}
 
+		deleted += batch.deleted
+		t.add(batch.deleted, 0)
+		if batch.deleted > 0 && db.aggCache != nil {
+			db.aggCache.invalidate(time.Time{}, before)
+		}
+		if !batch.full && found < deleteBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// startDeleteProgress returns a tracker for deleting the events of q before
+// a cutoff, with the number of events the hourly counts hold before it as
+// the estimated total.
+func (db *DB) startDeleteProgress(ctx context.Context, q Query, before time.Time) *progressTracker {
+	t := startProgress(ctx, "delete")
+	if t == nil {
 		return nil
+	}
+
+	end := before.Add(-time.Nanosecond)
+	q.End = &end
+	_ = db.badger.View(func(txn *badger.Txn) error {
+		n, err := db.countEvents(ctx, txn, q)
+		if err == nil {
+			t.total(n, 0)
+		}
+		return err
 	})
+	return t
+}
 
-	return deleted, err
+// uncountExpired records an event deleted because it expired in deltas,
+// unless counts are retained independently of events.
+func (db *DB) uncountExpired(deltas countDeltas, entry deleteEntry) {
+	if db.opts.CountRetention == 0 {
+		deltas.add(entry.event.Type, entry.id, -1)
+	}
 }
 
 // deleteEntry holds information needed to delete an event and its indices.
 type deleteEntry struct {
 	id    ulid.ULID
 	event Event
+
+	// searchKeys are the event's search index keys, if they have been read
+	searchKeys [][]byte
 }
 
-// findExpiredEvents scans for events before the cutoff time.
-func (db *DB) findExpiredEvents(txn *badger.Txn, before time.Time) ([]deleteEntry, error) {
+// findExpiredEvents scans for up to limit events before the cutoff time,
+// skipping those the guard keeps.
+func (db *DB) findExpiredEvents(txn *badger.Txn, before time.Time, guard *deletionGuard, limit int) ([]deleteEntry, error) {
 	var toDelete []deleteEntry
 
 	opts := badger.DefaultIteratorOptions
@@ -153,7 +576,7 @@ func (db *DB) findExpiredEvents(txn *badger.Txn, before time.Time) ([]deleteEntr
 	defer it.Close()
 
 	prefix := eventKeyPrefix()
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && len(toDelete) < limit; it.Next() {
 		item := it.Item()
 		key := item.Key()
 
@@ -164,11 +587,15 @@ func (db *DB) findExpiredEvents(txn *badger.Txn, before time.Time) ([]deleteEntr
 
 		eventTime := ulidTime(id)
 		if eventTime.Before(before) {
+			if guard.heldAt(eventTime) {
+				continue
+			}
+
 			var event Event
 			err := item.Value(func(val []byte) error {
-				return json.Unmarshal(val, &event)
+				return db.codec().Unmarshal(val, &event)
 			})
-			if err != nil {
+			if err != nil || guard.keeps(id, &event) {
 				continue
 			}
 
@@ -185,8 +612,143 @@ func (db *DB) findExpiredEvents(txn *badger.Txn, before time.Time) ([]deleteEntr
 	return toDelete, nil
 }
 
-// deleteEventAndIndices removes an event and all its associated indices.
-// Returns an error only if the primary event deletion fails.
+// findExpiredTypeEvents scans the type index for up to limit events of the
+// given type before the cutoff time, skipping those the guard keeps.
+func (db *DB) findExpiredTypeEvents(txn *badger.Txn, eventType string, before time.Time, guard *deletionGuard, limit int) ([]deleteEntry, error) {
+	var toDelete []deleteEntry
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := encodeTypeIndexPrefix(eventType)
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && len(toDelete) < limit; it.Next() {
+		id, err := decodeIndexKey(it.Item().Key())
+		if err != nil {
+			continue
+		}
+
+		// Index entries are sorted by time, so we can stop early
+		if !ulidTime(id).Before(before) {
+			break
+		}
+		if guard.heldAt(ulidTime(id)) {
+			continue
+		}
+
+		item, err := txn.Get(encodeEventKey(id))
+		if err != nil {
+			continue
+		}
+
+		var event Event
+		err = item.Value(func(val []byte) error {
+			return db.codec().Unmarshal(val, &event)
+		})
+		if err != nil || guard.keeps(id, &event) {
+			continue
+		}
+
+		toDelete = append(toDelete, deleteEntry{
+			id:    id,
+			event: event,
+		})
+	}
+
+	return toDelete, nil
+}
+
+// deleteBatch deletes events in a transaction for as long as their entries
+// fit in it. BadgerDB limits the number and total size of the entries a
+// transaction writes, and every event deletes its data and index entries
+// along with it, so a batch of events with many tags can reach the limit
+// long before a fixed number of events would.
+type deleteBatch struct {
+	db     *DB
+	txn    *badger.Txn
+	deltas countDeltas
+
+	// entries and size estimate what the batch writes, as BadgerDB does
+	entries, size int64
+
+	deleted int64
+	full    bool
+}
+
+func (db *DB) newDeleteBatch(txn *badger.Txn) *deleteBatch {
+	return &deleteBatch{db: db, txn: txn, deltas: make(countDeltas)}
+}
+
+// add deletes an event with expireEvent, unless the batch is full or the
+// event's entries would not fit in it, which marks it full. Events that
+// cannot be deleted are skipped, except when the transaction is too big.
+func (b *deleteBatch) add(entry deleteEntry) error {
+	if b.full {
+		return nil
+	}
+
+	// Search index entries are found from the event's data
+	if b.db.opts.SearchIndex {
+		entry.searchKeys = b.db.searchIndexKeys(b.txn, entry)
+	}
+
+	// Half of the limits are left for the counts written on commit and
+	// for estimates that fall short
+	entries, size := deleteCost(entry)
+	if b.entries > 0 && (b.entries+entries >= b.db.badger.MaxBatchCount()/2 || b.size+size >= b.db.badger.MaxBatchSize()/2) {
+		b.full = true
+		return nil
+	}
+	b.entries += entries
+	b.size += size
+
+	if err := b.db.expireEvent(b.txn, entry); err != nil {
+		if errors.Is(err, badger.ErrTxnTooBig) {
+			return err
+		}
+		return nil
+	}
+	b.db.uncountExpired(b.deltas, entry)
+	b.deleted++
+	return nil
+}
+
+// commit writes the count deltas of the deleted events.
+func (b *deleteBatch) commit() error {
+	return b.db.counts.write(b.txn, b.deltas)
+}
+
+// deleteCost returns the number of entries that deleting an event writes
+// and their size, as BadgerDB estimates them: each deleted key, with its
+// version and metadata. Data is counted as a shared payload, the costlier
+// case, and search index entries as far as entry.searchKeys holds them.
+func deleteCost(entry deleteEntry) (int64, int64) {
+	keys := [][]byte{
+		encodeEventKey(entry.id),
+		encodeDataKey(entry.id),
+		encodePayloadRefKey(payloadHash{}, entry.id),
+		encodePayloadKey(payloadHash{}),
+		encodeTypeIndexKey(entry.event.Type, entry.id),
+		encodeIngestIndexKey(entry.event.IngestedAt, entry.id),
+		encodeCorrectionKey(entry.id), // superseded by a correction
+		encodeCorrectionKey(entry.id), // superseding another event
+	}
+	for k, v := range entry.event.Tags {
+		keys = append(keys, encodeTagIndexKey(k, v, entry.id))
+	}
+	keys = append(keys, entry.searchKeys...)
+
+	var size int64
+	for _, key := range keys {
+		size += int64(len(key)) + 12
+	}
+	return int64(len(keys)), size
+}
+
+// deleteEventAndIndices removes an event, its data and all its associated
+// indices. Returns an error only if deleting the event or its data fails.
 // Index deletion errors are ignored since orphaned indices are harmless
 // and will not affect correctness (they just won't match any events).
 func (db *DB) deleteEventAndIndices(txn *badger.Txn, entry deleteEntry) error {
@@ -194,12 +756,109 @@ func (db *DB) deleteEventAndIndices(txn *badger.Txn, entry deleteEntry) error {
 	if err := txn.Delete(encodeEventKey(entry.id)); err != nil {
 		return err
 	}
+	if db.opts.SearchIndex {
+		db.deleteSearchIndex(txn, entry)
+	}
+	if err := db.deleteData(txn, entry.id); err != nil {
+		return err
+	}
+
+	deleteIndices(txn, entry)
+	return nil
+}
+
+// expireEvent deletes an event as deleteEventAndIndices does, and also the
+// link to the correction that supersedes it, if any. Updates keep that link,
+// so it is not removed with the other indices.
+func (db *DB) expireEvent(txn *badger.Txn, entry deleteEntry) error {
+	if err := db.deleteEventAndIndices(txn, entry); err != nil {
+		return err
+	}
+	_ = txn.Delete(encodeCorrectionKey(entry.id))
+	return nil
+}
 
+// deleteIndices removes the type, tag and ingest time index entries of an
+// event, and the link to it from the event it supersedes.
+func deleteIndices(txn *badger.Txn, entry deleteEntry) {
 	// Best-effort index cleanup - ignore errors
 	_ = txn.Delete(encodeTypeIndexKey(entry.event.Type, entry.id))
 	for k, v := range entry.event.Tags {
 		_ = txn.Delete(encodeTagIndexKey(k, v, entry.id))
 	}
+	if !entry.event.IngestedAt.IsZero() {
+		_ = txn.Delete(encodeIngestIndexKey(entry.event.IngestedAt, entry.id))
+	}
+	if entry.event.Supersedes != nil {
+		_ = txn.Delete(encodeCorrectionKey(*entry.event.Supersedes))
+	}
+}
 
-	return nil
+// deleteOldest deletes up to limit of the oldest events, regardless of age
+// and of retention holds, but not events under legal hold.
+func (db *DB) deleteOldest(limit int) (int64, error) {
+	var deleted int64
+
+	guard := db.newDeletionGuard(false)
+	defer db.logKept(guard)
+
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		toDelete, err := db.findOldestEvents(txn, guard, limit)
+		if err != nil {
+			return err
+		}
+
+		batch := db.newDeleteBatch(txn)
+		for _, entry := range toDelete {
+			if err := batch.add(entry); err != nil {
+				return err
+			}
+		}
+		if err := batch.commit(); err != nil {
+			return err
+		}
+		deleted = batch.deleted
+		return nil
+	})
+
+	if deleted > 0 && db.aggCache != nil {
+		db.aggCache.invalidateAll()
+	}
+
+	return deleted, err
+}
+
+// findOldestEvents returns up to limit of the oldest events the guard does
+// not keep.
+func (db *DB) findOldestEvents(txn *badger.Txn, guard *deletionGuard, limit int) ([]deleteEntry, error) {
+	var toDelete []deleteEntry
+
+	opts := badger.DefaultIteratorOptions
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	prefix := eventKeyPrefix()
+	for it.Seek(prefix); it.ValidForPrefix(prefix) && len(toDelete) < limit; it.Next() {
+		item := it.Item()
+
+		id, err := decodeEventKey(item.Key())
+		if err != nil {
+			continue
+		}
+
+		var event Event
+		err = item.Value(func(val []byte) error {
+			return db.codec().Unmarshal(val, &event)
+		})
+		if err != nil || guard.keeps(id, &event) {
+			continue
+		}
+
+		toDelete = append(toDelete, deleteEntry{
+			id:    id,
+			event: event,
+		})
+	}
+
+	return toDelete, nil
 }
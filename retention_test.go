@@ -2,6 +2,7 @@ package squid
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"testing"
 	"time"
@@ -128,6 +129,57 @@ func TestDeleteBeforeRemovesIndices(t *testing.T) {
 	}
 }
 
+func TestDeleteBeforeManyTags(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// More index entries than fit in one transaction of deleteBatchSize events
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	appendEvents := func(eventType string) {
+		batch := make([]Event, 0, 1000)
+		for i := range 12_000 {
+			tags := make(map[string]string)
+			for j := range 8 {
+				tags[fmt.Sprintf("tag%d", j)] = fmt.Sprintf("value-%d-%d", j, i)
+			}
+			batch = append(batch, Event{Timestamp: base.Add(time.Duration(i) * time.Second), Type: eventType, Tags: tags})
+			if len(batch) == cap(batch) {
+				if _, err := db.AppendBatch(batch); err != nil {
+					t.Fatalf("AppendBatch failed: %v", err)
+				}
+				batch = batch[:0]
+			}
+		}
+	}
+	ctx := context.Background()
+
+	appendEvents("request")
+	if n, err := db.DeleteBefore(base.Add(24 * time.Hour)); err != nil || n != 12_000 {
+		t.Fatalf("expected 12000 deleted, got %d, %v", n, err)
+	}
+	if n, err := db.QueryCount(ctx, Query{}); err != nil || n != 0 {
+		t.Errorf("expected no events left, got %d, %v", n, err)
+	}
+
+	// Type policies delete through the type index
+	appendEvents("debug")
+	if n, err := db.deleteTypeBefore(ctx, "debug", base.Add(24*time.Hour)); err != nil || n != 12_000 {
+		t.Fatalf("expected 12000 deleted, got %d, %v", n, err)
+	}
+	if n, err := db.QueryCount(ctx, Query{}); err != nil || n != 0 {
+		t.Errorf("expected no events left, got %d, %v", n, err)
+	}
+}
+
 func TestSetRetentionStartsCleanup(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
@@ -195,23 +247,17 @@ func TestSetRetentionDisable(t *testing.T) {
 		CleanupInterval: 100 * time.Millisecond,
 	})
 
-	// Verify retention is running
-	db.mu.RLock()
-	running := db.retention != nil && db.retention.running
-	db.mu.RUnlock()
-	if !running {
-		t.Error("expected retention to be running")
+	// Verify retention is configured
+	if _, ok := db.RetentionPolicies()[""]; !ok {
+		t.Error("expected retention policy to be set")
 	}
 
 	// Disable retention
 	db.SetRetention(RetentionPolicy{MaxAge: 0})
 
-	// Verify retention is stopped
-	db.mu.RLock()
-	retention := db.retention
-	db.mu.RUnlock()
-	if retention != nil {
-		t.Error("expected retention to be nil after disabling")
+	// Verify retention is removed
+	if len(db.RetentionPolicies()) != 0 {
+		t.Error("expected no retention policies after disabling")
 	}
 }
 
@@ -315,9 +361,7 @@ func TestRetentionDefaultInterval(t *testing.T) {
 	})
 
 	// Default should be MaxAge/10 = 1 hour
-	db.mu.RLock()
-	interval := db.retention.policy.CleanupInterval
-	db.mu.RUnlock()
+	interval := db.RetentionPolicies()[""].CleanupInterval
 
 	expected := time.Hour
 	if interval != expected {
@@ -344,12 +388,264 @@ func TestRetentionMinimumInterval(t *testing.T) {
 	})
 
 	// Default should be minimum of 1 minute (not MaxAge/10 = 30s)
-	db.mu.RLock()
-	interval := db.retention.policy.CleanupInterval
-	db.mu.RUnlock()
+	interval := db.RetentionPolicies()[""].CleanupInterval
 
 	expected := time.Minute
 	if interval != expected {
 		t.Errorf("expected minimum interval %v, got %v", expected, interval)
 	}
 }
+
+func TestRetentionNamedPolicies(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Debug events are kept for 1 hour, everything else for 1 day
+	db.PauseRetention()
+	db.SetRetention(RetentionPolicy{MaxAge: 24 * time.Hour})
+	db.SetRetentionPolicy("debug", RetentionPolicy{
+		MaxAge: time.Hour,
+		Types:  []string{"debug"},
+	})
+
+	old := time.Now().Add(-2 * time.Hour)
+	_, _ = db.Append(Event{Timestamp: old, Type: "debug"})
+	_, _ = db.Append(Event{Timestamp: old, Type: "request"})
+	_, _ = db.Append(Event{Timestamp: time.Now().Add(-48 * time.Hour), Type: "request"})
+	_, _ = db.Append(Event{Type: "debug"})
+
+	policies := db.RetentionPolicies()
+	if len(policies) != 2 {
+		t.Fatalf("expected 2 policies, got %d", len(policies))
+	}
+
+	deleted, err := db.RunCleanupNow(context.Background())
+	if err != nil {
+		t.Fatalf("RunCleanupNow failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 deleted, got %d", deleted)
+	}
+
+	ctx := context.Background()
+	events, err := db.Query(ctx, Query{Types: []string{"debug"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("expected 1 debug event, got %d", len(events))
+	}
+
+	count, _ := db.Count()
+	if count != 2 {
+		t.Errorf("expected 2 events, got %d", count)
+	}
+
+	db.RemoveRetentionPolicy("debug")
+	if _, ok := db.RetentionPolicies()["debug"]; ok {
+		t.Error("expected debug policy to be removed")
+	}
+}
+
+func TestPauseResumeRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	_, _ = db.Append(Event{Timestamp: time.Now().Add(-2 * time.Hour), Type: "event"})
+
+	db.PauseRetention()
+	db.SetRetention(RetentionPolicy{
+		MaxAge:          time.Hour,
+		CleanupInterval: 10 * time.Millisecond,
+	})
+
+	// Wait for cleanup that must not run
+	time.Sleep(50 * time.Millisecond)
+
+	count, _ := db.Count()
+	if count != 1 {
+		t.Fatalf("expected 1 event while paused, got %d", count)
+	}
+
+	db.ResumeRetention()
+
+	// Wait for cleanup
+	time.Sleep(50 * time.Millisecond)
+
+	count, _ = db.Count()
+	if count != 0 {
+		t.Errorf("expected 0 events after resume, got %d", count)
+	}
+}
+
+func TestRunCleanupNow(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// No policies: nothing to do
+	deleted, err := db.RunCleanupNow(context.Background())
+	if err != nil || deleted != 0 {
+		t.Fatalf("expected 0 deleted and no error, got %d, %v", deleted, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	db.PauseRetention()
+	db.SetRetention(RetentionPolicy{MaxAge: time.Hour})
+	if _, err := db.RunCleanupNow(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	db.Close()
+	if _, err := db.RunCleanupNow(context.Background()); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestHoldRetention(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		eventType := []string{"request", "error"}[i%2]
+		if _, err := db.Append(Event{Timestamp: base.Add(time.Duration(i) * time.Hour), Type: eventType}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// Hold 11:00 to 12:00 while "exporting" it
+	hold := db.HoldRetention(base.Add(time.Hour), base.Add(2*time.Hour))
+
+	deleted, err := db.DeleteBefore(base.Add(4 * time.Hour))
+	if err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	if deleted != 2 {
+		t.Errorf("expected 2 deleted around the hold, got %d", deleted)
+	}
+
+	db.SetRetentionPolicy("errors", RetentionPolicy{MaxAge: time.Hour, CleanupInterval: time.Hour, Types: []string{"error"}})
+	if _, err := db.RunCleanupNow(context.Background()); err != nil {
+		t.Fatalf("RunCleanupNow failed: %v", err)
+	}
+
+	events, err := db.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var held int
+	for _, e := range events {
+		if !e.Timestamp.Before(base.Add(time.Hour)) && !e.Timestamp.After(base.Add(2*time.Hour)) {
+			held++
+		}
+	}
+	if held != 2 {
+		t.Errorf("expected both held events to remain, got %d", held)
+	}
+
+	// Released events expire as usual
+	hold.Release()
+	hold.Release()
+	if _, err := db.DeleteBefore(base.Add(4 * time.Hour)); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	count, err := db.Count()
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 event after release, got %d", count)
+	}
+}
+
+func TestRetentionPreview(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	db, err := OpenWithOptions(dir, Options{Now: func() time.Time { return base.Add(10 * time.Hour) }})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// Hours 0-5 alternate request and error events
+	for i := 0; i < 6; i++ {
+		eventType := []string{"request", "error"}[i%2]
+		event := Event{Timestamp: base.Add(time.Duration(i) * time.Hour), Type: eventType, Data: map[string]any{"i": i}}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	if s := db.Stats(); s.RetentionEligibleEvents != 0 || s.RetentionEligibleBytes != 0 {
+		t.Errorf("expected nothing eligible without policies, got %+v", s)
+	}
+
+	db.PauseRetention()
+	// Hours 0 and 1 expire under the default policy and the error at hour 3
+	// under the other, while hour 0 is held
+	db.SetRetention(RetentionPolicy{MaxAge: 8 * time.Hour})
+	db.SetRetentionPolicy("errors", RetentionPolicy{MaxAge: 5 * time.Hour, Types: []string{"error"}})
+	hold := db.HoldRetention(base, base)
+
+	s := db.Stats()
+	if s.RetentionEligibleEvents != 2 {
+		t.Errorf("expected 2 eligible events, got %d", s.RetentionEligibleEvents)
+	}
+	if s.RetentionEligibleBytes <= 0 {
+		t.Error("expected eligible bytes to be reported")
+	}
+
+	hold.Release()
+	deleted, err := db.RunCleanupNow(context.Background())
+	if err != nil {
+		t.Fatalf("RunCleanupNow failed: %v", err)
+	}
+	if deleted != 3 {
+		t.Errorf("expected 3 deleted, got %d", deleted)
+	}
+	if s := db.Stats(); s.RetentionEligibleEvents != 0 || s.RetentionEligibleBytes != 0 {
+		t.Errorf("expected nothing eligible after cleanup, got %+v", s)
+	}
+}
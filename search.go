@@ -0,0 +1,215 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxSearchWordLen is the length in bytes of the longest word that
+// Query.Search matches and the search index holds. Longer runs of letters
+// and digits, such as encoded blobs, are ignored.
+const maxSearchWordLen = 64
+
+// searchTerm is a word of Query.Search. A prefix term matches every word
+// it starts.
+type searchTerm struct {
+	word   string
+	prefix bool
+}
+
+// searchWords calls fn with each word of s: a run of letters and digits,
+// lower-cased, of at most maxSearchWordLen bytes, and the offset in s just
+// past it.
+func searchWords(s string, fn func(word string, end int)) {
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+
+	for i := 0; i < len(s); {
+		start := strings.IndexFunc(s[i:], isWord)
+		if start < 0 {
+			return
+		}
+		start += i
+		end := strings.IndexFunc(s[start:], func(r rune) bool { return !isWord(r) })
+		if end < 0 {
+			end = len(s)
+		} else {
+			end += start
+		}
+		if end-start <= maxSearchWordLen {
+			fn(strings.ToLower(s[start:end]), end)
+		}
+		i = end
+	}
+}
+
+// parseSearch returns the terms of a Query.Search. A word followed by *
+// is a prefix term.
+func parseSearch(s string) []searchTerm {
+	var terms []searchTerm
+	searchWords(s, func(word string, end int) {
+		prefix := end < len(s) && s[end] == '*'
+		if !slices.Contains(terms, searchTerm{word, prefix}) {
+			terms = append(terms, searchTerm{word, prefix})
+		}
+	})
+	return terms
+}
+
+// checkSearch validates the search of a query.
+func checkSearch(q Query) error {
+	if q.Search != "" && len(parseSearch(q.Search)) == 0 {
+		return fmt.Errorf("%w: search %q has no words", ErrInvalidQuery, q.Search)
+	}
+	return nil
+}
+
+// eventWords returns the words of the string values in an event's data,
+// and of its tag values if tags is set.
+func eventWords(event *Event, tags bool) map[string]struct{} {
+	words := make(map[string]struct{})
+	add := func(word string, _ int) { words[word] = struct{}{} }
+
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case string:
+			searchWords(v, add)
+		case map[string]any:
+			for _, e := range v {
+				walk(e)
+			}
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(event.Data)
+
+	if tags {
+		for _, v := range event.Tags {
+			searchWords(v, add)
+		}
+	}
+	return words
+}
+
+// matchesSearch reports whether an event has every word of the query's
+// search.
+func matchesSearch(event *Event, q Query) bool {
+	if q.Search == "" {
+		return true
+	}
+	words := eventWords(event, q.SearchTags)
+
+	for _, t := range parseSearch(q.Search) {
+		if _, ok := words[t.word]; ok {
+			continue
+		}
+		if !t.prefix {
+			return false
+		}
+		found := false
+		for w := range words {
+			if strings.HasPrefix(w, t.word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// writeSearchIndex writes the search index entries of an event: one per
+// word of its data and tag values. data is the encoded data of an event
+// whose Data is not set. It returns the number of entries and their bytes.
+func (db *DB) writeSearchIndex(txn *badger.Txn, event *Event, data []byte) (int, int, error) {
+	if event.Data == nil && data != nil {
+		decoded := *event
+		if err := db.codec().Unmarshal(data, &decoded.Data); err != nil {
+			return 0, 0, fmt.Errorf("failed to decode data for search index: %w", err)
+		}
+		event = &decoded
+	}
+
+	var entries, bytes int
+	for word := range eventWords(event, true) {
+		key := encodeSearchIndexKey(word, event.ID)
+		if err := txn.Set(key, nil); err != nil {
+			return 0, 0, fmt.Errorf("failed to write search index %q: %w", word, err)
+		}
+		entries++
+		bytes += len(key)
+	}
+	return entries, bytes, nil
+}
+
+// deleteSearchIndex removes the search index entries of an event, reading
+// its data to find them unless entry.searchKeys holds them already. Errors
+// are ignored as in deleteIndices.
+func (db *DB) deleteSearchIndex(txn *badger.Txn, entry deleteEntry) {
+	keys := entry.searchKeys
+	if keys == nil {
+		keys = db.searchIndexKeys(txn, entry)
+	}
+	for _, key := range keys {
+		_ = txn.Delete(key)
+	}
+}
+
+// searchIndexKeys returns the search index keys of an event, reading its
+// data to find them. It returns an empty slice, not nil, if there are none
+// or the data cannot be read.
+func (db *DB) searchIndexKeys(txn *badger.Txn, entry deleteEntry) [][]byte {
+	keys := [][]byte{}
+	event := entry.event
+	event.ID = entry.id
+	if err := db.loadData(txn, &event); err != nil {
+		return keys
+	}
+	for word := range eventWords(&event, true) {
+		keys = append(keys, encodeSearchIndexKey(word, entry.id))
+	}
+	return keys
+}
+
+// searchIndexTerm returns the term of a query that the search index is
+// scanned for: the longest whole word, which is likely the rarest, or else
+// the longest prefix.
+func searchIndexTerm(q Query) searchTerm {
+	var best searchTerm
+	for _, t := range parseSearch(q.Search) {
+		if best.word == "" || !t.prefix && best.prefix || t.prefix == best.prefix && len(t.word) > len(best.word) {
+			best = t
+		}
+	}
+	return best
+}
+
+// scanSearchIndex scans the search index for the IDs of events with a
+// word of the query's search, returning them in the query's order.
+func (db *DB) scanSearchIndex(ctx context.Context, txn *badger.Txn, q Query) ([]ulid.ULID, error) {
+	t := searchIndexTerm(q)
+	if !t.prefix {
+		return db.scanIndex(ctx, txn, encodeSearchIndexPrefix(t.word, true), q)
+	}
+
+	// An event with several words of the prefix is found once for each
+	value := func(key []byte) (string, error) {
+		word, _, err := decodeSearchIndexKey(key)
+		return word, err
+	}
+	valuePrefix := func(word string) []byte { return encodeSearchIndexPrefix(word, true) }
+	match := func(string) bool { return true }
+	ids, err := db.scanIndexValues(ctx, txn, encodeSearchIndexPrefix(t.word, false), value, valuePrefix, match, q)
+	return slices.Compact(ids), err
+}
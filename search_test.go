@@ -0,0 +1,175 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+func TestParseSearch(t *testing.T) {
+	got := parseSearch("Disk FULL, time* disk -")
+	want := []searchTerm{{"disk", false}, {"full", false}, {"time", true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSearch = %v, want %v", got, want)
+	}
+}
+
+func TestQuerySearch(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		dir, err := os.MkdirTemp("", "squid-test-*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		db, err := OpenWithOptions(dir, Options{SearchIndex: indexed})
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer db.Close()
+
+		base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+		events := []Event{
+			{Type: "log", Data: map[string]any{"msg": "Disk is FULL on /var"}},
+			{Type: "log", Data: map[string]any{"msg": "connection timeout", "retries": 3.0}},
+			{Type: "log", Data: map[string]any{"error": map[string]any{"detail": []any{"request timed out"}}}},
+			{Type: "log", Tags: map[string]string{"host": "disk-full-1"}, Data: map[string]any{"msg": "ok"}},
+			{Type: "audit", Data: map[string]any{"msg": "disk full"}},
+		}
+		for i, e := range events {
+			e.Timestamp = base.Add(time.Duration(i) * time.Second)
+			if _, err := db.Append(e); err != nil {
+				t.Fatalf("Append failed: %v", err)
+			}
+		}
+
+		ctx := context.Background()
+		tests := []struct {
+			q    Query
+			want int
+		}{
+			{Query{Search: "disk full"}, 2},
+			{Query{Search: "DISK"}, 2},
+			{Query{Search: "disk full", SearchTags: true}, 3},
+			{Query{Search: "disk full", Types: []string{"log"}}, 1},
+			{Query{Search: "time*"}, 2},
+			{Query{Search: "timeout"}, 1},
+			{Query{Search: "tim* out"}, 1},
+			{Query{Search: "time"}, 0},
+			{Query{Search: "disk full", Limit: 1, Descending: true}, 1},
+		}
+		for _, tt := range tests {
+			got, err := db.Query(ctx, tt.q)
+			if err != nil {
+				t.Fatalf("Query failed: %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("indexed=%v %+v: expected %d events, got %d", indexed, tt.q, tt.want, len(got))
+			}
+		}
+
+		if _, err := db.Query(ctx, Query{Search: "*"}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("expected ErrInvalidQuery for a search without words, got %v", err)
+		}
+	}
+}
+
+func TestSearchIndexMaintenance(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{SearchIndex: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	result, err := db.Append(Event{Type: "log", Data: map[string]any{"msg": "disk full"}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if result.IndexEntries != 3 {
+		t.Errorf("expected 3 index entries, got %d", result.IndexEntries)
+	}
+
+	updated := *result.Event
+	updated.Data = map[string]any{"msg": "disk ok"}
+	if _, err := db.Update(updated); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+
+	ctx := context.Background()
+	q := Query{Search: "full", Hint: ForceIndex("search")}
+	var stats ExecStats
+	if events, err := db.Query(WithExecStats(ctx, &stats), q); err != nil || len(events) != 0 {
+		t.Errorf("expected no events after update, got %d (%v)", len(events), err)
+	}
+	if stats.Plan != "search index" || stats.KeysScanned != 0 {
+		t.Errorf("expected the replaced words to be unindexed, got plan %q with %d keys scanned", stats.Plan, stats.KeysScanned)
+	}
+
+	// Deleting the event removes the rest
+	if _, err := db.DeleteBefore(time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+	var entries int
+	err = db.badger.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek([]byte(prefixSearch)); it.ValidForPrefix([]byte(prefixSearch)); it.Next() {
+			entries++
+		}
+		return nil
+	})
+	if err != nil || entries != 0 {
+		t.Errorf("expected no search index entries, got %d (%v)", entries, err)
+	}
+}
+
+func TestDeleteSearchIndexedEvents(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{SearchIndex: true})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// 40 words each, more search index entries than fit in one transaction
+	// of deleteBatchSize events
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	batch := make([]Event, 0, 1000)
+	for i := range 12_000 {
+		msg := ""
+		for j := range 40 {
+			msg += fmt.Sprintf("word%dx%d ", j, i)
+		}
+		batch = append(batch, Event{Timestamp: base.Add(time.Duration(i) * time.Second), Type: "log", Data: map[string]any{"msg": msg}})
+		if len(batch) == cap(batch) {
+			if _, err := db.AppendBatch(batch); err != nil {
+				t.Fatalf("AppendBatch failed: %v", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if n, err := db.DeleteBefore(base.Add(24 * time.Hour)); err != nil || n != 12_000 {
+		t.Fatalf("expected 12000 deleted, got %d, %v", n, err)
+	}
+	if n, err := db.QueryCount(context.Background(), Query{}); err != nil || n != 0 {
+		t.Errorf("expected no events left, got %d, %v", n, err)
+	}
+}
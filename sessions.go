@@ -0,0 +1,129 @@
+package squid
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// maxSessions limits the number of sessions AggregateSessions computes.
+const maxSessions = 100_000
+
+// Session is the aggregation of a run of events with the same tag value,
+// none of them further than the session gap from the previous one.
+type Session struct {
+	// Key is the value of the tag the events were grouped by, e.g. a user ID.
+	Key string `json:"key"`
+
+	// Start is the timestamp of the first event of the session.
+	Start time.Time `json:"start"`
+
+	// End is the timestamp of the last event of the session.
+	End time.Time `json:"end"`
+
+	// Duration is the time between the first and last event, zero for a
+	// session of one event.
+	Duration time.Duration `json:"duration"`
+
+	AggregateResult
+}
+
+// openSession is a session that may still be extended by later events.
+type openSession struct {
+	start, end time.Time
+	agg        *aggregator
+}
+
+// AggregateSessions groups the events matching the query by the value of
+// tagKey and splits each group into sessions wherever two consecutive
+// events are more than gap apart, e.g. user activity separated by 30
+// minutes of inactivity. It aggregates the events of each session and
+// returns the sessions ordered by start, then key. Events without the tag
+// are ignored. Query.Limit applies to the events read, not the sessions.
+// Events are streamed rather than loaded at once, so memory grows with the
+// number of sessions, not of events.
+func (db *DB) AggregateSessions(ctx context.Context, q Query, tagKey string, gap time.Duration, field string, aggs []AggregationType) ([]*Session, error) {
+	if tagKey == "" {
+		return nil, fmt.Errorf("%w: tag key is required", ErrInvalidQuery)
+	}
+	if gap <= 0 {
+		return nil, fmt.Errorf("%w: gap must be positive", ErrInvalidQuery)
+	}
+
+	q.Descending = false
+	q.OrderByIngest = false
+	q.OmitData = field == ""
+
+	unit := db.opts.Units[field]
+	var sessions []*Session
+	closeSession := func(key string, s *openSession) error {
+		if len(sessions) >= maxSessions {
+			return fmt.Errorf("%w: more than %d sessions", ErrInvalidQuery, maxSessions)
+		}
+		sessions = append(sessions, &Session{
+			Key:             key,
+			Start:           s.start,
+			End:             s.end,
+			Duration:        s.end.Sub(s.start),
+			AggregateResult: *s.agg.result(),
+		})
+		return nil
+	}
+
+	// Events are streamed in ID order, keeping only the sessions still open.
+	// IDs only order events to the millisecond, so an event may precede the
+	// start or end of its session by less than that.
+	open := make(map[string]*openSession)
+	var event Event // reused for every event, as the aggregators keep no references
+	err := db.QueryRaw(ctx, q, func(id ulid.ULID, value []byte) error {
+		event.reset()
+		if err := db.codec().Unmarshal(value, &event); err != nil {
+			db.recordCorrupt(ctx)
+			return nil
+		}
+		key, ok := event.Tags[tagKey]
+		if !ok {
+			return nil
+		}
+
+		s := open[key]
+		if s != nil && event.Timestamp.Sub(s.end) > gap {
+			if err := closeSession(key, s); err != nil {
+				return err
+			}
+			s = nil
+		}
+		if s == nil {
+			s = &openSession{start: event.Timestamp, end: event.Timestamp, agg: newAggregator(field, aggs)}
+			s.agg.unit = unit
+			open[key] = s
+		}
+		if event.Timestamp.Before(s.start) {
+			s.start = event.Timestamp
+		}
+		if event.Timestamp.After(s.end) {
+			s.end = event.Timestamp
+		}
+		return s.agg.add(&event)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for key, s := range open {
+		if err := closeSession(key, s); err != nil {
+			return nil, err
+		}
+	}
+
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		if c := a.Start.Compare(b.Start); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return sessions, nil
+}
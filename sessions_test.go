@@ -0,0 +1,88 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAggregateSessions(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	activity := []struct {
+		user   string
+		offset time.Duration
+	}{
+		{"alice", 0},
+		{"bob", 5 * time.Minute},
+		{"bob", 5*time.Minute + 500*time.Microsecond}, // In the same millisecond, read in either order
+		{"alice", 20 * time.Minute},
+		{"alice", 45 * time.Minute},
+		{"alice", 2 * time.Hour}, // More than 30 minutes after the last click
+		{"", 50 * time.Minute},   // Anonymous, not part of any session
+	}
+	for _, a := range activity {
+		event := Event{Timestamp: day.Add(a.offset), Type: "click", Data: map[string]any{"value": 1.0}}
+		if a.user != "" {
+			event.Tags = map[string]string{"user": a.user}
+		}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	sessions, err := db.AggregateSessions(ctx, Query{Types: []string{"click"}}, "user", 30*time.Minute, "value", []AggregationType{Count, Sum})
+	if err != nil {
+		t.Fatalf("AggregateSessions failed: %v", err)
+	}
+
+	want := []struct {
+		key      string
+		start    time.Duration
+		duration time.Duration
+		count    int64
+	}{
+		{"alice", 0, 45 * time.Minute, 3},
+		{"bob", 5 * time.Minute, 500 * time.Microsecond, 2},
+		{"alice", 2 * time.Hour, 0, 1},
+	}
+	if len(sessions) != len(want) {
+		t.Fatalf("expected %d sessions, got %d", len(want), len(sessions))
+	}
+	for i, w := range want {
+		s := sessions[i]
+		if s.Key != w.key || !s.Start.Equal(day.Add(w.start)) || s.Duration != w.duration || s.Count != w.count || s.Sum != float64(w.count) {
+			t.Errorf("session %d: expected %s at +%s for %s with %d events, got %+v", i, w.key, w.start, w.duration, w.count, s)
+		}
+	}
+
+	// Limit applies to the events read, here the first click of each user
+	sessions, err = db.AggregateSessions(ctx, Query{Types: []string{"click"}, Limit: 2}, "user", 30*time.Minute, "", []AggregationType{Count})
+	if err != nil {
+		t.Fatalf("AggregateSessions failed: %v", err)
+	}
+	if len(sessions) != 2 || sessions[0].Key != "alice" || sessions[1].Key != "bob" || sessions[0].Count != 1 || sessions[1].Count != 1 {
+		t.Errorf("expected one click of alice and bob, got %+v", sessions)
+	}
+
+	if _, err := db.AggregateSessions(ctx, Query{}, "user", 0, "", []AggregationType{Count}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery for a zero gap, got %v", err)
+	}
+	if _, err := db.AggregateSessions(ctx, Query{}, "", time.Minute, "", []AggregationType{Count}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery without a tag key, got %v", err)
+	}
+}
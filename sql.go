@@ -0,0 +1,680 @@
+package squid
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SQLStatement is a parsed SQL-like query: the events it selects and, for
+// SELECT with aggregations, what it computes over them.
+type SQLStatement struct {
+	// Query selects the events.
+	Query Query
+
+	// Since is the length of the window ending now given by SINCE, or 0.
+	// It is applied when the statement runs.
+	Since time.Duration
+
+	// Field is the data field aggregated, or "" for SELECT * and
+	// SELECT COUNT(*).
+	Field string
+
+	// Aggregations are the aggregations selected, or none for SELECT *.
+	Aggregations []AggregationType
+}
+
+// SQLResult is the result of QuerySQL: the selected events, or the
+// aggregations computed over them.
+type SQLResult struct {
+	Events    []*Event         `json:"events,omitempty"`
+	Aggregate *AggregateResult `json:"aggregate,omitempty"`
+}
+
+// QuerySQL runs a SQL-like query, for ad-hoc questions from command lines
+// and tools that do not build a Query in Go:
+//
+//	SELECT * WHERE type='request' AND tags.service='api' AND data.status>=500 SINCE 1h LIMIT 100
+//	SELECT count(*), avg(data.latency), p99(data.latency) WHERE type IN ('request', 'rpc') SINCE 7d
+//
+// See ParseSQL for the grammar.
+func (db *DB) QuerySQL(ctx context.Context, sql string) (*SQLResult, error) {
+	return runSQL(ctx, db, db.now(), sql)
+}
+
+// sqlTarget is what a SQL-like query runs against.
+type sqlTarget interface {
+	Query(ctx context.Context, q Query) ([]*Event, error)
+	QueryCount(ctx context.Context, q Query) (int64, error)
+	Aggregate(ctx context.Context, q Query, field string, aggs []AggregationType) (*AggregateResult, error)
+}
+
+// runSQL parses and runs a SQL-like query, with SINCE ending at now.
+func runSQL(ctx context.Context, t sqlTarget, now time.Time, sql string) (*SQLResult, error) {
+	stmt, err := ParseSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	q := stmt.Query
+	if stmt.Since > 0 {
+		start := now.Add(-stmt.Since)
+		if q.Start == nil || q.Start.Before(start) {
+			q.Start = &start
+		}
+	}
+
+	switch {
+	case len(stmt.Aggregations) == 0:
+		events, err := t.Query(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return &SQLResult{Events: events}, nil
+
+	case stmt.Field == "":
+		n, err := t.QueryCount(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		return &SQLResult{Aggregate: &AggregateResult{Count: n}}, nil
+
+	default:
+		result, err := t.Aggregate(ctx, q, stmt.Field, stmt.Aggregations)
+		if err != nil {
+			return nil, err
+		}
+		return &SQLResult{Aggregate: result}, nil
+	}
+}
+
+// ParseSQL parses a SQL-like query. Keywords are case-insensitive and the
+// clauses come in this order, all but SELECT optional:
+//
+//	SELECT * | COUNT(*) | agg(field), ...
+//	FROM events
+//	WHERE condition AND condition ...
+//	SINCE duration
+//	ORDER BY time ASC | DESC
+//	LIMIT n OFFSET n
+//
+// Aggregations are those of ParseAggregationType, over one data field.
+// COUNT(*) counts the selected events, and cannot be combined with others.
+//
+// Conditions compare type, tags.key, data.field or time, where a field
+// without a prefix is a data field:
+//
+//	type = 'request'               type != 'debug'
+//	type IN ('request', 'rpc')     type NOT IN ('debug', 'trace')
+//	type LIKE 'payment.%'
+//	tags.service = 'api'           tags.env != 'test'
+//	data.status >= 500             data.path = '/login'      data.cached = true
+//	data.error IS NOT NULL         data.user IS NULL
+//	time >= '2024-01-01T00:00:00Z' time <= '2024-01-02T00:00:00Z'
+//
+// Data comparisons take =, !=, <>, <, <=, > and >=. Time bounds are
+// inclusive, as Query.Start and Query.End are. Strings are single-quoted,
+// with ” for a quote, and names that are not plain words double-quoted,
+// as in tags."app.kubernetes.io/name". Durations are those of
+// time.ParseDuration, or a number of days or weeks such as 7d or 2w.
+func ParseSQL(sql string) (*SQLStatement, error) {
+	p := &sqlParser{src: sql}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	stmt := &SQLStatement{}
+	if err := p.parse(stmt); err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+// sqlTokenKind is the kind of a token of a SQL-like query.
+type sqlTokenKind int
+
+const (
+	sqlWord   sqlTokenKind = iota // keyword, name or number
+	sqlName                       // double-quoted name
+	sqlString                     // single-quoted string
+	sqlSymbol                     // operator or punctuation
+	sqlEOF
+)
+
+// sqlToken is a token of a SQL-like query.
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+	pos  int
+}
+
+// sqlParser parses a SQL-like query.
+type sqlParser struct {
+	src    string
+	tokens []sqlToken
+	next   int
+}
+
+// lex splits the query into tokens.
+func (p *sqlParser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+
+		case r == '\'' || r == '"':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(s) {
+					return &SQLError{Offset: i, Message: "unterminated quote"}
+				}
+				if s[j] == byte(r) {
+					if j+1 < len(s) && s[j+1] == byte(r) {
+						b.WriteByte(byte(r))
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(s[j])
+				j++
+			}
+			kind := sqlString
+			if r == '"' {
+				kind = sqlName
+			}
+			p.tokens = append(p.tokens, sqlToken{kind, b.String(), i})
+			i = j + 1
+
+		case strings.ContainsRune("(),*=", r):
+			p.tokens = append(p.tokens, sqlToken{sqlSymbol, string(r), i})
+			i++
+
+		case r == '<' || r == '>' || r == '!':
+			op := s[i : i+1]
+			if i+1 < len(s) && (s[i+1] == '=' || r == '<' && s[i+1] == '>') {
+				op = s[i : i+2]
+			}
+			if op == "!" {
+				return &SQLError{Offset: i, Message: "unexpected '!'"}
+			}
+			p.tokens = append(p.tokens, sqlToken{sqlSymbol, op, i})
+			i += len(op)
+
+		case isSQLWordRune(r):
+			j := i
+			for j < len(s) {
+				r, size := utf8.DecodeRuneInString(s[j:])
+				if !isSQLWordRune(r) {
+					break
+				}
+				j += size
+			}
+			p.tokens = append(p.tokens, sqlToken{sqlWord, s[i:j], i})
+			i = j
+
+		default:
+			return &SQLError{Offset: i, Message: fmt.Sprintf("unexpected %q", r)}
+		}
+	}
+	p.tokens = append(p.tokens, sqlToken{sqlEOF, "", len(s)})
+	return nil
+}
+
+// isSQLWordRune reports whether r can be part of a word: a keyword, a
+// name such as tags.k8s-app, or a number such as -1.5e3.
+func isSQLWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-+:/", r)
+}
+
+// peek returns the next token.
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.next]
+}
+
+// take returns the next token and moves past it.
+func (p *sqlParser) take() sqlToken {
+	t := p.tokens[p.next]
+	if t.kind != sqlEOF {
+		p.next++
+	}
+	return t
+}
+
+// keyword reports whether the next token is one of the given keywords,
+// and moves past it if so.
+func (p *sqlParser) keyword(words ...string) bool {
+	t := p.peek()
+	if t.kind != sqlWord {
+		return false
+	}
+	for _, w := range words {
+		if strings.EqualFold(t.text, w) {
+			p.next++
+			return true
+		}
+	}
+	return false
+}
+
+// symbol reports whether the next token is the given symbol, and moves
+// past it if so.
+func (p *sqlParser) symbol(s string) bool {
+	if t := p.peek(); t.kind == sqlSymbol && t.text == s {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// errorf returns a *SQLError at token t.
+func (p *sqlParser) errorf(t sqlToken, format string, args ...any) error {
+	return &SQLError{Offset: t.pos, Message: fmt.Sprintf(format, args...)}
+}
+
+// describe names a token in error messages.
+func (t sqlToken) describe() string {
+	switch t.kind {
+	case sqlEOF:
+		return "end of query"
+	case sqlString:
+		return fmt.Sprintf("string '%s'", t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// expect moves past the given keyword or symbol, or fails.
+func (p *sqlParser) expect(s string) error {
+	if p.symbol(s) || p.keyword(s) {
+		return nil
+	}
+	t := p.peek()
+	return p.errorf(t, "expected %s, found %s", s, t.describe())
+}
+
+// parse parses the whole query into stmt.
+func (p *sqlParser) parse(stmt *SQLStatement) error {
+	if err := p.expect("SELECT"); err != nil {
+		return err
+	}
+	if err := p.parseSelect(stmt); err != nil {
+		return err
+	}
+
+	if p.keyword("FROM") {
+		if t := p.take(); t.kind != sqlWord || !strings.EqualFold(t.text, "events") {
+			return p.errorf(t, "expected events after FROM, found %s", t.describe())
+		}
+	}
+
+	if p.keyword("WHERE") {
+		for {
+			if err := p.parseCondition(&stmt.Query); err != nil {
+				return err
+			}
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("SINCE") {
+		t := p.take()
+		d, err := parseSQLDuration(t.text)
+		if t.kind != sqlWord || err != nil || d <= 0 {
+			return p.errorf(t, "expected a duration after SINCE, found %s", t.describe())
+		}
+		stmt.Since = d
+	}
+
+	if p.keyword("ORDER") {
+		if err := p.expect("BY"); err != nil {
+			return err
+		}
+		if t := p.take(); t.kind != sqlWord || !strings.EqualFold(t.text, "time") {
+			return p.errorf(t, "events can only be ordered by time, not %s", t.describe())
+		}
+		if p.keyword("DESC") {
+			stmt.Query.Descending = true
+		} else {
+			p.keyword("ASC")
+		}
+	}
+
+	if p.keyword("LIMIT") {
+		n, err := p.parseCount("LIMIT")
+		if err != nil {
+			return err
+		}
+		stmt.Query.Limit = n
+		if p.keyword("OFFSET") {
+			if stmt.Query.Offset, err = p.parseCount("OFFSET"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t := p.peek(); t.kind != sqlEOF {
+		return p.errorf(t, "unexpected %s", t.describe())
+	}
+	return nil
+}
+
+// parseSelect parses the projection of a SELECT.
+func (p *sqlParser) parseSelect(stmt *SQLStatement) error {
+	if p.symbol("*") {
+		return nil
+	}
+
+	countAll := false
+	for {
+		t := p.take()
+		if t.kind != sqlWord {
+			return p.errorf(t, "expected * or an aggregation, found %s", t.describe())
+		}
+		agg, err := ParseAggregationType(t.text)
+		if err != nil {
+			return p.errorf(t, "unknown aggregation %s", t.describe())
+		}
+		if err := p.expect("("); err != nil {
+			return err
+		}
+
+		if arg := p.peek(); p.symbol("*") {
+			if agg != Count {
+				return p.errorf(arg, "%s(*) is not an aggregation; only count(*) is", agg)
+			}
+			countAll = true
+		} else {
+			field, kind, err := p.parseField()
+			if err != nil {
+				return err
+			}
+			if kind != "data" {
+				return p.errorf(arg, "only data fields can be aggregated, not %s", arg.describe())
+			}
+			if stmt.Field != "" && field != stmt.Field {
+				return p.errorf(arg, "all aggregations must be over the same field, %q", stmt.Field)
+			}
+			stmt.Field = field
+		}
+		if err := p.expect(")"); err != nil {
+			return err
+		}
+		stmt.Aggregations = append(stmt.Aggregations, agg)
+
+		if countAll && stmt.Field != "" {
+			return p.errorf(t, "count(*) cannot be combined with aggregations of a field")
+		}
+		if !p.symbol(",") {
+			return nil
+		}
+	}
+}
+
+// parseField parses a field name and returns it with its kind: "type",
+// "time", "tags" or "data".
+func (p *sqlParser) parseField() (string, string, error) {
+	t := p.take()
+	switch t.kind {
+	case sqlName:
+		return t.text, "data", nil
+	case sqlWord:
+	default:
+		return "", "", p.errorf(t, "expected a field, found %s", t.describe())
+	}
+
+	name := t.text
+	for _, kind := range []string{"type", "time"} {
+		if strings.EqualFold(name, kind) {
+			return "", kind, nil
+		}
+	}
+	for _, kind := range []string{"tags", "data"} {
+		prefix, rest, ok := strings.Cut(name, ".")
+		if !strings.EqualFold(prefix, kind) {
+			continue
+		}
+		if ok && rest != "" {
+			return rest, kind, nil
+		}
+		// tags."quoted name"
+		if n := p.peek(); strings.HasSuffix(name, ".") && n.kind == sqlName {
+			p.next++
+			return n.text, kind, nil
+		}
+		return "", "", p.errorf(t, "expected a name after %s.", kind)
+	}
+	if r, _ := utf8.DecodeRuneInString(name); !unicode.IsLetter(r) && r != '_' {
+		return "", "", p.errorf(t, "expected a field, found %s", t.describe())
+	}
+	return name, "data", nil
+}
+
+// parseCondition parses a condition of a WHERE clause into q.
+func (p *sqlParser) parseCondition(q *Query) error {
+	start := p.peek()
+	field, kind, err := p.parseField()
+	if err != nil {
+		return err
+	}
+
+	if p.keyword("IS") {
+		not := p.keyword("NOT")
+		if err := p.expect("NULL"); err != nil {
+			return err
+		}
+		if kind != "data" {
+			return p.errorf(start, "IS NULL only applies to data fields")
+		}
+		if not {
+			q.HasFields = append(q.HasFields, field)
+		} else {
+			q.MissingFields = append(q.MissingFields, field)
+		}
+		return nil
+	}
+
+	if kind == "type" {
+		return p.parseTypeCondition(q, start)
+	}
+
+	opTok := p.take()
+	op, ok := sqlOps[opTok.text]
+	if opTok.kind != sqlSymbol || !ok {
+		return p.errorf(opTok, "expected a comparison, found %s", opTok.describe())
+	}
+	valTok := p.peek()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "tags":
+		s, ok := value.(string)
+		if !ok || (op != EQ && op != NE) {
+			return p.errorf(opTok, "tags can only be compared with = or != to a string")
+		}
+		if op == EQ {
+			if q.Tags == nil {
+				q.Tags = make(map[string]string)
+			}
+			q.Tags[field] = s
+		} else {
+			if q.ExcludeTags == nil {
+				q.ExcludeTags = make(map[string]string)
+			}
+			q.ExcludeTags[field] = s
+		}
+
+	case "time":
+		s, _ := value.(string)
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return p.errorf(valTok, "expected an RFC 3339 time, found %s", valTok.describe())
+		}
+		switch op {
+		case GT, GTE:
+			q.Start = &t
+		case LT, LTE:
+			q.End = &t
+		default:
+			return p.errorf(opTok, "time can only be compared with <, <=, > or >=")
+		}
+
+	default:
+		q.Where = append(q.Where, Predicate{Field: field, Op: op, Value: value})
+	}
+	return nil
+}
+
+// parseTypeCondition parses the rest of a condition on the event type.
+func (p *sqlParser) parseTypeCondition(q *Query, start sqlToken) error {
+	var include, exclude []string
+	switch {
+	case p.symbol("="):
+		t := p.take()
+		if t.kind != sqlString {
+			return p.errorf(t, "expected a type, found %s", t.describe())
+		}
+		include = []string{t.text}
+	case p.symbol("!="), p.symbol("<>"):
+		t := p.take()
+		if t.kind != sqlString {
+			return p.errorf(t, "expected a type, found %s", t.describe())
+		}
+		exclude = []string{t.text}
+	case p.keyword("LIKE"):
+		t := p.take()
+		if t.kind != sqlString {
+			return p.errorf(t, "expected a pattern after LIKE, found %s", t.describe())
+		}
+		if len(q.Types) > 0 || q.TypePattern != "" {
+			return p.errorf(start, "type can only be required once")
+		}
+		q.TypePattern = likePattern(t.text)
+		return nil
+	default:
+		not := p.keyword("NOT")
+		if err := p.expect("IN"); err != nil {
+			return err
+		}
+		types, err := p.parseStringList()
+		if err != nil {
+			return err
+		}
+		if not {
+			exclude = types
+		} else {
+			include = types
+		}
+	}
+
+	if include != nil && (len(q.Types) > 0 || q.TypePattern != "") {
+		// A second list would be read as alternatives, not as both
+		return p.errorf(start, "type can only be required once; use type IN (...)")
+	}
+	q.Types = append(q.Types, include...)
+	q.ExcludeTypes = append(q.ExcludeTypes, exclude...)
+	return nil
+}
+
+// parseStringList parses a parenthesized list of strings.
+func (p *sqlParser) parseStringList() ([]string, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var list []string
+	for {
+		t := p.take()
+		if t.kind != sqlString {
+			return nil, p.errorf(t, "expected a string, found %s", t.describe())
+		}
+		list = append(list, t.text)
+		if !p.symbol(",") {
+			break
+		}
+	}
+	return list, p.expect(")")
+}
+
+// parseValue parses a string, number or boolean.
+func (p *sqlParser) parseValue() (any, error) {
+	t := p.take()
+	switch t.kind {
+	case sqlString:
+		return t.text, nil
+	case sqlWord:
+		if strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false") {
+			return strings.EqualFold(t.text, "true"), nil
+		}
+		if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return n, nil
+		}
+	}
+	return nil, p.errorf(t, "expected a string, number or boolean, found %s", t.describe())
+}
+
+// parseCount parses the non-negative number after a LIMIT or OFFSET.
+func (p *sqlParser) parseCount(clause string) (int, error) {
+	t := p.take()
+	n, err := strconv.Atoi(t.text)
+	if t.kind != sqlWord || err != nil || n < 0 {
+		return 0, p.errorf(t, "expected a number after %s, found %s", clause, t.describe())
+	}
+	return n, nil
+}
+
+// sqlOps maps comparison symbols to predicate operators.
+var sqlOps = map[string]Op{
+	"=":  EQ,
+	"!=": NE,
+	"<>": NE,
+	"<":  LT,
+	"<=": LTE,
+	">":  GT,
+	">=": GTE,
+}
+
+// likePattern converts a LIKE pattern, with % for any run of characters
+// and _ for one character, to a TypePattern.
+func likePattern(like string) string {
+	var b strings.Builder
+	b.WriteByte('^')
+	for _, r := range like {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteByte('.')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteByte('$')
+	return b.String()
+}
+
+// parseSQLDuration parses a duration as time.ParseDuration does, or a
+// whole number of days or weeks such as 7d or 2w.
+func parseSQLDuration(s string) (time.Duration, error) {
+	if n, unit := strings.TrimRight(s, "dw"), s[len(strings.TrimRight(s, "dw")):]; len(unit) == 1 {
+		days, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, err
+		}
+		if unit == "w" {
+			days *= 7
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
@@ -0,0 +1,171 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSQL(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		sql  string
+		want SQLStatement
+	}{
+		{
+			sql: "SELECT * WHERE type='request' AND tags.service='api' AND data.status>=500 SINCE 1h LIMIT 100",
+			want: SQLStatement{
+				Query: Query{
+					Types: []string{"request"},
+					Tags:  map[string]string{"service": "api"},
+					Where: []Predicate{{Field: "status", Op: GTE, Value: 500.0}},
+					Limit: 100,
+				},
+				Since: time.Hour,
+			},
+		},
+		{
+			sql: "select count(*) from events where type in ('a', 'b') and tags.env <> 'test' since 7d",
+			want: SQLStatement{
+				Query: Query{
+					Types:       []string{"a", "b"},
+					ExcludeTags: map[string]string{"env": "test"},
+				},
+				Since:        7 * 24 * time.Hour,
+				Aggregations: []AggregationType{Count},
+			},
+		},
+		{
+			sql: `SELECT avg(latency), P99(data.latency) FROM events WHERE type NOT IN ('debug') AND type LIKE 'pay_ment.%' AND tags."app.kubernetes.io/name" = 'it''s' AND data.cached = false AND error IS NOT NULL AND data.user IS NULL`,
+			want: SQLStatement{
+				Query: Query{
+					ExcludeTypes:  []string{"debug"},
+					TypePattern:   `^pay.ment\..*$`,
+					Tags:          map[string]string{"app.kubernetes.io/name": "it's"},
+					Where:         []Predicate{{Field: "cached", Op: EQ, Value: false}},
+					HasFields:     []string{"error"},
+					MissingFields: []string{"user"},
+				},
+				Field:        "latency",
+				Aggregations: []AggregationType{Avg, P99},
+			},
+		},
+		{
+			sql: "SELECT COUNT(*) WHERE time >= '2024-01-01T00:00:00Z' AND type != 'debug' AND data.ms < -1.5e3 ORDER BY time DESC LIMIT 5 OFFSET 10",
+			want: SQLStatement{
+				Query: Query{
+					Start:        &start,
+					ExcludeTypes: []string{"debug"},
+					Where:        []Predicate{{Field: "ms", Op: LT, Value: -1500.0}},
+					Descending:   true,
+					Limit:        5,
+					Offset:       10,
+				},
+				Aggregations: []AggregationType{Count},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseSQL(tt.sql)
+		if err != nil {
+			t.Errorf("%s: %v", tt.sql, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("%s:\nexpected %+v\ngot      %+v", tt.sql, tt.want, *got)
+		}
+	}
+
+	errs := []struct {
+		sql    string
+		offset int
+	}{
+		{"", 0},
+		{"SELECT", 6},
+		{"SELECT count(*), FROM events", 17},
+		{"SELECT sum(*)", 11},
+		{"SELECT avg(data.a), max(data.b)", 24},
+		{"SELECT count(*), avg(data.a)", 17},
+		{"SELECT * WHERE type = 'a' AND type = 'b'", 30},
+		{"SELECT * WHERE tags.env > 'a'", 24},
+		{"SELECT * WHERE time > 'yesterday'", 22},
+		{"SELECT * WHERE data.a = 'unterminated", 24},
+		{"SELECT * WHERE data.a ! 1", 22},
+		{"SELECT * SINCE forever", 15},
+		{"SELECT * ORDER BY data.ms", 18},
+		{"SELECT * LIMIT -1", 15},
+		{"SELECT * LIMIT 10 garbage", 18},
+	}
+	for _, tt := range errs {
+		_, err := ParseSQL(tt.sql)
+		var sqlErr *SQLError
+		if !errors.As(err, &sqlErr) || !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%q: expected a SQLError, got %v", tt.sql, err)
+			continue
+		}
+		if sqlErr.Offset != tt.offset {
+			t.Errorf("%q: expected offset %d, got %v", tt.sql, tt.offset, err)
+		}
+	}
+}
+
+func TestQuerySQL(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		_, err := db.Append(Event{
+			Timestamp: now.Add(-time.Duration(i) * 10 * time.Minute),
+			Type:      "request",
+			Tags:      map[string]string{"service": "api"},
+			Data:      map[string]any{"status": float64(200 + 100*(i%4)), "latency": float64(i)},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	// Events 2, 3 and 6 are errors, and 6 is just over an hour old
+	result, err := db.QuerySQL(ctx, "SELECT * WHERE type='request' AND tags.service='api' AND data.status>=400 SINCE 1h ORDER BY time DESC LIMIT 10")
+	if err != nil {
+		t.Fatalf("QuerySQL failed: %v", err)
+	}
+	if len(result.Events) != 2 || result.Events[0].Data["latency"] != 2.0 || result.Events[1].Data["latency"] != 3.0 {
+		t.Errorf("unexpected events %v", result.Events)
+	}
+
+	result, err = db.QuerySQL(ctx, "SELECT count(*) WHERE data.status >= 400 SINCE 1h")
+	if err != nil {
+		t.Fatalf("QuerySQL failed: %v", err)
+	}
+	if result.Aggregate == nil || result.Aggregate.Count != 2 {
+		t.Errorf("expected a count of 2, got %+v", result.Aggregate)
+	}
+
+	result, err = db.QuerySQL(ctx, "SELECT count(latency), max(latency) WHERE type = 'request'")
+	if err != nil {
+		t.Fatalf("QuerySQL failed: %v", err)
+	}
+	if result.Aggregate == nil || result.Aggregate.Count != 10 || result.Aggregate.Max != 9 {
+		t.Errorf("unexpected aggregate %+v", result.Aggregate)
+	}
+
+	if _, err := db.QuerySQL(ctx, "SELECT nothing"); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
@@ -1,9 +1,10 @@
 package squid
 
 import (
-	"encoding/json"
 	"fmt"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
@@ -12,27 +13,190 @@ import (
 
 // DB is the main database handle for Squid.
 type DB struct {
-	badger    *badger.DB
-	ulids     *ulidSource
-	retention *retentionState
-	closed    bool
-	mu        sync.RWMutex
+	badger      *badger.DB
+	opts        Options
+	ulids       *ulidSource
+	retention   *retentionManager
+	legalHolds  legalHoldSet
+	cardinality *cardinalityTracker
+	stalls      *stallMonitor
+	watchdog    *watchdogState
+	files       *fileMonitor
+	batcher     *appendBatcher
+	drift       driftCounters
+	subs        *subscriptionHub
+	aggCache    *aggregateCache
+	counts      *countTracker
+	ingest      ingestClock
+	mirror      *mirrorState
+	reencrypt   *reencryptor
+	gc          *valueLogGC
+	corrupt     atomic.Int64
+	assignedIDs bool // event IDs are set by a Striped handle
+	dangling    atomic.Int64
+	closed      bool
+	mu          sync.RWMutex
 }
 
 // Open creates or opens a Squid database at the given path.
 func Open(path string) (*DB, error) {
-	opts := badger.DefaultOptions(path)
-	opts.Logger = nil // Disable BadgerDB's default logging
+	return OpenWithOptions(path, Options{})
+}
+
+// OpenWithOptions creates or opens a Squid database at the given path
+// with the given options.
+func OpenWithOptions(path string, opts Options) (*DB, error) {
+	if err := checkDerivedStreams(opts.DerivedStreams); err != nil {
+		return nil, err
+	}
+	if err := checkEncryption(opts.Encryption); err != nil {
+		return nil, err
+	}
+
+	bopts := badger.DefaultOptions(path)
+	bopts.Logger = nil // Disable BadgerDB's default logging
+	if opts.Logger != nil {
+		bopts.Logger = opts.Logger
+	}
+	if err := applyProfile(opts, &bopts); err != nil {
+		return nil, err
+	}
+	applyFileLimits(opts, &bopts)
+	if e := opts.Encryption; e != nil {
+		bopts.EncryptionKey = e.Key
+		bopts.EncryptionKeyRotationDuration = e.dataKeyRotation()
+		if bopts.IndexCacheSize == 0 {
+			bopts.IndexCacheSize = encryptionIndexCacheSize
+		}
+	}
+
+	bdb, err := badger.Open(bopts)
+	if err != nil {
+		return nil, encryptionError(err)
+	}
+
+	db := &DB{
+		badger:      bdb,
+		opts:        opts,
+		ulids:       newULIDSourceFrom(cryptoOf(opts).Rand()),
+		cardinality: newCardinalityTracker(opts),
+		stalls:      newStallMonitor(opts, bopts.NumLevelZeroTablesStall),
+		watchdog:    newWatchdog(opts, path),
+		files:       newFileMonitor(opts),
+		batcher:     newAppendBatcher(opts),
+		retention:   newRetentionManager(),
+		subs:        newSubscriptionHub(),
+		aggCache:    newAggregateCache(opts),
+		counts:      newCountTracker(),
+		reencrypt:   newReencryptor(opts),
+		gc:          newValueLogGC(opts),
+	}
 
-	bdb, err := badger.Open(opts)
+	db.mirror, err = newMirror(opts, db.now)
 	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	// Upgrade stores written with an older key layout
+	if err := db.migrateKeys(); err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	if err := db.seedULIDs(); err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	if err := db.counts.load(bdb); err != nil {
+		bdb.Close()
+		return nil, err
+	}
+
+	if err := db.legalHolds.load(bdb); err != nil {
+		bdb.Close()
 		return nil, err
 	}
 
-	return &DB{
-		badger: bdb,
-		ulids:  newULIDSource(),
-	}, nil
+	if db.cardinality != nil {
+		if err := db.cardinality.load(bdb); err != nil {
+			bdb.Close()
+			return nil, err
+		}
+	}
+
+	if db.reencrypt != nil {
+		if err := db.reencrypt.load(bdb); err != nil {
+			bdb.Close()
+			return nil, err
+		}
+	}
+
+	if db.stalls != nil {
+		db.stalls.start(bdb)
+	}
+
+	if db.watchdog != nil {
+		db.watchdog.start(db)
+	}
+
+	if db.files != nil {
+		db.files.start(bdb, opts.Logger)
+	}
+
+	if db.batcher != nil {
+		db.batcher.start(db)
+	}
+
+	db.retention.start(db)
+	if opts.CountRetention > 0 {
+		db.counts.prune = db.pruneCounts
+	}
+	db.counts.start(bdb, opts.Logger)
+
+	if db.reencrypt != nil {
+		db.reencrypt.start(db)
+	}
+
+	if db.gc != nil {
+		db.gc.start(bdb, opts.Logger)
+	}
+
+	return db, nil
+}
+
+// seedULIDs seeds the ULID source with the highest stored event ID.
+func (db *DB) seedULIDs() error {
+	return db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Reverse = true
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		prefix := eventKeyPrefix()
+		it.Seek(prefixEnd(prefix))
+		if !it.ValidForPrefix(prefix) {
+			return nil
+		}
+
+		id, err := decodeEventKey(it.Item().Key())
+		if err != nil {
+			return nil
+		}
+		db.ulids.seed(id)
+		return nil
+	})
+}
+
+// now returns the current time according to the configured clock.
+func (db *DB) now() time.Time {
+	if db.opts.Now != nil {
+		return db.opts.Now()
+	}
+	return time.Now()
 }
 
 // Close closes the database.
@@ -44,20 +208,80 @@ func (db *DB) Close() error {
 		return ErrClosed
 	}
 
-	// Stop retention goroutine if running
-	if db.retention != nil && db.retention.isRunning() {
-		db.retention.cancel()
-		<-db.retention.done
+	// Stop retention goroutine
+	db.retention.stop()
+
+	if db.batcher != nil {
+		db.batcher.stop()
+	}
+
+	if db.stalls != nil {
+		db.stalls.stop()
+	}
+
+	if db.watchdog != nil {
+		db.watchdog.stop()
+	}
+
+	if db.files != nil {
+		db.files.stop()
+	}
+
+	if db.reencrypt != nil {
+		db.reencrypt.stop()
+	}
+
+	if db.gc != nil {
+		db.gc.stop()
+	}
+
+	db.subs.closeAll(ErrClosed)
+
+	countsErr := db.counts.stop(db.badger)
+
+	var mirrorErr error
+	if db.mirror != nil {
+		mirrorErr = db.mirror.close()
 	}
 
 	db.closed = true
 
-	return db.badger.Close()
+	if err := db.badger.Close(); err != nil {
+		return err
+	}
+	if countsErr != nil {
+		return countsErr
+	}
+	return mirrorErr
+}
+
+// AppendResult describes a stored event and the cost of writing it.
+// It embeds the stored event, so its fields can be accessed directly.
+type AppendResult struct {
+	*Event
+
+	// Bytes is the number of key and value bytes written for the event and its indices.
+	Bytes int `json:"bytes"`
+
+	// IndexEntries is the number of index entries created for the event.
+	IndexEntries int `json:"index_entries"`
+
+	// TimestampDefaulted reports whether the timestamp was set by the database
+	// because the event did not carry one.
+	TimestampDefaulted bool `json:"timestamp_defaulted"`
+
+	// TimestampClamped reports whether the timestamp was set to the current
+	// time because it exceeded Options.MaxFutureDrift.
+	TimestampClamped bool `json:"timestamp_clamped,omitempty"`
+
+	// Coerced lists the changes made to the event by the Lenient ingest mode.
+	Coerced []string `json:"coerced,omitempty"`
 }
 
 // Append adds a new event to the database.
 // The event's ID and Timestamp are set automatically if not provided.
-func (db *DB) Append(event Event) (*Event, error) {
+func (db *DB) Append(event Event) (*AppendResult, error) {
+	start := time.Now()
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
@@ -65,55 +289,119 @@ func (db *DB) Append(event Event) (*Event, error) {
 	}
 	db.mu.RUnlock()
 
-	if err := event.validate(); err != nil {
+	if db.watchdog != nil && db.watchdog.rejectWrites() {
+		return nil, ErrDiskFull
+	}
+	if db.files != nil && db.files.rejectWrites() {
+		return nil, ErrTooManyFiles
+	}
+
+	given := event
+	coerced, err := db.checkIngest(&event, nil)
+	if err != nil {
+		db.recordIngestErrors(rejectedIngest(given, err))
+		return nil, err
+	}
+
+	clamped, err := db.checkFutureDrift(&event, db.now())
+	if err != nil {
+		db.recordIngestErrors(rejectedIngest(given, err))
 		return nil, err
 	}
 
+	tags := event.Tags
+	var admitted admission
+	if db.cardinality != nil {
+		if admitted, err = db.cardinality.admit(&event); err != nil {
+			db.recordIngestErrors(rejectedIngest(given, err))
+			return nil, err
+		}
+	}
+	var stored bool
+	defer func() { db.cardinality.settle(admitted, stored) }()
+
+	result := &AppendResult{Event: &event, TimestampClamped: clamped, Coerced: coerced}
+
 	// Set timestamp if not provided
+	now, done := db.ingest.begin(db.now)
+	defer done()
 	if event.Timestamp.IsZero() {
-		event.Timestamp = time.Now()
+		event.Timestamp = now
+		result.TimestampDefaulted = true
 	}
+	event.IngestedAt = now
 
-	// Generate ULID based on timestamp
-	event.ID = db.ulids.New(event.Timestamp)
+	// Generate ID based on timestamp
+	id, err := db.newID(&event)
+	if err != nil {
+		return nil, err
+	}
+	event.ID = id
+	event.Version = 1
 
 	// Serialize event to JSON
-	data, err := json.Marshal(event)
+	meta, data, err := db.encodeEvent(&event)
 	if err != nil {
 		return nil, err
 	}
 
-	// Write event and indices in a single transaction
-	err = db.badger.Update(func(txn *badger.Txn) error {
-		// Write primary event
-		if err := txn.Set(encodeEventKey(event.ID), data); err != nil {
-			return fmt.Errorf("failed to write event %s: %w", event.ID, err)
-		}
+	w := &pendingAppend{event: &event, meta: meta, data: data, result: result, start: start}
+	if db.batcher != nil {
+		err = db.batcher.append(w)
+	} else {
+		err = db.writeAppends([]*pendingAppend{w})
+	}
+	if err != nil {
+		return nil, supersedeConflict(err, []Event{event})
+	}
+	stored = true
 
-		// Write type index
-		if err := txn.Set(encodeTypeIndexKey(event.Type, event.ID), nil); err != nil {
-			return fmt.Errorf("failed to write type index %s: %w", event.Type, err)
-		}
+	db.appended(append([]Event{event}, w.derived...), nil)
 
-		// Write tag indices
-		for k, v := range event.Tags {
-			if err := txn.Set(encodeTagIndexKey(k, v, event.ID), nil); err != nil {
-				return fmt.Errorf("failed to write tag index key=%s: %w", k, err)
-			}
-		}
+	if changes := ingestChanges(given, tags, result); len(changes) > 0 {
+		db.recordIngestErrors([]ingestIssue{{event: event, changes: changes}})
+	}
 
-		return nil
-	})
+	return result, nil
+}
 
-	if err != nil {
-		return nil, err
-	}
+// writeAppends writes events prepared by Append, with their indices and
+// derived events, in a single transaction.
+func (db *DB) writeAppends(batch []*pendingAppend) error {
+	return db.badger.Update(func(txn *badger.Txn) error {
+		deltas := make(countDeltas)
+		for _, w := range batch {
+			if err := db.checkDuplicateID(txn, w.event.ID); err != nil {
+				return err
+			}
+			if err := db.checkSupersedes(txn, w.event); err != nil {
+				return err
+			}
 
-	return &event, nil
+			var err error
+			w.result.Bytes, w.result.IndexEntries, err = db.writeEvent(txn, w.event, w.meta, w.data)
+			if err != nil {
+				return err
+			}
+
+			deltas.add(w.event.Type, w.event.ID, 1)
+			if w.derived, err = db.writeDerived(txn, w.event, nil, deltas); err != nil {
+				return err
+			}
+		}
+		return db.counts.write(txn, deltas)
+	})
 }
 
 // AppendBatch adds multiple events to the database atomically.
-func (db *DB) AppendBatch(events []Event) ([]*Event, error) {
+func (db *DB) AppendBatch(events []Event) ([]*AppendResult, error) {
+	return db.appendBatch(events, nil, nil)
+}
+
+// appendBatch adds events atomically. If raw is not nil, raw[i] holds the
+// parts of events[i] left encoded by AppendJSONBatch. meta holds application
+// metadata written in the same transaction, as by AppendBatchWithMeta.
+func (db *DB) appendBatch(events []Event, raw []rawParts, meta map[string][]byte) ([]*AppendResult, error) {
 	db.mu.RLock()
 	if db.closed {
 		db.mu.RUnlock()
@@ -121,65 +409,301 @@ func (db *DB) AppendBatch(events []Event) ([]*Event, error) {
 	}
 	db.mu.RUnlock()
 
-	if len(events) == 0 {
+	if len(events) == 0 && len(meta) == 0 {
 		return nil, nil
 	}
+	if err := checkMetaKeys(meta); err != nil {
+		return nil, err
+	}
 
-	results := make([]*Event, len(events))
-	now := time.Now()
+	if db.watchdog != nil && db.watchdog.rejectWrites() {
+		return nil, ErrDiskFull
+	}
+	if db.files != nil && db.files.rejectWrites() {
+		return nil, ErrTooManyFiles
+	}
+
+	results := make([]*AppendResult, len(events))
+	now, done := db.ingest.begin(db.now)
+	defer done()
 
 	// Validate all events first
+	given := slices.Clone(events)
+	clamped := make([]bool, len(events))
+	coerced := make([][]string, len(events))
 	for i := range events {
-		if err := events[i].validate(); err != nil {
+		var parts *rawParts
+		if raw != nil {
+			parts = &raw[i]
+		}
+		var err error
+		if coerced[i], err = db.checkIngest(&events[i], parts); err != nil {
+			db.recordIngestErrors(rejectedIngest(given[i], err))
+			return nil, err
+		}
+
+		clamped[i], err = db.checkFutureDrift(&events[i], now)
+		if err != nil {
+			db.recordIngestErrors(rejectedIngest(given[i], err))
 			return nil, err
 		}
 	}
 
+	tags := make([]map[string]string, len(events))
+	var admitted admission
+	if db.cardinality != nil {
+		for i := range events {
+			tags[i] = events[i].Tags
+			a, err := db.cardinality.admit(&events[i])
+			if err != nil {
+				db.cardinality.settle(admitted, false)
+				db.recordIngestErrors(rejectedIngest(given[i], err))
+				return nil, err
+			}
+			admitted = append(admitted, a...)
+		}
+	}
+
+	var derived []Event
 	err := db.badger.Update(func(txn *badger.Txn) error {
+		derived = derived[:0]
+		deltas := make(countDeltas)
 		for i := range events {
 			event := &events[i]
+			result := &AppendResult{Event: event, TimestampClamped: clamped[i], Coerced: coerced[i]}
 
 			// Set timestamp if not provided
 			if event.Timestamp.IsZero() {
 				event.Timestamp = now
+				result.TimestampDefaulted = true
 			}
+			event.IngestedAt = now
 
-			// Generate ULID
-			event.ID = db.ulids.New(event.Timestamp)
+			// Generate ID
+			id, err := db.newID(event)
+			if err != nil {
+				return err
+			}
+			if err := db.checkDuplicateID(txn, id); err != nil {
+				return err
+			}
+			if err := db.checkSupersedes(txn, event); err != nil {
+				return err
+			}
+			event.ID = id
+			event.Version = 1
 
 			// Serialize
-			data, err := json.Marshal(event)
+			meta, data, err := db.encodeEvent(event)
 			if err != nil {
 				return err
 			}
+			if raw != nil && raw[i].data != nil {
+				data = raw[i].data
+			}
 
-			// Write primary event
-			if err := txn.Set(encodeEventKey(event.ID), data); err != nil {
-				return fmt.Errorf("failed to write event %s: %w", event.ID, err)
+			result.Bytes, result.IndexEntries, err = db.writeEvent(txn, event, meta, data)
+			if err != nil {
+				return err
 			}
 
-			// Write type index
-			if err := txn.Set(encodeTypeIndexKey(event.Type, event.ID), nil); err != nil {
-				return fmt.Errorf("failed to write type index %s: %w", event.Type, err)
+			deltas.add(event.Type, event.ID, 1)
+			results[i] = result
+
+			var rawData []byte
+			if raw != nil {
+				rawData = raw[i].data
+			}
+			d, err := db.writeDerived(txn, event, rawData, deltas)
+			if err != nil {
+				return err
 			}
+			derived = append(derived, d...)
+		}
+		if err := writeMeta(txn, meta); err != nil {
+			return err
+		}
+		return db.counts.write(txn, deltas)
+	})
+	db.cardinality.settle(admitted, err == nil)
 
-			// Write tag indices
-			for k, v := range event.Tags {
-				if err := txn.Set(encodeTagIndexKey(k, v, event.ID), nil); err != nil {
-					return fmt.Errorf("failed to write tag index key=%s: %w", k, err)
-				}
+	if err != nil {
+		return nil, supersedeConflict(err, events)
+	}
+
+	db.appended(events, raw)
+	db.appended(derived, nil)
+
+	if db.opts.RecordIngestErrors {
+		var issues []ingestIssue
+		for i, result := range results {
+			if changes := ingestChanges(given[i], tags[i], result); len(changes) > 0 {
+				issues = append(issues, ingestIssue{event: events[i], changes: changes})
 			}
+		}
+		db.recordIngestErrors(issues)
+	}
+
+	return results, nil
+}
+
+// appended passes newly appended events to the aggregate cache, the mirror
+// and subscribers. raw holds the encoded data of events appended as JSON,
+// which is only decoded if the events are mirrored or published.
+func (db *DB) appended(events []Event, raw []rawParts) {
+	if len(events) == 0 {
+		return
+	}
+	db.invalidateEvents(events)
+
+	if db.mirror == nil && !db.subs.active() {
+		return
+	}
+	if raw != nil {
+		events = db.withRawData(events, raw)
+	}
+
+	if db.mirror != nil {
+		db.mirror.write(events)
+	}
+
+	if db.subs.active() {
+		published := make([]*Event, len(events))
+		for i := range events {
+			event := events[i]
+			published[i] = &event
+		}
+		db.subs.publish(published)
+	}
+}
+
+// newID generates the ID of an event about to be appended.
+// IDs from a custom IDSource or a Striped handle must encode the event's timestamp.
+func (db *DB) newID(event *Event) (ulid.ULID, error) {
+	if db.opts.IDSource == nil && !db.assignedIDs {
+		return db.ulids.New(event.Timestamp), nil
+	}
 
-			results[i] = event
+	// Stripes store the IDs assigned by their Striped handle
+	id := event.ID
+	if !db.assignedIDs {
+		var err error
+		if id, err = db.opts.IDSource.NewID(event); err != nil {
+			return ulid.ULID{}, err
 		}
+	}
+	if id.Time() != ulid.Timestamp(event.Timestamp) {
+		return ulid.ULID{}, fmt.Errorf("%w: %s for %s", ErrInvalidID, id, event.Timestamp.Format(time.RFC3339Nano))
+	}
+	return id, nil
+}
+
+// checkDuplicateID rejects IDs from a custom IDSource that are already stored.
+// Generated ULIDs are unique, so they are not checked.
+func (db *DB) checkDuplicateID(txn *badger.Txn, id ulid.ULID) error {
+	if db.opts.IDSource == nil {
 		return nil
-	})
+	}
+
+	_, err := txn.Get(encodeEventKey(id))
+	if err == nil {
+		return fmt.Errorf("%w: %s", ErrDuplicateID, id)
+	}
+	if err != badger.ErrKeyNotFound {
+		return err
+	}
+	return nil
+}
 
+// encodeEvent serializes an event into its primary record, which holds
+// everything but the data, and its data payload, which is nil if the event
+// has no data.
+func (db *DB) encodeEvent(event *Event) ([]byte, []byte, error) {
+	withoutData := *event
+	withoutData.Data = nil
+	meta, err := db.codec().Marshal(withoutData)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return results, nil
+	if len(event.Data) == 0 {
+		return meta, nil, nil
+	}
+	data, err := db.codec().Marshal(event.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return meta, data, nil
+}
+
+// writeEvent writes the primary event record, its data payload and its type
+// and tag indices.
+// It returns the number of bytes written and the number of index entries created.
+func (db *DB) writeEvent(txn *badger.Txn, event *Event, meta, data []byte) (int, int, error) {
+	// Write primary event
+	key := encodeEventKey(event.ID)
+	if err := txn.Set(key, meta); err != nil {
+		return 0, 0, fmt.Errorf("failed to write event %s: %w", event.ID, err)
+	}
+	bytes := len(key) + len(meta)
+
+	// Write data payload
+	if data != nil {
+		n, err := db.writeData(txn, event.ID, data)
+		if err != nil {
+			return 0, 0, err
+		}
+		bytes += n
+	}
+
+	// Write type index
+	key = encodeTypeIndexKey(event.Type, event.ID)
+	if err := txn.Set(key, nil); err != nil {
+		return 0, 0, fmt.Errorf("failed to write type index %s: %w", event.Type, err)
+	}
+	bytes += len(key)
+
+	// Write tag indices
+	for k, v := range event.Tags {
+		key = encodeTagIndexKey(k, v, event.ID)
+		if err := txn.Set(key, nil); err != nil {
+			return 0, 0, fmt.Errorf("failed to write tag index key=%s: %w", k, err)
+		}
+		bytes += len(key)
+	}
+	entries := 1 + len(event.Tags)
+
+	// Write search index
+	if db.opts.SearchIndex {
+		n, b, err := db.writeSearchIndex(txn, event, data)
+		if err != nil {
+			return 0, 0, err
+		}
+		entries += n
+		bytes += b
+	}
+
+	// Write ingest time index
+	if db.opts.IndexIngestTime && !event.IngestedAt.IsZero() {
+		key = encodeIngestIndexKey(event.IngestedAt, event.ID)
+		if err := txn.Set(key, nil); err != nil {
+			return 0, 0, fmt.Errorf("failed to write ingest time index: %w", err)
+		}
+		bytes += len(key)
+		entries++
+	}
+
+	// Link the event a correction supersedes to it
+	if event.Supersedes != nil {
+		key = encodeCorrectionKey(*event.Supersedes)
+		if err := txn.Set(key, event.ID[:]); err != nil {
+			return 0, 0, fmt.Errorf("failed to write correction of %s: %w", *event.Supersedes, err)
+		}
+		bytes += len(key) + ulidLen
+		entries++
+	}
+
+	return bytes, entries, nil
 }
 
 // Get retrieves a single event by its ID.
@@ -194,17 +718,16 @@ func (db *DB) Get(id ulid.ULID) (*Event, error) {
 	var event Event
 
 	err := db.badger.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(encodeEventKey(id))
+		key := encodeEventKey(id)
+		item, err := txn.Get(key)
 		if err == badger.ErrKeyNotFound {
 			return ErrNotFound
 		}
 		if err != nil {
-			return err
+			return &QueryError{Stage: StageFetch, Key: key, Err: err}
 		}
 
-		return item.Value(func(val []byte) error {
-			return json.Unmarshal(val, &event)
-		})
+		return db.decodeEvent(txn, item, &event)
 	})
 
 	if err != nil {
@@ -213,3 +736,42 @@ func (db *DB) Get(id ulid.ULID) (*Event, error) {
 
 	return &event, nil
 }
+
+// GetBatch retrieves the events with the given IDs in a single read
+// transaction, which is much faster than calling Get for each. The events
+// are returned in the order of ids, with nil for the IDs not found, so
+// that a missing event is not an error.
+func (db *DB) GetBatch(ids []ulid.ULID) ([]*Event, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	events := make([]*Event, len(ids))
+
+	err := db.badger.View(func(txn *badger.Txn) error {
+		for i, f := range prefetch(txn, ids) {
+			if f.err == badger.ErrKeyNotFound {
+				continue
+			}
+			if f.err != nil {
+				return &QueryError{Stage: StageFetch, Key: encodeEventKey(ids[i]), Err: f.err}
+			}
+
+			var event Event
+			if err := db.decodeEvent(txn, f.item, &event); err != nil {
+				return err
+			}
+			events[i] = &event
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
@@ -1,9 +1,16 @@
 package squid
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/oklog/ulid/v2"
 )
 
 func TestOpenClose(t *testing.T) {
@@ -108,6 +115,115 @@ func TestAppendWithTimestamp(t *testing.T) {
 	}
 }
 
+func TestAppendResult(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	result, err := db.Append(Event{
+		Type: "request",
+		Tags: map[string]string{"service": "api", "env": "prod"},
+		Data: map[string]any{"status": 200},
+	})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	if !result.TimestampDefaulted {
+		t.Error("expected TimestampDefaulted to be true")
+	}
+	if result.IndexEntries != 3 {
+		t.Errorf("expected 3 index entries, got %d", result.IndexEntries)
+	}
+	if result.Bytes <= eventKeyLen {
+		t.Errorf("expected bytes written to include value and indices, got %d", result.Bytes)
+	}
+
+	results, err := db.AppendBatch([]Event{
+		{Type: "request", Timestamp: time.Now()},
+		{Type: "request"},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	if results[0].TimestampDefaulted || !results[1].TimestampDefaulted {
+		t.Error("TimestampDefaulted should only be set for events without a timestamp")
+	}
+	if results[0].IndexEntries != 1 {
+		t.Errorf("expected 1 index entry, got %d", results[0].IndexEntries)
+	}
+}
+
+func TestAppendCustomIDSource(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Keep the upstream sequence number in the ULID entropy
+	source := IDSourceFunc(func(e *Event) (ulid.ULID, error) {
+		var entropy [10]byte
+		seq, ok := e.Data["seq"].(int)
+		if !ok {
+			return ulid.ULID{}, errors.New("missing seq")
+		}
+		binary.BigEndian.PutUint64(entropy[2:], uint64(seq))
+		return ulid.New(ulid.Timestamp(e.Timestamp), bytes.NewReader(entropy[:]))
+	})
+
+	db, err := OpenWithOptions(dir, Options{IDSource: source})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	results, err := db.AppendBatch([]Event{
+		{Timestamp: ts, Type: "a", Data: map[string]any{"seq": 2}},
+		{Timestamp: ts, Type: "a", Data: map[string]any{"seq": 1}},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	// IDs sort by the upstream sequence within a millisecond
+	events, err := db.Query(context.Background(), Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[0].ID != results[1].ID {
+		t.Errorf("expected events ordered by sequence, got %v", events)
+	}
+
+	_, err = db.Append(Event{Timestamp: ts, Type: "a", Data: map[string]any{"seq": 1}})
+	if !errors.Is(err, ErrDuplicateID) {
+		t.Errorf("expected ErrDuplicateID, got %v", err)
+	}
+
+	_, err = db.Append(Event{Type: "a"})
+	if err == nil || err.Error() != "missing seq" {
+		t.Errorf("expected IDSource error, got %v", err)
+	}
+
+	// IDs must encode the event timestamp
+	db.opts.IDSource = IDSourceFunc(func(e *Event) (ulid.ULID, error) {
+		return ulid.Make(), nil
+	})
+	_, err = db.Append(Event{Timestamp: ts, Type: "a"})
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("expected ErrInvalidID, got %v", err)
+	}
+}
+
 func TestAppendEmptyType(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
@@ -127,6 +243,59 @@ func TestAppendEmptyType(t *testing.T) {
 	}
 }
 
+func TestAppendInvalidTags(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		event Event
+		field string
+		want  error
+	}{
+		{"empty tag key", Event{Type: "a", Tags: map[string]string{"": "v"}}, "tag key", ErrInvalidTag},
+		{"invalid utf8 type", Event{Type: "a\xff"}, "type", ErrInvalidTag},
+		{"invalid utf8 value", Event{Type: "a", Tags: map[string]string{"k": "\xfe"}}, "tag value", ErrInvalidTag},
+		{"long tag value", Event{Type: "a", Tags: map[string]string{"k": strings.Repeat("x", maxKeyComponentLen+1)}}, "tag value", ErrKeyTooLong},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := db.Append(tt.event)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("expected *ValidationError, got %T", err)
+			}
+			if verr.Field != tt.field {
+				t.Errorf("expected field %q, got %q", tt.field, verr.Field)
+			}
+		})
+	}
+
+	// Delimiter characters are valid
+	_, err = db.Append(Event{Type: "a:b", Tags: map[string]string{"k=1": "v:2"}})
+	if err != nil {
+		t.Errorf("Append with delimiters failed: %v", err)
+	}
+
+	count, _ := db.Count()
+	if count != 1 {
+		t.Errorf("expected 1 stored event, got %d", count)
+	}
+}
+
 func TestAppendBatch(t *testing.T) {
 	dir, err := os.MkdirTemp("", "squid-test-*")
 	if err != nil {
@@ -196,6 +365,58 @@ func TestGetNotFound(t *testing.T) {
 	}
 }
 
+func TestGetBatch(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	events := make([]Event, 20)
+	for i := range events {
+		events[i] = Event{Type: "request", Data: map[string]any{"n": float64(i)}}
+	}
+	results, err := db.AppendBatch(events)
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	// Missing IDs are nil, and the order of ids is kept
+	missing := db.ulids.Now()
+	ids := []ulid.ULID{results[19].ID, missing}
+	for _, r := range results[:18] {
+		ids = append(ids, r.ID)
+	}
+	got, err := db.GetBatch(ids)
+	if err != nil {
+		t.Fatalf("GetBatch failed: %v", err)
+	}
+	if len(got) != len(ids) {
+		t.Fatalf("expected %d results, got %d", len(ids), len(got))
+	}
+	if got[1] != nil {
+		t.Errorf("expected nil for a missing ID, got %v", got[1])
+	}
+	for i, e := range got {
+		if i == 1 {
+			continue
+		}
+		if e == nil || e.ID != ids[i] || e.Data["n"] == nil {
+			t.Errorf("result %d: unexpected event %v", i, e)
+		}
+	}
+
+	if got, err := db.GetBatch(nil); err != nil || len(got) != 0 {
+		t.Errorf("expected no events, got %v, %v", got, err)
+	}
+}
+
 func TestKeyEncoding(t *testing.T) {
 	source := newULIDSource()
 	id := source.Now()
@@ -229,4 +450,75 @@ func TestKeyEncoding(t *testing.T) {
 	if decodedType != id {
 		t.Errorf("type key roundtrip failed: got %s, want %s", decodedType, id)
 	}
+
+	// Delimiter characters must not leak into neighbouring components
+	tagKey = encodeTagIndexKey("url", "http://a=b:c", id)
+	k, v, decodedID, err := decodeTagIndexKey(tagKey)
+	if err != nil {
+		t.Fatalf("decodeTagIndexKey failed: %v", err)
+	}
+	if k != "url" || v != "http://a=b:c" || decodedID != id {
+		t.Errorf("tag key roundtrip failed: got %s=%s %s", k, v, decodedID)
+	}
+	if bytes.HasPrefix(tagKey, encodeTagIndexPrefix("url", "http://a")) {
+		t.Error("tag prefix for a shorter value must not match")
+	}
+
+	typeKey = encodeTypeIndexKey("a:b", id)
+	eventType, decodedID, err := decodeTypeIndexKey(typeKey)
+	if err != nil {
+		t.Fatalf("decodeTypeIndexKey failed: %v", err)
+	}
+	if eventType != "a:b" || decodedID != id {
+		t.Errorf("type key roundtrip failed: got %s %s", eventType, decodedID)
+	}
+}
+
+func TestULIDOrderAcrossRestart(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	var last ulid.ULID
+	for i := 0; i < 5; i++ {
+		db, err := Open(dir)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+
+		// Same millisecond as the events written before the restart
+		result, err := db.Append(Event{Timestamp: ts, Type: "event"})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+		if result.ID.Compare(last) <= 0 {
+			t.Errorf("restart %d: ID %s sorts before previous ID %s", i, result.ID, last)
+		}
+		last = result.ID
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+}
+
+func TestIncrementULID(t *testing.T) {
+	id := ulid.MustNew(1, bytes.NewReader(make([]byte, 10)))
+	id[15] = 0xff
+
+	next, ok := incrementULID(id)
+	if !ok || next.Compare(id) <= 0 || next.Time() != id.Time() || next[14] != 1 || next[15] != 0 {
+		t.Errorf("unexpected increment of %v: %v", id[:], next[:])
+	}
+
+	for i := 6; i < len(id); i++ {
+		id[i] = 0xff
+	}
+	if _, ok := incrementULID(id); ok {
+		t.Error("expected overflow")
+	}
 }
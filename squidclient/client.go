@@ -0,0 +1,401 @@
+// Package squidclient is a Go client for a Squid server (see squidserver).
+//
+// Client methods mirror the signatures of *squid.DB, so code written against
+// a small interface can switch between an embedded and a remote database
+// without rewrites. Errors returned by the server unwrap to Squid's sentinel
+// errors, so checks such as errors.Is(err, squid.ErrNotFound) keep working.
+package squidclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+	"github.com/asungur/squid/squidserver"
+	"github.com/oklog/ulid/v2"
+)
+
+// Client talks to a remote Squid server.
+type Client struct {
+	baseURL string
+	http    *http.Client
+}
+
+// New creates a client for the server at baseURL (e.g. "http://localhost:8080").
+// If hc is nil, http.DefaultClient is used.
+func New(baseURL string, hc *http.Client) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    hc,
+	}
+}
+
+// Error is returned when the server responds with an error.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+
+	// Code is the server's error code (one of the squidserver.Code* constants).
+	Code string
+
+	// Message is the server's error message.
+	Message string
+
+	// Limit names the server limit the request exceeded, if any (one of
+	// the squidserver.Limit* constants).
+	Limit string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Unwrap maps the server error code to the matching Squid sentinel error.
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case squidserver.CodeNotFound:
+		return squid.ErrNotFound
+	case squidserver.CodeEmptyType:
+		return squid.ErrEmptyType
+	case squidserver.CodeInvalidEvent:
+		return squid.ErrInvalidTag
+	case squidserver.CodeInvalidQuery, squidserver.CodeLimitExceeded:
+		return squid.ErrInvalidQuery
+	case squidserver.CodeCardinalityLimit:
+		return squid.ErrCardinalityLimit
+	case squidserver.CodeDiskFull:
+		return squid.ErrDiskFull
+	case squidserver.CodeTooManyFiles:
+		return squid.ErrTooManyFiles
+	case squidserver.CodeClosed:
+		return squid.ErrClosed
+	case squidserver.CodeDuplicateID:
+		return squid.ErrDuplicateID
+	case squidserver.CodeVersionConflict:
+		return squid.ErrVersionConflict
+	case squidserver.CodeSuperseded:
+		return squid.ErrSuperseded
+	case squidserver.CodeCorruptRecord:
+		return squid.ErrCorruptRecord
+	case squidserver.CodeOverflow:
+		return squid.ErrSubscriptionOverflow
+	case squidserver.CodeLegalHold:
+		return squid.ErrLegalHold
+	case squidserver.CodeTxConflict:
+		return squid.ErrTxConflict
+	default:
+		return nil
+	}
+}
+
+// Close releases idle connections. The remote database stays open.
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// Append adds a new event to the remote database.
+func (c *Client) Append(event squid.Event) (*squid.AppendResult, error) {
+	var result squid.AppendResult
+	if err := c.do(context.Background(), http.MethodPost, "/v1/events", event, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// AppendBatch adds multiple events to the remote database atomically.
+func (c *Client) AppendBatch(events []squid.Event) ([]*squid.AppendResult, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	var results []*squid.AppendResult
+	if err := c.do(context.Background(), http.MethodPost, "/v1/events/batch", events, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Get retrieves a single event by its ID.
+func (c *Client) Get(id ulid.ULID) (*squid.Event, error) {
+	var event squid.Event
+	if err := c.do(context.Background(), http.MethodGet, "/v1/events/"+id.String(), nil, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Latest retrieves the latest correction of an event, or the event itself
+// if no correction supersedes it.
+func (c *Client) Latest(id ulid.ULID) (*squid.Event, error) {
+	var event squid.Event
+	if err := c.do(context.Background(), http.MethodGet, "/v1/events/"+id.String()+"?latest=true", nil, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Update replaces the type, tags and data of a stored event.
+// event.Version must match the stored version.
+func (c *Client) Update(event squid.Event) (*squid.Event, error) {
+	var updated squid.Event
+	if err := c.do(context.Background(), http.MethodPut, "/v1/events/"+event.ID.String(), event, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// Query finds events matching the given criteria.
+func (c *Client) Query(ctx context.Context, q squid.Query) ([]*squid.Event, error) {
+	var events []*squid.Event
+	if err := c.do(ctx, http.MethodPost, "/v1/query", q, &events); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, nil
+	}
+	return events, nil
+}
+
+// Stream calls fn with each event matching the query, in order, reading
+// them page by page from GET /v1/events so that neither the server nor the
+// client holds the whole result. q.Limit bounds the number of events and
+// pageSize the events per request (0 uses the server's default). q.Hint is
+// ignored. Returning an error from fn stops the stream and returns it.
+func (c *Client) Stream(ctx context.Context, q squid.Query, pageSize int, fn func(*squid.Event) error) error {
+	next := "/v1/events?" + streamParams(q, pageSize).Encode()
+	seen := 0
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+next, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode >= 400 {
+			err := decodeError(resp)
+			resp.Body.Close()
+			return err
+		}
+
+		next = nextLink(resp.Header.Get("Link"))
+		dec := json.NewDecoder(resp.Body)
+		for dec.More() {
+			var event squid.Event
+			if err := dec.Decode(&event); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			if err := fn(&event); err != nil {
+				resp.Body.Close()
+				return err
+			}
+			if seen++; q.Limit > 0 && seen >= q.Limit {
+				resp.Body.Close()
+				return nil
+			}
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// streamParams encodes a query as the parameters of GET /v1/events.
+func streamParams(q squid.Query, pageSize int) url.Values {
+	params := url.Values{}
+	for _, t := range q.Types {
+		params.Add("type", t)
+	}
+	for k, v := range q.Tags {
+		params.Add("tag", k+":"+v)
+	}
+	if q.Start != nil {
+		params.Set("start", q.Start.Format(time.RFC3339Nano))
+	}
+	if q.End != nil {
+		params.Set("end", q.End.Format(time.RFC3339Nano))
+	}
+	if q.IngestedStart != nil {
+		params.Set("ingested_start", q.IngestedStart.Format(time.RFC3339Nano))
+	}
+	if q.IngestedEnd != nil {
+		params.Set("ingested_end", q.IngestedEnd.Format(time.RFC3339Nano))
+	}
+	if q.MinID != nil {
+		params.Set("min_id", q.MinID.String())
+	}
+	if q.MaxID != nil {
+		params.Set("max_id", q.MaxID.String())
+	}
+	if q.Descending {
+		params.Set("descending", "true")
+	}
+	if q.OmitData {
+		params.Set("omit_data", "true")
+	}
+	if q.Latest {
+		params.Set("latest", "true")
+	}
+	if pageSize > 0 {
+		if q.Limit > 0 && q.Limit < pageSize {
+			pageSize = q.Limit
+		}
+		params.Set("page_size", strconv.Itoa(pageSize))
+	}
+	return params
+}
+
+// nextLink returns the target of the rel="next" link in a Link header, or
+// "" if there is none.
+func nextLink(header string) string {
+	for _, link := range strings.Split(header, ",") {
+		target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+		if ok && strings.Contains(params, `rel="next"`) {
+			return strings.Trim(strings.TrimSpace(target), "<>")
+		}
+	}
+	return ""
+}
+
+// Aggregate computes aggregations over events matching the query.
+func (c *Client) Aggregate(ctx context.Context, q squid.Query, field string, aggs []squid.AggregationType) (*squid.AggregateResult, error) {
+	req := squidserver.AggregateRequest{
+		Query:        q,
+		Field:        field,
+		Aggregations: aggs,
+	}
+
+	var result squid.AggregateResult
+	if err := c.do(ctx, http.MethodPost, "/v1/aggregate", req, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Count returns the total number of events in the remote database.
+func (c *Client) Count() (int64, error) {
+	var resp squidserver.CountResponse
+	if err := c.do(context.Background(), http.MethodGet, "/v1/count", nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// Subscribe delivers events appended to the remote database from now on
+// that match opts.Query, in batches, until ctx is cancelled, Close is
+// called or the server ends the subscription. Batching and the overflow
+// policy apply on the server, so Dropped is always 0; a subscription that
+// overflows ends with an error that unwraps to squid.ErrSubscriptionOverflow.
+func (c *Client) Subscribe(ctx context.Context, opts squid.SubscribeOptions) (*squid.Subscription, error) {
+	data, err := json.Marshal(squidserver.NewSubscribeRequest(opts))
+	if err != nil {
+		return nil, err
+	}
+	// Cancelling the request ends the stream when the subscription ends
+	reqCtx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, c.baseURL+"/v1/subscribe", bytes.NewReader(data))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer cancel()
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	return squid.NewSubscription(ctx, func(ctx context.Context, send func([]*squid.Event) bool) error {
+		defer cancel()
+		defer resp.Body.Close()
+		stop := context.AfterFunc(ctx, cancel)
+		defer stop()
+
+		// Each line is a batch, or the error that ended the subscription
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var line json.RawMessage
+			if err := dec.Decode(&line); err != nil {
+				if errors.Is(err, io.EOF) {
+					return io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			if len(line) > 0 && line[0] == '{' {
+				var e squidserver.ErrorResponse
+				if err := json.Unmarshal(line, &e); err != nil {
+					return err
+				}
+				return &Error{StatusCode: resp.StatusCode, Code: e.Code, Message: e.Error}
+			}
+			var batch []*squid.Event
+			if err := json.Unmarshal(line, &batch); err != nil {
+				return err
+			}
+			if !send(batch) {
+				return nil
+			}
+		}
+	}), nil
+}
+
+// do sends a request with an optional JSON body and decodes the JSON response into out.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return decodeError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeError returns the error of a failed response.
+func decodeError(resp *http.Response) error {
+	var e squidserver.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		return &Error{StatusCode: resp.StatusCode, Message: fmt.Sprintf("squidclient: unexpected status %s", resp.Status)}
+	}
+	return &Error{StatusCode: resp.StatusCode, Code: e.Code, Message: e.Error, Limit: e.Limit}
+}
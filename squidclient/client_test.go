@@ -0,0 +1,228 @@
+package squidclient
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+	"github.com/asungur/squid/squidserver"
+	"github.com/oklog/ulid/v2"
+)
+
+// store is the subset of the embedded API that the client mirrors.
+type store interface {
+	Append(squid.Event) (*squid.AppendResult, error)
+	AppendBatch([]squid.Event) ([]*squid.AppendResult, error)
+	Get(ulid.ULID) (*squid.Event, error)
+	Latest(ulid.ULID) (*squid.Event, error)
+	Update(squid.Event) (*squid.Event, error)
+	Query(context.Context, squid.Query) ([]*squid.Event, error)
+	Aggregate(context.Context, squid.Query, string, []squid.AggregationType) (*squid.AggregateResult, error)
+	Count() (int64, error)
+	Subscribe(context.Context, squid.SubscribeOptions) (*squid.Subscription, error)
+	Close() error
+}
+
+var (
+	_ store = (*squid.DB)(nil)
+	_ store = (*Client)(nil)
+)
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	srv := httptest.NewServer(squidserver.New(db))
+	t.Cleanup(srv.Close)
+
+	return New(srv.URL, nil)
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	c := newTestClient(t)
+	ctx := context.Background()
+
+	result, err := c.Append(squid.Event{
+		Type: "request",
+		Tags: map[string]string{"service": "api"},
+		Data: map[string]any{"latency": 10.0},
+	})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if result.ID.String() == "" || result.IndexEntries != 2 || !result.TimestampDefaulted {
+		t.Errorf("unexpected append result: %+v", result)
+	}
+
+	_, err = c.AppendBatch([]squid.Event{
+		{Type: "request", Data: map[string]any{"latency": 20.0}},
+		{Type: "error"},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	got, err := c.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Tags["service"] != "api" {
+		t.Errorf("unexpected event: %+v", got)
+	}
+
+	got.Tags["service"] = "web"
+	updated, err := c.Update(*got)
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.Version != 2 || updated.Tags["service"] != "web" {
+		t.Errorf("unexpected updated event: %+v", updated)
+	}
+	if _, err := c.Update(*got); !errors.Is(err, squid.ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+
+	fix, err := c.Append(squid.Event{Type: "fix", Supersedes: &result.ID})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if latest, err := c.Latest(result.ID); err != nil || latest.ID != fix.ID {
+		t.Errorf("expected the correction, got %v, %v", latest, err)
+	}
+	if _, err := c.Append(squid.Event{Type: "fix", Supersedes: &result.ID}); !errors.Is(err, squid.ErrSuperseded) {
+		t.Errorf("expected ErrSuperseded, got %v", err)
+	}
+
+	events, err := c.Query(ctx, squid.Query{Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected 2 events, got %d", len(events))
+	}
+
+	agg, err := c.Aggregate(ctx, squid.Query{Types: []string{"request"}}, "latency", []squid.AggregationType{squid.Avg})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if agg.Avg != 15 {
+		t.Errorf("expected avg 15, got %v", agg.Avg)
+	}
+
+	count, err := c.Count()
+	if err != nil {
+		t.Fatalf("Count failed: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 events, got %d", count)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newTestClient(t)
+
+	_, err := c.Get(ulid.Make())
+	if !errors.Is(err, squid.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	_, err = c.Append(squid.Event{})
+	var cerr *Error
+	if !errors.As(err, &cerr) || cerr.StatusCode != 400 {
+		t.Errorf("expected 400 *Error, got %v", err)
+	}
+	if !errors.Is(err, squid.ErrEmptyType) {
+		t.Errorf("expected ErrEmptyType, got %v", err)
+	}
+}
+
+func TestClientSubscribe(t *testing.T) {
+	c := newTestClient(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := c.Subscribe(ctx, squid.SubscribeOptions{
+		Query:    squid.Query{Types: []string{"request"}},
+		Overflow: squid.DropOldest,
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	if _, err := c.AppendBatch([]squid.Event{{Type: "debug"}, {Type: "request", Data: map[string]any{"i": 1}}}); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	select {
+	case batch := <-sub.C:
+		if len(batch) != 1 || batch[0].Data["i"] != 1.0 {
+			t.Errorf("expected the request, got %v", batch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a batch")
+	}
+
+	cancel()
+	for range sub.C {
+	}
+	if !errors.Is(sub.Err(), context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", sub.Err())
+	}
+
+	_, err = c.Subscribe(context.Background(), squid.SubscribeOptions{Query: squid.Query{TypePattern: "("}})
+	if !errors.Is(err, squid.ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	c := newTestClient(t)
+
+	batch := make([]squid.Event, 12)
+	for i := range batch {
+		batch[i] = squid.Event{Type: "request", Data: map[string]any{"i": i}}
+	}
+	if _, err := c.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	var got []float64
+	err := c.Stream(context.Background(), squid.Query{Types: []string{"request"}}, 5, func(e *squid.Event) error {
+		got = append(got, e.Data["i"].(float64))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if len(got) != 12 || got[0] != 0 || got[11] != 11 {
+		t.Errorf("expected events 0 to 11 in order, got %v", got)
+	}
+
+	// Limit stops the stream early
+	n := 0
+	err = c.Stream(context.Background(), squid.Query{Limit: 7}, 5, func(e *squid.Event) error {
+		n++
+		return nil
+	})
+	if err != nil || n != 7 {
+		t.Errorf("expected 7 events, got %d, %v", n, err)
+	}
+
+	errStop := errors.New("stop")
+	if err := c.Stream(context.Background(), squid.Query{}, 0, func(e *squid.Event) error { return errStop }); err != errStop {
+		t.Errorf("expected the callback's error, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package squidclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/asungur/squid"
+	"github.com/asungur/squid/squidserver"
+	"github.com/oklog/ulid/v2"
+)
+
+// socketName is the name of the unix socket a shared database is served on,
+// inside its data directory.
+const socketName = "squid.sock"
+
+// DB is the part of the *squid.DB API that Client mirrors. It is
+// implemented by *squid.DB, *Client and *Shared.
+type DB interface {
+	Append(squid.Event) (*squid.AppendResult, error)
+	AppendBatch([]squid.Event) ([]*squid.AppendResult, error)
+	Get(ulid.ULID) (*squid.Event, error)
+	Latest(ulid.ULID) (*squid.Event, error)
+	Update(squid.Event) (*squid.Event, error)
+	Query(context.Context, squid.Query) ([]*squid.Event, error)
+	Aggregate(context.Context, squid.Query, string, []squid.AggregationType) (*squid.AggregateResult, error)
+	Count() (int64, error)
+	Subscribe(context.Context, squid.SubscribeOptions) (*squid.Subscription, error)
+	Close() error
+}
+
+// Shared is a database opened by Open and shared with other processes on
+// the same host. It embeds the *squid.DB, so the whole embedded API is
+// available to the process that owns it.
+type Shared struct {
+	*squid.DB
+
+	listener net.Listener
+	server   *http.Server
+	done     chan struct{}
+}
+
+// Open opens the database at path and shares it with other processes, or
+// connects to the process that already has it open.
+//
+// A BadgerDB directory can only be opened by one process at a time. The
+// first process to call Open opens the database, as squid.OpenWithOptions
+// does, and serves it on a unix socket in the directory until it is
+// closed; Open returns a *Shared. A later call from another process finds
+// the directory locked and returns a *Client connected to the socket
+// instead, so a command-line tool can read and write the database of a
+// running daemon. Sharing is opt-in: databases opened with squid.Open are
+// not served.
+//
+// opts only apply when the database is opened. Only processes that can
+// access the directory can connect.
+func Open(path string, opts squid.Options) (DB, error) {
+	db, err := squid.OpenWithOptions(path, opts)
+	if err != nil {
+		if c := dialShared(path); c != nil {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	shared, err := share(db, filepath.Join(path, socketName))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return shared, nil
+}
+
+// share serves db on a unix socket at socket.
+func share(db *squid.DB, socket string) (*Shared, error) {
+	// We hold the directory lock, so a socket left behind is stale
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := squidserver.New(db)
+	s := &Shared{
+		DB:       db,
+		listener: l,
+		server:   &http.Server{Handler: handler},
+		done:     make(chan struct{}),
+	}
+	// Shutdown would wait for subscriptions to end
+	s.server.RegisterOnShutdown(handler.EndSubscriptions)
+	go func() {
+		defer close(s.done)
+		s.server.Serve(l)
+	}()
+	return s, nil
+}
+
+// Close stops serving the database, waiting for requests in progress, and
+// closes it. Clients connected to it get errors from then on.
+func (s *Shared) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+	<-s.done
+
+	return s.DB.Close()
+}
+
+// dialShared returns a client connected to the process serving the
+// database at path, or nil if no process is serving it.
+func dialShared(path string) *Client {
+	socket := filepath.Join(path, socketName)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+
+	return NewUnix(socket)
+}
+
+// NewUnix creates a client for a server listening on the unix socket at
+// socket, such as a database shared by Open.
+func NewUnix(socket string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	return New("http://squid", &http.Client{Transport: transport})
+}
@@ -0,0 +1,100 @@
+package squidclient
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/asungur/squid"
+)
+
+var (
+	_ DB = (*squid.DB)(nil)
+	_ DB = (*Client)(nil)
+	_ DB = (*Shared)(nil)
+)
+
+func TestOpenShared(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A stale socket from a crashed owner is replaced
+	if err := os.WriteFile(filepath.Join(dir, socketName), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	owner, err := Open(dir, squid.Options{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, ok := owner.(*Shared); !ok {
+		t.Fatalf("expected the first Open to return *Shared, got %T", owner)
+	}
+	if _, err := owner.Append(squid.Event{Type: "deploy"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// The directory is locked, so a second Open connects to the owner
+	other, err := Open(dir, squid.Options{})
+	if err != nil {
+		t.Fatalf("second Open failed: %v", err)
+	}
+	if _, ok := other.(*Client); !ok {
+		t.Fatalf("expected the second Open to return *Client, got %T", other)
+	}
+	if _, err := other.Append(squid.Event{Type: "error"}); err != nil {
+		t.Fatalf("Append through the socket failed: %v", err)
+	}
+
+	events, err := owner.Query(context.Background(), squid.Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[1].Type != "error" {
+		t.Errorf("expected both events, got %+v", events)
+	}
+	if _, err := other.Get(events[0].ID); err != nil {
+		t.Errorf("Get through the socket failed: %v", err)
+	}
+
+	other.Close()
+	if err := owner.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := other.Count(); err == nil {
+		t.Error("expected an error after the owner closed")
+	}
+
+	// Once closed, the database can be opened again
+	reopened, err := Open(dir, squid.Options{})
+	if err != nil {
+		t.Fatalf("reopening failed: %v", err)
+	}
+	defer reopened.Close()
+	if n, err := reopened.Count(); err != nil || n != 2 {
+		t.Errorf("expected 2 events after reopening, got %d, %v", n, err)
+	}
+}
+
+func TestOpenNotShared(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// A database opened with squid.Open is not served
+	if _, err := Open(dir, squid.Options{}); err == nil {
+		t.Errorf("expected the lock error, got %v", err)
+	}
+}
@@ -0,0 +1,358 @@
+// Package squiddocker collects the logs of Docker containers as events, for
+// single-host container observability without a log pipeline.
+//
+// A Collector talks to the Docker Engine API on its unix socket. It follows
+// the logs of every running container, and of containers as they start.
+// Each line becomes an event typed "docker.stdout" or "docker.stderr",
+// tagged with the container's name, short ID and image, with the line as
+// the "message" data field, or its members if it is a JSON object and
+// Options.JSON is set. The timestamp of the last line appended from each
+// container is stored in the database with the events, so a restarted
+// Collector resumes after it:
+//
+//	c := squiddocker.New(db, squiddocker.Options{
+//		TagLabels: []string{"com.docker.compose.service"},
+//		JSON:      true,
+//	})
+//	err := c.Run(ctx) // until ctx is done
+//
+// Containers that log to journald instead can be collected with
+// squidjournal, with CONTAINER_NAME and IMAGE_NAME as tag fields.
+package squiddocker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// positionPrefix is the application metadata key prefix of the positions
+// in the logs of containers.
+const positionPrefix = "squiddocker/"
+
+// Options configures a Collector.
+type Options struct {
+	// Socket is the path of the Docker daemon's unix socket. Defaults to
+	// "/var/run/docker.sock".
+	Socket string
+
+	// Type prefixes the event types, which end with the stream of the
+	// line. Defaults to "docker".
+	Type string
+
+	// Labels limits the containers followed to those with all of the given
+	// labels, each "key" or "key=value", as docker ps --filter label= does.
+	Labels []string
+
+	// TagLabels names container labels stored as tags, such as
+	// "com.docker.compose.service".
+	TagLabels []string
+
+	// JSON stores the members of lines that are JSON objects as data
+	// fields, instead of the whole line as the message.
+	JSON bool
+
+	// BatchSize is the maximum number of lines appended per transaction.
+	// Defaults to 1000.
+	BatchSize int
+
+	// FlushInterval is how long lines wait for a batch to fill before they
+	// are appended. Defaults to 1 second.
+	FlushInterval time.Duration
+
+	// RetryDelay is the delay before the first retry of a failed request
+	// to the daemon, doubled for each further failure up to a minute.
+	// Defaults to 1 second.
+	RetryDelay time.Duration
+
+	// OnError is called when following the daemon or a container fails,
+	// or lines cannot be appended. They are retried.
+	OnError func(err error)
+}
+
+// Collector appends the logs of Docker containers to a database.
+type Collector struct {
+	db     *squid.DB
+	opts   Options
+	client *http.Client
+
+	mu        sync.Mutex
+	following map[string]bool      // IDs of the containers followed
+	positions map[string]time.Time // timestamps of the last lines queued
+	wg        sync.WaitGroup
+}
+
+// New creates a Collector for db.
+func New(db *squid.DB, opts Options) *Collector {
+	if opts.Socket == "" {
+		opts.Socket = "/var/run/docker.sock"
+	}
+	if opts.Type == "" {
+		opts.Type = "docker"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+
+	socket := opts.Socket
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+
+	return &Collector{
+		db:        db,
+		opts:      opts,
+		client:    &http.Client{Transport: transport},
+		following: make(map[string]bool),
+		positions: make(map[string]time.Time),
+	}
+}
+
+// queued is a line queued for appending, or the removal of the position of
+// a destroyed container.
+type queued struct {
+	id     string
+	ts     time.Time
+	event  squid.Event
+	remove bool
+}
+
+// Run follows the logs of containers until ctx is done, retrying failed
+// requests with a growing delay. It returns the error of ctx.
+func (c *Collector) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := make(chan queued, c.opts.BatchSize)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		c.write(lines)
+	}()
+
+	delay := c.opts.RetryDelay
+	for ctx.Err() == nil {
+		err := c.watch(ctx, lines, func() { delay = c.opts.RetryDelay })
+		if ctx.Err() != nil {
+			break
+		}
+		c.report(err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+		delay = min(2*delay, time.Minute)
+	}
+
+	// The lines queued by the followers are appended before returning
+	c.wg.Wait()
+	close(lines)
+	<-written
+	return ctx.Err()
+}
+
+// report passes err to Options.OnError.
+func (c *Collector) report(err error) {
+	if c.opts.OnError != nil {
+		c.opts.OnError(err)
+	}
+}
+
+// watch follows the running containers and those that start, until the
+// daemon's event stream ends. connected is called once the stream is open.
+func (c *Collector) watch(ctx context.Context, lines chan<- queued, connected func()) error {
+	filters := map[string][]string{
+		"type":  {"container"},
+		"event": {"start", "destroy"},
+	}
+	if len(c.opts.Labels) > 0 {
+		filters["label"] = c.opts.Labels
+	}
+	events, err := c.get(ctx, "/events", url.Values{"filters": {encodeFilters(filters)}})
+	if err != nil {
+		return err
+	}
+	defer events.Body.Close()
+	connected()
+
+	// Containers started before the stream was opened
+	var running []struct {
+		ID string `json:"Id"`
+	}
+	delete(filters, "type")
+	delete(filters, "event")
+	if err := c.getJSON(ctx, "/containers/json", url.Values{"filters": {encodeFilters(filters)}}, &running); err != nil {
+		return err
+	}
+	for _, r := range running {
+		c.follow(ctx, r.ID, lines)
+	}
+
+	dec := json.NewDecoder(events.Body)
+	for {
+		var msg struct {
+			Action string `json:"Action"`
+			Actor  struct {
+				ID string `json:"ID"`
+			} `json:"Actor"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return fmt.Errorf("squiddocker: reading events: %w", err)
+		}
+		switch msg.Action {
+		case "start":
+			c.follow(ctx, msg.Actor.ID, lines)
+		case "destroy":
+			c.mu.Lock()
+			delete(c.positions, msg.Actor.ID)
+			c.mu.Unlock()
+			select {
+			case lines <- queued{id: msg.Actor.ID, remove: true}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// follow starts following the logs of a container unless they are
+// followed already.
+func (c *Collector) follow(ctx context.Context, id string, lines chan<- queued) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.following[id] {
+		return
+	}
+	c.following[id] = true
+	c.wg.Add(1)
+
+	go func() {
+		defer c.wg.Done()
+		err := c.followLogs(ctx, id, lines)
+		c.mu.Lock()
+		delete(c.following, id)
+		c.mu.Unlock()
+		if err != nil && ctx.Err() == nil {
+			c.report(fmt.Errorf("squiddocker: container %.12s: %w", id, err))
+		}
+	}()
+}
+
+// write appends the queued lines in batches with the positions they reach,
+// until lines is closed. While a batch cannot be appended, it is retried
+// at each flush interval and no more lines are taken, which holds back the
+// followers.
+func (c *Collector) write(lines <-chan queued) {
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+
+	events := make([]squid.Event, 0, c.opts.BatchSize)
+	meta := make(map[string][]byte)
+	flush := func() error {
+		if len(meta) == 0 {
+			return nil
+		}
+		var err error
+		if len(events) == 0 {
+			err = c.db.UpdateMeta(meta)
+		} else {
+			_, err = c.db.AppendBatchWithMeta(events, meta)
+		}
+		if err != nil {
+			return fmt.Errorf("squiddocker: appending lines: %w", err)
+		}
+		events = events[:0]
+		clear(meta)
+		return nil
+	}
+
+	for {
+		in := lines
+		if len(events) >= c.opts.BatchSize {
+			in = nil // Full until flushed
+		}
+
+		select {
+		case q, ok := <-in:
+			if !ok {
+				if err := flush(); err != nil {
+					c.report(err)
+				}
+				return
+			}
+			if q.remove {
+				meta[positionPrefix+q.id] = nil
+				continue
+			}
+			events = append(events, q.event)
+			meta[positionPrefix+q.id] = []byte(q.ts.Format(time.RFC3339Nano))
+			if len(events) >= c.opts.BatchSize {
+				if err := flush(); err != nil {
+					c.report(err)
+				}
+			}
+
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				c.report(err)
+			}
+		}
+	}
+}
+
+// get requests path from the daemon, returning an error for statuses
+// other than 200 OK.
+func (c *Collector) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("squiddocker: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return nil, fmt.Errorf("squiddocker: GET %s returned %s: %s", path, resp.Status, msg.Message)
+	}
+	return resp, nil
+}
+
+// getJSON requests path from the daemon and decodes the response into v.
+func (c *Collector) getJSON(ctx context.Context, path string, query url.Values, v any) error {
+	resp, err := c.get(ctx, path, query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("squiddocker: reading %s: %w", path, err)
+	}
+	return nil
+}
+
+// encodeFilters encodes the filters parameter of the Engine API.
+func encodeFilters(filters map[string][]string) string {
+	b, _ := json.Marshal(filters)
+	return string(b)
+}
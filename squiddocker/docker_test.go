@@ -0,0 +1,176 @@
+package squiddocker
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// frame returns a frame of multiplexed logs.
+func frame(stream byte, payload string) string {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return string(header) + payload
+}
+
+// fakeDaemon serves the Engine API for a container "web" that is running,
+// and a container "tty" that starts when start is closed.
+type fakeDaemon struct {
+	start chan struct{}
+
+	mu     sync.Mutex
+	since  []string
+	webLog string
+}
+
+func (d *fakeDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/events":
+		w.(http.Flusher).Flush()
+		select {
+		case <-d.start:
+			fmt.Fprintln(w, `{"Type":"container","Action":"start","Actor":{"ID":"tty"}}`)
+			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			return
+		}
+		<-r.Context().Done()
+	case "/containers/json":
+		fmt.Fprint(w, `[{"Id":"web0123456789abcdef"}]`)
+	case "/containers/web0123456789abcdef/json":
+		fmt.Fprint(w, `{"Id":"web0123456789abcdef","Name":"/web","Config":{"Image":"nginx:1.27","Labels":{"com.docker.compose.service":"frontend"}}}`)
+	case "/containers/tty/json":
+		fmt.Fprint(w, `{"Id":"tty","Name":"/shell","Config":{"Image":"alpine","Tty":true}}`)
+	case "/containers/web0123456789abcdef/logs":
+		d.mu.Lock()
+		d.since = append(d.since, r.URL.Query().Get("since"))
+		fmt.Fprint(w, d.webLog)
+		d.mu.Unlock()
+	case "/containers/tty/logs":
+		fmt.Fprint(w, "2024-01-01T10:00:05Z $ ls\r\n")
+	default:
+		http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+	}
+}
+
+// runCollector runs a Collector against d until n events are stored.
+func runCollector(t *testing.T, db *squid.DB, d *fakeDaemon, n int64) {
+	t.Helper()
+
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: d}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c := New(db, Options{
+		Socket:        socket,
+		TagLabels:     []string{"com.docker.compose.service"},
+		JSON:          true,
+		FlushInterval: 10 * time.Millisecond,
+		OnError:       func(err error) { t.Errorf("unexpected error: %v", err) },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- c.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if count, _ := db.Count(); count >= n {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d events", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // No duplicates follow
+	cancel()
+	<-done
+}
+
+func TestCollector(t *testing.T) {
+	db := openTestDB(t)
+	d := &fakeDaemon{
+		start: make(chan struct{}),
+		webLog: frame(1, "2024-01-01T10:00:01.5Z GET /\n") +
+			frame(2, `2024-01-01T10:00:02Z {"level":"error","ms":12}`+"\n") +
+			frame(1, "2024-01-01T10:00:03Z a line spanning") + frame(1, " two frames\n"),
+	}
+	close(d.start)
+	runCollector(t, db, d, 4)
+
+	events, err := db.Query(context.Background(), squid.Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, fmt.Sprintf("%s %s %v", e.Type, e.Tags["container"], e.Data))
+	}
+	want := []string{
+		"docker.stdout web map[message:GET /]",
+		"docker.stderr web map[level:error ms:12]",
+		"docker.stdout web map[message:a line spanning two frames]",
+		"docker.stdout shell map[message:$ ls]",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected events %q, got %q", want, got)
+	}
+	wantTags := map[string]string{"container": "web", "container_id": "web012345678", "image": "nginx:1.27", "com.docker.compose.service": "frontend"}
+	if !reflect.DeepEqual(events[0].Tags, wantTags) {
+		t.Errorf("expected tags %v, got %v", wantTags, events[0].Tags)
+	}
+
+	// A restarted Collector asks for the lines since the last one stored,
+	// which Docker includes, and skips it
+	d.mu.Lock()
+	d.webLog += frame(1, "2024-01-01T10:00:04Z GET /health\n")
+	d.mu.Unlock()
+	d.start = make(chan struct{})
+	runCollector(t, db, d, 5)
+
+	// The tty container's line, at 10:00:05, is the last event
+	last, err := db.Query(context.Background(), squid.Query{Descending: true, Limit: 2})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if n, _ := db.Count(); n != 5 || last[1].Data["message"] != "GET /health" {
+		t.Errorf("expected 5 events ending with GET /health, got %d", n)
+	}
+	if !reflect.DeepEqual(d.since, []string{"", "1704103203.000000000"}) {
+		t.Errorf("unexpected since parameters %q", d.since)
+	}
+}
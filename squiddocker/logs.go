@@ -0,0 +1,192 @@
+package squiddocker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// container is the part of a container's inspection that tags its lines.
+type container struct {
+	ID     string `json:"Id"`
+	Name   string `json:"Name"`
+	Config struct {
+		Image  string            `json:"Image"`
+		Labels map[string]string `json:"Labels"`
+		Tty    bool              `json:"Tty"`
+	} `json:"Config"`
+}
+
+// streams names the streams of multiplexed logs by their header byte.
+var streams = []string{"stdin", "stdout", "stderr"}
+
+// followLogs queues the lines a container logs after the position reached,
+// until its log stream ends when it stops.
+func (c *Collector) followLogs(ctx context.Context, id string, lines chan<- queued) error {
+	var ctr container
+	if err := c.getJSON(ctx, "/containers/"+url.PathEscape(id)+"/json", nil, &ctr); err != nil {
+		return err
+	}
+
+	pos, err := c.position(id)
+	if err != nil {
+		return err
+	}
+	query := url.Values{
+		"follow":     {"1"},
+		"stdout":     {"1"},
+		"stderr":     {"1"},
+		"timestamps": {"1"},
+	}
+	if !pos.IsZero() {
+		query.Set("since", fmt.Sprintf("%d.%09d", pos.Unix(), pos.Nanosecond()))
+	}
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(id)+"/logs", query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	queue := func(stream, line string) error {
+		// Each line starts with its RFC 3339 timestamp
+		stamp, text, _ := strings.Cut(strings.TrimRight(line, "\r"), " ")
+		ts, err := time.Parse(time.RFC3339Nano, stamp)
+		if err != nil {
+			return fmt.Errorf("reading log line: %w", err)
+		}
+		if !ts.After(pos) {
+			return nil // Since is inclusive
+		}
+		pos = ts
+
+		c.mu.Lock()
+		c.positions[id] = ts
+		c.mu.Unlock()
+
+		select {
+		case lines <- queued{id: id, ts: ts, event: c.event(&ctr, stream, ts, text)}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if ctr.Config.Tty {
+		// Logs of containers with a TTY are not multiplexed
+		r := bufio.NewReader(resp.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if line = strings.TrimSuffix(line, "\n"); line != "" {
+				if err := queue("stdout", line); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Multiplexed logs are frames of an 8 byte header, holding the stream
+	// and the frame's size, and a payload of one or more lines. Lines longer
+	// than Docker's 16 KiB buffer span frames.
+	partial := make(map[string]*bytes.Buffer)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(resp.Body, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if int(header[0]) >= len(streams) {
+			return fmt.Errorf("reading logs: unknown stream %d", header[0])
+		}
+		stream := streams[header[0]]
+		buf := partial[stream]
+		if buf == nil {
+			buf = new(bytes.Buffer)
+			partial[stream] = buf
+		}
+		if _, err := io.CopyN(buf, resp.Body, int64(binary.BigEndian.Uint32(header[4:]))); err != nil {
+			return err
+		}
+
+		for {
+			i := bytes.IndexByte(buf.Bytes(), '\n')
+			if i < 0 {
+				break
+			}
+			line := string(buf.Next(i + 1))
+			if err := queue(stream, line[:i]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// position returns the timestamp of the last line queued or stored from a
+// container, or the zero time if there is none.
+func (c *Collector) position(id string) (time.Time, error) {
+	c.mu.Lock()
+	pos, ok := c.positions[id]
+	c.mu.Unlock()
+	if ok {
+		return pos, nil
+	}
+
+	val, err := c.db.GetMeta(positionPrefix + id)
+	if errors.Is(err, squid.ErrMetaNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, string(val))
+}
+
+// event maps a log line of a container to an event.
+func (c *Collector) event(ctr *container, stream string, ts time.Time, text string) squid.Event {
+	tags := map[string]string{
+		"container":    strings.TrimPrefix(ctr.Name, "/"),
+		"container_id": ctr.ID[:min(12, len(ctr.ID))],
+	}
+	if ctr.Config.Image != "" {
+		tags["image"] = ctr.Config.Image
+	}
+	for _, label := range c.opts.TagLabels {
+		if v := ctr.Config.Labels[label]; v != "" {
+			tags[label] = v
+		}
+	}
+
+	var data map[string]any
+	if c.opts.JSON && strings.HasPrefix(text, "{") {
+		if json.Unmarshal([]byte(text), &data) != nil {
+			data = nil
+		}
+	}
+	if data == nil {
+		data = map[string]any{"message": text}
+	}
+
+	return squid.Event{
+		Timestamp: ts,
+		Type:      c.opts.Type + "." + stream,
+		Tags:      tags,
+		Data:      data,
+	}
+}
@@ -0,0 +1,320 @@
+// Package squidhttp records the requests served by net/http handlers as
+// events, for request analytics without a separate metrics pipeline.
+//
+// Each request becomes an event typed "request", tagged with its method and
+// route, with its path, status, duration and response size as data:
+//
+//	mux := http.NewServeMux()
+//	mux.HandleFunc("GET /items/{id}", getItem)
+//	http.ListenAndServe(":8080", squidhttp.Middleware(db)(mux))
+//
+//	slow, err := db.Aggregate(ctx, squid.Query{
+//		Types: []string{"request"},
+//		Tags:  map[string]string{"route": "GET /items/{id}"},
+//	}, "duration_ms", []squid.AggregationType{squid.P99})
+//
+// Routers whose middleware has the same signature, such as chi, use it
+// directly; Options.Route names their routes:
+//
+//	rec := squidhttp.New(db, squidhttp.Options{
+//		Route: func(r *http.Request) string {
+//			return chi.RouteContext(r.Context()).RoutePattern()
+//		},
+//		SampleRate: 0.1,
+//		KeepErrors: true,
+//	})
+//	defer rec.Close()
+//	router.Use(rec.Handler)
+//
+// Frameworks with middleware of their own call Recorder.Record instead. The
+// squidgin module does so for gin, in a module of its own so that this one
+// does not depend on gin:
+//
+//	engine.Use(squidgin.Middleware(rec))
+//
+// Events are appended in batches in the background, so recording adds no
+// commit to the latency of requests.
+package squidhttp
+
+import (
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// Options configures a Recorder.
+type Options struct {
+	// Type is the type of the events. Defaults to "request".
+	Type string
+
+	// Tags are added to every event, e.g. the service name.
+	Tags map[string]string
+
+	// Route returns the route of a request, stored as the "route" tag, once
+	// it has been served. Defaults to the pattern http.ServeMux matched.
+	// Requests without a route have no route tag.
+	Route func(r *http.Request) string
+
+	// Skip excludes requests from recording, e.g. health checks.
+	Skip func(r *http.Request) bool
+
+	// SampleRate is the fraction of requests recorded, between 0 and 1.
+	// Defaults to 1. Sampled events have a "sample_rate" data field, so
+	// that counts can be scaled up.
+	SampleRate float64
+
+	// KeepErrors records every response with a 5xx status, whatever the
+	// sample rate.
+	KeepErrors bool
+
+	// QueueSize is the number of events waiting to be appended beyond which
+	// further events are dropped. Defaults to 10000.
+	QueueSize int
+
+	// BatchSize is the maximum number of events appended per transaction.
+	// Defaults to 500.
+	BatchSize int
+
+	// FlushInterval is how long events wait for a batch to fill before
+	// they are appended. Defaults to 1 second.
+	FlushInterval time.Duration
+
+	// OnError is called when events cannot be appended. They are dropped.
+	OnError func(err error)
+}
+
+// Recorder records the requests served by handlers.
+type Recorder struct {
+	db   *squid.DB
+	opts Options
+
+	queue   chan squid.Event
+	dropped atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// Middleware returns middleware recording requests to db with the default
+// Options. The events queued when the process exits are lost; use New and
+// Recorder.Close to append them on shutdown.
+func Middleware(db *squid.DB) func(http.Handler) http.Handler {
+	return New(db, Options{}).Handler
+}
+
+// New creates a Recorder for db, which appends events until it is closed.
+func New(db *squid.DB, opts Options) *Recorder {
+	if opts.Type == "" {
+		opts.Type = "request"
+	}
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+
+	rec := &Recorder{
+		db:    db,
+		opts:  opts,
+		queue: make(chan squid.Event, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go rec.run()
+	return rec
+}
+
+// Handler returns next wrapped to record the requests it serves.
+func (rec *Recorder) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec.opts.Skip != nil && rec.opts.Skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			if v := recover(); v != nil {
+				// The server answers a panicking handler with a 500, unless
+				// it aborted a response already under way
+				if sw.status == 0 && v != http.ErrAbortHandler {
+					sw.status = http.StatusInternalServerError
+				}
+				rec.record(r, sw.status, sw.bytes, start)
+				panic(v)
+			}
+			rec.record(r, sw.status, sw.bytes, start)
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// Record records a request served without Handler, such as by a framework
+// with middleware of its own, given the status and size of its response and
+// when it started. Skip and sampling apply as they do to Handler, and the
+// default route is r.Pattern, which adapters can set.
+func (rec *Recorder) Record(r *http.Request, status int, size int64, start time.Time) {
+	if rec.opts.Skip != nil && rec.opts.Skip(r) {
+		return
+	}
+	rec.record(r, status, size, start)
+}
+
+// record queues the event of a served request, if it is sampled.
+func (rec *Recorder) record(r *http.Request, status int, size int64, start time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	rate := rec.opts.SampleRate
+	if rec.opts.KeepErrors && status >= 500 {
+		rate = 1
+	}
+	if rate < 1 && rand.Float64() >= rate {
+		return
+	}
+
+	event := squid.Event{
+		Timestamp: start,
+		Type:      rec.opts.Type,
+		Tags:      maps.Clone(rec.opts.Tags),
+		Data: map[string]any{
+			"path":        r.URL.Path,
+			"status":      status,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"bytes":       size,
+		},
+	}
+	if event.Tags == nil {
+		event.Tags = make(map[string]string)
+	}
+	event.Tags["method"] = r.Method
+	route := r.Pattern
+	if rec.opts.Route != nil {
+		route = rec.opts.Route(r)
+	}
+	if route != "" {
+		event.Tags["route"] = route
+	}
+	if rate < 1 {
+		event.Data["sample_rate"] = rate
+	}
+
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	if rec.closed {
+		return
+	}
+	select {
+	case rec.queue <- event:
+	default:
+		rec.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (rec *Recorder) Dropped() int64 {
+	return rec.dropped.Load()
+}
+
+// Close stops recording and appends the queued events.
+func (rec *Recorder) Close() error {
+	rec.mu.Lock()
+	if rec.closed {
+		rec.mu.Unlock()
+		return nil
+	}
+	rec.closed = true
+	close(rec.queue)
+	rec.mu.Unlock()
+
+	<-rec.done
+	return nil
+}
+
+// run appends the queued events in batches until the Recorder is closed.
+func (rec *Recorder) run() {
+	defer close(rec.done)
+
+	ticker := time.NewTicker(rec.opts.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]squid.Event, 0, rec.opts.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if _, err := rec.db.AppendBatch(batch); err != nil && rec.opts.OnError != nil {
+			rec.opts.OnError(fmt.Errorf("squidhttp: appending %d requests: %w", len(batch), err))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event, ok := <-rec.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= rec.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 && status >= 200 {
+		// Informational responses such as 103 Early Hints come first
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes the response if the underlying writer supports it.
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
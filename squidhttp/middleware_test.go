@@ -0,0 +1,134 @@
+package squidhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/asungur/squid"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestRecorder(t *testing.T) {
+	db := openTestDB(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "item "+r.PathValue("id"))
+	})
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := New(db, Options{
+		Tags: map[string]string{"service": "api"},
+		Skip: func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+	})
+	srv := httptest.NewServer(rec.Handler(mux))
+	defer srv.Close()
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/items/42"},
+		{"POST", "/items"},
+		{"GET", "/missing"},
+		{"GET", "/healthz"},
+	} {
+		r, err := http.NewRequest(req.method, srv.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("%s %s: %v", req.method, req.path, err)
+		}
+		resp.Body.Close()
+	}
+	rec.Close()
+
+	events, err := db.Query(context.Background(), squid.Query{Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var got []string
+	for _, e := range events {
+		got = append(got, e.Tags["method"]+" "+e.Tags["route"]+" "+e.Data["path"].(string))
+	}
+	want := []string{"GET GET /items/{id} /items/42", "POST POST /items /items", "GET  /missing"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected requests %q, got %q", want, got)
+	}
+
+	first := events[0]
+	if first.Tags["service"] != "api" || first.Data["status"] != 200.0 || first.Data["bytes"] != float64(len("item 42")) {
+		t.Errorf("unexpected event %v %v", first.Tags, first.Data)
+	}
+	if _, ok := first.Data["duration_ms"].(float64); !ok {
+		t.Errorf("expected a duration, got %v", first.Data)
+	}
+	if events[1].Data["status"] != 201.0 || events[2].Data["status"] != 404.0 {
+		t.Errorf("unexpected statuses %v and %v", events[1].Data["status"], events[2].Data["status"])
+	}
+
+	// Requests after Close are not recorded
+	rec.Handler(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/items/1", nil))
+	if n, _ := db.Count(); n != 3 {
+		t.Errorf("expected 3 events, got %d", n)
+	}
+}
+
+func TestRecorderSampling(t *testing.T) {
+	db := openTestDB(t)
+
+	rec := New(db, Options{SampleRate: 0.000001, KeepErrors: true})
+	h := rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fail") {
+			http.Error(w, "failed", http.StatusBadGateway)
+		}
+	}))
+	for i := 0; i < 100; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	func() {
+		defer func() { recover() }()
+		rec.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}()
+	rec.Close()
+
+	// Only the errors are kept, at full rate
+	events, err := db.Query(context.Background(), squid.Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Data["status"] != 502.0 || events[1].Data["status"] != 500.0 {
+		t.Fatalf("expected the 502 and the 500, got %v", events)
+	}
+	if _, ok := events[0].Data["sample_rate"]; ok {
+		t.Errorf("errors kept at full rate should have no sample rate, got %v", events[0].Data)
+	}
+}
@@ -0,0 +1,51 @@
+// Package squidgin records the requests served by gin as events, adapting a
+// squidhttp.Recorder to gin's middleware. It is a module of its own, so that
+// squidhttp does not depend on gin.
+//
+// Events are tagged with the route gin matched, such as "/items/:id":
+//
+//	rec := squidhttp.New(db, squidhttp.Options{KeepErrors: true})
+//	defer rec.Close()
+//
+//	engine := gin.New()
+//	engine.Use(squidgin.Middleware(rec))
+package squidgin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/asungur/squid/squidhttp"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware returns gin middleware recording the requests handled after it
+// with rec. Options.Route, if set, takes precedence over gin's route.
+func Middleware(rec *squidhttp.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		defer func() {
+			status := c.Writer.Status()
+			if v := recover(); v != nil {
+				// Recovery middleware before this one answers with a 500,
+				// unless the response was already under way
+				if !c.Writer.Written() && v != http.ErrAbortHandler {
+					status = http.StatusInternalServerError
+				}
+				record(c, rec, status, start)
+				panic(v)
+			}
+			record(c, rec, status, start)
+		}()
+
+		c.Next()
+	}
+}
+
+// record records the request of c, with the route gin matched as its
+// pattern.
+func record(c *gin.Context, rec *squidhttp.Recorder, status int, start time.Time) {
+	r := *c.Request
+	r.Pattern = c.FullPath()
+	rec.Record(&r, status, int64(max(c.Writer.Size(), 0)), start)
+}
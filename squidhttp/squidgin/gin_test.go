@@ -0,0 +1,85 @@
+package squidgin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/asungur/squid"
+	"github.com/asungur/squid/squidhttp"
+	"github.com/gin-gonic/gin"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db := openTestDB(t)
+
+	rec := squidhttp.New(db, squidhttp.Options{
+		Skip: func(r *http.Request) bool { return r.URL.Path == "/healthz" },
+	})
+	engine := gin.New()
+	engine.Use(gin.Recovery(), Middleware(rec))
+	engine.GET("/items/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "item "+c.Param("id"))
+	})
+	engine.POST("/items", func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	engine.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	engine.GET("/healthz", func(c *gin.Context) {})
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/items/42"},
+		{"POST", "/items"},
+		{"GET", "/panic"},
+		{"GET", "/missing"},
+		{"GET", "/healthz"},
+	} {
+		engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+	rec.Close()
+
+	events, err := db.Query(context.Background(), squid.Query{Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var got []any
+	for _, e := range events {
+		got = append(got, []any{e.Tags["method"], e.Tags["route"], e.Data["path"], e.Data["status"]})
+	}
+	want := []any{
+		[]any{"GET", "/items/:id", "/items/42", 200.0},
+		[]any{"POST", "/items", "/items", 201.0},
+		[]any{"GET", "/panic", "/panic", 500.0},
+		[]any{"GET", "", "/missing", 404.0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected requests %v, got %v", want, got)
+	}
+	if events[0].Data["bytes"] != float64(len("item 42")) {
+		t.Errorf("expected the response size, got %v", events[0].Data)
+	}
+}
@@ -0,0 +1,376 @@
+// Package squidjournal appends the entries of the systemd journal as
+// events, so that a Linux host can keep its logs in Squid under its
+// retention policies and query and aggregate them locally.
+//
+// A Reader runs journalctl and follows its JSON output. Each entry becomes
+// an event whose type is named after its priority, such as
+// "journal.warning", tagged with the unit, host and syslog identifier that
+// logged it, with the message and the other journal fields as data. The
+// cursor of the last entry is stored in the database in the same
+// transaction as the events, so a restarted Reader resumes after it:
+//
+//	r := squidjournal.New(db, squidjournal.Options{
+//		Units: []string{"nginx.service", "postgresql.service"},
+//	})
+//	err := r.Run(ctx) // until ctx is done
+//
+//	failed, err := db.Query(ctx, squid.Query{
+//		Types: []string{"journal.emerg", "journal.alert", "journal.crit", "journal.err"},
+//		Tags:  map[string]string{"unit": "nginx.service"},
+//	})
+package squidjournal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// cursorPrefix is the application metadata key prefix of the journal
+// cursors.
+const cursorPrefix = "squidjournal/"
+
+// maxEntry is the largest journal entry read, in bytes of JSON.
+const maxEntry = 16 << 20
+
+// priorities names the syslog priorities of journal entries.
+var priorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// tagFields are the journal fields stored as tags, and the tag keys.
+var tagFields = map[string]string{
+	"_SYSTEMD_UNIT":      "unit",
+	"_SYSTEMD_USER_UNIT": "user_unit",
+	"_HOSTNAME":          "host",
+	"SYSLOG_IDENTIFIER":  "identifier",
+}
+
+// Options configures a Reader.
+type Options struct {
+	// Name identifies the stored cursor, so that Readers of different
+	// units keep their own. Defaults to "local".
+	Name string
+
+	// Type prefixes the event types, which end with the priority name.
+	// Defaults to "journal".
+	Type string
+
+	// Units limits the entries read to those of the given systemd units.
+	// Defaults to all entries.
+	Units []string
+
+	// TagFields names further journal fields stored as tags, in addition
+	// to _SYSTEMD_UNIT, _SYSTEMD_USER_UNIT, _HOSTNAME and SYSLOG_IDENTIFIER.
+	TagFields []string
+
+	// DataFields names the journal fields stored as data besides MESSAGE.
+	// Defaults to all fields that are not tags.
+	DataFields []string
+
+	// FromEnd starts without a stored cursor at the end of the journal,
+	// skipping the entries logged before the Reader first ran.
+	FromEnd bool
+
+	// BatchSize is the maximum number of entries appended per transaction.
+	// Defaults to 1000.
+	BatchSize int
+
+	// FlushInterval is how long entries wait for a batch to fill before
+	// they are appended. Defaults to 1 second.
+	FlushInterval time.Duration
+
+	// Command is the journalctl executable. Defaults to "journalctl".
+	Command string
+}
+
+// Reader appends journal entries to a database.
+type Reader struct {
+	db   *squid.DB
+	opts Options
+}
+
+// New creates a Reader for db.
+func New(db *squid.DB, opts Options) *Reader {
+	if opts.Name == "" {
+		opts.Name = "local"
+	}
+	if opts.Type == "" {
+		opts.Type = "journal"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.Command == "" {
+		opts.Command = "journalctl"
+	}
+	return &Reader{db: db, opts: opts}
+}
+
+// Cursor returns the cursor of the last entry appended, or "" if there is
+// none.
+func (r *Reader) Cursor() (string, error) {
+	cursor, err := r.db.GetMeta(cursorPrefix + r.opts.Name)
+	if errors.Is(err, squid.ErrMetaNotFound) {
+		return "", nil
+	}
+	return string(cursor), err
+}
+
+// Run follows the journal with journalctl, appending entries from the
+// stored cursor, until ctx is done or journalctl fails. It returns the
+// error of ctx when ctx is done.
+func (r *Reader) Run(ctx context.Context) error {
+	cursor, err := r.Cursor()
+	if err != nil {
+		return err
+	}
+
+	args := []string{"--output=json", "--all", "--follow"}
+	switch {
+	case cursor != "":
+		args = append(args, "--after-cursor="+cursor)
+	case r.opts.FromEnd:
+		args = append(args, "--lines=0")
+	default:
+		args = append(args, "--no-tail")
+	}
+	for _, unit := range r.opts.Units {
+		args = append(args, "--unit="+unit)
+	}
+
+	cmd := exec.CommandContext(ctx, r.opts.Command, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("squidjournal: %w", err)
+	}
+
+	err = r.Ingest(ctx, stdout)
+	if err != nil {
+		// Stops journalctl if it is still writing
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if waitErr != nil {
+		return fmt.Errorf("squidjournal: %s: %w: %s", r.opts.Command, waitErr, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return errors.New("squidjournal: journalctl exited")
+}
+
+// Ingest appends the journal entries read from src, in the JSON format of
+// journalctl --output=json, until src ends or ctx is done, and stores the
+// cursor of the last one. Entries are appended in batches of
+// Options.BatchSize, or after Options.FlushInterval when fewer arrive.
+func (r *Reader) Ingest(ctx context.Context, src io.Reader) error {
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(src)
+		sc.Buffer(make([]byte, 64<<10), maxEntry)
+		for sc.Scan() {
+			select {
+			case lines <- bytes.Clone(sc.Bytes()):
+			case <-done:
+				return
+			}
+		}
+		readErr <- sc.Err()
+	}()
+
+	ticker := time.NewTicker(r.opts.FlushInterval)
+	defer ticker.Stop()
+
+	events := make([]squid.Event, 0, r.opts.BatchSize)
+	var cursor string
+	flush := func() error {
+		if len(events) == 0 {
+			return nil
+		}
+		var meta map[string][]byte
+		if cursor != "" {
+			meta = map[string][]byte{cursorPrefix + r.opts.Name: []byte(cursor)}
+		}
+		if _, err := r.db.AppendBatchWithMeta(events, meta); err != nil {
+			return fmt.Errorf("squidjournal: appending entries up to %s: %w", cursor, err)
+		}
+		events = events[:0]
+		return nil
+	}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if err := flush(); err != nil {
+					return err
+				}
+				return <-readErr
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var entry map[string]any
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return fmt.Errorf("squidjournal: reading entry: %w", err)
+			}
+			event, c := r.event(entry)
+			events = append(events, event)
+			if c != "" {
+				cursor = c
+			}
+			if len(events) >= r.opts.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-ctx.Done():
+			if err := flush(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// event maps a journal entry to an event and returns the entry's cursor.
+func (r *Reader) event(entry map[string]any) (squid.Event, string) {
+	priority := "info" // The priority of stdout and of entries without one
+	if n, err := strconv.Atoi(fieldString(entry["PRIORITY"])); err == nil && n >= 0 && n < len(priorities) {
+		priority = priorities[n]
+	}
+	event := squid.Event{
+		Type: r.opts.Type + "." + priority,
+		Tags: make(map[string]string),
+		Data: make(map[string]any),
+	}
+	if us, err := strconv.ParseInt(fieldString(entry["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+		event.Timestamp = time.UnixMicro(us)
+	}
+	if msg, ok := fieldValue(entry["MESSAGE"]); ok {
+		event.Data["message"] = msg
+	}
+
+	// Fields are mapped in order so that a trusted field such as _PID,
+	// which sorts after PID, wins over the one the client sent
+	names := make([]string, 0, len(entry))
+	for name := range entry {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if strings.HasPrefix(name, "__") || name == "MESSAGE" || name == "PRIORITY" {
+			continue
+		}
+		v, ok := fieldValue(entry[name])
+		if !ok {
+			continue
+		}
+		key, tag := tagFields[name]
+		if !tag {
+			key = strings.ToLower(strings.TrimLeft(name, "_"))
+			tag = slices.Contains(r.opts.TagFields, name)
+		}
+		if tag {
+			if s := fmt.Sprint(v); s != "" {
+				event.Tags[key] = s
+			}
+			continue
+		}
+		if len(r.opts.DataFields) > 0 && !slices.Contains(r.opts.DataFields, name) {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			event.Data[key] = parseValue(s)
+		} else {
+			event.Data[key] = v
+		}
+	}
+
+	return event, fieldString(entry["__CURSOR"])
+}
+
+// fieldValue returns the value of a journal field in JSON. Binary values
+// are arrays of bytes, kept as strings, and fields set more than once are
+// arrays of values.
+func fieldValue(v any) (any, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case []any:
+		if b, ok := fieldBytes(v); ok {
+			return strings.ToValidUTF8(string(b), "�"), true
+		}
+		values := make([]any, 0, len(v))
+		for _, item := range v {
+			if item, ok := fieldValue(item); ok {
+				values = append(values, item)
+			}
+		}
+		return values, len(values) > 0
+	}
+	return nil, false // Null for fields too large without --all
+}
+
+// fieldString returns a journal field as a string, or "" if it is not one.
+func fieldString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// fieldBytes returns the bytes of a binary field.
+func fieldBytes(v []any) ([]byte, bool) {
+	b := make([]byte, len(v))
+	for i, item := range v {
+		n, ok := item.(float64)
+		if !ok || n < 0 || n > 255 || n != math.Trunc(n) {
+			return nil, false
+		}
+		b[i] = byte(n)
+	}
+	return b, len(v) > 0
+}
+
+// parseValue returns s as a number if it is one, so that aggregations can
+// use numeric fields such as those logged with sd_journal_send.
+func parseValue(s string) any {
+	if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+		return n
+	}
+	return s
+}
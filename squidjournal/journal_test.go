@@ -0,0 +1,130 @@
+package squidjournal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+const entries = `{"__CURSOR":"s=1","__REALTIME_TIMESTAMP":"1704103200000000","PRIORITY":"3","_SYSTEMD_UNIT":"nginx.service","_HOSTNAME":"web-1","SYSLOG_IDENTIFIER":"nginx","MESSAGE":"upstream timed out","_PID":"812","PID":"1","REQUEST_MS":"5000","_BOOT_ID":"b1","EMPTY":null}
+
+{"__CURSOR":"s=2","__REALTIME_TIMESTAMP":"1704103201000000","_HOSTNAME":"web-1","MESSAGE":[104,105],"TAG":["a","b"]}
+`
+
+func TestIngest(t *testing.T) {
+	db := openTestDB(t)
+	r := New(db, Options{TagFields: []string{"_BOOT_ID"}})
+
+	if err := r.Ingest(context.Background(), strings.NewReader(entries)); err != nil {
+		t.Fatalf("Ingest failed: %v", err)
+	}
+
+	events, err := db.Query(context.Background(), squid.Query{})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+
+	e := events[0]
+	if e.Type != "journal.err" || !e.Timestamp.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected event %s at %s", e.Type, e.Timestamp)
+	}
+	wantTags := map[string]string{"unit": "nginx.service", "host": "web-1", "identifier": "nginx", "boot_id": "b1"}
+	if !reflect.DeepEqual(e.Tags, wantTags) {
+		t.Errorf("expected tags %v, got %v", wantTags, e.Tags)
+	}
+	// The trusted _PID wins over the PID sent by the client
+	wantData := map[string]any{"message": "upstream timed out", "pid": 812.0, "request_ms": 5000.0}
+	if !reflect.DeepEqual(e.Data, wantData) {
+		t.Errorf("expected data %v, got %v", wantData, e.Data)
+	}
+
+	e = events[1]
+	if e.Type != "journal.info" || e.Data["message"] != "hi" || !reflect.DeepEqual(e.Data["tag"], []any{"a", "b"}) {
+		t.Errorf("unexpected event %s %v", e.Type, e.Data)
+	}
+
+	if cursor, err := r.Cursor(); err != nil || cursor != "s=2" {
+		t.Errorf("expected cursor s=2, got %q, %v", cursor, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell script")
+	}
+
+	// A fake journalctl records its arguments and prints the entries
+	dir := t.TempDir()
+	script := filepath.Join(dir, "journalctl")
+	args := filepath.Join(dir, "args")
+	data := filepath.Join(dir, "entries")
+	if err := os.WriteFile(data, []byte(entries), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	body := "#!/bin/sh\necho \"$@\" >> " + args + "\ncat " + data + "\n"
+	if err := os.WriteFile(script, []byte(body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	db := openTestDB(t)
+	r := New(db, Options{Command: script, Units: []string{"nginx.service"}})
+
+	// Run returns when journalctl exits, which it only does on failure
+	err := r.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Errorf("expected an exit error, got %v", err)
+	}
+	if n, _ := db.Count(); n != 2 {
+		t.Errorf("expected 2 events, got %d", n)
+	}
+
+	// A second run starts after the stored cursor
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := r.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	_ = r.Run(context.Background())
+
+	got, err := os.ReadFile(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(got)), "\n")
+	want := []string{
+		"--output=json --all --follow --no-tail --unit=nginx.service",
+		"--output=json --all --follow --after-cursor=s=2 --unit=nginx.service",
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("expected arguments %q, got %q", want, lines)
+	}
+}
@@ -0,0 +1,132 @@
+package squidkube
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Config locates an API server and authenticates to it.
+type Config struct {
+	// Host is the base URL of the API server, e.g.
+	// "https://10.96.0.1:443".
+	Host string
+
+	// Token is a bearer token sent with every request.
+	Token string
+
+	// TokenFile is read for the bearer token before every request, so that
+	// rotated service account tokens are picked up. Overrides Token.
+	TokenFile string
+
+	// Client makes the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// InClusterConfig returns the Config of the pod's service account, as
+// client-go's rest.InClusterConfig does.
+func InClusterConfig() (*Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("squidkube: not running in a cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("squidkube: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("squidkube: no certificates in the service account's ca.crt")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+
+	return &Config{
+		Host:      "https://" + net.JoinHostPort(host, port),
+		TokenFile: filepath.Join(serviceAccountDir, "token"),
+		Client:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// InClusterNamespace returns the namespace of the pod, from its service
+// account.
+func InClusterNamespace() (string, error) {
+	ns, err := os.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return "", fmt.Errorf("squidkube: %w", err)
+	}
+	return strings.TrimSpace(string(ns)), nil
+}
+
+// get requests path with the query from the API server. Responses other
+// than 200 OK are returned as a *StatusError.
+func (c *Config) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.Host, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token := c.Token
+	if c.TokenFile != "" {
+		b, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("squidkube: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		status := &StatusError{Code: resp.StatusCode}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, status) != nil || status.Message == "" {
+			status.Message = strings.TrimSpace(string(body))
+		}
+		return nil, status
+	}
+	return resp, nil
+}
+
+// StatusError is an error status returned by the API server.
+type StatusError struct {
+	// Code is the HTTP status code, e.g. 410 when a watch's resource
+	// version is too old.
+	Code int `json:"code"`
+
+	// Reason is the machine-readable reason, e.g. "Expired".
+	Reason string `json:"reason"`
+
+	// Message describes the error.
+	Message string `json:"message"`
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("squidkube: API server returned %d: %s", e.Code, e.Message)
+}
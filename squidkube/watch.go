@@ -0,0 +1,497 @@
+// Package squidkube stores Kubernetes Events as Squid events, keeping a
+// durable, queryable history of a cluster beyond the hour the API server
+// keeps them for.
+//
+// A Watcher lists the Events of a namespace, or of the whole cluster, and
+// watches them for new occurrences. Each occurrence becomes an event whose
+// type is named after the Event's type, "kube.normal" or "kube.warning",
+// tagged with the namespace, kind and name of the object it is about (and
+// the pod, for pods), its reason and the reporting component, with the
+// message and count as data. The resource version reached is stored in the
+// database in the same transaction as the events, so a restarted Watcher
+// resumes where it stopped:
+//
+//	config, err := squidkube.InClusterConfig()
+//	w := squidkube.New(db, config, squidkube.Options{Namespace: "shop"})
+//	err = w.Run(ctx) // until ctx is done
+//
+//	backoffs, err := db.Query(ctx, squid.Query{
+//		Types: []string{"kube.warning"},
+//		Tags:  map[string]string{"reason": "BackOff", "namespace": "shop"},
+//	})
+//
+// The service account needs the list and watch verbs on events.
+package squidkube
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// statePrefix is the application metadata key prefix of the watch states.
+const statePrefix = "squidkube/"
+
+// listLimit is the number of Events listed per request.
+const listLimit = 500
+
+// Options configures a Watcher.
+type Options struct {
+	// Name identifies the stored watch state, so that Watchers of different
+	// clusters or namespaces keep their own. Defaults to "cluster".
+	Name string
+
+	// Namespace limits the Events watched to one namespace. Defaults to
+	// all namespaces, which needs a ClusterRole.
+	Namespace string
+
+	// FieldSelector limits the Events watched, e.g. "type=Warning".
+	FieldSelector string
+
+	// Type prefixes the event types, which end with the Event's type.
+	// Defaults to "kube".
+	Type string
+
+	// BatchSize is the maximum number of Events appended per transaction.
+	// Defaults to 500.
+	BatchSize int
+
+	// FlushInterval is how long Events wait for a batch to fill before
+	// they are appended. Defaults to 1 second.
+	FlushInterval time.Duration
+
+	// RetryDelay is the delay before the first retry of a failed watch,
+	// doubled for each further failure up to a minute. Defaults to 1
+	// second.
+	RetryDelay time.Duration
+
+	// OnError is called when a list or watch fails, before it is retried.
+	OnError func(err error)
+}
+
+// Watcher appends the Kubernetes Events of a cluster to a database.
+type Watcher struct {
+	db     *squid.DB
+	config *Config
+	opts   Options
+}
+
+// New creates a Watcher storing the Events of the cluster config connects
+// to in db.
+func New(db *squid.DB, config *Config, opts Options) *Watcher {
+	if opts.Name == "" {
+		opts.Name = "cluster"
+	}
+	if opts.Type == "" {
+		opts.Type = "kube"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	return &Watcher{db: db, config: config, opts: opts}
+}
+
+// state is the stored progress of a Watcher.
+type state struct {
+	// ResourceVersion is where the watch resumes, or "" to list again.
+	ResourceVersion string `json:"resource_version,omitempty"`
+
+	// LastSeen is the newest occurrence stored. A list after the watch
+	// expired only stores the occurrences after it.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// kubeEvent is the part of a core/v1 Event that is stored.
+type kubeEvent struct {
+	Metadata struct {
+		Name              string    `json:"name"`
+		Namespace         string    `json:"namespace"`
+		UID               string    `json:"uid"`
+		ResourceVersion   string    `json:"resourceVersion"`
+		CreationTimestamp time.Time `json:"creationTimestamp"`
+	} `json:"metadata"`
+	InvolvedObject struct {
+		Kind      string `json:"kind"`
+		Namespace string `json:"namespace"`
+		Name      string `json:"name"`
+		UID       string `json:"uid"`
+		FieldPath string `json:"fieldPath"`
+	} `json:"involvedObject"`
+	Reason         string    `json:"reason"`
+	Message        string    `json:"message"`
+	Type           string    `json:"type"`
+	Count          int       `json:"count"`
+	FirstTimestamp time.Time `json:"firstTimestamp"`
+	LastTimestamp  time.Time `json:"lastTimestamp"`
+	EventTime      time.Time `json:"eventTime"`
+	Source         struct {
+		Component string `json:"component"`
+		Host      string `json:"host"`
+	} `json:"source"`
+	ReportingComponent string `json:"reportingComponent"`
+	Series             *struct {
+		Count            int       `json:"count"`
+		LastObservedTime time.Time `json:"lastObservedTime"`
+	} `json:"series"`
+}
+
+// Run lists and watches Events until ctx is done, retrying failed requests
+// with a growing delay. It returns the error of ctx.
+func (w *Watcher) Run(ctx context.Context) error {
+	delay := w.opts.RetryDelay
+	for {
+		err := w.sync(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			// The watch timed out or expired and is started again
+			delay = w.opts.RetryDelay
+			continue
+		}
+
+		if w.opts.OnError != nil {
+			w.opts.OnError(err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay = min(2*delay, time.Minute)
+	}
+}
+
+// sync lists the Events if there is no resource version to resume from,
+// then watches them until the watch ends.
+func (w *Watcher) sync(ctx context.Context) error {
+	st, err := w.load()
+	if err != nil {
+		return err
+	}
+	if st.ResourceVersion == "" {
+		if st, err = w.list(ctx, st); err != nil {
+			return err
+		}
+	}
+	return w.watch(ctx, st)
+}
+
+// path returns the API path of the watched Events.
+func (w *Watcher) path() string {
+	if w.opts.Namespace != "" {
+		return "/api/v1/namespaces/" + url.PathEscape(w.opts.Namespace) + "/events"
+	}
+	return "/api/v1/events"
+}
+
+// list appends the occurrences after st.LastSeen of the Events that exist,
+// page by page, and returns the state to watch from.
+func (w *Watcher) list(ctx context.Context, st state) (state, error) {
+	query := url.Values{"limit": {fmt.Sprint(listLimit)}}
+	if w.opts.FieldSelector != "" {
+		query.Set("fieldSelector", w.opts.FieldSelector)
+	}
+	since, seen := st.LastSeen, st.LastSeen
+
+	for {
+		var page struct {
+			Metadata struct {
+				ResourceVersion string `json:"resourceVersion"`
+				Continue        string `json:"continue"`
+			} `json:"metadata"`
+			Items []kubeEvent `json:"items"`
+		}
+		resp, err := w.config.get(ctx, w.path(), query)
+		if err != nil {
+			return st, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return st, fmt.Errorf("squidkube: reading events: %w", err)
+		}
+
+		var events []squid.Event
+		for i := range page.Items {
+			e := w.event(&page.Items[i])
+			if e.Timestamp.After(since) {
+				events = append(events, e)
+				seen = later(seen, e.Timestamp)
+			}
+		}
+
+		// Pages are not in time order, so the progress is stored with the
+		// last page and an interrupted list starts again
+		if page.Metadata.Continue == "" {
+			st.ResourceVersion = page.Metadata.ResourceVersion
+			st.LastSeen = seen
+		}
+		if err := w.commit(events, st); err != nil {
+			return st, err
+		}
+
+		if page.Metadata.Continue == "" {
+			return st, nil
+		}
+		query.Set("continue", page.Metadata.Continue)
+	}
+}
+
+// watch appends the occurrences of Events watched from st.ResourceVersion
+// until the watch ends. A watch whose resource version has expired clears
+// it, so that the Events are listed again.
+func (w *Watcher) watch(ctx context.Context, st state) error {
+	query := url.Values{
+		"watch":               {"true"},
+		"resourceVersion":     {st.ResourceVersion},
+		"allowWatchBookmarks": {"true"},
+	}
+	if w.opts.FieldSelector != "" {
+		query.Set("fieldSelector", w.opts.FieldSelector)
+	}
+
+	resp, err := w.config.get(ctx, w.path(), query)
+	if isExpired(err) {
+		st.ResourceVersion = ""
+		return w.commit(nil, st)
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	type watchEvent struct {
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	received := make(chan watchEvent)
+	decodeErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		defer close(received)
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var we watchEvent
+			if err := dec.Decode(&we); err != nil {
+				if err != io.EOF {
+					decodeErr <- fmt.Errorf("squidkube: reading watch: %w", err)
+				}
+				return
+			}
+			select {
+			case received <- we:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var events []squid.Event
+	dirty := false // st changed since it was stored
+	flush := func() error {
+		if !dirty {
+			return nil
+		}
+		if err := w.commit(events, st); err != nil {
+			return err
+		}
+		events, dirty = events[:0], false
+		return nil
+	}
+
+	for {
+		select {
+		case we, ok := <-received:
+			if !ok {
+				if err := flush(); err != nil {
+					return err
+				}
+				select {
+				case err := <-decodeErr:
+					return err
+				default:
+					return nil
+				}
+			}
+
+			var obj kubeEvent
+			switch we.Type {
+			case "ADDED", "MODIFIED":
+				if err := json.Unmarshal(we.Object, &obj); err != nil {
+					return fmt.Errorf("squidkube: reading event: %w", err)
+				}
+				e := w.event(&obj)
+				events = append(events, e)
+				st.LastSeen = later(st.LastSeen, e.Timestamp)
+			case "BOOKMARK", "DELETED":
+				// Events expire; only the resource version moves on
+				if err := json.Unmarshal(we.Object, &obj); err != nil {
+					return fmt.Errorf("squidkube: reading event: %w", err)
+				}
+			case "ERROR":
+				var status StatusError
+				if err := json.Unmarshal(we.Object, &status); err != nil {
+					return fmt.Errorf("squidkube: reading watch error: %w", err)
+				}
+				if err := flush(); err != nil {
+					return err
+				}
+				if isExpired(&status) {
+					st.ResourceVersion = ""
+					return w.commit(nil, st)
+				}
+				return &status
+			default:
+				continue
+			}
+			if obj.Metadata.ResourceVersion != "" {
+				st.ResourceVersion = obj.Metadata.ResourceVersion
+			}
+			dirty = true
+			if len(events) >= w.opts.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-ctx.Done():
+			if err := flush(); err != nil {
+				return err
+			}
+			return ctx.Err()
+		}
+	}
+}
+
+// isExpired reports whether err is the API server's 410 Gone, returned for
+// a resource version it no longer has.
+func isExpired(err error) bool {
+	var status *StatusError
+	return errors.As(err, &status) && status.Code == http.StatusGone
+}
+
+// event maps an occurrence of a Kubernetes Event to an event.
+func (w *Watcher) event(obj *kubeEvent) squid.Event {
+	kind := strings.ToLower(obj.Type)
+	if kind == "" {
+		kind = "normal"
+	}
+
+	ts, count := obj.LastTimestamp, obj.Count
+	if obj.Series != nil {
+		ts, count = obj.Series.LastObservedTime, obj.Series.Count
+	}
+	for _, t := range []time.Time{obj.EventTime, obj.FirstTimestamp, obj.Metadata.CreationTimestamp} {
+		if ts.IsZero() {
+			ts = t
+		}
+	}
+
+	namespace := obj.InvolvedObject.Namespace
+	if namespace == "" {
+		namespace = obj.Metadata.Namespace
+	}
+	component := obj.ReportingComponent
+	if component == "" {
+		component = obj.Source.Component
+	}
+
+	tags := make(map[string]string)
+	for key, value := range map[string]string{
+		"namespace": namespace,
+		"kind":      obj.InvolvedObject.Kind,
+		"name":      obj.InvolvedObject.Name,
+		"reason":    obj.Reason,
+		"component": component,
+		"node":      obj.Source.Host,
+	} {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+	if obj.InvolvedObject.Kind == "Pod" && obj.InvolvedObject.Name != "" {
+		tags["pod"] = obj.InvolvedObject.Name
+	}
+
+	data := map[string]any{
+		"message": obj.Message,
+		"count":   max(count, 1),
+		"event":   obj.Metadata.Name,
+	}
+	if obj.InvolvedObject.UID != "" {
+		data["object_uid"] = obj.InvolvedObject.UID
+	}
+	if obj.InvolvedObject.FieldPath != "" {
+		data["field_path"] = obj.InvolvedObject.FieldPath
+	}
+
+	return squid.Event{
+		Timestamp: ts,
+		Type:      w.opts.Type + "." + kind,
+		Tags:      tags,
+		Data:      data,
+	}
+}
+
+// commit appends events with the state after them.
+func (w *Watcher) commit(events []squid.Event, st state) error {
+	val, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	meta := map[string][]byte{statePrefix + w.opts.Name: val}
+	if len(events) == 0 {
+		return w.db.UpdateMeta(meta)
+	}
+	_, err = w.db.AppendBatchWithMeta(events, meta)
+	return err
+}
+
+// load returns the stored state.
+func (w *Watcher) load() (state, error) {
+	var st state
+	val, err := w.db.GetMeta(statePrefix + w.opts.Name)
+	if errors.Is(err, squid.ErrMetaNotFound) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(val, &st); err != nil {
+		return st, fmt.Errorf("squidkube: watch state of %s: %w", w.opts.Name, err)
+	}
+	return st, nil
+}
+
+// later returns the later of two times.
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
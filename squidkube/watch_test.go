@@ -0,0 +1,184 @@
+package squidkube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// kubeEventJSON returns a core/v1 Event occurring at minute m.
+func kubeEventJSON(name, rv string, m int) string {
+	return fmt.Sprintf(`{"metadata":{"name":%q,"namespace":"shop","resourceVersion":%q},
+		"involvedObject":{"kind":"Pod","namespace":"shop","name":"cart-1","uid":"u1"},
+		"reason":"BackOff","message":"Back-off restarting failed container","type":"Warning","count":%d,
+		"lastTimestamp":"2024-01-01T10:%02d:00Z","source":{"component":"kubelet","host":"node-1"}}`, name, rv, m, m)
+}
+
+// fakeAPIServer serves Events from a list, a watch, an expired watch, and
+// a second list.
+type fakeAPIServer struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	s.requests = append(s.requests, fmt.Sprintf("watch=%s rv=%s continue=%s", q.Get("watch"), q.Get("resourceVersion"), q.Get("continue")))
+	s.mu.Unlock()
+
+	if r.URL.Path != "/api/v1/namespaces/shop/events" || r.Header.Get("Authorization") != "Bearer t0ken" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case q.Get("watch") == "" && q.Get("continue") == "" && q.Get("resourceVersion") == "" && len(s.requests) == 1:
+		fmt.Fprintf(w, `{"metadata":{"continue":"c1"},"items":[%s]}`, kubeEventJSON("a", "10", 1))
+	case q.Get("continue") == "c1":
+		fmt.Fprintf(w, `{"metadata":{"resourceVersion":"100"},"items":[%s]}`, kubeEventJSON("b", "20", 3))
+	case q.Get("resourceVersion") == "100":
+		fmt.Fprintf(w, `{"type":"ADDED","object":%s}`+"\n", kubeEventJSON("c", "101", 5))
+		fmt.Fprintln(w, `{"type":"BOOKMARK","object":{"metadata":{"resourceVersion":"105"}}}`)
+	case q.Get("resourceVersion") == "105":
+		fmt.Fprintln(w, `{"type":"ERROR","object":{"kind":"Status","code":410,"reason":"Expired","message":"too old resource version"}}`)
+	case q.Get("watch") == "":
+		// Relisted after the watch expired: only d is new
+		fmt.Fprintf(w, `{"metadata":{"resourceVersion":"200"},"items":[%s,%s]}`, kubeEventJSON("a", "10", 1), kubeEventJSON("d", "150", 7))
+	default:
+		<-r.Context().Done()
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	db := openTestDB(t)
+	api := &fakeAPIServer{}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	w := New(db, &Config{Host: srv.URL, Token: "t0ken"}, Options{
+		Namespace:     "shop",
+		FlushInterval: 10 * time.Millisecond,
+		OnError:       func(err error) { t.Errorf("unexpected error: %v", err) },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.Run(ctx) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if n, _ := db.Count(); n >= 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for events")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // No duplicates follow
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	events, err := db.Query(context.Background(), squid.Query{Types: []string{"kube.warning"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	var minutes []int
+	for _, e := range events {
+		minutes = append(minutes, e.Timestamp.Minute())
+	}
+	if want := []int{1, 3, 5, 7}; !reflect.DeepEqual(minutes, want) {
+		t.Errorf("expected events at minutes %v, got %v", want, minutes)
+	}
+
+	e := events[0]
+	wantTags := map[string]string{"namespace": "shop", "kind": "Pod", "name": "cart-1", "pod": "cart-1", "reason": "BackOff", "component": "kubelet", "node": "node-1"}
+	if !reflect.DeepEqual(e.Tags, wantTags) {
+		t.Errorf("expected tags %v, got %v", wantTags, e.Tags)
+	}
+	wantData := map[string]any{"message": "Back-off restarting failed container", "count": 1.0, "event": "a", "object_uid": "u1"}
+	if !reflect.DeepEqual(e.Data, wantData) {
+		t.Errorf("expected data %v, got %v", wantData, e.Data)
+	}
+
+	st, err := w.load()
+	if err != nil || st.ResourceVersion != "200" {
+		t.Errorf("expected resource version 200, got %+v, %v", st, err)
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	want := []string{
+		"watch= rv= continue=",
+		"watch= rv= continue=c1",
+		"watch=true rv=100 continue=",
+		"watch=true rv=105 continue=",
+		"watch= rv= continue=",
+		"watch=true rv=200 continue=",
+	}
+	if !reflect.DeepEqual(api.requests, want) {
+		t.Errorf("expected requests %q, got %q", want, api.requests)
+	}
+}
+
+func TestWatcherResume(t *testing.T) {
+	db := openTestDB(t)
+	var rvs []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rvs = append(rvs, r.URL.Query().Get("resourceVersion"))
+		http.Error(w, `{"kind":"Status","code":410,"message":"too old"}`, http.StatusGone)
+	}))
+	defer srv.Close()
+
+	w := New(db, &Config{Host: srv.URL}, Options{Name: "prod"})
+	if err := w.commit(nil, state{ResourceVersion: "42"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A stored resource version is watched from, and cleared when expired
+	if err := w.sync(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if st, _ := w.load(); st.ResourceVersion != "" {
+		t.Errorf("expected the resource version cleared, got %q", st.ResourceVersion)
+	}
+
+	// A failed list is returned as a *StatusError
+	var status *StatusError
+	if err := w.sync(context.Background()); !errors.As(err, &status) || status.Message != "too old" {
+		t.Errorf("expected a StatusError, got %v", err)
+	}
+	if want := []string{"42", ""}; !reflect.DeepEqual(rvs, want) {
+		t.Errorf("expected resource versions %q, got %q", want, rvs)
+	}
+}
@@ -0,0 +1,192 @@
+package squidreport
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Chart dimensions in SVG user units.
+const (
+	chartWidth  = 720
+	chartHeight = 160
+)
+
+// series is one set of values drawn on a chart.
+type series struct {
+	Label  string
+	Class  string
+	Values []float64
+}
+
+// chart is a bar or line chart of series sharing a y axis.
+type chart struct {
+	Title  string
+	Max    string // label of the top of the y axis
+	Bars   []bar
+	Lines  []line
+	Legend []series
+}
+
+// bar is a rectangle of a bar chart.
+type bar struct {
+	X, Y, W, H float64
+	Class      string
+	Tooltip    string
+}
+
+// line is the polyline of a series.
+type line struct {
+	Points string
+	Class  string
+}
+
+// newChart lays out series as overlapping bars or as lines. labels name the
+// intervals in tooltips; format formats values.
+func newChart(title string, bars bool, labels []string, format func(float64) string, ss ...series) chart {
+	c := chart{Title: title, Legend: ss}
+
+	var top float64
+	for _, s := range ss {
+		for _, v := range s.Values {
+			top = max(top, v)
+		}
+	}
+	c.Max = format(top)
+	if top == 0 {
+		top = 1
+	}
+
+	for _, s := range ss {
+		n := len(s.Values)
+		if n == 0 {
+			continue
+		}
+		w := float64(chartWidth) / float64(n)
+		var points []string
+		for i, v := range s.Values {
+			h := v / top * chartHeight
+			if bars {
+				c.Bars = append(c.Bars, bar{
+					X: float64(i)*w + 1, Y: chartHeight - h, W: max(w-2, 1), H: h,
+					Class:   s.Class,
+					Tooltip: fmt.Sprintf("%s %s: %s", labels[i], s.Label, format(v)),
+				})
+				continue
+			}
+			points = append(points, fmt.Sprintf("%.1f,%.1f", float64(i)*w+w/2, chartHeight-h))
+		}
+		if !bars {
+			c.Lines = append(c.Lines, line{Points: strings.Join(points, " "), Class: s.Class})
+		}
+	}
+	return c
+}
+
+// charts returns the charts of the report.
+func (r *Report) charts() []chart {
+	labels := make([]string, len(r.Intervals))
+	events := make([]float64, len(r.Intervals))
+	errs := make([]float64, len(r.Intervals))
+	rates := make([]float64, len(r.Intervals))
+	var p50, p95, p99 []float64
+	var latencies int64
+	for i, in := range r.Intervals {
+		labels[i] = in.Start.Format("Jan 2 15:04")
+		events[i] = float64(in.Events)
+		errs[i] = float64(in.Errors)
+		rates[i] = in.ErrorRate() * 100
+		p50 = append(p50, in.Latency.P50)
+		p95 = append(p95, in.Latency.P95)
+		p99 = append(p99, in.Latency.P99)
+		latencies += in.Latency.Count
+	}
+
+	count := func(v float64) string { return fmt.Sprintf("%.0f", v) }
+	percent := func(v float64) string { return fmt.Sprintf("%.1f%%", v) }
+	value := func(v float64) string { return fmt.Sprintf("%.4g", v) }
+
+	charts := []chart{
+		newChart("Events", true, labels, count,
+			series{"events", "events", events},
+			series{"errors", "errors", errs}),
+		newChart("Error rate", false, labels, percent,
+			series{"error rate", "errors", rates}),
+	}
+	if latencies > 0 {
+		charts = append(charts, newChart(r.LatencyField+" percentiles", false, labels, value,
+			series{"p50", "p50", p50},
+			series{"p95", "p95", p95},
+			series{"p99", "p99", p99}))
+	}
+	return charts
+}
+
+// WriteHTML writes the report as a self-contained HTML page without scripts
+// or external resources, so it can be mailed or archived as it is.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, struct {
+		*Report
+		Charts []chart
+	}{r, r.charts()})
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.2f%%", f*100) },
+	"time":    func(t time.Time) string { return t.Format("2006-01-02 15:04 MST") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; max-width: 760px; margin: 2em auto; padding: 0 1em; }
+h1 { margin-bottom: 0.2em; }
+.range { color: #666; margin-top: 0; }
+.totals td { padding-right: 2em; font-size: 1.3em; }
+.totals th { text-align: left; color: #666; font-weight: normal; }
+table.types { border-collapse: collapse; }
+table.types td, table.types th { padding: 0.2em 1em 0.2em 0; text-align: left; }
+table.types td.n { text-align: right; }
+svg { display: block; margin: 0.5em 0; background: #fafafa; }
+.events { fill: #4c78a8; }
+.errors { fill: #e45756; stroke: #e45756; }
+.p50 { stroke: #54a24b; }
+.p95 { stroke: #f58518; }
+.p99 { stroke: #b279a2; }
+polyline { fill: none; stroke-width: 2; }
+.legend span { display: inline-block; margin-right: 1em; }
+.legend i { display: inline-block; width: 0.8em; height: 0.8em; margin-right: 0.3em; background: currentColor; }
+.legend .events { color: #4c78a8; } .legend .errors { color: #e45756; }
+.legend .p50 { color: #54a24b; } .legend .p95 { color: #f58518; } .legend .p99 { color: #b279a2; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="range">{{time .Start}} to {{time .End}}</p>
+
+<table class="totals">
+<tr><th>Events</th><th>Errors</th><th>Error rate</th></tr>
+<tr><td>{{.Events}}</td><td>{{.Errors}}</td><td>{{percent .ErrorRate}}</td></tr>
+</table>
+
+<h2>Top types</h2>
+{{if .TopTypes}}<table class="types">
+<tr><th>Type</th><th>Events</th></tr>
+{{range .TopTypes}}<tr><td>{{.Type}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>
+{{else}}<p>No events.</p>
+{{end}}
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<div class="legend">{{range .Legend}}<span class="{{.Class}}"><i></i>{{.Label}}</span>{{end}} <span>max {{.Max}}</span></div>
+<svg viewBox="0 0 720 160" width="720" height="160" role="img" aria-label="{{.Title}}">
+{{range .Bars}}<rect x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" width="{{printf "%.1f" .W}}" height="{{printf "%.1f" .H}}" class="{{.Class}}"><title>{{.Tooltip}}</title></rect>
+{{end}}{{range .Lines}}<polyline points="{{.Points}}" class="{{.Class}}"/>
+{{end}}</svg>
+{{end}}
+</body>
+</html>
+`))
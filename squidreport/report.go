@@ -0,0 +1,216 @@
+// Package squidreport summarizes recent events as a static HTML page: the
+// busiest event types, error rates and latency percentiles over time. It
+// suits daily summaries mailed from cron, and backs the squid report
+// command:
+//
+//	report, err := squidreport.Build(ctx, db, squidreport.Options{Since: 24 * time.Hour})
+//	if err != nil {
+//		return err
+//	}
+//	return report.WriteHTML(w)
+package squidreport
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// Source is the part of the database API a report reads. It is implemented
+// by *squid.DB and by the databases of squidclient.
+type Source interface {
+	Query(context.Context, squid.Query) ([]*squid.Event, error)
+	Aggregate(context.Context, squid.Query, string, []squid.AggregationType) (*squid.AggregateResult, error)
+}
+
+// Options configures a report.
+type Options struct {
+	// Title is the heading of the report. Defaults to "Squid report".
+	Title string
+
+	// Since is how far back the report looks. Defaults to 24 hours.
+	Since time.Duration
+
+	// Intervals is the number of intervals the charts split the report's
+	// time range into. Defaults to 24.
+	Intervals int
+
+	// TopTypes is the number of event types listed by count. Defaults to 10.
+	TopTypes int
+
+	// ErrorTypes are the event types counted as errors. Defaults to "error".
+	ErrorTypes []string
+
+	// LatencyField is the data field whose percentiles are charted.
+	// Defaults to "duration_ms".
+	LatencyField string
+
+	// LatencyTypes restricts the latency percentiles to events of the given
+	// types (empty means all types).
+	LatencyTypes []string
+
+	// Now returns the current time, which ends the report. Defaults to
+	// time.Now.
+	Now func() time.Time
+}
+
+// withDefaults returns the options with defaults applied.
+func (o Options) withDefaults() Options {
+	if o.Title == "" {
+		o.Title = "Squid report"
+	}
+	if o.Since == 0 {
+		o.Since = 24 * time.Hour
+	}
+	if o.Intervals == 0 {
+		o.Intervals = 24
+	}
+	if o.TopTypes == 0 {
+		o.TopTypes = 10
+	}
+	if o.ErrorTypes == nil {
+		o.ErrorTypes = []string{"error"}
+	}
+	if o.LatencyField == "" {
+		o.LatencyField = "duration_ms"
+	}
+	if o.Now == nil {
+		o.Now = time.Now
+	}
+	return o
+}
+
+// Report is a summary of the events in a time range.
+type Report struct {
+	Title string    `json:"title"`
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Events is the number of events in the range, and Errors the number
+	// of them with one of the error types.
+	Events int64 `json:"events"`
+	Errors int64 `json:"errors"`
+
+	// TopTypes are the most frequent event types, most frequent first.
+	TopTypes []TypeCount `json:"top_types"`
+
+	// LatencyField is the data field of the latency percentiles.
+	LatencyField string `json:"latency_field"`
+
+	// Intervals split the range into equal parts, oldest first.
+	Intervals []Interval `json:"intervals"`
+}
+
+// ErrorRate returns the fraction of events that are errors.
+func (r *Report) ErrorRate() float64 {
+	return rate(r.Errors, r.Events)
+}
+
+// TypeCount is the number of events of one type.
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// Interval summarizes the events of one part of a report's range.
+type Interval struct {
+	Start time.Time `json:"start"`
+
+	Events int64 `json:"events"`
+	Errors int64 `json:"errors"`
+
+	// Latency holds the percentiles of the latency field; its Count is the
+	// number of events with a latency.
+	Latency squid.AggregateResult `json:"latency"`
+}
+
+// ErrorRate returns the fraction of the interval's events that are errors.
+func (i *Interval) ErrorRate() float64 {
+	return rate(i.Errors, i.Events)
+}
+
+// rate returns n/total, or 0 if total is 0.
+func rate(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// Build summarizes the events of the last opts.Since. It reads the events
+// of the range without their data, so its cost grows with their number.
+func Build(ctx context.Context, src Source, opts Options) (*Report, error) {
+	opts = opts.withDefaults()
+	if opts.Since < 0 || opts.Intervals < 0 || opts.TopTypes < 0 {
+		return nil, errors.New("squidreport: Since, Intervals and TopTypes must not be negative")
+	}
+
+	end := opts.Now()
+	start := end.Add(-opts.Since)
+	step := opts.Since / time.Duration(opts.Intervals)
+	if step <= 0 {
+		return nil, errors.New("squidreport: too many intervals")
+	}
+
+	r := &Report{
+		Title:        opts.Title,
+		Start:        start,
+		End:          end,
+		LatencyField: opts.LatencyField,
+		Intervals:    make([]Interval, opts.Intervals),
+	}
+	for i := range r.Intervals {
+		r.Intervals[i].Start = start.Add(time.Duration(i) * step)
+	}
+
+	events, err := src.Query(ctx, squid.Query{Start: &start, End: &end, OmitData: true})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	for _, e := range events {
+		i := min(int(e.Timestamp.Sub(start)/step), len(r.Intervals)-1)
+		isError := slices.Contains(opts.ErrorTypes, e.Type)
+
+		counts[e.Type]++
+		r.Events++
+		r.Intervals[i].Events++
+		if isError {
+			r.Errors++
+			r.Intervals[i].Errors++
+		}
+	}
+
+	for t, n := range counts {
+		r.TopTypes = append(r.TopTypes, TypeCount{Type: t, Count: n})
+	}
+	slices.SortFunc(r.TopTypes, func(a, b TypeCount) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Type, b.Type)
+	})
+	r.TopTypes = r.TopTypes[:min(len(r.TopTypes), opts.TopTypes)]
+
+	percentiles := []squid.AggregationType{squid.Count, squid.P50, squid.P95, squid.P99}
+	for i := range r.Intervals {
+		// Query time ranges are inclusive at both ends
+		from, to := r.Intervals[i].Start, r.Intervals[i].Start.Add(step-time.Nanosecond)
+		if i == len(r.Intervals)-1 {
+			to = end
+		}
+		q := squid.Query{Types: opts.LatencyTypes, Start: &from, End: &to}
+		result, err := src.Aggregate(ctx, q, opts.LatencyField, percentiles)
+		if err != nil {
+			return nil, err
+		}
+		r.Intervals[i].Latency = *result
+	}
+
+	return r, nil
+}
@@ -0,0 +1,65 @@
+package squidreport
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+	"github.com/asungur/squid/squidtest"
+)
+
+func TestBuild(t *testing.T) {
+	db := squidtest.Open(t, squid.Options{})
+
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	hour := func(n int) time.Time { return now.Add(time.Duration(n-24) * time.Hour) }
+	squidtest.Seed(t, db,
+		squid.Event{Timestamp: hour(-1), Type: "request"}, // Before the report
+		squid.Event{Timestamp: hour(0), Type: "request", Data: map[string]any{"duration_ms": 100.0}},
+		squid.Event{Timestamp: hour(0), Type: "request", Data: map[string]any{"duration_ms": 300.0}},
+		squid.Event{Timestamp: hour(0), Type: "error"},
+		squid.Event{Timestamp: hour(12), Type: "signup"},
+		squid.Event{Timestamp: hour(23), Type: "request", Data: map[string]any{"duration_ms": 50.0}},
+	)
+
+	r, err := Build(context.Background(), db, Options{
+		Now:      func() time.Time { return now },
+		TopTypes: 2,
+	})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if r.Events != 5 || r.Errors != 1 || r.ErrorRate() != 0.2 {
+		t.Errorf("expected 5 events and 1 error, got %d and %d", r.Events, r.Errors)
+	}
+	want := []TypeCount{{"request", 3}, {"error", 1}}
+	if len(r.TopTypes) != 2 || r.TopTypes[0] != want[0] || r.TopTypes[1] != want[1] {
+		t.Errorf("expected top types %v, got %v", want, r.TopTypes)
+	}
+
+	if len(r.Intervals) != 24 {
+		t.Fatalf("expected 24 intervals, got %d", len(r.Intervals))
+	}
+	first := r.Intervals[0]
+	if first.Events != 3 || first.Errors != 1 || first.Latency.Count != 2 || first.Latency.P50 != 200 {
+		t.Errorf("unexpected first interval %+v", first)
+	}
+	if last := r.Intervals[23]; last.Events != 1 || last.Latency.P99 != 50 {
+		t.Errorf("unexpected last interval %+v", last)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteHTML(&buf); err != nil {
+		t.Fatalf("WriteHTML failed: %v", err)
+	}
+	html := buf.String()
+	for _, s := range []string{"<title>Squid report</title>", "<td>request</td>", "duration_ms percentiles", "<polyline"} {
+		if !strings.Contains(html, s) {
+			t.Errorf("expected the report to contain %q", s)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package squidserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// Limits protects the database from expensive or excessive reads by
+// clients. Clients are told apart by Client, or else by their remote
+// address. The limits apply to POST /v1/query, POST /v1/aggregate and
+// GET /v1/events.
+type Limits struct {
+	// MaxTimeRange is the longest span between a query's Start and End
+	// (0 means no limit). Queries without both are rejected when set.
+	MaxTimeRange time.Duration
+
+	// MaxResults is the largest Limit plus Offset of a query, as the
+	// events skipped are read too, or page size of GET /v1/events (0 means
+	// no limit). Queries without a Limit are rejected when set;
+	// aggregations are not limited.
+	MaxResults int
+
+	// MaxConcurrentQueries is the number of limited requests a client may
+	// have in progress (0 means no limit). Requests beyond it are rejected
+	// with 429 Too Many Requests.
+	MaxConcurrentQueries int
+
+	// Client returns the identity of the client of a request, such as the
+	// user that authentication middleware in front of the server stored in
+	// the request's context. Defaults to the host of the remote address:
+	// the server authenticates nobody, so a token taken from the request
+	// could be changed on every request to get a fresh quota.
+	Client func(r *http.Request) string
+}
+
+// Names of the limits reported in ErrorResponse.Limit.
+const (
+	LimitTimeRange         = "max_time_range"
+	LimitResults           = "max_results"
+	LimitConcurrentQueries = "max_concurrent_queries"
+)
+
+// limitsState holds the configured limits and the requests in progress
+// per client.
+type limitsState struct {
+	mu       sync.Mutex
+	limits   Limits
+	inflight map[string]int
+}
+
+// SetLimits replaces the limits of the server, which has none until it is
+// called. Requests in progress are not affected.
+func (s *Server) SetLimits(l Limits) {
+	s.limits.mu.Lock()
+	s.limits.limits = l
+	s.limits.mu.Unlock()
+}
+
+// admit checks a request's query against the limits and reserves a
+// concurrent query slot for its client. results is set for reads that
+// return events, which are subject to MaxResults. If the request is
+// rejected, admit writes the error response and returns false; otherwise
+// the caller must call the returned release function when done.
+func (s *Server) admit(w http.ResponseWriter, r *http.Request, q squid.Query, results bool) (func(), bool) {
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+	l := s.limits.limits
+
+	if l.MaxTimeRange > 0 {
+		if q.Start == nil || q.End == nil || q.End.Sub(*q.Start) > l.MaxTimeRange {
+			writeLimitError(w, http.StatusBadRequest, LimitTimeRange,
+				fmt.Sprintf("queries must set start and end at most %s apart", l.MaxTimeRange))
+			return nil, false
+		}
+	}
+
+	if results && l.MaxResults > 0 && (q.Limit <= 0 || q.Limit > l.MaxResults-max(q.Offset, 0)) {
+		writeLimitError(w, http.StatusBadRequest, LimitResults,
+			fmt.Sprintf("queries must set a limit of at most %d, including their offset", l.MaxResults))
+		return nil, false
+	}
+
+	if l.MaxConcurrentQueries <= 0 {
+		return func() {}, true
+	}
+	client := clientOf(r, l)
+	if s.limits.inflight[client] >= l.MaxConcurrentQueries {
+		w.Header().Set("Retry-After", "1")
+		writeLimitError(w, http.StatusTooManyRequests, LimitConcurrentQueries,
+			fmt.Sprintf("at most %d concurrent queries per client", l.MaxConcurrentQueries))
+		return nil, false
+	}
+	if s.limits.inflight == nil {
+		s.limits.inflight = make(map[string]int)
+	}
+	s.limits.inflight[client]++
+
+	return func() {
+		s.limits.mu.Lock()
+		defer s.limits.mu.Unlock()
+		if s.limits.inflight[client]--; s.limits.inflight[client] <= 0 {
+			delete(s.limits.inflight, client)
+		}
+	}, true
+}
+
+// streamPageSize returns the page size of GET /v1/events when the request
+// sets none: defaultPageSize, or MaxResults if lower.
+func (s *Server) streamPageSize() int {
+	s.limits.mu.Lock()
+	defer s.limits.mu.Unlock()
+
+	if limit := s.limits.limits.MaxResults; limit > 0 && limit < defaultPageSize {
+		return limit
+	}
+	return defaultPageSize
+}
+
+// clientOf returns the client of a request that the limits apply to.
+func clientOf(r *http.Request, l Limits) string {
+	if l.Client != nil {
+		return l.Client(r)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// writeLimitError writes the response for a request rejected by a limit.
+func writeLimitError(w http.ResponseWriter, status int, limit, msg string) {
+	code := CodeLimitExceeded
+	if status == http.StatusTooManyRequests {
+		code = CodeTooManyRequests
+	}
+	writeJSON(w, status, ErrorResponse{Error: "squidserver: " + msg, Code: code, Limit: limit})
+}
@@ -0,0 +1,141 @@
+package squidserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func TestLimits(t *testing.T) {
+	db, _ := newTestServer(t)
+	s := New(db)
+	s.SetLimits(Limits{MaxTimeRange: time.Hour, MaxResults: 100})
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	post := func(path, body string) (int, ErrorResponse) {
+		t.Helper()
+		resp, err := http.Post(srv.URL+path, "application/json", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var e ErrorResponse
+		if resp.StatusCode != http.StatusOK {
+			json.NewDecoder(resp.Body).Decode(&e)
+		}
+		return resp.StatusCode, e
+	}
+
+	tests := []struct {
+		path, body string
+		status     int
+		limit      string
+	}{
+		{"/v1/query", `{"limit":10}`, http.StatusBadRequest, LimitTimeRange},
+		{"/v1/query", `{"start":"2024-01-01T00:00:00Z","end":"2024-01-02T00:00:00Z","limit":10}`, http.StatusBadRequest, LimitTimeRange},
+		{"/v1/query", `{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T01:00:00Z"}`, http.StatusBadRequest, LimitResults},
+		{"/v1/query", `{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T01:00:00Z","limit":1000}`, http.StatusBadRequest, LimitResults},
+		{"/v1/query", `{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T01:00:00Z","limit":100}`, http.StatusOK, ""},
+		{"/v1/query", `{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T01:00:00Z","limit":10,"offset":1000000}`, http.StatusBadRequest, LimitResults},
+		{"/v1/query", `{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T01:00:00Z","limit":10,"offset":90}`, http.StatusOK, ""},
+		{"/v1/aggregate", `{"query":{"start":"2024-01-01T00:00:00Z","end":"2024-01-01T01:00:00Z"},"aggregations":["count"]}`, http.StatusOK, ""},
+		{"/v1/aggregate", `{"query":{},"aggregations":["count"]}`, http.StatusBadRequest, LimitTimeRange},
+	}
+	for _, tt := range tests {
+		status, e := post(tt.path, tt.body)
+		if status != tt.status || e.Limit != tt.limit {
+			t.Errorf("%s %s: expected %d %q, got %d %q", tt.path, tt.body, tt.status, tt.limit, status, e.Limit)
+		}
+		if tt.limit != "" && e.Code != CodeLimitExceeded {
+			t.Errorf("%s %s: expected code %s, got %s", tt.path, tt.body, CodeLimitExceeded, e.Code)
+		}
+	}
+
+	// Pages of GET /v1/events default to MaxResults
+	resp, err := http.Get(srv.URL + "/v1/events?start=2024-01-01T00:00:00Z&end=2024-01-01T00:30:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 for the default page size, got %d", resp.StatusCode)
+	}
+}
+
+func TestConcurrentQueryLimit(t *testing.T) {
+	s := &Server{}
+	s.SetLimits(Limits{MaxConcurrentQueries: 1})
+
+	request := func(addr, token string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/v1/query", nil)
+		r.RemoteAddr = addr
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		return r
+	}
+
+	release, ok := s.admit(httptest.NewRecorder(), request("10.0.0.1:1234", "a"), squid.Query{}, true)
+	if !ok {
+		t.Fatal("expected the first query to be admitted")
+	}
+
+	// Neither another connection nor another unauthenticated token gives a
+	// client a new quota
+	w := httptest.NewRecorder()
+	if _, ok := s.admit(w, request("10.0.0.1:5678", "b"), squid.Query{}, true); ok {
+		t.Fatal("expected a second concurrent query to be rejected")
+	}
+	var e ErrorResponse
+	json.NewDecoder(w.Body).Decode(&e)
+	if w.Code != http.StatusTooManyRequests || e.Code != CodeTooManyRequests || e.Limit != LimitConcurrentQueries || w.Header().Get("Retry-After") == "" {
+		t.Errorf("unexpected rejection: %d %+v", w.Code, e)
+	}
+
+	// Other clients have their own quota
+	releaseB, ok := s.admit(httptest.NewRecorder(), request("10.0.0.2:1234", "a"), squid.Query{}, true)
+	if !ok {
+		t.Fatal("expected another client's query to be admitted")
+	}
+	releaseB()
+
+	release()
+	release, ok = s.admit(httptest.NewRecorder(), request("10.0.0.1:1234", "a"), squid.Query{}, true)
+	if !ok {
+		t.Fatal("expected a query to be admitted after the first finished")
+	}
+	release()
+	if len(s.limits.inflight) != 0 {
+		t.Errorf("expected no queries in progress, got %v", s.limits.inflight)
+	}
+
+	// Client identifies clients instead of their address
+	s.SetLimits(Limits{MaxConcurrentQueries: 1, Client: func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}})
+	user := func(addr, name string) *http.Request {
+		r := request(addr, "")
+		r.Header.Set("X-User", name)
+		return r
+	}
+	release, ok = s.admit(httptest.NewRecorder(), user("10.0.0.1:1234", "alice"), squid.Query{}, true)
+	if !ok {
+		t.Fatal("expected the first query to be admitted")
+	}
+	if _, ok := s.admit(httptest.NewRecorder(), user("10.0.0.2:1234", "alice"), squid.Query{}, true); ok {
+		t.Error("expected the same user's query from another address to be rejected")
+	}
+	releaseB, ok = s.admit(httptest.NewRecorder(), user("10.0.0.1:1234", "bob"), squid.Query{}, true)
+	if !ok {
+		t.Error("expected another user's query to be admitted")
+	} else {
+		releaseB()
+	}
+	release()
+}
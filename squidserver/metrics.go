@@ -0,0 +1,190 @@
+package squidserver
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// openMetricsContentType is the media type of the OpenMetrics text format.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRe  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// aggregationLabel is the label that tells a metric's aggregations apart.
+const aggregationLabel = "aggregation"
+
+// Metric is an aggregation exposed by GET /metrics. Each aggregation is a
+// gauge sample labelled with its name, for example
+//
+//	squid_errors{service="api",aggregation="count"} 12
+//
+// Metrics sharing a name form one family and must differ in their labels.
+type Metric struct {
+	// Name is the metric family name.
+	Name string
+
+	// Help describes the family. The first non-empty Help of a family is used.
+	Help string
+
+	// Labels are added to every sample of the metric.
+	Labels map[string]string
+
+	// Query selects the events. Its Start and End are replaced by Window.
+	Query squid.Query
+
+	// Window is how far back from the time of a scrape events are aggregated.
+	Window time.Duration
+
+	// Field is the data field aggregated. It may be empty for counts.
+	Field string
+
+	// Aggregations are the values exposed.
+	Aggregations []squid.AggregationType
+}
+
+// validate checks that m can be exposed.
+func (m Metric) validate() error {
+	if !metricNameRe.MatchString(m.Name) {
+		return fmt.Errorf("squidserver: invalid metric name %q", m.Name)
+	}
+	for name := range m.Labels {
+		if !labelNameRe.MatchString(name) || name == aggregationLabel {
+			return fmt.Errorf("squidserver: metric %s: invalid label name %q", m.Name, name)
+		}
+	}
+	if m.Window <= 0 {
+		return fmt.Errorf("squidserver: metric %s: window must be positive", m.Name)
+	}
+	if len(m.Aggregations) == 0 {
+		return fmt.Errorf("squidserver: metric %s: no aggregations", m.Name)
+	}
+	return nil
+}
+
+// SetMetrics replaces the metrics served by GET /metrics, which serves none
+// until it is called. The aggregations run on every scrape, so windows
+// should be short enough to aggregate quickly.
+func (s *Server) SetMetrics(metrics []Metric) error {
+	for _, m := range metrics {
+		if err := m.validate(); err != nil {
+			return err
+		}
+	}
+
+	s.metricsMu.Lock()
+	s.metrics = slices.Clone(metrics)
+	s.metricsMu.Unlock()
+	return nil
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.metricsMu.RLock()
+	metrics := s.metrics
+	s.metricsMu.RUnlock()
+
+	// A family's samples must be contiguous, so group metrics by name
+	var names []string
+	families := make(map[string][]Metric)
+	for _, m := range metrics {
+		if _, ok := families[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		families[m.Name] = append(families[m.Name], m)
+	}
+
+	now := time.Now()
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", name)
+		for _, m := range families[name] {
+			if m.Help != "" {
+				fmt.Fprintf(&buf, "# HELP %s %s\n", name, escapeHelp(m.Help))
+				break
+			}
+		}
+
+		for _, m := range families[name] {
+			q := m.Query
+			start := now.Add(-m.Window)
+			q.Start, q.End = &start, &now
+
+			result, err := s.db.Aggregate(r.Context(), q, m.Field, m.Aggregations)
+			if err != nil {
+				writeError(w, fmt.Errorf("metric %s: %w", name, err))
+				return
+			}
+
+			for _, agg := range m.Aggregations {
+				fmt.Fprintf(&buf, "%s{%s} %s\n", name, formatLabels(m.Labels, agg), formatValue(result, agg))
+			}
+		}
+	}
+	buf.WriteString("# EOF\n")
+
+	w.Header().Set("Content-Type", openMetricsContentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// formatLabels returns the label set of a sample, in sorted order followed
+// by the aggregation.
+func formatLabels(labels map[string]string, agg squid.AggregationType) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		fmt.Fprintf(&b, "%s=\"%s\",", name, escapeLabel(labels[name]))
+	}
+	fmt.Fprintf(&b, "%s=\"%s\"", aggregationLabel, agg)
+	return b.String()
+}
+
+// formatValue returns the value of agg in result.
+func formatValue(result *squid.AggregateResult, agg squid.AggregationType) string {
+	var v float64
+	switch agg {
+	case squid.Count:
+		return strconv.FormatInt(result.Count, 10)
+	case squid.Sum:
+		v = result.Sum
+	case squid.Avg:
+		v = result.Avg
+	case squid.Min:
+		v = result.Min
+	case squid.Max:
+		v = result.Max
+	case squid.P50:
+		v = result.P50
+	case squid.P95:
+		v = result.P95
+	case squid.P99:
+		v = result.P99
+	case squid.ExactSum:
+		if result.ExactSum != "" {
+			return result.ExactSum
+		}
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Escaping of label values and help text in the OpenMetrics text format.
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+// escapeLabel escapes a label value.
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// escapeHelp escapes help text.
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
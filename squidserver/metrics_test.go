@@ -0,0 +1,99 @@
+package squidserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func TestMetrics(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for i, service := range []string{"api", "api", "web", "api"} {
+		event := squid.Event{
+			Type: "error",
+			Tags: map[string]string{"service": service},
+			Data: map[string]any{"latency": float64(i + 1)},
+		}
+		if _, err := db.Append(event); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	old := squid.Event{Type: "error", Tags: map[string]string{"service": "api"}, Timestamp: time.Now().Add(-time.Hour)}
+	if _, err := db.Append(old); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	s := New(db)
+	err = s.SetMetrics([]Metric{
+		{
+			Name:         "squid_errors",
+			Help:         "Errors in the last 5 minutes.",
+			Labels:       map[string]string{"service": "api"},
+			Query:        squid.Query{Types: []string{"error"}, Tags: map[string]string{"service": "api"}},
+			Window:       5 * time.Minute,
+			Field:        "latency",
+			Aggregations: []squid.AggregationType{squid.Count, squid.Max},
+		},
+		{
+			Name:         "squid_errors",
+			Labels:       map[string]string{"service": "web"},
+			Query:        squid.Query{Types: []string{"error"}, Tags: map[string]string{"service": "web"}},
+			Window:       5 * time.Minute,
+			Aggregations: []squid.AggregationType{squid.Count},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SetMetrics failed: %v", err)
+	}
+
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# TYPE squid_errors gauge
+# HELP squid_errors Errors in the last 5 minutes.
+squid_errors{service="api",aggregation="count"} 3
+squid_errors{service="api",aggregation="max"} 4
+squid_errors{service="web",aggregation="count"} 1
+# EOF
+`
+	if string(body) != want {
+		t.Errorf("unexpected metrics:\n%s", body)
+	}
+
+	if err := s.SetMetrics([]Metric{{Name: "bad name", Window: time.Minute, Aggregations: []squid.AggregationType{squid.Count}}}); err == nil {
+		t.Error("expected an invalid name to be rejected")
+	}
+	if err := s.SetMetrics([]Metric{{Name: "squid_errors", Aggregations: []squid.AggregationType{squid.Count}}}); err == nil {
+		t.Error("expected a missing window to be rejected")
+	}
+}
@@ -0,0 +1,311 @@
+// Package squidserver exposes a Squid database over HTTP with JSON bodies.
+//
+// Endpoints:
+//
+//	POST /v1/events        append a single event
+//	GET  /v1/events        stream matching events as NDJSON, a page at a time
+//	POST /v1/events/batch  append a batch of events atomically
+//	GET  /v1/events/{id}   get an event by ID
+//	PUT  /v1/events/{id}   update an event (optimistic concurrency on version)
+//	POST /v1/query         query events
+//	POST /v1/aggregate     aggregate a numeric field over matching events
+//	GET  /v1/count         count all events
+//	POST /v1/subscribe     stream batches of newly appended events as NDJSON
+//	GET  /metrics          aggregations set with SetMetrics, in OpenMetrics text format
+//
+// Errors are returned as {"error": "...", "code": "..."} where code is one of
+// the Code* constants, so clients can map them back to Squid's sentinel errors.
+// Reads can be limited per client with SetLimits.
+package squidserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/asungur/squid"
+	"github.com/oklog/ulid/v2"
+)
+
+// maxBodyBytes bounds the size of request bodies.
+const maxBodyBytes = 32 << 20
+
+// Error codes returned in error responses.
+const (
+	CodeNotFound         = "not_found"
+	CodeEmptyType        = "empty_type"
+	CodeInvalidEvent     = "invalid_event"
+	CodeInvalidQuery     = "invalid_query"
+	CodeCardinalityLimit = "cardinality_limit"
+	CodeDiskFull         = "disk_full"
+	CodeTooManyFiles     = "too_many_files"
+	CodeClosed           = "closed"
+	CodeDuplicateID      = "duplicate_id"
+	CodeVersionConflict  = "version_conflict"
+	CodeSuperseded       = "superseded"
+	CodeCorruptRecord    = "corrupt_record"
+	CodeOverflow         = "subscription_overflow"
+	CodeLegalHold        = "legal_hold"
+	CodeTxConflict       = "tx_conflict"
+	CodeLimitExceeded    = "limit_exceeded"
+	CodeTooManyRequests  = "too_many_requests"
+	CodeInternal         = "internal"
+)
+
+// AggregateRequest is the body of POST /v1/aggregate.
+type AggregateRequest struct {
+	Query        squid.Query             `json:"query"`
+	Field        string                  `json:"field"`
+	Aggregations []squid.AggregationType `json:"aggregations"`
+}
+
+// CountResponse is the body returned by GET /v1/count.
+type CountResponse struct {
+	Count int64 `json:"count"`
+}
+
+// ErrorResponse is the body returned for failed requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+
+	// Limit names the limit a request exceeded (one of the Limit*
+	// constants), for the limit_exceeded and too_many_requests codes.
+	Limit string `json:"limit,omitempty"`
+}
+
+// Server serves a Squid database over HTTP.
+type Server struct {
+	db  *squid.DB
+	mux *http.ServeMux
+
+	metricsMu sync.RWMutex
+	metrics   []Metric
+
+	limits limitsState
+
+	// subs is cancelled by EndSubscriptions
+	subs    context.Context
+	endSubs context.CancelFunc
+}
+
+// New creates a Server for db.
+func New(db *squid.DB) *Server {
+	s := &Server{
+		db:  db,
+		mux: http.NewServeMux(),
+	}
+	s.subs, s.endSubs = context.WithCancel(context.Background())
+
+	s.mux.HandleFunc("POST /v1/events", s.handleAppend)
+	s.mux.HandleFunc("GET /v1/events", s.handleStream)
+	s.mux.HandleFunc("POST /v1/events/batch", s.handleAppendBatch)
+	s.mux.HandleFunc("GET /v1/events/{id}", s.handleGet)
+	s.mux.HandleFunc("PUT /v1/events/{id}", s.handleUpdate)
+	s.mux.HandleFunc("POST /v1/query", s.handleQuery)
+	s.mux.HandleFunc("POST /v1/aggregate", s.handleAggregate)
+	s.mux.HandleFunc("GET /v1/count", s.handleCount)
+	s.mux.HandleFunc("POST /v1/subscribe", s.handleSubscribe)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleAppend(w http.ResponseWriter, r *http.Request) {
+	var event squid.Event
+	if !decodeBody(w, r, &event) {
+		return
+	}
+
+	result, err := s.db.Append(event)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, result)
+}
+
+func (s *Server) handleAppendBatch(w http.ResponseWriter, r *http.Request) {
+	var events []squid.Event
+	if !decodeBody(w, r, &events) {
+		return
+	}
+
+	results, err := s.db.AppendBatch(events)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if results == nil {
+		results = []*squid.AppendResult{}
+	}
+
+	writeJSON(w, http.StatusCreated, results)
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	id, err := ulid.ParseStrict(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInvalidQuery})
+		return
+	}
+
+	get := s.db.Get
+	if r.URL.Query().Get("latest") == "true" {
+		get = s.db.Latest
+	}
+	event, err := get(id)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, event)
+}
+
+func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := ulid.ParseStrict(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInvalidQuery})
+		return
+	}
+
+	var event squid.Event
+	if !decodeBody(w, r, &event) {
+		return
+	}
+	event.ID = id
+
+	updated, err := s.db.Update(event)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var q squid.Query
+	if !decodeBody(w, r, &q) {
+		return
+	}
+	release, ok := s.admit(w, r, q, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	events, err := s.db.Query(r.Context(), q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if events == nil {
+		events = []*squid.Event{}
+	}
+
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) handleAggregate(w http.ResponseWriter, r *http.Request) {
+	var req AggregateRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	release, ok := s.admit(w, r, req.Query, false)
+	if !ok {
+		return
+	}
+	defer release()
+
+	result, err := s.db.Aggregate(r.Context(), req.Query, req.Field, req.Aggregations)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleCount(w http.ResponseWriter, r *http.Request) {
+	count, err := s.db.Count()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CountResponse{Count: count})
+}
+
+// decodeBody decodes a JSON request body into v.
+// It writes an error response and returns false on failure.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodyBytes)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInvalidQuery})
+		return false
+	}
+	return true
+}
+
+// writeError maps a Squid error to an HTTP status and error code.
+func writeError(w http.ResponseWriter, err error) {
+	status, code := errorCode(err)
+	writeJSON(w, status, ErrorResponse{Error: err.Error(), Code: code})
+}
+
+// errorCode returns the HTTP status and error code of a Squid error.
+func errorCode(err error) (int, string) {
+	status, code := http.StatusInternalServerError, CodeInternal
+
+	switch {
+	case errors.Is(err, squid.ErrNotFound):
+		status, code = http.StatusNotFound, CodeNotFound
+	case errors.Is(err, squid.ErrEmptyType):
+		status, code = http.StatusBadRequest, CodeEmptyType
+	case errors.Is(err, squid.ErrInvalidTag), errors.Is(err, squid.ErrKeyTooLong), errors.Is(err, squid.ErrInvalidID),
+		errors.Is(err, squid.ErrFutureTimestamp), errors.Is(err, squid.ErrInvalidJSON), errors.Is(err, squid.ErrInvalidData):
+		status, code = http.StatusBadRequest, CodeInvalidEvent
+	case errors.Is(err, squid.ErrCardinalityLimit):
+		status, code = http.StatusUnprocessableEntity, CodeCardinalityLimit
+	case errors.Is(err, squid.ErrInvalidQuery), errors.Is(err, squid.ErrTooManyValues):
+		status, code = http.StatusBadRequest, CodeInvalidQuery
+	case errors.Is(err, squid.ErrDiskFull):
+		status, code = http.StatusInsufficientStorage, CodeDiskFull
+	case errors.Is(err, squid.ErrTooManyFiles):
+		status, code = http.StatusServiceUnavailable, CodeTooManyFiles
+	case errors.Is(err, squid.ErrClosed):
+		status, code = http.StatusServiceUnavailable, CodeClosed
+	case errors.Is(err, squid.ErrDuplicateID):
+		status, code = http.StatusConflict, CodeDuplicateID
+	case errors.Is(err, squid.ErrVersionConflict):
+		status, code = http.StatusConflict, CodeVersionConflict
+	case errors.Is(err, squid.ErrSuperseded):
+		status, code = http.StatusConflict, CodeSuperseded
+	case errors.Is(err, squid.ErrLegalHold):
+		status, code = http.StatusConflict, CodeLegalHold
+	case errors.Is(err, squid.ErrTxConflict):
+		status, code = http.StatusConflict, CodeTxConflict
+	case errors.Is(err, squid.ErrCorruptRecord):
+		code = CodeCorruptRecord
+	case errors.Is(err, squid.ErrSubscriptionOverflow):
+		code = CodeOverflow
+	}
+	return status, code
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
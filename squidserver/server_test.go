@@ -0,0 +1,126 @@
+package squidserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/asungur/squid"
+)
+
+func newTestServer(t *testing.T) (*squid.DB, *httptest.Server) {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	srv := httptest.NewServer(New(db))
+	t.Cleanup(srv.Close)
+
+	return db, srv
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	body := `{"type":"request","tags":{"service":"api"},"data":{"status":200}}`
+	resp, err := http.Post(srv.URL+"/v1/events", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/v1/query", "application/json", bytes.NewBufferString(`{"types":["request"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var events []*squid.Event
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Tags["service"] != "api" {
+		t.Errorf("unexpected query result: %+v", events)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		code   string
+	}{
+		{"empty type", http.MethodPost, "/v1/events", `{}`, http.StatusBadRequest, CodeEmptyType},
+		{"invalid tag", http.MethodPost, "/v1/events", `{"type":"a","tags":{"":"v"}}`, http.StatusBadRequest, CodeInvalidEvent},
+		{"malformed body", http.MethodPost, "/v1/query", `{`, http.StatusBadRequest, CodeInvalidQuery},
+		{"bad id", http.MethodGet, "/v1/events/nope", "", http.StatusBadRequest, CodeInvalidQuery},
+		{"not found", http.MethodGet, "/v1/events/01ARZ3NDEKTSV4RRFFQ69G5FAV", "", http.StatusNotFound, CodeNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+			var e ErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Code != tt.code {
+				t.Errorf("expected code %q, got %q", tt.code, e.Code)
+			}
+		})
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{fmt.Errorf("%w: commit: %v", squid.ErrTxConflict, "conflict"), http.StatusConflict, CodeTxConflict},
+		{squid.ErrTooManyFiles, http.StatusServiceUnavailable, CodeTooManyFiles},
+		{squid.ErrDiskFull, http.StatusInsufficientStorage, CodeDiskFull},
+		{squid.ErrVersionConflict, http.StatusConflict, CodeVersionConflict},
+		{errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+	}
+	for _, tt := range tests {
+		if status, code := errorCode(tt.err); status != tt.status || code != tt.code {
+			t.Errorf("%v: expected %d %q, got %d %q", tt.err, tt.status, tt.code, status, code)
+		}
+	}
+}
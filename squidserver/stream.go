@@ -0,0 +1,220 @@
+package squidserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+	"github.com/oklog/ulid/v2"
+)
+
+// Page sizes of GET /v1/events.
+const (
+	defaultPageSize = 1000
+	maxPageSize     = 10000
+)
+
+// streamFlushEvery is the number of events written between flushes of a
+// streamed page.
+const streamFlushEvery = 100
+
+// ndjsonContentType is the media type of newline-delimited JSON.
+const ndjsonContentType = "application/x-ndjson"
+
+// handleStream serves GET /v1/events: a page of the events matching the
+// query parameters, streamed as NDJSON, with a Link header to the next page.
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q, pageSize, err := parseStreamQuery(params)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInvalidQuery})
+		return
+	}
+	if params.Get("page_size") == "" {
+		pageSize = s.streamPageSize()
+	}
+	q.Limit = pageSize
+	release, ok := s.admit(w, r, q, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// Fetch one more event than the page holds to learn if there is a next page
+	q.Limit = pageSize + 1
+	events, err := s.db.Query(r.Context(), q)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		next := *r.URL
+		nextParams := next.Query()
+		nextParams.Set("cursor", encodeCursor(events[pageSize-1].ID))
+		next.RawQuery = nextParams.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
+	}
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	for i, e := range events {
+		if err := enc.Encode(e); err != nil {
+			return // The client went away
+		}
+		if flusher != nil && (i+1)%streamFlushEvery == 0 {
+			flusher.Flush()
+		}
+	}
+}
+
+// parseStreamQuery builds the query of GET /v1/events from its parameters:
+// type, tag (key:value), where (field:op:value), has_field and
+// missing_field may be repeated, start, end, ingested_start and ingested_end
+// are RFC 3339 times, min_id and max_id are ULIDs, and page_size,
+// descending, omit_data and cursor control paging. The cursor replaces the
+// bound it resumes from.
+func parseStreamQuery(params url.Values) (squid.Query, int, error) {
+	q := squid.Query{
+		Types:         params["type"],
+		TypePattern:   params.Get("type_pattern"),
+		ExcludeTypes:  params["exclude_type"],
+		HasFields:     params["has_field"],
+		MissingFields: params["missing_field"],
+		Search:        params.Get("search"),
+		SearchTags:    params.Get("search_tags") == "true",
+		Latest:        params.Get("latest") == "true",
+		Descending:    params.Get("descending") == "true",
+		OmitData:      params.Get("omit_data") == "true",
+	}
+
+	for name, dst := range map[string]*map[string]string{"tag": &q.Tags, "exclude_tag": &q.ExcludeTags} {
+		for _, tag := range params[name] {
+			k, v, ok := strings.Cut(tag, ":")
+			if !ok || k == "" {
+				return q, 0, fmt.Errorf("invalid %s %q, expected key:value", name, tag)
+			}
+			if *dst == nil {
+				*dst = make(map[string]string)
+			}
+			(*dst)[k] = v
+		}
+	}
+
+	for _, where := range params["where"] {
+		p, err := parsePredicate(where)
+		if err != nil {
+			return q, 0, err
+		}
+		q.Where = append(q.Where, p)
+	}
+
+	times := map[string]**time.Time{
+		"start":          &q.Start,
+		"end":            &q.End,
+		"ingested_start": &q.IngestedStart,
+		"ingested_end":   &q.IngestedEnd,
+	}
+	for name, dst := range times {
+		if s := params.Get(name); s != "" {
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return q, 0, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*dst = &t
+		}
+	}
+
+	for name, dst := range map[string]**ulid.ULID{"min_id": &q.MinID, "max_id": &q.MaxID} {
+		if s := params.Get(name); s != "" {
+			id, err := ulid.ParseStrict(s)
+			if err != nil {
+				return q, 0, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*dst = &id
+		}
+	}
+
+	pageSize := defaultPageSize
+	if s := params.Get("page_size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return q, 0, fmt.Errorf("page_size must be between 1 and %d", maxPageSize)
+		}
+		pageSize = n
+	}
+
+	if s := params.Get("cursor"); s != "" {
+		id, err := decodeCursor(s)
+		if err != nil {
+			return q, 0, err
+		}
+		// Resume after the last event of the previous page
+		if q.Descending {
+			if id == (ulid.ULID{}) {
+				return q, 0, fmt.Errorf("invalid cursor")
+			}
+			prev := adjacentID(id, -1)
+			q.MaxID = &prev
+		} else {
+			next := adjacentID(id, 1)
+			q.MinID = &next
+		}
+	}
+
+	return q, pageSize, nil
+}
+
+// parsePredicate parses a where parameter, field:op:value. A value that is
+// valid JSON, such as 500, true or "500", is decoded; any other value is a
+// string.
+func parsePredicate(s string) (squid.Predicate, error) {
+	field, rest, ok1 := strings.Cut(s, ":")
+	op, value, ok2 := strings.Cut(rest, ":")
+	if !ok1 || !ok2 || field == "" {
+		return squid.Predicate{}, fmt.Errorf("invalid where %q, expected field:op:value", s)
+	}
+
+	p := squid.Predicate{Field: field, Op: squid.Op(op), Value: value}
+	var v any
+	if err := json.Unmarshal([]byte(value), &v); err == nil {
+		p.Value = v
+	}
+	return p, nil
+}
+
+// encodeCursor returns the cursor of a page ending with the event id.
+// Cursors are opaque to clients.
+func encodeCursor(id ulid.ULID) string {
+	return id.String()
+}
+
+// decodeCursor returns the ID of the last event of the previous page.
+func decodeCursor(s string) (ulid.ULID, error) {
+	id, err := ulid.ParseStrict(s)
+	if err != nil {
+		return ulid.ULID{}, fmt.Errorf("invalid cursor")
+	}
+	return id, nil
+}
+
+// adjacentID returns the ID that follows (delta 1) or precedes (delta -1) id.
+func adjacentID(id ulid.ULID, delta int) ulid.ULID {
+	for i := len(id) - 1; i >= 0; i-- {
+		old := id[i]
+		id[i] += byte(delta)
+		if (delta > 0 && id[i] > old) || (delta < 0 && id[i] < old) {
+			break
+		}
+	}
+	return id
+}
@@ -0,0 +1,118 @@
+package squidserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// getPage fetches a page of GET /v1/events and returns its events and the
+// next page's link.
+func getPage(t *testing.T, url string) ([]*squid.Event, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != ndjsonContentType {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	var events []*squid.Event
+	dec := json.NewDecoder(resp.Body)
+	for dec.More() {
+		var e squid.Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, &e)
+	}
+	return events, resp.Header.Get("Link")
+}
+
+func TestStreamPages(t *testing.T) {
+	db, srv := newTestServer(t)
+
+	// Events within the same millisecond keep their order across pages
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	batch := make([]squid.Event, 25)
+	for i := range batch {
+		batch[i] = squid.Event{Timestamp: base, Type: "request", Tags: map[string]string{"service": "api"}, Data: map[string]any{"i": i}}
+	}
+	if _, err := db.AppendBatch(batch); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	if _, err := db.Append(squid.Event{Timestamp: base, Type: "error"}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	for _, descending := range []bool{false, true} {
+		url := srv.URL + "/v1/events?type=request&tag=service:api&page_size=10"
+		if descending {
+			url += "&descending=true"
+		}
+
+		var all []*squid.Event
+		pages := 0
+		for url != "" {
+			events, link := getPage(t, url)
+			all = append(all, events...)
+			pages++
+
+			url = ""
+			if link != "" {
+				url = srv.URL + nextLinkTarget(t, link)
+			}
+		}
+
+		if pages != 3 || len(all) != 25 {
+			t.Fatalf("descending=%v: expected 25 events over 3 pages, got %d over %d", descending, len(all), pages)
+		}
+		for i, e := range all {
+			want := float64(i)
+			if descending {
+				want = float64(24 - i)
+			}
+			if e.Data["i"] != want {
+				t.Errorf("descending=%v: event %d: expected i=%v, got %v", descending, i, want, e.Data["i"])
+			}
+		}
+	}
+}
+
+// nextLinkTarget returns the target of a rel="next" Link header.
+func nextLinkTarget(t *testing.T, link string) string {
+	t.Helper()
+
+	target, rest, ok := strings.Cut(strings.TrimPrefix(link, "<"), ">")
+	if !strings.HasPrefix(link, "<") || !ok || rest != `; rel="next"` {
+		t.Fatalf("unexpected Link header %q", link)
+	}
+	return target
+}
+
+func TestStreamInvalidParams(t *testing.T) {
+	_, srv := newTestServer(t)
+
+	for _, params := range []string{"page_size=0", "page_size=100000", "tag=service", "where=status", "where=status:between:5", "start=yesterday", "cursor=nope", "min_id=1"} {
+		resp, err := http.Get(srv.URL + "/v1/events?" + params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var e ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || e.Code != CodeInvalidQuery {
+			t.Errorf("%s: expected 400 %s, got %d %s", params, CodeInvalidQuery, resp.StatusCode, e.Code)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package squidserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// SubscribeRequest is the body of POST /v1/subscribe. The fields mirror
+// squid.SubscribeOptions.
+type SubscribeRequest struct {
+	Query      squid.Query `json:"query"`
+	BatchSize  int         `json:"batch_size,omitempty"`
+	BufferSize int         `json:"buffer_size,omitempty"`
+
+	// BatchDelay is a duration such as "100ms".
+	BatchDelay string `json:"batch_delay,omitempty"`
+
+	// Overflow is "block" (the default), "drop_oldest" or "fail".
+	Overflow string `json:"overflow,omitempty"`
+}
+
+// overflowPolicies maps the overflow policies of SubscribeRequest to
+// squid's.
+var overflowPolicies = map[string]squid.OverflowPolicy{
+	"":            squid.Block,
+	"block":       squid.Block,
+	"drop_oldest": squid.DropOldest,
+	"fail":        squid.FailSubscription,
+}
+
+// NewSubscribeRequest returns the request for a subscription with opts.
+func NewSubscribeRequest(opts squid.SubscribeOptions) SubscribeRequest {
+	req := SubscribeRequest{
+		Query:      opts.Query,
+		BatchSize:  opts.BatchSize,
+		BufferSize: opts.BufferSize,
+	}
+	if opts.BatchDelay > 0 {
+		req.BatchDelay = opts.BatchDelay.String()
+	}
+	for name, policy := range overflowPolicies {
+		if name != "" && policy == opts.Overflow {
+			req.Overflow = name
+		}
+	}
+	return req
+}
+
+// options returns the subscription options of the request.
+func (req SubscribeRequest) options() (squid.SubscribeOptions, error) {
+	opts := squid.SubscribeOptions{
+		Query:      req.Query,
+		BatchSize:  req.BatchSize,
+		BufferSize: req.BufferSize,
+	}
+	if req.BatchDelay != "" {
+		d, err := time.ParseDuration(req.BatchDelay)
+		if err != nil {
+			return opts, fmt.Errorf("invalid batch_delay: %w", err)
+		}
+		opts.BatchDelay = d
+	}
+	policy, ok := overflowPolicies[req.Overflow]
+	if !ok {
+		return opts, fmt.Errorf("invalid overflow %q, expected block, drop_oldest or fail", req.Overflow)
+	}
+	opts.Overflow = policy
+	return opts, nil
+}
+
+// EndSubscriptions ends the subscriptions streamed by POST /v1/subscribe,
+// which otherwise last until their clients go away, and rejects new ones.
+// Call it before shutting down an http.Server serving s, whose Shutdown
+// waits for them, e.g. with http.Server.RegisterOnShutdown.
+func (s *Server) EndSubscriptions() {
+	s.endSubs()
+}
+
+// handleSubscribe serves POST /v1/subscribe: the batches of a subscription,
+// streamed as NDJSON with one JSON array of events per line, until the
+// client goes away. If the subscription ends, a last line holds the
+// ErrorResponse saying why. Subscriptions are not subject to Limits.
+func (s *Server) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	var req SubscribeRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	opts, err := req.options()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: err.Error(), Code: CodeInvalidQuery})
+		return
+	}
+	if s.subs.Err() != nil {
+		writeError(w, squid.ErrClosed)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.subs, cancel)
+	defer stop()
+
+	sub, err := s.db.Subscribe(ctx, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		// Let the client know the subscription has started
+		flusher.Flush()
+	}
+
+	enc := json.NewEncoder(w)
+	for batch := range sub.C {
+		if err := enc.Encode(batch); err != nil {
+			return // The client went away
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if r.Context().Err() != nil {
+		return
+	}
+	err = sub.Err()
+	if s.subs.Err() != nil {
+		err = fmt.Errorf("squidserver: subscription ended by the server: %w", squid.ErrClosed)
+	}
+	if err != nil {
+		_, code := errorCode(err)
+		_ = enc.Encode(ErrorResponse{Error: err.Error(), Code: code})
+	}
+}
@@ -0,0 +1,69 @@
+package squidserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/asungur/squid"
+)
+
+func TestSubscribe(t *testing.T) {
+	db, _ := newTestServer(t)
+	s := New(db)
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	body := `{"query":{"types":["request"]},"batch_size":2,"batch_delay":"10ms"}`
+	resp, err := http.Post(srv.URL+"/v1/subscribe", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != ndjsonContentType {
+		t.Fatalf("expected a 200 NDJSON stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	_, err = db.AppendBatch([]squid.Event{{Type: "request"}, {Type: "debug"}, {Type: "request"}, {Type: "request"}})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	// Each line is a batch of at most 2 matching events
+	lines := bufio.NewScanner(resp.Body)
+	var sizes []int
+	for n := 0; n < 3 && lines.Scan(); {
+		var batch []*squid.Event
+		if err := json.Unmarshal(lines.Bytes(), &batch); err != nil {
+			t.Fatalf("unexpected line %s: %v", lines.Bytes(), err)
+		}
+		sizes = append(sizes, len(batch))
+		n += len(batch)
+	}
+	if len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 1 {
+		t.Errorf("expected batches of 2 and 1 events, got %v", sizes)
+	}
+
+	// Ending subscriptions says why in a last line
+	s.EndSubscriptions()
+	if !lines.Scan() {
+		t.Fatalf("expected a last line, got %v", lines.Err())
+	}
+	var e ErrorResponse
+	if err := json.Unmarshal(lines.Bytes(), &e); err != nil || e.Code != CodeClosed {
+		t.Errorf("expected a closed error, got %s", lines.Bytes())
+	}
+
+	// Invalid options are rejected before the stream starts
+	resp2, err := http.Post(srv.URL+"/v1/subscribe", "application/json", strings.NewReader(`{"overflow":"explode"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", resp2.StatusCode)
+	}
+}
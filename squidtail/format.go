@@ -0,0 +1,169 @@
+package squidtail
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// Format is how the lines of a file are parsed into fields.
+type Format string
+
+const (
+	// Plain keeps each line whole in the "message" field.
+	Plain Format = "plain"
+
+	// JSONLines parses each line as a JSON object, whose members become
+	// fields.
+	JSONLines Format = "json"
+
+	// Regexp matches each line against File.Pattern, whose named groups
+	// become fields.
+	Regexp Format = "regexp"
+
+	// CSV splits each line into the fields named by File.Columns, or by the
+	// first line of the file if Columns is empty.
+	CSV Format = "csv"
+)
+
+// MessageField holds the whole line of Plain files and of lines that do not
+// have their file's format, so that no line is lost.
+const MessageField = "message"
+
+// parse returns the fields of a line, reporting false if the line does not
+// have the file's format.
+func (f *File) parse(line string, columns []string) (map[string]any, bool) {
+	switch f.Format {
+	case JSONLines:
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil || fields == nil {
+			return nil, false
+		}
+		return fields, true
+
+	case Regexp:
+		m := f.Pattern.FindStringSubmatch(line)
+		if m == nil {
+			return nil, false
+		}
+		fields := make(map[string]any)
+		for i, name := range f.Pattern.SubexpNames() {
+			if name != "" && m[i] != "" {
+				fields[name] = parseValue(m[i])
+			}
+		}
+		return fields, true
+
+	case CSV:
+		values, err := splitCSV(line, f.Comma)
+		if err != nil || len(values) != len(columns) {
+			return nil, false
+		}
+		fields := make(map[string]any, len(values))
+		for i, v := range values {
+			if v != "" {
+				fields[columns[i]] = parseValue(v)
+			}
+		}
+		return fields, true
+	}
+
+	return map[string]any{MessageField: line}, true
+}
+
+// splitCSV splits a line of comma-separated values.
+func splitCSV(line string, comma rune) ([]string, error) {
+	r := csv.NewReader(strings.NewReader(line))
+	if comma != 0 {
+		r.Comma = comma
+	}
+	r.FieldsPerRecord = -1
+	return r.Read()
+}
+
+// parseValue returns s as a number if it is one, so that aggregations can
+// use the fields of text formats.
+func parseValue(s string) any {
+	if n, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+		return n
+	}
+	return s
+}
+
+// event builds the event of a line's fields. Lines that do not have the
+// file's format are kept whole in MessageField.
+func (f *File) event(line string, columns []string) squid.Event {
+	event := squid.Event{Type: f.Type, Tags: maps.Clone(f.Tags)}
+	fields, ok := f.parse(line, columns)
+	if !ok {
+		fields = map[string]any{MessageField: line}
+	}
+
+	if v, ok := fields[f.TypeField].(string); ok && f.TypeField != "" && v != "" {
+		event.Type = v
+		delete(fields, f.TypeField)
+	}
+	if v, ok := fields[f.TimeField]; ok && f.TimeField != "" {
+		if t, err := f.parseTime(v); err == nil {
+			event.Timestamp = t
+			delete(fields, f.TimeField)
+		}
+	}
+	for _, key := range f.TagFields {
+		v, ok := fields[key]
+		if !ok {
+			continue
+		}
+		if event.Tags == nil {
+			event.Tags = make(map[string]string)
+		}
+		event.Tags[key] = fmt.Sprint(v)
+		delete(fields, key)
+	}
+
+	if len(fields) > 0 {
+		event.Data = fields
+	}
+	return event
+}
+
+// parseTime parses the time field of a line with the file's TimeLayout.
+func (f *File) parseTime(v any) (time.Time, error) {
+	switch f.TimeLayout {
+	case "unix", "unixms":
+		var n float64
+		switch v := v.(type) {
+		case float64:
+			n = v
+		case string:
+			var err error
+			if n, err = strconv.ParseFloat(v, 64); err != nil {
+				return time.Time{}, err
+			}
+		default:
+			return time.Time{}, fmt.Errorf("squidtail: time %v is not a number", v)
+		}
+		if f.TimeLayout == "unixms" {
+			return time.UnixMilli(int64(n)), nil
+		}
+		sec, frac := math.Modf(n)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return time.Time{}, fmt.Errorf("squidtail: time %v is not a string", v)
+	}
+	layout := f.TimeLayout
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	return time.Parse(layout, s)
+}
@@ -0,0 +1,70 @@
+package squidtail
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func TestFileEvent(t *testing.T) {
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		file File
+		line string
+		want squid.Event
+	}{
+		{
+			name: "plain",
+			file: File{Type: "log", Format: Plain, Tags: map[string]string{"host": "a"}},
+			line: "started",
+			want: squid.Event{Type: "log", Tags: map[string]string{"host": "a"}, Data: map[string]any{"message": "started"}},
+		},
+		{
+			name: "json",
+			file: File{Type: "log", Format: JSONLines, TypeField: "kind", TimeField: "ts", TagFields: []string{"level"}},
+			line: `{"kind":"request","ts":"2024-01-01T10:00:00Z","level":"info","ms":12}`,
+			want: squid.Event{Type: "request", Timestamp: ts, Tags: map[string]string{"level": "info"}, Data: map[string]any{"ms": 12.0}},
+		},
+		{
+			name: "unix time",
+			file: File{Type: "log", Format: JSONLines, TimeField: "ts", TimeLayout: "unixms"},
+			line: `{"ts":1704103200000}`,
+			want: squid.Event{Type: "log", Timestamp: ts.Local()},
+		},
+		{
+			name: "regexp",
+			file: File{Type: "log", Format: Regexp, Pattern: regexp.MustCompile(`^(?P<level>\w+) (?P<msg>.*) in (?P<ms>\d+)ms$`), TagFields: []string{"level"}},
+			line: "WARN slow query in 250ms",
+			want: squid.Event{Type: "log", Tags: map[string]string{"level": "WARN"}, Data: map[string]any{"msg": "slow query", "ms": 250.0}},
+		},
+		{
+			name: "csv",
+			file: File{Type: "log", Format: CSV, Comma: ';', TagFields: []string{"host"}},
+			line: `web-1;"a;b";`,
+			want: squid.Event{Type: "log", Tags: map[string]string{"host": "web-1"}, Data: map[string]any{"path": "a;b"}},
+		},
+		{
+			name: "no match",
+			file: File{Type: "log", Format: JSONLines, TimeField: "ts"},
+			line: "not json",
+			want: squid.Event{Type: "log", Data: map[string]any{"message": "not json"}},
+		},
+		{
+			name: "bad time",
+			file: File{Type: "log", Format: JSONLines, TimeField: "ts"},
+			line: `{"ts":"yesterday"}`,
+			want: squid.Event{Type: "log", Data: map[string]any{"ts": "yesterday"}},
+		},
+	}
+	for _, tt := range tests {
+		got := tt.file.event(tt.line, []string{"host", "path", "ms"})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,476 @@
+// Package squidtail follows log files and appends their lines as events, so
+// that Squid can collect the logs of a host without a separate shipper.
+//
+// Each followed file is polled for new lines, which are parsed as plain
+// text, JSON lines, regular expression matches or CSV and appended in
+// batches. The position reached in each file is stored in the database in
+// the same transaction as the events it covers, so a restarted Tailer
+// resumes where it stopped without losing or repeating lines. Rotated and
+// truncated files are detected and read from the start:
+//
+//	t := squidtail.New(db, squidtail.Options{})
+//	defer t.Close()
+//
+//	t.Follow("nginx", squidtail.File{
+//		Path:      "/var/log/nginx/access.log",
+//		Type:      "request",
+//		Format:    squidtail.Regexp,
+//		Pattern:   regexp.MustCompile(`^(?P<remote>\S+) .* "(?P<method>\S+) (?P<path>\S+) [^"]*" (?P<status>\d+) (?P<bytes>\d+)`),
+//		TagFields: []string{"method", "status"},
+//	})
+package squidtail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// ErrExists is returned when following a file under a name that is in use.
+var ErrExists = errors.New("squidtail: file already followed")
+
+// positionPrefix is the application metadata key prefix of the positions
+// of followed files.
+const positionPrefix = "squidtail/"
+
+// headLen is the number of bytes at the start of a file that identify it,
+// so that a file replaced while the Tailer was stopped is read from the
+// start.
+const headLen = 256
+
+// File describes a followed file and how its lines become events.
+type File struct {
+	// Path is the file's path. It need not exist yet.
+	Path string
+
+	// Type is the type of the events. Defaults to "log".
+	Type string
+
+	// Tags are added to every event, e.g. the host name.
+	Tags map[string]string
+
+	// Format parses the lines. Defaults to Plain.
+	Format Format
+
+	// Pattern is the regular expression of the Regexp format.
+	Pattern *regexp.Regexp
+
+	// Columns names the fields of the CSV format. If empty, the first line
+	// of the file is the header.
+	Columns []string
+
+	// Comma is the field delimiter of the CSV format. Defaults to ','.
+	Comma rune
+
+	// TypeField names a field holding the event type, overriding Type.
+	TypeField string
+
+	// TimeField names a field holding the event timestamp. Events without
+	// one are timestamped when they are appended.
+	TimeField string
+
+	// TimeLayout parses TimeField as by time.Parse, or as seconds or
+	// milliseconds since the Unix epoch if "unix" or "unixms". Defaults to
+	// time.RFC3339Nano.
+	TimeLayout string
+
+	// TagFields names fields moved from the data to the tags.
+	TagFields []string
+
+	// FromEnd starts a file without a stored position at its end, skipping
+	// the lines written before it was first followed.
+	FromEnd bool
+}
+
+// Options configures a Tailer.
+type Options struct {
+	// PollInterval is how often files are checked for new lines.
+	// Defaults to 1 second.
+	PollInterval time.Duration
+
+	// BatchSize is the maximum number of lines appended per transaction.
+	// Defaults to 1000.
+	BatchSize int
+
+	// OnError is called when a file cannot be read or its lines cannot be
+	// appended. The lines are retried at the next poll.
+	OnError func(name string, err error)
+}
+
+// Tailer follows files and appends their lines to a database.
+type Tailer struct {
+	db   *squid.DB
+	opts Options
+
+	mu    sync.Mutex
+	files map[string]*follower
+}
+
+// New creates a Tailer for db.
+func New(db *squid.DB, opts Options) *Tailer {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	return &Tailer{db: db, opts: opts, files: make(map[string]*follower)}
+}
+
+// Follow starts appending the lines of file, from the position stored under
+// name if there is one. Names must stay the same across restarts for the
+// positions to be found.
+func (t *Tailer) Follow(name string, file File) error {
+	if file.Path == "" {
+		return errors.New("squidtail: Path is required")
+	}
+	switch file.Format {
+	case "":
+		file.Format = Plain
+	case Plain, JSONLines, CSV:
+	case Regexp:
+		if file.Pattern == nil {
+			return errors.New("squidtail: Pattern is required by the regexp format")
+		}
+	default:
+		return fmt.Errorf("squidtail: unknown format %q", file.Format)
+	}
+	if file.Type == "" {
+		file.Type = "log"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.files[name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, name)
+	}
+
+	fw := &follower{
+		t:    t,
+		name: name,
+		file: file,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	t.files[name] = fw
+
+	go fw.run()
+
+	return nil
+}
+
+// Unfollow stops following the named file. Its position stays stored.
+func (t *Tailer) Unfollow(name string) {
+	t.mu.Lock()
+	fw, ok := t.files[name]
+	delete(t.files, name)
+	t.mu.Unlock()
+
+	if ok {
+		fw.close()
+	}
+}
+
+// Close stops following every file.
+func (t *Tailer) Close() {
+	t.mu.Lock()
+	files := t.files
+	t.files = make(map[string]*follower)
+	t.mu.Unlock()
+
+	for _, fw := range files {
+		fw.close()
+	}
+}
+
+// position is the stored position of a followed file.
+type position struct {
+	// Offset is the offset of the first line not appended yet.
+	Offset int64 `json:"offset"`
+
+	// Head is the hex SHA-256 of the first HeadLen bytes of the file.
+	Head    string `json:"head"`
+	HeadLen int    `json:"head_len"`
+}
+
+// follower appends the lines of one file.
+type follower struct {
+	t    *Tailer
+	name string
+	file File
+	stop chan struct{}
+	done chan struct{}
+
+	f       *os.File
+	info    os.FileInfo
+	offset  int64
+	head    position // Head and HeadLen once the file has headLen bytes
+	columns []string
+}
+
+// close stops the follower and waits for it to exit.
+func (fw *follower) close() {
+	close(fw.stop)
+	<-fw.done
+}
+
+// run polls the file until the follower is stopped.
+func (fw *follower) run() {
+	defer close(fw.done)
+	defer func() {
+		if fw.f != nil {
+			fw.f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(fw.t.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := fw.poll(); err != nil && fw.t.opts.OnError != nil {
+			fw.t.opts.OnError(fw.name, err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-fw.stop:
+			return
+		}
+	}
+}
+
+// poll appends the lines written since the last poll, then switches to a
+// new file if the file was rotated.
+func (fw *follower) poll() error {
+	if fw.f == nil {
+		if err := fw.open(); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil // Not created yet, or between rotations
+			}
+			return err
+		}
+	}
+
+	info, err := fw.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < fw.offset {
+		// Truncated in place, e.g. by logrotate's copytruncate
+		fw.offset = 0
+		fw.head = position{}
+		fw.columns = nil
+	}
+
+	if err := fw.read(false); err != nil {
+		return err
+	}
+
+	current, err := os.Stat(fw.file.Path)
+	if err == nil && os.SameFile(current, fw.info) {
+		return nil
+	}
+
+	// The file was moved away or replaced. Lines written to it before the
+	// rename are read, including a last line without a newline, and the
+	// new file is read from its start at the next poll.
+	if err := fw.read(true); err != nil {
+		return err
+	}
+	fw.f.Close()
+	fw.f = nil
+	fw.offset = 0
+	fw.head = position{}
+	fw.columns = nil
+	return fw.store()
+}
+
+// open opens the file at the stored position, or at its start if it is not
+// the file the position was stored for.
+func (fw *follower) open() error {
+	f, err := os.Open(fw.file.Path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fw.f, fw.info = f, info
+	fw.offset = 0
+
+	pos, err := fw.load()
+	switch {
+	case err == nil:
+		if pos.Offset <= info.Size() && fw.headOf(pos.HeadLen) == pos.Head {
+			fw.offset = pos.Offset
+		}
+	case errors.Is(err, squid.ErrMetaNotFound):
+		if fw.file.FromEnd && info.Size() > 0 {
+			// Stored now so that lines written before a restart are kept
+			fw.offset = info.Size()
+			if err := fw.store(); err != nil {
+				f.Close()
+				fw.f = nil
+				return err
+			}
+		}
+	default:
+		f.Close()
+		fw.f = nil
+		return err
+	}
+
+	if fw.file.Format == CSV && len(fw.file.Columns) == 0 && fw.offset > 0 {
+		// Resuming after the header line, which must be read again
+		header, err := bufio.NewReader(io.NewSectionReader(f, 0, fw.offset)).ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("squidtail: reading the header of %s: %w", fw.file.Path, err)
+		}
+		if fw.columns, err = splitCSV(string(bytes.TrimRight([]byte(header), "\r\n")), fw.file.Comma); err != nil {
+			return fmt.Errorf("squidtail: reading the header of %s: %w", fw.file.Path, err)
+		}
+	}
+	return nil
+}
+
+// read appends the complete lines from the offset to the end of the file,
+// in batches of Options.BatchSize. If final is set, a last line without a
+// newline is appended too.
+func (fw *follower) read(final bool) error {
+	r := bufio.NewReader(io.NewSectionReader(fw.f, fw.offset, 1<<62))
+	events := make([]squid.Event, 0, fw.t.opts.BatchSize)
+	var read int64 // bytes of the lines in events
+
+	flush := func() error {
+		if read == 0 {
+			return nil
+		}
+		fw.offset += read
+		if err := fw.commit(events); err != nil {
+			fw.offset -= read
+			if fw.offset == 0 {
+				fw.columns = nil // The header is read again
+			}
+			return err
+		}
+		events, read = events[:0], 0
+		return nil
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF && (!final || line == "") {
+			return flush() // A partial line is read once it is complete
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		read += int64(len(line))
+
+		line = string(bytes.TrimRight([]byte(line), "\r\n"))
+		switch columns := fw.csvColumns(); {
+		case line == "":
+		case fw.file.Format == CSV && columns == nil:
+			if fw.columns, err = splitCSV(line, fw.file.Comma); err != nil {
+				return fmt.Errorf("squidtail: reading the header of %s: %w", fw.file.Path, err)
+			}
+		default:
+			events = append(events, fw.file.event(line, columns))
+		}
+
+		if len(events) >= fw.t.opts.BatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// csvColumns returns the fields of CSV lines, or nil until the header of a
+// file without Columns has been read.
+func (fw *follower) csvColumns() []string {
+	if len(fw.file.Columns) > 0 {
+		return fw.file.Columns
+	}
+	return fw.columns
+}
+
+// commit appends events with the position after them.
+func (fw *follower) commit(events []squid.Event) error {
+	if len(events) == 0 {
+		return fw.store()
+	}
+	meta, err := fw.positionMeta()
+	if err != nil {
+		return err
+	}
+	_, err = fw.t.db.AppendBatchWithMeta(events, meta)
+	return err
+}
+
+// store stores the position without appending events.
+func (fw *follower) store() error {
+	meta, err := fw.positionMeta()
+	if err != nil {
+		return err
+	}
+	return fw.t.db.UpdateMeta(meta)
+}
+
+// positionMeta returns the metadata update storing the position.
+func (fw *follower) positionMeta() (map[string][]byte, error) {
+	pos := fw.head
+	if pos.HeadLen < headLen && fw.f != nil {
+		// The head grows with the lines read until it is complete
+		pos.HeadLen = int(min(fw.offset, headLen))
+		pos.Head = fw.headOf(pos.HeadLen)
+		if pos.HeadLen == headLen {
+			fw.head = pos
+		}
+	}
+	pos.Offset = fw.offset
+
+	val, err := json.Marshal(pos)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{positionPrefix + fw.name: val}, nil
+}
+
+// load returns the stored position.
+func (fw *follower) load() (position, error) {
+	var pos position
+	val, err := fw.t.db.GetMeta(positionPrefix + fw.name)
+	if err != nil {
+		return pos, err
+	}
+	if err := json.Unmarshal(val, &pos); err != nil {
+		return pos, fmt.Errorf("squidtail: position of %s: %w", fw.name, err)
+	}
+	return pos, nil
+}
+
+// headOf returns the hex SHA-256 of the first n bytes of the open file, or
+// "" if it is shorter.
+func (fw *follower) headOf(n int) string {
+	buf := make([]byte, n)
+	if _, err := fw.f.ReadAt(buf, 0); err != nil && n > 0 {
+		return ""
+	}
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
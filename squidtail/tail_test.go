@@ -0,0 +1,214 @@
+package squidtail
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// appendFile appends s to the file at path, creating it if needed.
+func appendFile(t *testing.T, path, s string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitMessages waits until the messages of the stored events are want.
+func waitMessages(t *testing.T, db *squid.DB, want ...string) {
+	t.Helper()
+	var got []string
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		events, err := db.Query(context.Background(), squid.Query{})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		got = got[:0]
+		for _, e := range events {
+			got = append(got, e.Data[MessageField].(string))
+		}
+		if slices.Equal(got, want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected messages %q, got %q", want, got)
+}
+
+func TestTail(t *testing.T) {
+	db := openTestDB(t)
+	path := filepath.Join(t.TempDir(), "app.log")
+	opts := Options{PollInterval: 10 * time.Millisecond}
+
+	tl := New(db, opts)
+	if err := tl.Follow("app", File{Path: path}); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	if err := tl.Follow("app", File{Path: path}); !errors.Is(err, ErrExists) {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+
+	// The file is waited for, and a line is read once it is complete
+	appendFile(t, path, "one\n\ntwo\nthr")
+	waitMessages(t, db, "one", "two")
+	appendFile(t, path, "ee\n")
+	waitMessages(t, db, "one", "two", "three")
+
+	// A restarted Tailer resumes at the stored position
+	tl.Close()
+	appendFile(t, path, "four\n")
+	tl = New(db, opts)
+	defer tl.Close()
+	if err := tl.Follow("app", File{Path: path}); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	waitMessages(t, db, "one", "two", "three", "four")
+
+	// A rotated file is drained, including its last line, and the new file
+	// is read from the start
+	appendFile(t, path, "five")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "six\n")
+	waitMessages(t, db, "one", "two", "three", "four", "five", "six")
+
+	// A truncated file is read from the start
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "7\n")
+	waitMessages(t, db, "one", "two", "three", "four", "five", "six", "7")
+}
+
+func TestTailReplaced(t *testing.T) {
+	db := openTestDB(t)
+	path := filepath.Join(t.TempDir(), "app.log")
+	opts := Options{PollInterval: 10 * time.Millisecond}
+	appendFile(t, path, "old\n")
+
+	tl := New(db, opts)
+	if err := tl.Follow("app", File{Path: path}); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	waitMessages(t, db, "old")
+	tl.Close()
+
+	// A file replaced while the Tailer was stopped is read from the start,
+	// even if it is longer than the stored position
+	if err := os.WriteFile(path, []byte("new file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tl = New(db, opts)
+	defer tl.Close()
+	if err := tl.Follow("app", File{Path: path}); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	waitMessages(t, db, "old", "new file")
+}
+
+func TestTailFromEnd(t *testing.T) {
+	db := openTestDB(t)
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendFile(t, path, "before\n")
+
+	tl := New(db, Options{PollInterval: 10 * time.Millisecond})
+	defer tl.Close()
+	if err := tl.Follow("app", File{Path: path, FromEnd: true}); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+
+	// The first poll stores the position at the end before the line is
+	// written
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := db.GetMeta(positionPrefix + "app"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the position")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	appendFile(t, path, "after\n")
+	waitMessages(t, db, "after")
+}
+
+func TestTailCSV(t *testing.T) {
+	db := openTestDB(t)
+	path := filepath.Join(t.TempDir(), "metrics.csv")
+	appendFile(t, path, "host,ms\nweb-1,12\n")
+	opts := Options{PollInterval: 10 * time.Millisecond}
+	file := File{Path: path, Type: "latency", Format: CSV, TagFields: []string{"host"}}
+
+	tl := New(db, opts)
+	if err := tl.Follow("metrics", file); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	wait := func(n int64) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			count, err := db.QueryCount(context.Background(), squid.Query{Types: []string{"latency"}})
+			if err != nil {
+				t.Fatalf("QueryCount failed: %v", err)
+			}
+			if count == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d events, got %d", n, count)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	wait(1)
+	tl.Close()
+
+	// The header is read again when resuming
+	appendFile(t, path, "web-2,30\n")
+	tl = New(db, opts)
+	defer tl.Close()
+	if err := tl.Follow("metrics", file); err != nil {
+		t.Fatalf("Follow failed: %v", err)
+	}
+	wait(2)
+
+	events, err := db.Query(context.Background(), squid.Query{Tags: map[string]string{"host": "web-2"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 || events[0].Data["ms"] != 30.0 {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
@@ -0,0 +1,236 @@
+// Package squidtest provides helpers for testing code that embeds Squid:
+// temporary databases, fixture seeding, a fake clock, assertions over query
+// results and golden-file export comparisons.
+package squidtest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+var update = flag.Bool("squidtest.update", false, "rewrite squidtest golden files")
+
+// Open opens a database in a temporary directory that is closed and removed
+// when the test finishes.
+func Open(tb testing.TB, opts squid.Options) *squid.DB {
+	tb.Helper()
+
+	db, err := squid.OpenWithOptions(tb.TempDir(), opts)
+	if err != nil {
+		tb.Fatalf("squidtest: open failed: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// Clock is a fake clock for use as squid.Options.Now.
+// It only moves when Advance or Set is called.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the clock's current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Seed appends events in a single batch and returns the stored results.
+func Seed(tb testing.TB, db *squid.DB, events ...squid.Event) []*squid.AppendResult {
+	tb.Helper()
+
+	results, err := db.AppendBatch(events)
+	if err != nil {
+		tb.Fatalf("squidtest: seed failed: %v", err)
+	}
+	return results
+}
+
+// LoadFixtures reads events from a JSON file containing either an array of
+// events or one event per line (NDJSON).
+func LoadFixtures(tb testing.TB, path string) []squid.Event {
+	tb.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("squidtest: read fixtures: %v", err)
+	}
+
+	var events []squid.Event
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &events); err != nil {
+			tb.Fatalf("squidtest: parse fixtures %s: %v", path, err)
+		}
+		return events
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var event squid.Event
+		if err := json.Unmarshal(text, &event); err != nil {
+			tb.Fatalf("squidtest: parse fixtures %s:%d: %v", path, line, err)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		tb.Fatalf("squidtest: read fixtures %s: %v", path, err)
+	}
+
+	return events
+}
+
+// SeedFile loads fixtures from path and appends them to db.
+func SeedFile(tb testing.TB, db *squid.DB, path string) []*squid.AppendResult {
+	tb.Helper()
+	return Seed(tb, db, LoadFixtures(tb, path)...)
+}
+
+// AssertCount fails the test if the query does not return exactly want events.
+func AssertCount(tb testing.TB, db *squid.DB, q squid.Query, want int) {
+	tb.Helper()
+
+	events, err := db.Query(context.Background(), q)
+	if err != nil {
+		tb.Fatalf("squidtest: query failed: %v", err)
+	}
+	if len(events) != want {
+		tb.Errorf("squidtest: expected %d events, got %d", want, len(events))
+	}
+}
+
+// AssertEvents fails the test if got does not match want in order.
+// Type, Tags and Data are always compared; ID and Timestamp are only
+// compared when set in want. Data values are compared after a JSON
+// round trip, so want may use Go ints where stored values are float64.
+func AssertEvents(tb testing.TB, got []*squid.Event, want []squid.Event) {
+	tb.Helper()
+
+	if len(got) != len(want) {
+		tb.Errorf("squidtest: expected %d events, got %d", len(want), len(got))
+		return
+	}
+
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Type != w.Type {
+			tb.Errorf("squidtest: event %d: type %q, want %q", i, g.Type, w.Type)
+		}
+		if !equalJSON(g.Tags, w.Tags) {
+			tb.Errorf("squidtest: event %d: tags %v, want %v", i, g.Tags, w.Tags)
+		}
+		if !equalJSON(g.Data, w.Data) {
+			tb.Errorf("squidtest: event %d: data %v, want %v", i, g.Data, w.Data)
+		}
+		if w.ID != (squid.Event{}).ID && g.ID != w.ID {
+			tb.Errorf("squidtest: event %d: id %s, want %s", i, g.ID, w.ID)
+		}
+		if !w.Timestamp.IsZero() && !g.Timestamp.Equal(w.Timestamp) {
+			tb.Errorf("squidtest: event %d: timestamp %v, want %v", i, g.Timestamp, w.Timestamp)
+		}
+	}
+}
+
+// equalJSON compares two values by their JSON representation.
+func equalJSON(a, b any) bool {
+	var va, vb any
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+	_ = json.Unmarshal(ja, &va)
+	_ = json.Unmarshal(jb, &vb)
+	return reflect.DeepEqual(va, vb)
+}
+
+// ulidPattern matches ULIDs in export output.
+var ulidPattern = regexp.MustCompile(`\b[0-7][0-9A-HJKMNP-TV-Z]{25}\b`)
+
+// AssertGoldenExport exports the query in the given format and compares it
+// with the golden file at path. ULIDs are replaced with stable placeholders
+// (ID1, ID2, ...) in order of appearance, so fixtures only need fixed
+// timestamps to produce reproducible output.
+//
+// Run tests with -squidtest.update to rewrite golden files.
+func AssertGoldenExport(tb testing.TB, db *squid.DB, q squid.Query, format squid.Exporter, path string) {
+	tb.Helper()
+
+	var buf bytes.Buffer
+	if err := db.Export(context.Background(), &buf, q, format); err != nil {
+		tb.Fatalf("squidtest: export failed: %v", err)
+	}
+	got := normalizeIDs(buf.String())
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatalf("squidtest: update golden file: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			tb.Fatalf("squidtest: update golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("squidtest: read golden file (run with -squidtest.update to create it): %v", err)
+	}
+	if got != string(want) {
+		tb.Errorf("squidtest: export does not match %s\n--- got\n%s\n--- want\n%s", path, got, want)
+	}
+}
+
+// normalizeIDs replaces each distinct ULID with a numbered placeholder.
+func normalizeIDs(s string) string {
+	ids := make(map[string]string)
+	return ulidPattern.ReplaceAllStringFunc(s, func(id string) string {
+		id = strings.ToUpper(id)
+		if p, ok := ids[id]; ok {
+			return p
+		}
+		p := fmt.Sprintf("ID%d", len(ids)+1)
+		ids[id] = p
+		return p
+	})
+}
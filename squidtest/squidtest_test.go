@@ -0,0 +1,50 @@
+package squidtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func TestSeedAndAssert(t *testing.T) {
+	db := Open(t, squid.Options{})
+	SeedFile(t, db, "testdata/events.ndjson")
+
+	AssertCount(t, db, squid.Query{}, 3)
+	AssertCount(t, db, squid.Query{Types: []string{"request"}}, 2)
+
+	events, err := db.Query(context.Background(), squid.Query{Tags: map[string]string{"service": "api"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	AssertEvents(t, events, []squid.Event{
+		{Type: "request", Tags: map[string]string{"service": "api"}, Data: map[string]any{"status": 200, "latency": 12.5}},
+		{Type: "error", Tags: map[string]string{"service": "api"}, Data: map[string]any{"message": "timeout"}},
+	})
+}
+
+func TestGoldenExport(t *testing.T) {
+	db := Open(t, squid.Options{})
+	SeedFile(t, db, "testdata/events.ndjson")
+
+	AssertGoldenExport(t, db, squid.Query{}, squid.CSV, "testdata/events.golden.csv")
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	db := Open(t, squid.Options{Now: clock.Now})
+
+	first := Seed(t, db, squid.Event{Type: "tick"})[0]
+	clock.Advance(time.Minute)
+	second := Seed(t, db, squid.Event{Type: "tick"})[0]
+
+	if !first.Timestamp.Equal(start) {
+		t.Errorf("expected timestamp %v, got %v", start, first.Timestamp)
+	}
+	if got := second.Timestamp.Sub(first.Timestamp); got != time.Minute {
+		t.Errorf("expected events one minute apart, got %v", got)
+	}
+}
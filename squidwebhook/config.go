@@ -0,0 +1,216 @@
+package squidwebhook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// Config declares notifiers and the rules that route events to them.
+// It is usually loaded from a JSON file:
+//
+//	{
+//	  "notifiers": {
+//	    "oncall": {"type": "pagerduty", "routing_key": "R0UT1NGK3Y"},
+//	    "team":   {"type": "slack", "url": "https://hooks.slack.com/services/..."}
+//	  },
+//	  "rules": [
+//	    {"name": "errors", "notifier": "oncall", "query": {"types": ["error"]}},
+//	    {"name": "deploys", "notifier": "team", "query": {"types": ["deploy"]},
+//	     "batch_size": 20, "batch_delay": "1m"}
+//	  ]
+//	}
+type Config struct {
+	// Notifiers are the delivery channels, by name.
+	Notifiers map[string]NotifierConfig `json:"notifiers"`
+
+	// Rules select events and the notifier they are sent to.
+	Rules []RuleConfig `json:"rules"`
+}
+
+// NotifierConfig configures a notifier. Which fields apply depends on Type.
+type NotifierConfig struct {
+	// Type is "webhook", "slack", "pagerduty" or "smtp".
+	Type string `json:"type"`
+
+	// URL is the endpoint of a webhook or Slack incoming webhook, or
+	// overrides the PagerDuty Events API endpoint.
+	URL string `json:"url,omitempty"`
+
+	// Template renders the webhook body, Slack text, PagerDuty summary or
+	// email body as a text/template.
+	Template string `json:"template,omitempty"`
+
+	// Secret signs webhook requests.
+	Secret string `json:"secret,omitempty"`
+
+	// RoutingKey and Severity configure PagerDuty.
+	RoutingKey string `json:"routing_key,omitempty"`
+	Severity   string `json:"severity,omitempty"`
+
+	// Addr, Username, Password, From, To and Subject configure SMTP.
+	// Username and Password enable PLAIN authentication.
+	Addr     string   `json:"addr,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Subject  string   `json:"subject,omitempty"`
+}
+
+// RuleConfig routes events matching Query to a notifier.
+type RuleConfig struct {
+	// Name identifies the rule. It is the Webhook field of the Payload.
+	Name string `json:"name"`
+
+	// Notifier is the name of the notifier events are sent to.
+	Notifier string `json:"notifier"`
+
+	// Query selects the events.
+	Query squid.Query `json:"query"`
+
+	// BatchSize, BatchDelay, MaxRetries and RetryBackoff are as in Webhook.
+	BatchSize    int      `json:"batch_size,omitempty"`
+	BatchDelay   Duration `json:"batch_delay,omitempty"`
+	MaxRetries   int      `json:"max_retries,omitempty"`
+	RetryBackoff Duration `json:"retry_backoff,omitempty"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in JSON.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// LoadConfig reads a Config from a JSON file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("squidwebhook: parsing %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Apply registers every rule with d. All notifiers are built before any rule
+// is registered, so a configuration error registers nothing.
+func (c *Config) Apply(d *Dispatcher) error {
+	hooks := make([]Webhook, len(c.Rules))
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("squidwebhook: rule %d has no name", i)
+		}
+		nc, ok := c.Notifiers[rule.Notifier]
+		if !ok {
+			return fmt.Errorf("squidwebhook: rule %s: unknown notifier %q", rule.Name, rule.Notifier)
+		}
+
+		hook, err := nc.webhook()
+		if err != nil {
+			return fmt.Errorf("squidwebhook: notifier %s: %w", rule.Notifier, err)
+		}
+		hook.Query = rule.Query
+		hook.BatchSize = rule.BatchSize
+		hook.BatchDelay = time.Duration(rule.BatchDelay)
+		hook.MaxRetries = rule.MaxRetries
+		hook.RetryBackoff = time.Duration(rule.RetryBackoff)
+		hooks[i] = hook
+	}
+
+	for i, hook := range hooks {
+		if err := d.Register(c.Rules[i].Name, hook); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// webhook builds the delivery settings of a notifier.
+func (nc NotifierConfig) webhook() (Webhook, error) {
+	var tmpl *template.Template
+	if nc.Template != "" {
+		var err error
+		if tmpl, err = template.New("template").Parse(nc.Template); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	switch nc.Type {
+	case "webhook":
+		if nc.URL == "" {
+			return Webhook{}, errors.New("url is required")
+		}
+		hook := Webhook{URL: nc.URL, Template: tmpl}
+		if nc.Secret != "" {
+			hook.Secret = []byte(nc.Secret)
+		}
+		return hook, nil
+
+	case "slack":
+		if nc.URL == "" {
+			return Webhook{}, errors.New("url is required")
+		}
+		return Webhook{Notifier: &Slack{WebhookURL: nc.URL, Text: tmpl}}, nil
+
+	case "pagerduty":
+		if nc.RoutingKey == "" {
+			return Webhook{}, errors.New("routing_key is required")
+		}
+		return Webhook{Notifier: &PagerDuty{
+			RoutingKey: nc.RoutingKey,
+			Severity:   nc.Severity,
+			Summary:    tmpl,
+			URL:        nc.URL,
+		}}, nil
+
+	case "smtp":
+		if nc.Addr == "" || nc.From == "" || len(nc.To) == 0 {
+			return Webhook{}, errors.New("addr, from and to are required")
+		}
+		s := &SMTP{Addr: nc.Addr, From: nc.From, To: nc.To, Body: tmpl}
+		if nc.Subject != "" {
+			subject, err := template.New("subject").Parse(nc.Subject)
+			if err != nil {
+				return Webhook{}, err
+			}
+			s.Subject = subject
+		}
+		if nc.Username != "" {
+			host, _, err := net.SplitHostPort(nc.Addr)
+			if err != nil {
+				return Webhook{}, err
+			}
+			s.Auth = smtp.PlainAuth("", nc.Username, nc.Password, host)
+		}
+		return Webhook{Notifier: s}, nil
+	}
+
+	return Webhook{}, fmt.Errorf("unknown type %q", nc.Type)
+}
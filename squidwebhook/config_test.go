@@ -0,0 +1,121 @@
+package squidwebhook
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func TestLoadConfig(t *testing.T) {
+	db := openTestDB(t)
+	rec := newRecorder(0)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "alerts.json")
+	config := `{
+		"notifiers": {
+			"team": {"type": "slack", "url": "` + srv.URL + `", "template": "{{len .Events}} deploys"},
+			"hook": {"type": "webhook", "url": "` + srv.URL + `", "secret": "s3cret"}
+		},
+		"rules": [
+			{"name": "deploys", "notifier": "team", "query": {"types": ["deploy"]},
+			 "batch_size": 2, "batch_delay": "1m"},
+			{"name": "errors", "notifier": "hook", "query": {"types": ["error"]}}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if got := time.Duration(c.Rules[0].BatchDelay); got != time.Minute {
+		t.Errorf("expected batch_delay 1m, got %v", got)
+	}
+
+	d := New(db, Options{})
+	defer d.Close()
+	if err := c.Apply(d); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	_, _ = db.AppendBatch([]squid.Event{{Type: "deploy"}, {Type: "deploy"}})
+	rec.wait(t, 1)
+	_, _ = db.Append(squid.Event{Type: "error"})
+	rec.wait(t, 1)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if got := string(rec.bodies[0]); got != `{"text":"2 deploys"}` {
+		t.Errorf("unexpected Slack body: %s", got)
+	}
+	var p Payload
+	if err := json.Unmarshal(rec.bodies[1], &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Webhook != "errors" || !Verify([]byte("s3cret"), rec.bodies[1], rec.headers[1].Get(SignatureHeader)) {
+		t.Errorf("unexpected webhook request: %s", rec.bodies[1])
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	db := openTestDB(t)
+
+	tests := []struct {
+		name   string
+		config Config
+		want   string
+	}{
+		{
+			name:   "unknown notifier",
+			config: Config{Rules: []RuleConfig{{Name: "r", Notifier: "missing"}}},
+			want:   `unknown notifier "missing"`,
+		},
+		{
+			name: "unknown type",
+			config: Config{
+				Notifiers: map[string]NotifierConfig{"n": {Type: "carrier-pigeon"}},
+				Rules:     []RuleConfig{{Name: "r", Notifier: "n"}},
+			},
+			want: `unknown type "carrier-pigeon"`,
+		},
+		{
+			name: "missing routing key",
+			config: Config{
+				Notifiers: map[string]NotifierConfig{"n": {Type: "pagerduty"}},
+				Rules:     []RuleConfig{{Name: "r", Notifier: "n"}},
+			},
+			want: "routing_key is required",
+		},
+		{
+			name: "bad template",
+			config: Config{
+				Notifiers: map[string]NotifierConfig{"n": {Type: "slack", URL: "http://x", Template: "{{"}},
+				Rules:     []RuleConfig{{Name: "r", Notifier: "n"}},
+			},
+			want: "unclosed action",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(db, Options{})
+			defer d.Close()
+
+			err := tt.config.Apply(d)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package squidwebhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+)
+
+// Notifier delivers a batch of events to an alerting channel.
+// Errors are retried by the Dispatcher unless wrapped with Permanent.
+type Notifier interface {
+	Notify(ctx context.Context, p Payload) error
+}
+
+// NotifierFunc adapts a function to a Notifier.
+type NotifierFunc func(ctx context.Context, p Payload) error
+
+// Notify calls f(ctx, p).
+func (f NotifierFunc) Notify(ctx context.Context, p Payload) error {
+	return f(ctx, p)
+}
+
+// permanentError marks a delivery failure that retrying cannot fix.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the Dispatcher does not retry the delivery.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// defaultSummary renders a one-line description of a payload.
+var defaultSummary = template.Must(template.New("summary").Parse(
+	`{{len .Events}} {{if eq (len .Events) 1}}event{{else}}events{{end}} matched {{.Webhook}}` +
+		`{{range .Events}}{{"\n"}}{{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}} {{.Type}}{{range $k, $v := .Tags}} {{$k}}={{$v}}{{end}}{{end}}`))
+
+// renderText renders a payload with tmpl, or with a default summary if tmpl is nil.
+func renderText(tmpl *template.Template, p Payload) (string, error) {
+	if tmpl == nil {
+		tmpl = defaultSummary
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return "", Permanent(fmt.Errorf("squidwebhook: rendering %s: %w", p.Webhook, err))
+	}
+	return buf.String(), nil
+}
+
+// Slack posts a message to a Slack incoming webhook.
+type Slack struct {
+	// WebhookURL is the incoming webhook URL.
+	WebhookURL string
+
+	// Text renders the message text from a Payload. Defaults to a summary
+	// listing each event's time, type and tags.
+	Text *template.Template
+
+	// Client sends the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Notify implements Notifier.
+func (s *Slack) Notify(ctx context.Context, p Payload) error {
+	text, err := renderText(s.Text, p)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return Permanent(err)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	return post(ctx, clientOrDefault(s.Client), s.WebhookURL, header, body)
+}
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint.
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDuty triggers an incident through the PagerDuty Events API v2.
+type PagerDuty struct {
+	// RoutingKey is the integration key of the PagerDuty service.
+	RoutingKey string
+
+	// Severity is "critical", "error", "warning" or "info". Defaults to "error".
+	Severity string
+
+	// Summary renders the incident summary from a Payload. Defaults to a
+	// summary listing each event's time, type and tags.
+	Summary *template.Template
+
+	// URL overrides the Events API endpoint.
+	URL string
+
+	// Client sends the requests. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// pagerDutyEvent is the body of an Events API v2 request.
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	Payload     pagerDutyPayload `json:"payload"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string  `json:"summary"`
+	Source        string  `json:"source"`
+	Severity      string  `json:"severity"`
+	CustomDetails Payload `json:"custom_details"`
+}
+
+// Notify implements Notifier.
+func (pd *PagerDuty) Notify(ctx context.Context, p Payload) error {
+	summary, err := renderText(pd.Summary, p)
+	if err != nil {
+		return err
+	}
+	// PagerDuty truncates summaries at 1024 characters
+	if len(summary) > 1024 {
+		summary = summary[:1021] + "..."
+	}
+
+	severity := pd.Severity
+	if severity == "" {
+		severity = "error"
+	}
+
+	body, err := json.Marshal(pagerDutyEvent{
+		RoutingKey:  pd.RoutingKey,
+		EventAction: "trigger",
+		Payload: pagerDutyPayload{
+			Summary:       summary,
+			Source:        "squid",
+			Severity:      severity,
+			CustomDetails: p,
+		},
+	})
+	if err != nil {
+		return Permanent(err)
+	}
+
+	url := pd.URL
+	if url == "" {
+		url = pagerDutyEventsURL
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	return post(ctx, clientOrDefault(pd.Client), url, header, body)
+}
+
+// SMTP sends an email through an SMTP server.
+type SMTP struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+
+	// Auth authenticates with the server (nil sends without authentication).
+	Auth smtp.Auth
+
+	// From is the sender address.
+	From string
+
+	// To are the recipient addresses.
+	To []string
+
+	// Subject renders the subject line. Defaults to "[squid] <webhook name>".
+	Subject *template.Template
+
+	// Body renders the plain text body. Defaults to a summary listing each
+	// event's time, type and tags.
+	Body *template.Template
+
+	// sendMail sends the message; tests replace it.
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// Notify implements Notifier.
+func (s *SMTP) Notify(ctx context.Context, p Payload) error {
+	subject := "[squid] " + p.Webhook
+	if s.Subject != nil {
+		var err error
+		if subject, err = renderText(s.Subject, p); err != nil {
+			return err
+		}
+	}
+
+	body, err := renderText(s.Body, p)
+	if err != nil {
+		return err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(subject, "\n", " "))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	send := s.sendMail
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return send(s.Addr, s.Auth, s.From, s.To, msg.Bytes())
+}
+
+// clientOrDefault returns c, or http.DefaultClient if c is nil.
+func clientOrDefault(c *http.Client) *http.Client {
+	if c == nil {
+		return http.DefaultClient
+	}
+	return c
+}
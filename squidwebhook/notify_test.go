@@ -0,0 +1,153 @@
+package squidwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	db := openTestDB(t)
+	rec := newRecorder(0)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	d := New(db, Options{})
+	defer d.Close()
+
+	err := d.Register("errors", Webhook{
+		Notifier: &Slack{
+			WebhookURL: srv.URL,
+			Text:       template.Must(template.New("text").Parse(`{{range .Events}}{{.Tags.service}} failed{{end}}`)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	_, _ = db.Append(squid.Event{Type: "error", Tags: map[string]string{"service": "api"}})
+	rec.wait(t, 1)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if got := string(rec.bodies[0]); got != `{"text":"api failed"}` {
+		t.Errorf("unexpected body: %s", got)
+	}
+}
+
+func TestPagerDutyNotifier(t *testing.T) {
+	rec := newRecorder(0)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	pd := &PagerDuty{RoutingKey: "key", URL: srv.URL}
+	err := pd.Notify(context.Background(), Payload{
+		Webhook: "errors",
+		Events:  []*squid.Event{{Type: "error", Timestamp: time.Unix(0, 0).UTC()}},
+	})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	var got pagerDutyEvent
+	if err := json.Unmarshal(rec.bodies[0], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.RoutingKey != "key" || got.EventAction != "trigger" {
+		t.Errorf("unexpected event: %s", rec.bodies[0])
+	}
+	if got.Payload.Severity != "error" || got.Payload.Source != "squid" {
+		t.Errorf("unexpected defaults: %s", rec.bodies[0])
+	}
+	if want := "1 event matched errors\n1970-01-01T00:00:00Z error"; got.Payload.Summary != want {
+		t.Errorf("expected summary %q, got %q", want, got.Payload.Summary)
+	}
+	if len(got.Payload.CustomDetails.Events) != 1 {
+		t.Errorf("expected events in custom_details: %s", rec.bodies[0])
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	var sent []byte
+	s := &SMTP{
+		Addr:    "mail.example.com:25",
+		From:    "squid@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: template.Must(template.New("subject").Parse(`{{len .Events}} new {{.Webhook}}`)),
+		sendMail: func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+			if addr != "mail.example.com:25" || from != "squid@example.com" || len(to) != 2 {
+				t.Errorf("unexpected envelope %s %s %v", addr, from, to)
+			}
+			sent = msg
+			return nil
+		},
+	}
+
+	err := s.Notify(context.Background(), Payload{
+		Webhook: "errors",
+		Events: []*squid.Event{
+			{Type: "error", Timestamp: time.Unix(0, 0).UTC(), Tags: map[string]string{"host": "a"}},
+			{Type: "error", Timestamp: time.Unix(60, 0).UTC()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	msg := string(sent)
+	for _, want := range []string{
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: 2 new errors\r\n",
+		"\r\n\r\n2 events matched errors\r\n1970-01-01T00:00:00Z error host=a\r\n1970-01-01T00:01:00Z error\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestPermanentErrorNotRetried(t *testing.T) {
+	db := openTestDB(t)
+
+	var calls atomic.Int64
+	errs := make(chan error, 1)
+	d := New(db, Options{
+		OnError: func(name string, err error) { errs <- err },
+	})
+	defer d.Close()
+
+	errBadRequest := errors.New("bad request")
+	err := d.Register("broken", Webhook{
+		Notifier: NotifierFunc(func(ctx context.Context, p Payload) error {
+			calls.Add(1)
+			return Permanent(errBadRequest)
+		}),
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	_, _ = db.Append(squid.Event{Type: "error"})
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errBadRequest) {
+			t.Errorf("expected errBadRequest, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnError")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 attempt, got %d", n)
+	}
+}
@@ -0,0 +1,364 @@
+// Package squidwebhook POSTs events matching a query to HTTP endpoints.
+//
+// Each registered webhook subscribes to the database and delivers matching
+// events, one at a time or batched into digests, with retries and optional
+// HMAC-SHA256 signing. Instead of a URL, a webhook can deliver through a
+// Notifier such as Slack, PagerDuty or SMTP:
+//
+//	d := squidwebhook.New(db, squidwebhook.Options{})
+//	defer d.Close()
+//
+//	d.Register("errors", squidwebhook.Webhook{
+//		URL:    "https://alerts.example.com/hook",
+//		Query:  squid.Query{Types: []string{"error"}},
+//		Secret: []byte("shared secret"),
+//	})
+//
+// Receivers verify the X-Squid-Signature header with Verify.
+package squidwebhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+// SignatureHeader carries the HMAC-SHA256 signature of the request body.
+const SignatureHeader = "X-Squid-Signature"
+
+// ErrExists is returned when registering a webhook under a name that is in use.
+var ErrExists = errors.New("squidwebhook: webhook already registered")
+
+// Webhook describes an endpoint and the events delivered to it.
+type Webhook struct {
+	// URL is the endpoint events are POSTed to.
+	URL string
+
+	// Notifier delivers events instead of POSTing them to URL.
+	Notifier Notifier
+
+	// Query selects the delivered events by type, tags and time.
+	Query squid.Query
+
+	// Template renders the request body from a Payload. Defaults to the
+	// Payload encoded as JSON. Not used with a Notifier.
+	Template *template.Template
+
+	// ContentType is the Content-Type of the request. Defaults to
+	// "application/json".
+	ContentType string
+
+	// Secret signs request bodies with HMAC-SHA256 (nil disables signing).
+	Secret []byte
+
+	// BatchSize is the maximum number of events per request. Defaults to 1,
+	// which sends one request per event; larger values send digests.
+	BatchSize int
+
+	// BatchDelay is how long to wait for a digest to fill up before sending
+	// a partial one.
+	BatchDelay time.Duration
+
+	// MaxRetries is the number of times a failed request is retried.
+	// Defaults to 3; use a negative value to disable retries.
+	MaxRetries int
+
+	// RetryBackoff is the delay before the first retry, doubled after each
+	// attempt. Defaults to 1 second.
+	RetryBackoff time.Duration
+}
+
+// Payload is the data a webhook request is rendered from.
+type Payload struct {
+	// Webhook is the name the webhook was registered under.
+	Webhook string `json:"webhook"`
+
+	// Events are the delivered events, oldest first.
+	Events []*squid.Event `json:"events"`
+}
+
+// Options configures a Dispatcher.
+type Options struct {
+	// Client sends the requests. Defaults to a client with a 10 second timeout.
+	Client *http.Client
+
+	// BufferSize is the maximum number of events buffered per webhook. When a
+	// webhook falls further behind, its oldest undelivered events are
+	// dropped so slow endpoints never block writers. Defaults to 10000.
+	BufferSize int
+
+	// OnError is called when a request fails after all retries.
+	OnError func(webhook string, err error)
+
+	// Crypto computes the signatures of payloads (nil uses Go's crypto
+	// packages). Signatures are the same with any conforming provider, so
+	// receivers can still check them with Verify.
+	Crypto squid.CryptoProvider
+}
+
+// Dispatcher delivers events to registered webhooks.
+type Dispatcher struct {
+	db   *squid.DB
+	opts Options
+
+	mu    sync.Mutex
+	hooks map[string]*runner
+}
+
+// runner delivers events to a single webhook.
+type runner struct {
+	name     string
+	hook     Webhook
+	notifier Notifier
+	sub      *squid.Subscription
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// New creates a Dispatcher for db.
+func New(db *squid.DB, opts Options) *Dispatcher {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10000
+	}
+
+	return &Dispatcher{
+		db:    db,
+		opts:  opts,
+		hooks: make(map[string]*runner),
+	}
+}
+
+// Register starts delivering events appended from now on to hook.
+func (d *Dispatcher) Register(name string, hook Webhook) error {
+	if hook.URL == "" && hook.Notifier == nil {
+		return errors.New("squidwebhook: URL or Notifier is required")
+	}
+	if hook.ContentType == "" {
+		hook.ContentType = "application/json"
+	}
+	if hook.BatchSize <= 0 {
+		hook.BatchSize = 1
+	}
+	if hook.MaxRetries == 0 {
+		hook.MaxRetries = 3
+	}
+	if hook.RetryBackoff <= 0 {
+		hook.RetryBackoff = time.Second
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.hooks[name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, name)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := d.db.Subscribe(ctx, squid.SubscribeOptions{
+		Query:      hook.Query,
+		BatchSize:  hook.BatchSize,
+		BatchDelay: hook.BatchDelay,
+		BufferSize: max(d.opts.BufferSize, hook.BatchSize),
+		Overflow:   squid.DropOldest,
+	})
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	notifier := hook.Notifier
+	if notifier == nil {
+		notifier = &httpNotifier{hook: hook, client: d.opts.Client, crypto: d.opts.Crypto}
+	}
+
+	r := &runner{
+		name:     name,
+		hook:     hook,
+		notifier: notifier,
+		sub:      sub,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	d.hooks[name] = r
+
+	go d.run(ctx, r)
+
+	return nil
+}
+
+// Unregister stops delivery to the named webhook. Undelivered events are discarded.
+func (d *Dispatcher) Unregister(name string) {
+	d.mu.Lock()
+	r, ok := d.hooks[name]
+	delete(d.hooks, name)
+	d.mu.Unlock()
+
+	if ok {
+		r.stop()
+	}
+}
+
+// Close stops delivery to all webhooks.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	hooks := d.hooks
+	d.hooks = make(map[string]*runner)
+	d.mu.Unlock()
+
+	for _, r := range hooks {
+		r.stop()
+	}
+}
+
+// stop ends the runner's subscription and waits for it to exit.
+func (r *runner) stop() {
+	r.cancel()
+	r.sub.Close()
+	<-r.done
+}
+
+// run delivers batches from the runner's subscription until it ends.
+func (d *Dispatcher) run(ctx context.Context, r *runner) {
+	defer close(r.done)
+
+	for batch := range r.sub.C {
+		err := d.deliver(ctx, r, batch)
+		if err != nil && ctx.Err() == nil && d.opts.OnError != nil {
+			d.opts.OnError(r.name, err)
+		}
+	}
+}
+
+// deliver sends a batch, retrying failures with exponential backoff.
+func (d *Dispatcher) deliver(ctx context.Context, r *runner, events []*squid.Event) error {
+	payload := Payload{Webhook: r.name, Events: events}
+
+	backoff := r.hook.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := r.notifier.Notify(ctx, payload)
+		if err == nil {
+			return nil
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= r.hook.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// httpNotifier POSTs rendered payloads to a webhook's URL.
+type httpNotifier struct {
+	hook   Webhook
+	client *http.Client
+	crypto squid.CryptoProvider
+}
+
+// Notify implements Notifier.
+func (n *httpNotifier) Notify(ctx context.Context, p Payload) error {
+	body, err := render(n.hook.Template, p)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	header := http.Header{"Content-Type": {n.hook.ContentType}}
+	if n.hook.Secret != nil {
+		mac := hmac.New(sha256.New, n.hook.Secret)
+		if n.crypto != nil {
+			mac = n.crypto.HMACSHA256(n.hook.Secret)
+		}
+		header.Set(SignatureHeader, signature(mac, body))
+	}
+	return post(ctx, n.client, n.hook.URL, header, body)
+}
+
+// post sends a request body to url. Failures other than network errors,
+// 5xx and 429 responses are permanent.
+func post(ctx context.Context, client *http.Client, url string, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(err)
+	}
+	req.Header = header
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("squidwebhook: %s returned %s", url, resp.Status)
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return err
+	}
+	return Permanent(err)
+}
+
+// render builds a request body from a payload, as JSON if tmpl is nil.
+func render(tmpl *template.Template, p Payload) ([]byte, error) {
+	if tmpl == nil {
+		return json.Marshal(p)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, p); err != nil {
+		return nil, fmt.Errorf("squidwebhook: rendering %s: %w", p.Webhook, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns the signature header value for body: "sha256=" followed by
+// the hex-encoded HMAC-SHA256 of body keyed with secret.
+func Sign(secret, body []byte) string {
+	return signature(hmac.New(sha256.New, secret), body)
+}
+
+// signature returns the signature header value for body computed with mac.
+func signature(mac hash.Hash, body []byte) string {
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of body.
+func Verify(secret, body []byte, signature string) bool {
+	got, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	sig, err := hex.DecodeString(got)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
@@ -0,0 +1,223 @@
+package squidwebhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/asungur/squid"
+)
+
+func openTestDB(t *testing.T) *squid.DB {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := squid.Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// recorder is an endpoint that records request bodies.
+type recorder struct {
+	mu       sync.Mutex
+	bodies   [][]byte
+	headers  []http.Header
+	failures int // number of requests to fail with 503 first
+	received chan struct{}
+}
+
+func newRecorder(failures int) *recorder {
+	return &recorder{failures: failures, received: make(chan struct{}, 100)}
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if rec.failures > 0 {
+		rec.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	rec.bodies = append(rec.bodies, body)
+	rec.headers = append(rec.headers, r.Header.Clone())
+	rec.received <- struct{}{}
+}
+
+func (rec *recorder) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-rec.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for request %d", i+1)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	db := openTestDB(t)
+	rec := newRecorder(0)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	d := New(db, Options{})
+	defer d.Close()
+
+	secret := []byte("secret")
+	err := d.Register("errors", Webhook{
+		URL:    srv.URL,
+		Query:  squid.Query{Types: []string{"error"}},
+		Secret: secret,
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	if err := d.Register("errors", Webhook{URL: srv.URL}); err == nil {
+		t.Error("expected error registering a duplicate name")
+	}
+
+	_, _ = db.Append(squid.Event{Type: "request"})
+	_, _ = db.Append(squid.Event{Type: "error", Tags: map[string]string{"service": "api"}})
+	_, _ = db.Append(squid.Event{Type: "error", Tags: map[string]string{"service": "web"}})
+
+	rec.wait(t, 2)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	for i, body := range rec.bodies {
+		if !Verify(secret, body, rec.headers[i].Get(SignatureHeader)) {
+			t.Errorf("request %d: invalid signature", i)
+		}
+		if ct := rec.headers[i].Get("Content-Type"); ct != "application/json" {
+			t.Errorf("request %d: unexpected Content-Type %q", i, ct)
+		}
+
+		var p Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if p.Webhook != "errors" || len(p.Events) != 1 || p.Events[0].Type != "error" {
+			t.Errorf("request %d: unexpected payload %s", i, body)
+		}
+	}
+}
+
+func TestWebhookDigestTemplateAndRetry(t *testing.T) {
+	db := openTestDB(t)
+	rec := newRecorder(2)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	d := New(db, Options{})
+	defer d.Close()
+
+	tmpl := template.Must(template.New("slack").Parse(`{"text":"{{len .Events}} events{{range .Events}} {{.Tags.service}}{{end}}"}`))
+	err := d.Register("digest", Webhook{
+		URL:          srv.URL,
+		Template:     tmpl,
+		BatchSize:    3,
+		BatchDelay:   time.Second,
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	_, err = db.AppendBatch([]squid.Event{
+		{Type: "error", Tags: map[string]string{"service": "a"}},
+		{Type: "error", Tags: map[string]string{"service": "b"}},
+		{Type: "error", Tags: map[string]string{"service": "c"}},
+	})
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	rec.wait(t, 1)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if got := string(rec.bodies[0]); got != `{"text":"3 events a b c"}` {
+		t.Errorf("unexpected body: %s", got)
+	}
+	if rec.headers[0].Get(SignatureHeader) != "" {
+		t.Error("expected unsigned request")
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	db := openTestDB(t)
+	rec := newRecorder(100)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	errs := make(chan error, 1)
+	d := New(db, Options{
+		OnError: func(name string, err error) { errs <- err },
+	})
+	defer d.Close()
+
+	err := d.Register("flaky", Webhook{
+		URL:          srv.URL,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	_, _ = db.Append(squid.Event{Type: "error"})
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnError")
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.failures != 97 {
+		t.Errorf("expected 3 attempts, got %d", 100-rec.failures)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret, body := []byte("secret"), []byte(`{"events":[]}`)
+	sig := Sign(secret, body)
+
+	if !Verify(secret, body, sig) {
+		t.Error("expected valid signature")
+	}
+	if Verify([]byte("other"), body, sig) {
+		t.Error("expected invalid signature for wrong secret")
+	}
+	if Verify(secret, []byte("tampered"), sig) {
+		t.Error("expected invalid signature for tampered body")
+	}
+	if Verify(secret, body, "md5=abc") {
+		t.Error("expected invalid signature for wrong scheme")
+	}
+}
@@ -0,0 +1,123 @@
+package squid
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// stallPollInterval is how often the level 0 table count is sampled.
+const stallPollInterval = 100 * time.Millisecond
+
+// WriteStall describes a change in BadgerDB's write-stall state.
+// BadgerDB stalls writes when level 0 accumulates more tables than compaction
+// can keep up with; while stalled, Append blocks.
+type WriteStall struct {
+	// Stalled is true when a stall starts and false when it ends.
+	Stalled bool
+
+	// Duration is how long the stall lasted. It is only set when the stall ends.
+	Duration time.Duration
+
+	// Level0Tables is the number of level 0 tables when the change was observed.
+	Level0Tables int
+}
+
+// stallMonitor samples BadgerDB's level 0 and reports write stalls.
+type stallMonitor struct {
+	threshold int
+	notify    func(WriteStall)
+	logger    Logger
+
+	stalled bool
+	since   time.Time
+
+	count    int64
+	duration int64 // nanoseconds
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newStallMonitor returns a monitor for the given options,
+// or nil if nobody is interested in write stalls.
+func newStallMonitor(opts Options, threshold int) *stallMonitor {
+	if opts.OnWriteStall == nil && opts.Logger == nil {
+		return nil
+	}
+	return &stallMonitor{
+		threshold: threshold,
+		notify:    opts.OnWriteStall,
+		logger:    opts.Logger,
+	}
+}
+
+// start begins sampling bdb in a background goroutine.
+func (m *stallMonitor) start(bdb *badger.DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func() {
+		defer close(m.done)
+
+		ticker := time.NewTicker(stallPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.observe(level0Tables(bdb), now)
+			}
+		}
+	}()
+}
+
+// stop ends sampling and waits for the goroutine to exit.
+func (m *stallMonitor) stop() {
+	if m.cancel != nil {
+		m.cancel()
+		<-m.done
+	}
+}
+
+// observe updates the stall state from a level 0 sample.
+func (m *stallMonitor) observe(l0 int, now time.Time) {
+	switch {
+	case !m.stalled && l0 >= m.threshold:
+		m.stalled = true
+		m.since = now
+		atomic.AddInt64(&m.count, 1)
+		if m.logger != nil {
+			m.logger.Warningf("squid: write stall started, %d level 0 tables", l0)
+		}
+		if m.notify != nil {
+			m.notify(WriteStall{Stalled: true, Level0Tables: l0})
+		}
+
+	case m.stalled && l0 < m.threshold:
+		m.stalled = false
+		d := now.Sub(m.since)
+		atomic.AddInt64(&m.duration, int64(d))
+		if m.logger != nil {
+			m.logger.Warningf("squid: write stall ended after %s", d.Round(time.Millisecond))
+		}
+		if m.notify != nil {
+			m.notify(WriteStall{Stalled: false, Duration: d, Level0Tables: l0})
+		}
+	}
+}
+
+// level0Tables returns the number of tables in BadgerDB's level 0.
+func level0Tables(bdb *badger.DB) int {
+	for _, l := range bdb.Levels() {
+		if l.Level == 0 {
+			return l.NumTables
+		}
+	}
+	return 0
+}
@@ -0,0 +1,62 @@
+package squid
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStallMonitorObserve(t *testing.T) {
+	var got []WriteStall
+	m := newStallMonitor(Options{OnWriteStall: func(s WriteStall) { got = append(got, s) }}, 15)
+
+	start := time.Now()
+	m.observe(5, start)
+	m.observe(15, start.Add(time.Second))
+	m.observe(20, start.Add(2*time.Second))
+	m.observe(3, start.Add(4*time.Second))
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 notifications, got %d", len(got))
+	}
+	if !got[0].Stalled || got[0].Level0Tables != 15 {
+		t.Errorf("unexpected stall start: %+v", got[0])
+	}
+	if got[1].Stalled || got[1].Duration != 3*time.Second {
+		t.Errorf("unexpected stall end: %+v", got[1])
+	}
+	if m.count != 1 || time.Duration(m.duration) != 3*time.Second {
+		t.Errorf("unexpected counters: count=%d duration=%v", m.count, time.Duration(m.duration))
+	}
+}
+
+func TestStallMonitorLifecycle(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenWithOptions(dir, Options{OnWriteStall: func(WriteStall) {}})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	if db.stalls == nil {
+		t.Fatal("expected stall monitor to be running")
+	}
+
+	// Close must stop the monitoring goroutine
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	select {
+	case <-db.stalls.done:
+	default:
+		t.Error("expected stall monitor to be stopped")
+	}
+
+	if newStallMonitor(Options{}, 15) != nil {
+		t.Error("expected no monitor without a callback or logger")
+	}
+}
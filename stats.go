@@ -0,0 +1,305 @@
+package squid
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds counters describing the database's activity since it was opened.
+type Stats struct {
+	// CardinalityRejections is the number of events rejected by tag cardinality limits.
+	CardinalityRejections int64
+
+	// CardinalityDroppedTags is the number of tags dropped by tag cardinality limits.
+	CardinalityDroppedTags int64
+
+	// FutureTimestampsRejected is the number of events rejected by Options.MaxFutureDrift.
+	FutureTimestampsRejected int64
+
+	// FutureTimestampsClamped is the number of event timestamps clamped by Options.MaxFutureDrift.
+	FutureTimestampsClamped int64
+
+	// WriteStalls is the number of write stalls observed. Stalls are only
+	// tracked when Options.OnWriteStall or Options.Logger is set.
+	WriteStalls int64
+
+	// WriteStallTime is the total duration of completed write stalls.
+	WriteStallTime time.Duration
+
+	// DiskFreeBytes is the free space last observed by the disk watchdog.
+	DiskFreeBytes uint64
+
+	// DiskLow reports whether free space is below the watchdog threshold.
+	DiskLow bool
+
+	// EmergencyDeletes is the number of events deleted by the disk watchdog.
+	EmergencyDeletes int64
+
+	// OpenFiles is the number of table, value log and memtable files, which
+	// BadgerDB keeps open and memory-mapped, and MappedBytes their total
+	// size. OpenFileLimit is the process's limit of open files (0 where it
+	// is not available). See Options.FileLimits.
+	OpenFiles     int
+	MappedBytes   int64
+	OpenFileLimit uint64
+
+	// AppendBatchSize is the largest number of Appends that
+	// Options.AdaptiveBatching currently commits together, and
+	// AppendLatencyP99 the 99th percentile of recent Append latencies it
+	// sized the batches by. AppendBatches is the number of batches
+	// committed, holding AppendsBatched Appends in total.
+	AppendBatchSize  int
+	AppendLatencyP99 time.Duration
+	AppendBatches    int64
+	AppendsBatched   int64
+
+	// FileLimitExceeded reports whether writes are rejected with
+	// ErrTooManyFiles.
+	FileLimitExceeded bool
+
+	// DecodeErrors is the number of stored records skipped by reads because
+	// their key or value could not be decoded. A non-zero value indicates
+	// data corruption.
+	DecodeErrors int64
+
+	// DanglingIndexEntries is the number of index entries skipped by reads
+	// because the event they point to does not exist.
+	DanglingIndexEntries int64
+
+	// AggregateCacheHits and AggregateCacheMisses count lookups in the
+	// aggregation cache enabled by Options.AggregateCacheSize.
+	AggregateCacheHits   int64
+	AggregateCacheMisses int64
+
+	// RetentionEligibleEvents and RetentionEligibleBytes are the number and
+	// approximate stored size of the events the retention policies would
+	// delete if cleanup ran now, excluding events held by HoldRetention.
+	// Finding them scans the keys of expired events, so the cost of Stats
+	// grows when cleanup falls behind or is paused.
+	RetentionEligibleEvents int64
+	RetentionEligibleBytes  int64
+
+	// MirrorErrors is the number of errors writing to the files of
+	// Options.Mirror. Events appended while writing failed are missing from
+	// the mirror.
+	MirrorErrors int64
+
+	// Reencrypting reports whether a pass of Encryption.ReencryptRate is in
+	// progress. ReencryptedRecords is the number of records the current or
+	// last pass rewrote, of the ReencryptTotal stored when it started, and
+	// LastReencryption is when the last pass completed.
+	Reencrypting       bool
+	ReencryptedRecords int64
+	ReencryptTotal     int64
+	LastReencryption   time.Time
+}
+
+// Stats returns a snapshot of the database counters.
+func (db *DB) Stats() Stats {
+	var s Stats
+
+	if c := db.cardinality; c != nil {
+		s.CardinalityRejections = atomic.LoadInt64(&c.rejected)
+		s.CardinalityDroppedTags = atomic.LoadInt64(&c.dropped)
+	}
+
+	s.FutureTimestampsRejected = atomic.LoadInt64(&db.drift.rejected)
+	s.FutureTimestampsClamped = atomic.LoadInt64(&db.drift.clamped)
+
+	if m := db.stalls; m != nil {
+		s.WriteStalls = atomic.LoadInt64(&m.count)
+		s.WriteStallTime = time.Duration(atomic.LoadInt64(&m.duration))
+	}
+
+	if w := db.watchdog; w != nil {
+		s.DiskFreeBytes = w.freeBytes.Load()
+		s.DiskLow = w.lowSpace.Load()
+		s.EmergencyDeletes = w.deleted.Load()
+	}
+
+	if b := db.batcher; b != nil {
+		s.AppendBatchSize = int(b.size.Load())
+		s.AppendLatencyP99 = time.Duration(b.p99.Load())
+		s.AppendBatches = b.batches.Load()
+		s.AppendsBatched = b.appends.Load()
+	}
+
+	if m := db.files; m != nil {
+		s.FileLimitExceeded = m.rejectWrites()
+	}
+	s.OpenFileLimit, _ = openFileLimit()
+
+	s.DecodeErrors = db.corrupt.Load()
+
+	if m := db.mirror; m != nil {
+		s.MirrorErrors = m.errors.Load()
+	}
+	s.DanglingIndexEntries = db.dangling.Load()
+
+	if r := db.reencrypt; r != nil {
+		state := r.snapshot()
+		s.Reencrypting = state.Running
+		s.ReencryptedRecords = state.Rewritten
+		s.ReencryptTotal = state.Total
+		s.LastReencryption = state.Completed
+	}
+
+	if c := db.aggCache; c != nil {
+		s.AggregateCacheHits = c.hits.Load()
+		s.AggregateCacheMisses = c.misses.Load()
+	}
+
+	db.mu.RLock()
+	closed := db.closed
+	db.mu.RUnlock()
+	if !closed {
+		// Stats has no error to report; a failed scan leaves the preview empty
+		if events, bytes, err := db.retentionPreview(); err == nil {
+			s.RetentionEligibleEvents = events
+			s.RetentionEligibleBytes = bytes
+		}
+		if u, err := countFiles(db.badger); err == nil {
+			s.OpenFiles = u.files
+			s.MappedBytes = u.bytes
+		}
+	}
+
+	return s
+}
+
+// ScanReport counts the records a single read skipped. Attach one to a
+// context with WithScanReport to find out whether the results of a Query,
+// Aggregate, Export or Replay call are incomplete.
+type ScanReport struct {
+	// DecodeErrors is the number of records whose key or value could not be decoded.
+	DecodeErrors int64
+
+	// DanglingIndexEntries is the number of index entries pointing to missing events.
+	DanglingIndexEntries int64
+
+	// Partial is set if a query with Query.Partial returned what it found
+	// before its context's deadline expired.
+	Partial bool
+}
+
+// Skipped returns the total number of records skipped.
+func (r *ScanReport) Skipped() int64 {
+	return r.DecodeErrors + r.DanglingIndexEntries
+}
+
+// scanReportKey is the context key for a *ScanReport.
+type scanReportKey struct{}
+
+// WithScanReport returns a context that makes reads record skipped records
+// in r. A report must not be shared by concurrent calls.
+func WithScanReport(ctx context.Context, r *ScanReport) context.Context {
+	return context.WithValue(ctx, scanReportKey{}, r)
+}
+
+// scanReportFrom returns the report attached to ctx, or nil.
+func scanReportFrom(ctx context.Context) *ScanReport {
+	r, _ := ctx.Value(scanReportKey{}).(*ScanReport)
+	return r
+}
+
+// recordCorrupt counts a record that could not be decoded.
+func (db *DB) recordCorrupt(ctx context.Context) {
+	db.corrupt.Add(1)
+	if r := scanReportFrom(ctx); r != nil {
+		r.DecodeErrors++
+	}
+	if s := execStatsFrom(ctx); s != nil {
+		s.DecodeErrors++
+	}
+}
+
+// recordDangling counts an index entry whose event is missing.
+func (db *DB) recordDangling(ctx context.Context) {
+	db.dangling.Add(1)
+	if r := scanReportFrom(ctx); r != nil {
+		r.DanglingIndexEntries++
+	}
+	if s := execStatsFrom(ctx); s != nil {
+		s.DanglingIndexEntries++
+	}
+}
+
+// ExecStats describes how reads were executed, for example so service
+// middleware can log the database cost of each request. Attach one to a
+// context with WithExecStats; the Query, QueryRaw, Aggregate, HourlyCounts,
+// Export and Replay calls made with the context add to it.
+type ExecStats struct {
+	// Plan is how the last read found its events: "type index",
+	// "tag:<key> index", "tag index intersection", "ingest index",
+	// "search index", "full scan", "hourly counts" or, for aggregations
+	// answered from Options.AggregateCacheSize's cache, "aggregate cache".
+	Plan string
+
+	// KeysScanned is the number of index, event and count keys visited.
+	KeysScanned int64
+
+	// EventsDecoded is the number of stored events decoded.
+	EventsDecoded int64
+
+	// DecodeErrors and DanglingIndexEntries count skipped records, as in ScanReport.
+	DecodeErrors         int64
+	DanglingIndexEntries int64
+
+	// Duration is the time spent in reads.
+	Duration time.Duration
+}
+
+// execStatsKey is the context key for an *ExecStats.
+type execStatsKey struct{}
+
+// WithExecStats returns a context that makes reads record how they were
+// executed in s. Stats must not be shared by concurrent calls.
+func WithExecStats(ctx context.Context, s *ExecStats) context.Context {
+	return context.WithValue(ctx, execStatsKey{}, s)
+}
+
+// execStatsFrom returns the stats attached to ctx, or nil.
+func execStatsFrom(ctx context.Context) *ExecStats {
+	s, _ := ctx.Value(execStatsKey{}).(*ExecStats)
+	return s
+}
+
+// recordPlan records how a read finds its events.
+func recordPlan(ctx context.Context, plan string) {
+	if s := execStatsFrom(ctx); s != nil {
+		s.Plan = plan
+	}
+}
+
+// recordScanned counts n keys visited by a read.
+func recordScanned(ctx context.Context, n int) {
+	if s := execStatsFrom(ctx); s != nil {
+		s.KeysScanned += int64(n)
+	}
+}
+
+// recordDecoded counts an event decoded by a read.
+func recordDecoded(ctx context.Context) {
+	if s := execStatsFrom(ctx); s != nil {
+		s.EventsDecoded++
+	}
+}
+
+// recordDuration adds the time since start to the duration of reads.
+func recordDuration(ctx context.Context, start time.Time) {
+	if s := execStatsFrom(ctx); s != nil {
+		s.Duration += time.Since(start)
+	}
+}
+
+// add accumulates the stats of another read, such as a stripe's share of one.
+func (s *ExecStats) add(o *ExecStats) {
+	if o.Plan != "" {
+		s.Plan = o.Plan
+	}
+	s.KeysScanned += o.KeysScanned
+	s.EventsDecoded += o.EventsDecoded
+	s.DecodeErrors += o.DecodeErrors
+	s.DanglingIndexEntries += o.DanglingIndexEntries
+}
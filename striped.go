@@ -0,0 +1,508 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// metaStripe records a database's position in a Striped set as "<index>/<count>".
+var metaStripe = encodeMetaKey("stripe")
+
+// Striped spreads events over several databases, typically on different
+// disks, to raise the write throughput beyond that of a single disk.
+//
+// Each event is stored in the stripe selected by a hash of its ID, so Get
+// and Update go to a single stripe while queries and aggregations fan out
+// to all of them and merge the results. IDs are assigned by the Striped
+// handle and stay time-ordered across stripes.
+//
+// The number and order of stripes are fixed when a set is created.
+// AppendBatch is only atomic within each stripe, and tag cardinality
+// limits apply to each stripe separately. Use Stripes for anything not
+// covered by Striped's methods, such as subscriptions or retention.
+type Striped struct {
+	stripes []*DB
+	opts    Options
+	ulids   *ulidSource
+}
+
+// OpenStriped creates or opens a striped database over the given directories.
+// The options apply to every stripe, except that a Mirror writes the files
+// of stripe i to the subdirectory i of its Dir. A database that was not created as
+// a stripe of the same set cannot be opened as one.
+func OpenStriped(paths []string, opts Options) (*Striped, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("squid: at least one stripe directory is required")
+	}
+	if len(opts.DerivedStreams) > 0 {
+		// Derived events would be stored with their source, not in the
+		// stripe their ID maps to
+		return nil, errors.New("squid: derived streams are not supported by striped databases")
+	}
+
+	s := &Striped{
+		opts:  opts,
+		ulids: newULIDSourceFrom(cryptoOf(opts).Rand()),
+	}
+
+	for i, path := range paths {
+		stripeOpts := opts
+		if opts.Mirror != nil {
+			// Each stripe mirrors to its own subdirectory
+			mirror := *opts.Mirror
+			mirror.Dir = filepath.Join(mirror.Dir, strconv.Itoa(i))
+			stripeOpts.Mirror = &mirror
+		}
+
+		db, err := OpenWithOptions(path, stripeOpts)
+		if err != nil {
+			s.Close()
+			return nil, fmt.Errorf("squid: opening stripe %s: %w", path, err)
+		}
+		db.assignedIDs = true
+		s.stripes = append(s.stripes, db)
+
+		if err := db.checkStripe(i, len(paths)); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("squid: opening stripe %s: %w", path, err)
+		}
+
+		s.ulids.seed(db.ulids.latest())
+	}
+
+	return s, nil
+}
+
+// checkStripe verifies that db is stripe index of count, recording it if
+// the database is new.
+func (db *DB) checkStripe(index, count int) error {
+	want := fmt.Sprintf("%d/%d", index, count)
+
+	return db.badger.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(metaStripe)
+		if err == nil {
+			got, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if string(got) != want {
+				return fmt.Errorf("database is stripe %s, not %s", got, want)
+			}
+			return nil
+		}
+		if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		// Events stored outside a stripe set would be looked up in the wrong stripe
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: eventKeyPrefix()})
+		defer it.Close()
+		if it.Rewind(); it.Valid() {
+			return errors.New("database already contains events and is not a stripe")
+		}
+
+		return txn.Set(metaStripe, []byte(want))
+	})
+}
+
+// Stripes returns the underlying databases, in the order they were opened.
+func (s *Striped) Stripes() []*DB {
+	return slices.Clone(s.stripes)
+}
+
+// stripeFor returns the stripe storing the event with the given ID.
+func (s *Striped) stripeFor(id ulid.ULID) *DB {
+	h := fnv.New64a()
+	h.Write(id[:])
+	return s.stripes[h.Sum64()%uint64(len(s.stripes))]
+}
+
+// assignment records what assignID changed about an event.
+type assignment struct {
+	defaulted, clamped bool
+	coerced            []string
+
+	given Event // the event before it was checked
+}
+
+// set copies the assignment to the result of appending the event, and
+// records the changes made to the event in the stripe it was stored in.
+// Tags dropped by cardinality limits are recorded by the stripe itself.
+func (a assignment) set(db *DB, result *AppendResult) {
+	result.TimestampDefaulted = a.defaulted
+	result.TimestampClamped = a.clamped
+	result.Coerced = a.coerced
+
+	if changes := ingestChanges(a.given, nil, result); len(changes) > 0 {
+		db.recordIngestErrors([]ingestIssue{{event: *result.Event, changes: changes}})
+	}
+}
+
+// assignID checks an event about to be appended and sets its timestamp and
+// ID, as DB.Append would.
+func (s *Striped) assignID(event *Event) (assignment, error) {
+	// Drift is checked against the first stripe, which also counts it
+	first := s.stripes[0]
+	now := first.now()
+
+	a := assignment{given: *event}
+	if event.Supersedes != nil {
+		// The correction could be stored in another stripe than the event
+		return a, errors.New("squid: corrections are not supported by striped databases")
+	}
+	var err error
+	if a.coerced, err = first.checkIngest(event, nil); err != nil {
+		first.recordIngestErrors(rejectedIngest(a.given, err))
+		return a, err
+	}
+	if a.clamped, err = first.checkFutureDrift(event, now); err != nil {
+		first.recordIngestErrors(rejectedIngest(a.given, err))
+		return a, err
+	}
+
+	a.defaulted = event.Timestamp.IsZero()
+	if a.defaulted {
+		event.Timestamp = now
+	}
+
+	if s.opts.IDSource == nil {
+		event.ID = s.ulids.New(event.Timestamp)
+		return a, nil
+	}
+
+	event.ID, err = s.opts.IDSource.NewID(event)
+	return a, err
+}
+
+// Append adds a new event to the stripe selected by its ID.
+func (s *Striped) Append(event Event) (*AppendResult, error) {
+	a, err := s.assignID(&event)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.stripeFor(event.ID)
+	result, err := db.Append(event)
+	if err != nil {
+		return nil, err
+	}
+	a.set(db, result)
+	return result, nil
+}
+
+// AppendBatch adds multiple events, writing each stripe's share in a single
+// transaction. If a stripe fails, the events already written to the other
+// stripes are kept.
+func (s *Striped) AppendBatch(events []Event) ([]*AppendResult, error) {
+	if len(events) == 0 {
+		return nil, nil
+	}
+
+	assigned := make([]assignment, len(events))
+	batches := make(map[*DB][]int) // stripe -> indexes into events
+	for i := range events {
+		var err error
+		assigned[i], err = s.assignID(&events[i])
+		if err != nil {
+			return nil, err
+		}
+		db := s.stripeFor(events[i].ID)
+		batches[db] = append(batches[db], i)
+	}
+
+	results := make([]*AppendResult, len(events))
+	for db, indexes := range batches {
+		batch := make([]Event, len(indexes))
+		for j, i := range indexes {
+			batch[j] = events[i]
+		}
+
+		stored, err := db.AppendBatch(batch)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range indexes {
+			assigned[i].set(db, stored[j])
+			results[i] = stored[j]
+		}
+	}
+
+	return results, nil
+}
+
+// Get retrieves a single event by its ID.
+func (s *Striped) Get(id ulid.ULID) (*Event, error) {
+	return s.stripeFor(id).Get(id)
+}
+
+// GetBatch retrieves events by ID from their stripes, as DB.GetBatch does.
+func (s *Striped) GetBatch(ids []ulid.ULID) ([]*Event, error) {
+	byStripe := make(map[*DB][]int)
+	for i, id := range ids {
+		db := s.stripeFor(id)
+		byStripe[db] = append(byStripe[db], i)
+	}
+
+	events := make([]*Event, len(ids))
+	for db, idx := range byStripe {
+		stripeIDs := make([]ulid.ULID, len(idx))
+		for j, i := range idx {
+			stripeIDs[j] = ids[i]
+		}
+		found, err := db.GetBatch(stripeIDs)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range idx {
+			events[i] = found[j]
+		}
+	}
+	return events, nil
+}
+
+// Update replaces an event's type, tags and data, as DB.Update does.
+func (s *Striped) Update(event Event) (*Event, error) {
+	return s.stripeFor(event.ID).Update(event)
+}
+
+// UpdateMetadata replaces an event's type and tags, as DB.UpdateMetadata does.
+func (s *Striped) UpdateMetadata(event Event) (*Event, error) {
+	return s.stripeFor(event.ID).UpdateMetadata(event)
+}
+
+// fanOut calls fn for every stripe concurrently and returns the first error.
+func (s *Striped) fanOut(fn func(i int, db *DB) error) error {
+	errs := make([]error, len(s.stripes))
+
+	var wg sync.WaitGroup
+	for i, db := range s.stripes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(i, db)
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Query finds events matching the given criteria in all stripes.
+func (s *Striped) Query(ctx context.Context, q Query) ([]*Event, error) {
+	if q.Offset < 0 {
+		return nil, fmt.Errorf("%w: offset must not be negative", ErrInvalidQuery)
+	}
+
+	// Any stripe may hold the events before the offset, so each returns
+	// all of them and the offset is applied after merging
+	sq := q
+	sq.Limit, sq.Offset = q.scanLimit(), 0
+
+	start := time.Now()
+	results := make([][]*Event, len(s.stripes))
+	reports := make([]ScanReport, len(s.stripes))
+	stats := make([]ExecStats, len(s.stripes))
+	err := s.fanOut(func(i int, db *DB) error {
+		var err error
+		results[i], err = db.Query(s.stripeContext(ctx, &reports[i], &stats[i]), sq)
+		return err
+	})
+	s.mergeReports(ctx, reports, stats, start)
+	if err != nil {
+		return nil, err
+	}
+
+	events := slices.Concat(results...)
+	if q.OrderByIngest {
+		sortByIngestOrder(events, q.Descending)
+	} else {
+		slices.SortFunc(events, func(a, b *Event) int {
+			if q.Descending {
+				return b.ID.Compare(a.ID)
+			}
+			return a.ID.Compare(b.ID)
+		})
+	}
+	if q.Offset > 0 {
+		events = events[min(q.Offset, len(events)):]
+	}
+	if q.Limit > 0 && len(events) > q.Limit {
+		events = events[:q.Limit]
+	}
+
+	return events, nil
+}
+
+// Aggregate computes aggregations over events matching the query in all stripes.
+func (s *Striped) Aggregate(ctx context.Context, q Query, field string, aggs []AggregationType) (*AggregateResult, error) {
+	start := time.Now()
+	partial := make([]*aggregator, len(s.stripes))
+	reports := make([]ScanReport, len(s.stripes))
+	stats := make([]ExecStats, len(s.stripes))
+
+	err := s.fanOut(func(i int, db *DB) error {
+		db.mu.RLock()
+		closed := db.closed
+		db.mu.RUnlock()
+		if closed {
+			return ErrClosed
+		}
+
+		partial[i] = newAggregator(field, aggs)
+		stripeCtx := s.stripeContext(ctx, &reports[i], &stats[i])
+		if err := db.aggregateInto(stripeCtx, q, partial[i]); !isPartial(stripeCtx, q, err) {
+			return err
+		}
+		return nil
+	})
+	s.mergeReports(ctx, reports, stats, start)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := newAggregator(field, aggs)
+	var skipped int64
+	var cut bool
+	for i := range partial {
+		if err := agg.merge(partial[i]); err != nil {
+			return nil, err
+		}
+		skipped += reports[i].Skipped()
+		cut = cut || reports[i].Partial
+	}
+
+	result := agg.result()
+	result.Skipped = skipped
+	result.Partial = cut
+	return result, nil
+}
+
+// stripeContext returns a context for a stripe's share of a read, recording
+// into the stripe's own report and stats so that stripes can run concurrently.
+func (s *Striped) stripeContext(ctx context.Context, report *ScanReport, stats *ExecStats) context.Context {
+	return WithExecStats(WithScanReport(ctx, report), stats)
+}
+
+// mergeReports adds the stripes' reports and stats to those attached to ctx.
+func (s *Striped) mergeReports(ctx context.Context, reports []ScanReport, stats []ExecStats, start time.Time) {
+	if report := scanReportFrom(ctx); report != nil {
+		for _, r := range reports {
+			report.DecodeErrors += r.DecodeErrors
+			report.DanglingIndexEntries += r.DanglingIndexEntries
+			report.Partial = report.Partial || r.Partial
+		}
+	}
+	if total := execStatsFrom(ctx); total != nil {
+		for i := range stats {
+			total.add(&stats[i])
+		}
+		total.Duration += time.Since(start)
+	}
+}
+
+// Count returns the total number of events in all stripes.
+func (s *Striped) Count() (int64, error) {
+	counts := make([]int64, len(s.stripes))
+	err := s.fanOut(func(i int, db *DB) error {
+		var err error
+		counts[i], err = db.Count()
+		return err
+	})
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	return total, err
+}
+
+// QueryCount returns the number of events matching the query in all stripes.
+func (s *Striped) QueryCount(ctx context.Context, q Query) (int64, error) {
+	start := time.Now()
+	counts := make([]int64, len(s.stripes))
+	reports := make([]ScanReport, len(s.stripes))
+	stats := make([]ExecStats, len(s.stripes))
+	err := s.fanOut(func(i int, db *DB) error {
+		var err error
+		counts[i], err = db.QueryCount(s.stripeContext(ctx, &reports[i], &stats[i]), q)
+		return err
+	})
+	s.mergeReports(ctx, reports, stats, start)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	return total, nil
+}
+
+// QuerySQL runs a SQL-like query across all stripes, as DB.QuerySQL does.
+func (s *Striped) QuerySQL(ctx context.Context, sql string) (*SQLResult, error) {
+	return runSQL(ctx, s, s.stripes[0].now(), sql)
+}
+
+// DeleteBefore deletes all events before the given time from every stripe.
+func (s *Striped) DeleteBefore(before time.Time) (int64, error) {
+	deleted := make([]int64, len(s.stripes))
+	err := s.fanOut(func(i int, db *DB) error {
+		var err error
+		deleted[i], err = db.DeleteBefore(before)
+		return err
+	})
+
+	var total int64
+	for _, n := range deleted {
+		total += n
+	}
+	return total, err
+}
+
+// SetRetention sets the default retention policy of every stripe.
+func (s *Striped) SetRetention(policy RetentionPolicy) {
+	for _, db := range s.stripes {
+		db.SetRetention(policy)
+	}
+}
+
+// PlaceLegalHold places a legal hold on every stripe.
+func (s *Striped) PlaceLegalHold(hold LegalHold) error {
+	return s.fanOut(func(_ int, db *DB) error {
+		return db.PlaceLegalHold(hold)
+	})
+}
+
+// ReleaseLegalHold releases the named legal hold on every stripe.
+func (s *Striped) ReleaseLegalHold(name string) error {
+	return s.fanOut(func(_ int, db *DB) error {
+		return db.ReleaseLegalHold(name)
+	})
+}
+
+// Close closes every stripe and returns the first error.
+func (s *Striped) Close() error {
+	var first error
+	for _, db := range s.stripes {
+		if err := db.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,214 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func openStripedTestDirs(t *testing.T, n int) []string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	paths := make([]string, n)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, string(rune('a'+i)))
+	}
+	return paths
+}
+
+func TestStriped(t *testing.T) {
+	paths := openStripedTestDirs(t, 3)
+
+	s, err := OpenStriped(paths, Options{})
+	if err != nil {
+		t.Fatalf("OpenStriped failed: %v", err)
+	}
+	defer s.Close()
+
+	var events []Event
+	for i := 0; i < 99; i++ {
+		events = append(events, Event{Type: "request", Data: map[string]any{"latency": float64(i + 1)}})
+	}
+	results, err := s.AppendBatch(events)
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+	last, err := s.Append(Event{Type: "request", Data: map[string]any{"latency": 100.0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if !last.TimestampDefaulted || !results[0].TimestampDefaulted {
+		t.Error("expected defaulted timestamps to be reported")
+	}
+	results = append(results, last)
+
+	// Events are spread over every stripe
+	for i, db := range s.Stripes() {
+		n, err := db.Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			t.Errorf("stripe %d is empty", i)
+		}
+	}
+	if n, _ := s.Count(); n != 100 {
+		t.Errorf("expected 100 events, got %d", n)
+	}
+	if n, err := s.QueryCount(context.Background(), Query{Types: []string{"request"}}); n != 100 || err != nil {
+		t.Errorf("expected 100 matching events, got %d, %v", n, err)
+	}
+
+	for _, r := range results {
+		e, err := s.Get(r.ID)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", r.ID, err)
+		}
+		if e.Data["latency"] != r.Data["latency"] {
+			t.Errorf("Get %s: unexpected event %v", r.ID, e)
+		}
+	}
+
+	if r, err := s.QuerySQL(context.Background(), "SELECT count(*) WHERE type = 'request'"); err != nil || r.Aggregate.Count != 100 {
+		t.Errorf("expected a SQL count of 100, got %+v, %v", r, err)
+	}
+
+	ids := make([]ulid.ULID, len(results))
+	for i, r := range results {
+		ids[len(ids)-1-i] = r.ID
+	}
+	batch, err := s.GetBatch(ids)
+	if err != nil {
+		t.Fatalf("GetBatch failed: %v", err)
+	}
+	for i, e := range batch {
+		if e == nil || e.ID != ids[i] {
+			t.Errorf("GetBatch %s: unexpected event %v", ids[i], e)
+		}
+	}
+
+	// Fan-out queries return events in ID order across stripes
+	got, err := s.Query(context.Background(), Query{Descending: true, Limit: 10})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 10 {
+		t.Fatalf("expected 10 events, got %d", len(got))
+	}
+	for i, e := range got {
+		if want := results[len(results)-1-i].ID; e.ID != want {
+			t.Errorf("event %d: expected %s, got %s", i, want, e.ID)
+		}
+	}
+
+	// Offsets are applied after merging
+	got, err = s.Query(context.Background(), Query{Limit: 5, Offset: 40})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(got) != 5 {
+		t.Fatalf("expected 5 events, got %d", len(got))
+	}
+	for i, e := range got {
+		if want := results[40+i].ID; e.ID != want {
+			t.Errorf("offset event %d: expected %s, got %s", i, want, e.ID)
+		}
+	}
+
+	agg, err := s.Aggregate(context.Background(), Query{}, "latency", []AggregationType{Count, Sum, Min, Max, P50})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if agg.Count != 100 || agg.Sum != 5050 || agg.Min != 1 || agg.Max != 100 || agg.P50 != 50.5 {
+		t.Errorf("unexpected aggregation %+v", agg)
+	}
+}
+
+func TestStripedLayout(t *testing.T) {
+	paths := openStripedTestDirs(t, 3)
+
+	s, err := OpenStriped(paths[:2], Options{})
+	if err != nil {
+		t.Fatalf("OpenStriped failed: %v", err)
+	}
+	first, err := s.Append(Event{Type: "event"})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	s.Close()
+
+	// Changing the number or order of stripes would misroute lookups
+	if _, err := OpenStriped(paths, Options{}); err == nil {
+		t.Error("expected error opening with an extra stripe")
+	}
+	if _, err := OpenStriped([]string{paths[1], paths[0]}, Options{}); err == nil {
+		t.Error("expected error opening stripes out of order")
+	}
+
+	db, err := Open(paths[2])
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	_, _ = db.Append(Event{Type: "event"})
+	db.Close()
+	if _, err := OpenStriped(paths[2:], Options{}); err == nil {
+		t.Error("expected error opening a database with events as a stripe")
+	}
+
+	s, err = OpenStriped(paths[:2], Options{})
+	if err != nil {
+		t.Fatalf("OpenStriped failed: %v", err)
+	}
+	defer s.Close()
+
+	second, err := s.Append(Event{Type: "event", Timestamp: first.Timestamp})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if second.ID.Compare(first.ID) <= 0 {
+		t.Errorf("expected %s to sort after %s after reopening", second.ID, first.ID)
+	}
+}
+
+func TestStripedExecStats(t *testing.T) {
+	paths := openStripedTestDirs(t, 3)
+
+	s, err := OpenStriped(paths, Options{})
+	if err != nil {
+		t.Fatalf("OpenStriped failed: %v", err)
+	}
+	defer s.Close()
+
+	var events []Event
+	for i := 0; i < 30; i++ {
+		events = append(events, Event{Type: "request", Data: map[string]any{"latency": float64(i)}})
+	}
+	if _, err := s.AppendBatch(events); err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	// Stripes are queried concurrently, each into its own stats
+	var stats ExecStats
+	ctx := WithExecStats(context.Background(), &stats)
+	if _, err := s.Query(ctx, Query{}); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if _, err := s.Aggregate(ctx, Query{}, "latency", []AggregationType{Avg}); err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if stats.EventsDecoded != 60 || stats.KeysScanned != 60 {
+		t.Errorf("expected 60 events decoded and keys scanned, got %+v", stats)
+	}
+	if stats.Plan != "full scan" || stats.Duration <= 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
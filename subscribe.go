@@ -0,0 +1,380 @@
+package squid
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy defines what a subscription does when its buffer is full.
+type OverflowPolicy int
+
+const (
+	// Block makes Append wait until the subscriber has made room.
+	// Slow subscribers slow down writers instead of using more memory.
+	Block OverflowPolicy = iota
+	// DropOldest discards the oldest buffered events to make room.
+	DropOldest
+	// FailSubscription ends the subscription with ErrSubscriptionOverflow.
+	FailSubscription
+)
+
+// SubscribeOptions configures a subscription.
+type SubscribeOptions struct {
+	// Query filters the delivered events by type and tags. Start and End
+	// restrict event timestamps; Limit and Descending are ignored.
+	Query Query
+
+	// BatchSize is the maximum number of events per batch. Defaults to 100.
+	BatchSize int
+
+	// BatchDelay is how long to wait for a batch to fill up before
+	// delivering a partial one (0 delivers events as soon as they arrive).
+	BatchDelay time.Duration
+
+	// BufferSize is the maximum number of events buffered for delivery.
+	// Defaults to 10 * BatchSize.
+	BufferSize int
+
+	// Overflow decides what happens when the buffer is full. Defaults to Block.
+	Overflow OverflowPolicy
+}
+
+// Subscription delivers batches of newly appended events.
+type Subscription struct {
+	// C receives batches of events in append order. It is closed when the
+	// subscription ends; Err then reports why.
+	C <-chan []*Event
+
+	c    chan []*Event
+	opts SubscribeOptions
+	db   *DB
+
+	mu      sync.Mutex
+	buf     []*Event
+	first   time.Time // when the oldest buffered event arrived
+	dropped int64
+	err     error
+
+	ready chan struct{} // signalled when events are buffered
+	space chan struct{} // signalled when buffer space is freed
+	done  chan struct{} // closed when the subscription ends
+	once  sync.Once
+}
+
+// subscriptionHub fans appended events out to subscriptions.
+type subscriptionHub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// newSubscriptionHub returns a hub without subscriptions.
+func newSubscriptionHub() *subscriptionHub {
+	return &subscriptionHub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe delivers events appended from now on that match opts.Query, in
+// batches, until ctx is cancelled, Close is called or the database is closed.
+//
+// Delivered events are shared between subscriptions and must not be modified.
+func (db *DB) Subscribe(ctx context.Context, opts SubscribeOptions) (*Subscription, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrClosed
+	}
+	if err := checkTypePattern(opts.Query); err != nil {
+		return nil, err
+	}
+	if err := checkWhere(opts.Query); err != nil {
+		return nil, err
+	}
+	if err := checkSearch(opts.Query); err != nil {
+		return nil, err
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10 * opts.BatchSize
+	}
+
+	c := make(chan []*Event)
+	s := &Subscription{
+		C:     c,
+		c:     c,
+		opts:  opts,
+		db:    db,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	db.subs.mu.Lock()
+	db.subs.subs[s] = struct{}{}
+	db.subs.mu.Unlock()
+
+	go s.deliver(ctx)
+
+	return s, nil
+}
+
+// NewSubscription returns a Subscription fed by run instead of by the
+// appends to a DB, for implementations of Subscribe elsewhere, such as
+// squidclient's. run delivers batches with send, which reports false once
+// the subscription has ended, and the error it returns ends the
+// subscription. The context run is given is cancelled when ctx is or when
+// Close is called.
+func NewSubscription(ctx context.Context, run func(ctx context.Context, send func([]*Event) bool) error) *Subscription {
+	c := make(chan []*Event)
+	s := &Subscription{
+		C:    c,
+		c:    c,
+		done: make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-s.done
+		cancel()
+	}()
+	go func() {
+		defer close(s.c)
+
+		send := func(batch []*Event) bool {
+			select {
+			case s.c <- batch:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		err := run(ctx, send)
+		if ctx.Err() != nil {
+			// Cancelled by ctx, or by Close, which already ended it
+			err = ctx.Err()
+		}
+		s.end(err)
+	}()
+
+	return s
+}
+
+// Close ends the subscription. Buffered events are discarded.
+func (s *Subscription) Close() {
+	s.end(nil)
+}
+
+// Err returns the reason the subscription ended: the context's error,
+// ErrSubscriptionOverflow, ErrClosed, or nil if Close was called or the
+// subscription is still active.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Dropped returns the number of events discarded by the DropOldest policy.
+func (s *Subscription) Dropped() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// end stops the subscription, recording err as the reason.
+func (s *Subscription) end(err error) {
+	s.once.Do(func() {
+		if s.db != nil {
+			hub := s.db.subs
+			hub.mu.Lock()
+			delete(hub.subs, s)
+			hub.mu.Unlock()
+		}
+
+		s.mu.Lock()
+		s.err = err
+		s.buf = nil
+		s.mu.Unlock()
+
+		close(s.done)
+	})
+}
+
+// active reports whether there are any subscriptions.
+func (h *subscriptionHub) active() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs) > 0
+}
+
+// publish offers newly stored events to every matching subscription.
+func (h *subscriptionHub) publish(events []*Event) {
+	h.mu.RLock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.mu.RUnlock()
+
+	for _, s := range subs {
+		for _, event := range events {
+			if !s.matches(event) {
+				continue
+			}
+			if !s.push(event) {
+				break
+			}
+		}
+	}
+}
+
+// closeAll ends every subscription with err.
+func (h *subscriptionHub) closeAll(err error) {
+	h.mu.RLock()
+	subs := make([]*Subscription, 0, len(h.subs))
+	for s := range h.subs {
+		subs = append(subs, s)
+	}
+	h.mu.RUnlock()
+
+	for _, s := range subs {
+		s.end(err)
+	}
+}
+
+// matches checks an event against the subscription's query.
+func (s *Subscription) matches(event *Event) bool {
+	q := s.opts.Query
+	if q.Start != nil && event.Timestamp.Before(*q.Start) {
+		return false
+	}
+	if q.End != nil && event.Timestamp.After(*q.End) {
+		return false
+	}
+	return s.db.matchesFilters(event, q) && matchesData(event, q)
+}
+
+// push buffers an event, applying the overflow policy if the buffer is full.
+// It returns false if the subscription has ended.
+func (s *Subscription) push(event *Event) bool {
+	for {
+		s.mu.Lock()
+		select {
+		case <-s.done:
+			s.mu.Unlock()
+			return false
+		default:
+		}
+
+		if len(s.buf) < s.opts.BufferSize {
+			if len(s.buf) == 0 {
+				s.first = time.Now()
+			}
+			s.buf = append(s.buf, event)
+			if len(s.buf) < s.opts.BufferSize {
+				// Let the next blocked writer in
+				signal(s.space)
+			}
+			s.mu.Unlock()
+			signal(s.ready)
+			return true
+		}
+
+		switch s.opts.Overflow {
+		case DropOldest:
+			s.buf = append(s.buf[1:], event)
+			s.dropped++
+			s.mu.Unlock()
+			signal(s.ready)
+			return true
+		case FailSubscription:
+			s.mu.Unlock()
+			s.end(ErrSubscriptionOverflow)
+			return false
+		}
+		s.mu.Unlock()
+
+		// Block until the subscriber frees space
+		select {
+		case <-s.space:
+		case <-s.done:
+			return false
+		}
+	}
+}
+
+// deliver assembles batches and sends them on C until the subscription ends.
+func (s *Subscription) deliver(ctx context.Context) {
+	defer close(s.c)
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		batch, wait := s.nextBatch(time.Now())
+		if batch != nil {
+			select {
+			case s.c <- batch:
+				continue
+			case <-ctx.Done():
+				s.end(ctx.Err())
+				return
+			case <-s.done:
+				return
+			}
+		}
+
+		var timerC <-chan time.Time
+		if wait > 0 {
+			timer.Reset(wait)
+			timerC = timer.C
+		}
+
+		select {
+		case <-s.ready:
+		case <-timerC:
+		case <-ctx.Done():
+			s.end(ctx.Err())
+			return
+		case <-s.done:
+			return
+		}
+		timer.Stop()
+	}
+}
+
+// nextBatch takes a batch from the buffer if one is due. Otherwise it
+// returns how long to wait for a partial batch to become due (0 means wait
+// for more events).
+func (s *Subscription) nextBatch(now time.Time) ([]*Event, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf) == 0 {
+		return nil, 0
+	}
+
+	if len(s.buf) < s.opts.BatchSize && s.opts.BatchDelay > 0 {
+		if wait := s.first.Add(s.opts.BatchDelay).Sub(now); wait > 0 {
+			return nil, wait
+		}
+	}
+
+	n := min(len(s.buf), s.opts.BatchSize)
+	batch := make([]*Event, n)
+	copy(batch, s.buf)
+	s.buf = append(s.buf[:0], s.buf[n:]...)
+
+	signal(s.space)
+	return batch, 0
+}
+
+// signal performs a non-blocking send on a 1-buffered channel.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
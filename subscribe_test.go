@@ -0,0 +1,294 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receive reads batches from a subscription until n events have arrived.
+func receive(t *testing.T, sub *Subscription, n int) [][]*Event {
+	t.Helper()
+
+	var batches [][]*Event
+	timeout := time.After(5 * time.Second)
+	for got := 0; got < n; {
+		select {
+		case batch, ok := <-sub.C:
+			if !ok {
+				t.Fatalf("subscription ended after %d events: %v", got, sub.Err())
+			}
+			batches = append(batches, batch)
+			got += len(batch)
+		case <-timeout:
+			t.Fatalf("timed out after %d of %d events", got, n)
+		}
+	}
+	return batches
+}
+
+func TestSubscribeBatches(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), SubscribeOptions{
+		Query:      Query{Types: []string{"request"}},
+		BatchSize:  5,
+		BatchDelay: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	var events []Event
+	for i := 0; i < 12; i++ {
+		eventType := "request"
+		if i%3 == 0 {
+			eventType = "error"
+		}
+		events = append(events, Event{Type: eventType, Data: map[string]any{"i": i}})
+	}
+	results, err := db.AppendBatch(events)
+	if err != nil {
+		t.Fatalf("AppendBatch failed: %v", err)
+	}
+
+	var want []*AppendResult
+	for _, r := range results {
+		if r.Type == "request" {
+			want = append(want, r)
+		}
+	}
+
+	var got []*Event
+	for _, batch := range receive(t, sub, len(want)) {
+		if len(batch) > 5 {
+			t.Errorf("batch of %d exceeds BatchSize", len(batch))
+		}
+		got = append(got, batch...)
+	}
+
+	for i, e := range got {
+		if e.ID != want[i].ID {
+			t.Fatalf("event %d: expected %s, got %s", i, want[i].ID, e.ID)
+		}
+	}
+}
+
+func TestSubscribeBatchDelay(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	delay := 50 * time.Millisecond
+	sub, err := db.Subscribe(context.Background(), SubscribeOptions{
+		BatchSize:  10,
+		BatchDelay: delay,
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	start := time.Now()
+	_, _ = db.Append(Event{Type: "event"})
+	_, _ = db.Append(Event{Type: "event"})
+
+	batches := receive(t, sub, 2)
+	if len(batches) != 1 {
+		t.Errorf("expected one partial batch, got %d", len(batches))
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("partial batch delivered after %v, before BatchDelay", elapsed)
+	}
+}
+
+func TestSubscribeDropOldest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), SubscribeOptions{
+		BatchSize:  5,
+		BufferSize: 5,
+		Overflow:   DropOldest,
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	var last *AppendResult
+	for i := 0; i < 50; i++ {
+		last, err = db.Append(Event{Type: "event"})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	if sub.Dropped() == 0 {
+		t.Fatal("expected events to be dropped")
+	}
+
+	// The newest event is always kept
+	var received int64
+	for {
+		batch := receive(t, sub, 1)[0]
+		received += int64(len(batch))
+		if batch[len(batch)-1].ID == last.ID {
+			break
+		}
+	}
+	if received+sub.Dropped() != 50 {
+		t.Errorf("expected received + dropped = 50, got %d + %d", received, sub.Dropped())
+	}
+}
+
+func TestSubscribeFailOnOverflow(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), SubscribeOptions{
+		BatchSize:  1,
+		BufferSize: 2,
+		Overflow:   FailSubscription,
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := db.Append(Event{Type: "event"}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	for range sub.C {
+	}
+	if sub.Err() != ErrSubscriptionOverflow {
+		t.Errorf("expected ErrSubscriptionOverflow, got %v", sub.Err())
+	}
+}
+
+func TestSubscribeBlock(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	sub, err := db.Subscribe(context.Background(), SubscribeOptions{
+		BatchSize:  1,
+		BufferSize: 1,
+	})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer sub.Close()
+
+	var appended atomic.Int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if _, err := db.Append(Event{Type: "event"}); err != nil {
+				t.Error(err)
+				return
+			}
+			appended.Add(1)
+		}
+	}()
+
+	// One event in flight on C and one in the buffer
+	time.Sleep(50 * time.Millisecond)
+	if n := appended.Load(); n >= 10 {
+		t.Fatalf("expected writers to block, %d appends completed", n)
+	}
+
+	receive(t, sub, 10)
+	<-done
+}
+
+func TestSubscribeEnds(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled, err := db.Subscribe(ctx, SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	closed, err := db.Subscribe(context.Background(), SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	cancel()
+	for range cancelled.C {
+	}
+	if cancelled.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", cancelled.Err())
+	}
+
+	db.Close()
+	for range closed.C {
+	}
+	if closed.Err() != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", closed.Err())
+	}
+
+	if _, err := db.Subscribe(context.Background(), SubscribeOptions{}); err != ErrClosed {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package squid
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+// TagKeys returns the distinct tag keys of the stored events, sorted. Keys
+// are read from the tag index, visiting only the first entry of each, so
+// that exploration tools can discover the tags to pass to TagValues.
+func (db *DB) TagKeys(ctx context.Context) ([]string, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer recordDuration(ctx, time.Now())
+
+	var keys []string
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false // Index keys have no values
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var scanned int
+		defer func() { recordScanned(ctx, scanned) }()
+
+		prefix := []byte(prefixTag)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			scanned++
+
+			k, _, _, err := decodeTagIndexKey(it.Item().Key())
+			if err != nil {
+				db.recordCorrupt(ctx)
+				it.Next()
+				continue
+			}
+			keys = append(keys, k)
+			it.Seek(prefixEnd(encodeTagKeyPrefix(k)))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Keys are ordered by length first in the index
+	slices.Sort(keys)
+	return keys, nil
+}
+
+// TagValues returns the distinct values of a tag key, sorted, such as the
+// hosts to offer in a filter dropdown. Values are read from the tag index,
+// which holds one entry per event, so only the first entry of each value
+// is visited.
+func (db *DB) TagValues(ctx context.Context, key string) ([]string, error) {
+	return db.tagValues(ctx, key, nil, nil)
+}
+
+// TagValuesBetween returns the distinct values of a tag key on events
+// timestamped from start to end inclusive, sorted. Each value costs a seek
+// to its first entry in the range.
+func (db *DB) TagValuesBetween(ctx context.Context, key string, start, end time.Time) ([]string, error) {
+	if end.Before(start) {
+		return nil, nil
+	}
+	return db.tagValues(ctx, key, &start, &end)
+}
+
+// tagValues implements TagValues and TagValuesBetween; nil bounds leave
+// the time range open.
+func (db *DB) tagValues(ctx context.Context, key string, start, end *time.Time) ([]string, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer recordDuration(ctx, time.Now())
+	recordPlan(ctx, describeIndex("tag:"+key))
+
+	var values []string
+	err := db.badger.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false // Index keys have no values
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		var from ulid.ULID
+		if start != nil {
+			from = ulid.MustNew(ulid.Timestamp(*start), nil)
+		}
+
+		var scanned int
+		defer func() { recordScanned(ctx, scanned) }()
+
+		prefix := encodeTagKeyPrefix(key)
+		for it.Seek(prefix); it.ValidForPrefix(prefix); {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			scanned++
+
+			_, v, _, err := decodeTagIndexKey(it.Item().Key())
+			if err != nil {
+				db.recordCorrupt(ctx)
+				it.Next()
+				continue
+			}
+			vp := encodeTagIndexPrefix(key, v)
+
+			// Entries of a value are in ID order, so the first at or
+			// after start tells whether any is in the range
+			if start != nil {
+				it.Seek(append(slices.Clip(vp), from[:]...))
+				if !it.ValidForPrefix(vp) {
+					continue
+				}
+				scanned++
+				id, err := decodeIndexKey(it.Item().Key())
+				if err != nil || ulidTime(id).After(*end) {
+					it.Seek(prefixEnd(vp))
+					continue
+				}
+			}
+
+			values = append(values, v)
+			it.Seek(prefixEnd(vp))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.Sort(values)
+	return values, nil
+}
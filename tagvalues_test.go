@@ -0,0 +1,85 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTagValues(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	hosts := []string{"web-10", "web-2", "db", "web-2", "cache", "web-10", "db"}
+	for i, host := range hosts {
+		_, err := db.Append(Event{
+			Timestamp: base.Add(time.Duration(i) * time.Hour),
+			Type:      "request",
+			Tags:      map[string]string{"host": host, "region": "eu"},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	keys, err := db.TagKeys(ctx)
+	if err != nil {
+		t.Fatalf("TagKeys failed: %v", err)
+	}
+	if want := []string{"host", "region"}; !slices.Equal(keys, want) {
+		t.Errorf("expected keys %v, got %v", want, keys)
+	}
+
+	values, err := db.TagValues(ctx, "host")
+	if err != nil {
+		t.Fatalf("TagValues failed: %v", err)
+	}
+	if want := []string{"cache", "db", "web-10", "web-2"}; !slices.Equal(values, want) {
+		t.Errorf("expected %v, got %v", want, values)
+	}
+
+	tests := []struct {
+		start, end time.Time
+		want       []string
+	}{
+		{base.Add(time.Hour), base.Add(3 * time.Hour), []string{"db", "web-2"}},
+		{base.Add(4 * time.Hour), base.Add(4 * time.Hour), []string{"cache"}},
+		{base.Add(90 * time.Minute), base.Add(100 * time.Minute), nil},
+		{base.Add(7 * time.Hour), base.Add(24 * time.Hour), nil},
+	}
+	for _, tt := range tests {
+		values, err := db.TagValuesBetween(ctx, "host", tt.start, tt.end)
+		if err != nil {
+			t.Fatalf("TagValuesBetween failed: %v", err)
+		}
+		if !slices.Equal(values, tt.want) {
+			t.Errorf("%s to %s: expected %v, got %v", tt.start, tt.end, tt.want, values)
+		}
+	}
+
+	if values, err := db.TagValues(ctx, "missing"); err != nil || len(values) != 0 {
+		t.Errorf("expected no values of a missing key, got %v, %v", values, err)
+	}
+
+	// Only the first entry of each value is read
+	var stats ExecStats
+	if _, err := db.TagValues(WithExecStats(ctx, &stats), "host"); err != nil {
+		t.Fatalf("TagValues failed: %v", err)
+	}
+	if stats.KeysScanned != 4 {
+		t.Errorf("expected 4 keys scanned, got %d", stats.KeysScanned)
+	}
+}
@@ -0,0 +1,196 @@
+package squid
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Tx is a transaction started by DB.Tx. Its methods must only be called
+// from the function passed to DB.Tx.
+type Tx struct {
+	db     *DB
+	txn    *badger.Txn
+	events []*Event
+	deltas countDeltas
+
+	// now is the ingest time of the events
+	now time.Time
+
+	// issues are the changes made to the events, recorded as ingest errors
+	// once the transaction commits
+	issues []ingestIssue
+
+	// admitted holds the tag values the events were admitted with
+	admitted admission
+}
+
+// Tx runs fn in a transaction that appends events and reads and writes
+// application metadata atomically: either everything fn did is stored or,
+// if fn or the commit fails, nothing is. This allows outbox patterns, such
+// as storing events with the updated position or counter of the process
+// that produced them, without the anomalies of writing the two separately.
+//
+// The transaction sees its own writes. If fn read metadata that another
+// transaction changed before this one committed, Tx returns an error
+// wrapping ErrTxConflict and fn can be run again. Events appended by fn are
+// delivered to subscribers and the mirror only once committed. A
+// transaction is bounded in size by BadgerDB; very large batches fail with
+// badger.ErrTxnTooBig.
+func (db *DB) Tx(fn func(tx *Tx) error) error {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if db.watchdog != nil && db.watchdog.rejectWrites() {
+		return ErrDiskFull
+	}
+	if db.files != nil && db.files.rejectWrites() {
+		return ErrTooManyFiles
+	}
+
+	// Appends within fn all take the ingest time of the transaction
+	now, done := db.ingest.begin(db.now)
+	defer done()
+
+	var events []Event
+	var tx *Tx
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		tx = &Tx{db: db, txn: txn, deltas: make(countDeltas), now: now}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		for _, e := range tx.events {
+			events = append(events, *e)
+		}
+		return db.counts.write(txn, tx.deltas)
+	})
+	if tx != nil {
+		db.cardinality.settle(tx.admitted, err == nil)
+	}
+	if errors.Is(err, badger.ErrConflict) {
+		return fmt.Errorf("%w: %v", ErrTxConflict, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	db.appended(events, nil)
+	db.recordIngestErrors(tx.issues)
+	return nil
+}
+
+// Append adds an event as DB.Append does. The returned result describes
+// the event as it will be stored if the transaction commits. With
+// Options.RecordIngestErrors, rejected events are recorded at once and the
+// changes made to accepted ones once the transaction commits.
+func (tx *Tx) Append(event Event) (*AppendResult, error) {
+	db := tx.db
+	given := event
+	coerced, err := db.checkIngest(&event, nil)
+	if err != nil {
+		db.recordIngestErrors(rejectedIngest(given, err))
+		return nil, err
+	}
+
+	now := tx.now
+	clamped, err := db.checkFutureDrift(&event, now)
+	if err != nil {
+		db.recordIngestErrors(rejectedIngest(given, err))
+		return nil, err
+	}
+
+	tags := event.Tags
+	var admitted admission
+	if db.cardinality != nil {
+		if admitted, err = db.cardinality.admit(&event); err != nil {
+			db.recordIngestErrors(rejectedIngest(given, err))
+			return nil, err
+		}
+	}
+	// Values of events that fail here are forgotten at once, the others
+	// once the transaction commits or fails
+	written := false
+	defer func() {
+		if written {
+			tx.admitted = append(tx.admitted, admitted...)
+		} else {
+			db.cardinality.settle(admitted, false)
+		}
+	}()
+
+	result := &AppendResult{Event: &event, TimestampClamped: clamped, Coerced: coerced}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = now
+		result.TimestampDefaulted = true
+	}
+	event.IngestedAt = now
+
+	id, err := db.newID(&event)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.checkDuplicateID(tx.txn, id); err != nil {
+		return nil, err
+	}
+	if err := db.checkSupersedes(tx.txn, &event); err != nil {
+		return nil, err
+	}
+	event.ID = id
+	event.Version = 1
+
+	meta, data, err := db.encodeEvent(&event)
+	if err != nil {
+		return nil, err
+	}
+	result.Bytes, result.IndexEntries, err = db.writeEvent(tx.txn, &event, meta, data)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.deltas.add(event.Type, event.ID, 1)
+	tx.events = append(tx.events, &event)
+
+	derived, err := db.writeDerived(tx.txn, &event, nil, tx.deltas)
+	if err != nil {
+		return nil, err
+	}
+	for i := range derived {
+		tx.events = append(tx.events, &derived[i])
+	}
+	if changes := ingestChanges(given, tags, result); len(changes) > 0 {
+		tx.issues = append(tx.issues, ingestIssue{event: event, changes: changes})
+	}
+	written = true
+	return result, nil
+}
+
+// GetMeta returns the application metadata value stored under key, as
+// DB.GetMeta does, including values set earlier in the transaction.
+func (tx *Tx) GetMeta(key string) ([]byte, error) {
+	if err := checkMetaKey(key); err != nil {
+		return nil, err
+	}
+	item, err := tx.txn.Get(encodeAppMetaKey(key))
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrMetaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+// SetMeta stores an application metadata value as DB.SetMeta does. A nil
+// value deletes the key.
+func (tx *Tx) SetMeta(key string, value []byte) error {
+	if err := checkMetaKey(key); err != nil {
+		return err
+	}
+	return writeMeta(tx.txn, map[string][]byte{key: value})
+}
@@ -0,0 +1,86 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	// increment appends an event and bumps a counter stored alongside it
+	increment := func(tx *Tx) error {
+		n := 0
+		value, err := tx.GetMeta("orders")
+		if err == nil {
+			n, _ = strconv.Atoi(string(value))
+		} else if !errors.Is(err, ErrMetaNotFound) {
+			return err
+		}
+		if _, err := tx.Append(Event{Type: "order", Data: map[string]any{"n": float64(n + 1)}}); err != nil {
+			return err
+		}
+		return tx.SetMeta("orders", []byte(strconv.Itoa(n+1)))
+	}
+	for i := 0; i < 3; i++ {
+		if err := db.Tx(increment); err != nil {
+			t.Fatalf("Tx failed: %v", err)
+		}
+	}
+	if value, err := db.GetMeta("orders"); err != nil || string(value) != "3" {
+		t.Errorf("expected counter 3, got %q, %v", value, err)
+	}
+
+	// A failing function stores nothing
+	err = db.Tx(func(tx *Tx) error {
+		if err := increment(tx); err != nil {
+			return err
+		}
+		if value, err := tx.GetMeta("orders"); err != nil || string(value) != "4" {
+			t.Errorf("expected the transaction to see its own write, got %q, %v", value, err)
+		}
+		_, err := tx.Append(Event{Type: ""})
+		return err
+	})
+	if !errors.Is(err, ErrEmptyType) {
+		t.Errorf("expected ErrEmptyType, got %v", err)
+	}
+	if n, err := db.Count(); err != nil || n != 3 {
+		t.Errorf("expected 3 events after the failed transaction, got %d, %v", n, err)
+	}
+	if value, _ := db.GetMeta("orders"); string(value) != "3" {
+		t.Errorf("expected counter 3 after the failed transaction, got %q", value)
+	}
+
+	// A concurrent change to the metadata read fails the transaction
+	err = db.Tx(func(tx *Tx) error {
+		if err := increment(tx); err != nil {
+			return err
+		}
+		return db.SetMeta("orders", []byte("100"))
+	})
+	if !errors.Is(err, ErrTxConflict) {
+		t.Errorf("expected ErrTxConflict, got %v", err)
+	}
+
+	events, err := db.Query(context.Background(), Query{Types: []string{"order"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 3 || events[2].Data["n"] != 3.0 {
+		t.Errorf("expected 3 orders, got %+v", events)
+	}
+}
@@ -0,0 +1,124 @@
+package squid
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// TypeCount is the number of stored events of one type.
+type TypeCount struct {
+	Type  string `json:"type"`
+	Count int64  `json:"count"`
+}
+
+// Types returns the distinct types of the stored events, sorted. Types are
+// read from the type index, visiting only the first entry of each.
+func (db *DB) Types(ctx context.Context) ([]string, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer recordDuration(ctx, time.Now())
+	recordPlan(ctx, describeIndex("type"))
+
+	var types []string
+	err := db.badger.View(func(txn *badger.Txn) error {
+		var err error
+		types, err = db.scanTypes(ctx, txn)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return types, nil
+}
+
+// TypeCounts returns the distinct types of the stored events with the
+// number of events of each, sorted by type. Counts are summed from the
+// hourly counts, so this is fast regardless of the number of events; with
+// Options.CountRetention set, as for HourlyCounts, they include expired
+// events until the counts themselves expire.
+func (db *DB) TypeCounts(ctx context.Context) ([]TypeCount, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer recordDuration(ctx, time.Now())
+	recordPlan(ctx, "hourly counts")
+
+	var result []TypeCount
+	err := db.badger.View(func(txn *badger.Txn) error {
+		types, err := db.scanTypes(ctx, txn)
+		if err != nil {
+			return err
+		}
+
+		counts := make(map[string]int64, len(types))
+		err = db.scanCounts(ctx, txn, Query{Types: types}, func(k countKey, n int64) {
+			counts[k.eventType] += n
+		})
+		if err != nil {
+			return err
+		}
+
+		result = make([]TypeCount, 0, len(types))
+		for _, t := range types {
+			result = append(result, TypeCount{Type: t, Count: max(counts[t], 0)})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// scanTypes returns the distinct types in the type index, sorted. Entries
+// of a type are adjacent, so each type's are skipped once it is found.
+func (db *DB) scanTypes(ctx context.Context, txn *badger.Txn) ([]string, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false // Index keys have no values
+
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var types []string
+	var scanned int
+	defer func() { recordScanned(ctx, scanned) }()
+
+	prefix := []byte(prefixType)
+	for it.Seek(prefix); it.ValidForPrefix(prefix); {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		scanned++
+
+		t, _, err := decodeTypeIndexKey(it.Item().Key())
+		if err != nil {
+			db.recordCorrupt(ctx)
+			it.Next()
+			continue
+		}
+		types = append(types, t)
+		it.Seek(prefixEnd(encodeTypeIndexPrefix(t)))
+	}
+
+	// Types are ordered by length first in the index
+	slices.Sort(types)
+	return types, nil
+}
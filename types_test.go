@@ -0,0 +1,58 @@
+package squid
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTypes(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if types, err := db.Types(ctx); err != nil || len(types) != 0 {
+		t.Errorf("expected no types in an empty database, got %v, %v", types, err)
+	}
+
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	types := []string{"request", "error", "login", "request", "error", "request", "audit"}
+	for i, typ := range types {
+		if _, err := db.Append(Event{Timestamp: base.Add(time.Duration(i) * time.Hour), Type: typ}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	// The only login event is deleted, and with it its type
+	if _, err := db.DeleteBefore(base.Add(3 * time.Hour)); err != nil {
+		t.Fatalf("DeleteBefore failed: %v", err)
+	}
+
+	got, err := db.Types(ctx)
+	if err != nil {
+		t.Fatalf("Types failed: %v", err)
+	}
+	if want := []string{"audit", "error", "request"}; !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	counts, err := db.TypeCounts(ctx)
+	if err != nil {
+		t.Fatalf("TypeCounts failed: %v", err)
+	}
+	want := []TypeCount{{"audit", 1}, {"error", 1}, {"request", 2}}
+	if !slices.Equal(counts, want) {
+		t.Errorf("expected %v, got %v", want, counts)
+	}
+}
@@ -2,23 +2,53 @@ package squid
 
 import (
 	"crypto/rand"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/oklog/ulid/v2"
 )
 
+// IDSource generates the IDs of appended events.
+//
+// IDs must stay time-sortable: the ULID time component of an ID must be the
+// event's timestamp in milliseconds, which Append checks. The remaining 80
+// bits are free, so IDs from another system (e.g. a snowflake's worker and
+// sequence bits, or a hash of an external key) can be carried in them.
+// Append rejects IDs that are already stored.
+type IDSource interface {
+	NewID(event *Event) (ulid.ULID, error)
+}
+
+// IDSourceFunc adapts a function to an IDSource.
+type IDSourceFunc func(event *Event) (ulid.ULID, error)
+
+// NewID calls f(event).
+func (f IDSourceFunc) NewID(event *Event) (ulid.ULID, error) {
+	return f(event)
+}
+
 // ulidSource provides monotonic ULID generation.
 // It ensures that ULIDs generated within the same millisecond are ordered.
 type ulidSource struct {
 	mu      sync.Mutex
 	entropy *ulid.MonotonicEntropy
+
+	// last is the highest ULID stored or generated. ULIDs generated in its
+	// millisecond follow it, so ordering holds across restarts.
+	last ulid.ULID
 }
 
 // newULIDSource creates a new monotonic ULID source.
 func newULIDSource() *ulidSource {
+	return newULIDSourceFrom(rand.Reader)
+}
+
+// newULIDSourceFrom creates a new monotonic ULID source drawing entropy
+// from r.
+func newULIDSourceFrom(r io.Reader) *ulidSource {
 	return &ulidSource{
-		entropy: ulid.Monotonic(rand.Reader, 0),
+		entropy: ulid.Monotonic(r, 0),
 	}
 }
 
@@ -26,7 +56,56 @@ func newULIDSource() *ulidSource {
 func (s *ulidSource) New(t time.Time) ulid.ULID {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return ulid.MustNew(ulid.Timestamp(t), s.entropy)
+
+	ms := ulid.Timestamp(t)
+	if ms == s.last.Time() {
+		if id, ok := incrementULID(s.last); ok {
+			s.last = id
+			return id
+		}
+	}
+
+	id := ulid.MustNew(ms, s.entropy)
+	if id.Compare(s.last) > 0 {
+		s.last = id
+	}
+	return id
+}
+
+// seed records the highest ULID already stored, so that IDs generated in
+// the same millisecond after a restart sort after it.
+func (s *ulidSource) seed(id ulid.ULID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if id.Compare(s.last) > 0 {
+		s.last = id
+	}
+}
+
+// latest returns the highest ULID stored or generated.
+func (s *ulidSource) latest() ulid.ULID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// incrementULID returns the ULID following id in the same millisecond.
+// It returns false if the entropy of id is already at its maximum.
+func incrementULID(id ulid.ULID) (ulid.ULID, bool) {
+	// Bytes 6-15 hold the entropy
+	for i := len(id) - 1; i >= 6; i-- {
+		id[i]++
+		if id[i] != 0 {
+			return id, true
+		}
+	}
+	return ulid.ULID{}, false
+}
+
+// NewID generates a new ULID with the event's timestamp.
+func (s *ulidSource) NewID(event *Event) (ulid.ULID, error) {
+	return s.New(event.Timestamp), nil
 }
 
 // Now generates a new ULID with the current timestamp.
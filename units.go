@@ -0,0 +1,188 @@
+package squid
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unit is the unit of a numeric data field whose producers may send values
+// as strings with a unit suffix, such as "150ms" or "2.5MB". See
+// Options.Units.
+type Unit int
+
+const (
+	// Milliseconds stores durations such as "150ms" or "1.5s" as a number
+	// of milliseconds.
+	Milliseconds Unit = iota + 1
+	// Seconds stores durations as a number of seconds.
+	Seconds
+	// Bytes stores sizes such as "2.5MB" (decimal, 1000-based) or "4KiB"
+	// (binary, 1024-based) as a number of bytes.
+	Bytes
+)
+
+// unitNames maps each Unit to its string form.
+var unitNames = [...]string{
+	Milliseconds: "milliseconds",
+	Seconds:      "seconds",
+	Bytes:        "bytes",
+}
+
+// String returns the lower-case name of the unit, e.g. "bytes".
+func (u Unit) String() string {
+	if u > 0 && int(u) < len(unitNames) {
+		return unitNames[u]
+	}
+	return "Unit(" + strconv.Itoa(int(u)) + ")"
+}
+
+// ParseUnit returns the Unit named s. Names are case-insensitive.
+func ParseUnit(s string) (Unit, error) {
+	for i, name := range unitNames {
+		if name != "" && strings.EqualFold(s, name) {
+			return Unit(i), nil
+		}
+	}
+	return 0, fmt.Errorf("squid: unknown unit %q", s)
+}
+
+// MarshalText encodes the unit as its name.
+func (u Unit) MarshalText() ([]byte, error) {
+	if u <= 0 || int(u) >= len(unitNames) {
+		return nil, fmt.Errorf("squid: unknown unit %d", int(u))
+	}
+	return []byte(u.String()), nil
+}
+
+// UnmarshalText decodes a unit name.
+func (u *Unit) UnmarshalText(text []byte) error {
+	parsed, err := ParseUnit(string(text))
+	if err != nil {
+		return err
+	}
+	*u = parsed
+	return nil
+}
+
+// byteSuffixes maps the suffixes of sizes, lower-cased, to their multiples.
+var byteSuffixes = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"pb":  1e15,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"pib": 1 << 50,
+}
+
+// Parse converts a value with a unit suffix to a number in the unit, e.g.
+// Milliseconds.Parse("1.5s") returns 1500. Numbers without a suffix are
+// already in the unit.
+func (u Unit) Parse(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseFloat(s, 64); err == nil {
+		return checkFinite(s, v)
+	}
+
+	switch u {
+	case Milliseconds, Seconds:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("squid: invalid duration %q", s)
+		}
+		if u == Seconds {
+			return d.Seconds(), nil
+		}
+		return float64(d) / float64(time.Millisecond), nil
+
+	case Bytes:
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+		})
+		if i <= 0 {
+			return 0, fmt.Errorf("squid: invalid size %q", s)
+		}
+		mult, ok := byteSuffixes[strings.ToLower(strings.TrimSpace(s[i:]))]
+		v, err := strconv.ParseFloat(s[:i], 64)
+		if !ok || err != nil {
+			return 0, fmt.Errorf("squid: invalid size %q", s)
+		}
+		return checkFinite(s, v*mult)
+	}
+	return 0, fmt.Errorf("squid: unknown unit %d", int(u))
+}
+
+// checkFinite returns v, or an error if it is NaN or infinite.
+func checkFinite(s string, v float64) (float64, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("squid: invalid number %q", s)
+	}
+	return v, nil
+}
+
+// Format returns a number in the unit with the largest suffix that keeps it
+// at least 1, e.g. Bytes.Format(2500000) returns "2.5MB" and
+// Milliseconds.Format(1500) returns "1.5s". Parse reads it back.
+func (u Unit) Format(v float64) string {
+	switch u {
+	case Milliseconds:
+		return time.Duration(v * float64(time.Millisecond)).String()
+	case Seconds:
+		return time.Duration(v * float64(time.Second)).String()
+	case Bytes:
+		suffix := "B"
+		for _, s := range []string{"kB", "MB", "GB", "TB", "PB"} {
+			if math.Abs(v) < byteSuffixes[strings.ToLower(s)] {
+				break
+			}
+			suffix = s
+		}
+		return strconv.FormatFloat(v/byteSuffixes[strings.ToLower(suffix)], 'f', -1, 64) + suffix
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// applyUnits replaces string values of the data fields in Options.Units by
+// numbers in their unit. A value that cannot be parsed is an error if
+// strict is set, and is stored as given otherwise. raw is decoded into the
+// event first, as in checkIngest.
+func (db *DB) applyUnits(event *Event, raw *rawParts, strict bool) error {
+	if len(db.opts.Units) == 0 {
+		return nil
+	}
+	if err := db.decodeRawData(event, raw); err != nil {
+		return err
+	}
+
+	var data map[string]any
+	for _, field := range sortedKeys(db.opts.Units) {
+		s, ok := event.Data[field].(string)
+		if !ok {
+			continue
+		}
+		v, err := db.opts.Units[field].Parse(s)
+		if err != nil {
+			if strict {
+				return &ValidationError{Field: "data", Value: field, Err: ErrInvalidData}
+			}
+			continue
+		}
+		if data == nil {
+			data = maps.Clone(event.Data)
+		}
+		data[field] = v
+	}
+	if data != nil {
+		event.Data = data
+	}
+	return nil
+}
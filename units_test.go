@@ -0,0 +1,133 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestUnitParse(t *testing.T) {
+	tests := []struct {
+		unit  Unit
+		input string
+		want  float64
+	}{
+		{Milliseconds, "150ms", 150},
+		{Milliseconds, "1.5s", 1500},
+		{Milliseconds, "250us", 0.25},
+		{Milliseconds, "42", 42},
+		{Seconds, "2m", 120},
+		{Bytes, "2.5MB", 2.5e6},
+		{Bytes, "4 KiB", 4096},
+		{Bytes, "1gb", 1e9},
+		{Bytes, "512B", 512},
+		{Bytes, "100", 100},
+	}
+	for _, tt := range tests {
+		got, err := tt.unit.Parse(tt.input)
+		if err != nil {
+			t.Errorf("%v.Parse(%q) failed: %v", tt.unit, tt.input, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v.Parse(%q) = %v, want %v", tt.unit, tt.input, got, tt.want)
+		}
+	}
+
+	for _, input := range []string{"", "fast", "2.5XB", "MB", "NaN"} {
+		if _, err := Bytes.Parse(input); err == nil {
+			t.Errorf("expected error for size %q", input)
+		}
+	}
+	if _, err := Milliseconds.Parse("5 minutes"); err == nil {
+		t.Error("expected error for invalid duration")
+	}
+
+	for _, tt := range []struct {
+		unit Unit
+		v    float64
+		want string
+	}{
+		{Bytes, 2.5e6, "2.5MB"},
+		{Bytes, 999, "999B"},
+		{Milliseconds, 1500, "1.5s"},
+		{Seconds, 90, "1m30s"},
+	} {
+		s := tt.unit.Format(tt.v)
+		if s != tt.want {
+			t.Errorf("%v.Format(%v) = %q, want %q", tt.unit, tt.v, s, tt.want)
+		}
+		if v, err := tt.unit.Parse(s); err != nil || v != tt.v {
+			t.Errorf("%v.Parse(%q) = %v, %v, want %v", tt.unit, s, v, err, tt.v)
+		}
+	}
+
+	if u, err := ParseUnit("Bytes"); err != nil || u != Bytes {
+		t.Errorf("expected case-insensitive parse, got %v, %v", u, err)
+	}
+}
+
+func TestUnitsIngest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Strings stored before the unit is set are parsed by aggregations
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := db.Append(Event{Type: "request", Data: map[string]any{"latency": "1.5s"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	db.Close()
+
+	db, err = OpenWithOptions(dir, Options{
+		Units:       map[string]Unit{"latency": Milliseconds, "size": Bytes},
+		IngestModes: map[string]IngestMode{"legacy": Lenient},
+	})
+	if err != nil {
+		t.Fatalf("OpenWithOptions failed: %v", err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if _, err := db.Append(Event{Type: "request", Data: map[string]any{"latency": "150ms", "size": "2KB"}}); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if _, err := db.AppendJSON(ctx, []byte(`{"type":"request","data":{"latency":"350ms"}}`)); err != nil {
+		t.Fatalf("AppendJSON failed: %v", err)
+	}
+
+	events, err := db.Query(ctx, Query{Types: []string{"request"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 3 || events[1].Data["latency"] != 150.0 || events[1].Data["size"] != 2000.0 ||
+		events[2].Data["latency"] != 350.0 {
+		t.Errorf("expected values stored as numbers, got %+v", events)
+	}
+
+	result, err := db.Aggregate(ctx, Query{Types: []string{"request"}}, "latency", []AggregationType{Sum, Max})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Count != 3 || result.Sum != 2000 || result.Max != 1500 {
+		t.Errorf("expected count 3, sum 2000 and max 1500, got %+v", result)
+	}
+
+	// Strict appends reject values that cannot be parsed
+	_, err = db.Append(Event{Type: "request", Data: map[string]any{"latency": "slow"}})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrInvalidData) || verr.Value != "latency" {
+		t.Errorf("expected a ValidationError for latency, got %v", err)
+	}
+
+	// Lenient appends store them as given
+	if _, err := db.Append(Event{Type: "legacy", Data: map[string]any{"latency": "slow"}}); err != nil {
+		t.Errorf("expected the lenient append to succeed, got %v", err)
+	}
+}
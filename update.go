@@ -0,0 +1,121 @@
+package squid
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// Update replaces the type, tags and data of a stored event.
+//
+// Updates use optimistic concurrency: event.Version must equal the stored
+// version, which Append sets to 1 and every successful Update increments.
+// If another writer updated the event first, Update returns an error
+// wrapping ErrVersionConflict and the caller should re-read the event and
+// retry. The event's ID, Timestamp and Supersedes cannot change. Events
+// covered by a legal hold cannot be updated; Update returns an error
+// wrapping ErrLegalHold.
+func (db *DB) Update(event Event) (*Event, error) {
+	return db.update(event, false)
+}
+
+// UpdateMetadata replaces the type and tags of a stored event, keeping its
+// data, which is neither read nor rewritten. Versions are checked as by
+// Update. The returned event has no data.
+func (db *DB) UpdateMetadata(event Event) (*Event, error) {
+	event.Data = nil
+	return db.update(event, true)
+}
+
+// update implements Update and, with keepData set, UpdateMetadata.
+func (db *DB) update(event Event, keepData bool) (*Event, error) {
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return nil, ErrClosed
+	}
+	db.mu.RUnlock()
+
+	if db.watchdog != nil && db.watchdog.rejectWrites() {
+		return nil, ErrDiskFull
+	}
+	if db.files != nil && db.files.rejectWrites() {
+		return nil, ErrTooManyFiles
+	}
+
+	if err := event.validate(); err != nil {
+		return nil, err
+	}
+
+	var admitted admission
+	if db.cardinality != nil {
+		var err error
+		if admitted, err = db.cardinality.admit(&event); err != nil {
+			return nil, err
+		}
+	}
+
+	err := db.badger.Update(func(txn *badger.Txn) error {
+		key := encodeEventKey(event.ID)
+		item, err := txn.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return &QueryError{Stage: StageFetch, Key: key, Err: err}
+		}
+
+		var stored Event
+		if err := db.decodeItem(item, &stored); err != nil {
+			return err
+		}
+		if name := db.legalHolds.holding(event.ID, &stored); name != "" {
+			return fmt.Errorf("%w: event %s is held by %q", ErrLegalHold, event.ID, name)
+		}
+
+		// Events written before versioning was introduced are at version 1
+		version := max(stored.Version, 1)
+		if event.Version != version {
+			return fmt.Errorf("%w: event %s is at version %d, not %d", ErrVersionConflict, event.ID, version, event.Version)
+		}
+
+		event.Timestamp = stored.Timestamp
+		event.IngestedAt = stored.IngestedAt
+		event.Supersedes = stored.Supersedes
+		event.Version = version + 1
+
+		meta, data, err := db.encodeEvent(&event)
+		if err != nil {
+			return err
+		}
+
+		entry := deleteEntry{id: event.ID, event: stored}
+		if keepData {
+			deleteIndices(txn, entry)
+		} else if err := db.deleteEventAndIndices(txn, entry); err != nil {
+			return err
+		}
+		if _, _, err := db.writeEvent(txn, &event, meta, data); err != nil {
+			return err
+		}
+
+		deltas := make(countDeltas)
+		deltas.add(stored.Type, event.ID, -1)
+		deltas.add(event.Type, event.ID, 1)
+		return db.counts.write(txn, deltas)
+	})
+	db.cardinality.settle(admitted, err == nil)
+
+	// A concurrent Update of the same event committed first
+	if errors.Is(err, badger.ErrConflict) {
+		return nil, fmt.Errorf("%w: event %s was modified concurrently", ErrVersionConflict, event.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	db.invalidateEvents([]Event{event})
+
+	return &event, nil
+}
@@ -0,0 +1,212 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	result, err := db.Append(Event{
+		Timestamp: ts,
+		Type:      "request",
+		Tags:      map[string]string{"service": "api"},
+	})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	if result.Version != 1 {
+		t.Fatalf("expected version 1, got %d", result.Version)
+	}
+
+	updated, err := db.Update(Event{
+		ID:      result.ID,
+		Version: 1,
+		Type:    "request",
+		Tags:    map[string]string{"service": "web"},
+		Data:    map[string]any{"enriched": true},
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.Version != 2 || !updated.Timestamp.Equal(ts) {
+		t.Errorf("unexpected updated event: %+v", updated)
+	}
+
+	got, err := db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Version != 2 || got.Data["enriched"] != true {
+		t.Errorf("unexpected stored event: %+v", got)
+	}
+
+	// Indices follow the new tags
+	ctx := context.Background()
+	for service, want := range map[string]int{"api": 0, "web": 1} {
+		events, err := db.Query(ctx, Query{Tags: map[string]string{"service": service}})
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != want {
+			t.Errorf("expected %d events for service=%s, got %d", want, service, len(events))
+		}
+	}
+
+	// Stale versions are rejected
+	_, err = db.Update(Event{ID: result.ID, Version: 1, Type: "request"})
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("expected ErrVersionConflict, got %v", err)
+	}
+
+	_, err = db.Update(Event{ID: db.ulids.Now(), Version: 1, Type: "request"})
+	if err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	_, err = db.Update(Event{ID: result.ID, Version: 2})
+	if err != ErrEmptyType {
+		t.Errorf("expected ErrEmptyType, got %v", err)
+	}
+}
+
+func TestUpdateConcurrent(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	result, err := db.Append(Event{Type: "counter", Data: map[string]any{"n": 0}})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	// Workers increment a counter with read-modify-write retries
+	const workers, increments = 4, 10
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				event, err := db.Get(result.ID)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				event.Data["n"] = event.Data["n"].(float64) + 1
+
+				_, err = db.Update(*event)
+				if errors.Is(err, ErrVersionConflict) {
+					continue
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				i++
+			}
+		}()
+	}
+	wg.Wait()
+
+	event, err := db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if n := event.Data["n"].(float64); n != workers*increments {
+		t.Errorf("expected n=%d, got %v", workers*increments, n)
+	}
+	if event.Version != workers*increments+1 {
+		t.Errorf("expected version %d, got %d", workers*increments+1, event.Version)
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	result, err := db.Append(Event{
+		Type: "request",
+		Tags: map[string]string{"service": "api"},
+		Data: map[string]any{"body": "large payload"},
+	})
+	if err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+
+	updated, err := db.UpdateMetadata(Event{
+		ID:      result.ID,
+		Version: 1,
+		Type:    "request",
+		Tags:    map[string]string{"service": "web"},
+		Data:    map[string]any{"ignored": true},
+	})
+	if err != nil {
+		t.Fatalf("UpdateMetadata failed: %v", err)
+	}
+	if updated.Version != 2 || updated.Data != nil {
+		t.Errorf("unexpected updated event: %+v", updated)
+	}
+
+	// The data is kept
+	got, err := db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Tags["service"] != "web" || got.Data["body"] != "large payload" || got.Data["ignored"] != nil {
+		t.Errorf("unexpected stored event: %+v", got)
+	}
+
+	events, err := db.Query(context.Background(), Query{Tags: map[string]string{"service": "web"}})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("expected 1 event for service=web, got %d", len(events))
+	}
+
+	// Update replaces the data, removing it if the event has none
+	if _, err := db.Update(Event{ID: result.ID, Version: 2, Type: "request"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	got, err = db.Get(result.ID)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got.Data != nil {
+		t.Errorf("expected no data, got %v", got.Data)
+	}
+}
@@ -0,0 +1,144 @@
+package squid
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for DiskWatchdog.
+const (
+	defaultWatchdogInterval = 10 * time.Second
+	defaultWatchdogBatch    = 10_000
+)
+
+// DiskWatchdog configures monitoring of free space in the data directory.
+// When free space drops below MinFreeBytes the watchdog can delete the oldest
+// events and/or reject writes with ErrDiskFull until space is recovered.
+type DiskWatchdog struct {
+	// MinFreeBytes is the free space threshold that triggers the watchdog.
+	MinFreeBytes uint64
+
+	// DeleteOldest enables emergency retention: while below the threshold,
+	// the oldest events are deleted in batches regardless of the retention
+	// policy. Events under a LegalHold are kept.
+	DeleteOldest bool
+
+	// DeleteBatch is the number of events deleted per emergency step.
+	// Defaults to 10,000.
+	DeleteBatch int
+
+	// RejectWrites makes Append and AppendBatch return ErrDiskFull while
+	// below the threshold.
+	RejectWrites bool
+
+	// CheckInterval is how often free space is checked. Defaults to 10 seconds.
+	CheckInterval time.Duration
+}
+
+// watchdogState holds the state of the disk watchdog goroutine.
+type watchdogState struct {
+	config DiskWatchdog
+	free   func() (uint64, error)
+
+	lowSpace  atomic.Bool
+	freeBytes atomic.Uint64
+	deleted   atomic.Int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newWatchdog returns a watchdog for the data directory at path,
+// or nil if none is configured.
+func newWatchdog(opts Options, path string) *watchdogState {
+	if opts.DiskWatchdog == nil || opts.DiskWatchdog.MinFreeBytes == 0 {
+		return nil
+	}
+
+	config := *opts.DiskWatchdog
+	if config.DeleteBatch <= 0 {
+		config.DeleteBatch = defaultWatchdogBatch
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = defaultWatchdogInterval
+	}
+
+	return &watchdogState{
+		config: config,
+		free:   func() (uint64, error) { return diskFree(path) },
+	}
+}
+
+// start runs an initial check and then checks periodically in the background.
+func (w *watchdogState) start(db *DB) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.done = make(chan struct{})
+
+	w.check(db)
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.config.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.check(db)
+			}
+		}
+	}()
+}
+
+// stop ends checking and waits for the goroutine to exit.
+func (w *watchdogState) stop() {
+	if w.cancel != nil {
+		w.cancel()
+		<-w.done
+	}
+}
+
+// check samples free space and applies the configured emergency actions.
+func (w *watchdogState) check(db *DB) {
+	free, err := w.free()
+	if err != nil {
+		if db.opts.Logger != nil {
+			db.opts.Logger.Warningf("squid: disk watchdog: %v", err)
+		}
+		return
+	}
+	w.freeBytes.Store(free)
+
+	if free >= w.config.MinFreeBytes {
+		if w.lowSpace.Swap(false) && db.opts.Logger != nil {
+			db.opts.Logger.Infof("squid: disk space recovered, %d bytes free", free)
+		}
+		return
+	}
+
+	if !w.lowSpace.Swap(true) && db.opts.Logger != nil {
+		db.opts.Logger.Warningf("squid: low disk space, %d bytes free (minimum %d)", free, w.config.MinFreeBytes)
+	}
+
+	if w.config.DeleteOldest {
+		deleted, err := db.deleteOldest(w.config.DeleteBatch)
+		w.deleted.Add(deleted)
+		if err != nil && db.opts.Logger != nil {
+			db.opts.Logger.Errorf("squid: emergency retention failed: %v", err)
+		}
+		if deleted > 0 {
+			// Reclaim value log space held by the deleted events
+			_ = db.badger.RunValueLogGC(0.5)
+		}
+	}
+}
+
+// rejectWrites reports whether writes should fail with ErrDiskFull.
+func (w *watchdogState) rejectWrites() bool {
+	return w.config.RejectWrites && w.lowSpace.Load()
+}
@@ -0,0 +1,100 @@
+package squid
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatchdogRejectWrites(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// No disk has this much free space
+	db, err := OpenWithOptions(dir, Options{DiskWatchdog: &DiskWatchdog{
+		MinFreeBytes:  math.MaxUint64,
+		RejectWrites:  true,
+		CheckInterval: time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Append(Event{Type: "request"}); err != ErrDiskFull {
+		t.Errorf("expected ErrDiskFull, got %v", err)
+	}
+	if _, err := db.AppendBatch([]Event{{Type: "request"}}); err != ErrDiskFull {
+		t.Errorf("expected ErrDiskFull, got %v", err)
+	}
+
+	stats := db.Stats()
+	if !stats.DiskLow || stats.DiskFreeBytes == 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// Writes resume once space is recovered
+	db.watchdog.free = func() (uint64, error) { return math.MaxUint64, nil }
+	db.watchdog.check(db)
+	if _, err := db.Append(Event{Type: "request"}); err != nil {
+		t.Errorf("Append after recovery failed: %v", err)
+	}
+}
+
+func TestWatchdogDeleteOldest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	base := time.Now().Add(-time.Hour)
+	var newest *AppendResult
+	for i := 0; i < 5; i++ {
+		newest, err = db.Append(Event{
+			Type:      "request",
+			Tags:      map[string]string{"service": "api"},
+			Timestamp: base.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+	db.Close()
+
+	// Reopening triggers an immediate check that deletes one batch
+	db, err = OpenWithOptions(dir, Options{DiskWatchdog: &DiskWatchdog{
+		MinFreeBytes:  math.MaxUint64,
+		DeleteOldest:  true,
+		DeleteBatch:   2,
+		CheckInterval: time.Hour,
+	}})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	count, _ := db.Count()
+	if count != 3 {
+		t.Errorf("expected 3 events after emergency retention, got %d", count)
+	}
+	if _, err := db.Get(newest.ID); err != nil {
+		t.Errorf("expected newest event to survive: %v", err)
+	}
+	if got := db.Stats().EmergencyDeletes; got != 2 {
+		t.Errorf("expected 2 emergency deletes, got %d", got)
+	}
+
+	// Writes are still accepted without RejectWrites
+	if _, err := db.Append(Event{Type: "request"}); err != nil {
+		t.Errorf("Append failed: %v", err)
+	}
+}
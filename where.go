@@ -0,0 +1,140 @@
+package squid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is the comparison operator of a Predicate.
+type Op string
+
+const (
+	EQ  Op = "eq"  // equal
+	NE  Op = "ne"  // not equal
+	GT  Op = "gt"  // greater than
+	GTE Op = "gte" // greater than or equal
+	LT  Op = "lt"  // less than
+	LTE Op = "lte" // less than or equal
+)
+
+// Predicate compares a field of an event's data with a value, as in
+// Predicate{Field: "status", Op: GTE, Value: 500}.
+//
+// Value must be a number, a string or a bool. Numbers compare numerically
+// whatever their Go type, strings compare byte-wise, and bools only with
+// EQ and NE. A data value of another kind than Value is never equal to it,
+// so only NE matches it; an event without the field matches no predicate.
+type Predicate struct {
+	Field string `json:"field"`
+	Op    Op     `json:"op"`
+	Value any    `json:"value"`
+}
+
+// checkWhere validates the predicates of a query.
+func checkWhere(q Query) error {
+	for _, p := range q.Where {
+		if p.Field == "" {
+			return fmt.Errorf("%w: predicate without a field", ErrInvalidQuery)
+		}
+		switch p.Op {
+		case EQ, NE, GT, GTE, LT, LTE:
+		default:
+			return fmt.Errorf("%w: unknown operator %q in predicate on %q", ErrInvalidQuery, p.Op, p.Field)
+		}
+
+		switch p.Value.(type) {
+		case string:
+		case bool:
+			if p.Op != EQ && p.Op != NE {
+				return fmt.Errorf("%w: operator %q does not apply to bool value of %q", ErrInvalidQuery, p.Op, p.Field)
+			}
+		default:
+			if _, ok := numericValue(p.Value); !ok {
+				return fmt.Errorf("%w: value of %q must be a number, string or bool, not %T", ErrInvalidQuery, p.Field, p.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// matchesData reports whether an event's data satisfies the data filters
+// of the query.
+func matchesData(event *Event, q Query) bool {
+	for _, f := range q.HasFields {
+		if _, ok := event.Data[f]; !ok {
+			return false
+		}
+	}
+	for _, f := range q.MissingFields {
+		if _, ok := event.Data[f]; ok {
+			return false
+		}
+	}
+	for _, p := range q.Where {
+		if !p.matches(event.Data) {
+			return false
+		}
+	}
+	return matchesSearch(event, q)
+}
+
+// matches reports whether data satisfies the predicate.
+func (p Predicate) matches(data map[string]any) bool {
+	val, ok := data[p.Field]
+	if !ok {
+		return false
+	}
+
+	cmp, ok := compareValues(val, p.Value)
+	if !ok {
+		return p.Op == NE
+	}
+	switch p.Op {
+	case EQ:
+		return cmp == 0
+	case NE:
+		return cmp != 0
+	case GT:
+		return cmp > 0
+	case GTE:
+		return cmp >= 0
+	case LT:
+		return cmp < 0
+	case LTE:
+		return cmp <= 0
+	}
+	return false
+}
+
+// compareValues returns -1, 0 or 1 as a is less than, equal to or greater
+// than b, and false if they are of different kinds. Bools are equal or
+// not, returning 1 for unequal ones.
+func compareValues(a, b any) (int, bool) {
+	switch b := b.(type) {
+	case string:
+		a, ok := a.(string)
+		return strings.Compare(a, b), ok
+	case bool:
+		a, ok := a.(bool)
+		if a == b {
+			return 0, ok
+		}
+		return 1, ok
+	}
+
+	x, ok := numericValue(a)
+	if !ok {
+		return 0, false
+	}
+	y, ok := numericValue(b)
+	if !ok {
+		return 0, false
+	}
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	}
+	return 0, true
+}
@@ -0,0 +1,180 @@
+package squid
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+func TestPredicateMatches(t *testing.T) {
+	data := map[string]any{"status": float64(503), "method": "GET", "cached": false}
+	tests := []struct {
+		p    Predicate
+		want bool
+	}{
+		{Predicate{"status", GTE, 500}, true},
+		{Predicate{"status", GTE, 503.5}, false},
+		{Predicate{"status", LT, int64(600)}, true},
+		{Predicate{"status", EQ, uint8(200)}, false},
+		{Predicate{"status", NE, 200}, true},
+		{Predicate{"method", EQ, "GET"}, true},
+		{Predicate{"method", GT, "DELETE"}, true},
+		{Predicate{"method", LTE, "DELETE"}, false},
+		{Predicate{"cached", EQ, false}, true},
+		{Predicate{"cached", NE, false}, false},
+		{Predicate{"status", EQ, "503"}, false}, // Different kinds
+		{Predicate{"status", NE, "503"}, true},
+		{Predicate{"missing", NE, 1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.p.matches(data); got != tt.want {
+			t.Errorf("%+v matches = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestQueryWhere(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	statuses := []float64{200, 500, 404, 503, 200, 502}
+	for i, status := range statuses {
+		_, err := db.Append(Event{
+			Timestamp: base.Add(time.Duration(i) * time.Second),
+			Type:      "request",
+			Tags:      map[string]string{"host": "web-1"},
+			Data:      map[string]any{"status": status, "path": "/"},
+		})
+		if err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	errorsOnly := []Predicate{{Field: "status", Op: GTE, Value: 500}}
+	for _, q := range []Query{
+		{Where: errorsOnly},
+		{Types: []string{"request"}, Where: errorsOnly},
+		{Tags: map[string]string{"host": "web-1"}, Where: errorsOnly},
+	} {
+		events, err := db.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != 3 {
+			t.Fatalf("expected 3 server errors, got %d", len(events))
+		}
+		for _, e := range events {
+			if e.Data["status"].(float64) < 500 {
+				t.Errorf("unexpected status %v", e.Data["status"])
+			}
+		}
+	}
+
+	// Limits count matching events only
+	events, err := db.Query(ctx, Query{Types: []string{"request"}, Where: errorsOnly, Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Data["status"] != float64(503) {
+		t.Errorf("expected the second and third server errors, got %d events", len(events))
+	}
+
+	events, err = db.Query(ctx, Query{Where: append(errorsOnly, Predicate{Field: "status", Op: NE, Value: 503}), OmitData: true})
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(events) != 2 || events[0].Data != nil {
+		t.Errorf("expected 2 events without data, got %d", len(events))
+	}
+
+	var raw int
+	err = db.QueryRaw(ctx, Query{Where: []Predicate{{Field: "status", Op: EQ, Value: 200}}}, func(_ ulid.ULID, _ []byte) error {
+		raw++
+		return nil
+	})
+	if err != nil || raw != 2 {
+		t.Errorf("expected 2 raw events, got %d (%v)", raw, err)
+	}
+
+	result, err := db.Aggregate(ctx, Query{Where: errorsOnly}, "status", []AggregationType{Count, Max})
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	if result.Count != 3 || result.Max != 503 {
+		t.Errorf("expected 3 errors up to 503, got %d up to %v", result.Count, result.Max)
+	}
+
+	for _, p := range []Predicate{
+		{Field: "", Op: EQ, Value: 1},
+		{Field: "status", Op: "between", Value: 1},
+		{Field: "status", Op: GT, Value: true},
+		{Field: "status", Op: EQ, Value: []int{1}},
+	} {
+		_, err := db.Query(ctx, Query{Where: []Predicate{p}})
+		if !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%+v: expected ErrInvalidQuery, got %v", p, err)
+		}
+	}
+}
+
+func TestQueryFieldExistence(t *testing.T) {
+	dir, err := os.MkdirTemp("", "squid-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+
+	for _, data := range []map[string]any{
+		{"cpu": 0.5, "mem": 100.0},
+		{"cpu": 0.7},
+		{"mem": 200.0},
+		{"cpu": nil},
+		nil,
+	} {
+		if _, err := db.Append(Event{Type: "metrics", Data: data}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		q    Query
+		want int
+	}{
+		{Query{HasFields: []string{"cpu"}}, 3},
+		{Query{HasFields: []string{"cpu", "mem"}}, 1},
+		{Query{MissingFields: []string{"cpu"}}, 2},
+		{Query{Types: []string{"metrics"}, HasFields: []string{"mem"}, MissingFields: []string{"cpu"}, OmitData: true}, 1},
+		{Query{HasFields: []string{"cpu"}, Where: []Predicate{{Field: "cpu", Op: GT, Value: 0.6}}}, 1},
+	}
+	for _, tt := range tests {
+		events, err := db.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if len(events) != tt.want {
+			t.Errorf("%+v: expected %d events, got %d", tt.q, tt.want, len(events))
+		}
+	}
+}